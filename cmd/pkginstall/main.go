@@ -1,26 +1,79 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/go-i2p/go-pkginstall/pkg/audit"
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
 	"github.com/go-i2p/go-pkginstall/pkg/compat"
 	"github.com/go-i2p/go-pkginstall/pkg/debian"
+	"github.com/go-i2p/go-pkginstall/pkg/doctor"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/install"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/provenance"
+	"github.com/go-i2p/go-pkginstall/pkg/publish"
+	"github.com/go-i2p/go-pkginstall/pkg/repo"
+	"github.com/go-i2p/go-pkginstall/pkg/scaffold"
+	"github.com/go-i2p/go-pkginstall/pkg/server"
 	"github.com/go-i2p/go-pkginstall/pkg/symlink"
 	"github.com/spf13/cobra"
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version string
+	commit  string
+	date    string
+)
+
 func main() {
+	buildInfo := doctor.NewBuildInfo(version, commit, date)
+	provenance.SetBuilderInfo(buildInfo)
+
 	// Initialize the root command
 	var rootCmd = &cobra.Command{
-		Use:   "pkginstall",
-		Short: "A secure replacement for Checkinstall",
-		Long:  `pkginstall is a command-line utility for creating Debian packages with enhanced security features.`,
+		Use:     "pkginstall",
+		Short:   "A secure replacement for Checkinstall",
+		Long:    `pkginstall is a command-line utility for creating Debian packages with enhanced security features.`,
+		Version: buildInfo.String(),
 		Run: func(cmd *cobra.Command, args []string) {
 			// Placeholder for command execution logic
 			log.Println("Executing pkginstall...")
 		},
 	}
+	logOptions := logging.AddFlags(rootCmd.PersistentFlags())
+	auditLog := rootCmd.PersistentFlags().String("audit-log", "",
+		"Audit log of changes made to the system (default: /var/log/pkginstall/audit.jsonl for root, $XDG_STATE_HOME/pkginstall/audit.jsonl otherwise)")
+	rootCmd.PersistentFlags().Bool("ci", false,
+		"Never prompt, write one JSON result to stdout and exit with the code of the failure class")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if ci.Enabled() {
+			if err := setCIDefaults(cmd); err != nil {
+				return err
+			}
+		}
+		if err := logOptions.Setup(cmd.ErrOrStderr()); err != nil {
+			return err
+		}
+		auditLogFile, err := auditlog.New(*auditLog)
+		if err != nil {
+			// Commands still run, but without recording their changes
+			slog.Warn(fmt.Sprintf("audit log disabled: %v; give one with --audit-log", err))
+			return nil
+		}
+		auditlog.SetDefault(auditLogFile)
+		return nil
+	}
 
 	/*	// Load configuration
 		if cfg, err := config.LoadConfig(""); err != nil {
@@ -34,12 +87,97 @@ func main() {
 
 	// Register subcommands
 	rootCmd.AddCommand(debian.NewBuildCommand())
+	rootCmd.AddCommand(debian.NewVerifyCommand())
+	rootCmd.AddCommand(debian.NewConvertCommand())
+	rootCmd.AddCommand(debian.NewDeltaCommand())
+	rootCmd.AddCommand(provenance.NewProvenanceCommand())
 	rootCmd.AddCommand(symlink.NewSymlinkCommand())
 	rootCmd.AddCommand(compat.NewCheckinstallCommand())
+	rootCmd.AddCommand(repo.NewRepoCommand())
+	rootCmd.AddCommand(history.NewHistoryCommand())
+	rootCmd.AddCommand(publish.NewPublishCommand())
+	rootCmd.AddCommand(publish.NewFetchCommand())
+	rootCmd.AddCommand(install.NewInstallCommand())
+	rootCmd.AddCommand(install.NewRemoveCommand())
+	rootCmd.AddCommand(install.NewRollbackCommand())
+	rootCmd.AddCommand(audit.NewAuditCommand())
+	rootCmd.AddCommand(scaffold.NewTemplateCommand())
+	rootCmd.AddCommand(server.NewServeCommand())
+	rootCmd.AddCommand(doctor.NewDoctorCommand())
+	rootCmd.AddCommand(doctor.NewVersionCommand(buildInfo))
+
+	// Interrupting the process cancels the running command
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ci.ClassifyUsage(rootCmd)
+	if !ciRequested(os.Args[1:]) {
+		// Execute the root command
+		if err := rootCmd.ExecuteContext(ctx); err != nil {
+			log.Printf("Error executing command: %v", err)
+			stop()
+			os.Exit(ci.ExitCode(err))
+		}
+		return
+	}
+
+	// CI mode: nothing reads the terminal and stdout carries only the result
+	ci.SetEnabled(true)
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	rootCmd.SetIn(strings.NewReader(""))
+	if devNull, err := os.Open(os.DevNull); err == nil {
+		os.Stdin = devNull
+	}
+	os.Setenv("DEBIAN_FRONTEND", "noninteractive")
 
-	// Execute the root command
-	if err := rootCmd.Execute(); err != nil {
+	stdout := os.Stdout
+	finish, err := ci.CaptureStdout()
+	if err != nil {
 		log.Fatalf("Error executing command: %v", err)
-		os.Exit(1)
 	}
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	output := finish()
+	if cmd == nil {
+		cmd = rootCmd
+	}
+	if werr := ci.NewResult(cmd.CommandPath(), output, err).Write(stdout); werr != nil {
+		log.Printf("Error writing result: %v", werr)
+	}
+	stop()
+	os.Exit(ci.ExitCode(err))
+}
+
+// ciRequested reports whether --ci is among the flags in args, which is
+// decided before the command runs so its output can be captured
+func ciRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--ci" {
+			return true
+		}
+		if value, ok := strings.CutPrefix(arg, "--ci="); ok {
+			on, err := strconv.ParseBool(value)
+			return err == nil && on
+		}
+	}
+	return false
+}
+
+// setCIDefaults switches cmd to JSON logs and output, and turns off
+// interactive mode, unless the flags were set explicitly
+func setCIDefaults(cmd *cobra.Command) error {
+	defaults := map[string]string{"log-format": "json", "format": "json", "interactive": "false"}
+	for name, value := range defaults {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return ci.Wrap(ci.ClassUsage, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,170 @@
+// Package appstream generates AppStream metainfo files, which software
+// centers such as GNOME Software and KDE Discover use to list applications.
+package appstream
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetainfoDir is where metainfo files are installed
+const MetainfoDir = "/usr/share/metainfo"
+
+// metadataLicense is the license of the generated metainfo file itself
+const metadataLicense = "CC0-1.0"
+
+// validID matches AppStream component IDs such as org.example.MyApp
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// Component describes a desktop application. Name, Summary and Description
+// default to the package metadata.
+//
+// Example configuration:
+//
+//	appstream:
+//	  id: org.example.MyApp
+//	  license: GPL-3.0-or-later
+//	  homepage: https://example.org/myapp
+//	  icon: myapp
+//	  launchable: org.example.MyApp.desktop
+//	  categories: [Network]
+type Component struct {
+	ID          string   `mapstructure:"id"`          // Reverse-DNS component ID; defaults to the package name
+	Name        string   `mapstructure:"name"`        // Display name
+	Summary     string   `mapstructure:"summary"`     // One-line summary
+	Description string   `mapstructure:"description"` // Paragraphs separated by blank lines
+	License     string   `mapstructure:"license"`     // SPDX expression of the project license
+	Homepage    string   `mapstructure:"homepage"`    // Project homepage URL
+	Icon        string   `mapstructure:"icon"`        // Stock icon name from an installed icon theme
+	Launchable  string   `mapstructure:"launchable"`  // Desktop file ID, e.g. org.example.MyApp.desktop
+	Categories  []string `mapstructure:"categories"`  // Freedesktop menu categories
+}
+
+// WithDefaults returns a copy of c with missing fields taken from the package
+// name and description
+func (c Component) WithDefaults(packageName, description string) Component {
+	if c.ID == "" {
+		c.ID = packageName
+	}
+	if c.Name == "" {
+		c.Name = packageName
+	}
+	if c.Description == "" {
+		c.Description = description
+	}
+	if c.Summary == "" {
+		summary := strings.TrimSpace(strings.SplitN(strings.TrimSpace(c.Description), "\n", 2)[0])
+		c.Summary = strings.TrimSuffix(summary, ".")
+	}
+	return c
+}
+
+// Validate reports missing or malformed fields
+func (c Component) Validate() error {
+	if !validID.MatchString(c.ID) {
+		return fmt.Errorf("invalid AppStream component ID %q: use reverse-DNS notation such as org.example.MyApp", c.ID)
+	}
+	if c.Name == "" {
+		return fmt.Errorf("AppStream component %s has no name", c.ID)
+	}
+	if c.Summary == "" {
+		return fmt.Errorf("AppStream component %s has no summary", c.ID)
+	}
+	return nil
+}
+
+// Path returns the installed path of the component's metainfo file
+func (c Component) Path() string {
+	return MetainfoDir + "/" + c.ID + ".metainfo.xml"
+}
+
+// metainfo is the XML document written by Component.Metainfo
+type metainfo struct {
+	XMLName         xml.Name     `xml:"component"`
+	Type            string       `xml:"type,attr"`
+	ID              string       `xml:"id"`
+	MetadataLicense string       `xml:"metadata_license"`
+	ProjectLicense  string       `xml:"project_license,omitempty"`
+	Name            string       `xml:"name"`
+	Summary         string       `xml:"summary"`
+	Description     *description `xml:"description,omitempty"`
+	Launchable      *typedValue  `xml:"launchable,omitempty"`
+	Icon            *typedValue  `xml:"icon,omitempty"`
+	URL             *typedValue  `xml:"url,omitempty"`
+	Categories      *categories  `xml:"categories,omitempty"`
+	Releases        *releases    `xml:"releases,omitempty"`
+}
+
+// description holds the paragraphs of a long description
+type description struct {
+	Paragraphs []string `xml:"p"`
+}
+
+// typedValue is an element with a type attribute, such as <icon type="stock">
+type typedValue struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// categories lists the menu categories of the application
+type categories struct {
+	Category []string `xml:"category"`
+}
+
+// releases lists the packaged version
+type releases struct {
+	Release []release `xml:"release"`
+}
+
+// release is a version listed in <releases>
+type release struct {
+	Version string `xml:"version,attr"`
+}
+
+// Metainfo returns the metainfo XML for c, listing version as its release
+func (c Component) Metainfo(version string) ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := metainfo{
+		Type:            "desktop-application",
+		ID:              c.ID,
+		MetadataLicense: metadataLicense,
+		ProjectLicense:  c.License,
+		Name:            c.Name,
+		Summary:         c.Summary,
+	}
+	var paragraphs []string
+	for _, paragraph := range strings.Split(strings.TrimSpace(c.Description), "\n\n") {
+		if paragraph = strings.Join(strings.Fields(paragraph), " "); paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	if len(paragraphs) > 0 {
+		m.Description = &description{Paragraphs: paragraphs}
+	}
+	if c.Launchable != "" {
+		m.Launchable = &typedValue{Type: "desktop-id", Value: c.Launchable}
+	}
+	if c.Icon != "" {
+		m.Icon = &typedValue{Type: "stock", Value: c.Icon}
+	}
+	if c.Homepage != "" {
+		m.URL = &typedValue{Type: "homepage", Value: c.Homepage}
+	}
+	if len(c.Categories) > 0 {
+		m.Categories = &categories{Category: c.Categories}
+	}
+	if version != "" {
+		m.Releases = &releases{Release: []release{{Version: version}}}
+	}
+
+	content, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate metainfo for %s: %w", c.ID, err)
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
@@ -0,0 +1,98 @@
+package appstream
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestWithDefaults(t *testing.T) {
+	c := Component{Icon: "myapp"}.WithDefaults("myapp", "A tiny app.\n\nIt does things.")
+	if c.ID != "myapp" || c.Name != "myapp" || c.Summary != "A tiny app" || c.Icon != "myapp" {
+		t.Errorf("Unexpected defaults %+v", c)
+	}
+
+	c = Component{ID: "org.example.App", Name: "App", Summary: "Custom"}.WithDefaults("app", "Package description")
+	if c.ID != "org.example.App" || c.Name != "App" || c.Summary != "Custom" || c.Description != "Package description" {
+		t.Errorf("Expected configured fields to be kept, got %+v", c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		component Component
+		wantErr   bool
+	}{
+		{"Valid", Component{ID: "org.example.App", Name: "App", Summary: "An app"}, false},
+		{"Package name ID", Component{ID: "my-app", Name: "App", Summary: "An app"}, false},
+		{"Empty ID", Component{Name: "App", Summary: "An app"}, true},
+		{"ID with slash", Component{ID: "org/example", Name: "App", Summary: "An app"}, true},
+		{"Empty component", Component{ID: "org.example..App", Name: "App", Summary: "An app"}, true},
+		{"Missing summary", Component{ID: "org.example.App", Name: "App"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.component.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetainfo(t *testing.T) {
+	c := Component{
+		ID:          "org.example.App",
+		Name:        "App & Co",
+		Summary:     "An app",
+		Description: "First paragraph\nwrapped.\n\nSecond paragraph.",
+		License:     "GPL-3.0-or-later",
+		Homepage:    "https://example.org",
+		Icon:        "app",
+		Launchable:  "org.example.App.desktop",
+		Categories:  []string{"Network", "Chat"},
+	}
+	content, err := c.Metainfo("1.2")
+	if err != nil {
+		t.Fatalf("Metainfo() error = %v", err)
+	}
+
+	for _, want := range []string{
+		`<component type="desktop-application">`,
+		`<metadata_license>CC0-1.0</metadata_license>`,
+		`<project_license>GPL-3.0-or-later</project_license>`,
+		`<name>App &amp; Co</name>`,
+		`<p>First paragraph wrapped.</p>`,
+		`<p>Second paragraph.</p>`,
+		`<launchable type="desktop-id">org.example.App.desktop</launchable>`,
+		`<icon type="stock">app</icon>`,
+		`<url type="homepage">https://example.org</url>`,
+		`<category>Chat</category>`,
+		`<release version="1.2"></release>`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected %s in metainfo:\n%s", want, content)
+		}
+	}
+
+	var parsed struct {
+		ID string `xml:"id"`
+	}
+	if err := xml.Unmarshal(content, &parsed); err != nil || parsed.ID != c.ID {
+		t.Errorf("Metainfo is not valid XML: %v", err)
+	}
+	if c.Path() != "/usr/share/metainfo/org.example.App.metainfo.xml" {
+		t.Errorf("Unexpected path %s", c.Path())
+	}
+
+	minimal, err := Component{ID: "app", Name: "app", Summary: "An app"}.Metainfo("")
+	if err != nil {
+		t.Fatalf("Metainfo() error = %v", err)
+	}
+	for _, unwanted := range []string{"project_license", "<icon", "<url", "<categories", "<releases", "<description"} {
+		if strings.Contains(string(minimal), unwanted) {
+			t.Errorf("Expected no %s in minimal metainfo:\n%s", unwanted, minimal)
+		}
+	}
+}
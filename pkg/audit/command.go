@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/spf13/cobra"
+)
+
+// CommandOptions contains options for the audit command
+type CommandOptions struct {
+	Verbose bool
+
+	// Script command options
+	Format   string
+	Output   string
+	Level    string
+	Policy   string
+	Profile  string
+	Lint     string
+	ExitZero bool
+
+	levelChanged bool // Whether --level was given explicitly
+}
+
+// NewAuditCommand creates a new command for auditing packaging inputs
+func NewAuditCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Audit packaging inputs and the changes made to the system",
+		Long: `Audit packaging inputs without building a package, and review the audit
+log of changes pkginstall made to the system.
+
+Findings are reported with stable rule IDs, line numbers and severities and
+can be written as JSON or SARIF for ingestion by code-scanning dashboards.
+
+Examples:
+  pkginstall audit script debian/postinst
+  pkginstall audit script --format sarif -o results.sarif debian/*inst debian/*rm
+  pkginstall audit script --lint auto debian/postinst
+  pkginstall audit run-script debian/postinst
+  pkginstall audit log --verify
+`,
+	}
+
+	cmd.PersistentFlags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
+
+	cmd.AddCommand(newScriptCommand(options))
+	cmd.AddCommand(newRunScriptCommand(options))
+	cmd.AddCommand(newLogCommand())
+
+	return cmd
+}
+
+// newScriptCommand creates a subcommand for auditing maintainer scripts
+func newScriptCommand(options *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "script <file> [file...]",
+		Short: "Validate maintainer scripts and report findings",
+		Long: `Run maintainer scripts through the script validator.
+
+The command exits with a non-zero status when any script fails validation at
+the selected security level, after the report has been written. Use
+--exit-zero to always succeed, e.g. when uploading SARIF results in CI.
+
+Output formats:
+  text   Human-readable findings (default)
+  json   Findings with rule IDs, lines and severities
+  sarif  SARIF 2.1.0 log
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options.levelChanged = cmd.Flags().Changed("level")
+			return runScriptCommand(args, options)
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.Format, "format", "f", "text", "Output format (text, json, sarif)")
+	cmd.Flags().StringVarP(&options.Output, "output", "o", "", "Write the report to a file instead of stdout")
+	cmd.Flags().StringVar(&options.Level, "level", "medium", "Security level used to decide validity (low, medium, high); overrides --profile and --policy")
+	cmd.Flags().StringVar(&options.Policy, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
+	cmd.Flags().StringVar(&options.Lint, "lint", "", "Lint scripts for shell quality issues (off, auto, shellcheck, builtin); overrides --policy")
+	cmd.Flags().BoolVar(&options.ExitZero, "exit-zero", false, "Exit successfully even when scripts fail validation")
+
+	return cmd
+}
+
+// runScriptCommand handles the script audit logic
+func runScriptCommand(paths []string, options *CommandOptions) error {
+	level, err := security.ParseScriptSecurityLevel(options.Level)
+	if err != nil {
+		return err
+	}
+
+	format := strings.ToLower(options.Format)
+	if format != "text" && format != "json" && format != "sarif" {
+		return fmt.Errorf("unknown output format: %s", options.Format)
+	}
+
+	validator, err := newScriptValidator(options, level)
+	if err != nil {
+		return err
+	}
+
+	var reports []security.ScriptReport
+	var invalid []string
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read script: %w", err)
+		}
+
+		result, err := validator.ValidateScript(path, string(content))
+		if err != nil {
+			return fmt.Errorf("failed to validate %s: %w", path, err)
+		}
+		reports = append(reports, security.NewScriptReport(path, result))
+		if !result.Valid {
+			invalid = append(invalid, path)
+		}
+	}
+
+	w, closeReport, err := reportWriter(options.Output)
+	if err != nil {
+		return err
+	}
+	defer closeReport()
+
+	switch format {
+	case "json":
+		err = security.WriteJSONReport(w, reports)
+	case "sarif":
+		err = security.WriteSARIFReport(w, reports)
+	default:
+		writeTextReport(w, reports)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(invalid) > 0 && !options.ExitZero {
+		return ci.Errorf(ci.ClassPolicy, "%d script(s) failed validation: %s", len(invalid), strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// newScriptValidator creates the validator for --profile and --policy. An
+// explicit --level overrides their security levels.
+func newScriptValidator(options *CommandOptions, level security.ScriptSecurityLevel) (*security.ScriptValidator, error) {
+	profile, err := security.LookupProfile(options.Profile)
+	if err != nil {
+		return nil, err
+	}
+	opts := append(profile.ScriptValidatorOptions(), security.WithScriptVerbose(options.Verbose))
+	if options.Policy != "" {
+		policy, err := security.LoadPolicyFile(options.Policy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, policy.ScriptValidatorOptions()...)
+	}
+	if options.levelChanged {
+		opts = append(opts, security.WithSecurityLevel(level))
+	}
+	if options.Lint != "" {
+		mode, err := security.ParseLintMode(options.Lint)
+		if err != nil {
+			return nil, ci.Wrap(ci.ClassUsage, err)
+		}
+		opts = append(opts, security.WithLint(mode))
+	}
+	return security.NewScriptValidator(opts...), nil
+}
+
+// reportWriter returns stdout, or the created report file if path is set
+func reportWriter(path string) (io.Writer, func() error, error) {
+	if path == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create report file: %w", err)
+	}
+	return f, f.Close, nil
+}
+
+// writeTextReport writes the findings in a human-readable format
+func writeTextReport(w io.Writer, reports []security.ScriptReport) {
+	for _, report := range reports {
+		status := "PASS"
+		if !report.Valid {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s: %s (risk %d/10, %d finding(s))\n", report.Path, status, report.RiskLevel, len(report.Findings))
+		for _, finding := range report.Findings {
+			location := report.Path
+			if finding.Line > 0 {
+				location = fmt.Sprintf("%s:%d", report.Path, finding.Line)
+			}
+			fmt.Fprintf(w, "  %s: %s [%s] %s\n", location, finding.Severity, finding.RuleID, finding.Message)
+		}
+	}
+}
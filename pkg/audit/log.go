@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/spf13/cobra"
+)
+
+// LogOptions contains the options of the log command
+type LogOptions struct {
+	Limit   int
+	Action  string
+	Package string
+	Format  string
+	Verify  bool
+}
+
+// newLogCommand creates a subcommand showing the audit log
+func newLogCommand() *cobra.Command {
+	logOptions := &LogOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: "Show the audit log of changes made to the system",
+		Long: `Show the append-only audit log of the changes pkginstall made to the
+system: symlinks created and removed, files displaced by --force or a
+replaced symlink target and later restored, and packages built, installed
+and removed.
+
+Runs as root write to /var/log/pkginstall/audit.jsonl, other users to
+$XDG_STATE_HOME/pkginstall/audit.jsonl; the global --audit-log flag selects
+another file. Each entry records the hash of the one before it, and the whole
+chain is checked every time the log is shown: an entry that was edited or
+removed makes the command fail. --verify only checks the chain.
+
+Examples:
+  pkginstall audit log
+  pkginstall audit log --package myapp --action symlink-created
+  pkginstall audit log --verify
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return runLogCommand(cmd.OutOrStdout(), logOptions)
+		},
+	}
+
+	cmd.Flags().IntVarP(&logOptions.Limit, "limit", "l", 50, "Maximum number of entries to show (0 for all)")
+	cmd.Flags().StringVar(&logOptions.Action, "action", "", "Only show entries of this action, e.g. symlink-created or package-installed")
+	cmd.Flags().StringVar(&logOptions.Package, "package", "", "Only show entries of this package")
+	cmd.Flags().StringVarP(&logOptions.Format, "format", "f", "text", "Output format (text, json)")
+	cmd.Flags().BoolVar(&logOptions.Verify, "verify", false, "Only check the hash chain")
+
+	return cmd
+}
+
+// runLogCommand verifies the audit log and prints the selected entries
+func runLogCommand(w io.Writer, options *LogOptions) error {
+	format := strings.ToLower(options.Format)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown output format: %s", options.Format)
+	}
+
+	log := auditlog.Default()
+	if log == nil {
+		var err error
+		if log, err = auditlog.New(""); err != nil {
+			return err
+		}
+	}
+	entries, err := log.List()
+	if err != nil {
+		return err
+	}
+	chainErr := auditlog.Verify(entries)
+
+	if options.Verify {
+		if chainErr == nil {
+			fmt.Fprintf(w, "%s: %d entries, hash chain intact\n", log.Path(), len(entries))
+		}
+		return wrapChainError(log, chainErr)
+	}
+
+	var selected []auditlog.Entry
+	for _, entry := range entries {
+		if options.Action != "" && string(entry.Action) != options.Action {
+			continue
+		}
+		if options.Package != "" && entry.Package != options.Package {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	if options.Limit > 0 && len(selected) > options.Limit {
+		selected = selected[len(selected)-options.Limit:]
+	}
+
+	if format == "json" {
+		if selected == nil {
+			selected = []auditlog.Entry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(selected); err != nil {
+			return err
+		}
+		return wrapChainError(log, chainErr)
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintf(w, "No audit log entries in %s\n", log.Path())
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "SEQ\tTIME\tUSER\tACTION\tPACKAGE\tPATH\tDETAIL")
+	for _, entry := range selected {
+		path := entry.Path
+		if entry.Target != "" {
+			path += " -> " + entry.Target
+		}
+		pkg := entry.Package
+		if entry.Version != "" {
+			pkg += " " + entry.Version
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Seq,
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.User,
+			entry.Action,
+			pkg,
+			path,
+			entry.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if chainErr == nil {
+		fmt.Fprintf(w, "\nHash chain intact (%d entries)\n", len(entries))
+	}
+	return wrapChainError(log, chainErr)
+}
+
+// wrapChainError reports a broken hash chain as a validation failure
+func wrapChainError(log *auditlog.Log, err error) error {
+	if err == nil {
+		return nil
+	}
+	return ci.Errorf(ci.ClassValidation, "%s may have been tampered with: %w", log.Path(), err)
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/sandbox"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/spf13/cobra"
+)
+
+// RunScriptOptions contains the options of the run-script command
+type RunScriptOptions struct {
+	Backend string
+	Root    string
+	Package string
+	Env     []string
+	Timeout time.Duration
+}
+
+// defaultScriptArgs are the arguments dpkg passes on a fresh install
+var defaultScriptArgs = map[string][]string{
+	"preinst":  {"install"},
+	"postinst": {"configure"},
+	"prerm":    {"remove"},
+	"postrm":   {"remove"},
+}
+
+// newRunScriptCommand creates a subcommand running a maintainer script in a sandbox
+func newRunScriptCommand(options *CommandOptions) *cobra.Command {
+	runOptions := &RunScriptOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "run-script <file> [-- arg...]",
+		Short: "Run a maintainer script in a sandbox and record what it does",
+		Long: `Run a maintainer script against a throwaway fake root filesystem and record
+every file write and program execution it attempts.
+
+The script runs in a bubblewrap sandbox, or in a chroot in new namespaces when
+running as root without bubblewrap. The host's /usr and library directories
+are mounted read-only and the script has no network access; everything else
+is an empty fake root, optionally seeded with --root. With strace installed
+every attempted write and exec is recorded, including failed ones; otherwise
+only the changes to the fake root are.
+
+The recorded actions are checked like the static script audit: a non-zero
+exit status, risky commands and changes to protected paths are reported as
+findings. Scripts named preinst, postinst, prerm or postrm get the arguments
+dpkg passes on a fresh install unless arguments follow --.
+
+Examples:
+  pkginstall audit run-script debian/postinst
+  pkginstall audit run-script --root build/payload --format json debian/postinst -- configure 1.0
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options.levelChanged = cmd.Flags().Changed("level")
+			script, scriptArgs := args[0], defaultScriptArgs[filepath.Base(args[0])]
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				if dash != 1 {
+					return fmt.Errorf("run-script takes one script; pass its arguments after --")
+				}
+				scriptArgs = args[1:]
+			} else if len(args) > 1 {
+				return fmt.Errorf("run-script takes one script; pass its arguments after --")
+			}
+			return runRunScriptCommand(cmd, script, scriptArgs, options, runOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&runOptions.Backend, "backend", "",
+		"Sandbox backend ("+strings.Join(sandbox.Backends, ", ")+"; default: the first available)")
+	cmd.Flags().StringVar(&runOptions.Root, "root", "", "Directory copied into the fake root before the script runs, e.g. the package payload")
+	cmd.Flags().StringVar(&runOptions.Package, "package", "", "Package name passed to the script as DPKG_MAINTSCRIPT_PACKAGE")
+	cmd.Flags().StringArrayVar(&runOptions.Env, "env", nil, "Set KEY=VALUE for the script (repeatable)")
+	cmd.Flags().DurationVar(&runOptions.Timeout, "timeout", sandbox.DefaultTimeout, "Maximum run time of the script")
+	cmd.Flags().StringVarP(&options.Format, "format", "f", "text", "Output format (text, json, sarif)")
+	cmd.Flags().StringVarP(&options.Output, "output", "o", "", "Write the report to a file instead of stdout")
+	cmd.Flags().StringVar(&options.Level, "level", "medium", "Security level used to decide validity (low, medium, high); overrides --profile and --policy")
+	cmd.Flags().StringVar(&options.Policy, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
+	cmd.Flags().BoolVar(&options.ExitZero, "exit-zero", false, "Exit successfully even when the script fails verification")
+
+	return cmd
+}
+
+// runReport is the JSON report of a sandboxed script run
+type runReport struct {
+	security.ScriptReport
+	Args []string        `json:"args"`
+	Run  *sandbox.Result `json:"run"`
+}
+
+// runRunScriptCommand runs the script and reports what it did
+func runRunScriptCommand(cmd *cobra.Command, path string, args []string, options *CommandOptions, runOptions *RunScriptOptions) error {
+	level, err := security.ParseScriptSecurityLevel(options.Level)
+	if err != nil {
+		return err
+	}
+	format := strings.ToLower(options.Format)
+	if format != "text" && format != "json" && format != "sarif" {
+		return fmt.Errorf("unknown output format: %s", options.Format)
+	}
+	validator, err := newScriptValidator(options, level)
+	if err != nil {
+		return err
+	}
+
+	result, err := sandbox.RunScript(cmd.Context(), path, sandbox.Options{
+		Backend: runOptions.Backend,
+		Args:    args,
+		Package: runOptions.Package,
+		Root:    runOptions.Root,
+		Env:     runOptions.Env,
+		Timeout: runOptions.Timeout,
+		Output:  os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	verdict := validator.ValidateRuntime(path, result.ExitCode, result.Actions)
+	report := runReport{ScriptReport: security.NewScriptReport(path, verdict), Args: args, Run: result}
+
+	w, closeReport, err := reportWriter(options.Output)
+	if err != nil {
+		return err
+	}
+	defer closeReport()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to write JSON report: %w", err)
+		}
+	case "sarif":
+		err = security.WriteSARIFReport(w, []security.ScriptReport{report.ScriptReport})
+	default:
+		writeRunReport(w, report)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !report.Valid && !options.ExitZero {
+		return ci.Errorf(ci.ClassPolicy, "%s failed verification in the sandbox", path)
+	}
+	return nil
+}
+
+// writeRunReport writes the recorded actions and findings in a
+// human-readable format
+func writeRunReport(w io.Writer, report runReport) {
+	run := report.Run
+	fmt.Fprintf(w, "Ran %s %s in a %s sandbox: exit status %d\n", report.Path, strings.Join(report.Args, " "), run.Backend, run.ExitCode)
+	if run.Tracer == "strace" {
+		fmt.Fprintf(w, "Attempted actions (%d):\n", len(run.Actions))
+	} else {
+		fmt.Fprintf(w, "Changes to the fake root (%d; install strace to record every attempted write and exec):\n", len(run.Actions))
+	}
+	for _, action := range run.Actions {
+		fmt.Fprintf(w, "  %s\n", action)
+	}
+	writeTextReport(w, []security.ScriptReport{report.ScriptReport})
+}
@@ -0,0 +1,293 @@
+// Package auditlog keeps an append-only record of the changes pkginstall
+// makes to the system: symlinks created and removed, files displaced and
+// restored, and packages built, installed and removed. Each entry carries the
+// SHA-256 hash of the previous one, so editing, reordering or deleting an
+// entry breaks the chain and is detected by Verify. Removing entries from the
+// end keeps the chain valid; it shows only against a copy of the last hash.
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-i2p/go-pkginstall/pkg/state"
+)
+
+// SystemDir holds the audit log of runs as root
+const SystemDir = "/var/log/pkginstall"
+
+// Action is the kind of change an entry records
+type Action string
+
+const (
+	ActionSymlinkCreated   Action = "symlink-created"
+	ActionSymlinkRemoved   Action = "symlink-removed"
+	ActionFileDisplaced    Action = "file-displaced" // Backed up before being replaced or removed
+	ActionFileRestored     Action = "file-restored"
+	ActionPackageBuilt     Action = "package-built"
+	ActionFindingWaived    Action = "finding-waived" // Script validation finding accepted by a waiver in a built package
+	ActionPackageInstalled Action = "package-installed"
+	ActionPackageRemoved   Action = "package-removed"
+)
+
+// tailChunk is the number of bytes read at a time while looking for the
+// last entry from the end of the file
+const tailChunk = 4096
+
+// genesisHash is the previous hash of the first entry
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Entry is one recorded change. Seq, Time, User, PID, PrevHash and Hash are
+// filled in by Append.
+type Entry struct {
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Action   Action    `json:"action"`
+	Path     string    `json:"path,omitempty"`    // Symlink, file or package file changed
+	Target   string    `json:"target,omitempty"`  // Symlink destination, or where a displaced file was saved
+	Package  string    `json:"package,omitempty"` // Package name
+	Version  string    `json:"version,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	User     string    `json:"user"` // "uid" or "uid(name)"
+	PID      int       `json:"pid"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// computeHash returns the hash of the entry with its Hash field cleared
+func (e Entry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(append([]byte(e.PrevHash+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is an audit log file
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultPath returns SystemDir/audit.jsonl for root, and otherwise
+// audit.jsonl next to the run history, honouring $XDG_STATE_HOME
+func DefaultPath() (string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join(SystemDir, "audit.jsonl"), nil
+	}
+	return state.Dir("audit.jsonl")
+}
+
+// New creates a Log backed by path. An empty path uses DefaultPath.
+func New(path string) (*Log, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	return &Log{path: path}, nil
+}
+
+// Path returns the file backing the log
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append chains entry to the last one in the log and writes it. The file is
+// locked while the last entry is read, so concurrent runs keep the chain
+// intact.
+func (l *Log) Append(entry Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0750); err != nil {
+		return entry, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return entry, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return entry, fmt.Errorf("failed to lock audit log: %w", err)
+	}
+
+	last, err := lastEntry(f)
+	if err != nil {
+		return entry, err
+	}
+	entry.Seq, entry.PrevHash = 1, genesisHash
+	if last != nil {
+		entry.Seq, entry.PrevHash = last.Seq+1, last.Hash
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	entry.User = currentUser()
+	entry.PID = os.Getpid()
+	entry.Hash = entry.computeHash()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return entry, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return entry, nil
+}
+
+// lastEntry returns the last entry of f, or nil if it is empty. Only the
+// end of the file is read, so appending does not slow down as the log grows.
+func lastEntry(f *os.File) (*Entry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	var line []byte
+	for end := info.Size(); end > 0; {
+		n := int64(tailChunk)
+		if n > end {
+			n = end
+		}
+		end -= n
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, end); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		line = bytes.TrimRightFunc(append(chunk, line...), unicode.IsSpace)
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 {
+			line = line[i+1:]
+			break
+		}
+	}
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, nil
+	}
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, fmt.Errorf("corrupt last audit log entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// List returns all entries, oldest first. A missing file yields no entries.
+func (l *Log) List() ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	return readEntries(f)
+}
+
+// readEntries parses JSON lines
+func readEntries(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt audit log entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// ChainError reports the first entry that does not match the hash chain
+type ChainError struct {
+	Seq    int64  // Sequence number of the entry
+	Index  int    // Position of the entry in the log, from 0
+	Reason string // What does not match
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit log chain broken at entry %d (seq %d): %s", e.Index+1, e.Seq, e.Reason)
+}
+
+// Verify checks that every entry hashes to its recorded hash and links to
+// the one before it. It returns a *ChainError for the first mismatch.
+func Verify(entries []Entry) error {
+	prev, seq := genesisHash, int64(0)
+	for i, entry := range entries {
+		switch {
+		case entry.PrevHash != prev:
+			return &ChainError{Seq: entry.Seq, Index: i, Reason: "previous hash does not match; an entry was removed or changed"}
+		case entry.Hash != entry.computeHash():
+			return &ChainError{Seq: entry.Seq, Index: i, Reason: "hash does not match the entry's contents"}
+		case entry.Seq != seq+1:
+			return &ChainError{Seq: entry.Seq, Index: i, Reason: fmt.Sprintf("expected sequence number %d", seq+1)}
+		}
+		prev, seq = entry.Hash, entry.Seq
+	}
+	return nil
+}
+
+// currentUser describes the effective user
+func currentUser() string {
+	uid := strconv.Itoa(os.Geteuid())
+	if u, err := user.LookupId(uid); err == nil {
+		return uid + "(" + u.Username + ")"
+	}
+	return uid
+}
+
+var (
+	defaultMu  sync.RWMutex
+	defaultLog *Log
+)
+
+// SetDefault makes l the log Record writes to. The command line sets it;
+// nothing is recorded until it is set, so library users and tests opt in.
+func SetDefault(l *Log) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLog = l
+}
+
+// Default returns the log Record writes to, or nil
+func Default() *Log {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLog
+}
+
+// Record appends entry to the default log, if one is set. Failing to record
+// is logged as a warning but does not fail the change being recorded.
+func Record(entry Entry) {
+	l := Default()
+	if l == nil {
+		return
+	}
+	if _, err := l.Append(entry); err != nil {
+		slog.Warn(fmt.Sprintf("could not write audit log: %v", err))
+	}
+}
@@ -0,0 +1,217 @@
+package auditlog
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAppendChain(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "auditlog-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	log := newLog(t, filepath.Join(tempDir, "log", "audit.jsonl"))
+	entries, err := log.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("missing log should be empty, got %v, %v", entries, err)
+	}
+
+	first, err := log.Append(Entry{Action: ActionSymlinkCreated, Path: "/usr/bin/app", Target: "/opt/app/bin/app"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if first.Seq != 1 || first.PrevHash != genesisHash || first.Hash == "" || first.User == "" {
+		t.Errorf("unexpected first entry: %+v", first)
+	}
+	second, err := log.Append(Entry{Action: ActionPackageInstalled, Package: "app", Version: "1.0"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if second.Seq != 2 || second.PrevHash != first.Hash {
+		t.Errorf("second entry is not chained to the first: %+v", second)
+	}
+
+	entries, err = log.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if err := Verify(entries); err != nil {
+		t.Errorf("Verify failed on an untouched log: %v", err)
+	}
+
+	info, err := os.Stat(log.Path())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		t.Errorf("audit log should not be world-writable, mode %v", info.Mode())
+	}
+}
+
+func TestAppendReadsLastEntry(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "auditlog-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The last entry spans several chunks and is followed by blank lines
+	log := newLog(t, filepath.Join(tempDir, "audit.jsonl"))
+	long, err := log.Append(Entry{Action: ActionPackageBuilt, Detail: strings.Repeat("x", 3*tailChunk)})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	f, err := os.OpenFile(log.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	f.WriteString("\n \n")
+	f.Close()
+
+	next, err := log.Append(Entry{Action: ActionPackageRemoved, Package: "app"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if next.Seq != 2 || next.PrevHash != long.Hash {
+		t.Errorf("entry is not chained to the long one: %+v", next)
+	}
+	entries, err := log.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if err := Verify(entries); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "auditlog-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	log := newLog(t, filepath.Join(tempDir, "audit.jsonl"))
+	for _, path := range []string{"/usr/bin/a", "/usr/bin/b", "/usr/bin/c"} {
+		if _, err := log.Append(Entry{Action: ActionSymlinkCreated, Path: path}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	entries, err := log.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func([]Entry) []Entry
+		index  int
+	}{
+		{"EditedEntry", func(e []Entry) []Entry { e[1].Path = "/usr/bin/evil"; return e }, 1},
+		{"RemovedEntry", func(e []Entry) []Entry { return append(e[:1], e[2:]...) }, 1},
+		{"RemovedFirstEntry", func(e []Entry) []Entry { return e[1:] }, 0},
+		{"ReorderedEntries", func(e []Entry) []Entry { e[1], e[2] = e[2], e[1]; return e }, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]Entry(nil), entries...))
+			err := Verify(tampered)
+			var chainErr *ChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("expected a ChainError, got %v", err)
+			}
+			if chainErr.Index != tt.index {
+				t.Errorf("expected the break at entry %d, got %d (%v)", tt.index, chainErr.Index, err)
+			}
+		})
+	}
+
+	t.Run("RemovedLastEntry", func(t *testing.T) {
+		// A chain cannot reveal that its tail was cut off; comparing the
+		// last hash with a copy kept elsewhere does
+		if err := Verify(entries[:2]); err != nil {
+			t.Errorf("a prefix of the log should verify: %v", err)
+		}
+	})
+}
+
+func TestConcurrentAppend(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "auditlog-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "audit.jsonl")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Separate Logs, like separate processes, rely on the file lock
+			log, err := New(path)
+			if err == nil {
+				_, err = log.Append(Entry{Action: ActionPackageBuilt})
+			}
+			if err != nil {
+				t.Errorf("Append failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := newLog(t, path).List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 10 {
+		t.Errorf("expected 10 entries, got %d", len(entries))
+	}
+	if err := Verify(entries); err != nil {
+		t.Errorf("concurrent appends broke the chain: %v", err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "auditlog-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer SetDefault(nil)
+
+	// Without a default log nothing is recorded
+	Record(Entry{Action: ActionPackageRemoved, Package: "ignored"})
+
+	path := filepath.Join(tempDir, "audit.jsonl")
+	SetDefault(newLog(t, path))
+	Record(Entry{Action: ActionPackageRemoved, Package: "app"})
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if strings.Count(string(content), "\n") != 1 || !strings.Contains(string(content), `"package":"app"`) {
+		t.Errorf("unexpected audit log: %s", content)
+	}
+}
+
+// newLog creates a Log at path
+func newLog(t *testing.T, path string) *Log {
+	log, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return log
+}
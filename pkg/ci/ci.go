@@ -0,0 +1,207 @@
+// Package ci classifies command failures and maps them to the stable exit
+// codes pipelines branch on, and holds the non-interactive mode selected
+// with --ci.
+package ci
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+
+	"github.com/spf13/cobra"
+)
+
+// Class is the kind of a failure
+type Class string
+
+const (
+	ClassError       Class = "error"       // Anything not classified below
+	ClassUsage       Class = "usage"       // Invalid flags or arguments
+	ClassValidation  Class = "validation"  // Package metadata or contents are invalid
+	ClassBuild       Class = "build"       // Staging or writing the package failed
+	ClassEnvironment Class = "environment" // A tool, directory or permission the command needs is missing
+	ClassPolicy      Class = "policy"      // A security policy rejected the package or script
+)
+
+// Exit codes of each class. They are part of the command-line interface and
+// must not change.
+const (
+	ExitOK          = 0
+	ExitError       = 1
+	ExitUsage       = 2
+	ExitValidation  = 3
+	ExitBuild       = 4
+	ExitEnvironment = 5
+	ExitPolicy      = 6
+)
+
+var exitCodes = map[Class]int{
+	ClassError:       ExitError,
+	ClassUsage:       ExitUsage,
+	ClassValidation:  ExitValidation,
+	ClassBuild:       ExitBuild,
+	ClassEnvironment: ExitEnvironment,
+	ClassPolicy:      ExitPolicy,
+}
+
+// Error is a failure of a known class
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap marks err as a failure of class. Errors that already carry a class
+// keep it, so the most specific classification wins.
+func Wrap(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// Errorf formats an error of class, wrapping %w operands like fmt.Errorf
+func Errorf(class Class, format string, args ...interface{}) error {
+	return Wrap(class, fmt.Errorf(format, args...))
+}
+
+// usagePrefixes start the messages of usage errors cobra returns without
+// passing them to a FlagErrorFunc
+var usagePrefixes = []string{"required flag(s)", "unknown command", "unknown flag", "unknown shorthand flag"}
+
+// ClassOf returns the class of err. Unclassified errors caused by a missing
+// program or a denied permission are environment failures.
+func ClassOf(err error) Class {
+	var classified *Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &classified):
+		return classified.Class
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrPermission):
+		return ClassEnvironment
+	}
+	for _, prefix := range usagePrefixes {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return ClassUsage
+		}
+	}
+	return ClassError
+}
+
+// ExitCode returns the exit code for err: ExitOK for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	return exitCodes[ClassOf(err)]
+}
+
+var enabled atomic.Bool
+
+// SetEnabled turns CI mode on or off
+func SetEnabled(on bool) { enabled.Store(on) }
+
+// Enabled reports whether CI mode is on: prompts are never shown and output
+// is JSON
+func Enabled() bool { return enabled.Load() }
+
+// Interactive reports whether a command may prompt on the terminal
+func Interactive() bool { return !Enabled() }
+
+// CaptureStdout redirects os.Stdout to a pipe until the returned function is
+// called, which restores it and returns everything written in between
+func CaptureStdout() (func() []byte, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture output: %w", err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, r)
+		r.Close()
+		close(done)
+	}()
+
+	return func() []byte {
+		w.Close()
+		<-done
+		os.Stdout = orig
+		return buf.Bytes()
+	}, nil
+}
+
+// Result is the JSON document a command run writes to stdout in CI mode
+type Result struct {
+	Command  string          `json:"command"`
+	Status   string          `json:"status"` // "ok" or "failed"
+	ExitCode int             `json:"exit_code"`
+	Class    Class           `json:"class,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Output   json.RawMessage `json:"output,omitempty"` // JSON output of the command, or its text output as an array of lines
+}
+
+// NewResult describes the outcome of command, given what it wrote to stdout
+func NewResult(command string, output []byte, err error) Result {
+	result := Result{Command: command, Status: "ok", ExitCode: ExitCode(err)}
+	if err != nil {
+		result.Status, result.Class, result.Error = "failed", ClassOf(err), err.Error()
+	}
+	if trimmed := strings.TrimSpace(string(output)); trimmed != "" {
+		if json.Valid([]byte(trimmed)) {
+			result.Output = json.RawMessage(trimmed)
+		} else {
+			lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+			result.Output, _ = json.Marshal(lines)
+		}
+	}
+	return result
+}
+
+// Write writes the result as one line of JSON
+func (r Result) Write(w io.Writer) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// ClassifyUsage marks flag and argument errors of root and all its
+// subcommands as usage failures
+func ClassifyUsage(root *cobra.Command) {
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return Wrap(ClassUsage, err)
+	})
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		if args := cmd.Args; args != nil {
+			cmd.Args = func(cmd *cobra.Command, a []string) error {
+				return Wrap(ClassUsage, args(cmd, a))
+			}
+		}
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+}
@@ -0,0 +1,121 @@
+package ci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class Class
+		code  int
+	}{
+		{"nil", nil, "", ExitOK},
+		{"unclassified", errors.New("boom"), ClassError, ExitError},
+		{"usage", Errorf(ClassUsage, "bad flag"), ClassUsage, ExitUsage},
+		{"validation", Errorf(ClassValidation, "bad name"), ClassValidation, ExitValidation},
+		{"build", Errorf(ClassBuild, "dpkg-deb failed"), ClassBuild, ExitBuild},
+		{"environment", Errorf(ClassEnvironment, "no bwrap"), ClassEnvironment, ExitEnvironment},
+		{"policy", Errorf(ClassPolicy, "script rejected"), ClassPolicy, ExitPolicy},
+		{"wrapped", fmt.Errorf("build: %w", Errorf(ClassPolicy, "strict mode")), ClassPolicy, ExitPolicy},
+		{"inner class wins", Wrap(ClassBuild, Errorf(ClassValidation, "bad version")), ClassValidation, ExitValidation},
+		{"missing program", fmt.Errorf("run: %w", exec.ErrNotFound), ClassEnvironment, ExitEnvironment},
+		{"permission", fmt.Errorf("write: %w", os.ErrPermission), ClassEnvironment, ExitEnvironment},
+		{"required flag", errors.New(`required flag(s) "name" not set`), ClassUsage, ExitUsage},
+		{"unknown command", errors.New(`unknown command "bogus" for "pkginstall"`), ClassUsage, ExitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := ClassOf(tt.err); class != tt.class {
+				t.Errorf("ClassOf() = %q, want %q", class, tt.class)
+			}
+			if code := ExitCode(tt.err); code != tt.code {
+				t.Errorf("ExitCode() = %d, want %d", code, tt.code)
+			}
+		})
+	}
+
+	if Wrap(ClassBuild, nil) != nil {
+		t.Error("Wrap(nil) should return nil")
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	t.Run("JSONOutput", func(t *testing.T) {
+		result := NewResult("pkginstall doctor", []byte("[{\"name\":\"dpkg-deb\"}]\n"), Errorf(ClassEnvironment, "1 of 6 checks failed"))
+		if result.Status != "failed" || result.ExitCode != ExitEnvironment || result.Class != ClassEnvironment {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		if string(result.Output) != `[{"name":"dpkg-deb"}]` {
+			t.Errorf("JSON output should be kept as-is, got %s", result.Output)
+		}
+	})
+
+	t.Run("TextOutput", func(t *testing.T) {
+		result := NewResult("pkginstall version", []byte("pkginstall 1.0\n  Commit: abc\n"), nil)
+		if result.Status != "ok" || result.ExitCode != ExitOK || result.Class != "" || result.Error != "" {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		var lines []string
+		if err := json.Unmarshal(result.Output, &lines); err != nil {
+			t.Fatalf("text output should be an array of lines: %v", err)
+		}
+		if len(lines) != 2 || lines[1] != "  Commit: abc" {
+			t.Errorf("unexpected lines: %q", lines)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		var out strings.Builder
+		if err := NewResult("pkginstall build", nil, Errorf(ClassBuild, "failed")).Write(&out); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		want := `{"command":"pkginstall build","status":"failed","exit_code":4,"class":"build","error":"failed"}` + "\n"
+		if out.String() != want {
+			t.Errorf("Write() = %q, want %q", out.String(), want)
+		}
+	})
+}
+
+func TestClassifyUsage(t *testing.T) {
+	root := &cobra.Command{Use: "root", SilenceErrors: true, SilenceUsage: true}
+	root.AddCommand(&cobra.Command{
+		Use:  "sub",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error { return nil },
+	})
+	ClassifyUsage(root)
+
+	for _, args := range [][]string{{"sub", "extra"}, {"sub", "--bogus"}} {
+		root.SetArgs(args)
+		if err := root.Execute(); ClassOf(err) != ClassUsage {
+			t.Errorf("%v: expected a usage error, got %v (%q)", args, err, ClassOf(err))
+		}
+	}
+}
+
+func TestCaptureStdout(t *testing.T) {
+	orig := os.Stdout
+	finish, err := CaptureStdout()
+	if err != nil {
+		t.Fatalf("CaptureStdout failed: %v", err)
+	}
+	fmt.Println("captured")
+	output := finish()
+	if os.Stdout != orig {
+		t.Error("os.Stdout was not restored")
+	}
+	if string(output) != "captured\n" {
+		t.Errorf("captured %q", output)
+	}
+}
@@ -1,13 +1,20 @@
 package compat
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/container"
 	"github.com/go-i2p/go-pkginstall/pkg/debian"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+	"github.com/go-i2p/go-pkginstall/pkg/privilege"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
 	"github.com/spf13/cobra"
 )
 
@@ -23,6 +30,8 @@ type CheckinstallFlags struct {
 	Summary        string
 	License        string
 	Provides       string
+	Conflicts      string
+	Replaces       string
 	Requires       string
 	Description    string
 	DescriptionPak string
@@ -32,6 +41,7 @@ type CheckinstallFlags struct {
 	DocDir              string
 	DefaultDocs         bool
 	StripExecutables    bool
+	StripLibraries      bool
 	KeepBuildFiles      bool
 	BackupConfiguration bool
 	FStrans             bool
@@ -44,7 +54,6 @@ type CheckinstallFlags struct {
 	InstalledFile string
 
 	// Behavior flags
-	NoStrip       bool
 	NoSign        bool
 	Reset         bool
 	Interactive   bool
@@ -54,6 +63,23 @@ type CheckinstallFlags struct {
 	ReviewInstall bool
 	AcceptPak     bool
 	Type          string
+
+	// go-pkginstall extensions
+	PolicyFile       string
+	Profile          string
+	TransformTarget  string
+	PerPackageDir    bool
+	RelativeSymlinks bool
+	DebugPackage     bool
+	StripExclude     []string
+	AptContents      bool
+	AllowRoot        bool
+
+	// Container-assisted builds
+	InContainer      string
+	ContainerRuntime string
+	ContainerNetwork string
+	ContainerEnv     []string
 }
 
 // CheckinstallBuilderOptions maps Checkinstall flags to go-pkginstall build options
@@ -68,8 +94,21 @@ func (f *CheckinstallFlags) ToBuilderOptions() *debian.BuildOptions {
 		Section:       f.PkgGroup,
 		OutputDir:     ".",
 		SourceDir:     ".",
+		PackageType:   f.Type,
+		Release:       f.Release,
 		PreservePerms: !f.StripExecutables,
 		Verbose:       f.Debug,
+		PolicyFile:    f.PolicyFile,
+		Profile:       f.Profile,
+		PerPackageDir: f.PerPackageDir,
+
+		RelativeSymlinks: f.RelativeSymlinks,
+
+		StripExecutables: f.StripExecutables,
+		StripLibraries:   f.StripLibraries,
+		DebugPackage:     f.DebugPackage,
+		StripExclude:     f.StripExclude,
+		AptContents:      f.AptContents,
 	}
 
 	// Set source directory to current directory if not specified
@@ -77,11 +116,21 @@ func (f *CheckinstallFlags) ToBuilderOptions() *debian.BuildOptions {
 		buildOpts.SourceDir = f.InstallPrefix
 	}
 
+	buildOpts.TransformTarget = f.TransformTarget
+
 	// Convert comma-separated provides to slice
 	if f.Provides != "" {
 		buildOpts.Provides = strings.Split(f.Provides, ",")
 	}
 
+	// Convert comma-separated conflicts and replaces to slices
+	if f.Conflicts != "" {
+		buildOpts.Conflicts = strings.Split(f.Conflicts, ",")
+	}
+	if f.Replaces != "" {
+		buildOpts.Replaces = strings.Split(f.Replaces, ",")
+	}
+
 	// Convert comma-separated requires to depends
 	if f.Requires != "" {
 		buildOpts.Depends = strings.Split(f.Requires, ",")
@@ -91,6 +140,7 @@ func (f *CheckinstallFlags) ToBuilderOptions() *debian.BuildOptions {
 	if len(f.Exclude) > 0 {
 		buildOpts.ExcludeDirs = f.Exclude
 	}
+	buildOpts.IncludePatterns = f.Include
 
 	// Set security options based on Checkinstall's FStrans flag
 	buildOpts.DisableSymlinks = !f.FStrans
@@ -117,9 +167,22 @@ benefiting from enhanced security features provided by go-pkginstall.
 Usage:
   pkginstall checkinstall [flags] [-- command]
 
+With --in-container, the install command runs in a throwaway docker or
+podman container with the current directory mounted at /src, and the files
+it adds or changes in the container become the package payload. Untrusted
+build scripts never touch the host filesystem.
+
+With --inspect, the files of an installed package (from the dpkg database)
+or of a .deb file are listed with the paths they would move to. Once
+confirmed (or with --accept), they are rebuilt as a transformed package with
+the original metadata, a migration path for packages built by checkinstall.
+
 Example:
   pkginstall checkinstall -D --pkgname=myapp --pkgversion=1.0 -- make install
-  pkginstall checkinstall --install=no --fstrans=no -D`,
+  pkginstall checkinstall --install=no --fstrans=no -D
+  pkginstall checkinstall --in-container debian:bookworm --pkgname=myapp -- make install
+  pkginstall checkinstall --inspect myapp
+  pkginstall checkinstall --inspect old/myapp_1.0-1_amd64.deb --accept`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runCheckinstall(cmd, args, flags)
 		},
@@ -138,6 +201,8 @@ Example:
 	cmd.Flags().StringVarP(&flags.Summary, "pkgsummary", "s", "", "Package summary")
 	cmd.Flags().StringVarP(&flags.License, "license", "l", "", "Package license")
 	cmd.Flags().StringVar(&flags.Provides, "provides", "", "Package provides (comma-separated)")
+	cmd.Flags().StringVar(&flags.Conflicts, "conflicts", "", "Packages this package conflicts with (comma-separated)")
+	cmd.Flags().StringVar(&flags.Replaces, "replaces", "", "Packages whose files this package may overwrite (comma-separated)")
 	cmd.Flags().StringVar(&flags.Requires, "requires", "", "Package requires/depends (comma-separated)")
 	cmd.Flags().StringVarP(&flags.Description, "pkgdescription", "d", "", "Package description")
 	cmd.Flags().StringVar(&flags.DescriptionPak, "dpkgdescription", "", "Debian package description file")
@@ -147,19 +212,19 @@ Example:
 	cmd.Flags().StringVar(&flags.DocDir, "docdir", "", "Documentation directory")
 	cmd.Flags().BoolVar(&flags.DefaultDocs, "deldoc", true, "Delete doc-pak directory after package creation")
 	cmd.Flags().BoolVar(&flags.StripExecutables, "strip", false, "Strip executables")
+	cmd.Flags().BoolVar(&flags.StripLibraries, "stripso", false, "Strip shared libraries")
 	cmd.Flags().BoolVar(&flags.KeepBuildFiles, "keep", false, "Keep build files")
 	cmd.Flags().BoolVar(&flags.BackupConfiguration, "backup", true, "Backup configuration files")
 	cmd.Flags().BoolVar(&flags.FStrans, "fstrans", true, "Enable filesystem translation (security feature)")
 
 	// Add file-related flags
-	cmd.Flags().StringArrayVar(&flags.Include, "include", nil, "Include files/directories")
-	cmd.Flags().StringArrayVar(&flags.Exclude, "exclude", nil, "Exclude files/directories")
+	cmd.Flags().StringArrayVar(&flags.Include, "include", nil, "Include files/directories matching a glob, even if excluded")
+	cmd.Flags().StringArrayVar(&flags.Exclude, "exclude", nil, "Exclude files/directories matching a glob")
 	cmd.Flags().StringVar(&flags.ExcludeFile, "exclude-file", "", "File containing exclusion patterns")
 	cmd.Flags().StringVar(&flags.ExcludeDocsf, "excludedocs", "", "File containing excluded docs")
-	cmd.Flags().StringVar(&flags.InstalledFile, "inspect", "", "Inspect an already-installed package")
+	cmd.Flags().StringVar(&flags.InstalledFile, "inspect", "", "List an installed package or .deb file and offer to rebuild it as a transformed package")
 
 	// Add behavior flags
-	cmd.Flags().BoolVar(&flags.NoStrip, "stripso", false, "Strip shared libraries")
 	cmd.Flags().BoolVar(&flags.NoSign, "nosign", true, "Do not sign package")
 	cmd.Flags().BoolVarP(&flags.Interactive, "interactive", "i", true, "Interactive mode")
 	cmd.Flags().BoolVarP(&flags.ShowHelp, "help", "h", false, "Show help message")
@@ -168,11 +233,37 @@ Example:
 	cmd.Flags().BoolVar(&flags.ReviewInstall, "review-install", true, "Review installation")
 	cmd.Flags().BoolVar(&flags.AcceptPak, "accept", false, "Accept default answers")
 
+	// go-pkginstall extensions
+	cmd.Flags().StringVar(&flags.PolicyFile, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.Flags().StringVar(&flags.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+"); checkinstall-compat keeps original paths")
+
+	cmd.Flags().StringVar(&flags.TransformTarget, "transform-target", string(security.TargetOpt),
+		"Where system paths are relocated (opt, usr-local, srv)")
+	cmd.Flags().BoolVar(&flags.PerPackageDir, "per-package-dir", false,
+		"Relocate into a per-package directory such as /opt/<name>")
+	cmd.Flags().BoolVar(&flags.RelativeSymlinks, "relative-symlinks", false,
+		"Create install-time symlinks with relative paths (../../opt/...)")
+	cmd.Flags().BoolVar(&flags.DebugPackage, "dbgsym", false,
+		"Keep debug info removed by --strip/--stripso in a <name>-dbgsym package")
+	cmd.Flags().StringArrayVar(&flags.StripExclude, "strip-exclude", nil, "Never strip files matching a glob")
+	cmd.Flags().BoolVar(&flags.AptContents, "apt-contents", false,
+		"Also suggest relations with packages that are not installed, using the apt-file Contents indices")
+	cmd.Flags().BoolVar(&flags.AllowRoot, "allow-root", false,
+		privilege.AllowRootUsage+"; an install command that needs root can run --in-container instead")
+	cmd.Flags().StringVar(&flags.InContainer, "in-container", "",
+		"Run the install command in a throwaway container from this image and package the files it installs")
+	cmd.Flags().StringVar(&flags.ContainerRuntime, "container-runtime", "",
+		"Container engine for --in-container ("+strings.Join(container.Runtimes, ", ")+"; default: the first installed)")
+	cmd.Flags().StringVar(&flags.ContainerNetwork, "container-network", container.DefaultNetwork,
+		"Network of the --in-container container, e.g. bridge when the install command downloads dependencies")
+	cmd.Flags().StringArrayVar(&flags.ContainerEnv, "container-env", nil, "Set KEY=VALUE in the --in-container container (repeatable)")
+
 	// Add package type flags (mimic original Checkinstall's behavior)
-	cmd.Flags().StringVarP(&flags.Type, "type", "t", "debian", "Package type (determined by -D/-R/-S flags)")
+	cmd.Flags().StringVarP(&flags.Type, "type", "t", debian.DefaultPackageType, "Package type ("+strings.Join(debian.PackageTypes(), ", ")+"; also set by -D/-R/-S)")
 	cmd.Flags().BoolP("debian", "D", false, "Create Debian package (default)")
-	cmd.Flags().BoolP("rpm", "R", false, "Create RPM package (unsupported)")
-	cmd.Flags().BoolP("slackware", "S", false, "Create Slackware package (unsupported)")
+	cmd.Flags().BoolP("rpm", "R", false, "Create RPM package")
+	cmd.Flags().BoolP("slackware", "S", false, "Create Slackware package")
 
 	// Add install flag (legacy behavior simulation)
 	install := cmd.Flags().String("install", "yes", "Install the package after creation (yes/no)")
@@ -185,10 +276,11 @@ Example:
 			// No need to change behavior as go-pkginstall doesn't install by default
 		}
 
-		// Check for unsupported package types
-		if cmd.Flags().Changed("rpm") || cmd.Flags().Changed("slackware") {
-			fmt.Println("Warning: Only Debian packages are supported in go-pkginstall.")
-			fmt.Println("The package will be created as a Debian package regardless of -R or -S flags.")
+		// -D, -R and -S select the package type like --type
+		for _, packageType := range []string{"debian", "rpm", "slackware"} {
+			if cmd.Flags().Changed(packageType) {
+				flags.Type = packageType
+			}
 		}
 	}
 
@@ -204,14 +296,32 @@ func runCheckinstall(cmd *cobra.Command, args []string, flags *CheckinstallFlags
 		return nil
 	}
 
+	if err := privilege.CheckRoot(flags.AllowRoot); err != nil {
+		return err
+	}
+
 	// Process install command if provided after --
 	installCommand := []string{}
-	for i, arg := range args {
-		if arg == "--" && i < len(args)-1 {
-			installCommand = args[i+1:]
-			args = args[:i]
-			break
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 && dash < len(args) {
+		installCommand = args[dash:]
+		args = args[:dash]
+	}
+
+	// Rebuild the files of an existing package instead of running a command
+	if flags.InstalledFile != "" {
+		if len(installCommand) > 0 || flags.InContainer != "" {
+			return fmt.Errorf("--inspect cannot be combined with an install command")
+		}
+		stageDir, err := runInspect(cmd, flags)
+		if err != nil || stageDir == "" {
+			return err
+		}
+		if !flags.KeepBuildFiles {
+			defer os.RemoveAll(stageDir)
+		} else {
+			fmt.Printf("Staged files kept in %s\n", stageDir)
 		}
+		flags.InstallPrefix = stageDir
 	}
 
 	// Set default maintainer if not provided
@@ -247,7 +357,7 @@ func runCheckinstall(cmd *cobra.Command, args []string, flags *CheckinstallFlags
 
 	// Handle exclusion file if provided
 	if flags.ExcludeFile != "" {
-		excludePatterns, err := readExcludeFile(flags.ExcludeFile)
+		excludePatterns, err := pattern.ReadPatternFile(flags.ExcludeFile)
 		if err != nil {
 			return fmt.Errorf("failed to read exclude file: %w", err)
 		}
@@ -257,6 +367,26 @@ func runCheckinstall(cmd *cobra.Command, args []string, flags *CheckinstallFlags
 	// Convert Checkinstall flags to go-pkginstall build options
 	buildOpts := flags.ToBuilderOptions()
 
+	// Load the package type, profile and policy before running the install
+	// command so mistakes fail early
+	if _, err := debian.LookupPackageWriter(buildOpts.PackageType); err != nil {
+		return err
+	}
+	profile, err := security.LookupProfile(buildOpts.Profile)
+	if err != nil {
+		return err
+	}
+	target, err := security.ParseTransformTarget(buildOpts.TransformTarget)
+	if err != nil {
+		return err
+	}
+	var policy *security.PolicyFile
+	if buildOpts.PolicyFile != "" {
+		if policy, err = security.LoadPolicyFile(buildOpts.PolicyFile); err != nil {
+			return err
+		}
+	}
+
 	// Print summary in debug mode
 	if flags.Debug {
 		fmt.Println("Checkinstall compatibility mode:")
@@ -268,8 +398,38 @@ func runCheckinstall(cmd *cobra.Command, args []string, flags *CheckinstallFlags
 		}
 	}
 
-	// Run the install command if provided
-	if len(installCommand) > 0 {
+	// Check the package metadata before the install command changes anything
+	arch, autoArch, err := debian.ResolveArchitecture(buildOpts.Architecture, "")
+	if err != nil {
+		return err
+	}
+	pkg := debian.NewPackage(
+		buildOpts.PackageName,
+		buildOpts.Version,
+		arch,
+		buildOpts.Maintainer,
+		buildOpts.Description,
+		buildOpts.Section,
+		"optional",
+		buildOpts.Depends,
+	)
+	if err := pkg.Validate(); err != nil {
+		return fmt.Errorf("invalid package metadata: %w", err)
+	}
+
+	// Run the install command in a container and package what it installed
+	if flags.InContainer != "" {
+		payloadDir, err := captureInContainer(cmd.Context(), flags, installCommand)
+		if err != nil {
+			return err
+		}
+		if !flags.KeepBuildFiles {
+			defer os.RemoveAll(payloadDir)
+		} else {
+			fmt.Printf("Captured payload kept in %s\n", payloadDir)
+		}
+		buildOpts.SourceDir = payloadDir
+	} else if len(installCommand) > 0 {
 		if flags.Debug {
 			fmt.Printf("Executing: %s\n", strings.Join(installCommand, " "))
 		}
@@ -281,20 +441,8 @@ func runCheckinstall(cmd *cobra.Command, args []string, flags *CheckinstallFlags
 	}
 
 	// Create a builder and build the package
-	builder, err := debian.NewBuilder(
-		debian.NewPackage(
-			buildOpts.PackageName,
-			buildOpts.Version,
-			buildOpts.Architecture,
-			buildOpts.Maintainer,
-			buildOpts.Description,
-			buildOpts.Section,
-			"optional",
-			buildOpts.Depends,
-		),
-		buildOpts.SourceDir,
-		buildOpts.OutputDir,
-	)
+	builder, err := debian.NewBuilder(pkg, buildOpts.SourceDir, buildOpts.OutputDir,
+		debian.WithPackageType(buildOpts.PackageType), debian.WithRelease(buildOpts.Release))
 
 	if err != nil {
 		return fmt.Errorf("failed to create package builder: %w", err)
@@ -303,25 +451,124 @@ func runCheckinstall(cmd *cobra.Command, args []string, flags *CheckinstallFlags
 	// Configure builder with options
 	builder.PreservePerms = buildOpts.PreservePerms
 	builder.Verbose = buildOpts.Verbose
+	builder.AutoArchitecture = autoArch
+	builder.DisableSymlinks = buildOpts.DisableSymlinks
+	builder.RelativeSymlinks = buildOpts.RelativeSymlinks
+	layout := &security.PathLayout{
+		Target:     target,
+		Package:    buildOpts.PackageName,
+		PerPackage: buildOpts.PerPackageDir,
+	}
+	if err := builder.ApplyLayout(layout); err != nil {
+		return fmt.Errorf("invalid path layout: %w", err)
+	}
+	builder.ApplyProfile(profile)
+	if policy != nil {
+		builder.ApplyPolicy(policy)
+	}
+	if buildOpts.StrictMode {
+		builder.EnableStrictMode()
+	}
 	for _, exclude := range buildOpts.ExcludeDirs {
 		builder.AddExcludeDir(exclude)
 	}
+	for _, include := range buildOpts.IncludePatterns {
+		builder.AddIncludePattern(include)
+	}
 
 	if len(buildOpts.Provides) > 0 {
 		builder.SetProvides(buildOpts.Provides)
 	}
+	if len(buildOpts.Conflicts) > 0 {
+		builder.SetConflicts(buildOpts.Conflicts)
+	}
+	if len(buildOpts.Replaces) > 0 {
+		builder.SetReplaces(buildOpts.Replaces)
+	}
+	builder.AptContents = buildOpts.AptContents
+	err = builder.SetStrip(debian.StripOptions{
+		Executables:  buildOpts.StripExecutables,
+		Libraries:    buildOpts.StripLibraries,
+		DebugPackage: buildOpts.DebugPackage,
+		Exclude:      buildOpts.StripExclude,
+	})
+	if err != nil {
+		return err
+	}
 
 	// Build the package
-	outputPath, err := builder.Build()
+	outputPath, _, err := builder.Build(cmd.Context())
+	summary := builder.Summary(outputPath)
+	summary.Command = "checkinstall"
+	if flags.InContainer != "" {
+		summary.AddAction(fmt.Sprintf("ran install command in container %s: %s", flags.InContainer, strings.Join(installCommand, " ")))
+	} else if len(installCommand) > 0 {
+		summary.AddAction(fmt.Sprintf("ran install command: %s", strings.Join(installCommand, " ")))
+	}
 	if err != nil {
-		return fmt.Errorf("package build failed: %w", err)
+		summary.SetError(err)
+		history.Record(os.Stdout, summary)
+		return ci.Errorf(ci.ClassBuild, "package build failed: %w", err)
 	}
 
 	fmt.Printf("Package created: %s\n", outputPath)
+	if builder.DebugPackagePath != "" {
+		fmt.Printf("Debug symbols: %s\n", builder.DebugPackagePath)
+	}
+	history.Record(os.Stdout, summary)
 
 	return nil
 }
 
+// captureInContainer runs the install command in a container from the
+// --in-container image with the current directory mounted, and returns a
+// temporary directory holding the files it installed
+func captureInContainer(ctx context.Context, flags *CheckinstallFlags, installCommand []string) (string, error) {
+	if len(installCommand) == 0 {
+		return "", fmt.Errorf("--in-container requires an install command after --")
+	}
+	sourceDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+	payloadDir, err := os.MkdirTemp("", "pkginstall-container-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create payload directory: %w", err)
+	}
+
+	fmt.Printf("Running %s in container %s\n", strings.Join(installCommand, " "), flags.InContainer)
+	result, err := container.Capture(ctx, container.Options{
+		Runtime:   flags.ContainerRuntime,
+		Image:     flags.InContainer,
+		Command:   installCommand,
+		SourceDir: sourceDir,
+		Network:   flags.ContainerNetwork,
+		Env:       flags.ContainerEnv,
+		Output:    os.Stdout,
+	}, payloadDir)
+	if err != nil {
+		os.RemoveAll(payloadDir)
+		return "", err
+	}
+	if len(result.Files) == 0 {
+		os.RemoveAll(payloadDir)
+		return "", fmt.Errorf("install command did not install any files in the container")
+	}
+	for _, name := range result.Deleted {
+		fmt.Printf("Warning: install command removed %s from the image; the package will not remove it\n", name)
+	}
+	for _, name := range result.Skipped {
+		fmt.Printf("Warning: skipped special file %s\n", name)
+	}
+	if flags.Debug {
+		fmt.Printf("Captured %d files with %s:\n", len(result.Files), result.Runtime)
+		for _, name := range result.Files {
+			fmt.Printf("  /%s\n", name)
+		}
+	}
+	return payloadDir, nil
+}
+
 // sanitizePackageName cleans a string to make it a valid Debian package name
 func sanitizePackageName(name string) string {
 	// Replace invalid characters with hyphens
@@ -348,26 +595,6 @@ func isAlphanumeric(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
 }
 
-// readExcludeFile reads a file containing patterns to exclude
-func readExcludeFile(filePath string) ([]string, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
-	}
-
-	lines := strings.Split(string(content), "\n")
-	var patterns []string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && !strings.HasPrefix(line, "#") {
-			patterns = append(patterns, line)
-		}
-	}
-
-	return patterns, nil
-}
-
 // runInstallCommand executes the installation command
 func runInstallCommand(args []string, debug bool) error {
 	if len(args) == 0 {
@@ -425,7 +652,7 @@ func EnsureCompatibility() error {
 	requiredCommands := []string{"dpkg-deb"}
 	for _, cmd := range requiredCommands {
 		if _, err := exec.LookPath(cmd); err != nil {
-			return fmt.Errorf("required command not found: %s", cmd)
+			return ci.Errorf(ci.ClassEnvironment, "required command not found: %s", cmd)
 		}
 	}
 
@@ -0,0 +1,268 @@
+package compat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/install"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/spf13/cobra"
+)
+
+// inspectedPackage is the file set and metadata of an installed package or
+// .deb file examined with --inspect
+type inspectedPackage struct {
+	Name         string
+	Version      string
+	Architecture string
+	Maintainer   string
+	Description  string
+	Section      string
+	Depends      string
+	Files        []string // Paths that are not directories
+	Dirs         int
+	deb          string // The .deb file; empty for an installed package
+}
+
+// inspectPackage reads a .deb file, or the dpkg database entry of an
+// installed package
+func inspectPackage(name string) (*inspectedPackage, error) {
+	if strings.HasSuffix(name, ".deb") {
+		info, err := install.Inspect(name)
+		if err != nil {
+			return nil, err
+		}
+		pkg := newInspectedPackage(info.Control)
+		pkg.deb = name
+		for _, file := range info.Files {
+			if file.IsDir {
+				pkg.Dirs++
+			} else {
+				pkg.Files = append(pkg.Files, file.Path)
+			}
+		}
+		return pkg, nil
+	}
+
+	db, err := dpkgdb.Open("/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dpkg database: %w", err)
+	}
+	if !db.Installed(name) {
+		return nil, fmt.Errorf("package %s is not installed", name)
+	}
+	fields, ok := db.Fields(name)
+	if !ok {
+		fields = map[string]string{"Package": name}
+	}
+	pkg := newInspectedPackage(fields)
+
+	// Directories are recorded like files. Those with entries below them are
+	// directories even where the host has replaced them with symlinks, such
+	// as /bin on merged-/usr systems; for the others, ask the host.
+	paths := db.Files(name)
+	parents := make(map[string]bool)
+	for _, path := range paths {
+		for dir := filepath.Dir(path); dir != "/" && !parents[dir]; dir = filepath.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+	for _, path := range paths {
+		if info, err := os.Lstat(path); parents[path] || (err == nil && info.IsDir()) {
+			pkg.Dirs++
+			continue
+		}
+		pkg.Files = append(pkg.Files, path)
+	}
+	return pkg, nil
+}
+
+// newInspectedPackage takes the metadata of a package from its control fields
+func newInspectedPackage(fields map[string]string) *inspectedPackage {
+	synopsis, _, _ := strings.Cut(fields["Description"], "\n")
+	var depends []string
+	for _, dep := range strings.Split(fields["Depends"], ",") {
+		if dep = strings.TrimSpace(dep); dep != "" {
+			depends = append(depends, dep)
+		}
+	}
+	return &inspectedPackage{
+		Name:         fields["Package"],
+		Version:      fields["Version"],
+		Architecture: fields["Architecture"],
+		Maintainer:   fields["Maintainer"],
+		Description:  synopsis,
+		Section:      fields["Section"],
+		Depends:      strings.Join(depends, ","),
+	}
+}
+
+// print lists the package and where each file lands in the transformed package
+func (p *inspectedPackage) print(out io.Writer, mapper *security.PathMapper) {
+	origin := "installed package"
+	if p.deb != "" {
+		origin = p.deb
+	}
+	fmt.Fprintf(out, "Package: %s %s (%s), from %s\n", p.Name, p.Version, p.Architecture, origin)
+	fmt.Fprintf(out, "%d files, %d directories\n", len(p.Files), p.Dirs)
+	for _, file := range p.Files {
+		transformed, _, err := mapper.TransformPath(file)
+		switch {
+		case err != nil:
+			fmt.Fprintf(out, "  %s (cannot be relocated: %v)\n", file, err)
+		case transformed == file:
+			fmt.Fprintf(out, "  %s (kept)\n", file)
+		default:
+			fmt.Fprintf(out, "  %s -> %s\n", file, transformed)
+		}
+	}
+}
+
+// stage copies the file set of the package into a new directory, laid out
+// as it is installed, to be packaged again
+func (p *inspectedPackage) stage() (string, error) {
+	dir, err := ioutil.TempDir("", "pkginstall-inspect-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	if p.deb != "" {
+		if out, err := exec.Command("dpkg-deb", "-x", p.deb, dir).CombinedOutput(); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to extract %s: %w: %s", p.deb, err, strings.TrimSpace(string(out)))
+		}
+		return dir, nil
+	}
+
+	for _, file := range p.Files {
+		if err := stageFile(file, filepath.Join(dir, file)); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Printf("Warning: %s is listed but missing; not packaged\n", file)
+				continue
+			}
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// stageFile copies a regular file or symlink, keeping its mode
+func stageFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dst, err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", src, err)
+		}
+		return os.Symlink(link, dst)
+	}
+	if !info.Mode().IsRegular() {
+		fmt.Printf("Warning: %s is not a regular file; not packaged\n", src)
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %w", src, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to stage %s: %w", src, err)
+	}
+	return out.Close()
+}
+
+// runInspect lists the package named by --inspect and, once confirmed,
+// stages its files so runCheckinstall rebuilds them as a transformed
+// package. Metadata not given on the command line is taken from the package.
+// It returns the staging directory, or "" when nothing is to be rebuilt.
+func runInspect(cmd *cobra.Command, flags *CheckinstallFlags) (string, error) {
+	pkg, err := inspectPackage(flags.InstalledFile)
+	if err != nil {
+		return "", err
+	}
+
+	mapper, err := previewMapper(flags, pkg.Name)
+	if err != nil {
+		return "", err
+	}
+	pkg.print(cmd.OutOrStdout(), mapper)
+
+	rebuild := flags.AcceptPak
+	if !rebuild && flags.Interactive && ci.Interactive() {
+		fmt.Fprint(cmd.OutOrStdout(), "\nRebuild these files as a transformed package? [y/N] ")
+		answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		rebuild = answer == "y" || answer == "yes"
+	}
+	if !rebuild {
+		return "", nil
+	}
+
+	for _, field := range []struct {
+		flag  string
+		dest  *string
+		value string
+	}{
+		{"pkgname", &flags.PackageName, pkg.Name},
+		{"pkgversion", &flags.Version, pkg.Version},
+		{"arch", &flags.Architecture, pkg.Architecture},
+		{"maintainer", &flags.Maintainer, pkg.Maintainer},
+		{"pkgdescription", &flags.Description, pkg.Description},
+		{"pkggroup", &flags.PkgGroup, pkg.Section},
+		{"requires", &flags.Requires, pkg.Depends},
+	} {
+		if !cmd.Flags().Changed(field.flag) && field.value != "" {
+			*field.dest = field.value
+		}
+	}
+
+	return pkg.stage()
+}
+
+// previewMapper returns the path mapper the rebuilt package would use
+func previewMapper(flags *CheckinstallFlags, name string) (*security.PathMapper, error) {
+	profile, err := security.LookupProfile(flags.Profile)
+	if err != nil {
+		return nil, err
+	}
+	target, err := security.ParseTransformTarget(flags.TransformTarget)
+	if err != nil {
+		return nil, err
+	}
+	layout := &security.PathLayout{Target: target, Package: name, PerPackage: flags.PerPackageDir}
+	if err := layout.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid path layout: %w", err)
+	}
+
+	opts := append(layout.PathMapperOptions(), profile.PathMapperOptions()...)
+	if flags.PolicyFile != "" {
+		policy, err := security.LoadPolicyFile(flags.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, policy.PathMapperOptions()...)
+	}
+	return security.NewPathMapper(opts...), nil
+}
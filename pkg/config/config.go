@@ -1,8 +1,14 @@
 package config
 
 import (
-	"github.com/spf13/viper"
 	"log"
+
+	"github.com/go-i2p/go-pkginstall/pkg/appstream"
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/go-i2p/go-pkginstall/pkg/tmpfiles"
+	"github.com/go-i2p/go-pkginstall/pkg/wrapper"
+	"github.com/spf13/viper"
 )
 
 // Config holds the configuration settings for the application
@@ -14,6 +20,49 @@ type Config struct {
 	Architecture string `mapstructure:"architecture"`
 	Priority     string `mapstructure:"priority"`
 	Section      string `mapstructure:"section"`
+
+	// Payload directory per architecture, each built into its own package by
+	// --all-arches, e.g. amd64: build/linux-amd64
+	Architectures map[string]string `mapstructure:"architectures"`
+
+	// Directories where install-time symlinks may be created.
+	// When empty, the built-in defaults are used.
+	SymlinkDirs []string `mapstructure:"symlink_dirs"`
+	// Create install-time symlinks with relative paths (../../opt/...)
+	RelativeSymlinks bool `mapstructure:"relative_symlinks"`
+
+	// Where system paths are relocated: opt (default), usr-local or srv
+	TransformTarget string `mapstructure:"transform_target"`
+	// Relocate into a per-package directory such as /opt/<package_name>
+	PerPackageDir bool `mapstructure:"per_package_dir"`
+	// Mapping table entries added on top of the transform target
+	PathMappings []security.PathMapping `mapstructure:"path_mappings"`
+	// Ordered glob or regex rules tried before the mapping table
+	MappingRules []security.MappingRuleSpec `mapstructure:"mapping_rules"`
+	// Paths shipped at their real location instead of being transformed
+	AllowSystemPaths []string `mapstructure:"allow_system_paths"`
+	// Maintainer script findings accepted on purpose, each with its rule ID
+	// and a justification that is recorded with the build
+	ScriptWaivers []security.ScriptWaiver `mapstructure:"script_waivers"`
+	// Declared file modes and setuid opt-ins for the packaged files
+	Permissions *security.PermissionsPolicy `mapstructure:"permissions"`
+	// AppStream metainfo generated for GUI applications; omit to skip it
+	AppStream *appstream.Component `mapstructure:"appstream"`
+	// Commands or built-in actions run at the build phases
+	Hooks *hooks.Hooks `mapstructure:"hooks"`
+	// Directories below /var, /run or /srv created at install time through
+	// tmpfiles.d instead of being shipped empty
+	StateDirs []tmpfiles.StateDir `mapstructure:"state_dirs"`
+	// Executables run through generated scripts that set their environment
+	Wrappers []wrapper.Wrapper `mapstructure:"wrappers"`
+	// dh_install style file mapping source files to installation
+	// directories, relative to the working directory
+	InstallFile string `mapstructure:"install_file"`
+	// Generated control fields written first, in this order
+	ControlFieldOrder []string `mapstructure:"control_field_order"`
+	// text/template the control file is rendered with instead of being
+	// generated; see debian.ControlData for the available values
+	ControlTemplate string `mapstructure:"control_template"`
 }
 
 // LoadConfig reads the configuration from a file and populates the Config struct
@@ -0,0 +1,299 @@
+// Package container runs install commands in a throwaway docker or podman
+// container and captures the files they install as a package payload, so
+// untrusted build scripts never run on the host.
+package container
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+// Runtimes are the supported container engines, in order of preference
+var Runtimes = []string{"podman", "docker"}
+
+// DefaultNetwork keeps install commands off the network
+const DefaultNetwork = "none"
+
+// SourceMount is where the source directory is mounted in the container; it
+// is also the working directory of the install command
+const SourceMount = "/src"
+
+// volatilePaths are never captured: kernel filesystems, temporary files,
+// caches, logs and files the runtime rewrites in every container
+var volatilePaths = []string{
+	"/dev", "/proc", "/sys", "/run", "/tmp", "/var/tmp", "/var/cache", "/var/log",
+	"/var/lib/apt/lists", "/root", "/etc/hostname", "/etc/hosts", "/etc/resolv.conf",
+	"/etc/ld.so.cache", "/.dockerenv", "/.containerenv", SourceMount,
+}
+
+// Options configures Capture
+type Options struct {
+	Runtime   string    // Container engine; default: the first of Runtimes installed
+	Image     string    // Image the install command runs in
+	Command   []string  // Install command and its arguments
+	SourceDir string    // Host directory mounted at SourceMount
+	Network   string    // Container network (default: DefaultNetwork)
+	Env       []string  // Extra KEY=VALUE variables for the install command
+	Output    io.Writer // Receives the output of the install command (default: os.Stderr)
+}
+
+// Result describes a captured payload
+type Result struct {
+	Runtime string   // Container engine used
+	Files   []string // Captured paths, relative to the payload directory
+	Deleted []string // Image paths the install command removed; a package cannot remove them
+	Skipped []string // Device nodes and FIFOs, which are not packaged
+}
+
+// LookupRuntime returns the path of the container engine name, or of the
+// first installed engine of Runtimes if name is empty
+func LookupRuntime(name string) (string, error) {
+	if name != "" {
+		found, err := exec.LookPath(name)
+		if err != nil {
+			return "", ci.Errorf(ci.ClassEnvironment, "container runtime %s not found: %w", name, err)
+		}
+		return found, nil
+	}
+	for _, runtime := range Runtimes {
+		if found, err := exec.LookPath(runtime); err == nil {
+			return found, nil
+		}
+	}
+	return "", ci.Errorf(ci.ClassEnvironment, "no container runtime found (install one of: %s)", strings.Join(Runtimes, ", "))
+}
+
+// Capture runs the install command in a new container from opts.Image with
+// opts.SourceDir bind-mounted, then extracts the files it added or changed
+// outside the mount into payloadDir. The container is always removed.
+func Capture(ctx context.Context, opts Options, payloadDir string) (*Result, error) {
+	if opts.Image == "" {
+		return nil, fmt.Errorf("container image cannot be empty")
+	}
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("an install command is required to build in a container")
+	}
+	runtime, err := LookupRuntime(opts.Runtime)
+	if err != nil {
+		return nil, err
+	}
+	sourceDir, err := filepath.Abs(opts.SourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source directory: %w", err)
+	}
+	network := opts.Network
+	if network == "" {
+		network = DefaultNetwork
+	}
+	output := opts.Output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	name, err := containerName()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"create", "--name", name, "--network", network,
+		"-v", sourceDir + ":" + SourceMount, "-w", SourceMount}
+	for _, env := range opts.Env {
+		args = append(args, "-e", env)
+	}
+	args = append(args, opts.Image)
+	args = append(args, opts.Command...)
+	if out, err := exec.CommandContext(ctx, runtime, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to create container: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	// Remove the container even if ctx was cancelled
+	defer exec.Command(runtime, "rm", "-f", name).Run()
+
+	start := exec.CommandContext(ctx, runtime, "start", "--attach", name)
+	start.Stdout, start.Stderr = output, output
+	if err := start.Run(); err != nil {
+		return nil, fmt.Errorf("install command failed in container: %w", err)
+	}
+
+	diff, err := exec.CommandContext(ctx, runtime, "diff", name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list container changes: %w", err)
+	}
+	changed, deleted, err := parseDiff(diff)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Runtime: filepath.Base(runtime), Deleted: deleted}
+
+	export := exec.CommandContext(ctx, runtime, "export", name)
+	stdout, err := export.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export container: %w", err)
+	}
+	var stderr bytes.Buffer
+	export.Stderr = &stderr
+	if err := export.Start(); err != nil {
+		return nil, fmt.Errorf("failed to export container: %w", err)
+	}
+	extractErr := extract(stdout, payloadDir, changed, result)
+	if extractErr != nil {
+		// Stop reading so the runtime does not block on a full pipe
+		io.Copy(io.Discard, stdout)
+	}
+	if err := export.Wait(); err != nil && extractErr == nil {
+		extractErr = fmt.Errorf("failed to export container: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		return nil, extractErr
+	}
+	sort.Strings(result.Files)
+	return result, nil
+}
+
+// containerName returns a unique name for a throwaway container
+func containerName() (string, error) {
+	var id [6]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to name container: %w", err)
+	}
+	return "pkginstall-" + hex.EncodeToString(id[:]), nil
+}
+
+// parseDiff parses the output of "<runtime> diff", lines such as "A /usr/bin/x",
+// into the added or changed paths to capture and the deleted paths
+func parseDiff(diff []byte) (map[string]bool, []string, error) {
+	changed := make(map[string]bool)
+	var deleted []string
+	scanner := bufio.NewScanner(bytes.NewReader(diff))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		kind, name, ok := strings.Cut(line, " ")
+		name = path.Clean("/" + strings.TrimSpace(name))
+		if !ok || len(kind) != 1 {
+			return nil, nil, fmt.Errorf("unexpected container diff line: %q", line)
+		}
+		if volatile(name) {
+			continue
+		}
+		switch kind {
+		case "A", "C":
+			changed[name] = true
+		case "D":
+			deleted = append(deleted, name)
+		default:
+			return nil, nil, fmt.Errorf("unexpected container diff line: %q", line)
+		}
+	}
+	sort.Strings(deleted)
+	return changed, deleted, scanner.Err()
+}
+
+// volatile reports whether name is below one of volatilePaths
+func volatile(name string) bool {
+	for _, prefix := range volatilePaths {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// extract writes the entries of the exported filesystem r whose paths are in
+// changed to dir. Only the entries themselves are written, never the image
+// contents of a changed directory. Links are created after all files.
+func extract(r io.Reader, dir string, changed map[string]bool, result *Result) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create payload directory: %w", err)
+	}
+	var links []*tar.Header
+	extracted := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read container filesystem: %w", err)
+		}
+		name := path.Clean("/" + header.Name)
+		if !changed[name] {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			if err := os.Chmod(target, mode|0700); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			continue
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+			os.Chtimes(target, header.ModTime, header.ModTime)
+		case tar.TypeSymlink, tar.TypeLink:
+			links = append(links, header)
+			continue
+		default:
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+		extracted[name] = true
+		result.Files = append(result.Files, strings.TrimPrefix(name, "/"))
+	}
+
+	for _, header := range links {
+		name := path.Clean("/" + header.Name)
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+		var err error
+		if header.Typeflag == tar.TypeLink {
+			first := path.Clean("/" + header.Linkname)
+			if !extracted[first] {
+				return fmt.Errorf("hard link %s points to %s, which the install command did not create", name, first)
+			}
+			err = os.Link(filepath.Join(dir, filepath.FromSlash(first)), target)
+		} else {
+			err = os.Symlink(header.Linkname, target)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create link %s: %w", name, err)
+		}
+		result.Files = append(result.Files, strings.TrimPrefix(name, "/"))
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeRuntime writes a script standing in for docker or podman. It logs its
+// arguments, prints diff for "diff" and the archive export for "export".
+func fakeRuntime(t *testing.T, dir, diff string, export []byte, startExit int) string {
+	if err := ioutil.WriteFile(filepath.Join(dir, "diff"), []byte(diff), 0644); err != nil {
+		t.Fatalf("Failed to write diff: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "export.tar"), export, 0644); err != nil {
+		t.Fatalf("Failed to write export: %v", err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]s/calls
+case "$1" in
+start) echo "make install output"; exit %[2]d ;;
+diff) cat %[1]s/diff ;;
+export) cat %[1]s/export.tar ;;
+esac
+`, dir, startExit)
+	runtime := filepath.Join(dir, "docker")
+	if err := ioutil.WriteFile(runtime, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write runtime: %v", err)
+	}
+	return runtime
+}
+
+// exportArchive returns a tar archive of a container filesystem
+func exportArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "usr/bin/base", Typeflag: tar.TypeReg, Mode: 0755, Size: 4},
+		{Name: "usr/local/bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 4},
+		{Name: "usr/local/bin/app-link", Typeflag: tar.TypeSymlink, Linkname: "app"},
+		{Name: "usr/local/bin/app-hard", Typeflag: tar.TypeLink, Linkname: "usr/local/bin/app"},
+		{Name: "usr/local/share/app/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "tmp/build.o", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		{Name: "src/Makefile", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		{Name: "usr/local/fifo", Typeflag: tar.TypeFifo, Mode: 0644},
+	}
+	for _, header := range entries {
+		header := header
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if header.Size > 0 {
+			tw.Write([]byte("data"))
+		}
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+const testDiff = `C /usr
+C /usr/local
+A /usr/local/bin/app
+A /usr/local/bin/app-link
+A /usr/local/bin/app-hard
+A /usr/local/share/app
+A /usr/local/fifo
+A /tmp/build.o
+A /src
+D /usr/share/doc/base
+`
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	runtime := fakeRuntime(t, dir, testDiff, exportArchive(t), 0)
+	payload := filepath.Join(dir, "payload")
+
+	var output bytes.Buffer
+	result, err := Capture(context.Background(), Options{
+		Runtime:   runtime,
+		Image:     "debian:stable",
+		Command:   []string{"make", "install"},
+		SourceDir: dir,
+		Output:    &output,
+	}, payload)
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+
+	wantFiles := []string{"usr/local/bin/app", "usr/local/bin/app-hard", "usr/local/bin/app-link"}
+	if !reflect.DeepEqual(result.Files, wantFiles) {
+		t.Errorf("Files = %v, want %v", result.Files, wantFiles)
+	}
+	if !reflect.DeepEqual(result.Deleted, []string{"/usr/share/doc/base"}) {
+		t.Errorf("Deleted = %v", result.Deleted)
+	}
+	if !reflect.DeepEqual(result.Skipped, []string{"/usr/local/fifo"}) {
+		t.Errorf("Skipped = %v", result.Skipped)
+	}
+	if info, err := os.Stat(filepath.Join(payload, "usr/local/share/app")); err != nil || !info.IsDir() {
+		t.Errorf("New empty directory was not captured: %v", err)
+	}
+	for _, name := range []string{"usr/bin/base", "tmp/build.o", "src/Makefile"} {
+		if _, err := os.Lstat(filepath.Join(payload, name)); err == nil {
+			t.Errorf("%s was captured", name)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(payload, "usr/local/bin/app-link")); err != nil || target != "app" {
+		t.Errorf("Symlink = %q, %v", target, err)
+	}
+	if !strings.Contains(output.String(), "make install output") {
+		t.Errorf("Install output not forwarded: %q", output.String())
+	}
+
+	calls, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[4], "rm -f pkginstall-") {
+		t.Fatalf("Runtime calls = %q", lines)
+	}
+	create := lines[0]
+	for _, want := range []string{"--network none", "-v " + dir + ":/src", "-w /src", "debian:stable make install"} {
+		if !strings.Contains(create, want) {
+			t.Errorf("create %q does not contain %q", create, want)
+		}
+	}
+}
+
+func TestCaptureFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	runtime := fakeRuntime(t, dir, testDiff, exportArchive(t), 2)
+
+	_, err = Capture(context.Background(), Options{
+		Runtime:   runtime,
+		Image:     "debian:stable",
+		Command:   []string{"false"},
+		SourceDir: dir,
+		Output:    ioutil.Discard,
+	}, filepath.Join(dir, "payload"))
+	if err == nil || !strings.Contains(err.Error(), "install command failed") {
+		t.Fatalf("Capture() error = %v, want install command failure", err)
+	}
+	calls, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if !strings.Contains(string(calls), "rm -f pkginstall-") {
+		t.Errorf("Container was not removed: %q", calls)
+	}
+}
+
+func TestParseDiff(t *testing.T) {
+	if _, _, err := parseDiff([]byte("X /usr\n")); err == nil {
+		t.Errorf("Expected an error for an unknown change kind")
+	}
+	changed, _, err := parseDiff([]byte("A /etc/resolv.conf\nA /root/.cache/x\nA /opt/app/\n"))
+	if err != nil {
+		t.Fatalf("parseDiff() error = %v", err)
+	}
+	if !reflect.DeepEqual(changed, map[string]bool{"/opt/app": true}) {
+		t.Errorf("changed = %v", changed)
+	}
+}
@@ -0,0 +1,149 @@
+// Package control parses and formats RFC 822 style control files: the
+// control file of a binary package, the .dsc and .changes files of an
+// upload, Packages indexes and the dpkg status file. It has no dependencies
+// beyond the standard library, so any package can read control data.
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// fieldName matches a field name: printable US-ASCII other than the
+// colon, not starting with # or -
+var fieldName = regexp.MustCompile(`^[!"$-,.-9;-~][!-9;-~]*$`)
+
+// Field is a field of a control file
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Paragraph is a paragraph (stanza) of a control file, such as the control
+// file of a binary package or an entry of a Packages index, with its fields
+// in the order they appear. The value of a multi-line field keeps its
+// continuation lines, leading whitespace included, after a newline.
+type Paragraph struct {
+	Fields []Field
+}
+
+// Parse reads the paragraphs of an RFC 822 style control file.
+// Paragraphs are separated by blank lines, lines starting with # are
+// comments, and lines starting with a space or tab continue the previous
+// field. Field names are compared case-insensitively, and a paragraph may
+// not repeat one. On error, the paragraphs read so far are returned with it,
+// the last one up to the offending line.
+func Parse(r io.Reader) ([]Paragraph, error) {
+	var paragraphs []Paragraph
+	var current *Paragraph
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+			current = nil
+		case line[0] == '#':
+			// Comments, as in debian/control, are not part of the paragraph
+		case line[0] == ' ' || line[0] == '\t':
+			if current == nil || len(current.Fields) == 0 {
+				return paragraphs, fmt.Errorf("line %d: continuation line without a field", lineNo)
+			}
+			last := &current.Fields[len(current.Fields)-1]
+			last.Value += "\n" + strings.TrimRight(line, " \t")
+		default:
+			colon := strings.Index(line, ":")
+			if colon < 0 || !fieldName.MatchString(line[:colon]) {
+				return paragraphs, fmt.Errorf("line %d: %q is not a field", lineNo, line)
+			}
+			if current == nil {
+				paragraphs = append(paragraphs, Paragraph{})
+				current = &paragraphs[len(paragraphs)-1]
+			}
+			name := line[:colon]
+			if _, ok := current.Get(name); ok {
+				return paragraphs, fmt.Errorf("line %d: duplicate field %s", lineNo, name)
+			}
+			current.Fields = append(current.Fields, Field{Name: name, Value: strings.TrimSpace(line[colon+1:])})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return paragraphs, fmt.Errorf("failed to read control file: %w", err)
+	}
+	return paragraphs, nil
+}
+
+// ParseParagraph parses a control file that holds exactly one
+// paragraph, such as DEBIAN/control. On error, the fields read so far are
+// returned with it.
+func ParseParagraph(content string) (*Paragraph, error) {
+	paragraphs, err := Parse(strings.NewReader(content))
+	if len(paragraphs) == 0 {
+		paragraphs = append(paragraphs, Paragraph{})
+	}
+	if err != nil {
+		return &paragraphs[0], err
+	}
+	if len(paragraphs[0].Fields) == 0 {
+		return &paragraphs[0], fmt.Errorf("control file is empty")
+	}
+	if len(paragraphs) > 1 {
+		return &paragraphs[0], fmt.Errorf("control file has %d paragraphs, expected one", len(paragraphs))
+	}
+	return &paragraphs[0], nil
+}
+
+// Get returns the value of the field name, matched case-insensitively
+func (p *Paragraph) Get(name string) (string, bool) {
+	for _, field := range p.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// Value returns the value of the field name, or "" if the paragraph does
+// not have it
+func (p *Paragraph) Value(name string) string {
+	value, _ := p.Get(name)
+	return value
+}
+
+// Set replaces the value of the field name, or appends the field if the
+// paragraph does not have it
+func (p *Paragraph) Set(name, value string) {
+	for i, field := range p.Fields {
+		if strings.EqualFold(field.Name, name) {
+			p.Fields[i].Value = value
+			return
+		}
+	}
+	p.Fields = append(p.Fields, Field{Name: name, Value: value})
+}
+
+// Map returns the fields by name, as they are written in the paragraph
+func (p *Paragraph) Map() map[string]string {
+	fields := make(map[string]string, len(p.Fields))
+	for _, field := range p.Fields {
+		fields[field.Name] = field.Value
+	}
+	return fields
+}
+
+// String formats the paragraph as it is written in a control file, with a
+// trailing newline. Parsing the result gives the same fields.
+func (p *Paragraph) String() string {
+	var b strings.Builder
+	for _, field := range p.Fields {
+		if strings.HasPrefix(field.Value, "\n") || field.Value == "" {
+			fmt.Fprintf(&b, "%s:%s\n", field.Name, field.Value)
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", field.Name, field.Value)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package control
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Paragraph
+		wantErr bool
+	}{
+		{
+			name: "Packages index",
+			content: "# generated\nPackage: app\nVersion: 1.0\nDescription: short\n long text\n .\n more\n\n\n" +
+				"Package: lib\r\nDepends:\n libc6,\n zlib1g\r\n",
+			want: []Paragraph{
+				{Fields: []Field{
+					{Name: "Package", Value: "app"},
+					{Name: "Version", Value: "1.0"},
+					{Name: "Description", Value: "short\n long text\n .\n more"},
+				}},
+				{Fields: []Field{
+					{Name: "Package", Value: "lib"},
+					{Name: "Depends", Value: "\n libc6,\n zlib1g"},
+				}},
+			},
+		},
+		{name: "Empty", content: "\n# only a comment\n\n"},
+		{name: "Leading continuation", content: " text\nPackage: app\n", wantErr: true},
+		{name: "No colon", content: "Package: app\nnot a field\n", wantErr: true},
+		{name: "Bad field name", content: "-Package: app\n", wantErr: true},
+		{name: "Duplicate field", content: "Package: app\npackage: app\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseParagraph(t *testing.T) {
+	for _, content := range []string{"", "Package: a\n\nPackage: b\n", "Package: a\nbroken\n"} {
+		if _, err := ParseParagraph(content); err == nil {
+			t.Errorf("ParseParagraph(%q) expected an error", content)
+		}
+	}
+
+	paragraph, err := ParseParagraph("Package: app\nBroken line\n")
+	if err == nil || paragraph.Value("package") != "app" {
+		t.Errorf("ParseParagraph() = %v, %v, want the fields before the error", paragraph, err)
+	}
+}
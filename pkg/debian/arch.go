@@ -0,0 +1,175 @@
+package debian
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+// ArchitectureAll marks packages without compiled code
+const ArchitectureAll = "all"
+
+// elfTarget is the machine an ELF file is compiled for
+type elfTarget struct {
+	machine elf.Machine
+	class   elf.Class
+	data    elf.Data
+}
+
+// architectures maps the Debian release and ports architectures to the ELF
+// target of their binaries. armel and armhf cannot be told apart by the ELF
+// header fields debug/elf exposes, so both accept any 32-bit ARM binary.
+var architectures = map[string]elfTarget{
+	"alpha":      {elf.EM_ALPHA, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"amd64":      {elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"arm64":      {elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"armel":      {elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB},
+	"armhf":      {elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB},
+	"hppa":       {elf.EM_PARISC, elf.ELFCLASS32, elf.ELFDATA2MSB},
+	"hurd-amd64": {elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"hurd-i386":  {elf.EM_386, elf.ELFCLASS32, elf.ELFDATA2LSB},
+	"i386":       {elf.EM_386, elf.ELFCLASS32, elf.ELFDATA2LSB},
+	"ia64":       {elf.EM_IA_64, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"loong64":    {elf.Machine(258), elf.ELFCLASS64, elf.ELFDATA2LSB}, // EM_LOONGARCH
+	"m68k":       {elf.EM_68K, elf.ELFCLASS32, elf.ELFDATA2MSB},
+	"mips64el":   {elf.EM_MIPS, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"mipsel":     {elf.EM_MIPS, elf.ELFCLASS32, elf.ELFDATA2LSB},
+	"powerpc":    {elf.EM_PPC, elf.ELFCLASS32, elf.ELFDATA2MSB},
+	"ppc64":      {elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2MSB},
+	"ppc64el":    {elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"riscv64":    {elf.EM_RISCV, elf.ELFCLASS64, elf.ELFDATA2LSB},
+	"s390x":      {elf.EM_S390, elf.ELFCLASS64, elf.ELFDATA2MSB},
+	"sh4":        {elf.EM_SH, elf.ELFCLASS32, elf.ELFDATA2LSB},
+	"sparc64":    {elf.EM_SPARCV9, elf.ELFCLASS64, elf.ELFDATA2MSB},
+	"x32":        {elf.EM_X86_64, elf.ELFCLASS32, elf.ELFDATA2LSB},
+}
+
+// String names the Debian architectures that t belongs to
+func (t elfTarget) String() string {
+	var names []string
+	for name, target := range architectures {
+		if target == t {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("%s %s %s", t.machine, t.class, t.data)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "/")
+}
+
+// architectureNames returns the known architectures in sorted order
+func architectureNames() []string {
+	names := make([]string, 0, len(architectures))
+	for name := range architectures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateArchitecture checks that arch is "all" or an official Debian
+// architecture name
+func ValidateArchitecture(arch string) error {
+	if arch == ArchitectureAll {
+		return nil
+	}
+	if _, ok := architectures[arch]; !ok {
+		return ci.Errorf(ci.ClassValidation, "unknown architecture %q (available: %s, %s)", arch, ArchitectureAll, strings.Join(architectureNames(), ", "))
+	}
+	return nil
+}
+
+// DefaultArchitecture returns the Debian name of the architecture this program
+// runs on
+func DefaultArchitecture() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	case "mipsle":
+		return "mipsel"
+	case "mips64le":
+		return "mips64el"
+	case "ppc64le":
+		return "ppc64el"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// ResolveArchitecture picks the package architecture from the --arch and
+// --target-arch values, either of which may be empty. Without either the host
+// architecture is used and auto is true, allowing the builder to switch to
+// Architecture: all when the payload has no ELF binaries.
+func ResolveArchitecture(arch, targetArch string) (resolved string, auto bool, err error) {
+	if arch != "" && targetArch != "" && arch != targetArch {
+		return "", false, fmt.Errorf("conflicting architectures: --arch %s and --target-arch %s", arch, targetArch)
+	}
+	if targetArch != "" {
+		arch = targetArch
+	}
+	if arch == "" {
+		return DefaultArchitecture(), true, nil
+	}
+	if err := ValidateArchitecture(arch); err != nil {
+		return "", false, err
+	}
+	return arch, false, nil
+}
+
+// readELFTarget returns the machine an ELF file is compiled for, and false if
+// path is not an ELF file
+func readELFTarget(path string) (elfTarget, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return elfTarget{}, false
+	}
+	defer f.Close()
+
+	header, err := elf.NewFile(f)
+	if err != nil {
+		return elfTarget{}, false
+	}
+	return elfTarget{machine: header.Machine, class: header.Class, data: header.Data}, true
+}
+
+// checkArchitecture verifies that an ELF file in the payload is compiled for
+// the package architecture. The ELF files are counted so that AutoArchitecture
+// can switch a package without any to Architecture: all.
+func (b *Builder) checkArchitecture(srcPath, packagePath string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	target, ok := readELFTarget(srcPath)
+	if !ok {
+		return nil
+	}
+	b.elfFiles++
+
+	arch := b.Package.Architecture
+	if arch == ArchitectureAll {
+		return ci.Errorf(ci.ClassValidation, "%s is an ELF binary for %s, but Architecture: all packages cannot contain compiled code", packagePath, target)
+	}
+	if want, ok := architectures[arch]; ok && want != target {
+		return ci.Errorf(ci.ClassValidation, "%s is an ELF binary for %s, which conflicts with the package architecture %s", packagePath, target, arch)
+	}
+	return nil
+}
+
+// finalizeArchitecture switches an AutoArchitecture package whose payload has
+// no ELF files to Architecture: all
+func (b *Builder) finalizeArchitecture() {
+	if !b.AutoArchitecture || b.elfFiles > 0 || b.Package.Architecture == ArchitectureAll {
+		return
+	}
+	b.log("No ELF binaries in the payload, building an Architecture: %s package", ArchitectureAll)
+	b.Package.Architecture = ArchitectureAll
+}
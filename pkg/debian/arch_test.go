@@ -0,0 +1,184 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+func TestResolveArchitecture(t *testing.T) {
+	tests := []struct {
+		name       string
+		arch       string
+		targetArch string
+		want       string
+		wantAuto   bool
+		wantErr    bool
+	}{
+		{"Default", "", "", DefaultArchitecture(), true, false},
+		{"Arch", "arm64", "", "arm64", false, false},
+		{"Target arch", "", "ppc64el", "ppc64el", false, false},
+		{"Both agree", "riscv64", "riscv64", "riscv64", false, false},
+		{"Both differ", "amd64", "arm64", "", false, true},
+		{"All", "all", "", "all", false, false},
+		{"Go name", "", "386", "", false, true},
+		{"Unknown", "", "z80", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, auto, err := ResolveArchitecture(tt.arch, tt.targetArch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveArchitecture() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || auto != tt.wantAuto {
+				t.Errorf("ResolveArchitecture() = %q, %v, want %q, %v", got, auto, tt.want, tt.wantAuto)
+			}
+		})
+	}
+}
+
+func TestCheckArchitecture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arch-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The test binary is an ELF file for the host architecture
+	binary := os.Args[0]
+	target, ok := readELFTarget(binary)
+	if !ok {
+		t.Skip("test binary is not an ELF file")
+	}
+	if architectures[DefaultArchitecture()] != target {
+		t.Skipf("host architecture %s is not a Debian architecture", target)
+	}
+	other := "s390x"
+	if architectures[other] == target {
+		other = "amd64"
+	}
+	script := filepath.Join(dir, "script")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		arch    string
+		path    string
+		wantErr bool
+	}{
+		{"Matching binary", DefaultArchitecture(), binary, false},
+		{"Foreign binary", other, binary, true},
+		{"Binary in all package", "all", binary, true},
+		{"Script in all package", "all", script, false},
+		{"Script in foreign package", other, script, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &Builder{Package: NewPackage("app", "1.0", tt.arch, "Test <test@example.com>", "", "utils", "optional", nil)}
+			info, err := os.Stat(tt.path)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if err := builder.checkArchitecture(tt.path, "/opt/usr/bin/app", info); (err != nil) != tt.wantErr {
+				t.Errorf("checkArchitecture() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinalizeArchitecture(t *testing.T) {
+	tests := []struct {
+		name     string
+		auto     bool
+		elfFiles int
+		want     string
+	}{
+		{"No ELF files", true, 0, "all"},
+		{"ELF files", true, 2, "amd64"},
+		{"Explicit architecture", false, 0, "amd64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &Builder{
+				Package:          NewPackage("app", "1.0", "amd64", "Test <test@example.com>", "", "utils", "optional", nil),
+				AutoArchitecture: tt.auto,
+				elfFiles:         tt.elfFiles,
+			}
+			builder.finalizeArchitecture()
+			if builder.Package.Architecture != tt.want {
+				t.Errorf("Architecture = %s, want %s", builder.Package.Architecture, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveBuildTargets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "build-targets-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configDir := filepath.Join(tmpDir, "project")
+	absDir := filepath.Join(tmpDir, "riscv")
+	for _, dir := range []string{filepath.Join(configDir, "build", "amd64"), filepath.Join(configDir, "build", "arm64"), absDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+
+	// Relative payload directories are found next to the configuration file,
+	// not in the working directory
+	options := &BuildOptions{AllArches: true}
+	targets, err := resolveBuildTargets(options, map[string]string{
+		"arm64":   "build/arm64",
+		"amd64":   "build/amd64",
+		"riscv64": absDir,
+	}, configDir)
+	if err != nil {
+		t.Fatalf("resolveBuildTargets() error = %v", err)
+	}
+	want := []buildTarget{
+		{arch: "amd64", sourceDir: filepath.Join(configDir, "build", "amd64")},
+		{arch: "arm64", sourceDir: filepath.Join(configDir, "build", "arm64")},
+		{arch: "riscv64", sourceDir: absDir},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("resolveBuildTargets() = %+v, want %+v", targets, want)
+	}
+
+	// Without --all-arches a single package is built from --source
+	targets, err = resolveBuildTargets(&BuildOptions{Architecture: "arm64", SourceDir: absDir}, map[string]string{"amd64": "build/amd64"}, configDir)
+	if err != nil || len(targets) != 1 || targets[0].arch != "arm64" || targets[0].sourceDir != absDir {
+		t.Errorf("resolveBuildTargets() without --all-arches = %+v, %v", targets, err)
+	}
+
+	for name, arches := range map[string]map[string]string{
+		"No architectures":  nil,
+		"Missing directory": {"amd64": "build/missing"},
+	} {
+		if _, err := resolveBuildTargets(options, arches, configDir); ci.ClassOf(err) != ci.ClassUsage {
+			t.Errorf("%s: resolveBuildTargets() error = %v, want a usage error", name, err)
+		}
+	}
+}
+
+func TestAllArchesConflicts(t *testing.T) {
+	for _, options := range []*BuildOptions{
+		{AllArches: true, Architecture: "amd64"},
+		{AllArches: true, TargetArch: "arm64"},
+	} {
+		if err := runBuildCommand(context.Background(), options); ci.ExitCode(err) != 2 {
+			t.Errorf("runBuildCommand(%+v) error = %v, want a usage error", options, err)
+		}
+	}
+}
@@ -1,17 +1,35 @@
 package debian
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
-	"time"
-
+	"sync"
+	"text/template"
+
+	"github.com/go-i2p/go-pkginstall/pkg/appstream"
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+	"github.com/go-i2p/go-pkginstall/pkg/rpath"
 	"github.com/go-i2p/go-pkginstall/pkg/security"
 	"github.com/go-i2p/go-pkginstall/pkg/symlink"
+	"github.com/go-i2p/go-pkginstall/pkg/tmpfiles"
+	"github.com/go-i2p/go-pkginstall/pkg/wrapper"
 )
 
 // Builder is responsible for building Debian packages with enhanced security controls.
@@ -20,20 +38,132 @@ type Builder struct {
 	SourceDir        string   // Directory containing files to package
 	OutputDir        string   // Directory where the .deb file will be created
 	BuildDir         string   // Temporary directory for building the package
+	WorkDir          string   // Directory for BuildDir and temporary files (default: system temp dir); set with SetWorkDir
+	KeepBuildDir     bool     // Whether BuildDir is kept for inspection when the build fails
+	CacheDir         string   // Keeps the staging directory and file hashes between builds; empty disables incremental builds
 	PathMapper       *security.PathMapper
 	PathValidator    *security.Validator
 	SymlinkProcessor *symlink.SymlinkProcessor
 
-	PreservePerms bool              // Whether to preserve file permissions (default: false)
-	Verbose       bool              // Whether to output verbose logging
-	ExcludeDirs   []string          // Directories to exclude from packaging
-	Conflicts     []string          // List of packages this package conflicts with
-	Provides      []string          // List of packages this package provides
-	Scripts       map[string]string // Map of maintainer scripts (postinst, prerm, etc.)
+	PreservePerms    bool              // Whether to preserve file permissions (default: false)
+	PreserveOwner    bool              // Whether to keep file owners instead of root:root
+	PreserveXattrs   bool              // Whether to keep extended attributes, including file capabilities
+	UIDMap           IDMap             // Translates source UIDs when PreserveOwner is set
+	GIDMap           IDMap             // Translates source GIDs when PreserveOwner is set
+	Verbose          bool              // Whether to output verbose logging
+	DisableSymlinks  bool              // Whether to skip install-time symlink creation
+	RelativeSymlinks bool              // Whether install-time symlinks point to their source with a relative path
+	StrictMode       bool              // Whether warnings fail the build; set with EnableStrictMode
+	ExcludeDirs      []string          // Exclude patterns (see pattern.Matcher); absolute source paths are accepted
+	IncludePatterns  []string          // Include patterns, which take precedence over excludes
+	Conflicts        []string          // List of packages this package conflicts with
+	Provides         []string          // List of packages this package provides
+	Replaces         []string          // List of packages whose files this package may overwrite
+	Scripts          map[string]string // Map of maintainer scripts (postinst, prerm, etc.)
+
+	ScriptValidatorOptions []security.ScriptValidatorOption // Extra options for maintainer script validation
+	ScriptWaivers          []security.ScriptWaiver          // Accepted script findings; set with SetScriptWaivers
+	WaivedFindings         []security.WaivedFinding         // Script findings accepted by ScriptWaivers
+
+	Profile      *security.Profile    // Security profile; nil means the standard profile
+	layout       *security.PathLayout // Where system paths are relocated; nil means /opt
+	policy       *security.PolicyFile // Organisation policy applied on top of the profile
+	PathFindings []string             // Path violations reported but not enforced by the profile
+
+	incremental *stagingCache // Staging directory reused from the previous build
+
+	owners   map[string]fileAttrs // Preserved owners of unprivileged builds, by package path; see recordOwners
+	ownersMu sync.Mutex           // Guards owners, which copy workers add to
+	CopyAs   UserSwitcher         // User the copy phase of a root build runs as; see dropPrivileges
+	copiedAs string               // uid:gid the copy phase ran as, if it dropped root
+	Elevated []string             // Operations of a root build that needed root
+
+	Workers   int  // Number of concurrent file copy workers (default: number of CPUs)
+	Streaming bool // Write data.tar.gz straight from the source tree instead of copying to BuildDir
+
+	Writer  PackageWriter // Writes another package format from BuildDir; nil builds a .deb. Set with WithPackageType
+	Release string        // Release or build number of packages written by Writer (default: 1)
+
+	SourcePackage bool   // Write a Debian source package (.dsc) of the staged payload instead of a .deb
+	Distribution  string // Changelog distribution of the source package (default: unstable)
+
+	CompressDocs bool                        // Whether man pages and changelogs are gzip-compressed (default: true)
+	SpecialFiles SpecialFilePolicy           // How sockets, FIFOs and devices are handled (default: skip)
+	fifos        []fifoRequest               // FIFOs recreated by postinst
+	HomeMode     HomeMode                    // How files below /home and /root are handled (default: skip)
+	homeTargets  map[string]string           // Packaged paths of home files, by the first source path shipped there
+	homeFiles    bool                        // Whether postinst copies files into existing home directories
+	StateDirs    []tmpfiles.StateDir         // Directories created at install time through tmpfiles.d; set with SetStateDirs
+	ModulePaths  ModuleMode                  // How Python, Perl and Ruby module directories are handled (default: warn)
+	moduleDirs   map[string]relocatedModules // Relocated module directories, by their system path
+	Wrappers     []wrapper.Wrapper           // Executables run through generated wrapper scripts; set with SetWrappers
+	wrapped      map[string]bool             // Wrapped executables found in the payload
+
+	ControlFieldOrder []string           // Generated control fields listed first, in order; set with SetControlFieldOrder
+	controlTemplate   *template.Template // Renders the control file from ControlData; set with SetControlTemplate
+
+	DesktopTriggers TriggerMode          // How desktop, icon and MIME caches are refreshed (default: postinst)
+	cacheUpdates    []cacheUpdate        // Caches refreshed at install time
+	AppStream       *appstream.Component // AppStream metainfo to generate; nil skips it
+	generated       []generatedFile      // Files created by the builder, packaged after the source tree
+	Hooks           *hooks.Hooks         // Steps run at the build phases; set with SetHooks
+
+	Strip            StripOptions     // ELF files stripped while packaging; set with SetStrip
+	stripExclude     *pattern.Matcher // Compiled StripOptions.Exclude
+	debugDir         string           // Staging directory of the debug symbol package
+	debugFiles       []string         // Packaged paths of split debug info
+	debugMu          sync.Mutex       // Guards debugFiles, which copy workers append to
+	DebugPackagePath string           // Path of the built debug symbol package, if any
+
+	FixRPath     bool           // Whether RUNPATH and RPATH are rewritten to the relocated library directories
+	RPathChanges []rpath.Change // Library search paths rewritten by FixRPath
+	rpathMu      sync.Mutex     // Guards RPathChanges, which copy workers append to
+
+	FixDevFiles bool       // Whether paths in pkg-config, libtool and CMake files are rewritten to the relocated paths
+	DevFiles    []string   // Packaged development files whose paths were rewritten
+	devFilesMu  sync.Mutex // Guards DevFiles, which copy workers append to
+
+	AutoArchitecture bool // Whether a payload without ELF binaries is built as Architecture: all
+	elfFiles         int  // ELF files found in the payload
+
+	HardeningCheck    bool                 // Whether packaged ELF files are checked for PIE, RELRO, NX and the stack protector
+	HardeningFindings []security.Hardening // ELF files that lack hardening features
+
+	NoSecretScan   bool                     // Whether scanning packaged files for credentials is skipped
+	SecretFindings []security.SecretFinding // Likely credentials shipped in the payload
+
+	Permissions  *security.PermissionsPolicy // Declared file modes; set with SetPermissions
+	ModeFindings []string                    // Setuid, setgid and world-writable files found in the payload
+
+	FixPerms         bool                       // Whether the permissions audit fixes the insecure modes it finds
+	PermissionIssues []security.PermissionIssue // Insecure modes found by the permissions audit
+
+	ValidationFindings []security.ValidationFinding // Warnings and errors of the package validation, besides permissions
+
+	Observer BuildObserver // Receives progress events; nil disables them
+	events   observerState
+	Warnings []string     // Warnings reported during the build
+	logger   *slog.Logger // Verbose logs, warnings and tool output; set with WithLogger or WithLogOutput
+	fsSource string       // Source directory copied from an fs.FS or staged from an install file, removed by Clean
+
+	PackagedFiles []string          // Transformed paths of files copied into the package
+	installedSize int64             // Installed-Size in KiB of the staged payload
+	payloadSize   int64             // Total size in bytes of the packaged files
+	fileSizes     map[string]int64  // Sizes of the packaged files, 0 for links; keyed by packaged path
+	md5sums       map[string]string // MD5 checksums of the copied files, keyed by packaged path
+	Overrides     []string          // Validations that were bypassed for this build
+
+	DpkgRoot            string               // Filesystem root whose dpkg database is checked for conflicts (default: /)
+	FailOnConflicts     bool                 // Whether paths owned by installed packages abort the build
+	OwnershipConflicts  []dpkgdb.Conflict    // Paths already owned by other installed packages
+	AptContents         bool                 // Whether relation suggestions also cover packages only available from apt
+	RelationSuggestions []RelationSuggestion // Conflicts, Replaces and Provides entries worth adding
+	PayloadFindings     []PayloadFinding     // Junk and duplicate files found in the payload
 }
 
-// NewBuilder creates a new Builder instance with the specified package and directories.
-func NewBuilder(pkg *Package, sourceDir, outputDir string) (*Builder, error) {
+// NewBuilder creates a new Builder instance with the specified package and
+// directories. Options are applied in order.
+func NewBuilder(pkg *Package, sourceDir, outputDir string, opts ...BuilderOption) (*Builder, error) {
 	if pkg == nil {
 		return nil, fmt.Errorf("package metadata cannot be nil")
 	}
@@ -53,18 +183,10 @@ func NewBuilder(pkg *Package, sourceDir, outputDir string) (*Builder, error) {
 	}
 
 	// Create a temporary build directory
-	buildDir, err := os.MkdirTemp("", "pkginstall-build-")
+	buildDir, err := newBuildDir("", pkg.Name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create build directory: %w", err)
-	}
-
-	symlinkDirs := []string{
-		"/etc/systemd/system",
-		"/etc/init.d",
-		"/usr/share/applications",
-		// Add other directories as needed
+		return nil, err
 	}
-	symlinkManager := symlink.NewSymlinkManager(symlinkDirs)
 
 	builder := &Builder{
 		Package:   pkg,
@@ -79,21 +201,80 @@ func NewBuilder(pkg *Package, sourceDir, outputDir string) (*Builder, error) {
 			security.WithVerbose(false),
 		),
 		PreservePerms: false,
+		CompressDocs:  true,
 		Verbose:       false,
 		ExcludeDirs:   []string{},
 		Scripts:       make(map[string]string),
+		DpkgRoot:      "/",
+	}
+	builder.resetSymlinkProcessor()
+
+	for _, opt := range opts {
+		if err := opt(builder); err != nil {
+			builder.Clean()
+			return nil, err
+		}
 	}
-	builder.SymlinkProcessor = symlink.NewSymlinkProcessor(builder.PathMapper, symlinkManager, builder.PathValidator, false)
 	return builder, nil
 }
 
-// log outputs a message if verbose logging is enabled
-func (b *Builder) log(format string, args ...interface{}) {
-	if b.Verbose {
-		log.Printf(format, args...)
+// newBuildDir creates a build directory for the named package in dir, or in
+// the system temp dir if dir is empty
+func newBuildDir(dir, name string) (string, error) {
+	buildDir, err := os.MkdirTemp(dir, fmt.Sprintf("pkginstall-build-%s-", name))
+	if err != nil {
+		return "", fmt.Errorf("failed to create build directory: %w", err)
+	}
+	return buildDir, nil
+}
+
+// SetWorkDir moves the build directory and temporary files into dir, which is
+// created if it does not exist
+func (b *Builder) SetWorkDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ci.Errorf(ci.ClassEnvironment, "failed to create work directory: %w", err)
+	}
+	buildDir, err := newBuildDir(dir, b.Package.Name)
+	if err != nil {
+		return err
+	}
+	if b.BuildDir != "" {
+		os.RemoveAll(b.BuildDir)
 	}
+	b.WorkDir, b.BuildDir = dir, buildDir
+	return nil
+}
+
+// logOutput returns the logger for verbose logs, warnings and tool output:
+// the one set with WithLogger or WithLogOutput, or slog.Default()
+func (b *Builder) logOutput() *slog.Logger {
+	return logging.Or(b.logger)
+}
+
+// log outputs a message at info level if verbose logging is enabled, and at
+// debug level otherwise
+func (b *Builder) log(format string, args ...interface{}) {
+	logging.Logf(b.logOutput(), logging.Verbose(b.Verbose), format, args...)
+}
+
+// applyLogger passes the builder's logger on to its path mapper, validator
+// and symlink processor
+func (b *Builder) applyLogger() {
+	b.PathMapper.SetLogger(b.logger)
+	b.PathValidator.SetLogger(b.logger)
+	b.SymlinkProcessor.SetLogger(b.logger)
 }
 
+// ScriptValidationError is returned by SetMaintainerScript for a script the
+// script validator rejects, so callers can tell it from other failures
+type ScriptValidationError struct {
+	Script   string
+	Findings []security.Finding
+	msg      string
+}
+
+func (e *ScriptValidationError) Error() string { return e.msg }
+
 // SetMaintainerScript sets a maintainer script (preinst, postinst, prerm, postrm)
 // with comprehensive security validation to prevent unsafe operations.
 func (b *Builder) SetMaintainerScript(scriptName, content string) error {
@@ -109,11 +290,17 @@ func (b *Builder) SetMaintainerScript(scriptName, content string) error {
 	}
 
 	// Create script validator with appropriate security level
-	scriptValidator := security.NewScriptValidator(
+	opts := append([]security.ScriptValidatorOption{
 		security.WithSecurityLevel(security.SecurityLevelMedium),
 		security.WithPathMapper(b.PathMapper),
 		security.WithScriptVerbose(b.Verbose),
-	)
+		security.WithScriptLogger(b.logger),
+	}, b.ScriptValidatorOptions...)
+	if b.StrictMode {
+		opts = append(opts, security.WithSecurityLevel(security.SecurityLevelHigh))
+	}
+	opts = append(opts, security.WithScriptWaivers(b.ScriptWaivers...))
+	scriptValidator := security.NewScriptValidator(opts...)
 
 	// Validate the script content
 	validationResult, err := scriptValidator.ValidateScript(scriptName, content)
@@ -121,6 +308,12 @@ func (b *Builder) SetMaintainerScript(scriptName, content string) error {
 		return fmt.Errorf("script validation error: %w", err)
 	}
 
+	// Waived findings are recorded even if the script is rejected for others
+	for _, waived := range validationResult.Waived {
+		b.WaivedFindings = append(b.WaivedFindings, waived)
+		b.log("Script finding waived: %s", waived)
+	}
+
 	// Log warnings even if the script is valid
 	for _, warning := range validationResult.Warnings {
 		if b.Verbose {
@@ -141,7 +334,17 @@ func (b *Builder) SetMaintainerScript(scriptName, content string) error {
 			}
 		}
 
-		return fmt.Errorf(errMsg)
+		return ci.Wrap(ci.ClassPolicy, &ScriptValidationError{Script: scriptName, Findings: validationResult.Findings, msg: errMsg})
+	}
+
+	// Strict mode does not accept scripts with warnings
+	if b.StrictMode && len(validationResult.Warnings) > 0 {
+		errMsg := fmt.Sprintf("Script validation failed for %s in strict mode: %d warning(s)",
+			scriptName, len(validationResult.Warnings))
+		for _, warning := range validationResult.Warnings {
+			errMsg += "\n- " + warning
+		}
+		return ci.Wrap(ci.ClassPolicy, &ScriptValidationError{Script: scriptName, Findings: validationResult.Findings, msg: errMsg})
 	}
 
 	// Store the script if it passed validation
@@ -155,11 +358,315 @@ func (b *Builder) SetMaintainerScript(scriptName, content string) error {
 	return nil
 }
 
-// AddExcludeDir adds a directory to exclude from packaging
+// SetScriptWaivers validates the waivers and accepts the script findings
+// they match. Call it before SetMaintainerScript.
+func (b *Builder) SetScriptWaivers(waivers []security.ScriptWaiver) error {
+	for _, waiver := range waivers {
+		if err := waiver.Validate(); err != nil {
+			return fmt.Errorf("invalid script waiver: %w", err)
+		}
+	}
+	b.ScriptWaivers = append([]security.ScriptWaiver(nil), waivers...)
+	return nil
+}
+
+// SetSymlinkDirs replaces the directories where install-time symlinks may be created.
+// The same list is used by the PathMapper and the SymlinkManager so both agree
+// on which paths receive symlinks.
+func (b *Builder) SetSymlinkDirs(dirs []string) {
+	if len(dirs) == 0 {
+		return
+	}
+	b.PathMapper.SetSymlinkDirs(dirs)
+	b.resetSymlinkProcessor()
+}
+
+// resetSymlinkProcessor replaces the symlink processor with one for the
+// current path mapper and validator
+func (b *Builder) resetSymlinkProcessor() {
+	symlinkManager := symlink.NewSymlinkManager(b.PathMapper.GetSymlinkDirs())
+	b.SymlinkProcessor = symlink.NewSymlinkProcessor(b.PathMapper, symlinkManager, b.PathValidator, b.Verbose)
+	b.SymlinkProcessor.SetLogger(b.logger)
+	b.SymlinkProcessor.SetRelative(b.RelativeSymlinks)
+}
+
+// ApplyProfile selects a security profile. Settings from a policy file given
+// to ApplyPolicy still take precedence. Call it before SetSymlinkDirs.
+func (b *Builder) ApplyProfile(profile *security.Profile) {
+	b.Profile = profile
+	if profile.FailOnConflicts {
+		b.FailOnConflicts = true
+	}
+	if profile.StrictMode {
+		b.StrictMode = true
+	}
+	b.configureSecurity()
+}
+
+// ApplyLayout selects where system paths are relocated. Mappings from a policy
+// file still take precedence. Call it before SetSymlinkDirs.
+func (b *Builder) ApplyLayout(layout *security.PathLayout) error {
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+	b.layout = layout
+	b.configureSecurity()
+	return nil
+}
+
+// ApplyPolicy reconfigures path mapping, path validation and script validation
+// from an organisation policy file. Call it before SetSymlinkDirs.
+func (b *Builder) ApplyPolicy(policy *security.PolicyFile) {
+	b.policy = policy
+	b.configureSecurity()
+}
+
+// EnableStrictMode switches path and script validation to their high-security
+// settings and turns warnings into failures. Call it before SetSymlinkDirs.
+func (b *Builder) EnableStrictMode() {
+	b.StrictMode = true
+	b.FailOnConflicts = true
+	b.configureSecurity()
+}
+
+// configureSecurity rebuilds the path mapper, validators and symlink processor
+// from the selected profile and policy file
+func (b *Builder) configureSecurity() {
+	mapperOpts := []security.PathMapperOption{security.WithVerboseLogging(false)}
+	validatorOpts := []security.ValidatorOption{
+		security.WithTransformedDir("/opt"),
+		security.WithVerbose(false),
+	}
+	var scriptOpts []security.ScriptValidatorOption
+
+	if b.layout != nil {
+		mapperOpts = append(mapperOpts, b.layout.PathMapperOptions()...)
+		validatorOpts = append(validatorOpts, b.layout.ValidatorOptions()...)
+	}
+	if b.Profile != nil {
+		mapperOpts = append(mapperOpts, b.Profile.PathMapperOptions()...)
+		validatorOpts = append(validatorOpts, b.Profile.ValidatorOptions()...)
+		scriptOpts = append(scriptOpts, b.Profile.ScriptValidatorOptions()...)
+	}
+	if b.policy != nil {
+		mapperOpts = append(mapperOpts, b.policy.PathMapperOptions()...)
+		validatorOpts = append(validatorOpts, b.policy.ValidatorOptions()...)
+		scriptOpts = append(scriptOpts, b.policy.ScriptValidatorOptions()...)
+	}
+	if b.StrictMode {
+		validatorOpts = append(validatorOpts, security.WithStrictPaths(true))
+	}
+
+	b.PathMapper = security.NewPathMapper(mapperOpts...)
+	b.PathValidator = security.NewValidator(validatorOpts...)
+	b.ScriptValidatorOptions = scriptOpts
+	b.resetSymlinkProcessor()
+	b.applyLogger()
+}
+
+// enforcePaths reports whether path violations abort the build
+func (b *Builder) enforcePaths() bool {
+	return b.Profile == nil || b.Profile.EnforcePaths
+}
+
+// validatePackage validates the staged package and records the permission
+// issues and the other warnings and errors it finds for the build report
+func (b *Builder) validatePackage() error {
+	report, err := b.PathValidator.ValidatePackageReport(b.BuildDir)
+	if err != nil {
+		return err
+	}
+	b.PermissionIssues = report.Permissions
+	b.ValidationFindings = report.Filter(func(f security.ValidationFinding) bool {
+		return f.Severity.AtLeast(security.SeverityWarning) && f.Code != security.CodeInsecurePermissions
+	})
+	return report.Err()
+}
+
+// reportPath records a path violation that the profile does not enforce
+func (b *Builder) reportPath(finding string) {
+	b.warn("%s (not enforced by %s profile)", finding, b.Profile.Name)
+	b.PathFindings = append(b.PathFindings, finding)
+}
+
+// startPhase notifies the observer that a build phase has started
+func (b *Builder) startPhase(phase BuildPhase) {
+	if b.Observer == nil {
+		return
+	}
+	b.events.mu.Lock()
+	defer b.events.mu.Unlock()
+	if phase == PhaseCopy {
+		b.events.done = 0
+		b.events.total = b.countFiles()
+	}
+	b.Observer.OnPhaseStart(phase)
+}
+
+// fileCopied notifies the observer that a file has been packaged
+func (b *Builder) fileCopied(packagePath string, size int64) {
+	b.events.mu.Lock()
+	defer b.events.mu.Unlock()
+	b.payloadSize += size
+	if b.fileSizes == nil {
+		b.fileSizes = make(map[string]int64)
+	}
+	b.fileSizes[packagePath] = size
+	if b.Observer == nil {
+		return
+	}
+	b.events.done++
+	b.Observer.OnFileCopied(packagePath, size, b.events.done, b.events.total)
+}
+
+// warn records a warning and reports it to the observer, or logs it if there
+// is none
+func (b *Builder) warn(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	b.events.mu.Lock()
+	defer b.events.mu.Unlock()
+	b.Warnings = append(b.Warnings, message)
+	if b.Observer == nil {
+		b.logOutput().Warn(message)
+		return
+	}
+	b.Observer.OnWarning(message)
+}
+
+// checkStrictWarnings fails a strict build that produced warnings, before
+// the package is written. Checks that must stop the build at once fail where
+// they are made; every other warning goes through warn and is enforced here.
+func (b *Builder) checkStrictWarnings() error {
+	if !b.StrictMode {
+		return nil
+	}
+	b.events.mu.Lock()
+	defer b.events.mu.Unlock()
+	if len(b.Warnings) == 0 {
+		return nil
+	}
+	return ci.Errorf(ci.ClassPolicy, "strict mode: %d warning(s): %s", len(b.Warnings), strings.Join(b.Warnings, "; "))
+}
+
+// countFiles estimates the number of files to package for progress reporting.
+// It returns 0 if the source tree cannot be walked.
+func (b *Builder) countFiles() int {
+	matcher, err := b.newMatcher()
+	if err != nil {
+		return 0
+	}
+	count := len(b.generated)
+	err = filepath.Walk(b.SourceDir, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(b.SourceDir, srcPath)
+		if err != nil || relPath == "." {
+			return err
+		}
+		if matcher.Excluded(filepath.ToSlash(relPath), info.IsDir()) {
+			if info.IsDir() && matcher.CanPrune() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && specialFileKind(info.Mode()) == "" {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// RecordOverride notes that a validation was bypassed so it appears in the run summary
+func (b *Builder) RecordOverride(override string) {
+	b.Overrides = append(b.Overrides, override)
+}
+
+// Summary returns a summary of what the package built by this Builder will do
+// to a system: files packaged, install-time symlinks, scripts and overrides.
+func (b *Builder) Summary(outputPath string) *history.Summary {
+	summary := history.NewSummary("build")
+	summary.Package = fmt.Sprintf("%s_%s_%s", b.Package.Name, b.Package.Version, b.Package.Architecture)
+	summary.Output = outputPath
+	summary.FilesPackaged = append(summary.FilesPackaged, b.PackagedFiles...)
+
+	for _, request := range b.SymlinkProcessor.GetQueuedSymlinks() {
+		summary.AddSymlink(request.Target, request.Source)
+	}
+
+	for scriptName := range b.Scripts {
+		summary.Scripts = append(summary.Scripts, scriptName)
+	}
+	sort.Strings(summary.Scripts)
+
+	for _, override := range b.Overrides {
+		summary.AddOverride(override)
+	}
+	for _, waived := range b.WaivedFindings {
+		summary.AddOverride(waived.String())
+	}
+
+	for _, conflict := range b.OwnershipConflicts {
+		summary.Conflicts = append(summary.Conflicts, conflict.String())
+	}
+
+	summary.Privileged = append(summary.Privileged, b.ModeFindings...)
+
+	return summary
+}
+
+// AddExcludeDir adds a directory or exclude pattern
 func (b *Builder) AddExcludeDir(dir string) {
 	b.ExcludeDirs = append(b.ExcludeDirs, dir)
 }
 
+// AddIncludePattern adds a pattern for paths that are packaged even if excluded
+func (b *Builder) AddIncludePattern(include string) {
+	b.IncludePatterns = append(b.IncludePatterns, include)
+}
+
+// newMatcher compiles the exclude patterns from the builder and the source
+// directory's .pkgignore file, together with the include patterns
+func (b *Builder) newMatcher() (*pattern.Matcher, error) {
+	excludes := []string{"/" + pattern.IgnoreFileName}
+
+	ignoreFile := filepath.Join(b.SourceDir, pattern.IgnoreFileName)
+	if _, err := os.Stat(ignoreFile); err == nil {
+		patterns, err := pattern.ReadPatternFile(ignoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", ignoreFile, err)
+		}
+		excludes = append(excludes, patterns...)
+	}
+
+	for _, exclude := range b.ExcludeDirs {
+		// Absolute paths inside the source directory are anchored at its root
+		if rel, err := filepath.Rel(b.SourceDir, exclude); err == nil && filepath.IsAbs(exclude) && !strings.HasPrefix(rel, "..") {
+			exclude = "/" + filepath.ToSlash(rel)
+		}
+		excludes = append(excludes, exclude)
+	}
+
+	matcher, err := pattern.NewMatcher(excludes, b.IncludePatterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude or include pattern: %w", err)
+	}
+	return matcher, nil
+}
+
+// SetPermissions validates and applies a permissions policy
+func (b *Builder) SetPermissions(policy *security.PermissionsPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid permissions policy: %w", err)
+	}
+	b.Permissions = policy
+	return nil
+}
+
 // SetConflicts sets packages that conflict with this package
 func (b *Builder) SetConflicts(conflicts []string) {
 	b.Conflicts = conflicts
@@ -170,9 +677,20 @@ func (b *Builder) SetProvides(provides []string) {
 	b.Provides = provides
 }
 
+// SetReplaces sets packages whose files this package may overwrite
+func (b *Builder) SetReplaces(replaces []string) {
+	b.Replaces = replaces
+}
+
 // Clean removes temporary build files
 func (b *Builder) Clean() error {
-	if b.BuildDir != "" {
+	if b.fsSource != "" {
+		if err := os.RemoveAll(b.fsSource); err != nil {
+			return err
+		}
+	}
+	// A cached staging directory is kept for the next build
+	if b.BuildDir != "" && b.incremental == nil {
 		return os.RemoveAll(b.BuildDir)
 	}
 	return nil
@@ -184,15 +702,36 @@ func (b *Builder) createDebianDir() error {
 	if err := os.MkdirAll(debianDir, 0755); err != nil {
 		return fmt.Errorf("failed to create DEBIAN directory: %w", err)
 	}
+	return nil
+}
+
+// writeDebianFiles writes the control file, md5sums and maintainer scripts.
+// It runs after the files are copied so Installed-Size and md5sums describe
+// the actual payload and generated scripts are included.
+func (b *Builder) writeDebianFiles() error {
+	debianDir := filepath.Join(b.BuildDir, "DEBIAN")
 
 	// Generate control file
 	controlPath := filepath.Join(debianDir, "control")
-	controlContent := b.generateControlFile()
+	controlContent, err := b.generateControlFile()
+	if err != nil {
+		return err
+	}
 
 	if err := os.WriteFile(controlPath, []byte(controlContent), 0644); err != nil {
 		return fmt.Errorf("failed to write control file: %w", err)
 	}
 
+	if err := b.writeMD5Sums(); err != nil {
+		return err
+	}
+
+	if triggers := b.triggersFile(); triggers != "" {
+		if err := os.WriteFile(filepath.Join(debianDir, "triggers"), []byte(triggers), 0644); err != nil {
+			return fmt.Errorf("failed to write triggers file: %w", err)
+		}
+	}
+
 	// Write maintainer scripts
 	for scriptName, content := range b.Scripts {
 		scriptPath := filepath.Join(debianDir, scriptName)
@@ -204,76 +743,52 @@ func (b *Builder) createDebianDir() error {
 	return nil
 }
 
-// generateControlFile creates the control file content based on package metadata
-func (b *Builder) generateControlFile() string {
-	var controlLines []string
-
-	// Required fields
-	controlLines = append(controlLines, fmt.Sprintf("Package: %s", b.Package.Name))
-	controlLines = append(controlLines, fmt.Sprintf("Version: %s", b.Package.Version))
-	controlLines = append(controlLines, fmt.Sprintf("Architecture: %s", b.Package.Architecture))
-	controlLines = append(controlLines, fmt.Sprintf("Maintainer: %s", b.Package.Maintainer))
-	controlLines = append(controlLines, fmt.Sprintf("Description: %s", b.Package.Description))
-
-	// Optional fields
-	if b.Package.Section != "" {
-		controlLines = append(controlLines, fmt.Sprintf("Section: %s", b.Package.Section))
-	}
-
-	if b.Package.Priority != "" {
-		controlLines = append(controlLines, fmt.Sprintf("Priority: %s", b.Package.Priority))
-	}
-
-	if len(b.Package.Depends) > 0 {
-		controlLines = append(controlLines, fmt.Sprintf("Depends: %s", strings.Join(b.Package.Depends, ", ")))
-	}
-
-	if len(b.Conflicts) > 0 {
-		controlLines = append(controlLines, fmt.Sprintf("Conflicts: %s", strings.Join(b.Conflicts, ", ")))
+// generateControlFile creates the control file content based on package
+// metadata, in the configured field order or from the control template
+func (b *Builder) generateControlFile() (string, error) {
+	data := b.controlData()
+	if b.controlTemplate != nil {
+		return b.renderControlTemplate(data)
 	}
+	return data.Generated, nil
+}
 
-	if len(b.Provides) > 0 {
-		controlLines = append(controlLines, fmt.Sprintf("Provides: %s", strings.Join(b.Provides, ", ")))
+// relationField formats relationship entries for the control file, dropping
+// relations restricted to other architectures
+func (b *Builder) relationField(entries []string) string {
+	relationships, err := ParseRelationshipList(entries)
+	if err != nil {
+		// The entries are validated before building; keep them as given
+		return strings.Join(entries, ", ")
 	}
-
-	// Add timestamp
-	controlLines = append(controlLines, fmt.Sprintf("Installed-Size: %d", b.calculateInstalledSize()))
-	controlLines = append(controlLines, fmt.Sprintf("Homepage: https://github.com/go-i2p/go-pkginstall"))
-
-	return strings.Join(controlLines, "\n") + "\n"
+	return relationships.ForArchitecture(b.Package.Architecture).String()
 }
 
-// calculateInstalledSize estimates the installed size in KB
+// calculateInstalledSize returns the Installed-Size in KiB of the payload
 func (b *Builder) calculateInstalledSize() int {
-	var size int64
-	filepath.Walk(b.SourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-
-		if !info.IsDir() {
-			size += info.Size()
-		}
-
-		return nil
-	})
-
-	// Convert to KB and round up
-	return int((size + 1023) / 1024)
+	return int(b.installedSize)
 }
 
-// copyFiles copies files from source to build directory with secure path transformation
-func (b *Builder) copyFiles() error {
-	return filepath.Walk(b.SourceDir, func(srcPath string, info os.FileInfo, err error) error {
+// walkSource walks the source tree and calls fn for every path that will be
+// packaged, after exclusion, transformation, validation and symlink planning.
+// packagePath is the absolute path of the entry on the installed system.
+// Wrapper scripts are queued once the walk is done.
+func (b *Builder) walkSource(ctx context.Context, fn func(srcPath, packagePath string, info os.FileInfo) error) error {
+	matcher, err := b.newMatcher()
+	if err != nil {
+		return err
+	}
+	usage := &security.PayloadUsage{}
+	b.homeTargets, b.homeFiles = make(map[string]string), false
+	b.moduleDirs = make(map[string]relocatedModules)
+	b.wrapped = make(map[string]bool)
+
+	err = filepath.Walk(b.SourceDir, func(srcPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-
-		// Skip excluded directories
-		for _, excludeDir := range b.ExcludeDirs {
-			if strings.HasPrefix(srcPath, excludeDir) {
-				return nil
-			}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
 		// Get relative path from source directory
@@ -287,98 +802,563 @@ func (b *Builder) copyFiles() error {
 			return nil
 		}
 
+		// Skip excluded paths
+		if matcher.Excluded(filepath.ToSlash(relPath), info.IsDir()) {
+			b.log("Excluding %s", relPath)
+			if info.IsDir() && matcher.CanPrune() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Convert to absolute path for transformation
 		absPath := filepath.Join("/", relPath)
 
+		// Files in home directories are left out or redirected (--home-mode)
+		absPath, final, err := b.redirectHome(absPath, info)
+		if err != nil || absPath == "" {
+			return err
+		}
+		// Empty state directories are created by tmpfiles.d instead
+		if b.skipStateDir(srcPath, absPath, info) {
+			return nil
+		}
+		// Executables with a wrapper script make way for it
+		absPath = b.redirectWrapped(absPath, info)
+
 		// Transform the path for security
-		transformedPath, needsSymlink, err := b.PathMapper.TransformPath(absPath)
+		transformedPath, needsSymlink := absPath, false
+		if !final {
+			transformedPath, needsSymlink, err = b.PathMapper.TransformPath(absPath)
+		}
 		if err != nil {
-			// Log warning but continue if path cannot be transformed
-			if b.Verbose {
-				log.Printf("Warning: Could not transform path %s: %v", absPath, err)
+			if b.StrictMode {
+				return ci.Errorf(ci.ClassPolicy, "strict mode: %w", err)
 			}
+			// Log warning but continue if path cannot be transformed
+			b.warn("Could not transform path %s: %v", absPath, err)
 			transformedPath = absPath
 		}
+		if !info.IsDir() {
+			b.checkModulePath(absPath, transformedPath)
+		}
+
+		// Man pages and changelogs are shipped compressed, as Debian policy requires
+		if b.compresses(srcPath, info) {
+			absPath += ".gz"
+			transformedPath += ".gz"
+		}
+
+		if !info.IsDir() && b.PathMapper.IsExemptPath(absPath) {
+			b.RecordOverride(fmt.Sprintf("%s shipped at its system path (--allow-system-path)", absPath))
+		}
 
 		// Validate the path for security
 		if err := b.PathValidator.ValidatePath(transformedPath); err != nil {
-			return fmt.Errorf("path validation failed for %s: %w", transformedPath, err)
+			if b.enforcePaths() {
+				return ci.Errorf(ci.ClassPolicy, "path validation failed for %s: %w", transformedPath, err)
+			}
+			b.reportPath(fmt.Sprintf("path validation failed for %s: %v", transformedPath, err))
 		}
 
 		// Path traversal validation
 		if err := b.PathValidator.ValidatePathTraversal(transformedPath); err != nil {
-			return fmt.Errorf("path traversal check failed for %s: %w", transformedPath, err)
+			return ci.Errorf(ci.ClassPolicy, "path traversal check failed for %s: %w", transformedPath, err)
+		}
+
+		// Sockets, FIFOs and devices cannot be copied into the payload
+		if specialFileKind(info.Mode()) != "" {
+			return b.handleSpecialFile(srcPath, transformedPath, info)
+		}
+
+		// Stop runaway payloads before copying them
+		if !info.IsDir() {
+			var size int64
+			if info.Mode().IsRegular() {
+				size = info.Size()
+			}
+			if err := b.PathValidator.CheckPayloadFile(usage, transformedPath, size); err != nil {
+				return ci.Wrap(ci.ClassPolicy, err)
+			}
+		}
+
+		if err := b.scanMode(srcPath, transformedPath, info); err != nil {
+			return err
+		}
+		if err := b.checkArchitecture(srcPath, transformedPath, info); err != nil {
+			return err
+		}
+		b.checkHardening(srcPath, transformedPath, info)
+		b.checkFileType(srcPath, info)
+		if err := b.checkSecrets(srcPath, transformedPath, info); err != nil {
+			return err
 		}
 
 		// Record symlink requirement if needed
-		if needsSymlink {
-			if err := b.SymlinkProcessor.ProcessPath(absPath, transformedPath); err != nil {
-				if b.Verbose {
-					log.Printf("Warning: Failed to process symlink for %s: %v", absPath, err)
+		visiblePath := transformedPath
+		if needsSymlink && b.DisableSymlinks {
+			b.log("Symlinks disabled, not linking %s -> %s", absPath, transformedPath)
+		} else if needsSymlink {
+			if err := b.SymlinkProcessor.ProcessPath(absPath, transformedPath); err == nil {
+				visiblePath = absPath
+			} else {
+				// Existing parent directories are expected, so only files are fatal
+				if b.StrictMode && !info.IsDir() {
+					return ci.Errorf(ci.ClassPolicy, "strict mode: failed to process symlink for %s: %w", absPath, err)
 				}
+				b.warn("Failed to process symlink for %s: %v", absPath, err)
 				// Continue with the build process even if symlink processing fails
 			}
 		}
 
+		if !info.IsDir() {
+			b.noteDesktopFile(absPath, visiblePath)
+		}
+		return fn(srcPath, transformedPath, info)
+	})
+	if err != nil {
+		return err
+	}
+	b.addWrappers()
+	return nil
+}
+
+// copyFiles copies files from source to build directory with secure path transformation
+func (b *Builder) copyFiles(ctx context.Context) error {
+	// Files are copied, hashed and sized by a bounded pool of workers while the
+	// walk makes the transformation and validation decisions in order
+	jobs := make(chan copyJob)
+	failed := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		once     sync.Once
+		firstErr error
+		results  []copyResult
+		inodes   = make(map[fileKey]string)
+		links    []hardlink
+		dirs     = make(map[string]bool)
+	)
+	for i := 0; i < b.workerCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				result, err := b.copyFile(ctx, job)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+					continue
+				}
+				b.incremental.store(job, result)
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+				b.fileCopied(result.packagePath, result.size)
+			}
+		}()
+	}
+
+	walkErr := b.walkSource(ctx, func(srcPath, packagePath string, info os.FileInfo) error {
 		// Create the target path in the build directory
-		targetPath := filepath.Join(b.BuildDir, transformedPath)
+		targetPath := filepath.Join(b.BuildDir, packagePath)
 
 		if info.IsDir() {
 			// Create directory
-			if err := os.MkdirAll(targetPath, info.Mode()); err != nil {
+			dirs[packagePath] = true
+			if err := b.incremental.prepare(targetPath, true); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(targetPath, 0755); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
 			}
-		} else {
-			// Create parent directory if it doesn't exist
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+			if err := os.Chmod(targetPath, b.fileMode(srcPath, info)); err != nil {
+				return fmt.Errorf("failed to set permissions on %s: %w", targetPath, err)
 			}
-
-			// Copy file
-			srcFile, err := os.Open(srcPath)
-			if err != nil {
-				return fmt.Errorf("failed to open source file %s: %w", srcPath, err)
+			if b.PreserveOwner || b.PreserveXattrs {
+				attrs, err := b.sourceAttrs(srcPath, info)
+				if err != nil {
+					return err
+				}
+				return b.applyAttrs(targetPath, attrs)
 			}
-			defer srcFile.Close()
+			return nil
+		}
 
-			targetFile, err := os.Create(targetPath)
-			if err != nil {
-				return fmt.Errorf("failed to create target file %s: %w", targetPath, err)
+		b.PackagedFiles = append(b.PackagedFiles, packagePath)
+		// Further links to an already queued file are created once it is copied
+		key, linked := fileID(info)
+		if first, seen := inodes[key]; linked && seen && info.Mode()&os.ModeSymlink == 0 {
+			links = append(links, hardlink{packagePath: packagePath, first: first})
+			return b.incremental.prepare(targetPath, false)
+		}
+		if result, ok := b.incremental.lookup(srcPath, targetPath, packagePath, info); ok {
+			if linked {
+				inodes[key] = packagePath
 			}
-			defer targetFile.Close()
-
-			if _, err := io.Copy(targetFile, srcFile); err != nil {
-				return fmt.Errorf("failed to copy file content from %s to %s: %w", srcPath, targetPath, err)
+			if err := os.Chmod(targetPath, b.fileMode(srcPath, info)); err != nil {
+				return fmt.Errorf("failed to set permissions on %s: %w", targetPath, err)
 			}
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+			b.fileCopied(packagePath, result.size)
+			return nil
+		}
+		if err := b.incremental.prepare(targetPath, false); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return b.copySymlink(srcPath, targetPath, packagePath, info)
+		}
+		if linked {
+			inodes[key] = packagePath
+		}
 
-			// Set file permissions
-			mode := info.Mode()
-			if !b.PreservePerms {
-				// Default permissions: rw-r--r--
-				mode = 0644
-				// Make executable files executable by all
-				if mode&0100 != 0 {
-					mode = 0755
-				}
-			}
+		select {
+		case jobs <- copyJob{srcPath: srcPath, targetPath: targetPath, packagePath: packagePath, info: info}:
+			return nil
+		case <-failed:
+			return errCopyAborted
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
 
-			if err := os.Chmod(targetPath, mode); err != nil {
-				return fmt.Errorf("failed to set permissions on %s: %w", targetPath, err)
-			}
+	if firstErr != nil {
+		return firstErr
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+
+	b.md5sums = make(map[string]string, len(results))
+	for _, result := range results {
+		b.md5sums[result.packagePath] = result.md5sum
+	}
+
+	for _, link := range links {
+		if err := os.Link(filepath.Join(b.BuildDir, link.first), filepath.Join(b.BuildDir, link.packagePath)); err != nil {
+			return fmt.Errorf("failed to create hard link %s: %w", link.packagePath, err)
+		}
+		b.md5sums[link.packagePath] = b.md5sums[link.first]
+		if b.owners != nil {
+			b.owners[link.packagePath] = b.owners[link.first]
 		}
+		b.fileCopied(link.packagePath, 0)
+	}
 
-		return nil
+	err := b.packageGenerated(func(packagePath string, content []byte, mode os.FileMode) error {
+		targetPath := filepath.Join(b.BuildDir, packagePath)
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+		}
+		if err := os.WriteFile(targetPath, content, mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", targetPath, err)
+		}
+		return os.Chmod(targetPath, mode)
 	})
+	if err != nil {
+		return err
+	}
+
+	if err := b.incremental.prune(b.BuildDir, b.PackagedFiles, dirs); err != nil {
+		return err
+	}
+
+	// Measure what was staged, including the directories the layout created
+	b.installedSize, err = stagedInstalledSize(b.BuildDir)
+	return err
+}
+
+// copySymlink recreates a source symlink in the build directory
+func (b *Builder) copySymlink(srcPath, targetPath, packagePath string, info os.FileInfo) error {
+	target, err := b.linkTarget(srcPath, packagePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+	}
+	if err := os.Symlink(target, targetPath); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", targetPath, err)
+	}
+
+	attrs, err := b.sourceAttrs(srcPath, info)
+	if err != nil {
+		return err
+	}
+	if err := b.applyAttrs(targetPath, attrs); err != nil {
+		return err
+	}
+	b.fileCopied(packagePath, 0)
+	return nil
+}
+
+// copyJob is a file queued for the copy pipeline
+type copyJob struct {
+	srcPath     string
+	targetPath  string
+	packagePath string // Path of the file on the installed system
+	info        os.FileInfo
+}
+
+// copyResult is the outcome of a copyJob
+type copyResult struct {
+	packagePath string
+	size        int64
+	md5sum      string
+}
+
+// errCopyAborted stops the walk once a copy worker has failed
+var errCopyAborted = errors.New("file copy aborted")
+
+// workerCount returns the number of concurrent copy workers
+func (b *Builder) workerCount() int {
+	if b.Workers > 0 {
+		return b.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// copyFile copies a single file into the build directory, hashing it on the way
+func (b *Builder) copyFile(ctx context.Context, job copyJob) (copyResult, error) {
+	result := copyResult{packagePath: job.packagePath}
+
+	// Create parent directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(job.targetPath), 0755); err != nil {
+		return result, fmt.Errorf("failed to create parent directory for %s: %w", job.targetPath, err)
+	}
+
+	contentPath, cleanup, err := b.payloadContent(ctx, job.srcPath, job.packagePath, job.info)
+	if err != nil {
+		return result, err
+	}
+	defer cleanup()
+
+	srcFile, err := os.Open(contentPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to open source file %s: %w", job.srcPath, err)
+	}
+	defer srcFile.Close()
+
+	targetFile, err := os.Create(job.targetPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to create target file %s: %w", job.targetPath, err)
+	}
+	defer targetFile.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(targetFile, hash), contextReader{ctx, srcFile})
+	if err != nil {
+		return result, fmt.Errorf("failed to copy file content from %s to %s: %w", job.srcPath, job.targetPath, err)
+	}
+	result.size = size
+	result.md5sum = hex.EncodeToString(hash.Sum(nil))
+
+	if err := os.Chmod(job.targetPath, b.fileMode(job.srcPath, job.info)); err != nil {
+		return result, fmt.Errorf("failed to set permissions on %s: %w", job.targetPath, err)
+	}
+
+	attrs, err := b.sourceAttrs(job.srcPath, job.info)
+	if err != nil {
+		return result, err
+	}
+	if err := b.applyAttrs(job.targetPath, attrs); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// contextReader stops a copy once its context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// fileMode returns the mode a packaged file or directory is given: the source
+// mode with PreservePerms, otherwise 0755 for directories and executables and
+// 0644 for everything else, then adjusted by the permissions policy. Setuid and
+// setgid bits on files are dropped unless the policy allows them.
+func (b *Builder) fileMode(srcPath string, info os.FileInfo) os.FileMode {
+	mode := b.requestedMode(srcPath, info)
+	if !info.IsDir() && mode&(os.ModeSetuid|os.ModeSetgid) != 0 && !b.Permissions.SetuidAllowed(b.systemPath(srcPath)) {
+		return mode &^ (os.ModeSetuid | os.ModeSetgid)
+	}
+	return mode
+}
+
+// requestedMode returns the mode asked for by the source tree and the
+// permissions policy, before setuid and setgid bits are checked
+func (b *Builder) requestedMode(srcPath string, info os.FileInfo) os.FileMode {
+	var mode os.FileMode
+	switch {
+	case b.PreservePerms:
+		mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	case info.IsDir() || info.Mode()&0100 != 0:
+		mode = 0755
+	default:
+		mode = 0644
+	}
+	return b.Permissions.FileMode(b.systemPath(srcPath), info.IsDir(), mode)
+}
+
+// systemPath returns the absolute path of a source file on the target system,
+// before transformation
+func (b *Builder) systemPath(srcPath string) string {
+	relPath, err := filepath.Rel(b.SourceDir, srcPath)
+	if err != nil {
+		return srcPath
+	}
+	return filepath.Join("/", relPath)
+}
+
+// writeMD5Sums writes DEBIAN/md5sums for the copied files
+func (b *Builder) writeMD5Sums() error {
+	paths := make([]string, 0, len(b.md5sums))
+	for path := range b.md5sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var content strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&content, "%s  %s\n", b.md5sums[path], strings.TrimPrefix(path, "/"))
+	}
+
+	md5sumsPath := filepath.Join(b.BuildDir, "DEBIAN", "md5sums")
+	if err := os.WriteFile(md5sumsPath, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write md5sums file: %w", err)
+	}
+	return nil
 }
 
 // Build compiles the package from source and generates the .deb file.
-// It returns the full path to the created .deb file.
-func (b *Builder) Build() (string, error) {
-	defer b.Clean()
+// It returns the full path to the created .deb file and a report of the
+// build, which is also returned when the build fails. Cancelling ctx stops the
+// file walk, the copy workers and dpkg-deb, and removes any partial output.
+func (b *Builder) Build(ctx context.Context) (string, *BuildReport, error) {
+	outputPath, err := b.build(ctx, nil)
+	var checksum string
+	var size int64
+	if err == nil {
+		if checksum, size, err = checksumFile(outputPath); err != nil {
+			err = fmt.Errorf("failed to checksum package: %w", err)
+		}
+	}
+	report := b.report(outputPath, err)
+	report.SHA256, report.Size = checksum, size
+	b.complete(outputPath, report, err)
+	return outputPath, report, err
+}
 
-	// Validate package metadata
-	if err := b.Package.Validate(); err != nil {
-		return "", fmt.Errorf("package validation failed: %w", err)
+// BuildTo builds the package like Build but writes the .deb to w with the
+// built-in archive writer, so neither OutputDir nor dpkg-deb is used. With a
+// Writer set, the package is staged and written in that format instead. Debug
+// symbol packages need Build.
+func (b *Builder) BuildTo(ctx context.Context, w io.Writer) (*BuildReport, error) {
+	if w == nil {
+		return nil, fmt.Errorf("package writer cannot be nil")
+	}
+	if b.Strip.DebugPackage {
+		return nil, fmt.Errorf("debug symbol packages can only be written to an output directory")
+	}
+	if b.Writer == nil {
+		b.Streaming = true
+	}
+	out := newChecksumWriter(w)
+	_, err := b.build(ctx, out)
+	report := b.report("", err)
+	if err == nil {
+		report.SHA256, report.Size = out.sum(), out.size
+	}
+	b.complete("", report, err)
+	return report, err
+}
+
+// complete records a built package in the audit log and reports the end of
+// the build to the observer
+func (b *Builder) complete(outputPath string, report *BuildReport, err error) {
+	if err == nil {
+		auditlog.Record(auditlog.Entry{
+			Action:  auditlog.ActionPackageBuilt,
+			Path:    outputPath,
+			Package: b.Package.Name,
+			Version: b.Package.Version,
+			Detail:  "sha256 " + report.SHA256,
+		})
+		for _, waived := range b.WaivedFindings {
+			auditlog.Record(auditlog.Entry{
+				Action:  auditlog.ActionFindingWaived,
+				Path:    outputPath,
+				Package: b.Package.Name,
+				Version: b.Package.Version,
+				Detail:  waived.String(),
+			})
+		}
+	}
+	if b.Observer == nil {
+		return
+	}
+	if ro, ok := b.Observer.(ReportObserver); ok {
+		ro.OnReport(report)
+	}
+	b.Observer.OnComplete(outputPath, err)
+}
+
+// build builds the package into OutputDir, or into w if it is not nil, and
+// returns the path of the written file
+func (b *Builder) build(ctx context.Context, w io.Writer) (outputPath string, err error) {
+	defer func() {
+		b.closeCache(err)
+		if err != nil && b.KeepBuildDir {
+			b.log("Keeping build directory %s", b.BuildDir)
+			return
+		}
+		b.Clean()
+	}()
+
+	if err := b.validateMetadata(); err != nil {
+		return "", err
+	}
+
+	// Other package formats are written from the staged payload
+	if b.Writer != nil && b.SourcePackage {
+		return "", fmt.Errorf("source packages can only be built for the debian package type")
+	}
+	if b.Writer != nil || b.SourcePackage {
+		if b.Streaming || b.PreserveXattrs {
+			return "", fmt.Errorf("streaming and extended attributes are only supported for .deb packages")
+		}
+		if b.Strip.DebugPackage {
+			return "", fmt.Errorf("debug symbol packages are only supported for .deb packages")
+		}
+	}
+	if b.SourcePackage && w != nil {
+		return "", fmt.Errorf("source packages can only be written to an output directory")
+	}
+
+	// Concurrent builds of the same package must not write the same file
+	if w == nil {
+		unlock, err := b.lockOutput(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+	}
+	if err := b.openCache(ctx); err != nil {
+		return "", err
 	}
 
 	// Create DEBIAN directory structure
@@ -386,101 +1366,364 @@ func (b *Builder) Build() (string, error) {
 		return "", err
 	}
 
-	// Copy files with secure path transformation
-	if err := b.copyFiles(); err != nil {
+	if err := b.addMetainfo(); err != nil {
 		return "", err
 	}
+	b.addTmpfiles()
 
-	// Process symlinks if any were detected during file copying
-	if b.SymlinkProcessor.GetQueuedSymlinkCount() > 0 {
-		if b.Verbose {
-			log.Printf("Creating %d symlinks", b.SymlinkProcessor.GetQueuedSymlinkCount())
+	b.startPhase(PhaseCopy)
+	// dpkg-deb drops extended attributes, so they need the built-in writer
+	if b.PreserveXattrs && !b.Streaming {
+		b.log("Preserving extended attributes, using the built-in archive writer")
+		b.Streaming = true
+	}
+	b.recordOwners()
+	if err := b.checkStreamingHooks(); err != nil {
+		return "", err
+	}
+	if err := b.runHooks(ctx, hooks.PreCopy, ""); err != nil {
+		return "", err
+	}
+
+	if b.Strip.DebugPackage {
+		debugDir, err := os.MkdirTemp(b.WorkDir, "pkginstall-dbgsym-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create debug symbol directory: %w", err)
 		}
+		b.debugDir = debugDir
+		defer os.RemoveAll(debugDir)
+	}
 
-		// Create a special script to handle symlinks during package installation
-		if err := b.createSymlinkScript(); err != nil {
-			return "", fmt.Errorf("failed to create symlink script: %w", err)
+	var dataPath string
+	var dataFile *os.File
+	if b.Streaming {
+		// Stream the payload into a compressed data archive next to the output
+		// instead of copying the tree into the build directory
+		dataDir := b.OutputDir
+		if w != nil {
+			dataDir = b.WorkDir
 		}
+		dataFile, err = os.CreateTemp(dataDir, ".pkginstall-data-*.tar.gz")
+		if err != nil {
+			return "", fmt.Errorf("failed to create data archive: %w", err)
+		}
+		dataPath = dataFile.Name()
+		defer os.Remove(dataPath)
 	}
 
-	if err := b.PathValidator.ValidatePackage(b.BuildDir); err != nil {
-		return "", fmt.Errorf("package validation failed: %w", err)
+	// A root build started through sudo copies as the invoking user
+	restore, err := b.dropPrivileges()
+	if err != nil {
+		if dataFile != nil {
+			dataFile.Close()
+		}
+		return "", err
+	}
+	if dataFile != nil {
+		err = b.streamData(ctx, dataFile)
+		if closeErr := dataFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write data archive: %w", closeErr)
+		}
+	} else {
+		// Copy files with secure path transformation
+		err = b.copyFiles(ctx)
+	}
+	if restoreErr := restore(); err == nil {
+		err = restoreErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if !b.Streaming && len(b.Hooks.At(hooks.PostCopy)) > 0 {
+		if err := b.runHooks(ctx, hooks.PostCopy, ""); err != nil {
+			return "", err
+		}
+		if err := b.rescanStaged(); err != nil {
+			return "", err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("package build cancelled: %w", err)
 	}
 
-	// Generate output file name
+	// The architecture is final once the payload has been scanned
+	b.finalizeArchitecture()
 	outputFileName := fmt.Sprintf("%s_%s_%s.deb",
 		b.Package.Name,
 		b.Package.Version,
 		b.Package.Architecture)
-	outputPath := filepath.Join(b.OutputDir, outputFileName)
+	var staged *StagedPackage
+	if b.Writer != nil {
+		staged = b.staged()
+		outputFileName = b.Writer.FileName(staged)
+	}
+	if b.SourcePackage {
+		outputFileName = sourceFileName(b.Package)
+	}
+	outputPath = filepath.Join(b.OutputDir, outputFileName)
+
+	b.startPhase(PhaseScripts)
+
+	if count := b.SymlinkProcessor.GetQueuedSymlinkCount(); count > 0 {
+		b.log("Creating %d symlinks", count)
+	}
+
+	// Add symlinks detected during file copying and other install-time steps to postinst
+	if err := b.createPostinstScript(); err != nil {
+		return "", fmt.Errorf("failed to create postinst script: %w", err)
+	}
+
+	if err := b.writeDebianFiles(); err != nil {
+		return "", err
+	}
+
+	b.startPhase(PhaseValidate)
+	b.PathValidator.SetWorkers(b.workerCount())
+	b.PathValidator.SetPermissionAudit(b.Permissions, b.FixPerms)
+	if b.FixPerms && b.Streaming {
+		b.warn("--fix-perms has no effect on a streamed payload, which is not staged")
+	}
+	err = b.validatePackage()
+	if err != nil {
+		if b.enforcePaths() {
+			return "", ci.Errorf(ci.ClassPolicy, "package validation failed: %w", err)
+		}
+		// The individual paths have usually been reported while copying already
+		if len(b.PathFindings) == 0 {
+			b.reportPath(fmt.Sprintf("package validation failed: %v", err))
+		}
+	}
+	if len(b.PathFindings) > 0 {
+		b.RecordOverride(fmt.Sprintf("%d path violation(s) reported but not enforced (%s profile)", len(b.PathFindings), b.Profile.Name))
+	}
+
+	// Detect paths that are already owned by other installed packages
+	if err := b.checkOwnershipConflicts(); err != nil {
+		return "", err
+	}
+	b.analyzePayload()
+	if err := b.checkStrictWarnings(); err != nil {
+		return "", err
+	}
+
+	if err := b.runHooks(ctx, hooks.PrePackage, ""); err != nil {
+		return "", err
+	}
+
+	b.startPhase(PhaseArchive)
+	if b.Writer != nil || b.SourcePackage {
+		if b.SourcePackage {
+			err = b.writeSourcePackage(ctx, outputPath)
+		} else {
+			err = b.writePackage(ctx, staged, outputPath, w)
+		}
+		if err != nil {
+			return "", err
+		}
+		if w != nil {
+			outputPath = ""
+		}
+		if err := b.runHooks(ctx, hooks.PostPackage, outputPath); err != nil {
+			if outputPath != "" {
+				os.Remove(outputPath)
+			}
+			return "", err
+		}
+		return outputPath, nil
+	}
+	if w != nil {
+		if err := b.writeDebTo(ctx, w, dataPath); err != nil {
+			return "", fmt.Errorf("failed to build package: %w", err)
+		}
+		return "", b.runHooks(ctx, hooks.PostPackage, "")
+	}
+	if err := b.writeArchive(ctx, outputPath, dataPath); err != nil {
+		return "", err
+	}
 
-	// Build the package using dpkg-deb
+	b.startPhase(PhaseVerify)
+	if err := b.verifyOutput(ctx, outputPath); err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+
+	if b.Strip.DebugPackage {
+		debugPath, err := b.buildDebugPackage(ctx)
+		if err != nil {
+			os.Remove(outputPath)
+			return "", err
+		}
+		b.DebugPackagePath = debugPath
+	}
+
+	if err := b.runHooks(ctx, hooks.PostPackage, outputPath); err != nil {
+		os.Remove(outputPath)
+		if b.DebugPackagePath != "" {
+			os.Remove(b.DebugPackagePath)
+		}
+		return "", err
+	}
+
+	return outputPath, nil
+}
+
+// validateMetadata checks the package metadata and relations before a build
+func (b *Builder) validateMetadata() error {
+	if err := b.Package.Validate(); err != nil {
+		return ci.Errorf(ci.ClassValidation, "package validation failed: %w", err)
+	}
+	for _, field := range []struct {
+		name    string
+		entries []string
+	}{{"Conflicts", b.Conflicts}, {"Provides", b.Provides}, {"Replaces", b.Replaces}} {
+		if err := validateRelations(field.name, field.entries); err != nil {
+			return ci.Errorf(ci.ClassValidation, "package validation failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyOutput checks that the package written to outputPath holds exactly
+// the staged payload and the validated maintainer scripts
+func (b *Builder) verifyOutput(ctx context.Context, outputPath string) error {
+	b.log("Verifying %s", outputPath)
+	result, err := VerifyPackage(ctx, outputPath, VerifyOptions{
+		Package: b.Package,
+		Files:   b.PackagedFiles,
+		Scripts: b.Scripts,
+		// Checksum files next to the package describe the previous build
+		IgnoreChecksumFile: true,
+	})
+	if err != nil {
+		return ci.Errorf(ci.ClassValidation, "package verification failed: %w", err)
+	}
+	return result.Err()
+}
+
+// writeArchive writes the .deb to outputPath, with the built-in writer for
+// streamed builds and dpkg-deb otherwise
+func (b *Builder) writeArchive(ctx context.Context, outputPath, dataPath string) error {
+	if b.Streaming {
+		b.log("Writing %s", outputPath)
+		if err := b.writeDeb(ctx, outputPath, dataPath); err != nil {
+			return fmt.Errorf("failed to build package: %w", err)
+		}
+		return nil
+	}
+	if b.owners != nil {
+		return b.writeOwnedArchive(ctx, outputPath)
+	}
+
+	// Build the package using dpkg-deb; preserved owners are taken from the
+	// build directory instead of being reset to root
 	cmdArgs := []string{"--build", "--root-owner-group", b.BuildDir, outputPath}
-	if b.Verbose {
-		log.Printf("Running: dpkg-deb %s", strings.Join(cmdArgs, " "))
+	if b.PreserveOwner {
+		cmdArgs = []string{"--build", b.BuildDir, outputPath}
 	}
+	b.log("Running: dpkg-deb %s", strings.Join(cmdArgs, " "))
 
-	cmd := exec.Command("dpkg-deb", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := exec.CommandContext(ctx, "dpkg-deb", cmdArgs...)
+	cmd.Stdout = logging.Writer(b.logOutput(), slog.LevelInfo)
+	cmd.Stderr = logging.Writer(b.logOutput(), slog.LevelWarn)
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to build package: %w", err)
+		if ctx.Err() != nil {
+			// Don't leave a truncated package behind
+			os.Remove(outputPath)
+			return fmt.Errorf("package build cancelled: %w", ctx.Err())
+		}
+		return fmt.Errorf("failed to build package: %w", err)
 	}
 
-	return outputPath, nil
+	return nil
 }
 
-// BuildWithTimeout runs the Build method with a timeout.
-// It returns the path to the created .deb file or an error.
-func (b *Builder) BuildWithTimeout(timeout time.Duration) (string, error) {
-	resultCh := make(chan string, 1)
-	errCh := make(chan error, 1)
+// checkOwnershipConflicts compares packaged files and planned symlink targets against
+// the dpkg database and reports any path already owned by another installed package.
+// It also suggests relations with the packages that ship the same commands.
+func (b *Builder) checkOwnershipConflicts() error {
+	db, err := dpkgdb.Open(b.DpkgRoot)
+	if err != nil {
+		b.warn("Could not check dpkg database for conflicts: %v", err)
+		return nil
+	}
 
-	go func() {
-		path, err := b.Build()
-		if err != nil {
-			errCh <- err
-			return
+	b.OwnershipConflicts = db.FindConflicts(b.PackagedFiles, "file", b.Package.Name)
+
+	var targets []string
+	for _, request := range b.SymlinkProcessor.GetQueuedSymlinks() {
+		targets = append(targets, request.Target)
+	}
+	b.OwnershipConflicts = append(b.OwnershipConflicts, db.FindConflicts(targets, "symlink target", b.Package.Name)...)
+	for _, target := range targets {
+		if managed, ok := db.Managed(target); ok {
+			b.warn("Install-time symlink target %s; dpkg will fight over a raw symlink there", managed)
 		}
-		resultCh <- path
-	}()
+	}
 
-	select {
-	case path := <-resultCh:
-		return path, nil
-	case err := <-errCh:
-		return "", err
-	case <-time.After(timeout):
-		// Clean up on timeout
-		b.Clean()
-		return "", fmt.Errorf("package build timed out after %v", timeout)
+	for _, conflict := range b.OwnershipConflicts {
+		b.warn("%s", conflict)
 	}
+
+	b.suggestRelations(db)
+
+	if b.FailOnConflicts && len(b.OwnershipConflicts) > 0 {
+		return ci.Errorf(ci.ClassPolicy, "%d path(s) are already owned by installed packages", len(b.OwnershipConflicts))
+	}
+	return nil
 }
 
-// createSymlinkScript creates a postinst script that will create necessary symlinks during package installation
-func (b *Builder) createSymlinkScript() error {
-	symlinks := b.SymlinkProcessor.GetQueuedSymlinks()
-	if len(symlinks) == 0 {
+// postinstToken marks where generated commands are inserted into a
+// user-provided postinst script; without it they are appended
+const postinstToken = "#PKGINSTALL#"
+
+// createPostinstScript adds the install-time steps collected during the build,
+// such as symlinks, state directories, FIFOs, per-user files and cache
+// updates, to the postinst script
+func (b *Builder) createPostinstScript() error {
+	generated := b.symlinkSnippet() + b.stateDirSnippet() + b.fifoSnippet() + b.homeSnippet() + b.cacheSnippet()
+	if generated == "" {
 		return nil
 	}
 
-	// Create the postinst script content
-	var scriptContent strings.Builder
-	scriptContent.WriteString("#!/bin/sh\n\n")
-	scriptContent.WriteString("# This script was generated by go-pkginstall to create necessary symlinks\n\n")
-	scriptContent.WriteString("set -e\n\n")
+	var content string
+	if user, ok := b.Scripts["postinst"]; ok {
+		if strings.Contains(user, postinstToken) {
+			content = strings.Replace(user, postinstToken, strings.TrimRight(generated, "\n"), 1)
+		} else {
+			content = strings.TrimRight(user, "\n") + "\n\n# Added by go-pkginstall\n" + generated
+		}
+	} else {
+		var scriptContent strings.Builder
+		scriptContent.WriteString("#!/bin/sh\n\n")
+		scriptContent.WriteString("# This script was generated by go-pkginstall\n\n")
+		scriptContent.WriteString("set -e\n\n")
+		scriptContent.WriteString(generated)
+		content = scriptContent.String()
+	}
 
-	for _, symlink := range symlinks {
+	// Set the maintainer script
+	return b.SetMaintainerScript("postinst", content)
+}
+
+// symlinkSnippet returns the postinst commands that create the queued symlinks.
+// Relative symlinks are computed by ln -r on the installed system, whose
+// directories may be symlinks where the build host's are not.
+func (b *Builder) symlinkSnippet() string {
+	ln := "ln -sf"
+	if b.RelativeSymlinks {
+		ln = "ln -sfr"
+	}
+	var scriptContent strings.Builder
+	for _, symlink := range b.SymlinkProcessor.GetQueuedSymlinks() {
 		scriptContent.WriteString(fmt.Sprintf("# %s\n", symlink.Description))
 		scriptContent.WriteString(fmt.Sprintf("mkdir -p $(dirname '%s')\n", symlink.Target))
 		scriptContent.WriteString(fmt.Sprintf("if [ ! -e '%s' ]; then\n", symlink.Target))
-		scriptContent.WriteString(fmt.Sprintf("    ln -sf '%s' '%s'\n", symlink.Source, symlink.Target))
+		scriptContent.WriteString(fmt.Sprintf("    %s '%s' '%s'\n", ln, symlink.Source, symlink.Target))
 		scriptContent.WriteString(fmt.Sprintf("else\n"))
 		scriptContent.WriteString(fmt.Sprintf("    echo \"Warning: File '%s' already exists, not creating symlink\"\n", symlink.Target))
 		scriptContent.WriteString(fmt.Sprintf("fi\n\n"))
 	}
-
-	// Set the maintainer script
-	return b.SetMaintainerScript("postinst", scriptContent.String())
+	return scriptContent.String()
 }
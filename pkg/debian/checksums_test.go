@@ -0,0 +1,129 @@
+package debian
+
+import (
+	"archive/tar"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksumManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksums-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	control := []testEntry{
+		{name: "./control", content: "Package: demo\nVersion: 1.0\nArchitecture: all\nMaintainer: Demo <demo@example.com>\nDescription: demo\n", typeflag: tar.TypeReg},
+		{name: "./md5sums", content: "d3b07384d113edec49eaa6238ad5ff00  opt/demo/bin/demo\nd3b07384d113edec49eaa6238ad5ff00  opt/demo/bin/alias\n", typeflag: tar.TypeReg},
+	}
+	data := []testEntry{
+		{name: "./opt/demo/bin/", typeflag: tar.TypeDir},
+		{name: "./opt/demo/bin/demo", content: "foo\n", typeflag: tar.TypeReg},
+		{name: "./opt/demo/bin/alias", linkname: "./opt/demo/bin/demo", typeflag: tar.TypeLink},
+		{name: "./opt/demo/run", linkname: "bin/demo", typeflag: tar.TypeSymlink},
+	}
+	deb := filepath.Join(dir, "demo_1.0_all.deb")
+	writeTestDeb(t, deb, control, data)
+
+	written, err := writeChecksums(context.Background(), deb)
+	if err != nil {
+		t.Fatalf("writeChecksums() error = %v", err)
+	}
+	if len(written) != 2 || written[0] != deb+".sha256" || written[1] != filepath.Join(dir, "demo_1.0_all.manifest.json") {
+		t.Fatalf("writeChecksums() = %v", written)
+	}
+	sum, err := fileSHA256(deb)
+	if err != nil {
+		t.Fatalf("fileSHA256() error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(written[0]); string(content) != sum+"  demo_1.0_all.deb\n" {
+		t.Errorf("Unexpected checksum file %q", content)
+	}
+
+	manifest, err := LoadChecksumManifest(written[1])
+	if err != nil {
+		t.Fatalf("LoadChecksumManifest() error = %v", err)
+	}
+	if manifest.Package != "demo" || manifest.SHA256 != sum || len(manifest.Files) != 4 {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+	alias := manifest.Files[1]
+	if alias.Path != "/opt/demo/bin/alias" || alias.Type != "hardlink" || alias.SHA256 != manifest.Files[2].SHA256 || alias.SHA256 == "" {
+		t.Errorf("Unexpected hard link entry %+v", alias)
+	}
+
+	result, err := VerifyPackage(context.Background(), deb, VerifyOptions{Against: manifest})
+	if err != nil {
+		t.Fatalf("VerifyPackage() error = %v", err)
+	}
+	if len(result.Problems) != 0 {
+		t.Errorf("Unexpected problems %v", result.Problems)
+	}
+
+	// A package with different contents fails both the sidecar and the manifest
+	data[1].content = "bar\n"
+	data = append(data, testEntry{name: "./opt/demo/extra", content: "x", typeflag: tar.TypeReg})
+	writeTestDeb(t, deb, control, data)
+	result, err = VerifyPackage(context.Background(), deb, VerifyOptions{Against: manifest})
+	if err != nil {
+		t.Fatalf("VerifyPackage() error = %v", err)
+	}
+	problems := strings.Join(result.Problems, "\n")
+	for _, want := range []string{
+		"does not match its checksum in",
+		"the archive checksum",
+		"/opt/demo/bin/demo does not match its manifest entry",
+		"/opt/demo/extra is not in the manifest",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Problems lack %q:\n%s", want, problems)
+		}
+	}
+}
+
+func TestRebuildWithChecksumFiles(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	// Each build changes the payload, so the archive differs from the last one
+	for i, content := range []string{"first\n", "second\n"} {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "data"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.DpkgRoot = srcDir
+		outputPath, _, err := builder.Build(context.Background())
+		if err != nil {
+			t.Fatalf("Build() %d error = %v", i+1, err)
+		}
+		if _, err := writeChecksums(context.Background(), outputPath); err != nil {
+			t.Fatalf("writeChecksums() error = %v", err)
+		}
+		result, err := VerifyPackage(context.Background(), outputPath, VerifyOptions{})
+		if err != nil {
+			t.Fatalf("VerifyPackage() error = %v", err)
+		}
+		if err := result.Err(); err != nil {
+			t.Errorf("Expected the rewritten checksum file to match, got %v", err)
+		}
+	}
+}
@@ -1,14 +1,29 @@
 package debian
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"runtime"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/go-i2p/go-pkginstall/pkg/appstream"
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
 	"github.com/go-i2p/go-pkginstall/pkg/config"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+	"github.com/go-i2p/go-pkginstall/pkg/privilege"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/go-i2p/go-pkginstall/pkg/signature"
+	"github.com/go-i2p/go-pkginstall/pkg/telemetry"
+	"github.com/go-i2p/go-pkginstall/pkg/tmpfiles"
+	"github.com/go-i2p/go-pkginstall/pkg/torrent"
+	"github.com/go-i2p/go-pkginstall/pkg/wrapper"
 	"github.com/spf13/cobra"
 )
 
@@ -25,35 +40,108 @@ type BuildOptions struct {
 	Maintainer   string
 	Description  string
 	Architecture string
+	TargetArch   string
+	AllArches    bool
 	Section      string
 	Priority     string
 	Depends      []string
 	Conflicts    []string
 	Provides     []string
+	Replaces     []string
 	ConfigFile   string
+	PolicyFile   string
+	Profile      string
 
 	// Build options
 	SourceDir        string
 	OutputDir        string
+	PackageType      string
+	PluginSigner     string
+	PluginKey        string
+	Release          string
+	SourcePackage    bool
+	Distribution     string
+	WorkDir          string
+	KeepBuildDir     bool
+	AllowRoot        bool
+	Incremental      bool
+	CacheDir         string
 	PreservePerms    bool
+	FixPerms         bool
+	HardeningCheck   bool
+	FixRPath         bool
+	FixDevFiles      bool
+	NoSecretScan     bool
+	PreserveOwner    bool
+	PreserveXattrs   bool
+	UIDMap           []string
+	GIDMap           []string
 	Verbose          bool
+	Jobs             int
+	Stream           bool
+	LogFormat        string
+	Report           string
+	MetricsFile      string
+	OTLPEndpoint     string
+	SpecialFiles     string
+	HomeMode         string
+	ModulePaths      string
+	StripExecutables bool
+	StripLibraries   bool
+	DebugPackage     bool
+	StripExclude     []string
+	NoCompressDocs   bool
+	DesktopTriggers  string
 	ExcludeDirs      []string
+	IncludePatterns  []string
 	MaintainerScript string
+	ImportDebian     bool
+	InstallFile      string
+	SymlinkDirs      []string
+	TransformTarget  string
+	PerPackageDir    bool
+	AllowSystemPaths []string
 
 	// Security options
 	DisableSymlinks        bool
+	RelativeSymlinks       bool
 	StrictMode             bool
 	IgnoreScriptValidation bool
+	FailOnConflicts        bool
+	AptContents            bool
+
+	// Plan options
+	DryRun   bool
+	PlanFile string
+	FromPlan string
+
+	// Delta options
+	DeltaFrom string
+
+	// Signature options
+	MinisignKey string
+	SignifyKey  string
+
+	// Checksum options
+	Checksums bool
+
+	// Provenance options
+	Provenance    bool
+	ProvenanceKey string
+
+	// Torrent options
+	Torrent  bool
+	Trackers []string
+	WebSeeds []string
 }
 
 // NewBuildCommand creates a new cobra command for building Debian packages
 func NewBuildCommand() *cobra.Command {
 	options := &BuildOptions{
-		Architecture: getDefaultArchitecture(),
-		Priority:     "optional",
-		Section:      "utils",
-		OutputDir:    ".",
-		SourceDir:    ".",
+		Priority:  "optional",
+		Section:   "utils",
+		OutputDir: ".",
+		SourceDir: ".",
 	}
 
 	cmd := &cobra.Command{
@@ -69,50 +157,194 @@ alternatives, and symlinks are created only when necessary.
 Examples:
   pkginstall build --name myapp --version 1.0.0 --source ./build
   pkginstall build --config myapp.yaml --verbose
+  pkginstall build --config myapp.yaml --dry-run --plan myapp.plan.json
+  pkginstall build --from-plan myapp.plan.json
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runBuildCommand(options)
+			// Progress events follow the global --log-format
+			if flag := cmd.Flags().Lookup("log-format"); flag != nil {
+				options.LogFormat = flag.Value.String()
+			}
+			return runBuildCommand(cmd.Context(), options)
 		},
 	}
 
 	// Package metadata flags
-	cmd.Flags().StringVarP(&options.PackageName, "name", "n", "", "Package name (required)")
-	cmd.Flags().StringVarP(&options.Version, "version", "v", "", "Package version (required)")
-	cmd.Flags().StringVarP(&options.Maintainer, "maintainer", "m", "", "Package maintainer (required)")
+	cmd.Flags().StringVarP(&options.PackageName, "name", "n", "", "Package name (required unless --from-plan or --import-debian)")
+	cmd.Flags().StringVarP(&options.Version, "version", "v", "", "Package version (required unless --from-plan)")
+	cmd.Flags().StringVarP(&options.Maintainer, "maintainer", "m", "", "Package maintainer (required unless --from-plan)")
 	cmd.Flags().StringVarP(&options.Description, "description", "d", "", "Package description")
-	cmd.Flags().StringVar(&options.Architecture, "arch", options.Architecture, "Package architecture")
+	cmd.Flags().StringVar(&options.Architecture, "arch", "", "Package architecture (default: the host architecture, or all without ELF binaries)")
+	cmd.Flags().StringVar(&options.TargetArch, "target-arch", "", "Debian architecture to package for, checked against the payload's ELF binaries")
+	cmd.Flags().BoolVar(&options.AllArches, "all-arches", false,
+		"Build one package per entry of the configuration file's architectures section, each from its own payload directory")
 	cmd.Flags().StringVar(&options.Section, "section", options.Section, "Package section")
 	cmd.Flags().StringVar(&options.Priority, "priority", options.Priority, "Package priority")
 	cmd.Flags().StringSliceVar(&options.Depends, "depends", nil, "Package dependencies (comma-separated)")
 	cmd.Flags().StringSliceVar(&options.Conflicts, "conflicts", nil, "Package conflicts (comma-separated)")
 	cmd.Flags().StringSliceVar(&options.Provides, "provides", nil, "Packages this package provides (comma-separated)")
+	cmd.Flags().StringSliceVar(&options.Replaces, "replaces", nil, "Packages whose files this package may overwrite (comma-separated)")
 	cmd.Flags().StringVar(&options.ConfigFile, "config", "", "Configuration file path")
+	cmd.Flags().StringVar(&options.PolicyFile, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
 
 	// Build options flags
 	cmd.Flags().StringVarP(&options.SourceDir, "source", "s", options.SourceDir, "Source directory containing files to package")
 	cmd.Flags().StringVarP(&options.OutputDir, "output", "o", options.OutputDir, "Output directory for the generated .deb file")
+	cmd.Flags().StringVarP(&options.PackageType, "type", "t", DefaultPackageType, "Package type to build ("+strings.Join(PackageTypes(), ", ")+")")
+	cmd.Flags().StringVar(&options.Release, "release", "1", "Release or build number of rpm and slackware packages")
+	cmd.Flags().StringVar(&options.PluginSigner, "plugin-signer", "",
+		"su3 signer ID of --type i2p-plugin packages (default: the maintainer's email address)")
+	cmd.Flags().StringVar(&options.PluginKey, "plugin-key", "",
+		"PEM RSA key signing --type i2p-plugin packages, generated with a certificate if missing (default: $XDG_STATE_HOME/pkginstall/i2p/<signer>.su3.pem)")
+	cmd.Flags().BoolVar(&options.SourcePackage, "source-package", false,
+		"Write a Debian source package (.dsc, tarballs and debian/ directory) of the relocated payload instead of a .deb")
+	cmd.Flags().StringVar(&options.Distribution, "distribution", DefaultDistribution, "Changelog distribution of --source-package, e.g. a PPA series")
+	cmd.Flags().StringVar(&options.WorkDir, "work-dir", "", "Directory for build directories and temporary files (default: system temp dir)")
+	cmd.Flags().BoolVar(&options.KeepBuildDir, "keep-build-dir", false, "Keep the build directory for inspection when the build fails")
+	cmd.Flags().BoolVar(&options.AllowRoot, "allow-root", false,
+		privilege.AllowRootUsage+"; when started with sudo, files are still copied as the invoking user")
+	cmd.Flags().BoolVar(&options.Incremental, "incremental", false,
+		"Keep the staging directory between builds and only copy and hash files that changed")
+	cmd.Flags().StringVar(&options.CacheDir, "cache-dir", "",
+		"Directory of the --incremental staging caches, implies --incremental (default: $XDG_CACHE_HOME/pkginstall/build)")
 	cmd.Flags().BoolVarP(&options.PreservePerms, "preserve-perms", "p", false, "Preserve file permissions")
+	cmd.Flags().BoolVar(&options.FixPerms, "fix-perms", false,
+		"Fix world-writable paths, group-writable configs and executable documentation instead of reporting them")
+	cmd.Flags().BoolVar(&options.HardeningCheck, "hardening-check", false,
+		"Report packaged ELF files built without PIE, full RELRO, a non-executable stack or the stack protector")
+	cmd.Flags().BoolVar(&options.FixRPath, "fix-rpath", false,
+		"Rewrite the RUNPATH and RPATH of ELF files to the relocated library directories of the payload")
+	cmd.Flags().BoolVar(&options.FixDevFiles, "fix-dev-files", false,
+		"Rewrite the relocated paths in pkg-config (.pc), libtool (.la) and CMake package files")
+	cmd.Flags().BoolVar(&options.NoSecretScan, "no-secret-scan", false,
+		"Do not scan packaged files for private keys, access keys and tokens")
+	cmd.Flags().BoolVar(&options.PreserveOwner, "preserve-owner", false,
+		"Preserve file owners instead of root:root (without root, .deb packages are built under fakeroot or with the built-in writer)")
+	cmd.Flags().BoolVar(&options.PreserveXattrs, "preserve-xattrs", false,
+		"Preserve extended attributes and file capabilities (implies --stream)")
+	cmd.Flags().StringSliceVar(&options.UIDMap, "uid-map", nil, "Map a source UID to a packaged UID with --preserve-owner, e.g. 1000:0 (repeatable)")
+	cmd.Flags().StringSliceVar(&options.GIDMap, "gid-map", nil, "Map a source GID to a packaged GID with --preserve-owner, e.g. 1000:0 (repeatable)")
 	cmd.Flags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
-	cmd.Flags().StringSliceVar(&options.ExcludeDirs, "exclude", nil, "Directories to exclude from packaging (comma-separated)")
+	cmd.Flags().IntVarP(&options.Jobs, "jobs", "j", 0, "Number of files copied concurrently (default: number of CPUs)")
+	cmd.Flags().BoolVar(&options.Stream, "stream", false, "Stream files from the source directory into the package without a temporary copy")
+	cmd.Flags().StringVar(&options.SpecialFiles, "special-files", string(SpecialFilesSkip),
+		"How sockets, FIFOs and device nodes are handled: skip (with a warning), fail, or recreate (FIFOs are created by postinst)")
+	cmd.Flags().StringVar(&options.HomeMode, "home-mode", string(HomeSkip),
+		"How files below /home and /root are handled: skip (with a warning), skel (shipped in /etc/skel for new users), or postinst (also copied into existing homes)")
+	cmd.Flags().StringVar(&options.ModulePaths, "module-paths", string(ModulesWarn),
+		"How relocated Python, Perl and Ruby module directories are handled: warn (they cannot be imported), or relocate (Python gets a .pth file; Perl and Ruby need their search path variable)")
+	cmd.Flags().BoolVar(&options.StripExecutables, "strip", false, "Strip symbols from ELF executables")
+	cmd.Flags().BoolVar(&options.StripLibraries, "strip-so", false, "Strip symbols from shared libraries")
+	cmd.Flags().BoolVar(&options.DebugPackage, "dbgsym", false,
+		"Keep stripped debug info in a <name>-dbgsym package instead of discarding it")
+	cmd.Flags().StringSliceVar(&options.StripExclude, "strip-exclude", nil,
+		"Glob patterns of files that are never stripped (comma-separated)")
+	cmd.Flags().BoolVar(&options.NoCompressDocs, "no-compress-docs", false,
+		"Ship man pages and changelogs uncompressed instead of gzip -9n as Debian policy requires")
+	cmd.Flags().StringVar(&options.DesktopTriggers, "desktop-triggers", string(TriggersPostinst),
+		"How menus, icon caches and the MIME database are refreshed for packaged .desktop files, icons and MIME XML: postinst, dpkg (file triggers), or none")
+	cmd.Flags().StringVar(&options.Report, "report", "",
+		"Write a build report next to each .deb (json: <name>_<version>_<arch>.report.json)")
+	cmd.Flags().StringVar(&options.MetricsFile, "metrics-file", "",
+		"Write Prometheus metrics of the build to this file, e.g. for the node_exporter textfile collector")
+	cmd.Flags().StringVar(&options.OTLPEndpoint, "otlp-endpoint", telemetry.EndpointFromEnv(),
+		"Export build traces to this OTLP/HTTP collector, e.g. http://localhost:4318 (default: $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	cmd.Flags().StringSliceVar(&options.ExcludeDirs, "exclude", nil,
+		"Glob patterns to exclude from packaging, in .gitignore syntax (comma-separated); "+pattern.IgnoreFileName+" in the source directory is also read")
+	cmd.Flags().StringSliceVar(&options.IncludePatterns, "include", nil,
+		"Glob patterns to package even if excluded (comma-separated)")
 	cmd.Flags().StringVar(&options.MaintainerScript, "script", "", "Path to maintainer script file (postinst, preinst, etc.)")
+	cmd.Flags().StringVar(&options.InstallFile, "install-file", "",
+		"dh_install style file mapping source files to installation directories, e.g. \"build/app usr/bin\"; the source directory need not mirror the installed layout")
+	cmd.Flags().BoolVar(&options.ImportDebian, "import-debian", false,
+		"Seed the metadata, relations, maintainer scripts and conffiles from the debian/ directory of the source directory; flags and the config file take precedence")
+	cmd.Flags().StringSliceVar(&options.SymlinkDirs, "symlink-dir", nil, "Additional directory where install-time symlinks may be created (repeatable)")
+	cmd.Flags().StringVar(&options.TransformTarget, "transform-target", string(security.TargetOpt),
+		"Where system paths are relocated (opt, usr-local, srv)")
+	cmd.Flags().BoolVar(&options.PerPackageDir, "per-package-dir", false,
+		"Relocate into a per-package directory such as /opt/<name>")
+	cmd.Flags().StringSliceVar(&options.AllowSystemPaths, "allow-system-path", nil,
+		"Ship this path at its real location instead of transforming it; you accept the risk (repeatable)")
 
 	// Security options flags
 	cmd.Flags().BoolVar(&options.DisableSymlinks, "disable-symlinks", false, "Disable automatic symlink creation")
-	cmd.Flags().BoolVar(&options.StrictMode, "strict", false, "Enable strict security validation")
+	cmd.Flags().BoolVar(&options.RelativeSymlinks, "relative-symlinks", false,
+		"Create install-time symlinks with relative paths (../../opt/...) that survive chroot moves and images")
+	cmd.Flags().BoolVar(&options.StrictMode, "strict", false, "Enable strict security validation (high-security checks, warnings fail the build)")
 	cmd.Flags().BoolVar(&options.IgnoreScriptValidation, "ignore-script-validation", false,
 		"Ignore script validation failures (NOT RECOMMENDED)")
+	cmd.Flags().BoolVar(&options.FailOnConflicts, "fail-on-conflicts", false,
+		"Fail if packaged files or symlink targets are owned by installed packages")
+	cmd.Flags().BoolVar(&options.AptContents, "apt-contents", false,
+		"Also suggest relations with packages that are not installed, using the apt-file Contents indices")
+
+	// Plan flags
+	cmd.Flags().BoolVar(&options.DryRun, "dry-run", false,
+		"Transform and validate paths and plan symlinks without writing anything; print the build plan as JSON")
+	cmd.Flags().StringVar(&options.PlanFile, "plan", "", "Write the --dry-run plan to this file instead of stdout")
+	cmd.Flags().StringVar(&options.FromPlan, "from-plan", "",
+		"Build exactly the package described by a plan written with --dry-run; package metadata, paths and scripts come from the plan")
+
+	// Delta flags
+	cmd.Flags().StringVar(&options.DeltaFrom, "delta-from", "",
+		"Also write a delta from this previous .deb of the package to the new one (see pkginstall delta)")
+
+	// Signature flags
+	cmd.Flags().StringVar(&options.MinisignKey, "minisign-key", "",
+		"minisign secret key writing a detached <package>.minisig next to each package")
+	cmd.Flags().StringVar(&options.SignifyKey, "signify-key", "",
+		"signify secret key writing a detached <package>.sig next to each package")
+
+	// Checksum flags
+	cmd.Flags().BoolVar(&options.Checksums, "checksums", true,
+		"Write <package>.sha256 and, for .deb packages, a manifest of per-file SHA-256 checksums for pkginstall verify --against; --checksums=false skips them")
 
-	// Mark required flags
-	cmd.MarkFlagRequired("name")
-	cmd.MarkFlagRequired("version")
-	cmd.MarkFlagRequired("maintainer")
+	// Provenance flags
+	cmd.Flags().BoolVar(&options.Provenance, "provenance", true,
+		"Write signed SLSA provenance (<package>.intoto.jsonl) next to each package; --provenance=false skips it")
+	cmd.Flags().StringVar(&options.ProvenanceKey, "provenance-key", "",
+		"PEM Ed25519 key signing the provenance, generated with its .pub public key if missing (default: $XDG_STATE_HOME/pkginstall/provenance.key)")
+
+	// Torrent flags
+	cmd.Flags().BoolVar(&options.Torrent, "torrent", false,
+		"Also write a .torrent file next to each package and print its magnet link")
+	cmd.Flags().StringSliceVar(&options.Trackers, "tracker", nil,
+		"Announce URL of --torrent files, the first one primary (repeatable; implies --torrent)")
+	cmd.Flags().StringSliceVar(&options.WebSeeds, "webseed", nil,
+		"HTTP URL serving the package, added to --torrent files and magnet links as a web seed (repeatable; implies --torrent)")
 
 	return cmd
 }
 
 // runBuildCommand executes the build command with the specified options
-func runBuildCommand(options *BuildOptions) error {
+func runBuildCommand(ctx context.Context, options *BuildOptions) error {
+	// --all-arches takes every architecture from the configuration file, so
+	// one given on the command line would be ignored
+	if options.AllArches {
+		switch {
+		case options.Architecture != "":
+			return ci.Errorf(ci.ClassUsage, "--all-arches and --arch cannot be combined")
+		case options.TargetArch != "":
+			return ci.Errorf(ci.ClassUsage, "--all-arches and --target-arch cannot be combined")
+		}
+	}
+
 	// Load configuration from file if specified
+	var configSymlinkDirs []string
+	var configMappings []security.PathMapping
+	var configRules []security.MappingRuleSpec
+	var configPermissions *security.PermissionsPolicy
+	var configWaivers []security.ScriptWaiver
+	var configAppStream *appstream.Component
+	var configHooks *hooks.Hooks
+	var configStateDirs []tmpfiles.StateDir
+	var configWrappers []wrapper.Wrapper
+	var configControlFieldOrder []string
+	var configControlTemplate string
+	var configArches map[string]string
+	var configDir string
 	if options.ConfigFile != "" {
 		cfg, err := config.LoadConfig(options.ConfigFile)
 		if err != nil {
@@ -132,7 +364,7 @@ func runBuildCommand(options *BuildOptions) error {
 		if options.Description == "" {
 			options.Description = cfg.Description
 		}
-		if options.Architecture == getDefaultArchitecture() {
+		if options.Architecture == "" {
 			options.Architecture = cfg.Architecture
 		}
 		if options.Section == "utils" {
@@ -141,23 +373,203 @@ func runBuildCommand(options *BuildOptions) error {
 		if options.Priority == "optional" {
 			options.Priority = cfg.Priority
 		}
+		if options.InstallFile == "" {
+			options.InstallFile = cfg.InstallFile
+		}
+		if options.TransformTarget == string(security.TargetOpt) && cfg.TransformTarget != "" {
+			options.TransformTarget = cfg.TransformTarget
+		}
+		options.PerPackageDir = options.PerPackageDir || cfg.PerPackageDir
+		options.RelativeSymlinks = options.RelativeSymlinks || cfg.RelativeSymlinks
+		configSymlinkDirs = cfg.SymlinkDirs
+		configMappings = cfg.PathMappings
+		configRules = cfg.MappingRules
+		configPermissions = cfg.Permissions
+		configWaivers = cfg.ScriptWaivers
+		configAppStream = cfg.AppStream
+		configHooks = cfg.Hooks
+		configStateDirs = cfg.StateDirs
+		configWrappers = cfg.Wrappers
+		configControlFieldOrder = cfg.ControlFieldOrder
+		configControlTemplate = cfg.ControlTemplate
+		configArches = cfg.Architectures
+		configDir = filepath.Dir(options.ConfigFile)
+		options.AllowSystemPaths = append(cfg.AllowSystemPaths, options.AllowSystemPaths...)
+	}
+
+	var imported *DebianDir
+	if options.ImportDebian {
+		if options.FromPlan != "" {
+			return ci.Errorf(ci.ClassUsage, "--import-debian cannot be combined with --from-plan, which uses the plan's metadata")
+		}
+		var err error
+		if imported, err = importDebianOptions(options); err != nil {
+			return err
+		}
+		// --install-file replaces debian/install
+		if options.InstallFile != "" {
+			imported.Install = ""
+		}
+	}
+	if options.InstallFile != "" || imported != nil && imported.Install != "" {
+		switch {
+		case options.DryRun:
+			return ci.Errorf(ci.ClassUsage, "an install file cannot be combined with --dry-run, since the plan would name the temporarily staged files")
+		case options.FromPlan != "":
+			return ci.Errorf(ci.ClassUsage, "--install-file cannot be combined with --from-plan, which uses the plan's files")
+		}
+	}
+	// A dry run only plans the package
+	if !options.DryRun {
+		if err := privilege.CheckRoot(options.AllowRoot); err != nil {
+			return err
+		}
+	}
+
+	transformTarget, err := security.ParseTransformTarget(options.TransformTarget)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the security profile; a policy file is applied on top of it
+	profile, err := security.LookupProfile(options.Profile)
+	if err != nil {
+		return err
+	}
+	observer, err := newBuildObserver(options.LogFormat, options.Verbose)
+	if err != nil {
+		return err
+	}
+	if report := strings.ToLower(options.Report); report != "" && report != "json" {
+		return fmt.Errorf("unknown report format: %s (available: json)", options.Report)
+	}
+	var metrics *BuildMetrics
+	if options.MetricsFile != "" {
+		registry := telemetry.NewRegistry()
+		metrics = NewBuildMetrics(registry)
+		defer func() {
+			if err := registry.WriteFile(options.MetricsFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}()
+	}
+	var tracer *telemetry.Tracer
+	if options.OTLPEndpoint != "" {
+		tracer = telemetry.NewTracer("pkginstall", options.OTLPEndpoint, nil)
+		defer func() {
+			if err := tracer.Flush(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}()
+	}
+	desktopTriggers, err := ParseTriggerMode(options.DesktopTriggers)
+	if err != nil {
+		return err
+	}
+	specialFiles, err := ParseSpecialFilePolicy(options.SpecialFiles)
+	if err != nil {
+		return err
+	}
+	homeMode, err := ParseHomeMode(options.HomeMode)
+	if err != nil {
+		return err
+	}
+	moduleMode, err := ParseModuleMode(options.ModulePaths)
+	if err != nil {
+		return err
+	}
+	uidMap, err := ParseIDMap(options.UIDMap)
+	if err != nil {
+		return err
+	}
+	gidMap, err := ParseIDMap(options.GIDMap)
+	if err != nil {
+		return err
+	}
+	// Load the organisation security policy, if any
+	var policy *security.PolicyFile
+	if options.PolicyFile != "" {
+		policy, err = security.LoadPolicyFile(options.PolicyFile)
+		if err != nil {
+			return err
+		}
+		// Symlink directories from the policy take precedence over the config file
+		if len(policy.PathMapping.SymlinkDirs) > 0 {
+			configSymlinkDirs = policy.PathMapping.SymlinkDirs
+		}
+	}
+
+	// A plan supplies the metadata and the single payload to build
+	var plan *Plan
+	if options.FromPlan != "" {
+		switch {
+		case options.DryRun:
+			return ci.Errorf(ci.ClassUsage, "--dry-run and --from-plan cannot be combined")
+		case options.AllArches:
+			return ci.Errorf(ci.ClassUsage, "--all-arches cannot be combined with --from-plan")
+		case options.MaintainerScript != "":
+			return ci.Errorf(ci.ClassUsage, "--script cannot be combined with --from-plan, which uses the plan's scripts")
+		}
+		if plan, err = LoadPlan(options.FromPlan); err != nil {
+			return err
+		}
+		options.PackageName, options.Version, options.Maintainer = plan.Name, plan.Version, plan.Maintainer
+		options.Architecture, options.SourceDir = plan.Architecture, plan.SourceDir
+	}
+	if options.PlanFile != "" && !options.DryRun {
+		return ci.Errorf(ci.ClassUsage, "--plan requires --dry-run")
 	}
 
 	// Validate required options
 	if options.PackageName == "" {
-		return fmt.Errorf("package name is required")
+		return ci.Errorf(ci.ClassUsage, "package name is required")
 	}
 	if options.Version == "" {
-		return fmt.Errorf("package version is required")
+		return ci.Errorf(ci.ClassUsage, "package version is required")
 	}
 	if options.Maintainer == "" {
-		return fmt.Errorf("package maintainer is required")
+		return ci.Errorf(ci.ClassUsage, "package maintainer is required")
 	}
-
-	// Normalize and validate paths
-	sourceDir, err := validatePath(options.SourceDir, true)
+	targets, err := resolveBuildTargets(options, configArches, configDir)
 	if err != nil {
-		return fmt.Errorf("invalid source directory: %w", err)
+		return err
+	}
+	if options.DryRun && len(targets) > 1 {
+		return ci.Errorf(ci.ClassUsage, "--dry-run plans a single package; use --arch instead of --all-arches")
+	}
+	if options.DeltaFrom != "" {
+		switch {
+		case options.DryRun:
+			return ci.Errorf(ci.ClassUsage, "--delta-from cannot be combined with --dry-run")
+		case options.SourcePackage:
+			return ci.Errorf(ci.ClassUsage, "--delta-from requires a .deb build, not --source-package")
+		case len(targets) > 1:
+			return ci.Errorf(ci.ClassUsage, "--delta-from applies to a single package; use --arch instead of --all-arches")
+		}
+		if _, err := os.Stat(options.DeltaFrom); err != nil {
+			return ci.Errorf(ci.ClassUsage, "invalid --delta-from package: %w", err)
+		}
+	}
+	signatureKeys := signature.Keys(options.MinisignKey, options.SignifyKey)
+	for _, key := range signatureKeys {
+		switch {
+		case options.DryRun:
+			return ci.Errorf(ci.ClassUsage, "--%s-key cannot be combined with --dry-run", key.Scheme)
+		case options.SourcePackage:
+			return ci.Errorf(ci.ClassUsage, "--%s-key requires a binary package build, not --source-package", key.Scheme)
+		}
+		if _, err := os.Stat(key.File); err != nil {
+			return ci.Errorf(ci.ClassUsage, "invalid --%s-key: %w", key.Scheme, err)
+		}
+	}
+	options.Torrent = options.Torrent || len(options.Trackers) > 0 || len(options.WebSeeds) > 0
+	if options.Torrent {
+		switch {
+		case options.DryRun:
+			return ci.Errorf(ci.ClassUsage, "--torrent cannot be combined with --dry-run")
+		case options.SourcePackage:
+			return ci.Errorf(ci.ClassUsage, "--torrent requires a binary package build, not --source-package")
+		}
 	}
 
 	outputDir, err := validatePath(options.OutputDir, false)
@@ -170,83 +582,425 @@ func runBuildCommand(options *BuildOptions) error {
 		options.Description = options.PackageName
 	}
 
-	// Create package metadata
-	pkg := NewPackage(
-		options.PackageName,
-		options.Version,
-		options.Architecture,
-		options.Maintainer,
-		options.Description,
-		options.Section,
-		options.Priority,
-		options.Depends,
-	)
-
-	// Create builder
-	builder, err := NewBuilder(pkg, sourceDir, outputDir)
-	if err != nil {
-		return fmt.Errorf("failed to create builder: %w", err)
-	}
+	// build builds the package for one architecture
+	build := func(target buildTarget) error {
+		// Create package metadata
+		pkg := NewPackage(
+			options.PackageName,
+			options.Version,
+			target.arch,
+			options.Maintainer,
+			options.Description,
+			options.Section,
+			options.Priority,
+			options.Depends,
+		)
 
-	// Configure builder
-	builder.PreservePerms = options.PreservePerms
-	builder.Verbose = options.Verbose
+		// Create builder
+		builderOpts := []BuilderOption{
+			WithPackageType(options.PackageType),
+			WithPluginSigner(options.PluginSigner, options.PluginKey),
+			WithRelease(options.Release),
+		}
+		if options.WorkDir != "" {
+			builderOpts = append(builderOpts, WithWorkDir(options.WorkDir))
+		}
+		builder, err := NewBuilder(pkg, target.sourceDir, outputDir, builderOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to create builder: %w", err)
+		}
 
-	// Add excluded directories
-	for _, excludeDir := range options.ExcludeDirs {
-		builder.AddExcludeDir(excludeDir)
-	}
+		// Configure builder
+		builder.PreservePerms = options.PreservePerms
+		builder.FixPerms = options.FixPerms
+		builder.HardeningCheck = options.HardeningCheck
+		builder.FixRPath = options.FixRPath
+		builder.FixDevFiles = options.FixDevFiles
+		builder.NoSecretScan = options.NoSecretScan
+		builder.PreserveOwner = options.PreserveOwner
+		builder.PreserveXattrs = options.PreserveXattrs
+		builder.UIDMap = uidMap
+		builder.GIDMap = gidMap
+		builder.Verbose = options.Verbose
+		builder.KeepBuildDir = options.KeepBuildDir
+		// The command line runs one build at a time, so it may switch users
+		if cred := privilege.SudoCredential(); cred != nil && geteuid() == 0 {
+			builder.CopyAs = cred
+		}
+		if options.Incremental || options.CacheDir != "" {
+			builder.CacheDir = DefaultCacheDir(options.PackageName, target.arch)
+			if options.CacheDir != "" {
+				builder.CacheDir = filepath.Join(options.CacheDir, options.PackageName+"_"+target.arch)
+			}
+		}
+		builder.Workers = options.Jobs
+		builder.AutoArchitecture = target.auto
+		builder.Streaming = options.Stream
+		builder.SourcePackage = options.SourcePackage
+		builder.Distribution = options.Distribution
+		builder.Observer = withTelemetry(ctx, observer, builder.logOutput(), metrics, tracer)
+		builder.SpecialFiles = specialFiles
+		builder.HomeMode = homeMode
+		builder.ModulePaths = moduleMode
+		builder.CompressDocs = !options.NoCompressDocs
+		builder.DesktopTriggers = desktopTriggers
+		builder.AppStream = configAppStream
+		if err := builder.SetHooks(configHooks); err != nil {
+			return err
+		}
+		if err := builder.SetStateDirs(configStateDirs); err != nil {
+			return err
+		}
+		if err := builder.SetWrappers(configWrappers); err != nil {
+			return err
+		}
+		if err := builder.SetControlFieldOrder(configControlFieldOrder); err != nil {
+			return err
+		}
+		if err := builder.SetControlTemplate(configControlTemplate); err != nil {
+			return err
+		}
+		builder.FailOnConflicts = options.FailOnConflicts
+		builder.AptContents = options.AptContents
+		builder.DisableSymlinks = options.DisableSymlinks
+		builder.RelativeSymlinks = options.RelativeSymlinks
+		layout := &security.PathLayout{
+			Target:     transformTarget,
+			Package:    options.PackageName,
+			PerPackage: options.PerPackageDir,
+			Mappings:   configMappings,
+			Rules:      configRules,
+			Exempt:     options.AllowSystemPaths,
+		}
+		if err := builder.ApplyLayout(layout); err != nil {
+			return fmt.Errorf("invalid path layout: %w", err)
+		}
+		if err := builder.SetPermissions(configPermissions); err != nil {
+			return err
+		}
+		if err := builder.SetScriptWaivers(configWaivers); err != nil {
+			return err
+		}
+		err = builder.SetStrip(StripOptions{
+			Executables:  options.StripExecutables,
+			Libraries:    options.StripLibraries,
+			DebugPackage: options.DebugPackage,
+			Exclude:      options.StripExclude,
+		})
+		if err != nil {
+			return err
+		}
+		builder.ApplyProfile(profile)
+		if options.Verbose {
+			fmt.Printf("Using security profile: %s\n", profile.Name)
+			fmt.Printf("Transformed paths root: %s\n", layout.Root())
+		}
+		if policy != nil {
+			builder.ApplyPolicy(policy)
+			if options.Verbose {
+				fmt.Printf("Using security policy: %s\n", policy.Path())
+			}
+		}
+		if options.StrictMode {
+			builder.EnableStrictMode()
+		}
 
-	// Set conflicts and provides
-	if len(options.Conflicts) > 0 {
-		builder.SetConflicts(options.Conflicts)
-	}
-	if len(options.Provides) > 0 {
-		builder.SetProvides(options.Provides)
-	}
+		// Resolve the effective symlink directories from config and flags
+		symlinkDirs := security.ResolveSymlinkDirs(configSymlinkDirs, options.SymlinkDirs)
+		builder.SetSymlinkDirs(symlinkDirs)
+		if options.Verbose {
+			fmt.Printf("Effective symlink directories: %s\n", strings.Join(symlinkDirs, ", "))
+		}
 
-	if options.MaintainerScript != "" {
-		scriptContent, scriptName, err := loadMaintainerScript(options.MaintainerScript)
-		if err != nil {
-			return fmt.Errorf("failed to load maintainer script: %w", err)
+		// Add excluded directories
+		for _, excludeDir := range options.ExcludeDirs {
+			builder.AddExcludeDir(excludeDir)
+		}
+		for _, include := range options.IncludePatterns {
+			builder.AddIncludePattern(include)
 		}
 
-		err = builder.SetMaintainerScript(scriptName, scriptContent)
-		if err != nil {
-			// Check if this is a validation error
-			if strings.Contains(err.Error(), "Script validation failed") {
-				if options.IgnoreScriptValidation {
-					// If the user has chosen to ignore validation, log a warning but continue
-					fmt.Printf("WARNING: Script validation issues were detected but ignored due to --ignore-script-validation flag.\n")
-					fmt.Printf("Issues: %v\n", err)
-
-					// Force set the script bypassing validation
-					builder.Scripts[scriptName] = scriptContent
-				} else {
-					// Provide guidance on how to bypass if needed
+		if options.InstallFile != "" {
+			if err := builder.SetInstallFile(options.InstallFile); err != nil {
+				return ci.Errorf(ci.ClassUsage, "--install-file: %w", err)
+			}
+		}
+		if imported != nil {
+			if err := imported.Apply(builder, options.IgnoreScriptValidation); err != nil {
+				var scriptErr *ScriptValidationError
+				if errors.As(err, &scriptErr) {
 					return fmt.Errorf("%w\n\nTo bypass script validation, use the --ignore-script-validation flag (not recommended)", err)
 				}
+				return fmt.Errorf("--import-debian: %w", err)
+			}
+		}
+
+		// Set conflicts, provides and replaces
+		if len(options.Conflicts) > 0 {
+			builder.SetConflicts(options.Conflicts)
+		}
+		if len(options.Provides) > 0 {
+			builder.SetProvides(options.Provides)
+		}
+		if len(options.Replaces) > 0 {
+			builder.SetReplaces(options.Replaces)
+		}
+
+		if options.MaintainerScript != "" {
+			scriptContent, scriptName, err := loadMaintainerScript(options.MaintainerScript)
+			if err != nil {
+				return fmt.Errorf("failed to load maintainer script: %w", err)
+			}
+
+			err = builder.SetMaintainerScript(scriptName, scriptContent)
+			if err != nil {
+				// Check if this is a validation error
+				var scriptErr *ScriptValidationError
+				if errors.As(err, &scriptErr) {
+					if options.IgnoreScriptValidation {
+						// If the user has chosen to ignore validation, log a warning but continue
+						fmt.Printf("WARNING: Script validation issues were detected but ignored due to --ignore-script-validation flag.\n")
+						fmt.Printf("Issues: %v\n", err)
+
+						// Force set the script bypassing validation
+						builder.Scripts[scriptName] = scriptContent
+						builder.RecordOverride(fmt.Sprintf("%s script validation ignored (--ignore-script-validation)", scriptName))
+					} else {
+						// Provide guidance on how to bypass if needed
+						return fmt.Errorf("%w\n\nTo bypass script validation, use the --ignore-script-validation flag (not recommended)", err)
+					}
+				} else {
+					// Regular error setting script
+					return fmt.Errorf("failed to set maintainer script: %w", err)
+				}
+			}
+		}
+
+		// Build the package with timeout
+		// The output file is named after the builder's architecture, which a
+		// plan sets and which switches to all for payloads without ELF files
+		if options.Verbose {
+			pkg := builder.Package
+			if builder.AutoArchitecture {
+				fmt.Printf("Building package %s_%s (architecture %s, or %s if the payload has no ELF binaries)...\n",
+					pkg.Name, pkg.Version, pkg.Architecture, ArchitectureAll)
 			} else {
-				// Regular error setting script
-				return fmt.Errorf("failed to set maintainer script: %w", err)
+				fmt.Printf("Building package %s_%s_%s...\n", pkg.Name, pkg.Version, pkg.Architecture)
 			}
 		}
+
+		ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+		started := time.Now()
+
+		if options.DryRun {
+			return writePlan(ctx, builder, options.PlanFile)
+		}
+		var outputPath string
+		var report *BuildReport
+		if plan != nil {
+			outputPath, report, err = builder.ApplyPlan(ctx, plan)
+		} else {
+			outputPath, report, err = builder.Build(ctx)
+		}
+		summary := builder.Summary(outputPath)
+		if err != nil {
+			summary.SetError(err)
+			history.Record(os.Stdout, summary)
+			if report.BuildDir != "" {
+				fmt.Printf("Build directory kept for inspection: %s\n", report.BuildDir)
+			}
+			return ci.Errorf(ci.ClassBuild, "package build failed: %w", err)
+		}
+
+		fmt.Printf("Successfully created package: %s\n", outputPath)
+		if options.Verbose && builder.AutoArchitecture {
+			fmt.Printf("Package architecture: %s\n", builder.Package.Architecture)
+		}
+		if report.CachedFiles > 0 {
+			fmt.Printf("Reused %d unchanged files from %s\n", report.CachedFiles, builder.CacheDir)
+		}
+		if builder.DebugPackagePath != "" {
+			fmt.Printf("Debug symbols: %s\n", builder.DebugPackagePath)
+		}
+		if options.Report != "" {
+			reportPath := ReportPath(outputPath)
+			if err := report.WriteFile(reportPath); err != nil {
+				return err
+			}
+			fmt.Printf("Build report: %s\n", reportPath)
+		}
+		if options.DeltaFrom != "" {
+			result, err := CreateDelta(ctx, options.DeltaFrom, outputPath, "")
+			if err != nil {
+				return fmt.Errorf("failed to create delta: %w", err)
+			}
+			printDelta(result)
+		}
+		if options.Checksums {
+			for _, path := range []string{outputPath, builder.DebugPackagePath} {
+				if path == "" {
+					continue
+				}
+				written, err := writeChecksums(ctx, path)
+				if err != nil {
+					return fmt.Errorf("failed to write checksums: %w", err)
+				}
+				fmt.Printf("Checksums: %s\n", strings.Join(written, ", "))
+			}
+		}
+		for _, key := range signatureKeys {
+			for _, path := range []string{outputPath, builder.DebugPackagePath} {
+				if path == "" {
+					continue
+				}
+				sigPath, err := signature.Sign(key, path)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Signature: %s\n", sigPath)
+			}
+		}
+		if options.Provenance {
+			if err := writeProvenance(options, pkg, target.sourceDir, started, outputPath, builder.DebugPackagePath); err != nil {
+				return fmt.Errorf("failed to write provenance: %w", err)
+			}
+		}
+		if options.Torrent {
+			if err := writeTorrent(outputPath, options.Trackers, options.WebSeeds); err != nil {
+				return err
+			}
+		}
+		history.Record(os.Stdout, summary)
+		return nil
 	}
 
-	// Build the package with timeout
-	if options.Verbose {
-		fmt.Printf("Building package %s_%s...\n", options.PackageName, options.Version)
+	// All packages share the metadata, scripts and security settings
+	for _, target := range targets {
+		if err := build(target); err != nil {
+			if len(targets) > 1 {
+				return fmt.Errorf("%s: %w", target.arch, err)
+			}
+			return err
+		}
 	}
+	return nil
+}
 
-	outputPath, err := builder.BuildWithTimeout(defaultTimeout)
+// writePlan plans the build and writes the plan to path, or to stdout
+func writePlan(ctx context.Context, builder *Builder, path string) error {
+	plan, err := builder.Plan(ctx)
 	if err != nil {
-		return fmt.Errorf("package build failed: %w", err)
+		return ci.Wrap(ci.ClassBuild, fmt.Errorf("package plan failed: %w", err))
 	}
-
-	fmt.Printf("Successfully created package: %s\n", outputPath)
+	if path == "" {
+		return plan.WriteJSON(os.Stdout)
+	}
+	if err := plan.WriteFile(path); err != nil {
+		return err
+	}
+	fmt.Printf("Build plan for %s_%s_%s: %s (%d files, %d symlinks)\n",
+		plan.Name, plan.Version, plan.Architecture, path, plan.Sizes.Files, len(plan.Symlinks))
 	return nil
 }
 
+// buildTarget is a package to build: its architecture and payload directory
+type buildTarget struct {
+	arch      string
+	auto      bool // Whether the architecture may still switch to all
+	sourceDir string
+}
+
+// resolveBuildTargets returns the packages to build: one per entry of the
+// configuration file's architectures with --all-arches, or a single package
+// from --source otherwise. Relative payload directories of the entries are
+// relative to configDir, the directory of the configuration file.
+func resolveBuildTargets(options *BuildOptions, configArches map[string]string, configDir string) ([]buildTarget, error) {
+	if !options.AllArches {
+		arch, auto, err := ResolveArchitecture(options.Architecture, options.TargetArch)
+		if err != nil {
+			return nil, err
+		}
+		sourceDir, err := validatePath(options.SourceDir, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source directory: %w", err)
+		}
+		return []buildTarget{{arch: arch, auto: auto, sourceDir: sourceDir}}, nil
+	}
+
+	if len(configArches) == 0 {
+		return nil, ci.Errorf(ci.ClassUsage, "--all-arches requires an architectures section in the configuration file")
+	}
+	arches := make([]string, 0, len(configArches))
+	for arch := range configArches {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+
+	var targets []buildTarget
+	for _, arch := range arches {
+		if err := ValidateArchitecture(arch); err != nil {
+			return nil, err
+		}
+		sourceDir := configArches[arch]
+		if !filepath.IsAbs(sourceDir) {
+			sourceDir = filepath.Join(configDir, sourceDir)
+		}
+		sourceDir, err := validatePath(sourceDir, true)
+		if err != nil {
+			return nil, ci.Errorf(ci.ClassUsage, "invalid source directory for %s: %v", arch, err)
+		}
+		targets = append(targets, buildTarget{arch: arch, sourceDir: sourceDir})
+	}
+	return targets, nil
+}
+
+// importDebianOptions reads the debian/ directory of the source directory
+// and fills in the metadata and relations that neither the flags nor the
+// configuration file set
+func importDebianOptions(options *BuildOptions) (*DebianDir, error) {
+	imported, err := ImportDebianDir(filepath.Join(options.SourceDir, "debian"), options.PackageName)
+	if err != nil {
+		return nil, ci.Errorf(ci.ClassUsage, "--import-debian: %w", err)
+	}
+	for _, warning := range imported.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	pkg := imported.Package
+	for _, field := range []struct {
+		value    *string
+		imported string
+		unset    string
+	}{
+		{&options.PackageName, pkg.Name, ""},
+		{&options.Version, pkg.Version, ""},
+		{&options.Maintainer, pkg.Maintainer, ""},
+		{&options.Description, pkg.Description, ""},
+		{&options.Architecture, pkg.Architecture, ""},
+		{&options.Section, pkg.Section, "utils"},
+		{&options.Priority, pkg.Priority, "optional"},
+	} {
+		if (*field.value == "" || *field.value == field.unset) && field.imported != "" {
+			*field.value = field.imported
+		}
+	}
+	for _, field := range []struct {
+		value    *[]string
+		imported []string
+	}{
+		{&options.Depends, pkg.Depends},
+		{&options.Conflicts, imported.Conflicts},
+		{&options.Provides, imported.Provides},
+		{&options.Replaces, imported.Replaces},
+	} {
+		if len(*field.value) == 0 {
+			*field.value = field.imported
+		}
+	}
+	return imported, nil
+}
+
 // loadMaintainerScript reads a maintainer script file and determines its type
 func loadMaintainerScript(path string) (string, string, error) {
 	content, err := os.ReadFile(path)
@@ -299,20 +1053,407 @@ func validatePath(path string, mustExist bool) (string, error) {
 	return absPath, nil
 }
 
-// getDefaultArchitecture returns the default architecture based on the current system
-func getDefaultArchitecture() string {
-	arch := runtime.GOARCH
-	// Map Go architecture names to Debian architecture names
-	switch arch {
-	case "386":
-		return "i386"
-	case "amd64":
-		return "amd64"
-	case "arm":
-		return "armhf"
-	case "arm64":
-		return "arm64"
+// withTelemetry adds the metrics and tracing observers of one build to
+// observer. Without a progress observer, warnings are still logged to logger.
+func withTelemetry(ctx context.Context, observer BuildObserver, logger *slog.Logger, metrics *BuildMetrics, tracer *telemetry.Tracer) BuildObserver {
+	if metrics == nil && tracer == nil {
+		return observer
+	}
+	observers := MultiObserver{LogObserver{Logger: logger}}
+	if observer != nil {
+		observers = MultiObserver{observer}
+	}
+	if metrics != nil {
+		observers = append(observers, metrics.Observer())
+	}
+	if tracer != nil {
+		observers = append(observers, NewTracingObserver(ctx, tracer))
+	}
+	return observers
+}
+
+// newBuildObserver returns the progress observer for --log-format. The text
+// progress bar is only drawn on a terminal and never mixed with verbose logs.
+func newBuildObserver(format string, verbose bool) (BuildObserver, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && !verbose {
+			return NewProgressObserver(os.Stderr), nil
+		}
+		return nil, nil
+	case "json":
+		return NewJSONObserver(os.Stderr), nil
 	default:
-		return arch
+		return nil, fmt.Errorf("unknown log format: %s (available: text, json)", format)
+	}
+}
+
+// VerifyCommandOptions contains options for the verify command
+type VerifyCommandOptions struct {
+	Roots   []string
+	Scripts []string
+	Against string
+}
+
+// NewVerifyCommand creates a command that checks built packages for corruption
+// or tampering
+func NewVerifyCommand() *cobra.Command {
+	options := &VerifyCommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "verify [flags] <package.deb>...",
+		Short: "Check that built packages are intact",
+		Long: `Extract .deb packages and check that they are intact.
+
+The control file must parse and follow Debian policy, every payload file must
+match its md5sums entry, and no payload path may escape the package root.
+With --root the payload must stay under the given directories, and with
+--script the maintainer scripts must be identical to the given files.
+pkginstall build runs the same checks on every package it writes.
+
+A <package>.sha256 file next to the package must match the archive. With
+--against, the archive and every payload entry must match the checksum
+manifest pkginstall build wrote for it (<name>_<version>_<arch>.manifest.json).
+
+Examples:
+  pkginstall verify myapp_1.0_amd64.deb
+  pkginstall verify --root /opt --script postinst myapp_1.0_amd64.deb
+  pkginstall verify --against myapp_1.0_amd64.manifest.json myapp_1.0_amd64.deb
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerifyCommand(cmd.Context(), args, options)
+		},
 	}
+
+	cmd.Flags().StringSliceVar(&options.Roots, "root", nil, "Directory the payload must stay under (repeatable)")
+	cmd.Flags().StringSliceVar(&options.Scripts, "script", nil,
+		"Maintainer script file the packaged script must match; any other packaged script is reported (repeatable)")
+	cmd.Flags().StringVar(&options.Against, "against", "",
+		"Checksum manifest the package's archive and payload must match")
+	return cmd
+}
+
+// runVerifyCommand verifies each package and fails if any has problems
+func runVerifyCommand(ctx context.Context, paths []string, options *VerifyCommandOptions) error {
+	opts := VerifyOptions{Roots: options.Roots}
+	if len(options.Scripts) > 0 {
+		opts.Scripts = make(map[string]string)
+		for _, path := range options.Scripts {
+			content, name, err := loadMaintainerScript(path)
+			if err != nil {
+				return err
+			}
+			opts.Scripts[name] = content
+		}
+	}
+	if options.Against != "" {
+		if len(paths) > 1 {
+			return ci.Errorf(ci.ClassUsage, "--against checks a single package")
+		}
+		manifest, err := LoadChecksumManifest(options.Against)
+		if err != nil {
+			return ci.Wrap(ci.ClassUsage, err)
+		}
+		opts.Against = manifest
+	}
+
+	failed := 0
+	for _, path := range paths {
+		result, err := VerifyPackage(ctx, path, opts)
+		if err != nil {
+			return err
+		}
+		if len(result.Problems) == 0 {
+			fmt.Printf("OK: %s (%d files)\n", path, result.Files)
+			continue
+		}
+		failed++
+		fmt.Printf("FAILED: %s\n", path)
+		for _, problem := range result.Problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d package(s) failed verification", failed, len(paths))
+	}
+	return nil
+}
+
+// ConvertOptions contains options for the convert command
+type ConvertOptions struct {
+	OutputDir              string
+	Version                string
+	WorkDir                string
+	PolicyFile             string
+	Profile                string
+	TransformTarget        string
+	PerPackageDir          bool
+	AllowSystemPaths       []string
+	SymlinkDirs            []string
+	DisableSymlinks        bool
+	RelativeSymlinks       bool
+	StrictMode             bool
+	IgnoreScriptValidation bool
+	KeepBuildDir           bool
+	AllowRoot              bool
+	Verbose                bool
+}
+
+// NewConvertCommand creates a command that rebuilds third-party packages
+// with the path transformation and validation of pkginstall build
+func NewConvertCommand() *cobra.Command {
+	options := &ConvertOptions{OutputDir: "."}
+
+	cmd := &cobra.Command{
+		Use:   "convert [flags] <package.deb>",
+		Short: "Rebuild an existing .deb as a hardened package",
+		Long: `Rebuild a .deb that was not built by pkginstall with the same security model.
+
+The payload is unpacked and relocated like the source tree of pkginstall
+build: system paths move under /opt (or --transform-target) with install-time
+symlinks where they are needed. The maintainer scripts are validated again
+against the relocated paths, and the package metadata and relations are kept.
+Control fields and members that cannot be carried over, such as conffiles or
+Pre-Depends, are listed as warnings.
+
+Examples:
+  pkginstall convert vendor-tool_2.1_amd64.deb
+  pkginstall convert --version 2.1+hardened1 --profile strict -o dist vendor-tool_2.1_amd64.deb
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConvertCommand(cmd.Context(), args[0], options)
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.OutputDir, "output", "o", options.OutputDir, "Output directory for the converted .deb file")
+	cmd.Flags().StringVar(&options.Version, "version", "", "Version of the converted package (default: the original version)")
+	cmd.Flags().StringVar(&options.WorkDir, "work-dir", "", "Directory for build directories and temporary files (default: system temp dir)")
+	cmd.Flags().StringVar(&options.PolicyFile, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
+	cmd.Flags().StringVar(&options.TransformTarget, "transform-target", string(security.TargetOpt),
+		"Where system paths are relocated (opt, usr-local, srv)")
+	cmd.Flags().BoolVar(&options.PerPackageDir, "per-package-dir", false,
+		"Relocate into a per-package directory such as /opt/<name>")
+	cmd.Flags().StringSliceVar(&options.AllowSystemPaths, "allow-system-path", nil,
+		"Ship this path at its real location instead of transforming it; you accept the risk (repeatable)")
+	cmd.Flags().StringSliceVar(&options.SymlinkDirs, "symlink-dir", nil, "Additional directory where install-time symlinks may be created (repeatable)")
+	cmd.Flags().BoolVar(&options.DisableSymlinks, "disable-symlinks", false, "Disable automatic symlink creation")
+	cmd.Flags().BoolVar(&options.RelativeSymlinks, "relative-symlinks", false,
+		"Create install-time symlinks with relative paths (../../opt/...)")
+	cmd.Flags().BoolVar(&options.StrictMode, "strict", false, "Enable strict security validation (high-security checks, warnings fail the build)")
+	cmd.Flags().BoolVar(&options.IgnoreScriptValidation, "ignore-script-validation", false,
+		"Ignore script validation failures (NOT RECOMMENDED)")
+	cmd.Flags().BoolVar(&options.KeepBuildDir, "keep-build-dir", false, "Keep the build directory for inspection when the build fails")
+	cmd.Flags().BoolVar(&options.AllowRoot, "allow-root", false, privilege.AllowRootUsage)
+	cmd.Flags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
+	return cmd
+}
+
+// runConvertCommand unpacks a .deb and builds it again with the selected
+// security settings
+func runConvertCommand(ctx context.Context, debPath string, options *ConvertOptions) error {
+	if err := privilege.CheckRoot(options.AllowRoot); err != nil {
+		return err
+	}
+	transformTarget, err := security.ParseTransformTarget(options.TransformTarget)
+	if err != nil {
+		return err
+	}
+	profile, err := security.LookupProfile(options.Profile)
+	if err != nil {
+		return err
+	}
+	var policy *security.PolicyFile
+	var policySymlinkDirs []string
+	if options.PolicyFile != "" {
+		policy, err = security.LoadPolicyFile(options.PolicyFile)
+		if err != nil {
+			return err
+		}
+		policySymlinkDirs = policy.PathMapping.SymlinkDirs
+	}
+	outputDir, err := validatePath(options.OutputDir, false)
+	if err != nil {
+		return fmt.Errorf("invalid output directory: %w", err)
+	}
+
+	stageDir, err := os.MkdirTemp(options.WorkDir, "pkginstall-convert-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stageDir)
+	// The staging directory becomes the package root
+	if err := os.Chmod(stageDir, 0755); err != nil {
+		return fmt.Errorf("failed to prepare staging directory: %w", err)
+	}
+
+	extracted, err := ExtractPackage(ctx, debPath, stageDir)
+	if err != nil {
+		return err
+	}
+	for _, warning := range extracted.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	pkg := extracted.Package
+	if options.Version != "" {
+		pkg.Version = options.Version
+	}
+
+	var builderOpts []BuilderOption
+	if options.WorkDir != "" {
+		builderOpts = append(builderOpts, WithWorkDir(options.WorkDir))
+	}
+	builder, err := NewBuilder(pkg, stageDir, outputDir, builderOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %w", err)
+	}
+	builder.Verbose = options.Verbose
+	builder.KeepBuildDir = options.KeepBuildDir
+	builder.DisableSymlinks = options.DisableSymlinks
+	builder.RelativeSymlinks = options.RelativeSymlinks
+	layout := &security.PathLayout{
+		Target:     transformTarget,
+		Package:    pkg.Name,
+		PerPackage: options.PerPackageDir,
+		Exempt:     options.AllowSystemPaths,
+	}
+	if err := builder.ApplyLayout(layout); err != nil {
+		return fmt.Errorf("invalid path layout: %w", err)
+	}
+	builder.ApplyProfile(profile)
+	if policy != nil {
+		builder.ApplyPolicy(policy)
+	}
+	if options.StrictMode {
+		builder.EnableStrictMode()
+	}
+	builder.SetSymlinkDirs(security.ResolveSymlinkDirs(policySymlinkDirs, options.SymlinkDirs))
+
+	if err := extracted.Apply(builder, options.IgnoreScriptValidation); err != nil {
+		var scriptErr *ScriptValidationError
+		if errors.As(err, &scriptErr) {
+			return fmt.Errorf("%w\n\nTo bypass script validation, use the --ignore-script-validation flag (not recommended)", err)
+		}
+		return fmt.Errorf("failed to set maintainer script: %w", err)
+	}
+
+	fmt.Printf("Converting %s_%s_%s...\n", pkg.Name, extracted.Control["Version"], pkg.Architecture)
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	outputPath, report, err := builder.Build(ctx)
+	summary := builder.Summary(outputPath)
+	if err != nil {
+		summary.SetError(err)
+		history.Record(os.Stdout, summary)
+		if report.BuildDir != "" {
+			fmt.Printf("Build directory kept for inspection: %s\n", report.BuildDir)
+		}
+		return ci.Errorf(ci.ClassBuild, "package conversion failed: %w", err)
+	}
+
+	fmt.Printf("Successfully created package: %s\n", outputPath)
+	history.Record(os.Stdout, summary)
+	return nil
+}
+
+// DeltaOptions contains options for the delta subcommands
+type DeltaOptions struct {
+	Output string
+}
+
+// NewDeltaCommand creates a command that writes and applies binary deltas
+// between two versions of a package
+func NewDeltaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delta",
+		Short: "Create and apply binary deltas between package versions",
+		Long: `Ship updates as the difference between two versions of a .deb.
+
+A delta holds only the bytes of the new package that are not in the old one,
+which makes updates over slow links such as I2P much smaller. Applying it to
+the old package rebuilds the new .deb byte for byte; both packages are
+checked against the checksums recorded in the delta.
+
+Examples:
+  pkginstall delta create myapp_1.0_amd64.deb myapp_1.1_amd64.deb
+  pkginstall delta apply myapp_1.0_amd64.deb myapp_1.0_1.1_amd64.pkgdelta
+  pkginstall build --delta-from dist/myapp_1.0_amd64.deb -n myapp -v 1.1 ...
+`,
+	}
+	cmd.AddCommand(newDeltaCreateCommand(), newDeltaApplyCommand())
+	return cmd
+}
+
+func newDeltaCreateCommand() *cobra.Command {
+	options := &DeltaOptions{}
+	cmd := &cobra.Command{
+		Use:   "create [flags] <old.deb> <new.deb>",
+		Short: "Write the delta from one version of a package to another",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := CreateDelta(cmd.Context(), args[0], args[1], options.Output)
+			if err != nil {
+				return err
+			}
+			printDelta(result)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&options.Output, "output", "o", "",
+		"Delta file to write (default: <name>_<old>_<new>_<arch>"+DeltaExtension+" next to the new package)")
+	return cmd
+}
+
+func newDeltaApplyCommand() *cobra.Command {
+	options := &DeltaOptions{}
+	cmd := &cobra.Command{
+		Use:   "apply [flags] <old.deb> <delta>",
+		Short: "Rebuild the new version of a package from the old one and a delta",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputPath, info, err := ApplyDelta(cmd.Context(), args[0], args[1], options.Output)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Rebuilt %s %s: %s\n", info.Package, info.NewVersion, outputPath)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&options.Output, "output", "o", "",
+		"Package file to write (default: <name>_<new>_<arch>.deb next to the old package)")
+	return cmd
+}
+
+// printDelta reports a delta and its size relative to the full package
+func printDelta(result *DeltaResult) {
+	percent := 0.0
+	if result.NewSize > 0 {
+		percent = float64(result.Size) * 100 / float64(result.NewSize)
+	}
+	fmt.Printf("Delta from %s to %s: %s (%d bytes, %.1f%% of the package)\n",
+		result.OldVersion, result.NewVersion, result.Path, result.Size, percent)
+}
+
+// writeTorrent writes <package>.torrent next to a built package and prints
+// its magnet link
+func writeTorrent(packagePath string, trackers, webSeeds []string) error {
+	t, err := torrent.Create(packagePath, torrent.Options{
+		Trackers:  trackers,
+		WebSeeds:  webSeeds,
+		CreatedBy: "pkginstall",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create torrent: %w", err)
+	}
+	path := packagePath + torrent.Extension
+	if err := t.WriteFile(path); err != nil {
+		return err
+	}
+	fmt.Printf("Torrent: %s (%d pieces of %d bytes)\n", path, (t.Length+t.PieceLength-1)/t.PieceLength, t.PieceLength)
+	fmt.Printf("Magnet link: %s\n", t.Magnet())
+	return nil
 }
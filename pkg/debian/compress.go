@@ -0,0 +1,110 @@
+package debian
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// compressedSuffixes are extensions of files that are already compressed
+var compressedSuffixes = []string{".gz", ".bz2", ".xz", ".lzma", ".Z", ".zst"}
+
+// changelogNames are the files in /usr/share/doc/<package> that Debian policy
+// requires to be compressed
+var changelogNames = map[string]bool{
+	"changelog":        true,
+	"changelog.Debian": true,
+	"NEWS.Debian":      true,
+}
+
+// needsCompression reports whether a file at systemPath, before
+// transformation, is a man page or changelog that is shipped gzip-compressed
+func needsCompression(systemPath string) bool {
+	systemPath = filepath.ToSlash(systemPath)
+	for _, suffix := range compressedSuffixes {
+		if strings.HasSuffix(systemPath, suffix) {
+			return false
+		}
+	}
+	if strings.HasPrefix(systemPath, "/usr/share/man/") {
+		return true
+	}
+	dir, name := path.Split(systemPath)
+	return changelogNames[name] && path.Dir(path.Clean(dir)) == "/usr/share/doc"
+}
+
+// compresses reports whether srcPath is packaged gzip-compressed with a .gz
+// suffix. Symlinks follow their target, so a link to a compressed man page
+// gets the suffix on both its name and its target.
+func (b *Builder) compresses(srcPath string, info os.FileInfo) bool {
+	systemPath := b.systemPath(srcPath)
+	if !b.CompressDocs || !needsCompression(systemPath) {
+		return false
+	}
+	if info.Mode().IsRegular() {
+		return true
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return false
+	}
+	resolved := filepath.ToSlash(target)
+	if !path.IsAbs(resolved) {
+		resolved = path.Join(path.Dir(filepath.ToSlash(systemPath)), resolved)
+	}
+	targetInfo, err := os.Lstat(filepath.Join(b.SourceDir, filepath.FromSlash(resolved)))
+	return err == nil && targetInfo.Mode().IsRegular() && needsCompression(resolved)
+}
+
+// compressFile writes srcPath compressed like gzip -9n, without a file name or
+// timestamp, to a temporary file in tmpDir. cleanup removes the temporary file.
+func compressFile(ctx context.Context, tmpDir, srcPath string) (string, func(), error) {
+	none := func() {}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", none, fmt.Errorf("failed to open source file %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(tmpDir, "pkginstall-gzip-*")
+	if err != nil {
+		return "", none, fmt.Errorf("failed to create temporary file for compression: %w", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	gz, err := gzip.NewWriterLevel(tmp, gzip.BestCompression)
+	if err == nil {
+		_, err = io.Copy(gz, contextReader{ctx, src})
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", none, fmt.Errorf("failed to compress %s: %w", srcPath, err)
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// payloadContent returns the file whose content is packaged for srcPath: a
+// compressed, stripped or rewritten temporary copy, or srcPath itself.
+// cleanup removes any temporary copy.
+func (b *Builder) payloadContent(ctx context.Context, srcPath, packagePath string, info os.FileInfo) (string, func(), error) {
+	if b.compresses(srcPath, info) {
+		b.log("Compressing %s", packagePath)
+		return compressFile(ctx, b.WorkDir, srcPath)
+	}
+	return b.uncompressedContent(ctx, srcPath, packagePath)
+}
@@ -0,0 +1,133 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNeedsCompression(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/usr/share/man/man1/tool.1", true},
+		{"/usr/share/man/de/man1/tool.1", true},
+		{"/usr/share/man/man1/tool.1.gz", false},
+		{"/usr/share/doc/tool/changelog", true},
+		{"/usr/share/doc/tool/changelog.Debian", true},
+		{"/usr/share/doc/tool/NEWS.Debian", true},
+		{"/usr/share/doc/tool/README", false},
+		{"/usr/share/doc/tool/examples/changelog", false},
+		{"/usr/bin/changelog", false},
+	}
+
+	for _, tt := range tests {
+		if got := needsCompression(tt.path); got != tt.want {
+			t.Errorf("needsCompression(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompressDocs(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "compress-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	manDir := filepath.Join(srcDir, "usr", "share", "man", "man1")
+	docDir := filepath.Join(srcDir, "usr", "share", "doc", "tool")
+	for _, dir := range []string{manDir, docDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	manPage := []byte(".TH TOOL 1\n")
+	files := map[string][]byte{
+		filepath.Join(manDir, "tool.1"):    manPage,
+		filepath.Join(docDir, "changelog"): []byte("tool (1.0) unstable; urgency=low\n"),
+		filepath.Join(docDir, "README"):    []byte("readme\n"),
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.Symlink("tool.1", filepath.Join(manDir, "alias.1")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("tool", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+
+	t.Run("copy", func(t *testing.T) {
+		if err := builder.copyFiles(context.Background()); err != nil {
+			t.Fatalf("copyFiles() error = %v", err)
+		}
+		root := filepath.Join(builder.BuildDir, "opt")
+
+		compressed, err := ioutil.ReadFile(filepath.Join(root, "usr/share/man/man1/tool.1.gz"))
+		if err != nil {
+			t.Fatalf("Expected compressed man page: %v", err)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("Invalid gzip data: %v", err)
+		}
+		content, err := ioutil.ReadAll(gz)
+		if err != nil || !bytes.Equal(content, manPage) {
+			t.Errorf("Unexpected man page content %q, %v", content, err)
+		}
+		if gz.Name != "" || !gz.ModTime.IsZero() {
+			t.Errorf("Expected no name or timestamp in the gzip header, got %q %v", gz.Name, gz.ModTime)
+		}
+
+		if target, err := os.Readlink(filepath.Join(root, "usr/share/man/man1/alias.1.gz")); err != nil || target != "tool.1.gz" {
+			t.Errorf("Expected alias.1.gz -> tool.1.gz, got %q, %v", target, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "usr/share/doc/tool/changelog.gz")); err != nil {
+			t.Errorf("Expected compressed changelog: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "usr/share/doc/tool/README")); err != nil {
+			t.Errorf("Expected README to stay uncompressed: %v", err)
+		}
+		if _, ok := builder.md5sums["/opt/usr/share/man/man1/tool.1.gz"]; !ok {
+			t.Errorf("Expected md5sum for the compressed man page")
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := builder.streamData(context.Background(), &buf); err != nil {
+			t.Fatalf("streamData() error = %v", err)
+		}
+		entries := readTarGz(t, buf.Bytes())
+		if header := entries["./opt/usr/share/man/man1/tool.1.gz"]; header == nil || header.Typeflag != tar.TypeReg {
+			t.Errorf("Expected compressed man page, got %+v", header)
+		}
+		if header := entries["./opt/usr/share/man/man1/alias.1.gz"]; header == nil || header.Linkname != "tool.1.gz" {
+			t.Errorf("Expected alias.1.gz -> tool.1.gz, got %+v", header)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		builder.CompressDocs = false
+		builder.PackagedFiles = nil
+		var buf bytes.Buffer
+		if err := builder.streamData(context.Background(), &buf); err != nil {
+			t.Fatalf("streamData() error = %v", err)
+		}
+		if entries := readTarGz(t, buf.Bytes()); entries["./opt/usr/share/man/man1/tool.1"] == nil {
+			t.Errorf("Expected uncompressed man page")
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package debian
+
+import (
+	"io"
+
+	"github.com/go-i2p/go-pkginstall/pkg/control"
+)
+
+// ControlField is a field of a control file
+type ControlField = control.Field
+
+// Paragraph is a paragraph (stanza) of a control file; see control.Paragraph
+type Paragraph = control.Paragraph
+
+// ParseControl reads the paragraphs of an RFC 822 style control file; see
+// control.Parse
+func ParseControl(r io.Reader) ([]Paragraph, error) {
+	return control.Parse(r)
+}
+
+// ParseControlParagraph parses a control file that holds exactly one
+// paragraph, such as DEBIAN/control; see control.ParseParagraph
+func ParseControlParagraph(content string) (*Paragraph, error) {
+	return control.ParseParagraph(content)
+}
@@ -0,0 +1,48 @@
+package debian
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestControlRoundTrip(t *testing.T) {
+	pkg := NewPackage("app", "1.0-1", "amd64", "Test <test@example.com>", "d", "utils", "optional",
+		[]string{"libc6 (>= 2.31)", "libfoo [arm64]"})
+	builder := &Builder{Package: pkg, Provides: []string{"app-api"}}
+	builder.installedSize = 42
+
+	control, err := builder.generateControlFile()
+	if err != nil {
+		t.Fatalf("generateControlFile() error = %v", err)
+	}
+	paragraph, err := ParseControlParagraph(control)
+	if err != nil {
+		t.Fatalf("ParseControlParagraph() error = %v", err)
+	}
+	want := map[string]string{
+		"Package":        "app",
+		"Version":        "1.0-1",
+		"Architecture":   "amd64",
+		"Maintainer":     "Test <test@example.com>",
+		"Description":    "d",
+		"Section":        "utils",
+		"Priority":       "optional",
+		"Depends":        "libc6 (>= 2.31)",
+		"Provides":       "app-api",
+		"Installed-Size": "42",
+		"Homepage":       "https://github.com/go-i2p/go-pkginstall",
+	}
+	if got := paragraph.Map(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parsed control fields = %v, want %v", got, want)
+	}
+	if paragraph.String() != control {
+		t.Errorf("String() = %q, want %q", paragraph.String(), control)
+	}
+
+	paragraph.Set("description", "d\n extended")
+	paragraph.Set("Multi-Arch", "foreign")
+	reparsed, err := ParseControlParagraph(paragraph.String())
+	if err != nil || !reflect.DeepEqual(reparsed, paragraph) {
+		t.Errorf("Round trip of %q = %v, %v", paragraph.String(), reparsed, err)
+	}
+}
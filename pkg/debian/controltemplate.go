@@ -0,0 +1,178 @@
+package debian
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// controlFieldNames are the fields the builder generates, in their default
+// order
+var controlFieldNames = []string{
+	"Package", "Version", "Architecture", "Maintainer", "Description",
+	"Section", "Priority", "Depends", "Conflicts", "Provides", "Replaces",
+	"Installed-Size", "Homepage",
+}
+
+// ControlData is the data a control file template is executed with. The
+// relationship fields are formatted as in the generated control file.
+//
+//	control_template: |
+//	  {{.Generated}}Multi-Arch: foreign
+//	  X-Upstream-Version: {{.Version}}
+type ControlData struct {
+	Package       string
+	Version       string
+	Architecture  string
+	Maintainer    string
+	Description   string
+	Section       string
+	Priority      string
+	Depends       string
+	Conflicts     string
+	Provides      string
+	Replaces      string
+	InstalledSize int64
+	Homepage      string
+
+	Fields    []ControlField // The generated fields, in the configured order
+	Generated string         // The control file the builder would write without a template
+}
+
+// Field returns the value of the generated field name, or "" if it is not
+// generated
+func (d ControlData) Field(name string) string {
+	for _, field := range d.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// SetControlFieldOrder sets the order of the generated control fields. The
+// listed fields come first, in the given order, and the others follow in the
+// default order. Names are matched case-insensitively.
+func (b *Builder) SetControlFieldOrder(order []string) error {
+	seen := make(map[string]bool)
+	var canonical []string
+	for _, name := range order {
+		known := ""
+		for _, field := range controlFieldNames {
+			if strings.EqualFold(field, name) {
+				known = field
+			}
+		}
+		if known == "" {
+			return fmt.Errorf("unknown control field %q in the field order (available: %s)", name, strings.Join(controlFieldNames, ", "))
+		}
+		if seen[known] {
+			return fmt.Errorf("control field %s is listed twice in the field order", known)
+		}
+		seen[known] = true
+		canonical = append(canonical, known)
+	}
+	b.ControlFieldOrder = canonical
+	return nil
+}
+
+// SetControlTemplate parses the text/template the control file is rendered
+// with, from ControlData. An empty template restores the generated file.
+func (b *Builder) SetControlTemplate(text string) error {
+	if strings.TrimSpace(text) == "" {
+		b.controlTemplate = nil
+		return nil
+	}
+	tmpl, err := template.New("control").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid control template: %w", err)
+	}
+	b.controlTemplate = tmpl
+	return nil
+}
+
+// controlData returns the generated control fields, in the configured order,
+// as template data
+func (b *Builder) controlData() ControlData {
+	data := ControlData{
+		Package:       b.Package.Name,
+		Version:       b.Package.Version,
+		Architecture:  b.Package.Architecture,
+		Maintainer:    b.Package.Maintainer,
+		Description:   b.Package.Description,
+		Section:       b.Package.Section,
+		Priority:      b.Package.Priority,
+		Depends:       b.relationField(b.Package.Depends),
+		Conflicts:     b.relationField(b.Conflicts),
+		Provides:      b.relationField(b.Provides),
+		Replaces:      b.relationField(b.Replaces),
+		InstalledSize: int64(b.calculateInstalledSize()),
+		Homepage:      "https://github.com/go-i2p/go-pkginstall",
+	}
+
+	values := map[string]string{
+		"Package":        data.Package,
+		"Version":        data.Version,
+		"Architecture":   data.Architecture,
+		"Maintainer":     data.Maintainer,
+		"Description":    data.Description,
+		"Section":        data.Section,
+		"Priority":       data.Priority,
+		"Depends":        data.Depends,
+		"Conflicts":      data.Conflicts,
+		"Provides":       data.Provides,
+		"Replaces":       data.Replaces,
+		"Installed-Size": fmt.Sprintf("%d", data.InstalledSize),
+		"Homepage":       data.Homepage,
+	}
+	ordered := make(map[string]bool)
+	for _, name := range append(append([]string{}, b.ControlFieldOrder...), controlFieldNames...) {
+		if ordered[name] {
+			continue
+		}
+		ordered[name] = true
+		// Optional fields are left out when empty
+		if value := values[name]; value != "" {
+			data.Fields = append(data.Fields, ControlField{Name: name, Value: value})
+		}
+	}
+
+	data.Generated = (&Paragraph{Fields: data.Fields}).String()
+	return data
+}
+
+// renderControlTemplate executes the control template and checks that the
+// result is a control file that still describes the package being built
+func (b *Builder) renderControlTemplate(data ControlData) (string, error) {
+	var buf bytes.Buffer
+	if err := b.controlTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render control template: %w", err)
+	}
+	content := strings.TrimRight(buf.String(), "\n") + "\n"
+	if err := checkControlFile(content, data); err != nil {
+		return "", fmt.Errorf("control template: %w", err)
+	}
+	return content, nil
+}
+
+// checkControlFile checks that content is a single control paragraph with
+// the required fields, and that the fields that name the package file match
+// the generated ones
+func checkControlFile(content string, data ControlData) error {
+	paragraph, err := ParseControlParagraph(content)
+	if err != nil {
+		return err
+	}
+	for _, name := range requiredControlFields {
+		if paragraph.Value(name) == "" {
+			return fmt.Errorf("missing required field %s", name)
+		}
+	}
+	for _, name := range []string{"Package", "Version", "Architecture"} {
+		if got, want := paragraph.Value(name), data.Field(name); got != want {
+			return fmt.Errorf("field %s is %q, but the package is built as %q", name, got, want)
+		}
+	}
+	return ValidateMaintainer(paragraph.Value("Maintainer"))
+}
@@ -0,0 +1,81 @@
+package debian
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestControlFieldOrder(t *testing.T) {
+	pkg := NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", []string{"libc6"})
+	builder := &Builder{Package: pkg}
+
+	if err := builder.SetControlFieldOrder([]string{"section", "Installed-Size"}); err != nil {
+		t.Fatalf("SetControlFieldOrder() error = %v", err)
+	}
+	control, err := builder.generateControlFile()
+	if err != nil {
+		t.Fatalf("generateControlFile() error = %v", err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(control), "\n") {
+		names = append(names, strings.SplitN(line, ":", 2)[0])
+	}
+	want := "Section Installed-Size Package Version Architecture Maintainer Description Priority Depends Homepage"
+	if strings.Join(names, " ") != want {
+		t.Errorf("Field order = %v, want %s", names, want)
+	}
+
+	for _, order := range [][]string{{"X-Custom"}, {"Package", "package"}} {
+		if err := builder.SetControlFieldOrder(order); err == nil {
+			t.Errorf("SetControlFieldOrder(%v) expected an error", order)
+		}
+	}
+}
+
+func TestControlTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "Extra fields",
+			template: "{{.Generated}}Multi-Arch: foreign\nX-Upstream: {{.Version}}\n",
+			want:     []string{"Package: app\n", "Multi-Arch: foreign\n", "X-Upstream: 1.0\n"},
+		},
+		{
+			name: "Own layout",
+			template: "Package: {{.Package}}\nVersion: {{.Version}}\nArchitecture: {{.Architecture}}\n" +
+				"Maintainer: {{.Maintainer}}\nDepends: {{.Field \"Depends\"}}\nDescription: {{.Description}}\n extended\n\n",
+			want: []string{"Depends: libc6\nDescription: d\n extended\n"},
+		},
+		{name: "Syntax error", template: "{{.Package", wantErr: true},
+		{name: "Unknown value", template: "{{.Generated}}X: {{.Missing}}", wantErr: true},
+		{name: "Version mismatch", template: "Package: app\nVersion: 2.0\nArchitecture: all\nMaintainer: Test <t@example.com>\nDescription: d\n", wantErr: true},
+		{name: "Missing field", template: "Package: app\nVersion: 1.0\nArchitecture: all\nDescription: d\n", wantErr: true},
+		{name: "Two paragraphs", template: "{{.Generated}}\nPackage: other\n", wantErr: true},
+		{name: "Duplicate field", template: "{{.Generated}}package: app\n", wantErr: true},
+		{name: "Not a field", template: "{{.Generated}}no colon here\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg := NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", []string{"libc6"})
+			builder := &Builder{Package: pkg}
+			err := builder.SetControlTemplate(tt.template)
+			if err == nil {
+				var control string
+				control, err = builder.generateControlFile()
+				for _, want := range tt.want {
+					if !strings.Contains(control, want) {
+						t.Errorf("generateControlFile() = %q, want it to contain %q", control, want)
+					}
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package debian
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// convertedFields are the control fields a converted package keeps; the
+// others are generated again or reported as dropped
+var convertedFields = map[string]bool{
+	"Package": true, "Version": true, "Architecture": true, "Maintainer": true,
+	"Description": true, "Section": true, "Priority": true,
+	"Depends": true, "Conflicts": true, "Provides": true, "Replaces": true,
+	"Installed-Size": true, "Source": true,
+}
+
+// ExtractedPackage is a .deb unpacked by ExtractPackage to be built again
+type ExtractedPackage struct {
+	Package   *Package
+	Control   map[string]string // All fields of the control file
+	Conflicts []string
+	Provides  []string
+	Replaces  []string
+	Scripts   map[string]string // Maintainer scripts
+	Root      string            // Directory holding the payload
+	Warnings  []string          // What the converted package cannot carry over
+}
+
+// ExtractPackage unpacks the payload of the .deb at debPath into dir and
+// reads its metadata and maintainer scripts. Payload paths that escape dir,
+// directly or through a symlink in the payload, are an error; device nodes
+// and FIFOs are skipped with a warning.
+func ExtractPackage(ctx context.Context, debPath, dir string) (*ExtractedPackage, error) {
+	f, err := os.Open(debPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer f.Close()
+
+	members, err := readArIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", debPath, err)
+	}
+
+	extracted := &ExtractedPackage{Root: dir}
+	control, err := openMember(ctx, debPath, members, "control.tar")
+	if err != nil {
+		return nil, err
+	}
+	err = extracted.readControl(control)
+	if closeErr := control.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read control archive of %s: %w", debPath, err)
+	}
+
+	data, err := openMember(ctx, debPath, members, "data.tar")
+	if err != nil {
+		return nil, err
+	}
+	err = extracted.extractPayload(data)
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract %s: %w", debPath, err)
+	}
+	return extracted, nil
+}
+
+// readControl reads the control fields and maintainer scripts
+func (e *ExtractedPackage) readControl(r io.Reader) error {
+	e.Scripts = make(map[string]string)
+	var dropped []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		switch name := path.Base(path.Clean(header.Name)); name {
+		case "control":
+			paragraph, err := ParseControlParagraph(string(content))
+			if err != nil {
+				return fmt.Errorf("invalid control file: %w", err)
+			}
+			e.Control = paragraph.Map()
+		case "preinst", "postinst", "prerm", "postrm":
+			e.Scripts[name] = string(content)
+		case "md5sums":
+			// Generated again for the converted payload
+		default:
+			dropped = append(dropped, name)
+		}
+	}
+	if e.Control == nil {
+		return fmt.Errorf("the control archive has no control file")
+	}
+	for _, field := range requiredControlFields {
+		if e.Control[field] == "" {
+			return fmt.Errorf("the control file has no %s field", field)
+		}
+	}
+
+	relations := func(field string) []string {
+		var entries []string
+		for _, entry := range strings.Split(e.Control[field], ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+	e.Package = NewPackage(e.Control["Package"], e.Control["Version"], e.Control["Architecture"],
+		e.Control["Maintainer"], e.Control["Description"], e.Control["Section"], e.Control["Priority"],
+		relations("Depends"))
+	e.Conflicts = relations("Conflicts")
+	e.Provides = relations("Provides")
+	e.Replaces = relations("Replaces")
+
+	var fields []string
+	for field := range e.Control {
+		if !convertedFields[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	if len(fields) > 0 {
+		e.warn("control fields not carried over: %s", strings.Join(fields, ", "))
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		if name == "conffiles" {
+			e.warn("conffiles are not carried over; relocated configuration files are plain files")
+			continue
+		}
+		e.warn("control member %s is not carried over", name)
+	}
+	return nil
+}
+
+// extractPayload writes the entries of the data archive below e.Root
+func (e *ExtractedPackage) extractPayload(r io.Reader) error {
+	links := make(map[string]bool)
+	// belowLink returns the payload symlink that p lies below, if any
+	belowLink := func(p string) string {
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			if links[dir] {
+				return dir
+			}
+		}
+		return ""
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.Contains("/"+header.Name+"/", "/../") {
+			return fmt.Errorf("%s escapes the package root", header.Name)
+		}
+		name := path.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+		// Later entries must not be written through a symlink of the payload
+		if link := belowLink(name); link != "" {
+			return fmt.Errorf("%s lies below the symlink %s", name, link)
+		}
+		if header.Typeflag == tar.TypeDir && links[name] {
+			return fmt.Errorf("directory %s replaces the symlink %s", name, name)
+		}
+		dest := filepath.Join(e.Root, filepath.FromSlash(name))
+		mode := header.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+
+		if header.Typeflag != tar.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", name, err)
+			}
+			if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to replace %s: %w", name, err)
+			}
+			delete(links, name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			if err := os.Chmod(dest, mode|0700); err != nil {
+				return fmt.Errorf("failed to set mode of %s: %w", name, err)
+			}
+		case tar.TypeReg:
+			out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", name, err)
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return fmt.Errorf("failed to write %s: %w", name, err)
+			}
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("failed to write %s: %w", name, err)
+			}
+			// Set after writing, since the umask would drop setuid bits
+			if err := os.Chmod(dest, mode); err != nil {
+				return fmt.Errorf("failed to set mode of %s: %w", name, err)
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, dest); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", name, err)
+			}
+			links[name] = true
+		case tar.TypeLink:
+			target := path.Clean("/" + header.Linkname)
+			if strings.Contains("/"+header.Linkname+"/", "/../") || links[target] || belowLink(target) != "" {
+				return fmt.Errorf("hard link %s points to %s, which is not a payload file", name, header.Linkname)
+			}
+			if err := os.Link(filepath.Join(e.Root, filepath.FromSlash(target)), dest); err != nil {
+				return fmt.Errorf("failed to create hard link %s: %w", name, err)
+			}
+		default:
+			e.warn("%s is not a regular file, directory or link; not converted", name)
+		}
+	}
+}
+
+// warn records something the converted package does not carry over
+func (e *ExtractedPackage) warn(format string, args ...interface{}) {
+	e.Warnings = append(e.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Apply sets the relations and maintainer scripts of the extracted package
+// on a builder for its payload. Scripts are validated against the builder's
+// security settings, so apply its profile, layout and policy first. With
+// ignoreScriptValidation, scripts that fail validation are packaged anyway
+// and recorded as overrides.
+func (e *ExtractedPackage) Apply(b *Builder, ignoreScriptValidation bool) error {
+	b.SetConflicts(e.Conflicts)
+	b.SetProvides(e.Provides)
+	b.SetReplaces(e.Replaces)
+
+	return setMaintainerScripts(b, e.Scripts, ignoreScriptValidation)
+}
+
+// setMaintainerScripts validates and sets maintainer scripts on a builder.
+// With ignoreScriptValidation, scripts that fail validation are packaged
+// anyway and recorded as overrides.
+func setMaintainerScripts(b *Builder, scripts map[string]string, ignoreScriptValidation bool) error {
+	names := make([]string, 0, len(scripts))
+	for name := range scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := b.SetMaintainerScript(name, scripts[name])
+		if err == nil {
+			continue
+		}
+		var scriptErr *ScriptValidationError
+		if !ignoreScriptValidation || !errors.As(err, &scriptErr) {
+			return err
+		}
+		b.warn("%s script validation ignored: %v", name, err)
+		b.Scripts[name] = scripts[name]
+		b.RecordOverride(fmt.Sprintf("%s script validation ignored (--ignore-script-validation)", name))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package debian
+
+import (
+	"archive/tar"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractPackage(t *testing.T) {
+	const control = "Package: vendor-app\nVersion: 2.0-1\nArchitecture: all\nMaintainer: Vendor <vendor@example.com>\n" +
+		"Description: Vendor app\n Extended text.\nDepends: libc6\nConflicts: old-app\nPre-Depends: dpkg\n"
+
+	tests := []struct {
+		name    string
+		data    []testEntry
+		wantErr string
+	}{
+		{"Payload", []testEntry{
+			{name: "./usr/bin/app", content: "#!/bin/sh\n", typeflag: tar.TypeReg},
+			{name: "./usr/bin/app-link", linkname: "app", typeflag: tar.TypeSymlink},
+			{name: "./usr/bin/app-hard", linkname: "./usr/bin/app", typeflag: tar.TypeLink},
+		}, ""},
+		{"Parent directory", []testEntry{
+			{name: "./usr/../../etc/passwd", content: "x", typeflag: tar.TypeReg},
+		}, "escapes the package root"},
+		{"Write through symlink", []testEntry{
+			{name: "./usr/lib", linkname: "/etc", typeflag: tar.TypeSymlink},
+			{name: "./usr/lib/passwd", content: "x", typeflag: tar.TypeReg},
+		}, "lies below the symlink /usr/lib"},
+		{"Hard link through symlink", []testEntry{
+			{name: "./usr/lib", linkname: "/etc", typeflag: tar.TypeSymlink},
+			{name: "./usr/shadow", linkname: "./usr/lib/shadow", typeflag: tar.TypeLink},
+		}, "is not a payload file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "convert-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			debPath := filepath.Join(dir, "vendor-app_2.0-1_all.deb")
+			writeTestDeb(t, debPath, []testEntry{
+				{name: "./control", content: control, typeflag: tar.TypeReg},
+				{name: "./conffiles", content: "/etc/app.conf\n", typeflag: tar.TypeReg},
+				{name: "./postinst", content: "#!/bin/sh\nexit 0\n", typeflag: tar.TypeReg},
+			}, tt.data)
+			root := filepath.Join(dir, "root")
+
+			extracted, err := ExtractPackage(context.Background(), debPath, root)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ExtractPackage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExtractPackage() error = %v", err)
+			}
+
+			if extracted.Package.Name != "vendor-app" || extracted.Package.Description != "Vendor app\n Extended text." {
+				t.Errorf("Unexpected package: %+v", extracted.Package)
+			}
+			if len(extracted.Conflicts) != 1 || extracted.Conflicts[0] != "old-app" {
+				t.Errorf("Conflicts = %v", extracted.Conflicts)
+			}
+			if extracted.Scripts["postinst"] != "#!/bin/sh\nexit 0\n" {
+				t.Errorf("Scripts = %v", extracted.Scripts)
+			}
+			warnings := strings.Join(extracted.Warnings, "\n")
+			if !strings.Contains(warnings, "Pre-Depends") || !strings.Contains(warnings, "conffiles") {
+				t.Errorf("Unexpected warnings:\n%s", warnings)
+			}
+			if link, err := os.Readlink(filepath.Join(root, "usr", "bin", "app-link")); err != nil || link != "app" {
+				t.Errorf("Symlink = %q, %v", link, err)
+			}
+			app, _ := os.Stat(filepath.Join(root, "usr", "bin", "app"))
+			hard, _ := os.Stat(filepath.Join(root, "usr", "bin", "app-hard"))
+			if app == nil || hard == nil || !os.SameFile(app, hard) {
+				t.Errorf("Hard link was not recreated")
+			}
+		})
+	}
+}
+
+func TestConvertPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		postinst string
+		ignore   bool
+		wantErr  bool
+	}{
+		{"Valid script", "#!/bin/sh\nset -e\necho configured\n", false, false},
+		{"Rejected script", "#!/bin/sh\nrm -f /usr/bin/app\n", false, true},
+		{"Ignored validation", "#!/bin/sh\nrm -f /usr/bin/app\n", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "convert-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			debPath := filepath.Join(dir, "vendor-app_2.0-1_all.deb")
+			writeTestDeb(t, debPath, []testEntry{
+				{name: "./control", content: "Package: vendor-app\nVersion: 2.0-1\nArchitecture: all\n" +
+					"Maintainer: Vendor <vendor@example.com>\nDescription: Vendor app\nSection: utils\nPriority: optional\n", typeflag: tar.TypeReg},
+				{name: "./postinst", content: tt.postinst, typeflag: tar.TypeReg},
+			}, []testEntry{
+				{name: "./etc/", typeflag: tar.TypeDir},
+				{name: "./etc/app.conf", content: "x=1\n", typeflag: tar.TypeReg},
+			})
+			root := filepath.Join(dir, "root")
+			if err := os.Mkdir(root, 0755); err != nil {
+				t.Fatalf("Failed to create root: %v", err)
+			}
+
+			extracted, err := ExtractPackage(context.Background(), debPath, root)
+			if err != nil {
+				t.Fatalf("ExtractPackage() error = %v", err)
+			}
+			builder, err := NewBuilder(extracted.Package, root, filepath.Join(dir, "out"))
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			builder.DpkgRoot = root
+
+			err = extracted.Apply(builder, tt.ignore)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Apply() succeeded with a rejected script")
+				}
+				builder.Clean()
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if tt.ignore && len(builder.Overrides) != 1 {
+				t.Errorf("Overrides = %v", builder.Overrides)
+			}
+
+			outputPath, _, err := builder.Build(context.Background())
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			result, err := VerifyPackage(context.Background(), outputPath, VerifyOptions{
+				Files:   []string{"/opt/etc/app.conf"},
+				Scripts: map[string]string{"postinst": tt.postinst},
+			})
+			if err != nil {
+				t.Fatalf("VerifyPackage() error = %v", err)
+			}
+			if err := result.Err(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
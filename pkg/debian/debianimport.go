@@ -0,0 +1,255 @@
+package debian
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// debhelperToken marks where debhelper inserts its generated commands into
+// a maintainer script
+const debhelperToken = "#DEBHELPER#"
+
+// substvar matches a substitution variable such as ${shlibs:Depends}, which
+// dpkg-gencontrol fills in and the builder cannot
+var substvar = regexp.MustCompile(`\$\{[^}]*\}`)
+
+// changelogHeader matches the first line of debian/changelog:
+// package (version) distribution; urgency=medium
+var changelogHeader = regexp.MustCompile(`^([a-z0-9][a-z0-9+.-]+) \(([^)\s]+)\)`)
+
+// unimportedDebianFiles are debhelper files that the import does not apply,
+// each with its pkginstall counterpart
+var unimportedDebianFiles = map[string]string{
+	"dirs":     "create the directories in the source directory",
+	"links":    "ship the links in the source directory",
+	"triggers": "desktop triggers are generated with --desktop-triggers",
+	"tmpfiles": "declare state_dirs in the configuration file",
+	"service":  "ship the unit in the source directory",
+}
+
+// DebianDir is a debian/ packaging directory read by ImportDebianDir
+type DebianDir struct {
+	Dir       string
+	Package   *Package // Metadata of the imported binary package
+	Conflicts []string
+	Provides  []string
+	Replaces  []string
+	Scripts   map[string]string // Maintainer scripts, with #DEBHELPER# handled
+	Conffiles []string          // Paths listed in conffiles
+	Install   string            // The package's debian/install file, if any, which Apply stages the payload with
+	Warnings  []string          // What the import does not carry over
+}
+
+// ImportDebianDir reads debian/control, debian/changelog, the maintainer
+// scripts, conffiles and install file of the debian/ directory at dir. name
+// selects the binary package of a multi-package control file; an empty name
+// selects the first one. Substitution variables and architecture wildcards, which
+// dpkg-gencontrol resolves, are left out with a warning.
+func ImportDebianDir(dir, name string) (*DebianDir, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "control"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read debian/control: %w", err)
+	}
+	paragraphs, err := ParseControl(strings.NewReader(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid debian/control: %w", err)
+	}
+	if len(paragraphs) < 2 || paragraphs[0].Value("Source") == "" {
+		return nil, fmt.Errorf("debian/control must have a source paragraph followed by a binary package paragraph")
+	}
+	source := paragraphs[0]
+
+	d := &DebianDir{Dir: dir, Scripts: make(map[string]string)}
+	var binary *Paragraph
+	for i := range paragraphs[1:] {
+		p := &paragraphs[i+1]
+		switch {
+		case binary == nil && (name == "" || p.Value("Package") == name):
+			binary = p
+		default:
+			d.warn("binary package %s in debian/control is not imported", p.Value("Package"))
+		}
+	}
+	if binary == nil {
+		return nil, fmt.Errorf("debian/control has no binary package %s", name)
+	}
+	pkgName := binary.Value("Package")
+
+	field := func(name string) string {
+		if value := binary.Value(name); value != "" {
+			return value
+		}
+		return source.Value(name)
+	}
+	relations := func(name string) []string {
+		var entries []string
+		for _, entry := range strings.Split(binary.Value(name), ",") {
+			entry = strings.TrimSpace(entry)
+			if substvar.MatchString(entry) {
+				d.warn("%s entry %s is a substitution variable and is not imported", name, entry)
+				continue
+			}
+			if entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	d.Package = NewPackage(pkgName, "", d.architecture(binary.Value("Architecture")),
+		source.Value("Maintainer"), binary.Value("Description"), field("Section"), field("Priority"),
+		relations("Depends"))
+	d.Conflicts = relations("Conflicts")
+	d.Provides = relations("Provides")
+	d.Replaces = relations("Replaces")
+	if d.Package.Version, err = readChangelogVersion(filepath.Join(dir, "changelog"), source.Value("Source")); err != nil {
+		d.warn("%v", err)
+	}
+
+	// debhelper reads debian/<package>.<file>, or debian/<file> for the
+	// first binary package
+	first := binary == &paragraphs[1]
+	find := func(file string) string {
+		candidates := []string{pkgName + "." + file}
+		if first {
+			candidates = append(candidates, file)
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Lstat(filepath.Join(dir, candidate)); err == nil {
+				return filepath.Join(dir, candidate)
+			}
+		}
+		return ""
+	}
+	read := func(file string) (string, bool, error) {
+		found := find(file)
+		if found == "" {
+			return "", false, nil
+		}
+		content, err := os.ReadFile(found)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read debian/%s: %w", filepath.Base(found), err)
+		}
+		return string(content), true, nil
+	}
+
+	for _, script := range []string{"preinst", "postinst", "prerm", "postrm"} {
+		content, ok, err := read(script)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			d.Scripts[script] = debhelperScript(script, content)
+		}
+	}
+	conffiles, _, err := read("conffiles")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(conffiles, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			d.Conffiles = append(d.Conffiles, line)
+		}
+	}
+
+	d.Install = find("install")
+
+	files := make([]string, 0, len(unimportedDebianFiles))
+	for file := range unimportedDebianFiles {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if find(file) != "" {
+			d.warn("debian/%s is not imported; %s", file, unimportedDebianFiles[file])
+		}
+	}
+	return d, nil
+}
+
+// architecture returns the architecture of an imported binary package:
+// "all" or a single architecture as written, or "" for wildcards and lists,
+// so that it is detected from the payload
+func (d *DebianDir) architecture(arch string) string {
+	if arch == ArchitectureAll || ValidateArchitecture(arch) == nil {
+		return arch
+	}
+	if arch != "" && arch != "any" {
+		d.warn("architecture %q is not imported; the architecture is detected instead", arch)
+	}
+	return ""
+}
+
+// readChangelogVersion returns the version of the first entry of the
+// debian/changelog at path, which must be an entry of the source package
+func readChangelogVersion(path, source string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("no version imported: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		match := changelogHeader.FindStringSubmatch(line)
+		if match == nil || match[1] != source {
+			return "", fmt.Errorf("no version imported: the first line of debian/changelog is not an entry of %s", source)
+		}
+		return match[2], nil
+	}
+	return "", fmt.Errorf("no version imported: debian/changelog is empty")
+}
+
+// debhelperScript prepares a debhelper maintainer script for the builder:
+// the #DEBHELPER# token of postinst becomes the point where the builder
+// inserts its generated commands, and is dropped from the other scripts
+func debhelperScript(name, content string) string {
+	if name == "postinst" {
+		return strings.Replace(content, debhelperToken, postinstToken, 1)
+	}
+	var lines []string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if strings.TrimSpace(line) != debhelperToken {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// warn records something the import does not carry over
+func (d *DebianDir) warn(format string, args ...interface{}) {
+	d.Warnings = append(d.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Apply stages the payload with the install file of the import, if any, or
+// else leaves the debian/ directory out of the payload, and sets the
+// relations and maintainer scripts of the import on a builder, as
+// ExtractedPackage.Apply does. Conffiles are reported at their transformed
+// paths, where they are plain files.
+func (d *DebianDir) Apply(b *Builder, ignoreScriptValidation bool) error {
+	if d.Install != "" {
+		if err := b.SetInstallFile(d.Install); err != nil {
+			return err
+		}
+	} else if abs, err := filepath.Abs(d.Dir); err == nil {
+		b.AddExcludeDir(abs)
+	}
+	b.SetConflicts(d.Conflicts)
+	b.SetProvides(d.Provides)
+	b.SetReplaces(d.Replaces)
+	for _, conffile := range d.Conffiles {
+		target, _, err := b.PathMapper.TransformPath(conffile)
+		if err == nil && target != conffile {
+			b.warn("conffile %s is packaged at %s as a plain file; dpkg does not preserve local changes to it", conffile, target)
+		}
+	}
+	return setMaintainerScripts(b, d.Scripts, ignoreScriptValidation)
+}
@@ -0,0 +1,117 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportDebianDir(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "debian-import-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	files := map[string]string{
+		"debian/control": "Source: app\nSection: net\nPriority: optional\nMaintainer: Test <test@example.com>\n" +
+			"Build-Depends: debhelper-compat (= 13)\n\n" +
+			"Package: app\nArchitecture: any\nDepends: ${shlibs:Depends}, ${misc:Depends},\n libfoo (>= 1.0)\n" +
+			"Conflicts: app-legacy\nDescription: test application\n longer description\n\n" +
+			"Package: app-doc\nArchitecture: all\nDescription: docs\n",
+		"debian/changelog":    "app (1.2-1) unstable; urgency=medium\n\n  * Release.\n\n -- Test <test@example.com>  Mon, 01 Jan 2024 00:00:00 +0000\n",
+		"debian/postinst":     "#!/bin/sh\nset -e\necho configured\n#DEBHELPER#\nexit 0\n",
+		"debian/app.prerm":    "#!/bin/sh\nset -e\n#DEBHELPER#\nexit 0\n",
+		"debian/conffiles":    "/etc/app.conf\n",
+		"debian/install":      "build/app usr/bin\nconf/* etc/\n",
+		"build/app":           "#!/bin/sh\necho app\n",
+		"conf/app.conf":       "key=value\n",
+		"debian/app-doc.dirs": "usr/share/doc/app-doc\n",
+	}
+	for file, content := range files {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	imported, err := ImportDebianDir(filepath.Join(srcDir, "debian"), "")
+	if err != nil {
+		t.Fatalf("ImportDebianDir() error = %v", err)
+	}
+	pkg := imported.Package
+	if pkg.Name != "app" || pkg.Version != "1.2-1" || pkg.Architecture != "" || pkg.Maintainer != "Test <test@example.com>" ||
+		pkg.Section != "net" || pkg.Description != "test application\n longer description" {
+		t.Errorf("Imported package = %+v", pkg)
+	}
+	if !reflect.DeepEqual(pkg.Depends, []string{"libfoo (>= 1.0)"}) || !reflect.DeepEqual(imported.Conflicts, []string{"app-legacy"}) {
+		t.Errorf("Imported relations = %v, %v", pkg.Depends, imported.Conflicts)
+	}
+	if want := "#!/bin/sh\nset -e\necho configured\n#PKGINSTALL#\nexit 0\n"; imported.Scripts["postinst"] != want {
+		t.Errorf("postinst = %q, want %q", imported.Scripts["postinst"], want)
+	}
+	if want := "#!/bin/sh\nset -e\nexit 0\n"; imported.Scripts["prerm"] != want {
+		t.Errorf("prerm = %q, want %q", imported.Scripts["prerm"], want)
+	}
+	if !reflect.DeepEqual(imported.Conffiles, []string{"/etc/app.conf"}) {
+		t.Errorf("Conffiles = %v", imported.Conffiles)
+	}
+	warnings := strings.Join(imported.Warnings, "\n")
+	if imported.Install != filepath.Join(srcDir, "debian/install") {
+		t.Errorf("Install = %q", imported.Install)
+	}
+	for _, want := range []string{"${shlibs:Depends}", "app-doc"} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("Expected a warning about %s, got %v", want, imported.Warnings)
+		}
+	}
+	if strings.Contains(warnings, "dirs") {
+		t.Errorf("Files of other binary packages should not be reported: %v", imported.Warnings)
+	}
+
+	// The second binary package reads only its own files
+	doc, err := ImportDebianDir(filepath.Join(srcDir, "debian"), "app-doc")
+	if err != nil {
+		t.Fatalf("ImportDebianDir() error = %v", err)
+	}
+	if doc.Package.Architecture != "all" || len(doc.Scripts) != 0 || len(doc.Conffiles) != 0 || doc.Install != "" {
+		t.Errorf("Imported app-doc = %+v, scripts %v, conffiles %v", doc.Package, doc.Scripts, doc.Conffiles)
+	}
+	if _, err := ImportDebianDir(filepath.Join(srcDir, "debian"), "missing"); err == nil {
+		t.Error("Expected an error for a binary package that is not in debian/control")
+	}
+
+	pkg.Architecture = "all"
+	builder, err := NewBuilder(pkg, srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+	if err := imported.Apply(builder, false); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := builder.copyFiles(context.Background()); err != nil {
+		t.Fatalf("copyFiles() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(builder.BuildDir, "opt/debian")); !os.IsNotExist(err) {
+		t.Errorf("Expected debian/ to be left out of the payload, got %v", err)
+	}
+	for _, file := range []string{"opt/usr/bin/app", "opt/etc/app.conf"} {
+		if _, err := os.Stat(filepath.Join(builder.BuildDir, file)); err != nil {
+			t.Errorf("Expected the payload staged with debian/install to be packaged: %v", err)
+		}
+	}
+	if !strings.Contains(strings.Join(builder.Warnings, "\n"), "conffile /etc/app.conf is packaged at /opt/etc/app.conf") {
+		t.Errorf("Expected a warning about the relocated conffile, got %v", builder.Warnings)
+	}
+	if builder.Scripts["postinst"] != imported.Scripts["postinst"] || !reflect.DeepEqual(builder.Conflicts, []string{"app-legacy"}) {
+		t.Errorf("Apply() did not set the scripts and relations: %v, %v", builder.Scripts, builder.Conflicts)
+	}
+}
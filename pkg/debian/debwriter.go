@@ -0,0 +1,402 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// arMagic starts every ar archive, including .deb files
+const arMagic = "!<arch>\n"
+
+// arWriter writes the common ar archive format used by .deb files
+type arWriter struct {
+	w io.Writer
+}
+
+// newArWriter writes the archive signature and returns a writer for its members
+func newArWriter(w io.Writer) (*arWriter, error) {
+	if _, err := io.WriteString(w, arMagic); err != nil {
+		return nil, fmt.Errorf("failed to write archive header: %w", err)
+	}
+	return &arWriter{w: w}, nil
+}
+
+// writeMember writes a member of the given size read from r
+func (a *arWriter) writeMember(name string, size int64, modTime time.Time, r io.Reader) error {
+	if len(name) > 16 {
+		return fmt.Errorf("archive member name too long: %s", name)
+	}
+	header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, modTime.Unix(), 0, 0, 0100644, size)
+	if _, err := io.WriteString(a.w, header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", name, err)
+	}
+	if n, err := io.Copy(a.w, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	} else if n != size {
+		return fmt.Errorf("failed to write %s: wrote %d of %d bytes", name, n, size)
+	}
+	// Members are aligned to an even offset
+	if size%2 != 0 {
+		if _, err := io.WriteString(a.w, "\n"); err != nil {
+			return fmt.Errorf("failed to pad %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// tarArchive writes a gzip-compressed tar archive of a package filesystem.
+// Entries are owned by root and named "./path" as dpkg-deb does.
+type tarArchive struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+	dirs    map[string]bool // Directories already written
+	blocks  int64           // Installed-Size in KiB of the entries written
+}
+
+func newTarArchive(w io.Writer, modTime time.Time) *tarArchive {
+	gz := gzip.NewWriter(w)
+	return &tarArchive{
+		gz:      gz,
+		tw:      tar.NewWriter(gz),
+		modTime: modTime,
+		dirs:    make(map[string]bool),
+	}
+}
+
+// header returns a root-owned tar header for an absolute package path
+func (t *tarArchive) header(packagePath string, typeflag byte, mode os.FileMode, modTime time.Time) *tar.Header {
+	name := "." + path.Clean("/"+packagePath)
+	if typeflag == tar.TypeDir && name != "./" {
+		name += "/"
+	}
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Mode:     unixMode(mode),
+		ModTime:  modTime,
+		Uname:    "root",
+		Gname:    "root",
+		Format:   tar.FormatGNU,
+	}
+}
+
+// unixMode converts a mode to its numeric form, including the setuid, setgid
+// and sticky bits
+func unixMode(mode os.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// withAttrs applies preserved ownership and extended attributes to a header.
+// Non-root owners are stored numerically, since names may differ on the target.
+func withAttrs(header *tar.Header, attrs fileAttrs) *tar.Header {
+	header.Uid, header.Gid = attrs.uid, attrs.gid
+	if attrs.uid != 0 {
+		header.Uname = ""
+	}
+	if attrs.gid != 0 {
+		header.Gname = ""
+	}
+	if len(attrs.xattrs) > 0 {
+		header.Format = tar.FormatPAX
+		header.PAXRecords = make(map[string]string, len(attrs.xattrs))
+		for name, value := range attrs.xattrs {
+			header.PAXRecords["SCHILY.xattr."+name] = value
+		}
+	}
+	return header
+}
+
+// addDir writes a directory entry, creating any missing parents as root-owned
+// with mode 0755
+func (t *tarArchive) addDir(packagePath string, mode os.FileMode, attrs fileAttrs) error {
+	packagePath = path.Clean("/" + packagePath)
+	if t.dirs[packagePath] {
+		return nil
+	}
+	if packagePath != "/" {
+		if err := t.addDir(path.Dir(packagePath), 0755, fileAttrs{}); err != nil {
+			return err
+		}
+	}
+	if err := t.tw.WriteHeader(withAttrs(t.header(packagePath, tar.TypeDir, mode, t.modTime), attrs)); err != nil {
+		return fmt.Errorf("failed to write directory %s: %w", packagePath, err)
+	}
+	t.dirs[packagePath] = true
+	t.blocks++
+	return nil
+}
+
+// addFile writes a regular file read from srcPath and returns its size and MD5 checksum
+func (t *tarArchive) addFile(ctx context.Context, packagePath, srcPath string, mode os.FileMode, attrs fileAttrs) (int64, string, error) {
+	if err := t.addDir(path.Dir(packagePath), 0755, fileAttrs{}); err != nil {
+		return 0, "", err
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open source file %s: %w", srcPath, err)
+	}
+	defer srcFile.Close()
+
+	// Stat the opened file so symlinked sources report the size of their target
+	info, err := srcFile.Stat()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to stat source file %s: %w", srcPath, err)
+	}
+
+	header := withAttrs(t.header(packagePath, tar.TypeReg, mode, info.ModTime()), attrs)
+	header.Size = info.Size()
+	if err := t.tw.WriteHeader(header); err != nil {
+		return 0, "", fmt.Errorf("failed to write header for %s: %w", packagePath, err)
+	}
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(t.tw, hash), contextReader{ctx, srcFile})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to stream file content from %s: %w", srcPath, err)
+	}
+	t.blocks += fileBlocks(size)
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// addSymlink writes a symbolic link entry
+func (t *tarArchive) addSymlink(packagePath, target string, modTime time.Time, attrs fileAttrs) error {
+	if err := t.addDir(path.Dir(packagePath), 0755, fileAttrs{}); err != nil {
+		return err
+	}
+	header := withAttrs(t.header(packagePath, tar.TypeSymlink, 0777, modTime), attrs)
+	header.Linkname = target
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write symlink %s: %w", packagePath, err)
+	}
+	t.blocks += fileBlocks(int64(len(target)))
+	return nil
+}
+
+// addHardlink writes a hard link to an entry already in the archive
+func (t *tarArchive) addHardlink(packagePath, first string, mode os.FileMode, modTime time.Time, attrs fileAttrs) error {
+	if err := t.addDir(path.Dir(packagePath), 0755, fileAttrs{}); err != nil {
+		return err
+	}
+	header := withAttrs(t.header(packagePath, tar.TypeLink, mode, modTime), attrs)
+	header.Linkname = "." + path.Clean("/"+first)
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write hard link %s: %w", packagePath, err)
+	}
+	return nil
+}
+
+// addBytes writes a regular file with in-memory content
+func (t *tarArchive) addBytes(packagePath string, content []byte, mode os.FileMode) error {
+	header := t.header(packagePath, tar.TypeReg, mode, t.modTime)
+	header.Size = int64(len(content))
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", packagePath, err)
+	}
+	if _, err := t.tw.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", packagePath, err)
+	}
+	t.blocks += fileBlocks(header.Size)
+	return nil
+}
+
+// Close flushes the tar and gzip streams
+func (t *tarArchive) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if err := t.gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed archive: %w", err)
+	}
+	return nil
+}
+
+// streamData writes data.tar.gz straight from the source tree to w, applying
+// the same exclusion, transformation and validation as copyFiles. Packaged
+// files, checksums and the installed size are recorded as the files are written.
+func (b *Builder) streamData(ctx context.Context, w io.Writer) error {
+	archive := newTarArchive(w, time.Now())
+	b.md5sums = make(map[string]string)
+	inodes := make(map[fileKey]string)
+
+	err := b.walkSource(ctx, func(srcPath, packagePath string, info os.FileInfo) error {
+		attrs, err := b.sourceAttrs(srcPath, info)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return archive.addDir(packagePath, b.fileMode(srcPath, info), attrs)
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := b.linkTarget(srcPath, packagePath)
+			if err != nil {
+				return err
+			}
+			if err := archive.addSymlink(packagePath, target, info.ModTime(), attrs); err != nil {
+				return err
+			}
+			b.PackagedFiles = append(b.PackagedFiles, packagePath)
+			b.fileCopied(packagePath, 0)
+			return nil
+		}
+
+		if key, ok := fileID(info); ok {
+			if first, seen := inodes[key]; seen {
+				if err := archive.addHardlink(packagePath, first, b.fileMode(srcPath, info), info.ModTime(), attrs); err != nil {
+					return err
+				}
+				b.PackagedFiles = append(b.PackagedFiles, packagePath)
+				b.md5sums[packagePath] = b.md5sums[first]
+				b.fileCopied(packagePath, 0)
+				return nil
+			}
+			inodes[key] = packagePath
+		}
+
+		contentPath, cleanup, err := b.payloadContent(ctx, srcPath, packagePath, info)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		size, sum, err := archive.addFile(ctx, packagePath, contentPath, b.fileMode(srcPath, info), attrs)
+		if err != nil {
+			return err
+		}
+		b.PackagedFiles = append(b.PackagedFiles, packagePath)
+		b.md5sums[packagePath] = sum
+		b.fileCopied(packagePath, size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.packageGenerated(func(packagePath string, content []byte, mode os.FileMode) error {
+		if err := archive.addDir(path.Dir(packagePath), 0755, fileAttrs{}); err != nil {
+			return err
+		}
+		return archive.addBytes(packagePath, content, mode)
+	})
+	if err != nil {
+		return err
+	}
+	b.installedSize = archive.blocks
+	return archive.Close()
+}
+
+// controlArchive returns control.tar.gz built from the DEBIAN directory
+func (b *Builder) controlArchive() ([]byte, error) {
+	debianDir := filepath.Join(b.BuildDir, "DEBIAN")
+	entries, err := os.ReadDir(debianDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DEBIAN directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	archive := newTarArchive(&buf, time.Now())
+	if err := archive.addDir("/", 0755, fileAttrs{}); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		filePath := filepath.Join(debianDir, name)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := archive.addBytes("/"+name, content, info.Mode()); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeDeb assembles the .deb at outputPath from the DEBIAN directory and a
+// data.tar.gz file
+func (b *Builder) writeDeb(ctx context.Context, outputPath, dataPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create package file: %w", err)
+	}
+
+	err = b.writeDebTo(ctx, out, dataPath)
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write package file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
+
+// writeDebTo writes the .deb assembled from the DEBIAN directory and a
+// data.tar.gz file to w
+func (b *Builder) writeDebTo(ctx context.Context, w io.Writer, dataPath string) error {
+	control, err := b.controlArchive()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.Open(dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open data archive: %w", err)
+	}
+	defer data.Close()
+	dataInfo, err := data.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat data archive: %w", err)
+	}
+
+	now := time.Now()
+	ar, err := newArWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := ar.writeMember("debian-binary", 4, now, strings.NewReader("2.0\n")); err != nil {
+		return err
+	}
+	if err := ar.writeMember("control.tar.gz", int64(len(control)), now, bytes.NewReader(control)); err != nil {
+		return err
+	}
+	return ar.writeMember("data.tar.gz", dataInfo.Size(), now, contextReader{ctx, data})
+}
@@ -0,0 +1,144 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readAr returns the members of an ar archive in order
+func readAr(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	if !bytes.HasPrefix(data, []byte(arMagic)) {
+		t.Fatalf("Missing ar signature")
+	}
+	var names []string
+	members := make(map[string][]byte)
+	for offset := len(arMagic); offset < len(data); {
+		header := data[offset : offset+60]
+		name := strings.TrimSpace(string(header[:16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+		if err != nil {
+			t.Fatalf("Invalid member size for %s: %v", name, err)
+		}
+		offset += 60
+		names = append(names, name)
+		members[name] = data[offset : offset+size]
+		offset += size + size%2
+	}
+	return names, members
+}
+
+// readTarGz returns the entries of a gzip-compressed tar archive
+func readTarGz(t *testing.T, data []byte) map[string]*tar.Header {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to open gzip stream: %v", err)
+	}
+	entries := make(map[string]*tar.Header)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar entry: %v", err)
+		}
+		entries[header.Name] = header
+	}
+	return entries
+}
+
+func TestStreamingBuild(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "run.sh"), []byte("hello\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "odd"), []byte("odd"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	builder.Streaming = true
+	builder.DpkgRoot = srcDir
+
+	outputPath, _, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	// Nothing but the package is left in the output directory
+	if files, _ := ioutil.ReadDir(outDir); len(files) != 1 {
+		t.Errorf("Expected only the package in the output directory, got %d files", len(files))
+	}
+
+	deb, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+	names, members := readAr(t, deb)
+	if strings.Join(names, ",") != "debian-binary,control.tar.gz,data.tar.gz" {
+		t.Fatalf("Unexpected archive members %v", names)
+	}
+	if string(members["debian-binary"]) != "2.0\n" {
+		t.Errorf("Unexpected debian-binary %q", members["debian-binary"])
+	}
+
+	data := readTarGz(t, members["data.tar.gz"])
+	for name, mode := range map[string]int64{
+		"./":                         0755,
+		"./opt/":                     0755,
+		"./opt/usr/share/app/":       0755,
+		"./opt/usr/share/app/run.sh": 0755,
+		"./opt/usr/share/app/odd":    0644,
+	} {
+		header, ok := data[name]
+		if !ok {
+			t.Errorf("Missing data entry %s", name)
+			continue
+		}
+		if header.Mode != mode || header.Uid != 0 || header.Uname != "root" {
+			t.Errorf("Entry %s has mode %o owner %d/%s", name, header.Mode, header.Uid, header.Uname)
+		}
+	}
+	if size := data["./opt/usr/share/app/run.sh"].Size; size != 6 {
+		t.Errorf("Expected run.sh size 6, got %d", size)
+	}
+
+	control := readTarGz(t, members["control.tar.gz"])
+	for _, name := range []string{"./control", "./md5sums"} {
+		if _, ok := control[name]; !ok {
+			t.Errorf("Missing control entry %s", name)
+		}
+	}
+	if builder.md5sums["/opt/usr/share/app/run.sh"] != "b1946ac92492d2347c6235b4d2611184" {
+		t.Errorf("Unexpected checksum %q", builder.md5sums["/opt/usr/share/app/run.sh"])
+	}
+	if len(builder.PackagedFiles) != 2 {
+		t.Errorf("Expected 2 packaged files, got %v", builder.PackagedFiles)
+	}
+}
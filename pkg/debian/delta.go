@@ -0,0 +1,629 @@
+package debian
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/delta"
+)
+
+// DeltaFormatVersion is the version of the delta file format
+const DeltaFormatVersion = 1
+
+// DeltaExtension is the file extension of package deltas
+const DeltaExtension = ".pkgdelta"
+
+// deltaMagic starts every delta file, followed by a gzip stream holding the
+// JSON DeltaInfo on one line and the delta of each member
+const deltaMagic = "PKGINSTALL-DELTA\n"
+
+// DeltaInfo describes the two packages of a delta and how each member of
+// the new package is rebuilt
+type DeltaInfo struct {
+	Format       int           `json:"format"`
+	Package      string        `json:"package"`
+	Architecture string        `json:"architecture"`
+	OldVersion   string        `json:"old_version"`
+	NewVersion   string        `json:"new_version"`
+	OldSHA256    string        `json:"old_sha256"`
+	OldSize      int64         `json:"old_size"`
+	NewSHA256    string        `json:"new_sha256"`
+	NewSize      int64         `json:"new_size"`
+	Members      []DeltaMember `json:"members"`
+}
+
+// DeltaMember is how one ar member of the new package is rebuilt
+type DeltaMember struct {
+	Header     string      `json:"header"`               // ar header of the member in the new package
+	Base       string      `json:"base,omitempty"`       // Member of the old package the delta copies from
+	Recompress string      `json:"recompress,omitempty"` // Recipe compressing the patched contents; empty if the compressed bytes are patched
+	Gzip       *gzipParams `json:"gzip,omitempty"`       // Header fields of a gzip-compressed member
+}
+
+// gzipParams are the header fields a gzip recipe must reproduce
+type gzipParams struct {
+	Name    string `json:"name,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	ModTime int64  `json:"mtime,omitempty"`
+	OS      byte   `json:"os"`
+}
+
+// DeltaResult describes a delta written by CreateDelta
+type DeltaResult struct {
+	*DeltaInfo
+	Path     string // Path of the delta file
+	Size     int64  // Size of the delta file in bytes
+	Inserted int64  // Bytes of the new package's contents not found in the old one
+}
+
+// memberCodec decompresses the members with one file extension
+type memberCodec struct {
+	decompress func(ctx context.Context, r io.Reader, w io.Writer) (*gzipParams, error)
+	recipes    []string // Recipes that may reproduce a member, tried in order
+}
+
+// Compressed tar members differ completely after a small change, so deltas
+// are computed on their contents when one of the recipes reproduces the
+// compressed bytes exactly.
+var memberCodecs = map[string]memberCodec{
+	".gz":  {decompressGzip, []string{"gzip", "gzip-9"}},
+	".xz":  {commandCodec("xz", "-dc"), []string{"xz-6", "xz-9", "xz-6-crc32"}},
+	".zst": {commandCodec("zstd", "-dcq"), []string{"zstd-3", "zstd-19"}},
+}
+
+// deltaRecipes compress the patched contents of a member. Deltas refer to
+// them by name, so applying a delta only ever runs these commands.
+var deltaRecipes = map[string]func(ctx context.Context, params *gzipParams, r io.Reader, w io.Writer) error{
+	"gzip":       gzipRecipe(gzip.DefaultCompression),
+	"gzip-9":     gzipRecipe(gzip.BestCompression),
+	"xz-6":       commandRecipe("xz", "-c", "-6", "--check=crc64"),
+	"xz-9":       commandRecipe("xz", "-c", "-9", "--check=crc64"),
+	"xz-6-crc32": commandRecipe("xz", "-c", "-6", "--check=crc32"),
+	"zstd-3":     commandRecipe("zstd", "-cq", "-3"),
+	"zstd-19":    commandRecipe("zstd", "-cq", "-19"),
+}
+
+func decompressGzip(ctx context.Context, r io.Reader, w io.Writer) (*gzipParams, error) {
+	gz, err := gzip.NewReader(contextReader{ctx, r})
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	if _, err := io.Copy(w, gz); err != nil {
+		return nil, err
+	}
+	params := &gzipParams{Name: gz.Name, Comment: gz.Comment, OS: gz.OS}
+	if !gz.ModTime.IsZero() {
+		params.ModTime = gz.ModTime.Unix()
+	}
+	return params, nil
+}
+
+func gzipRecipe(level int) func(ctx context.Context, params *gzipParams, r io.Reader, w io.Writer) error {
+	return func(ctx context.Context, params *gzipParams, r io.Reader, w io.Writer) error {
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return err
+		}
+		if params != nil {
+			gz.Name, gz.Comment, gz.OS = params.Name, params.Comment, params.OS
+			if params.ModTime != 0 {
+				gz.ModTime = time.Unix(params.ModTime, 0)
+			}
+		}
+		if _, err := io.Copy(gz, contextReader{ctx, r}); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+}
+
+func commandCodec(name string, args ...string) func(ctx context.Context, r io.Reader, w io.Writer) (*gzipParams, error) {
+	return func(ctx context.Context, r io.Reader, w io.Writer) (*gzipParams, error) {
+		return nil, runFilter(ctx, r, w, name, args...)
+	}
+}
+
+func commandRecipe(name string, args ...string) func(ctx context.Context, params *gzipParams, r io.Reader, w io.Writer) error {
+	return func(ctx context.Context, params *gzipParams, r io.Reader, w io.Writer) error {
+		return runFilter(ctx, r, w, name, args...)
+	}
+}
+
+// runFilter pipes r through a compression tool into w
+func runFilter(ctx context.Context, r io.Reader, w io.Writer, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, w, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// codecFor returns the codec of a compressed tar member
+func codecFor(name string) (memberCodec, bool) {
+	if !strings.HasPrefix(name, "control.tar") && !strings.HasPrefix(name, "data.tar") {
+		return memberCodec{}, false
+	}
+	codec, ok := memberCodecs[filepath.Ext(name)]
+	return codec, ok
+}
+
+// deltaPackage is a .deb mapped into memory
+type deltaPackage struct {
+	path    string
+	data    []byte
+	release func()
+	members []arMember
+	sha256  string
+	fields  map[string]string
+	tmpDir  string
+	mapped  []func()
+}
+
+// openDeltaPackage maps the package at path and reads its control fields.
+// Expanded members are written to tmpDir.
+func openDeltaPackage(ctx context.Context, path, tmpDir string) (*deltaPackage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer f.Close()
+	members, err := readArIndex(f)
+	if err != nil {
+		return nil, ci.Errorf(ci.ClassValidation, "%s: %w", path, err)
+	}
+	control, err := openMember(ctx, path, members, "control.tar")
+	if err != nil {
+		return nil, ci.Errorf(ci.ClassValidation, "%s: %w", path, err)
+	}
+	fields, err := readControlFields(control)
+	control.Close()
+	if err != nil {
+		return nil, ci.Errorf(ci.ClassValidation, "%s: %w", path, err)
+	}
+
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return &deltaPackage{
+		path:    path,
+		data:    data,
+		release: release,
+		members: members,
+		sha256:  hex.EncodeToString(sum[:]),
+		fields:  fields,
+		tmpDir:  tmpDir,
+	}, nil
+}
+
+// close unmaps the package and its expanded members
+func (p *deltaPackage) close() {
+	for _, release := range p.mapped {
+		release()
+	}
+	p.release()
+}
+
+// contents returns the bytes of a member
+func (p *deltaPackage) contents(member arMember) []byte {
+	return p.data[member.offset : member.offset+member.Size()]
+}
+
+// header returns the raw ar header of a member
+func (p *deltaPackage) header(member arMember) string {
+	return string(p.data[member.offset-60 : member.offset])
+}
+
+// find returns the member called name
+func (p *deltaPackage) find(name string) (arMember, bool) {
+	for _, member := range p.members {
+		if member.name == name {
+			return member, true
+		}
+	}
+	return arMember{}, false
+}
+
+// counterpart returns the member corresponding to a member of another
+// package: the one of the same name, or the tar member of the same kind
+// with another compression
+func (p *deltaPackage) counterpart(name string) (arMember, bool) {
+	if member, ok := p.find(name); ok {
+		return member, true
+	}
+	for _, prefix := range []string{"control.tar", "data.tar"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, member := range p.members {
+			if strings.HasPrefix(member.name, prefix) {
+				return member, true
+			}
+		}
+	}
+	return arMember{}, false
+}
+
+// expand decompresses a member into a temporary file and maps it
+func (p *deltaPackage) expand(ctx context.Context, member arMember) ([]byte, *gzipParams, error) {
+	codec, ok := codecFor(member.name)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a compressed tar member", member.name)
+	}
+	tmp, err := os.CreateTemp(p.tmpDir, "member-*.tar")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	params, err := codec.decompress(ctx, bytes.NewReader(p.contents(member)), tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decompress %s of %s: %w", member.name, p.path, err)
+	}
+	data, release, err := mapFile(tmp.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	p.mapped = append(p.mapped, release)
+	return data, params, nil
+}
+
+// findRecipe returns the recipe that compresses contents into exactly the
+// bytes of member, or "" if none does
+func findRecipe(ctx context.Context, member string, params *gzipParams, contents, want []byte) string {
+	codec, _ := codecFor(member)
+	wantSum := sha256.Sum256(want)
+	for _, name := range codec.recipes {
+		out := newChecksumWriter(io.Discard)
+		if err := deltaRecipes[name](ctx, params, bytes.NewReader(contents), out); err != nil {
+			continue
+		}
+		if out.size == int64(len(want)) && out.sum() == hex.EncodeToString(wantSum[:]) {
+			return name
+		}
+	}
+	return ""
+}
+
+// readControlFields returns the fields of the control file in a control.tar
+// stream
+func readControlFields(r io.Reader) (map[string]string, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("control archive has no control file")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read control archive: %w", err)
+		}
+		if strings.TrimPrefix(header.Name, "./") != "control" {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read control file: %w", err)
+		}
+		paragraph, err := ParseControlParagraph(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid control file: %w", err)
+		}
+		return paragraph.Map(), nil
+	}
+}
+
+// DeltaFileName returns the name of a delta: <name>_<old>_<new>_<arch>.pkgdelta
+func DeltaFileName(info *DeltaInfo) string {
+	return fmt.Sprintf("%s_%s_%s_%s%s", info.Package, info.OldVersion, info.NewVersion, info.Architecture, DeltaExtension)
+}
+
+// CreateDelta writes the delta that rebuilds the package at newPath from the
+// one at oldPath to deltaPath, or next to newPath with DeltaFileName if
+// deltaPath is empty. Both must be versions of the same package.
+func CreateDelta(ctx context.Context, oldPath, newPath, deltaPath string) (*DeltaResult, error) {
+	tmpDir, err := os.MkdirTemp("", "pkginstall-delta-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldPkg, err := openDeltaPackage(ctx, oldPath, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	defer oldPkg.close()
+	newPkg, err := openDeltaPackage(ctx, newPath, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	defer newPkg.close()
+
+	if oldPkg.fields["Package"] != newPkg.fields["Package"] || oldPkg.fields["Architecture"] != newPkg.fields["Architecture"] {
+		return nil, ci.Errorf(ci.ClassUsage, "%s (%s %s) and %s (%s %s) are not versions of the same package",
+			oldPath, oldPkg.fields["Package"], oldPkg.fields["Architecture"],
+			newPath, newPkg.fields["Package"], newPkg.fields["Architecture"])
+	}
+	info := &DeltaInfo{
+		Format:       DeltaFormatVersion,
+		Package:      newPkg.fields["Package"],
+		Architecture: newPkg.fields["Architecture"],
+		OldVersion:   oldPkg.fields["Version"],
+		NewVersion:   newPkg.fields["Version"],
+		OldSHA256:    oldPkg.sha256,
+		OldSize:      int64(len(oldPkg.data)),
+		NewSHA256:    newPkg.sha256,
+		NewSize:      int64(len(newPkg.data)),
+	}
+
+	// Decide how each member is rebuilt before anything is written
+	type memberPair struct{ base, target []byte }
+	var pairs []memberPair
+	for _, member := range newPkg.members {
+		entry := DeltaMember{Header: newPkg.header(member)}
+		pair := memberPair{target: newPkg.contents(member)}
+		base, hasBase := oldPkg.counterpart(member.name)
+		if hasBase {
+			entry.Base, pair.base = base.name, oldPkg.contents(base)
+		}
+		if expanded, ok := expandForDelta(ctx, newPkg, oldPkg, member, base, hasBase); ok {
+			entry.Recompress, entry.Gzip = expanded.recipe, expanded.params
+			pair = memberPair{base: expanded.base, target: expanded.target}
+		}
+		info.Members = append(info.Members, entry)
+		pairs = append(pairs, pair)
+	}
+
+	if deltaPath == "" {
+		deltaPath = filepath.Join(filepath.Dir(newPath), DeltaFileName(info))
+	}
+	result := &DeltaResult{DeltaInfo: info, Path: deltaPath}
+	out, err := os.Create(deltaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create delta: %w", err)
+	}
+	err = func() error {
+		if _, err := io.WriteString(out, deltaMagic); err != nil {
+			return err
+		}
+		gz, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(gz).Encode(info); err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			stats, err := delta.Diff(pair.base, pair.target, gz)
+			if err != nil {
+				return err
+			}
+			result.Inserted += stats.Inserted
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		return out.Close()
+	}()
+	if err != nil {
+		out.Close()
+		os.Remove(deltaPath)
+		return nil, fmt.Errorf("failed to write delta: %w", err)
+	}
+	if info, err := os.Stat(deltaPath); err == nil {
+		result.Size = info.Size()
+	}
+	return result, nil
+}
+
+// expandedMember is a member whose delta is computed on its decompressed
+// contents
+type expandedMember struct {
+	base, target []byte
+	recipe       string
+	params       *gzipParams
+}
+
+// expandForDelta decompresses a member of the new package and its
+// counterpart in the old one. It reports false, and the compressed bytes are
+// diffed instead, unless a recipe reproduces the member exactly and the
+// counterpart, if any, can be decompressed too.
+func expandForDelta(ctx context.Context, newPkg, oldPkg *deltaPackage, member, base arMember, hasBase bool) (*expandedMember, bool) {
+	if _, ok := codecFor(member.name); !ok {
+		return nil, false
+	}
+	target, params, err := newPkg.expand(ctx, member)
+	if err != nil {
+		return nil, false
+	}
+	recipe := findRecipe(ctx, member.name, params, target, newPkg.contents(member))
+	if recipe == "" {
+		return nil, false
+	}
+	expanded := &expandedMember{target: target, recipe: recipe, params: params}
+	if hasBase {
+		if _, ok := codecFor(base.name); !ok {
+			return nil, false
+		}
+		if expanded.base, _, err = oldPkg.expand(ctx, base); err != nil {
+			return nil, false
+		}
+	}
+	return expanded, true
+}
+
+// ApplyDelta rebuilds the new package of a delta from the old package at
+// oldPath and writes it to outPath, or next to oldPath with the usual .deb
+// name if outPath is empty. The old package and the result are checked
+// against the checksums in the delta.
+func ApplyDelta(ctx context.Context, oldPath, deltaPath, outPath string) (string, *DeltaInfo, error) {
+	f, err := os.Open(deltaPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open delta: %w", err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != deltaMagic {
+		return "", nil, ci.Errorf(ci.ClassValidation, "%s is not a package delta", deltaPath)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", nil, ci.Errorf(ci.ClassValidation, "corrupt delta %s: %w", deltaPath, err)
+	}
+	r := bufio.NewReader(gz)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return "", nil, ci.Errorf(ci.ClassValidation, "corrupt delta %s: %w", deltaPath, err)
+	}
+	var info DeltaInfo
+	if err := json.Unmarshal(line, &info); err != nil {
+		return "", nil, ci.Errorf(ci.ClassValidation, "corrupt delta %s: %w", deltaPath, err)
+	}
+	if info.Format != DeltaFormatVersion {
+		return "", nil, ci.Errorf(ci.ClassValidation, "unsupported delta format version %d (expected %d)", info.Format, DeltaFormatVersion)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pkginstall-delta-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldPkg, err := openDeltaPackage(ctx, oldPath, tmpDir)
+	if err != nil {
+		return "", nil, err
+	}
+	defer oldPkg.close()
+	if oldPkg.sha256 != info.OldSHA256 {
+		return "", nil, ci.Errorf(ci.ClassValidation, "%s is not the package the delta was made from (%s %s, sha256 %s)",
+			oldPath, info.Package, info.OldVersion, info.OldSHA256)
+	}
+
+	if outPath == "" {
+		outPath = filepath.Join(filepath.Dir(oldPath), fmt.Sprintf("%s_%s_%s.deb", info.Package, info.NewVersion, info.Architecture))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), ".pkginstall-delta-*.deb")
+	if err != nil {
+		return "", nil, ci.Errorf(ci.ClassEnvironment, "failed to create package: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	out := newChecksumWriter(tmp)
+	err = applyMembers(ctx, oldPkg, &info, r, out)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if out.size != info.NewSize || out.sum() != info.NewSHA256 {
+		return "", nil, ci.Errorf(ci.ClassValidation, "rebuilt package does not match %s %s (sha256 %s)", info.Package, info.NewVersion, info.NewSHA256)
+	}
+	if err := os.Rename(tmp.Name(), outPath); err != nil {
+		return "", nil, fmt.Errorf("failed to write package: %w", err)
+	}
+	return outPath, &info, nil
+}
+
+// applyMembers writes the ar archive of the new package to out
+func applyMembers(ctx context.Context, oldPkg *deltaPackage, info *DeltaInfo, r *bufio.Reader, out *checksumWriter) error {
+	if _, err := io.WriteString(out, arMagic); err != nil {
+		return err
+	}
+	for _, member := range info.Members {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header := member.Header
+		if len(header) != 60 || header[58:] != "`\n" {
+			return ci.Errorf(ci.ClassValidation, "corrupt delta: invalid archive member header %q", header)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(header[48:58]), 10, 64)
+		if err != nil || size < 0 {
+			return ci.Errorf(ci.ClassValidation, "corrupt delta: invalid archive member size %q", header[48:58])
+		}
+		if _, err := io.WriteString(out, header); err != nil {
+			return err
+		}
+
+		var base arMember
+		var baseData []byte
+		if member.Base != "" {
+			var ok bool
+			if base, ok = oldPkg.find(member.Base); !ok {
+				return ci.Errorf(ci.ClassValidation, "corrupt delta: old package has no %s member", member.Base)
+			}
+			baseData = oldPkg.contents(base)
+		}
+
+		start := out.size
+		if member.Recompress == "" {
+			_, err = delta.Patch(baseData, r, out)
+		} else {
+			err = applyExpanded(ctx, oldPkg, member, base, baseData, r, out)
+		}
+		if err != nil {
+			return ci.Wrap(ci.ClassValidation, fmt.Errorf("failed to rebuild %s: %w", strings.TrimSpace(header[:16]), err))
+		}
+		if out.size-start != size {
+			return ci.Errorf(ci.ClassValidation, "rebuilt %s has %d bytes, expected %d", strings.TrimSpace(header[:16]), out.size-start, size)
+		}
+		if size%2 == 1 {
+			if _, err := io.WriteString(out, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyExpanded patches the decompressed contents of a member and
+// compresses them with the member's recipe
+func applyExpanded(ctx context.Context, oldPkg *deltaPackage, member DeltaMember, base arMember, baseData []byte, r *bufio.Reader, out io.Writer) error {
+	recipe, ok := deltaRecipes[member.Recompress]
+	if !ok {
+		return fmt.Errorf("unknown compression recipe %q", member.Recompress)
+	}
+	if member.Base != "" {
+		var err error
+		if baseData, _, err = oldPkg.expand(ctx, base); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(oldPkg.tmpDir, "member-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer tmp.Close()
+	w := bufio.NewWriter(tmp)
+	if _, err := delta.Patch(baseData, r, w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return recipe(ctx, member.Gzip, tmp, out)
+}
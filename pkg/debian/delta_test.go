@@ -0,0 +1,97 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+func TestDelta(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "delta-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "delta-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	binDir := filepath.Join(srcDir, "usr", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	var content []byte
+	for i := 0; i < 20000; i++ {
+		content = append(content, byte(i*7919>>3), byte(i))
+	}
+	build := func(name, version string, payload []byte) string {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(binDir, "app"), payload, 0755); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		builder, err := NewBuilder(NewPackage(name, version, "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.DpkgRoot = srcDir
+		path, _, err := builder.Build(context.Background())
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		return path
+	}
+	oldPath := build("app", "1.0", content)
+	newPath := build("app", "1.1", append(append([]byte{}, content...), "appended\n"...))
+	otherPath := build("other", "1.0", content)
+
+	result, err := CreateDelta(context.Background(), oldPath, newPath, "")
+	if err != nil {
+		t.Fatalf("CreateDelta() error = %v", err)
+	}
+	if want := filepath.Join(outDir, "app_1.0_1.1_all"+DeltaExtension); result.Path != want {
+		t.Errorf("delta path = %s, want %s", result.Path, want)
+	}
+	if result.Size >= result.NewSize/4 {
+		t.Errorf("delta has %d bytes for a %d byte package", result.Size, result.NewSize)
+	}
+
+	rebuilt := filepath.Join(outDir, "rebuilt.deb")
+	path, info, err := ApplyDelta(context.Background(), oldPath, result.Path, rebuilt)
+	if err != nil {
+		t.Fatalf("ApplyDelta() error = %v", err)
+	}
+	if path != rebuilt || info.NewVersion != "1.1" {
+		t.Errorf("ApplyDelta() = %s, %s; want %s, 1.1", path, info.NewVersion, rebuilt)
+	}
+	got, _ := ioutil.ReadFile(rebuilt)
+	want, _ := ioutil.ReadFile(newPath)
+	if !bytes.Equal(got, want) {
+		t.Error("rebuilt package differs from the new package")
+	}
+
+	t.Run("WrongBase", func(t *testing.T) {
+		_, _, err := ApplyDelta(context.Background(), newPath, result.Path, filepath.Join(outDir, "wrong.deb"))
+		if ci.ClassOf(err) != ci.ClassValidation {
+			t.Errorf("ApplyDelta() error = %v, want a validation error", err)
+		}
+	})
+	t.Run("OtherPackage", func(t *testing.T) {
+		_, err := CreateDelta(context.Background(), otherPath, newPath, filepath.Join(outDir, "other.pkgdelta"))
+		if ci.ClassOf(err) != ci.ClassUsage {
+			t.Errorf("CreateDelta() error = %v, want a usage error", err)
+		}
+	})
+	t.Run("NotADelta", func(t *testing.T) {
+		_, _, err := ApplyDelta(context.Background(), oldPath, newPath, filepath.Join(outDir, "bad.deb"))
+		if ci.ClassOf(err) != ci.ClassValidation {
+			t.Errorf("ApplyDelta() error = %v, want a validation error", err)
+		}
+	})
+}
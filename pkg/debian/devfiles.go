@@ -0,0 +1,61 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/devfiles"
+)
+
+// rewritesDevFile reports whether the paths in the file at srcPath are
+// rewritten to the relocated paths
+func (b *Builder) rewritesDevFile(srcPath string) bool {
+	return b.FixDevFiles && devfiles.IsDevFile(filepath.ToSlash(b.systemPath(srcPath)))
+}
+
+// rewriteDevFile writes a copy of the pkg-config, libtool or CMake file at
+// srcPath with the paths the payload relocates rewritten, and returns its
+// path, or srcPath itself if nothing changed. cleanup removes the copy.
+func (b *Builder) rewriteDevFile(srcPath, packagePath string) (string, func(), error) {
+	none := func() {}
+	content, err := os.ReadFile(srcPath)
+	if err != nil {
+		return "", none, fmt.Errorf("failed to read %s: %w", srcPath, err)
+	}
+	rewritten, count := devfiles.Rewrite(content, b.relocatedPath)
+	if count == 0 {
+		return srcPath, none, nil
+	}
+
+	tmp, err := os.CreateTemp(b.WorkDir, "pkginstall-devfile-*")
+	if err != nil {
+		return "", none, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	_, err = tmp.Write(rewritten)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", none, fmt.Errorf("failed to write rewritten %s: %w", packagePath, err)
+	}
+
+	b.devFilesMu.Lock()
+	b.DevFiles = append(b.DevFiles, packagePath)
+	b.devFilesMu.Unlock()
+	b.log("Rewrote %d path(s) in %s", count, packagePath)
+	return tmp.Name(), cleanup, nil
+}
+
+// uncompressedContent returns the file whose content is packaged for a file
+// that is not compressed: a rewritten development file, a stripped or
+// rewritten ELF file, or srcPath itself. cleanup removes any temporary copy.
+func (b *Builder) uncompressedContent(ctx context.Context, srcPath, packagePath string) (string, func(), error) {
+	if b.rewritesDevFile(srcPath) {
+		return b.rewriteDevFile(srcPath, packagePath)
+	}
+	return b.elfContent(ctx, srcPath, packagePath)
+}
@@ -0,0 +1,61 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixDevFiles(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "devfiles-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	const pc = "prefix=/usr\nlibdir=${prefix}/lib\nLibs: -L${libdir} -lapp -L/usr/lib/other\n"
+	files := map[string]string{
+		"usr/lib/pkgconfig/app.pc": pc,
+		"usr/lib/libapp.so":        "library",
+		"usr/share/app/notes.txt":  "prefix=/usr\n",
+	}
+	for file, content := range files {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	for _, fix := range []bool{false, true} {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir, WithFixDevFiles(fix))
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		defer builder.Clean()
+		if err := builder.copyFiles(context.Background()); err != nil {
+			t.Fatalf("copyFiles() error = %v", err)
+		}
+
+		want := pc
+		if fix {
+			// /usr/lib/other is not in the payload
+			want = "prefix=/opt/usr\nlibdir=${prefix}/lib\nLibs: -L${libdir} -lapp -L/usr/lib/other\n"
+		}
+		content, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, "opt/usr/lib/pkgconfig/app.pc"))
+		if err != nil || string(content) != want {
+			t.Errorf("app.pc with fix %v = %q, %v, want %q", fix, content, err, want)
+		}
+		notes, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, "opt/usr/share/app/notes.txt"))
+		if err != nil || string(notes) != "prefix=/usr\n" {
+			t.Errorf("Expected other files to be left alone, got %q, %v", notes, err)
+		}
+		if fix != (len(builder.DevFiles) == 1) {
+			t.Errorf("DevFiles with fix %v = %v", fix, builder.DevFiles)
+		}
+	}
+}
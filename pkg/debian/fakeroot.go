@@ -0,0 +1,191 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+// geteuid returns the effective user ID. It is a variable so tests can substitute it.
+var geteuid = os.Geteuid
+
+// lookPath finds fakeroot. It is a variable so tests can substitute it.
+var lookPath = exec.LookPath
+
+// recordOwners makes an unprivileged build, or a root build that copies as
+// CopyAs, that preserves owners for dpkg-deb record them instead of changing
+// the owners in BuildDir, which requires root. The recorded owners are
+// applied when the archive is written.
+func (b *Builder) recordOwners() {
+	if !b.PreserveOwner || b.Streaming || b.Writer != nil || b.SourcePackage || geteuid() == 0 && b.CopyAs == nil {
+		return
+	}
+	b.log("Recording preserved owners for the archive")
+	b.owners = make(map[string]fileAttrs)
+}
+
+// recordOwner records the preserved owner of a file in the build directory
+func (b *Builder) recordOwner(targetPath string, attrs fileAttrs) error {
+	rel, err := filepath.Rel(b.BuildDir, targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to get relative path: %w", err)
+	}
+	b.ownersMu.Lock()
+	b.owners[path.Clean("/"+filepath.ToSlash(rel))] = fileAttrs{uid: attrs.uid, gid: attrs.gid}
+	b.ownersMu.Unlock()
+	return nil
+}
+
+// writeOwnedArchive builds the package from BuildDir with the recorded
+// owners: under fakeroot if it is installed, or else with the built-in
+// archive writer
+func (b *Builder) writeOwnedArchive(ctx context.Context, outputPath string) error {
+	fakeroot, err := lookPath("fakeroot")
+	if err != nil {
+		b.log("fakeroot not found, writing %s with the built-in archive writer", outputPath)
+		return b.writeOwnedData(ctx, outputPath)
+	}
+	b.log("Writing %s under fakeroot", outputPath)
+	return b.fakerootBuild(ctx, fakeroot, outputPath)
+}
+
+// fakerootBuild sets the recorded owners in a fakeroot session, whose state
+// is saved between the commands, and runs dpkg-deb in it
+func (b *Builder) fakerootBuild(ctx context.Context, fakeroot, outputPath string) error {
+	stateFile, err := os.CreateTemp(b.WorkDir, ".pkginstall-fakeroot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create fakeroot state file: %w", err)
+	}
+	state := stateFile.Name()
+	stateFile.Close()
+	defer os.Remove(state)
+
+	// Files that have no recorded owner are packaged as root:root
+	if err := b.runFakeroot(ctx, fakeroot, []string{"-s", state}, nil, "chown", "-hR", "0:0", b.BuildDir); err != nil {
+		return fmt.Errorf("failed to set owners under fakeroot: %w", err)
+	}
+
+	groups := make(map[string][]string)
+	for packagePath, attrs := range b.owners {
+		if attrs.uid != 0 || attrs.gid != 0 {
+			owner := fmt.Sprintf("%d:%d", attrs.uid, attrs.gid)
+			groups[owner] = append(groups[owner], filepath.Join(b.BuildDir, filepath.FromSlash(packagePath)))
+		}
+	}
+	owners := make([]string, 0, len(groups))
+	for owner := range groups {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		// Paths are passed on stdin so large payloads don't exceed the argument limit
+		paths := strings.NewReader(strings.Join(groups[owner], "\x00"))
+		if err := b.runFakeroot(ctx, fakeroot, []string{"-i", state, "-s", state}, paths, "xargs", "-0", "chown", "-h", owner, "--"); err != nil {
+			return fmt.Errorf("failed to set owners under fakeroot: %w", err)
+		}
+	}
+
+	if err := b.runFakeroot(ctx, fakeroot, []string{"-i", state}, nil, "dpkg-deb", "--build", b.BuildDir, outputPath); err != nil {
+		if ctx.Err() != nil {
+			// Don't leave a truncated package behind
+			os.Remove(outputPath)
+			return fmt.Errorf("package build cancelled: %w", ctx.Err())
+		}
+		return fmt.Errorf("failed to build package: %w", err)
+	}
+	return nil
+}
+
+// runFakeroot runs a command under fakeroot with the given fakeroot options
+func (b *Builder) runFakeroot(ctx context.Context, fakeroot string, options []string, stdin io.Reader, command ...string) error {
+	args := append(append(append([]string{}, options...), "--"), command...)
+	b.log("Running: fakeroot %s", strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, fakeroot, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = logging.Writer(b.logOutput(), slog.LevelInfo)
+	cmd.Stderr = logging.Writer(b.logOutput(), slog.LevelWarn)
+	return cmd.Run()
+}
+
+// writeOwnedData writes the package at outputPath with the built-in archive
+// writer, from the files staged in BuildDir and their recorded owners
+func (b *Builder) writeOwnedData(ctx context.Context, outputPath string) error {
+	dataFile, err := os.CreateTemp(b.WorkDir, ".pkginstall-data-*.tar.gz")
+	if err != nil {
+		return fmt.Errorf("failed to create data archive: %w", err)
+	}
+	dataPath := dataFile.Name()
+	defer os.Remove(dataPath)
+
+	err = b.ownedData(ctx, dataFile)
+	if closeErr := dataFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write data archive: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.writeDeb(ctx, outputPath, dataPath); err != nil {
+		return fmt.Errorf("failed to build package: %w", err)
+	}
+	return nil
+}
+
+// ownedData writes the data archive of the files staged in BuildDir, owned
+// as recorded and by root otherwise. Hard links are kept.
+func (b *Builder) ownedData(ctx context.Context, w io.Writer) error {
+	archive := newTarArchive(w, time.Now())
+	inodes := make(map[fileKey]string)
+	err := filepath.Walk(b.BuildDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.BuildDir, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if rel == "DEBIAN" {
+			return filepath.SkipDir
+		}
+		packagePath := path.Clean("/" + filepath.ToSlash(rel))
+		attrs := b.owners[packagePath]
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			return archive.addDir(packagePath, mode, attrs)
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", filePath, err)
+			}
+			return archive.addSymlink(packagePath, target, info.ModTime(), attrs)
+		case mode.IsRegular():
+			if key, linked := fileID(info); linked {
+				if first, ok := inodes[key]; ok {
+					return archive.addHardlink(packagePath, first, mode, info.ModTime(), attrs)
+				}
+				inodes[key] = packagePath
+			}
+			_, _, err := archive.addFile(ctx, packagePath, filePath, mode, attrs)
+			return err
+		default:
+			return fmt.Errorf("cannot package %s: unsupported file type", filePath)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,94 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnprivilegedPreserveOwner(t *testing.T) {
+	for _, method := range []string{"fakeroot", "built-in"} {
+		t.Run(method, func(t *testing.T) {
+			if method == "fakeroot" {
+				if _, err := exec.LookPath("fakeroot"); err != nil {
+					t.Skipf("fakeroot not available: %v", err)
+				}
+			} else {
+				lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+				defer func() { lookPath = exec.LookPath }()
+			}
+			geteuid = func() int { return 1000 }
+			defer func() { geteuid = os.Geteuid }()
+
+			srcDir, err := ioutil.TempDir("", "builder-src-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(srcDir)
+			outDir, err := ioutil.TempDir("", "builder-out-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(outDir)
+
+			appDir := filepath.Join(srcDir, "usr", "share", "app")
+			if err := os.MkdirAll(appDir, 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(appDir, "data"), []byte("data\n"), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			if err := os.Link(filepath.Join(appDir, "data"), filepath.Join(appDir, "copy")); err != nil {
+				t.Fatalf("Failed to create hard link: %v", err)
+			}
+			if err := os.Symlink("data", filepath.Join(appDir, "link")); err != nil {
+				t.Fatalf("Failed to create symlink: %v", err)
+			}
+
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			builder.DpkgRoot = srcDir
+			builder.PreserveOwner = true
+			builder.UIDMap = IDMap{os.Getuid(): 54321}
+			builder.GIDMap = IDMap{os.Getgid(): 54322}
+
+			outputPath, _, err := builder.Build(context.Background())
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if builder.owners == nil {
+				t.Fatalf("Expected owners to be recorded without root")
+			}
+
+			manifest, err := NewChecksumManifest(context.Background(), outputPath)
+			if err != nil {
+				t.Fatalf("NewChecksumManifest() error = %v", err)
+			}
+			files := make(map[string]ManifestFile)
+			for _, file := range manifest.Files {
+				files[file.Path] = file
+			}
+			owner := fmt.Sprintf("%d:%d", 54321, 54322)
+			for name, want := range map[string]string{
+				"/opt":                    "root:root",
+				"/opt/usr/share/app":      owner,
+				"/opt/usr/share/app/data": owner,
+				"/opt/usr/share/app/copy": owner,
+				"/opt/usr/share/app/link": owner,
+			} {
+				if got := files[name].Owner; got != want {
+					t.Errorf("Entry %s is owned by %q, want %q", name, got, want)
+				}
+			}
+			if files["/opt/usr/share/app/copy"].Type != "hardlink" && files["/opt/usr/share/app/data"].Type != "hardlink" {
+				t.Errorf("Expected the hard link to be kept, got %+v", files["/opt/usr/share/app/copy"])
+			}
+		})
+	}
+}
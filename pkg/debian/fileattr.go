@@ -0,0 +1,146 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// capabilityXattr holds POSIX file capabilities set with setcap
+const capabilityXattr = "security.capability"
+
+// IDMap translates build-host user or group IDs to the IDs used in the package
+type IDMap map[int]int
+
+// ParseIDMap parses "from:to" pairs of numeric IDs, e.g. "1000:0"
+func ParseIDMap(entries []string) (IDMap, error) {
+	m := make(IDMap)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid ID mapping %q (expected from:to)", entry)
+		}
+		from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid ID mapping %q: bad source ID", entry)
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || to < 0 {
+			return nil, fmt.Errorf("invalid ID mapping %q: bad target ID", entry)
+		}
+		m[from] = to
+	}
+	return m, nil
+}
+
+// Map returns the packaged ID for a build-host ID
+func (m IDMap) Map(id int) int {
+	if mapped, ok := m[id]; ok {
+		return mapped
+	}
+	return id
+}
+
+// fileAttrs is the ownership and extended attributes packaged with a file
+type fileAttrs struct {
+	uid, gid int
+	xattrs   map[string]string
+}
+
+// sourceAttrs returns the attributes to package for srcPath. Files are owned by
+// root unless PreserveOwner is set, and extended attributes are only kept with
+// PreserveXattrs; file capabilities dropped without it are reported.
+func (b *Builder) sourceAttrs(srcPath string, info os.FileInfo) (fileAttrs, error) {
+	var attrs fileAttrs
+	if b.PreserveOwner {
+		if uid, gid, ok := fileOwner(info); ok {
+			attrs.uid, attrs.gid = b.UIDMap.Map(uid), b.GIDMap.Map(gid)
+		}
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		// Extended attributes would be read from the link target
+		return attrs, nil
+	}
+	if !b.PreserveXattrs {
+		if !info.IsDir() && hasCapabilities(srcPath) {
+			b.warn("File capabilities on %s are dropped; use --preserve-xattrs to keep them", srcPath)
+		}
+		return attrs, nil
+	}
+
+	xattrs, err := readXattrs(srcPath)
+	if err != nil {
+		return attrs, fmt.Errorf("failed to read extended attributes of %s: %w", srcPath, err)
+	}
+	attrs.xattrs = xattrs
+	return attrs, nil
+}
+
+// applyAttrs sets preserved ownership and extended attributes on a file in the
+// build directory. Changing ownership requires root; unprivileged builds
+// record the owners for the archive instead (see recordOwners).
+func (b *Builder) applyAttrs(targetPath string, attrs fileAttrs) error {
+	if b.owners != nil {
+		if err := b.recordOwner(targetPath, attrs); err != nil {
+			return err
+		}
+	} else if b.PreserveOwner {
+		if err := os.Lchown(targetPath, attrs.uid, attrs.gid); err != nil {
+			return fmt.Errorf("failed to preserve owner of %s (requires root, or use --stream): %w", targetPath, err)
+		}
+		if geteuid() == 0 {
+			b.elevate("changing file owners in the build directory to preserve them")
+		}
+	}
+	return writeXattrs(targetPath, attrs.xattrs)
+}
+
+// fileKey identifies a file on the build host by device and inode
+type fileKey struct {
+	dev, ino uint64
+}
+
+// hardlink is a packaged path that shares its content with an earlier path
+type hardlink struct {
+	packagePath string
+	first       string // Package path of the first link to the same file
+}
+
+// linkTarget returns the target to package for a source symlink. Targets are
+// resolved on the installed system and moved through the PathMapper, so an
+// absolute target stays absolute and a relative one is made relative to the
+// link's new location.
+func (b *Builder) linkTarget(srcPath, packagePath string) (string, error) {
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read symlink %s: %w", srcPath, err)
+	}
+	relPath, err := filepath.Rel(b.SourceDir, srcPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get relative path: %w", err)
+	}
+
+	// Links to compressed man pages point at the compressed file
+	if info, err := os.Lstat(srcPath); err == nil && b.compresses(srcPath, info) {
+		target += ".gz"
+	}
+
+	resolved := filepath.ToSlash(target)
+	if !path.IsAbs(resolved) {
+		resolved = path.Join(path.Dir("/"+filepath.ToSlash(relPath)), resolved)
+	}
+	transformed, _, err := b.PathMapper.TransformPath(resolved)
+	if err != nil {
+		b.log("Keeping symlink target %s for %s: %v", target, packagePath, err)
+		return target, nil
+	}
+
+	if path.IsAbs(filepath.ToSlash(target)) {
+		return transformed, nil
+	}
+	return filepath.Rel(filepath.Dir(packagePath), transformed)
+}
@@ -0,0 +1,91 @@
+package debian
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner of a file
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// readXattrs returns the extended attributes of a file
+func readXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreUnsupported(err)
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, ignoreUnsupported(err)
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+// readXattr returns a single extended attribute
+func readXattr(path, name string) (string, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of %s: %w", name, path, err)
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, value)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of %s: %w", name, path, err)
+	}
+	return string(value[:size]), nil
+}
+
+// hasCapabilities reports whether a file carries POSIX file capabilities
+func hasCapabilities(path string) bool {
+	_, err := syscall.Getxattr(path, capabilityXattr, nil)
+	return err == nil
+}
+
+// writeXattrs sets extended attributes on a file
+func writeXattrs(path string, xattrs map[string]string) error {
+	for name, value := range xattrs {
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("failed to set %s on %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// ignoreUnsupported treats filesystems without xattr support as having none
+func ignoreUnsupported(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENODATA) {
+		return nil
+	}
+	return err
+}
+
+// fileID identifies a file with more than one hard link
+func fileID(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package debian
+
+import (
+	"os"
+)
+
+// fileOwner is not supported on this platform
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// readXattrs is not supported on this platform
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+// hasCapabilities is not supported on this platform
+func hasCapabilities(path string) bool {
+	return false
+}
+
+// writeXattrs is not supported on this platform
+func writeXattrs(path string, xattrs map[string]string) error {
+	return nil
+}
+
+// fileID is not supported on this platform, so hard links are copied as files
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,78 @@
+package debian
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseIDMap(t *testing.T) {
+	m, err := ParseIDMap([]string{"1000:0", " 1001 : 50 "})
+	if err != nil {
+		t.Fatalf("ParseIDMap() error = %v", err)
+	}
+	for from, want := range map[int]int{1000: 0, 1001: 50, 7: 7} {
+		if got := m.Map(from); got != want {
+			t.Errorf("Map(%d) = %d, want %d", from, got, want)
+		}
+	}
+
+	for _, entry := range []string{"1000", "a:0", "1000:b", "-1:0"} {
+		if _, err := ParseIDMap([]string{entry}); err == nil {
+			t.Errorf("Expected ParseIDMap(%q) to fail", entry)
+		}
+	}
+}
+
+func TestSourceAttrs(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	filePath := filepath.Join(srcDir, "file")
+	if err := ioutil.WriteFile(filePath, []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chown(filePath, 1000, 1001); err != nil {
+		t.Skipf("Changing ownership requires root: %v", err)
+	}
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+
+	builder := &Builder{}
+	if attrs, err := builder.sourceAttrs(filePath, info); err != nil || attrs.uid != 0 || attrs.gid != 0 {
+		t.Errorf("Expected root ownership by default, got %+v, %v", attrs, err)
+	}
+
+	builder.PreserveOwner = true
+	builder.UIDMap = IDMap{1000: 0}
+	attrs, err := builder.sourceAttrs(filePath, info)
+	if err != nil {
+		t.Fatalf("sourceAttrs() error = %v", err)
+	}
+	if attrs.uid != 0 || attrs.gid != 1001 {
+		t.Errorf("Expected mapped owner 0:1001, got %d:%d", attrs.uid, attrs.gid)
+	}
+}
+
+func TestWithAttrs(t *testing.T) {
+	archive := newTarArchive(ioutil.Discard, time.Now())
+	header := withAttrs(archive.header("/opt/bin/ping", tar.TypeReg, 0755, time.Now()), fileAttrs{
+		uid:    0,
+		gid:    1001,
+		xattrs: map[string]string{capabilityXattr: "\x01\x00\x00\x02"},
+	})
+	if header.Uname != "root" || header.Gname != "" || header.Gid != 1001 {
+		t.Errorf("Unexpected owner %s/%s %d", header.Uname, header.Gname, header.Gid)
+	}
+	if header.Format != tar.FormatPAX || header.PAXRecords["SCHILY.xattr.security.capability"] != "\x01\x00\x00\x02" {
+		t.Errorf("Expected capability in PAX records, got %v", header.PAXRecords)
+	}
+}
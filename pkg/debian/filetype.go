@@ -0,0 +1,23 @@
+package debian
+
+import (
+	"os"
+)
+
+// checkFileType warns about a packaged file whose content is unexpected at its
+// location or does not match its extension, such as an ELF binary below /etc.
+// The content is sniffed, so extensionless binaries and data files are judged
+// by what they contain.
+func (b *Builder) checkFileType(srcPath string, info os.FileInfo) {
+	if !info.Mode().IsRegular() {
+		return
+	}
+	_, findings, err := b.PathValidator.CheckFileType(b.systemPath(srcPath), srcPath)
+	if err != nil {
+		b.warn("%v", err)
+		return
+	}
+	for _, finding := range findings {
+		b.warn("%s", finding)
+	}
+}
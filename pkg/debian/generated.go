@@ -0,0 +1,89 @@
+package debian
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// generatedFile is a payload file created by the builder rather than copied
+// from the source tree
+type generatedFile struct {
+	systemPath string // Path on the target system, before transformation
+	content    []byte
+	mode       os.FileMode // Default: 0644
+	final      bool        // Whether systemPath is packaged as is, without transformation
+}
+
+// addGeneratedFile queues content to be packaged at systemPath. The path is
+// transformed and linked back at install time like a source path.
+func (b *Builder) addGeneratedFile(systemPath string, content []byte) {
+	b.queueGenerated(generatedFile{systemPath: systemPath, content: content})
+}
+
+// addSystemFile queues content to be packaged at systemPath itself, for
+// files only read at their real location
+func (b *Builder) addSystemFile(systemPath string, content []byte) {
+	b.queueGenerated(generatedFile{systemPath: systemPath, content: content, final: true})
+}
+
+// queueGenerated queues file, replacing a file queued for the same path by
+// an earlier walk of the source tree
+func (b *Builder) queueGenerated(file generatedFile) {
+	for i := range b.generated {
+		if b.generated[i].systemPath == file.systemPath {
+			b.generated[i] = file
+			return
+		}
+	}
+	b.generated = append(b.generated, file)
+}
+
+// addMetainfo queues the AppStream metainfo file described by b.AppStream
+func (b *Builder) addMetainfo() error {
+	if b.AppStream == nil {
+		return nil
+	}
+	component := b.AppStream.WithDefaults(b.Package.Name, b.Package.Description)
+	content, err := component.Metainfo(b.Package.Version)
+	if err != nil {
+		return err
+	}
+	b.log("Generating AppStream metainfo %s", component.Path())
+	b.addGeneratedFile(component.Path(), content)
+	return nil
+}
+
+// packageGenerated places the generated files in the payload, calling write
+// with each file's packaged path, content and mode
+func (b *Builder) packageGenerated(write func(packagePath string, content []byte, mode os.FileMode) error) error {
+	for _, file := range b.generated {
+		transformedPath, needsSymlink := file.systemPath, false
+		if !file.final {
+			var err error
+			transformedPath, needsSymlink, err = b.PathMapper.TransformPath(file.systemPath)
+			if err != nil {
+				return fmt.Errorf("failed to transform generated file %s: %w", file.systemPath, err)
+			}
+		}
+		if needsSymlink && !b.DisableSymlinks {
+			if err := b.SymlinkProcessor.ProcessPath(file.systemPath, transformedPath); err != nil {
+				b.warn("Failed to process symlink for %s: %v", file.systemPath, err)
+			}
+		}
+
+		mode := file.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := write(transformedPath, file.content, mode); err != nil {
+			return err
+		}
+		sum := md5.Sum(file.content)
+		b.PackagedFiles = append(b.PackagedFiles, transformedPath)
+		b.md5sums[transformedPath] = hex.EncodeToString(sum[:])
+		b.fileCopied(transformedPath, int64(len(file.content)))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/appstream"
+)
+
+func TestAppStreamMetainfo(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "appstream-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "bin"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "bin", "app"), []byte("app"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	const packagePath = "/opt/usr/share/metainfo/org.example.App.metainfo.xml"
+	for _, streaming := range []bool{false, true} {
+		name := "copy"
+		if streaming {
+			name = "stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "An app", "utils", "optional", nil), srcDir, srcDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.Streaming = streaming
+			builder.AppStream = &appstream.Component{ID: "org.example.App", Icon: "app"}
+			if err := builder.addMetainfo(); err != nil {
+				t.Fatalf("addMetainfo() error = %v", err)
+			}
+
+			if streaming {
+				var buf bytes.Buffer
+				if err := builder.streamData(context.Background(), &buf); err != nil {
+					t.Fatalf("streamData() error = %v", err)
+				}
+				if readTarGz(t, buf.Bytes())["."+packagePath] == nil {
+					t.Errorf("Expected %s in the payload", packagePath)
+				}
+			} else {
+				if err := builder.copyFiles(context.Background()); err != nil {
+					t.Fatalf("copyFiles() error = %v", err)
+				}
+				content, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, packagePath))
+				if err != nil || !strings.Contains(string(content), `<icon type="stock">app</icon>`) {
+					t.Errorf("Expected generated metainfo, got %q, %v", content, err)
+				}
+			}
+
+			if _, ok := builder.md5sums[packagePath]; !ok {
+				t.Errorf("Expected md5sum for %s", packagePath)
+			}
+			var linked bool
+			for _, request := range builder.SymlinkProcessor.GetQueuedSymlinks() {
+				linked = linked || request.Target == "/usr/share/metainfo/org.example.App.metainfo.xml"
+			}
+			if !linked {
+				t.Errorf("Expected the metainfo file to be linked into /usr/share/metainfo")
+			}
+		})
+	}
+
+	builder := &Builder{Package: NewPackage("app", "1.0", "all", "Test <test@example.com>", "", "utils", "optional", nil), AppStream: &appstream.Component{}}
+	if err := builder.addMetainfo(); err == nil {
+		t.Errorf("Expected metainfo without a summary to be rejected")
+	}
+}
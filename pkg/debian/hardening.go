@@ -0,0 +1,27 @@
+package debian
+
+import (
+	"os"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// checkHardening records a packaged ELF executable or shared library that
+// lacks PIE, RELRO, a non-executable stack or the stack protector. Files that
+// cannot be read are left to the copy to report.
+func (b *Builder) checkHardening(srcPath, packagePath string, info os.FileInfo) {
+	if !b.HardeningCheck || !info.Mode().IsRegular() {
+		return
+	}
+	hardening, err := security.CheckHardening(srcPath)
+	if err != nil || hardening == nil {
+		return
+	}
+	hardening.Path = packagePath
+	if len(hardening.Missing) == 0 {
+		b.log("Hardening: %s", hardening)
+		return
+	}
+	b.warn("Hardening: %s", hardening)
+	b.HardeningFindings = append(b.HardeningFindings, *hardening)
+}
@@ -0,0 +1,121 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HomeMode decides how files below /home and /root in the source tree, such
+// as ~/.config/app, are packaged. Packages cannot own files in home
+// directories, and relocating them below the transform root helps nobody.
+type HomeMode string
+
+const (
+	HomeSkip     HomeMode = "skip"     // Leave them out with a warning
+	HomeSkel     HomeMode = "skel"     // Ship them in /etc/skel, which seeds the homes of new users
+	HomePostinst HomeMode = "postinst" // Ship them below /usr/share/<pkg>/skel and copy them into existing homes from postinst
+)
+
+// ParseHomeMode converts "skip", "skel" or "postinst" to a mode. An empty
+// string selects skip.
+func ParseHomeMode(mode string) (HomeMode, error) {
+	switch m := HomeMode(strings.ToLower(mode)); m {
+	case "":
+		return HomeSkip, nil
+	case HomeSkip, HomeSkel, HomePostinst:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown home mode: %s (available: skip, skel, postinst)", mode)
+	}
+}
+
+// splitHomePath splits a path below /home/<user> or /root into the home
+// directory and the path relative to it. /home itself has no home directory.
+func splitHomePath(absPath string) (home, rest string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(absPath, "/"), "/", 3)
+	switch {
+	case parts[0] == "root":
+		home, parts = "/root", parts[1:]
+	case parts[0] == "home" && len(parts) == 1:
+		return "", "", true
+	case parts[0] == "home":
+		home, parts = "/home/"+parts[1], parts[2:]
+	default:
+		return "", "", false
+	}
+	return home, strings.Join(parts, "/"), true
+}
+
+// homeSkelDir returns the directory the postinst home mode ships the files
+// of home directories in
+func (b *Builder) homeSkelDir() string {
+	return path.Join("/usr/share", b.Package.Name, "skel")
+}
+
+// redirectHome applies the home mode to a source path. It returns the path
+// to package the entry at, "" to leave it out, and whether that path is
+// final rather than to be transformed. Home directories themselves are
+// never packaged.
+func (b *Builder) redirectHome(absPath string, info os.FileInfo) (string, bool, error) {
+	home, rest, ok := splitHomePath(absPath)
+	if !ok {
+		return absPath, false, nil
+	}
+	if rest == "" {
+		if home != "" && (b.HomeMode == "" || b.HomeMode == HomeSkip) {
+			b.warn("Skipping %s: files in home directories are not packaged (--home-mode skip)", absPath)
+			if info.IsDir() {
+				return "", false, filepath.SkipDir
+			}
+		}
+		return "", false, nil
+	}
+
+	var target string
+	switch b.HomeMode {
+	case HomeSkel:
+		target = path.Join("/etc/skel", rest)
+	case HomePostinst:
+		target = path.Join(b.homeSkelDir(), rest)
+		b.homeFiles = true
+	default:
+		return "", false, nil
+	}
+
+	// The same file in several home directories can only be shipped once
+	if !info.IsDir() {
+		if first, ok := b.homeTargets[target]; ok {
+			b.warn("Skipping %s: %s is already shipped from %s", absPath, target, first)
+			return "", false, nil
+		}
+		b.homeTargets[target] = absPath
+	}
+	// /etc/skel is only read at its real location
+	return target, b.HomeMode == HomeSkel, nil
+}
+
+// homeSnippet returns the postinst commands that copy the files of the
+// postinst home mode into the existing home directories. The copy runs as
+// each user, so it cannot be tricked into following their symlinks, and
+// files they already have are kept.
+func (b *Builder) homeSnippet() string {
+	if !b.homeFiles {
+		return ""
+	}
+	skel, _, err := b.PathMapper.TransformPath(b.homeSkelDir())
+	if err != nil {
+		skel = b.homeSkelDir()
+	}
+
+	var snippet strings.Builder
+	fmt.Fprintf(&snippet, "# Copy the per-user files of %s into existing home directories\n", b.Package.Name)
+	snippet.WriteString("getent passwd | while IFS=: read -r user _ uid _ _ home _; do\n")
+	snippet.WriteString("    [ \"$uid\" -ge 1000 ] && [ \"$uid\" -lt 65534 ] && [ -d \"$home\" ] || continue\n")
+	snippet.WriteString("    # cp -n exits non-zero for skipped files with some coreutils versions\n")
+	fmt.Fprintf(&snippet, "    runuser -u \"$user\" -- cp -Rn '%s/.' \"$home/\" 2>/dev/null || true\n", skel)
+	snippet.WriteString("done\n\n")
+	return snippet.String()
+}
@@ -0,0 +1,96 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseHomeMode(t *testing.T) {
+	for input, want := range map[string]HomeMode{
+		"":         HomeSkip,
+		"skip":     HomeSkip,
+		"SKEL":     HomeSkel,
+		"postinst": HomePostinst,
+	} {
+		if got, err := ParseHomeMode(input); err != nil || got != want {
+			t.Errorf("ParseHomeMode(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseHomeMode("opt"); err == nil {
+		t.Errorf("Expected unknown mode to be rejected")
+	}
+}
+
+func TestHomeMode(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	for _, file := range []string{
+		"home/alice/.config/app/app.conf",
+		"home/bob/.config/app/app.conf",
+		"root/.apprc",
+		"usr/share/app/data",
+	} {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		mode         HomeMode
+		want         []string
+		wantSnippet  bool
+		wantWarnings int
+	}{
+		{HomeSkip, []string{"/opt/usr/share/app/data"}, false, 3},
+		{HomeSkel, []string{"/etc/skel/.apprc", "/etc/skel/.config/app/app.conf", "/opt/usr/share/app/data"}, false, 1},
+		{HomePostinst, []string{"/opt/usr/share/app/data", "/opt/usr/share/app/skel/.apprc", "/opt/usr/share/app/skel/.config/app/app.conf"}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.HomeMode = tt.mode
+
+			if err := builder.copyFiles(context.Background()); err != nil {
+				t.Fatalf("copyFiles() error = %v", err)
+			}
+			var files []string
+			for _, file := range builder.PackagedFiles {
+				if info, err := os.Stat(filepath.Join(builder.BuildDir, file)); err == nil && !info.IsDir() {
+					files = append(files, file)
+				}
+			}
+			sort.Strings(files)
+			if strings.Join(files, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Packaged files = %v, want %v", files, tt.want)
+			}
+			if len(builder.Warnings) != tt.wantWarnings {
+				t.Errorf("Warnings = %v, want %d", builder.Warnings, tt.wantWarnings)
+			}
+
+			snippet := builder.homeSnippet()
+			if (snippet != "") != tt.wantSnippet {
+				t.Fatalf("homeSnippet() = %q, want a snippet: %v", snippet, tt.wantSnippet)
+			}
+			if tt.wantSnippet && !strings.Contains(snippet, "runuser -u \"$user\" -- cp -Rn '/opt/usr/share/app/skel/.'") {
+				t.Errorf("Unexpected snippet:\n%s", snippet)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+// SetHooks validates and sets the steps run at the build phases
+func (b *Builder) SetHooks(h *hooks.Hooks) error {
+	if h != nil {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("invalid hooks: %w", err)
+		}
+	}
+	b.Hooks = h
+	return nil
+}
+
+// runHooks runs the hooks configured for point
+func (b *Builder) runHooks(ctx context.Context, point hooks.Point, outputPath string) error {
+	list := b.Hooks.At(point)
+	if len(list) == 0 {
+		return nil
+	}
+	b.log("Running %d %s hook(s)", len(list), point)
+	return hooks.Run(ctx, list, hooks.Env{
+		Point:        point,
+		StagingDir:   b.BuildDir,
+		SourceDir:    b.SourceDir,
+		OutputPath:   outputPath,
+		Package:      b.Package.Name,
+		Version:      b.Package.Version,
+		Architecture: b.Package.Architecture,
+		Output:       logging.Writer(b.logOutput(), slog.LevelInfo),
+	})
+}
+
+// checkStreamingHooks rejects hooks that need the staging directory when the
+// payload is streamed from the source tree instead
+func (b *Builder) checkStreamingHooks() error {
+	if !b.Streaming {
+		return nil
+	}
+	for _, point := range []hooks.Point{hooks.PostCopy, hooks.PrePackage} {
+		if len(b.Hooks.At(point)) > 0 {
+			return fmt.Errorf("%s hooks need a staged payload and cannot be used with streaming builds", point)
+		}
+	}
+	return nil
+}
+
+// rescanStaged updates the packaged files, checksums and sizes after hooks
+// changed the staged payload
+func (b *Builder) rescanStaged() error {
+	var files []string
+	var payloadSize int64
+	md5sums := make(map[string]string)
+	sizes := make(map[string]int64)
+	inodes := make(map[fileKey]bool)
+	err := filepath.Walk(b.BuildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == filepath.Join(b.BuildDir, "DEBIAN") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(b.BuildDir, path)
+		if err != nil {
+			return err
+		}
+		packagePath := "/" + filepath.ToSlash(relPath)
+		files = append(files, packagePath)
+		if info.Mode().IsRegular() {
+			sum, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			md5sums[packagePath] = sum
+			payloadSize += info.Size()
+			// Hard links take no space of their own
+			if key, linked := fileID(info); !linked || !inodes[key] {
+				sizes[packagePath] = info.Size()
+				inodes[key] = linked
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rescan staged payload: %w", err)
+	}
+	b.PackagedFiles, b.md5sums, b.payloadSize, b.fileSizes = files, md5sums, payloadSize, sizes
+	b.installedSize, err = stagedInstalledSize(b.BuildDir)
+	return err
+}
@@ -0,0 +1,216 @@
+package debian
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/i2p"
+)
+
+// I2PPluginWriter writes I2P router console plugins: a zip archive of
+// plugin.config and the payload below its install prefix, such as
+// /opt/<name> with a per-package layout, signed as an su3 file. Routers
+// install plugins whose signer certificate is in their certificates/plugin
+// directory.
+type I2PPluginWriter struct {
+	Signer  string // Signer ID; defaults to the maintainer's email address
+	KeyFile string // PEM RSA signing key, generated if missing; defaults to i2p.DefaultSigningKeyFile
+}
+
+// FileName returns name-version.su3
+func (I2PPluginWriter) FileName(staged *StagedPackage) string {
+	return fmt.Sprintf("%s-%s.su3", staged.Package.Name, pluginVersion(staged.Package.Version))
+}
+
+// pluginVersion drops the epoch, which plugin versions cannot express
+func pluginVersion(version string) string {
+	if _, v, ok := strings.Cut(version, ":"); ok {
+		return v
+	}
+	return version
+}
+
+// signer returns the signer ID of the plugin
+func (p I2PPluginWriter) signer(pkg *Package) (string, error) {
+	if p.Signer != "" {
+		return p.Signer, nil
+	}
+	addr, err := mail.ParseAddress(pkg.Maintainer)
+	if err != nil {
+		return "", fmt.Errorf("I2P plugins need a signer ID; the maintainer has no email address")
+	}
+	return addr.Address, nil
+}
+
+// WritePackage writes the signed plugin to w
+func (p I2PPluginWriter) WritePackage(ctx context.Context, staged *StagedPackage, w io.Writer) error {
+	scripts := make([]string, 0, len(staged.Scripts))
+	for script := range staged.Scripts {
+		scripts = append(scripts, script)
+	}
+	sort.Strings(scripts)
+	for _, script := range scripts {
+		staged.warn("I2P plugins have no %s script; it is not packaged", script)
+	}
+	signer, err := p.signer(staged.Package)
+	if err != nil {
+		return err
+	}
+	keyFile := p.KeyFile
+	if keyFile == "" {
+		if keyFile, err = i2p.DefaultSigningKeyFile(signer); err != nil {
+			return fmt.Errorf("%w; give the signing key with --plugin-key", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(staged.WorkDir, "pkginstall-plugin-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := writePluginZip(ctx, staged, signer, tmp); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key, generated, err := i2p.LoadOrGenerateSigningKey(keyFile, signer)
+	if err != nil {
+		return err
+	}
+	if generated {
+		staged.warn("Generated su3 signing key %s; routers must trust %s to install the plugin",
+			keyFile, filepath.Join(filepath.Dir(keyFile), i2p.CertificateFile(signer)))
+	}
+	info := i2p.SU3Info{
+		Version:     pluginVersion(staged.Package.Version),
+		Signer:      signer,
+		FileType:    i2p.SU3FileZip,
+		ContentType: i2p.SU3ContentPlugin,
+	}
+	return i2p.WriteSU3(w, info, tmp, size, key)
+}
+
+// pluginConfig returns the plugin.config of the package
+func pluginConfig(staged *StagedPackage, signer string) string {
+	description, _, _ := strings.Cut(staged.Package.Description, "\n")
+	lines := []string{
+		"name=" + staged.Package.Name,
+		"signer=" + signer,
+		"version=" + pluginVersion(staged.Package.Version),
+		fmt.Sprintf("date=%d", staged.BuildTime.UnixMilli()),
+		"author=" + staged.Package.Maintainer,
+		"description=" + strings.TrimSpace(description),
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// writePluginZip writes plugin.config and the payload below its install
+// prefix as a zip archive
+func writePluginZip(ctx context.Context, staged *StagedPackage, signer string, w io.Writer) error {
+	files, err := staged.files()
+	if err != nil {
+		return err
+	}
+	prefix := pluginPrefix(files)
+	if prefix == "/" {
+		staged.warn("The payload has no common install directory; the plugin keeps the full paths")
+	}
+
+	zw := zip.NewWriter(w)
+	config, err := zw.CreateHeader(&zip.FileHeader{Name: "plugin.config", Method: zip.Deflate, Modified: staged.BuildTime})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(config, pluginConfig(staged, signer)); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(file.Path, prefix)
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: staged.BuildTime}
+		header.SetMode(file.Info.Mode())
+		switch {
+		case file.Info.IsDir():
+			header.Name += "/"
+			header.Method = zip.Store
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+		case file.Link != "":
+			staged.warn("I2P plugins cannot contain symlinks; %s is not packaged", file.Path)
+		case file.Info.Mode().IsRegular():
+			entry, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			if err := copyStagedFile(entry, file.Src); err != nil {
+				return err
+			}
+		default:
+			staged.warn("I2P plugins cannot contain special files; %s is not packaged", file.Path)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write plugin archive: %w", err)
+	}
+	return nil
+}
+
+// pluginPrefix returns the deepest directory, ending in a slash, that holds
+// every file of the payload
+func pluginPrefix(files []stagedFile) string {
+	var common []string
+	first := true
+	for _, file := range files {
+		if file.Info.IsDir() {
+			continue
+		}
+		dir := strings.Split(strings.Trim(filepath.ToSlash(filepath.Dir(file.Path)), "/"), "/")
+		if first {
+			common, first = dir, false
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(dir) && common[n] == dir[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 || common[0] == "" {
+		return "/"
+	}
+	return "/" + strings.Join(common, "/") + "/"
+}
+
+// copyStagedFile copies a staged file into w
+func copyStagedFile(w io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package debian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+// cacheFormatVersion is bumped whenever staged files or the index change
+// meaning, which discards existing caches
+const cacheFormatVersion = 1
+
+// DefaultCacheDir returns where incremental builds of a package keep their
+// staging directory: pkginstall/build/<name>_<arch> in the user cache
+// directory, honouring $XDG_CACHE_HOME
+func DefaultCacheDir(name, arch string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "pkginstall", "build", name+"_"+arch)
+}
+
+// cacheEntry is a staged file and the state of the source it was copied from
+type cacheEntry struct {
+	Source     string `json:"source"`
+	Size       int64  `json:"size"`
+	ModTime    int64  `json:"mtime"` // Nanoseconds since the epoch
+	Mode       uint32 `json:"mode"`
+	MD5        string `json:"md5"`
+	StagedSize int64  `json:"staged_size"`
+}
+
+// cacheIndex is the index.json of a cache directory
+type cacheIndex struct {
+	Settings string                `json:"settings"` // Settings the staged files were produced with
+	Files    map[string]cacheEntry `json:"files"`    // Keyed by packaged path
+}
+
+// stagingCache keeps the staging directory of the previous build, so files
+// whose source has the same size, modification time and mode are neither
+// copied nor hashed again
+type stagingCache struct {
+	dir      string
+	settings string
+	lock     *os.File
+	mu       sync.Mutex
+	previous map[string]cacheEntry
+	current  map[string]cacheEntry
+	hits     int
+}
+
+// incrementalBlocker returns why the build cannot reuse a staging directory,
+// or "" if it can
+func (b *Builder) incrementalBlocker() string {
+	switch {
+	case b.Streaming || b.PreserveXattrs:
+		return "streaming builds do not stage files"
+	case b.PreserveOwner:
+		return "preserved owners are not cached"
+	case b.Strip.DebugPackage:
+		return "debug symbols are split while copying"
+	case len(b.Hooks.At(hooks.PostCopy)) > 0:
+		return "post_copy hooks may change staged files"
+	}
+	return ""
+}
+
+// openCache replaces the temporary build directory with the staging
+// directory in CacheDir. The index is removed until the build succeeds, so a
+// failed build leaves nothing that a later build would trust.
+func (b *Builder) openCache(ctx context.Context) error {
+	if b.CacheDir == "" {
+		return nil
+	}
+	if reason := b.incrementalBlocker(); reason != "" {
+		b.log("Incremental build disabled: %s", reason)
+		return nil
+	}
+	if err := os.MkdirAll(b.CacheDir, 0755); err != nil {
+		return ci.Errorf(ci.ClassEnvironment, "failed to create cache directory: %w", err)
+	}
+	lock, err := b.lockCache(ctx)
+	if err != nil {
+		return err
+	}
+
+	settings, _ := json.Marshal(struct {
+		Version     int          `json:"version"`
+		Strip       StripOptions `json:"strip"`
+		FixRPath    bool         `json:"fix_rpath,omitempty"`
+		FixDevFiles bool         `json:"fix_dev_files,omitempty"`
+	}{cacheFormatVersion, b.Strip, b.FixRPath, b.FixDevFiles})
+	cache := &stagingCache{
+		dir:      b.CacheDir,
+		settings: string(settings),
+		lock:     lock,
+		current:  make(map[string]cacheEntry),
+	}
+	staging := cache.staging()
+	var index cacheIndex
+	if data, err := os.ReadFile(cache.indexPath()); err == nil && json.Unmarshal(data, &index) == nil && index.Settings == cache.settings {
+		cache.previous = index.Files
+	} else {
+		// Without a matching index the staged files cannot be trusted
+		if err := os.RemoveAll(staging); err != nil {
+			lock.Close()
+			return fmt.Errorf("failed to clear staging directory: %w", err)
+		}
+	}
+	if err := os.Remove(cache.indexPath()); err != nil && !os.IsNotExist(err) {
+		lock.Close()
+		return fmt.Errorf("failed to reset cache index: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(staging, "DEBIAN")); err != nil {
+		lock.Close()
+		return fmt.Errorf("failed to clear staging directory: %w", err)
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		lock.Close()
+		return ci.Errorf(ci.ClassEnvironment, "failed to create staging directory: %w", err)
+	}
+
+	os.RemoveAll(b.BuildDir)
+	b.BuildDir = staging
+	b.incremental = cache
+	b.log("Incremental build in %s (%d cached files)", b.CacheDir, len(cache.previous))
+	return nil
+}
+
+// lockCache waits until no other build uses CacheDir
+func (b *Builder) lockCache(ctx context.Context) (*os.File, error) {
+	path := filepath.Join(b.CacheDir, ".lock")
+	waiting := false
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, ci.Errorf(ci.ClassEnvironment, "failed to create lock file: %w", err)
+		}
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			return f, nil
+		}
+		f.Close()
+
+		if !waiting {
+			logging.Logf(b.logOutput(), slog.LevelInfo, "Waiting for another build using %s", b.CacheDir)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("package build cancelled while waiting for cache %s: %w", b.CacheDir, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// closeCache saves the index after a successful build and releases the cache
+func (b *Builder) closeCache(buildErr error) {
+	cache := b.incremental
+	if cache == nil || cache.lock == nil {
+		return
+	}
+	defer func() {
+		cache.lock.Close()
+		cache.lock = nil
+	}()
+	if buildErr != nil {
+		return
+	}
+	data, err := json.Marshal(cacheIndex{Settings: cache.settings, Files: cache.current})
+	if err == nil {
+		err = os.WriteFile(cache.indexPath(), data, 0644)
+	}
+	if err != nil {
+		b.warn("Failed to save build cache index: %v", err)
+	}
+}
+
+func (c *stagingCache) staging() string {
+	return filepath.Join(c.dir, "staging")
+}
+
+func (c *stagingCache) indexPath() string {
+	return filepath.Join(c.dir, "index.json")
+}
+
+// lookup returns the staged copy of a source file if it is unchanged
+func (c *stagingCache) lookup(srcPath, targetPath, packagePath string, info os.FileInfo) (copyResult, bool) {
+	if c == nil {
+		return copyResult{}, false
+	}
+	entry, ok := c.previous[packagePath]
+	if !ok || entry.Source != srcPath || entry.Size != info.Size() ||
+		entry.ModTime != info.ModTime().UnixNano() || entry.Mode != uint32(info.Mode()) {
+		return copyResult{}, false
+	}
+	staged, err := os.Lstat(targetPath)
+	if err != nil || !staged.Mode().IsRegular() || staged.Size() != entry.StagedSize {
+		return copyResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current[packagePath] = entry
+	c.hits++
+	return copyResult{packagePath: packagePath, size: entry.StagedSize, md5sum: entry.MD5}, true
+}
+
+// cachedFiles returns how many files were reused
+func (c *stagingCache) cachedFiles() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// store records a file copied by this build
+func (c *stagingCache) store(job copyJob, result copyResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current[job.packagePath] = cacheEntry{
+		Source:     job.srcPath,
+		Size:       job.info.Size(),
+		ModTime:    job.info.ModTime().UnixNano(),
+		Mode:       uint32(job.info.Mode()),
+		MD5:        result.md5sum,
+		StagedSize: result.size,
+	}
+}
+
+// prepare removes what a previous build staged at targetPath unless it is a
+// directory that stays one. Files are replaced rather than rewritten, as
+// they may be hard links.
+func (c *stagingCache) prepare(targetPath string, isDir bool) error {
+	if c == nil {
+		return nil
+	}
+	info, err := os.Lstat(targetPath)
+	if os.IsNotExist(err) || (err == nil && isDir && info.IsDir()) {
+		return nil
+	}
+	if err := os.RemoveAll(targetPath); err != nil {
+		return fmt.Errorf("failed to replace staged %s: %w", targetPath, err)
+	}
+	return nil
+}
+
+// prune removes staged files this build did not package, and directories
+// left empty
+func (c *stagingCache) prune(buildDir string, packaged []string, dirs map[string]bool) error {
+	if c == nil {
+		return nil
+	}
+	keep := make(map[string]bool, len(packaged))
+	for _, packagePath := range packaged {
+		keep[packagePath] = true
+	}
+	var paths []string
+	err := filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == buildDir {
+			return nil
+		}
+		rel, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return err
+		}
+		rel = "/" + filepath.ToSlash(rel)
+		if rel == "/DEBIAN" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			if !dirs[rel] {
+				paths = append(paths, path)
+			}
+			return nil
+		}
+		if !keep[rel] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune staging directory: %w", err)
+	}
+	// Deepest first, so parents are empty once their children are gone
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, path := range paths {
+		if entries, err := os.ReadDir(path); err == nil && len(entries) == 0 {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to prune staging directory: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+)
+
+func TestIncrementalBuild(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "incremental-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "incremental-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	cacheDir := filepath.Join(outDir, "cache")
+
+	binDir := filepath.Join(srcDir, "usr", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(filepath.Join(binDir, name), []byte(name+"\n"), 0755); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	build := func(opts ...BuilderOption) *BuildReport {
+		t.Helper()
+		opts = append([]BuilderOption{WithCacheDir(cacheDir)}, opts...)
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir, opts...)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.DpkgRoot = srcDir
+		_, report, err := builder.Build(context.Background())
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, "staging")); err != nil {
+			t.Fatalf("staging directory was not kept: %v", err)
+		}
+		return report
+	}
+	staged := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(cacheDir, "staging", "opt", "usr", "bin", name))
+		if err != nil {
+			return ""
+		}
+		return string(content)
+	}
+
+	if report := build(); report.CachedFiles != 0 || report.Files != 3 {
+		t.Errorf("first build reused %d of %d files, want 0 of 3", report.CachedFiles, report.Files)
+	}
+	if report := build(); report.CachedFiles != 3 {
+		t.Errorf("unchanged rebuild reused %d files, want 3", report.CachedFiles)
+	}
+
+	// A changed file is copied again even if its size stays the same
+	later := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(filepath.Join(binDir, "a"), []byte("A\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(binDir, "a"), later, later); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(binDir, "c")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	report := build()
+	if report.CachedFiles != 1 || report.Files != 2 {
+		t.Errorf("rebuild reused %d of %d files, want 1 of 2", report.CachedFiles, report.Files)
+	}
+	if got := staged("a"); got != "A\n" {
+		t.Errorf("staged a = %q, want the changed content", got)
+	}
+	if got := staged("c"); got != "" {
+		t.Errorf("removed file c is still staged: %q", got)
+	}
+
+	// Other strip settings invalidate everything staged
+	if report := build(WithStrip(StripOptions{Executables: true})); report.CachedFiles != 0 {
+		t.Errorf("build with new strip settings reused %d files, want 0", report.CachedFiles)
+	}
+}
+
+func TestIncrementalBuildFailure(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "incremental-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "incremental-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	cacheDir := filepath.Join(outDir, "cache")
+
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	build := func(opts ...BuilderOption) (*BuildReport, error) {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir,
+			append([]BuilderOption{WithCacheDir(cacheDir)}, opts...)...)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.DpkgRoot = srcDir
+		_, report, err := builder.Build(context.Background())
+		return report, err
+	}
+
+	if _, err := build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	// A pre_package hook fails the build after the payload was staged
+	if _, err := build(WithHooks(&hooks.Hooks{PrePackage: []hooks.Hook{{Action: "forbid", Args: []string{"file"}}}})); err == nil {
+		t.Fatal("Build() succeeded despite the forbid hook")
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "index.json")); !os.IsNotExist(err) {
+		t.Errorf("a failed build left a cache index behind: %v", err)
+	}
+	report, err := build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if report.CachedFiles != 0 {
+		t.Errorf("build after a failure reused %d files, want 0", report.CachedFiles)
+	}
+}
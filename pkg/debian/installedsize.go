@@ -0,0 +1,47 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Installed-Size is computed as dpkg-gencontrol does: regular files and
+// symlinks count their size rounded up to whole KiB, further hard links to a
+// file count nothing, and directories and other objects count 1 KiB each.
+
+// fileBlocks returns the Installed-Size in KiB of a file or symlink of size bytes
+func fileBlocks(size int64) int64 {
+	return (size + 1023) / 1024
+}
+
+// stagedInstalledSize returns the Installed-Size in KiB of the package tree
+// staged at root, ignoring its DEBIAN directory
+func stagedInstalledSize(root string) (int64, error) {
+	var size int64
+	seen := make(map[fileKey]bool)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == filepath.Join(root, "DEBIAN") {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			size++
+			return nil
+		}
+		if key, ok := fileID(info); ok {
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		size += fileBlocks(info.Size())
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute installed size: %w", err)
+	}
+	return size, nil
+}
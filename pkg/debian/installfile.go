@@ -0,0 +1,115 @@
+package debian
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/installmap"
+)
+
+// SetInstallFile applies a dh_install style install file, which maps files
+// of the project tree in SourceDir to the directories they are installed in.
+// The mapped files are staged, as hard links where possible, in a new
+// directory below WorkDir that becomes SourceDir and is removed by Clean.
+// The staged tree is then transformed and validated like any source tree.
+func (b *Builder) SetInstallFile(file string) error {
+	if b.fsSource != "" {
+		return fmt.Errorf("an install file cannot be applied to a staged source directory")
+	}
+	entries, err := installmap.ParseFile(file)
+	if err != nil {
+		return err
+	}
+	mappings, err := installmap.Resolve(b.SourceDir, entries)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	stageDir, err := os.MkdirTemp(b.WorkDir, "pkginstall-src-")
+	if err != nil {
+		return fmt.Errorf("failed to create source directory: %w", err)
+	}
+	// The staging directory becomes the package root
+	if err := os.Chmod(stageDir, 0755); err != nil {
+		os.RemoveAll(stageDir)
+		return fmt.Errorf("failed to prepare source directory: %w", err)
+	}
+	for _, mapping := range mappings {
+		src := filepath.Join(b.SourceDir, filepath.FromSlash(mapping.Source))
+		dst := filepath.Join(stageDir, filepath.FromSlash(mapping.Target))
+		if err := stageTree(src, dst); err != nil {
+			os.RemoveAll(stageDir)
+			return fmt.Errorf("%s: line %d: %w", file, mapping.Line, err)
+		}
+		b.log("Installing %s at %s", mapping.Source, mapping.Target)
+	}
+	b.SourceDir, b.fsSource = stageDir, stageDir
+	return nil
+}
+
+// stageTree stages the file, symlink or directory tree at src at dst.
+// Directories already staged at dst are merged into.
+func stageTree(src, dst string) error {
+	return filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target, err)
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(target, mode.Perm()|0700); err != nil && !os.IsExist(err) {
+				return fmt.Errorf("failed to create %s: %w", target, err)
+			}
+			return nil
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(srcPath)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", srcPath, err)
+			}
+			return os.Symlink(link, target)
+		case mode.IsRegular():
+			if err := os.Link(srcPath, target); err == nil || os.IsExist(err) {
+				return err
+			}
+			return stageCopy(srcPath, target, info)
+		default:
+			return fmt.Errorf("cannot install %s: only regular files, directories and symlinks are supported", srcPath)
+		}
+	})
+}
+
+// stageCopy copies the regular file at src to dst, keeping its mode and
+// modification time, where it cannot be hard linked
+func stageCopy(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	// Set after writing, since the umask would drop setuid bits
+	if err := os.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", dst, err)
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
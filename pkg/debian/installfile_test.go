@@ -0,0 +1,86 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetInstallFile(t *testing.T) {
+	projectDir, err := ioutil.TempDir("", "install-file-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(projectDir)
+
+	files := map[string]os.FileMode{
+		"build/nginx":        0755,
+		"conf/nginx.conf":    0644,
+		"conf/sites/default": 0644,
+		"README":             0644,
+	}
+	for file, mode := range files {
+		path := filepath.Join(projectDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), mode); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.Symlink("nginx.conf", filepath.Join(projectDir, "conf/current.conf")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	installFile := filepath.Join(projectDir, "install")
+	if err := ioutil.WriteFile(installFile, []byte("build/nginx usr/sbin\nconf/* etc/nginx/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write install file: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("nginx", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), projectDir, projectDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	if err := builder.SetInstallFile(installFile); err != nil {
+		t.Fatalf("SetInstallFile() error = %v", err)
+	}
+	stageDir := builder.SourceDir
+	if stageDir == projectDir {
+		t.Fatal("Expected the install file to stage a new source directory")
+	}
+	if err := builder.SetInstallFile(installFile); err == nil {
+		t.Error("Expected an error when applying an install file twice")
+	}
+	if err := builder.copyFiles(context.Background()); err != nil {
+		t.Fatalf("copyFiles() error = %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(builder.BuildDir, "opt/usr/sbin/nginx"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected opt/usr/sbin/nginx to be packaged as an executable, got %v, %v", info, err)
+	}
+	for _, file := range []string{"opt/etc/nginx/nginx.conf", "opt/etc/nginx/sites/default"} {
+		if _, err := os.Stat(filepath.Join(builder.BuildDir, file)); err != nil {
+			t.Errorf("Expected %s to be packaged: %v", file, err)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(builder.BuildDir, "opt/etc/nginx/current.conf")); err != nil || link != "nginx.conf" {
+		t.Errorf("Expected the symlink to be packaged, got %q, %v", link, err)
+	}
+	for _, file := range []string{"opt/README", "opt/install", "opt/build"} {
+		if _, err := os.Lstat(filepath.Join(builder.BuildDir, file)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be left out, got %v", file, err)
+		}
+	}
+
+	if err := builder.Clean(); err != nil {
+		t.Fatalf("Clean() error = %v", err)
+	}
+	if _, err := os.Stat(stageDir); !os.IsNotExist(err) {
+		t.Errorf("Expected Clean() to remove the staged source directory, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "build/nginx")); err != nil {
+		t.Errorf("Expected the project files to be kept: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package debian
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// PayloadFinding is junk, or a set of duplicate files, found in the payload
+// before it is archived, with a suggestion to exclude or symlink it
+type PayloadFinding struct {
+	Kind       string   `json:"kind"`           // junk or duplicate
+	Reason     string   `json:"reason"`         // What the paths are, such as "Python bytecode"
+	Paths      []string `json:"paths"`          // Packaged paths, sorted
+	Size       int64    `json:"size,omitempty"` // Bytes of the junk, or bytes taken by the extra copies
+	Suggestion string   `json:"suggestion"`
+}
+
+// String returns the finding on one line, naming the first few paths
+func (f PayloadFinding) String() string {
+	paths := f.Paths
+	more := ""
+	if len(paths) > 3 {
+		paths, more = paths[:3], fmt.Sprintf(" and %d more", len(f.Paths)-3)
+	}
+	return fmt.Sprintf("%s: %s%s (%s); %s", f.Reason, strings.Join(paths, ", "), more, security.FormatSize(f.Size), f.Suggestion)
+}
+
+// junkRule recognises one kind of junk file by its packaged path
+type junkRule struct {
+	reason  string
+	exclude string // Exclude pattern that drops the junk
+	match   func(packagePath string) bool
+}
+
+// suffixRule returns a rule matching file names that end in one of suffixes
+func suffixRule(reason, exclude string, suffixes ...string) junkRule {
+	return junkRule{reason, exclude, func(packagePath string) bool {
+		name := path.Base(packagePath)
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(name, suffix) && name != suffix {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// nameRule returns a rule matching file names that match re
+func nameRule(reason, exclude string, re *regexp.Regexp) junkRule {
+	return junkRule{reason, exclude, func(packagePath string) bool {
+		return re.MatchString(path.Base(packagePath))
+	}}
+}
+
+// junkDirs are version control and cache directories, reported once per
+// directory
+var junkDirs = map[string]string{
+	".git":        "Git repository",
+	".svn":        "Subversion working copy",
+	".hg":         "Mercurial repository",
+	"CVS":         "CVS working copy",
+	"__pycache__": "Python bytecode cache",
+}
+
+// junkRules returns the rules for junk files, checked in order
+func (b *Builder) junkRules() []junkRule {
+	return []junkRule{
+		suffixRule("object files", "*.o", ".o"),
+		suffixRule("Python bytecode", "*.py[co]", ".pyc", ".pyo"),
+		{"core dumps", "core*", func(packagePath string) bool {
+			return coreDumpRe.MatchString(path.Base(packagePath)) && b.isCoreDump(packagePath)
+		}},
+		suffixRule("editor backups", "*~", "~"),
+		suffixRule("editor swap files", "*.sw[op]", ".swp", ".swo"),
+		nameRule("editor lock files", ".#*", editorLockRe),
+		nameRule("editor autosaves", "#*#", editorAutosaveRe),
+		suffixRule("patch leftovers", "*.orig", ".orig"),
+		suffixRule("rejected patch hunks", "*.rej", ".rej"),
+		nameRule("Finder metadata", ".DS_Store", finderMetadataRe),
+	}
+}
+
+var (
+	coreDumpRe       = regexp.MustCompile(`^core(\.[0-9]+)?$`)
+	editorLockRe     = regexp.MustCompile(`^\.#.`)
+	editorAutosaveRe = regexp.MustCompile(`^#.*#$`)
+	finderMetadataRe = regexp.MustCompile(`^\.DS_Store$`)
+)
+
+// Duplicates are reported when a file has at least duplicateCopies copies,
+// or when its extra copies take at least duplicateWaste bytes
+const (
+	duplicateCopies = 3
+	duplicateWaste  = 1 << 20
+)
+
+// analyzePayload flags junk and duplicate files in the packaged payload. The
+// findings are warnings only, listed in the build report with a suggestion.
+func (b *Builder) analyzePayload() {
+	b.PayloadFindings = append(b.junkFindings(), b.duplicateFindings()...)
+	for _, finding := range b.PayloadFindings {
+		logging.Logf(b.logOutput(), slog.LevelWarn, "Payload %s: %s", finding.Kind, finding)
+	}
+}
+
+// junkFindings returns the junk in the payload, one finding per version
+// control directory and per kind of junk file
+func (b *Builder) junkFindings() []PayloadFinding {
+	rules := b.junkRules()
+	dirs := make(map[string]*PayloadFinding)
+	kinds := make(map[string]*PayloadFinding)
+	for _, file := range b.PackagedFiles {
+		if dir, reason := junkDir(file); dir != "" {
+			finding := dirs[dir]
+			if finding == nil {
+				finding = &PayloadFinding{
+					Kind:       "junk",
+					Reason:     reason,
+					Suggestion: fmt.Sprintf("exclude it with --exclude '%s/' or a line in %s", path.Base(dir), pattern.IgnoreFileName),
+				}
+				dirs[dir] = finding
+			}
+			finding.Paths = append(finding.Paths, file)
+			finding.Size += b.fileSizes[file]
+			continue
+		}
+
+		for _, rule := range rules {
+			if !rule.match(file) {
+				continue
+			}
+			finding := kinds[rule.reason]
+			if finding == nil {
+				finding = &PayloadFinding{
+					Kind:       "junk",
+					Reason:     rule.reason,
+					Suggestion: fmt.Sprintf("exclude them with --exclude '%s' or a line in %s", rule.exclude, pattern.IgnoreFileName),
+				}
+				kinds[rule.reason] = finding
+			}
+			finding.Paths = append(finding.Paths, file)
+			finding.Size += b.fileSizes[file]
+			break
+		}
+	}
+
+	var findings []PayloadFinding
+	for _, group := range []map[string]*PayloadFinding{dirs, kinds} {
+		for _, finding := range group {
+			sort.Strings(finding.Paths)
+			findings = append(findings, *finding)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Paths[0] < findings[j].Paths[0] })
+	return findings
+}
+
+// junkDir returns the outermost version control or cache directory file is
+// in, and what it is
+func junkDir(file string) (string, string) {
+	parts := strings.Split(strings.TrimPrefix(file, "/"), "/")
+	for i, part := range parts[:len(parts)-1] {
+		if reason, ok := junkDirs[part]; ok {
+			return "/" + strings.Join(parts[:i+1], "/"), reason
+		}
+	}
+	return "", ""
+}
+
+// isCoreDump reports whether the staged file at packagePath is an ELF core
+// file. Files that are not staged, as when streaming, are judged by name.
+func (b *Builder) isCoreDump(packagePath string) bool {
+	f, err := os.Open(filepath.Join(b.BuildDir, packagePath))
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	header := make([]byte, 18)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.HasPrefix(header, []byte("\x7fELF")) {
+		return false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[5] == 2 {
+		order = binary.BigEndian
+	}
+	// e_type 4 is ET_CORE
+	return order.Uint16(header[16:]) == 4
+}
+
+// duplicateFindings returns the sets of packaged files with identical
+// content that are worth replacing by symlinks. Hard links and empty files
+// take no extra space and are not reported.
+func (b *Builder) duplicateFindings() []PayloadFinding {
+	bySum := make(map[string][]string)
+	for file, sum := range b.md5sums {
+		if b.fileSizes[file] > 0 {
+			bySum[sum] = append(bySum[sum], file)
+		}
+	}
+
+	var findings []PayloadFinding
+	for _, files := range bySum {
+		if len(files) < 2 {
+			continue
+		}
+		sort.Strings(files)
+		waste := b.fileSizes[files[0]] * int64(len(files)-1)
+		if len(files) < duplicateCopies && waste < duplicateWaste {
+			continue
+		}
+		findings = append(findings, PayloadFinding{
+			Kind:       "duplicate",
+			Reason:     fmt.Sprintf("%d identical copies", len(files)),
+			Paths:      files,
+			Size:       waste,
+			Suggestion: fmt.Sprintf("keep %s and replace the other copies with symlinks to it", files[0]),
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Size != findings[j].Size {
+			return findings[i].Size > findings[j].Size
+		}
+		return findings[i].Paths[0] < findings[j].Paths[0]
+	})
+	return findings
+}
@@ -0,0 +1,106 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzePayload(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	// A little-endian ELF header of type ET_CORE
+	coreDump := append([]byte("\x7fELF\x02\x01\x01"), make([]byte, 9)...)
+	coreDump = append(coreDump, 4, 0)
+	files := map[string]string{
+		"app/.git/HEAD":           "ref: refs/heads/main\n",
+		"app/.git/config":         "[core]\n",
+		"app/lib/util.py":         "x = 1\n",
+		"app/lib/util.pyc":        "bytecode",
+		"app/lib/__init__.pyc":    "bytecode",
+		"app/build/main.o":        "object",
+		"app/bin/core":            string(coreDump),
+		"app/share/core":          "not a core dump, just a word\n",
+		"app/etc/app.conf~":       "backup",
+		"app/share/a/logo.svg":    "<svg/>",
+		"app/share/b/logo.svg":    "<svg/>",
+		"app/share/c/logo.svg":    "<svg/>",
+		"app/share/d/license.txt": "MIT",
+		"app/share/e/license.txt": "MIT",
+		"app/share/empty1":        "",
+		"app/share/empty2":        "",
+		"app/share/empty3":        "",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	// Hard links share their content and are not duplicates
+	for _, name := range []string{"app/share/link1", "app/share/link2"} {
+		if err := os.Link(filepath.Join(srcDir, "app/lib/util.py"), filepath.Join(srcDir, name)); err != nil {
+			t.Fatalf("Failed to create hard link: %v", err)
+		}
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+	if err := builder.copyFiles(context.Background()); err != nil {
+		t.Fatalf("copyFiles() error = %v", err)
+	}
+	builder.analyzePayload()
+
+	found := make(map[string]PayloadFinding)
+	for _, finding := range builder.PayloadFindings {
+		found[finding.Reason] = finding
+	}
+
+	tests := []struct {
+		reason string
+		paths  []string
+	}{
+		{"Git repository", []string{"/app/.git/HEAD", "/app/.git/config"}},
+		{"Python bytecode", []string{"/app/lib/__init__.pyc", "/app/lib/util.pyc"}},
+		{"object files", []string{"/app/build/main.o"}},
+		{"core dumps", []string{"/app/bin/core"}},
+		{"editor backups", []string{"/app/etc/app.conf~"}},
+		{"3 identical copies", []string{"/app/share/a/logo.svg", "/app/share/b/logo.svg", "/app/share/c/logo.svg"}},
+	}
+	for _, tt := range tests {
+		finding, ok := found[tt.reason]
+		if !ok {
+			t.Errorf("Expected a %s finding, got %v", tt.reason, builder.PayloadFindings)
+			continue
+		}
+		if strings.Join(finding.Paths, ",") != strings.Join(tt.paths, ",") {
+			t.Errorf("%s: paths = %v, want %v", tt.reason, finding.Paths, tt.paths)
+		}
+	}
+	if len(builder.PayloadFindings) != len(tests) {
+		t.Errorf("Expected %d findings, got %v", len(tests), builder.PayloadFindings)
+	}
+
+	if s := found["Python bytecode"].Suggestion; !strings.Contains(s, "--exclude '*.py[co]'") {
+		t.Errorf("Unexpected suggestion %q", s)
+	}
+	if s := found["3 identical copies"].Suggestion; !strings.Contains(s, "keep /app/share/a/logo.svg") {
+		t.Errorf("Unexpected suggestion %q", s)
+	}
+	if report := builder.report("", nil); len(report.Payload) != len(tests) {
+		t.Errorf("Expected the findings in the build report, got %v", report.Payload)
+	}
+}
@@ -0,0 +1,62 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+// lockRetryInterval is how often a build waiting for another build of the
+// same package retries the lock
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockOutput waits until no other build writes the same package to OutputDir
+// and returns a function that releases the lock. The lock file is removed on
+// release.
+func (b *Builder) lockOutput(ctx context.Context) (func(), error) {
+	name := fmt.Sprintf("%s_%s_%s", b.Package.Name, b.Package.Version, b.Package.Architecture)
+	path := filepath.Join(b.OutputDir, "."+name+".lock")
+	waiting := false
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, ci.Errorf(ci.ClassEnvironment, "failed to create lock file: %w", err)
+		}
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			// The previous holder removes the file on release, so the lock is
+			// only valid if path still refers to the file that was locked
+			current, statErr := os.Stat(path)
+			info, err := f.Stat()
+			if statErr == nil && err == nil && os.SameFile(current, info) {
+				return func() {
+					os.Remove(path)
+					f.Close()
+				}, nil
+			}
+			f.Close()
+			continue
+		}
+		f.Close()
+
+		if !waiting {
+			logging.Logf(b.logOutput(), slog.LevelInfo, "Waiting for another build of %s %s in %s", b.Package.Name, b.Package.Version, b.OutputDir)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("package build cancelled while waiting for another build of %s %s: %w", b.Package.Name, b.Package.Version, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package debian
+
+import (
+	"os"
+)
+
+// tryLockFile is not supported on this platform, so concurrent builds of the
+// same package are not serialized
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
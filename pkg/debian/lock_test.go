@@ -0,0 +1,75 @@
+package debian
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+func TestLockOutput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("build locking is only supported on Linux")
+	}
+	outDir, err := ioutil.TempDir("", "lock-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	newBuilder := func(version string) *Builder {
+		return &Builder{
+			Package:   NewPackage("app", version, "all", "Test <test@example.com>", "d", "utils", "optional", nil),
+			OutputDir: outDir,
+			logger:    logging.Discard(),
+		}
+	}
+
+	unlock, err := newBuilder("1.0").lockOutput(context.Background())
+	if err != nil {
+		t.Fatalf("lockOutput() error = %v", err)
+	}
+
+	// Another version of the package is not blocked
+	unlockOther, err := newBuilder("2.0").lockOutput(context.Background())
+	if err != nil {
+		t.Fatalf("lockOutput() error = %v", err)
+	}
+	unlockOther()
+
+	// The same package waits until the lock is released or ctx ends
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockRetryInterval)
+	defer cancel()
+	if _, err := newBuilder("1.0").lockOutput(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the second build to wait, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		unlockSecond, err := newBuilder("1.0").lockOutput(context.Background())
+		if err == nil {
+			unlockSecond()
+		}
+		done <- err
+	}()
+	time.Sleep(lockRetryInterval)
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("lockOutput() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The waiting build did not get the lock")
+	}
+
+	if _, err := os.Stat(filepath.Join(outDir, ".app_1.0_all.lock")); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock file to be removed, got %v", err)
+	}
+}
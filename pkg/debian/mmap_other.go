@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package debian
+
+import (
+	"os"
+)
+
+// mapFile reads the file at path into memory, as mapping it is not
+// supported on this platform
+func mapFile(path string) ([]byte, func(), error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() {}, nil
+}
@@ -0,0 +1,71 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+// modeIssues lists the properties of a packaged mode that need review:
+// setuid, setgid, and writable by all users. Sticky world-writable directories
+// such as /tmp are not reported.
+func modeIssues(mode os.FileMode, isDir bool) []string {
+	var issues []string
+	if !isDir {
+		if mode&os.ModeSetuid != 0 {
+			issues = append(issues, "setuid")
+		}
+		if mode&os.ModeSetgid != 0 {
+			issues = append(issues, "setgid")
+		}
+	}
+	if mode&0002 != 0 && !(isDir && mode&os.ModeSticky != 0) {
+		issues = append(issues, "world-writable")
+	}
+	return issues
+}
+
+// scanMode checks the mode requested for a packaged path against the
+// permissions policy and records setuid, setgid and world-writable files for
+// the build summary. In strict mode a path the policy does not allow fails the
+// build; otherwise setuid and setgid bits are dropped and world-writable paths
+// are shipped with a warning.
+func (b *Builder) scanMode(srcPath, packagePath string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	mode := b.requestedMode(srcPath, info)
+	issues := modeIssues(mode, info.IsDir())
+	if len(issues) == 0 {
+		return nil
+	}
+
+	systemPath := b.systemPath(srcPath)
+	var shipped, dropped []string
+	for _, issue := range issues {
+		allowed := b.Permissions.SetuidAllowed(systemPath)
+		list := "allow_setuid"
+		if issue == "world-writable" {
+			allowed = b.Permissions.WorldWritableAllowed(systemPath, info.IsDir())
+			list = "allow_world_writable"
+		}
+		switch {
+		case allowed || issue == "world-writable" && !b.StrictMode:
+			b.warn("Shipping %s %s (mode %04o)", issue, systemPath, unixMode(mode))
+			shipped = append(shipped, issue)
+		case b.StrictMode:
+			return ci.Errorf(ci.ClassPolicy, "strict mode: %s is %s (mode %04o); list it in %s to ship it", systemPath, issue, unixMode(mode), list)
+		default:
+			dropped = append(dropped, issue)
+		}
+	}
+
+	if len(dropped) > 0 {
+		b.warn("Dropping %s bits from %s; list it in allow_setuid to keep them", strings.Join(dropped, "/"), systemPath)
+		shipped = append(shipped, strings.Join(dropped, ", ")+" dropped")
+	}
+	b.ModeFindings = append(b.ModeFindings, fmt.Sprintf("%s (mode %04o): %s", packagePath, unixMode(mode), strings.Join(shipped, ", ")))
+	return nil
+}
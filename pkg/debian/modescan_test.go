@@ -0,0 +1,75 @@
+package debian
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+func TestModeIssues(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  os.FileMode
+		isDir bool
+		want  string
+	}{
+		{"Regular file", 0644, false, ""},
+		{"Setuid and setgid", 0755 | os.ModeSetuid | os.ModeSetgid, false, "setuid,setgid"},
+		{"World-writable file", 0666, false, "world-writable"},
+		{"World-writable directory", 0777, true, "world-writable"},
+		{"Sticky directory", 0777 | os.ModeSticky, true, ""},
+		{"Setgid directory", 0755 | os.ModeSetgid, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(modeIssues(tt.mode, tt.isDir), ","); got != tt.want {
+				t.Errorf("modeIssues() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanMode(t *testing.T) {
+	policy := &security.PermissionsPolicy{
+		AllowSetuid:        []string{"/usr/bin/helper"},
+		AllowWorldWritable: []string{"/var/spool/app"},
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		mode        os.FileMode
+		strict      bool
+		wantErr     bool
+		wantFinding string
+	}{
+		{"Plain file", "/usr/bin/app", 0755, true, false, ""},
+		{"Allowed setuid", "/usr/bin/helper", 0755 | os.ModeSetuid, true, false, "/opt/usr/bin/helper (mode 4755): setuid"},
+		{"Setuid dropped", "/usr/bin/other", 0755 | os.ModeSetuid, false, false, "/opt/usr/bin/other (mode 4755): setuid dropped"},
+		{"Setuid rejected in strict mode", "/usr/bin/other", 0755 | os.ModeSetuid, true, true, ""},
+		{"World-writable shipped", "/var/lib/app/db", 0666, false, false, "/opt/var/lib/app/db (mode 0666): world-writable"},
+		{"World-writable rejected in strict mode", "/var/lib/app/db", 0666, true, true, ""},
+		{"Allowed world-writable directory", "/var/spool/app", os.ModeDir | 0777, true, false, "/opt/var/spool/app (mode 0777): world-writable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &Builder{SourceDir: "/src", PreservePerms: true, StrictMode: tt.strict, Observer: &recordingObserver{}}
+			if err := builder.SetPermissions(policy); err != nil {
+				t.Fatalf("SetPermissions() error = %v", err)
+			}
+			info := fakeFileInfo{name: filepath.Base(tt.path), mode: tt.mode}
+			err := builder.scanMode(filepath.Join("/src", tt.path), "/opt"+tt.path, info)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("scanMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Join(builder.ModeFindings, "\n"); got != tt.wantFinding {
+				t.Errorf("ModeFindings = %q, want %q", got, tt.wantFinding)
+			}
+		})
+	}
+}
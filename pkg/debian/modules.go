@@ -0,0 +1,95 @@
+package debian
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// ModuleMode decides how Python, Perl and Ruby module directories in the
+// payload, such as /usr/lib/python3/dist-packages, are handled. Their
+// interpreters only search the real directories, so relocated modules
+// cannot be imported unless the interpreter is told where they went.
+type ModuleMode string
+
+const (
+	ModulesWarn     ModuleMode = "warn"     // Relocate them and warn that they cannot be imported
+	ModulesRelocate ModuleMode = "relocate" // Relocate them and add the new directories to the interpreter search paths
+)
+
+// ParseModuleMode converts "warn" or "relocate" to a mode. An empty string
+// selects warn.
+func ParseModuleMode(mode string) (ModuleMode, error) {
+	switch m := ModuleMode(strings.ToLower(mode)); m {
+	case "":
+		return ModulesWarn, nil
+	case ModulesWarn, ModulesRelocate:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown module path mode: %s (available: warn, relocate)", mode)
+	}
+}
+
+// relocatedModules is a module directory of the payload and where it is
+// packaged
+type relocatedModules struct {
+	security.ModulePath
+	target string
+}
+
+// checkModulePath applies the module mode to the file at absPath, packaged at
+// transformedPath. Each relocated module directory is handled once, when the
+// first file in it is found.
+func (b *Builder) checkModulePath(absPath, transformedPath string) {
+	module, ok := security.DetectModulePath(absPath)
+	if !ok || transformedPath == absPath {
+		return
+	}
+	if _, seen := b.moduleDirs[module.Root]; seen {
+		return
+	}
+	target, _, err := b.PathMapper.TransformPath(module.Root)
+	if err != nil || target == module.Root {
+		return
+	}
+	b.moduleDirs[module.Root] = relocatedModules{ModulePath: module, target: target}
+
+	switch {
+	case b.ModulePaths != ModulesRelocate:
+		b.warn("%s modules in %s are relocated to %s, where %s cannot import them; use --module-paths relocate or --allow-system-path %s",
+			module.Language, module.Root, target, module.Language, module.Root)
+	case module.Language == "python":
+		// site.py adds the directories listed in .pth files to sys.path
+		pth := path.Join(module.Root, b.Package.Name+".pth")
+		b.log("Adding %s to the Python path with %s", target, pth)
+		b.addSystemFile(pth, []byte(target+"\n"))
+	default:
+		b.warn("%s modules in %s are relocated to %s; %s has no drop-in search path, so run its programs with %s=%s, for example from a wrapper script",
+			module.Language, module.Root, target, module.Language, module.EnvVar, target)
+	}
+}
+
+// ModuleEnv returns the search path variables, such as PYTHONPATH, that make
+// the relocated module directories found by the last build importable
+func (b *Builder) ModuleEnv() map[string]string {
+	dirs := make(map[string][]string)
+	for _, module := range b.moduleDirs {
+		dirs[module.EnvVar] = append(dirs[module.EnvVar], module.target)
+	}
+	env := make(map[string]string, len(dirs))
+	for name, list := range dirs {
+		sort.Strings(list)
+		env[name] = strings.Join(list, ":")
+	}
+	return env
+}
+
+// isModulePth reports whether target is a .pth file directly in a Python
+// module directory, as the relocate module mode generates
+func isModulePth(target string) bool {
+	module, ok := security.DetectModulePath(target)
+	return ok && module.Language == "python" && path.Dir(target) == module.Root && path.Ext(target) == ".pth"
+}
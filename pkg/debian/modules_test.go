@@ -0,0 +1,89 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseModuleMode(t *testing.T) {
+	for input, want := range map[string]ModuleMode{
+		"":         ModulesWarn,
+		"warn":     ModulesWarn,
+		"Relocate": ModulesRelocate,
+	} {
+		if got, err := ParseModuleMode(input); err != nil || got != want {
+			t.Errorf("ParseModuleMode(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseModuleMode("keep"); err == nil {
+		t.Errorf("Expected unknown mode to be rejected")
+	}
+}
+
+func TestModulePaths(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	for _, file := range []string{
+		"usr/lib/python3/dist-packages/app/__init__.py",
+		"usr/lib/python3/dist-packages/app/core.py",
+		"usr/share/perl5/App.pm",
+		"usr/share/app/data",
+	} {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		mode         ModuleMode
+		wantPth      bool
+		wantWarnings int
+	}{
+		{ModulesWarn, false, 2},
+		{ModulesRelocate, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.ModulePaths = tt.mode
+
+			if err := builder.copyFiles(context.Background()); err != nil {
+				t.Fatalf("copyFiles() error = %v", err)
+			}
+			if len(builder.Warnings) != tt.wantWarnings {
+				t.Errorf("Warnings = %v, want %d", builder.Warnings, tt.wantWarnings)
+			}
+			content, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, "usr", "lib", "python3", "dist-packages", "app.pth"))
+			if tt.wantPth && (err != nil || string(content) != "/opt/usr/lib/python3/dist-packages\n") {
+				t.Errorf("Expected a .pth file naming the relocated directory, got %q, %v", content, err)
+			}
+			if !tt.wantPth && err == nil {
+				t.Errorf("Expected no .pth file in %s mode", tt.mode)
+			}
+
+			env := builder.ModuleEnv()
+			if env["PYTHONPATH"] != "/opt/usr/lib/python3/dist-packages" || env["PERL5LIB"] != "/opt/usr/share/perl5" {
+				t.Errorf("ModuleEnv() = %v", env)
+			}
+			if !strings.Contains(strings.Join(builder.Warnings, "\n"), "PERL5LIB") && tt.mode == ModulesRelocate {
+				t.Errorf("Expected the Perl warning to name PERL5LIB, got %v", builder.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package debian
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+// BuildPhase identifies a stage of Builder.Build
+type BuildPhase string
+
+const (
+	PhaseCopy     BuildPhase = "copy"     // Walking, transforming and copying the source tree
+	PhaseScripts  BuildPhase = "scripts"  // Writing the control file, md5sums and maintainer scripts
+	PhaseValidate BuildPhase = "validate" // Package validation and ownership conflict checks
+	PhaseArchive  BuildPhase = "archive"  // Writing the .deb file
+	PhaseVerify   BuildPhase = "verify"   // Checking the written .deb against what was staged
+)
+
+// BuildObserver receives progress events from a Builder. Calls are serialized,
+// so implementations need no locking of their own.
+type BuildObserver interface {
+	// OnPhaseStart is called when the build enters a new phase
+	OnPhaseStart(phase BuildPhase)
+	// OnFileCopied is called for every packaged file. total is the number of
+	// files expected, or 0 if it is unknown.
+	OnFileCopied(packagePath string, size int64, done, total int)
+	// OnWarning is called for conditions that do not stop the build
+	OnWarning(message string)
+	// OnComplete is called once when the build finishes
+	OnComplete(outputPath string, err error)
+}
+
+// ReportObserver is implemented by observers that also want the report of
+// the finished build. OnReport is called just before OnComplete.
+type ReportObserver interface {
+	OnReport(report *BuildReport)
+}
+
+// MultiObserver forwards events to several observers in order
+type MultiObserver []BuildObserver
+
+// OnPhaseStart implements BuildObserver
+func (m MultiObserver) OnPhaseStart(phase BuildPhase) {
+	for _, o := range m {
+		o.OnPhaseStart(phase)
+	}
+}
+
+// OnFileCopied implements BuildObserver
+func (m MultiObserver) OnFileCopied(packagePath string, size int64, done, total int) {
+	for _, o := range m {
+		o.OnFileCopied(packagePath, size, done, total)
+	}
+}
+
+// OnWarning implements BuildObserver
+func (m MultiObserver) OnWarning(message string) {
+	for _, o := range m {
+		o.OnWarning(message)
+	}
+}
+
+// OnReport implements ReportObserver
+func (m MultiObserver) OnReport(report *BuildReport) {
+	for _, o := range m {
+		if ro, ok := o.(ReportObserver); ok {
+			ro.OnReport(report)
+		}
+	}
+}
+
+// OnComplete implements BuildObserver
+func (m MultiObserver) OnComplete(outputPath string, err error) {
+	for _, o := range m {
+		o.OnComplete(outputPath, err)
+	}
+}
+
+// LogObserver logs warnings as a Builder without an observer does. Add it to
+// a MultiObserver to keep warnings visible next to other observers.
+type LogObserver struct {
+	Logger *slog.Logger
+}
+
+// OnPhaseStart implements BuildObserver
+func (l LogObserver) OnPhaseStart(phase BuildPhase) {}
+
+// OnFileCopied implements BuildObserver
+func (l LogObserver) OnFileCopied(packagePath string, size int64, done, total int) {}
+
+// OnWarning implements BuildObserver
+func (l LogObserver) OnWarning(message string) {
+	logging.Or(l.Logger).Warn(message)
+}
+
+// OnComplete implements BuildObserver
+func (l LogObserver) OnComplete(outputPath string, err error) {}
+
+// observerState serializes events and tracks file progress for a Builder
+type observerState struct {
+	mu    sync.Mutex
+	done  int
+	total int
+}
+
+// ProgressObserver renders a progress bar for the copy phase
+type ProgressObserver struct {
+	w       io.Writer
+	width   int
+	percent int  // Last rendered percentage
+	drawn   bool // Whether the bar occupies the current line
+}
+
+// NewProgressObserver creates an observer that draws a progress bar on w,
+// which should be a terminal
+func NewProgressObserver(w io.Writer) *ProgressObserver {
+	return &ProgressObserver{w: w, width: 40, percent: -1}
+}
+
+// OnPhaseStart implements BuildObserver
+func (p *ProgressObserver) OnPhaseStart(phase BuildPhase) {
+	if phase != PhaseCopy {
+		p.clear()
+	}
+}
+
+// OnFileCopied implements BuildObserver
+func (p *ProgressObserver) OnFileCopied(packagePath string, size int64, done, total int) {
+	if total <= 0 {
+		return
+	}
+	if done > total {
+		done = total
+	}
+	percent := done * 100 / total
+	// Only redraw when the bar changes
+	if percent == p.percent && done != total {
+		return
+	}
+	p.percent = percent
+	filled := p.width * done / total
+	fmt.Fprintf(p.w, "\r[%s%s] %3d%% (%d/%d files)",
+		strings.Repeat("#", filled), strings.Repeat(" ", p.width-filled), percent, done, total)
+	p.drawn = true
+}
+
+// OnWarning implements BuildObserver
+func (p *ProgressObserver) OnWarning(message string) {
+	p.clear()
+	fmt.Fprintf(p.w, "Warning: %s\n", message)
+	// Redraw the bar on the next file
+	p.percent = -1
+}
+
+// OnComplete implements BuildObserver
+func (p *ProgressObserver) OnComplete(outputPath string, err error) {
+	p.clear()
+}
+
+// clear ends the line holding the progress bar
+func (p *ProgressObserver) clear() {
+	if p.drawn {
+		fmt.Fprintln(p.w)
+		p.drawn = false
+	}
+}
+
+// buildEvent is a single line of JSON build output
+type buildEvent struct {
+	Time    string `json:"time"`
+	Event   string `json:"event"`
+	Phase   string `json:"phase,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Done    int    `json:"done,omitempty"`
+	Total   int    `json:"total,omitempty"`
+	Message string `json:"message,omitempty"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// JSONObserver writes build events as JSON lines for log scraping
+type JSONObserver struct {
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewJSONObserver creates an observer that writes one JSON object per event to w
+func NewJSONObserver(w io.Writer) *JSONObserver {
+	return &JSONObserver{enc: json.NewEncoder(w), now: time.Now}
+}
+
+func (j *JSONObserver) emit(event buildEvent) {
+	event.Time = j.now().UTC().Format(time.RFC3339)
+	j.enc.Encode(event)
+}
+
+// OnPhaseStart implements BuildObserver
+func (j *JSONObserver) OnPhaseStart(phase BuildPhase) {
+	j.emit(buildEvent{Event: "phase_start", Phase: string(phase)})
+}
+
+// OnFileCopied implements BuildObserver
+func (j *JSONObserver) OnFileCopied(packagePath string, size int64, done, total int) {
+	j.emit(buildEvent{Event: "file_copied", Path: packagePath, Size: size, Done: done, Total: total})
+}
+
+// OnWarning implements BuildObserver
+func (j *JSONObserver) OnWarning(message string) {
+	j.emit(buildEvent{Event: "warning", Message: message})
+}
+
+// OnComplete implements BuildObserver
+func (j *JSONObserver) OnComplete(outputPath string, err error) {
+	event := buildEvent{Event: "complete", Output: outputPath}
+	if err != nil {
+		event.Event = "failed"
+		event.Error = err.Error()
+	}
+	j.emit(event)
+}
@@ -0,0 +1,144 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingObserver records events as strings
+type recordingObserver struct {
+	events []string
+}
+
+func (r *recordingObserver) OnPhaseStart(phase BuildPhase) {
+	r.events = append(r.events, "phase "+string(phase))
+}
+
+func (r *recordingObserver) OnFileCopied(packagePath string, size int64, done, total int) {
+	r.events = append(r.events, fmt.Sprintf("file %d/%d", done, total))
+}
+
+func (r *recordingObserver) OnWarning(message string) {
+	r.events = append(r.events, "warning")
+}
+
+func (r *recordingObserver) OnComplete(outputPath string, err error) {
+	r.events = append(r.events, fmt.Sprintf("complete %v", err))
+}
+
+func TestBuildObserverEvents(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c", "skip.log"} {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%v", streaming), func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.Streaming = streaming
+			builder.AddExcludeDir("*.log")
+			observer := &recordingObserver{}
+			builder.Observer = observer
+
+			if err := builder.createDebianDir(); err != nil {
+				t.Fatalf("createDebianDir() error = %v", err)
+			}
+			builder.startPhase(PhaseCopy)
+			if streaming {
+				err = builder.streamData(context.Background(), ioutil.Discard)
+			} else {
+				err = builder.copyFiles(context.Background())
+			}
+			if err != nil {
+				t.Fatalf("copy error = %v", err)
+			}
+
+			want := "phase copy,file 1/3,file 2/3,file 3/3"
+			if got := strings.Join(observer.events, ","); got != want {
+				t.Errorf("Events = %q, want %q", got, want)
+			}
+		})
+	}
+
+	// A failed build still reports completion
+	builder, err := NewBuilder(NewPackage("", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	observer := &recordingObserver{}
+	builder.Observer = observer
+	if _, _, err := builder.Build(context.Background()); err == nil {
+		t.Fatalf("Expected invalid package to fail")
+	}
+	if len(observer.events) != 1 || !strings.HasPrefix(observer.events[0], "complete package validation failed") {
+		t.Errorf("Unexpected events %v", observer.events)
+	}
+}
+
+func TestProgressObserver(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgressObserver(&buf)
+	p.width = 10
+
+	p.OnPhaseStart(PhaseCopy)
+	p.OnFileCopied("/opt/a", 1, 1, 4)
+	p.OnFileCopied("/opt/b", 1, 2, 4)
+	p.OnWarning("careful")
+	p.OnFileCopied("/opt/c", 1, 4, 4)
+	p.OnComplete("/out/app.deb", nil)
+
+	want := "\r[##        ]  25% (1/4 files)" +
+		"\r[#####     ]  50% (2/4 files)\n" +
+		"Warning: careful\n" +
+		"\r[##########] 100% (4/4 files)\n"
+	if buf.String() != want {
+		t.Errorf("Output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestJSONObserver(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJSONObserver(&buf)
+	j.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	j.OnPhaseStart(PhaseCopy)
+	j.OnFileCopied("/opt/a", 3, 1, 2)
+	j.OnComplete("", fmt.Errorf("boom"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(lines))
+	}
+	var event buildEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", lines[1], err)
+	}
+	if event.Event != "file_copied" || event.Path != "/opt/a" || event.Size != 3 || event.Time != "2024-01-02T03:04:05Z" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if !strings.Contains(lines[2], `"event":"failed"`) || !strings.Contains(lines[2], `"error":"boom"`) {
+		t.Errorf("Unexpected failure event %s", lines[2])
+	}
+}
@@ -0,0 +1,438 @@
+package debian
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/appstream"
+	"github.com/go-i2p/go-pkginstall/pkg/hooks"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// BuilderOption configures a Builder created by NewBuilder or NewFSBuilder.
+// Options are applied in order, so layout, profile, policy and strict mode
+// options must come before WithSymlinkDirs.
+type BuilderOption func(*Builder) error
+
+// WithVerbose enables verbose logging
+func WithVerbose(verbose bool) BuilderOption {
+	return func(b *Builder) error {
+		b.Verbose = verbose
+		b.resetSymlinkProcessor()
+		return nil
+	}
+}
+
+// WithLogger sends verbose logs, warnings and the output of external tools
+// to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) BuilderOption {
+	return func(b *Builder) error {
+		if logger == nil {
+			return fmt.Errorf("logger cannot be nil")
+		}
+		b.logger = logger
+		b.applyLogger()
+		return nil
+	}
+}
+
+// WithLogOutput sends verbose logs, warnings and the output of external tools
+// to w as text, instead of to slog.Default()
+func WithLogOutput(w io.Writer) BuilderOption {
+	return func(b *Builder) error {
+		if w == nil {
+			return fmt.Errorf("log output cannot be nil")
+		}
+		return WithLogger(slog.New(logging.NewTextHandler(w, slog.LevelInfo)))(b)
+	}
+}
+
+// WithObserver reports build progress to observer
+func WithObserver(observer BuildObserver) BuilderOption {
+	return func(b *Builder) error {
+		b.Observer = observer
+		return nil
+	}
+}
+
+// WithOutputDir sets the directory Build writes the .deb to
+func WithOutputDir(dir string) BuilderOption {
+	return func(b *Builder) error {
+		if dir == "" {
+			return fmt.Errorf("output directory cannot be empty")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		b.OutputDir = dir
+		return nil
+	}
+}
+
+// WithWorkDir creates the build directory and temporary files in dir
+func WithWorkDir(dir string) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetWorkDir(dir)
+	}
+}
+
+// WithKeepBuildDir keeps the build directory for inspection when the build
+// fails
+func WithKeepBuildDir(keep bool) BuilderOption {
+	return func(b *Builder) error {
+		b.KeepBuildDir = keep
+		return nil
+	}
+}
+
+// WithCacheDir keeps the staging directory in dir between builds, so only
+// changed files are copied and hashed again. See DefaultCacheDir.
+func WithCacheDir(dir string) BuilderOption {
+	return func(b *Builder) error {
+		b.CacheDir = dir
+		return nil
+	}
+}
+
+// WithLayout selects where system paths are relocated
+func WithLayout(layout *security.PathLayout) BuilderOption {
+	return func(b *Builder) error {
+		return b.ApplyLayout(layout)
+	}
+}
+
+// WithProfile selects a security profile
+func WithProfile(profile *security.Profile) BuilderOption {
+	return func(b *Builder) error {
+		b.ApplyProfile(profile)
+		return nil
+	}
+}
+
+// WithPolicy applies an organisation policy file
+func WithPolicy(policy *security.PolicyFile) BuilderOption {
+	return func(b *Builder) error {
+		b.ApplyPolicy(policy)
+		return nil
+	}
+}
+
+// WithStrictMode turns warnings into failures
+func WithStrictMode() BuilderOption {
+	return func(b *Builder) error {
+		b.EnableStrictMode()
+		return nil
+	}
+}
+
+// WithSymlinkDirs replaces the directories where install-time symlinks may be
+// created
+func WithSymlinkDirs(dirs []string) BuilderOption {
+	return func(b *Builder) error {
+		b.SetSymlinkDirs(dirs)
+		return nil
+	}
+}
+
+// WithExcludes excludes source paths matching the patterns
+func WithExcludes(patterns ...string) BuilderOption {
+	return func(b *Builder) error {
+		for _, p := range patterns {
+			b.AddExcludeDir(p)
+		}
+		return nil
+	}
+}
+
+// WithIncludes includes source paths matching the patterns even if they are
+// excluded
+func WithIncludes(patterns ...string) BuilderOption {
+	return func(b *Builder) error {
+		for _, p := range patterns {
+			b.AddIncludePattern(p)
+		}
+		return nil
+	}
+}
+
+// WithConflicts sets the packages this package conflicts with
+func WithConflicts(conflicts ...string) BuilderOption {
+	return func(b *Builder) error {
+		b.SetConflicts(conflicts)
+		return nil
+	}
+}
+
+// WithProvides sets the packages this package provides
+func WithProvides(provides ...string) BuilderOption {
+	return func(b *Builder) error {
+		b.SetProvides(provides)
+		return nil
+	}
+}
+
+// WithReplaces sets the packages whose files this package may overwrite
+func WithReplaces(replaces ...string) BuilderOption {
+	return func(b *Builder) error {
+		b.SetReplaces(replaces)
+		return nil
+	}
+}
+
+// WithMaintainerScript validates and adds a maintainer script
+func WithMaintainerScript(name, content string) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetMaintainerScript(name, content)
+	}
+}
+
+// WithScriptWaivers accepts the maintainer script findings the waivers match.
+// Pass it before WithMaintainerScript.
+func WithScriptWaivers(waivers ...security.ScriptWaiver) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetScriptWaivers(waivers)
+	}
+}
+
+// WithPreservePerms keeps the file permissions of the source tree
+func WithPreservePerms(preserve bool) BuilderOption {
+	return func(b *Builder) error {
+		b.PreservePerms = preserve
+		return nil
+	}
+}
+
+// WithFixPerms fixes the insecure modes the permissions audit finds, such as
+// world-writable files, instead of reporting them
+func WithFixPerms(fix bool) BuilderOption {
+	return func(b *Builder) error {
+		b.FixPerms = fix
+		return nil
+	}
+}
+
+// WithHardeningCheck checks the packaged ELF files for PIE, RELRO, a
+// non-executable stack and the stack protector
+func WithHardeningCheck(check bool) BuilderOption {
+	return func(b *Builder) error {
+		b.HardeningCheck = check
+		return nil
+	}
+}
+
+// WithFixRPath rewrites the RUNPATH and RPATH of packaged ELF files that
+// name relocated library directories
+func WithFixRPath(fix bool) BuilderOption {
+	return func(b *Builder) error {
+		b.FixRPath = fix
+		return nil
+	}
+}
+
+// WithFixDevFiles rewrites the paths in packaged pkg-config, libtool and
+// CMake files that name relocated paths
+func WithFixDevFiles(fix bool) BuilderOption {
+	return func(b *Builder) error {
+		b.FixDevFiles = fix
+		return nil
+	}
+}
+
+// WithControlFieldOrder writes the listed control fields first, in order
+func WithControlFieldOrder(fields ...string) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetControlFieldOrder(fields)
+	}
+}
+
+// WithControlTemplate renders the control file with a text/template executed
+// with ControlData
+func WithControlTemplate(text string) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetControlTemplate(text)
+	}
+}
+
+// WithSecretScan scans the packaged files for private keys, access keys and
+// tokens; it is on by default
+func WithSecretScan(scan bool) BuilderOption {
+	return func(b *Builder) error {
+		b.NoSecretScan = !scan
+		return nil
+	}
+}
+
+// WithPermissions applies declared file modes
+func WithPermissions(policy *security.PermissionsPolicy) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetPermissions(policy)
+	}
+}
+
+// WithStrip strips ELF files while packaging
+func WithStrip(opts StripOptions) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetStrip(opts)
+	}
+}
+
+// WithAppStream generates AppStream metainfo for component
+func WithAppStream(component *appstream.Component) BuilderOption {
+	return func(b *Builder) error {
+		b.AppStream = component
+		return nil
+	}
+}
+
+// WithHooks runs steps at the build phases
+func WithHooks(h *hooks.Hooks) BuilderOption {
+	return func(b *Builder) error {
+		return b.SetHooks(h)
+	}
+}
+
+// WithStreaming writes data.tar.gz straight from the source tree with the
+// built-in archive writer
+func WithStreaming(streaming bool) BuilderOption {
+	return func(b *Builder) error {
+		b.Streaming = streaming
+		return nil
+	}
+}
+
+// WithPackageType builds the named package type, such as rpm or slackware,
+// instead of a .deb (see PackageTypes)
+func WithPackageType(name string) BuilderOption {
+	return func(b *Builder) error {
+		writer, err := LookupPackageWriter(name)
+		if err != nil {
+			return err
+		}
+		b.Writer = writer
+		return nil
+	}
+}
+
+// WithPluginSigner sets the su3 signer ID and signing key of the
+// i2p-plugin package type; it must follow WithPackageType
+func WithPluginSigner(signer, keyFile string) BuilderOption {
+	return func(b *Builder) error {
+		if _, ok := b.Writer.(I2PPluginWriter); !ok {
+			if signer != "" || keyFile != "" {
+				return fmt.Errorf("a plugin signer requires the i2p-plugin package type")
+			}
+			return nil
+		}
+		b.Writer = I2PPluginWriter{Signer: signer, KeyFile: keyFile}
+		return nil
+	}
+}
+
+// WithRelease sets the release or build number of RPM and Slackware packages
+func WithRelease(release string) BuilderOption {
+	return func(b *Builder) error {
+		b.Release = release
+		return nil
+	}
+}
+
+// WithWorkers sets the number of concurrent file copy workers
+func WithWorkers(workers int) BuilderOption {
+	return func(b *Builder) error {
+		if workers < 0 {
+			return fmt.Errorf("number of workers cannot be negative")
+		}
+		b.Workers = workers
+		return nil
+	}
+}
+
+// WithAutoArchitecture builds a payload without ELF binaries as
+// Architecture: all
+func WithAutoArchitecture() BuilderOption {
+	return func(b *Builder) error {
+		b.AutoArchitecture = true
+		return nil
+	}
+}
+
+// NewFSBuilder creates a Builder that packages the files of fsys. The tree is
+// copied to a temporary directory, which Clean removes. Write the package
+// with BuildTo, or with Build after setting WithOutputDir.
+func NewFSBuilder(pkg *Package, fsys fs.FS, opts ...BuilderOption) (*Builder, error) {
+	if fsys == nil {
+		return nil, fmt.Errorf("source filesystem cannot be nil")
+	}
+	sourceDir, err := os.MkdirTemp("", "pkginstall-src-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create source directory: %w", err)
+	}
+	if err := copyFS(sourceDir, fsys); err != nil {
+		os.RemoveAll(sourceDir)
+		return nil, err
+	}
+
+	builder, err := NewBuilder(pkg, sourceDir, ".", func(b *Builder) error {
+		b.fsSource = sourceDir
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(sourceDir)
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(builder); err != nil {
+			builder.Clean()
+			return nil, err
+		}
+	}
+	return builder, nil
+}
+
+// copyFS copies the directories and regular files of fsys to dir. Symlinks to
+// files are copied as files; other symlinks and special files are rejected
+// because fs.FS cannot represent them.
+func copyFS(dir string, fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.IsDir():
+			return fmt.Errorf("cannot package %s: symlinked directories are not supported in an fs.FS source", name)
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("cannot package %s: only directories and regular files are supported in an fs.FS source", name)
+		}
+
+		src, err := fsys.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		defer src.Close()
+		dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", target, err)
+		}
+		_, err = io.Copy(dst, src)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
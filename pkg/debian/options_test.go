@@ -0,0 +1,103 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFSBuilderBuildTo(t *testing.T) {
+	fsys := fstest.MapFS{
+		"usr/bin/app":          {Data: []byte("#!/bin/sh\necho hello\n"), Mode: 0755, ModTime: time.Unix(1700000000, 0)},
+		"usr/share/app/README": {Data: []byte("readme\n"), Mode: 0644},
+	}
+
+	var logs bytes.Buffer
+	builder, err := NewFSBuilder(
+		NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil),
+		fsys,
+		WithVerbose(true),
+		WithLogOutput(&logs),
+		WithExcludes("usr/share/app"),
+	)
+	if err != nil {
+		t.Fatalf("NewFSBuilder() error = %v", err)
+	}
+	sourceDir := builder.SourceDir
+	builder.DpkgRoot = sourceDir
+
+	var deb bytes.Buffer
+	report, err := builder.BuildTo(context.Background(), &deb)
+	if err != nil {
+		t.Fatalf("BuildTo() error = %v", err)
+	}
+	if sum := sha256.Sum256(deb.Bytes()); report.SHA256 != hex.EncodeToString(sum[:]) || report.Size != int64(deb.Len()) {
+		t.Errorf("Report has checksum %s and size %d for a %d byte package", report.SHA256, report.Size, deb.Len())
+	}
+
+	names, members := readAr(t, deb.Bytes())
+	if strings.Join(names, ",") != "debian-binary,control.tar.gz,data.tar.gz" {
+		t.Fatalf("Unexpected archive members %v", names)
+	}
+	data := readTarGz(t, members["data.tar.gz"])
+	header, ok := data["./opt/usr/bin/app"]
+	if !ok {
+		t.Fatalf("Missing data entry ./opt/usr/bin/app")
+	}
+	if header.Mode != 0755 || !header.ModTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Entry has mode %o and time %v", header.Mode, header.ModTime)
+	}
+	if _, ok := data["./opt/usr/share/app/README"]; ok {
+		t.Errorf("Excluded file was packaged")
+	}
+	if logs.Len() == 0 {
+		t.Errorf("Expected verbose logs in the log output")
+	}
+
+	if err := builder.Clean(); err != nil {
+		t.Fatalf("Clean() error = %v", err)
+	}
+	if _, err := os.Stat(sourceDir); !os.IsNotExist(err) {
+		t.Errorf("Expected Clean to remove the copied source tree, got %v", err)
+	}
+}
+
+func TestBuilderOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     BuilderOption
+		wantErr string
+	}{
+		{"Valid script", WithMaintainerScript("postinst", "#!/bin/sh\nexit 0\n"), ""},
+		{"Invalid script name", WithMaintainerScript("install", "#!/bin/sh\n"), "invalid maintainer script name"},
+		{"Nil log output", WithLogOutput(nil), "log output cannot be nil"},
+		{"Negative workers", WithWorkers(-1), "cannot be negative"},
+		{"Empty output directory", WithOutputDir(""), "output directory cannot be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder, err := NewFSBuilder(
+				NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil),
+				fstest.MapFS{"file": {Data: []byte("x")}},
+				tt.opt,
+			)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewFSBuilder() error = %v", err)
+				}
+				builder.Clean()
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewFSBuilder() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package debian
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 )
 
 // Package represents a Debian package with its metadata and attributes.
@@ -30,14 +32,168 @@ func NewPackage(name, version, architecture, maintainer, description, section, p
 	}
 }
 
-// Validate checks if the package metadata is valid.
+// Validate checks the package metadata against Debian policy, so that
+// mistakes are reported before anything is built.
 func (p *Package) Validate() error {
-	if p.Name == "" {
+	if err := ValidatePackageName(p.Name); err != nil {
+		return err
+	}
+	if err := ValidateVersion(p.Version); err != nil {
+		return err
+	}
+	if err := ValidateArchitecture(p.Architecture); err != nil {
+		return err
+	}
+	if err := ValidateMaintainer(p.Maintainer); err != nil {
+		return err
+	}
+	if err := validateSection(p.Section); err != nil {
+		return err
+	}
+	if err := validatePriority(p.Priority); err != nil {
+		return err
+	}
+	return validateRelations("Depends", p.Depends)
+}
+
+var (
+	// validName matches package names: at least two lowercase letters, digits,
+	// +, - or ., starting with a letter or digit
+	validName = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+	// validUpstream matches the upstream part of a version
+	validUpstream = regexp.MustCompile(`^[0-9][A-Za-z0-9.+~:-]*$`)
+	// validRevision matches the Debian revision of a version
+	validRevision = regexp.MustCompile(`^[A-Za-z0-9.+~]+$`)
+	// validMaintainer matches "Full Name <address@example.org>"
+	validMaintainer = regexp.MustCompile(`^[^<>,\s][^<>,]* <[^<>@\s]+@[^<>@\s]+>$`)
+)
+
+// sections are the sections of the Debian archive. They may be prefixed with
+// an archive area such as contrib/.
+var sections = []string{
+	"admin", "cli-mono", "comm", "database", "debian-installer", "debug",
+	"devel", "doc", "editors", "education", "electronics", "embedded", "fonts",
+	"games", "gnome", "gnu-r", "gnustep", "graphics", "hamradio", "haskell",
+	"httpd", "interpreters", "introspection", "java", "javascript", "kde",
+	"kernel", "libdevel", "libs", "lisp", "localization", "mail", "math",
+	"metapackages", "misc", "net", "news", "ocaml", "oldlibs", "otherosfs",
+	"perl", "php", "python", "ruby", "rust", "science", "shells", "sound",
+	"tasks", "tex", "text", "utils", "vcs", "video", "web", "x11", "xfce", "zope",
+}
+
+// sectionAreas are the archive areas a section may be prefixed with
+var sectionAreas = []string{"main", "contrib", "non-free", "non-free-firmware"}
+
+// priorities are the priorities allowed by Debian policy
+var priorities = []string{"required", "important", "standard", "optional"}
+
+// ValidatePackageName checks a package name against Debian policy
+func ValidatePackageName(name string) error {
+	if name == "" {
 		return fmt.Errorf("package name cannot be empty")
 	}
-	if p.Version == "" {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid package name %q: use at least two lowercase letters, digits, '+', '-' or '.', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// ValidateVersion checks a version of the form [epoch:]upstream[-revision]
+func ValidateVersion(version string) error {
+	if version == "" {
 		return fmt.Errorf("package version cannot be empty")
 	}
-	// Additional validation rules can be added here
+	upstream, hasEpoch := version, false
+	if i := strings.Index(upstream, ":"); i >= 0 {
+		epoch := upstream[:i]
+		if epoch == "" || strings.Trim(epoch, "0123456789") != "" {
+			return fmt.Errorf("invalid version %q: the epoch before ':' must be a number", version)
+		}
+		upstream, hasEpoch = upstream[i+1:], true
+	}
+	if i := strings.LastIndex(upstream, "-"); i >= 0 {
+		if revision := upstream[i+1:]; !validRevision.MatchString(revision) {
+			return fmt.Errorf("invalid version %q: the revision after the last '-' may only contain letters, digits, '+', '.' and '~'", version)
+		}
+		upstream = upstream[:i]
+	}
+	if !validUpstream.MatchString(upstream) || (strings.Contains(upstream, ":") && !hasEpoch) {
+		return fmt.Errorf("invalid version %q: the upstream version must start with a digit and only contain letters, digits, '.', '+', '~', '-' and ':'", version)
+	}
+	return nil
+}
+
+// ValidateMaintainer checks that maintainer is an RFC 822 style
+// "Full Name <address>" as the Maintainer field requires
+func ValidateMaintainer(maintainer string) error {
+	if maintainer == "" {
+		return fmt.Errorf("package maintainer cannot be empty")
+	}
+	if !validMaintainer.MatchString(maintainer) {
+		return fmt.Errorf("invalid maintainer %q: use the form \"Full Name <address@example.org>\"", maintainer)
+	}
+	return nil
+}
+
+// validateSection checks an optional section, such as utils or contrib/net
+func validateSection(section string) error {
+	if section == "" {
+		return nil
+	}
+	name := section
+	if i := strings.Index(section, "/"); i >= 0 {
+		if !contains(sectionAreas, section[:i]) {
+			return fmt.Errorf("invalid section %q: unknown archive area %q (available: %s)", section, section[:i], strings.Join(sectionAreas, ", "))
+		}
+		name = section[i+1:]
+	}
+	if !contains(sections, name) {
+		return fmt.Errorf("invalid section %q (available: %s)", section, strings.Join(sections, ", "))
+	}
+	return nil
+}
+
+// validatePriority checks an optional priority
+func validatePriority(priority string) error {
+	if priority == "" || contains(priorities, priority) {
+		return nil
+	}
+	if priority == "extra" {
+		return fmt.Errorf("priority \"extra\" is deprecated: use \"optional\" instead")
+	}
+	return fmt.Errorf("invalid priority %q (available: %s)", priority, strings.Join(priorities, ", "))
+}
+
+// validateRelations checks the entries of the Depends, Conflicts, Provides or Replaces
+// field. Only Depends accepts alternatives, Provides only accepts exact
+// versions, and build profiles only make sense in source packages.
+func validateRelations(field string, entries []string) error {
+	relationships, err := ParseRelationshipList(entries)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	for _, alternatives := range relationships {
+		if len(alternatives) > 1 && field != "Depends" {
+			return fmt.Errorf("%s: invalid relationship %q: alternatives with '|' are only allowed in Depends", field, alternatives)
+		}
+		for _, r := range alternatives {
+			if len(r.Profiles) > 0 {
+				return fmt.Errorf("%s: invalid relationship %q: build profiles are only allowed in source packages", field, alternatives)
+			}
+			if field == "Provides" && r.Operator != "" && r.Operator != "=" {
+				return fmt.Errorf("%s: invalid relationship %q: only exact versions with = are allowed in Provides", field, alternatives)
+			}
+		}
+	}
 	return nil
 }
+
+// contains reports whether list contains value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package debian
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackageValidate(t *testing.T) {
+	valid := func() *Package {
+		return NewPackage("my-app", "1.0", "amd64", "Jane Doe <jane@example.org>", "My app", "utils", "optional", []string{"libc6 (>= 2.31)"})
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(p *Package)
+		wantErr string
+	}{
+		{"Valid", func(p *Package) {}, ""},
+		{"Empty name", func(p *Package) { p.Name = "" }, "package name cannot be empty"},
+		{"Uppercase name", func(p *Package) { p.Name = "MyApp" }, "invalid package name"},
+		{"Single character name", func(p *Package) { p.Name = "a" }, "invalid package name"},
+		{"Underscore in name", func(p *Package) { p.Name = "my_app" }, "invalid package name"},
+		{"Name with plus and dot", func(p *Package) { p.Name = "libfoo2.0+dfsg" }, ""},
+		{"Empty version", func(p *Package) { p.Version = "" }, "package version cannot be empty"},
+		{"Epoch and revision", func(p *Package) { p.Version = "2:1.0~rc1-3+deb12u1" }, ""},
+		{"Hyphen in upstream", func(p *Package) { p.Version = "1.0-beta-1" }, ""},
+		{"Leading v", func(p *Package) { p.Version = "v1.0" }, "must start with a digit"},
+		{"Bad epoch", func(p *Package) { p.Version = "a:1.0" }, "epoch"},
+		{"Empty revision", func(p *Package) { p.Version = "1.0-" }, "revision"},
+		{"Space in version", func(p *Package) { p.Version = "1.0 beta" }, "upstream version"},
+		{"Unknown architecture", func(p *Package) { p.Architecture = "x86_64" }, "unknown architecture"},
+		{"Maintainer without address", func(p *Package) { p.Maintainer = "Jane Doe" }, "invalid maintainer"},
+		{"Maintainer address only", func(p *Package) { p.Maintainer = "jane@example.org" }, "invalid maintainer"},
+		{"Two maintainers", func(p *Package) { p.Maintainer = "Jane <jane@example.org>, Joe <joe@example.org>" }, "invalid maintainer"},
+		{"Area section", func(p *Package) { p.Section = "contrib/net" }, ""},
+		{"Empty section", func(p *Package) { p.Section = "" }, ""},
+		{"Unknown section", func(p *Package) { p.Section = "tools" }, "invalid section"},
+		{"Unknown area", func(p *Package) { p.Section = "restricted/net" }, "unknown archive area"},
+		{"Extra priority", func(p *Package) { p.Priority = "extra" }, "deprecated"},
+		{"Unknown priority", func(p *Package) { p.Priority = "high" }, "invalid priority"},
+		{"Depends alternatives", func(p *Package) { p.Depends = []string{"default-mta | mail-transport-agent", "python3:any"} }, ""},
+		{"Depends arch restriction", func(p *Package) { p.Depends = []string{"libfoo (>= 1.0) [amd64]"} }, ""},
+		{"Obsolete operator", func(p *Package) { p.Depends = []string{"libc6 (> 2.31)"} }, "use >> or >="},
+		{"Unknown operator", func(p *Package) { p.Depends = []string{"libc6 (=> 2.31)"} }, "unknown version operator"},
+		{"Missing version", func(p *Package) { p.Depends = []string{"libc6 (>=)"} }, "version cannot be empty"},
+		{"Comma-separated entry", func(p *Package) { p.Depends = []string{"libc6, libssl3"} }, ""},
+		{"Build profile", func(p *Package) { p.Depends = []string{"check <!nocheck>"} }, "build profiles"},
+		{"Empty alternative", func(p *Package) { p.Depends = []string{"libc6 |"} }, "empty package name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.modify(p)
+			err := p.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRelations(t *testing.T) {
+	tests := []struct {
+		field     string
+		relations []string
+		wantErr   bool
+	}{
+		{"Conflicts", []string{"oldapp (<< 2.0)", "other"}, false},
+		{"Conflicts", []string{"a | b"}, true},
+		{"Provides", []string{"mail-transport-agent", "app-api (= 3)"}, false},
+		{"Provides", []string{"app-api (>= 3)"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+" "+strings.Join(tt.relations, ", "), func(t *testing.T) {
+			if err := validateRelations(tt.field, tt.relations); (err != nil) != tt.wantErr {
+				t.Errorf("validateRelations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPackageType is the package type built without a PackageWriter
+const DefaultPackageType = "debian"
+
+// PackageWriter writes a staged package in a format other than .deb. The
+// builder stages and transforms the payload exactly as for a .deb, then hands
+// the staging directory to the writer instead of dpkg-deb.
+type PackageWriter interface {
+	// FileName returns the name of the package file written for staged
+	FileName(staged *StagedPackage) string
+	// WritePackage writes the package to w
+	WritePackage(ctx context.Context, staged *StagedPackage, w io.Writer) error
+}
+
+// StagedPackage is a built payload handed to a PackageWriter
+type StagedPackage struct {
+	Package   *Package
+	Release   string            // Release or build number of the package
+	Root      string            // Staging directory laid out as installed; DEBIAN is not part of the payload
+	Scripts   map[string]string // Maintainer scripts by their Debian name, such as postinst
+	Provides  []string
+	Conflicts []string
+	Replaces  []string
+	BuildTime time.Time
+	WorkDir   string                                   // Directory for temporary files (default: system temp dir)
+	Warn      func(format string, args ...interface{}) // Reports what the format cannot represent
+}
+
+// warn reports through Warn, if set
+func (s *StagedPackage) warn(format string, args ...interface{}) {
+	if s.Warn != nil {
+		s.Warn(format, args...)
+	}
+}
+
+// stagedFile is an entry of the staged payload
+type stagedFile struct {
+	Path string // Absolute path on the installed system
+	Info os.FileInfo
+	Link string // Target of a symlink
+	Src  string // Path in the staging directory
+}
+
+// files returns the payload entries below Root in lexical order, including
+// directories but not Root itself or the DEBIAN control directory
+func (s *StagedPackage) files() ([]stagedFile, error) {
+	var files []stagedFile
+	err := filepath.Walk(s.Root, func(src string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.Root, src)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if rel == "DEBIAN" && info.IsDir() {
+			return filepath.SkipDir
+		}
+		file := stagedFile{Path: "/" + filepath.ToSlash(rel), Info: info, Src: src}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if file.Link, err = os.Readlink(src); err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", src, err)
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staged payload: %w", err)
+	}
+	return files, nil
+}
+
+// packageWriters holds the writers selectable by package type
+var packageWriters = map[string]PackageWriter{}
+
+// RegisterPackageWriter makes a package type available under the given name.
+// Registering an existing name replaces the previous writer.
+func RegisterPackageWriter(name string, writer PackageWriter) {
+	packageWriters[strings.ToLower(name)] = writer
+}
+
+// PackageTypes returns the sorted names of all package types, including the
+// built-in .deb type
+func PackageTypes() []string {
+	names := []string{DefaultPackageType}
+	for name := range packageWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupPackageWriter returns the writer registered for a package type. The
+// built-in .deb type, also selected by an empty name, has no writer.
+func LookupPackageWriter(name string) (PackageWriter, error) {
+	name = strings.ToLower(name)
+	if name == "" || name == DefaultPackageType || name == "deb" {
+		return nil, nil
+	}
+	writer, ok := packageWriters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown package type %q (available: %s)", name, strings.Join(PackageTypes(), ", "))
+	}
+	return writer, nil
+}
+
+// splitVersion splits a Debian version into its epoch and a version usable
+// by formats that reserve the hyphen, which is replaced by an underscore
+func splitVersion(version string) (epoch, rest string) {
+	if e, v, ok := strings.Cut(version, ":"); ok {
+		epoch, version = e, v
+	}
+	return epoch, strings.ReplaceAll(version, "-", "_")
+}
+
+// staged returns the payload in BuildDir as handed to the package writer
+func (b *Builder) staged() *StagedPackage {
+	release := b.Release
+	if release == "" {
+		release = "1"
+	}
+	return &StagedPackage{
+		Package:   b.Package,
+		Release:   release,
+		Root:      b.BuildDir,
+		Scripts:   b.Scripts,
+		Provides:  b.Provides,
+		Conflicts: b.Conflicts,
+		Replaces:  b.Replaces,
+		BuildTime: time.Now(),
+		WorkDir:   b.WorkDir,
+		Warn:      b.warn,
+	}
+}
+
+// writePackage writes the staged payload with the package writer, to w or
+// to outputPath when w is nil
+func (b *Builder) writePackage(ctx context.Context, staged *StagedPackage, outputPath string, w io.Writer) error {
+	if w != nil {
+		if err := b.Writer.WritePackage(ctx, staged, w); err != nil {
+			return fmt.Errorf("failed to build package: %w", err)
+		}
+		return nil
+	}
+
+	b.log("Writing %s", outputPath)
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create package file: %w", err)
+	}
+	err = b.Writer.WritePackage(ctx, staged, out)
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to build package: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	RegisterPackageWriter("slackware", SlackwareWriter{})
+	RegisterPackageWriter("rpm", RPMWriter{})
+	RegisterPackageWriter("i2p-plugin", I2PPluginWriter{})
+}
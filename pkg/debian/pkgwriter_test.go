@@ -0,0 +1,398 @@
+package debian
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/i2p"
+)
+
+// newStagedPackage stages a small payload with a symlink and a DEBIAN
+// directory that is not part of it
+func newStagedPackage(t *testing.T) *StagedPackage {
+	root, err := ioutil.TempDir("", "pkgwriter-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	for _, dir := range []string{"DEBIAN", "opt/demo/bin", "opt/demo/empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	files := map[string]string{
+		"DEBIAN/control":    "Package: demo\n",
+		"opt/demo/bin/demo": "#!/bin/sh\necho demo\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.Symlink("bin/demo", filepath.Join(root, "opt/demo/run")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	return &StagedPackage{
+		Package: &Package{
+			Name:         "demo",
+			Version:      "1:2.0-3",
+			Architecture: "amd64",
+			Maintainer:   "Demo <demo@example.com>",
+			Description:  "Demo tool\n Does demo things.\n .\n Second paragraph.",
+			Section:      "utils",
+			Depends:      []string{"libc6 (>= 2.31)", "foo | bar"},
+		},
+		Release:   "1",
+		Root:      root,
+		Scripts:   map[string]string{"postinst": "#!/bin/sh\necho installed\n", "prerm": "#!/bin/sh\n"},
+		Replaces:  []string{"old-demo"},
+		BuildTime: time.Unix(1700000000, 0),
+	}
+}
+
+func TestLookupPackageWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    PackageWriter
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"debian", nil, false},
+		{"rpm", RPMWriter{}, false},
+		{"Slackware", SlackwareWriter{}, false},
+		{"i2p-plugin", I2PPluginWriter{}, false},
+		{"pacman", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LookupPackageWriter(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LookupPackageWriter(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LookupPackageWriter(%q) = %#v, want %#v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlackwareWriter(t *testing.T) {
+	staged := newStagedPackage(t)
+	var warnings []string
+	staged.Warn = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	writer := SlackwareWriter{}
+	if got := writer.FileName(staged); got != "demo-2.0_3-x86_64-1.tgz" {
+		t.Errorf("FileName() = %q", got)
+	}
+	var buf bytes.Buffer
+	if err := writer.WritePackage(context.Background(), staged, &buf); err != nil {
+		t.Fatalf("WritePackage() error = %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "prerm") {
+		t.Errorf("Expected a warning about prerm, got %v", warnings)
+	}
+
+	entries := readTarGz(t, buf.Bytes())
+	for _, name := range []string{"./", "install/slack-desc", "install/doinst.sh", "opt/demo/bin/demo", "opt/demo/empty/"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("Missing entry %s", name)
+		}
+	}
+	for _, name := range []string{"DEBIAN/", "DEBIAN/control", "opt/demo/run"} {
+		if _, ok := entries[name]; ok {
+			t.Errorf("Unexpected entry %s", name)
+		}
+	}
+	if header := entries["opt/demo/bin/demo"]; header.Mode != 0755 || header.Uname != "root" {
+		t.Errorf("Unexpected mode %o or owner %s", header.Mode, header.Uname)
+	}
+
+	gz, _ := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	contents := readTarContents(t, gz)
+	doinst := contents["install/doinst.sh"]
+	if !strings.Contains(doinst, "( cd opt/demo ; ln -sf bin/demo run )") || !strings.Contains(doinst, "echo installed") {
+		t.Errorf("Unexpected doinst.sh:\n%s", doinst)
+	}
+	var descLines int
+	for _, line := range strings.Split(contents["install/slack-desc"], "\n") {
+		if strings.HasPrefix(line, "demo:") {
+			descLines++
+		}
+	}
+	if descLines != slackDescLines || !strings.Contains(contents["install/slack-desc"], "demo: demo (Demo tool)") {
+		t.Errorf("Unexpected slack-desc:\n%s", contents["install/slack-desc"])
+	}
+}
+
+// readTarContents returns the contents of the regular files of a tar stream
+func readTarContents(t *testing.T, r io.Reader) map[string]string {
+	contents := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar entry: %v", err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		contents[header.Name] = string(data)
+	}
+}
+
+// rpmTestHeader is a parsed RPM header structure
+type rpmTestHeader struct {
+	tags map[int32][]byte // Raw data of each tag, up to the end of the store
+	size int
+}
+
+// parseRPMHeader parses the header structure at the start of data
+func parseRPMHeader(t *testing.T, data []byte, region int32) rpmTestHeader {
+	if !bytes.HasPrefix(data, []byte{0x8e, 0xad, 0xe8, 0x01}) {
+		t.Fatalf("Missing header magic")
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	storeSize := int(binary.BigEndian.Uint32(data[12:]))
+	store := data[16+16*count : 16+16*count+storeSize]
+
+	h := rpmTestHeader{tags: make(map[int32][]byte), size: 16 + 16*count + storeSize}
+	for i := 0; i < count; i++ {
+		entry := data[16+16*i:]
+		tag := int32(binary.BigEndian.Uint32(entry))
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:])))
+		if i == 0 {
+			if tag != region {
+				t.Fatalf("First tag = %d, want region %d", tag, region)
+			}
+			trailer := int32(binary.BigEndian.Uint32(store[offset+8:]))
+			if trailer != -int32(16*count) {
+				t.Errorf("Region trailer offset = %d, want %d", trailer, -16*count)
+			}
+			continue
+		}
+		h.tags[tag] = store[offset:]
+	}
+	return h
+}
+
+// str returns a string tag
+func (h rpmTestHeader) str(tag int32) string {
+	data := h.tags[tag]
+	return string(data[:bytes.IndexByte(data, 0)])
+}
+
+// strs returns the first n strings of a string array tag
+func (h rpmTestHeader) strs(tag int32, n int) []string {
+	return strings.Split(string(h.tags[tag]), "\x00")[:n]
+}
+
+func TestRPMWriter(t *testing.T) {
+	staged := newStagedPackage(t)
+	writer := RPMWriter{}
+	if got := writer.FileName(staged); got != "demo-2.0_3-1.x86_64.rpm" {
+		t.Errorf("FileName() = %q", got)
+	}
+	var buf bytes.Buffer
+	if err := writer.WritePackage(context.Background(), staged, &buf); err != nil {
+		t.Fatalf("WritePackage() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	if !bytes.HasPrefix(data, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0}) {
+		t.Fatalf("Missing lead magic")
+	}
+	if name := string(bytes.TrimRight(data[10:76], "\x00")); name != "demo-2.0_3-1" {
+		t.Errorf("Lead name = %q", name)
+	}
+
+	sig := parseRPMHeader(t, data[96:], rpmTagHeaderSignatures)
+	offset := 96 + sig.size
+	offset += (8 - offset%8) % 8
+	header := parseRPMHeader(t, data[offset:], rpmTagHeaderImmutable)
+	headerEnd := offset + header.size
+
+	if size := int(binary.BigEndian.Uint32(sig.tags[rpmSigTagSize])); size != len(data)-offset {
+		t.Errorf("Signature size = %d, want %d", size, len(data)-offset)
+	}
+	if sum := md5.Sum(data[offset:]); !bytes.Equal(sig.tags[rpmSigTagMD5][:16], sum[:]) {
+		t.Errorf("Signature MD5 does not match the header and payload")
+	}
+
+	for tag, want := range map[int32]string{
+		rpmTagName:    "demo",
+		rpmTagVersion: "2.0_3",
+		rpmTagRelease: "1",
+		rpmTagArch:    "x86_64",
+		rpmTagOS:      "linux",
+		rpmTagPostIn:  "#!/bin/sh\necho installed\n",
+		rpmTagPreUn:   "#!/bin/sh\n",
+	} {
+		if got := header.str(tag); got != want {
+			t.Errorf("Tag %d = %q, want %q", tag, got, want)
+		}
+	}
+	if epoch := binary.BigEndian.Uint32(header.tags[rpmTagEpoch]); epoch != 1 {
+		t.Errorf("Epoch = %d, want 1", epoch)
+	}
+	if got := header.str(rpmTagDescription); got != "Does demo things.\n\nSecond paragraph." {
+		t.Errorf("Description = %q", got)
+	}
+	requires := header.strs(rpmTagRequireName, 4)
+	if requires[2] != "libc6" || requires[3] != "foo" {
+		t.Errorf("Requires = %v", requires)
+	}
+	if flags := binary.BigEndian.Uint32(header.tags[rpmTagRequireFlags][8:]); flags != rpmSenseGreater|rpmSenseEqual {
+		t.Errorf("libc6 flags = %#x", flags)
+	}
+
+	// Payload: the file, the symlink and the empty directory, but not
+	// directories with entries or DEBIAN
+	baseNames := header.strs(rpmTagBaseNames, 3)
+	if strings.Join(baseNames, ",") != "demo,empty,run" {
+		t.Errorf("Basenames = %v", baseNames)
+	}
+	if dirs := header.strs(rpmTagDirNames, 2); strings.Join(dirs, ",") != "/opt/demo/bin/,/opt/demo/" {
+		t.Errorf("Dirnames = %v", dirs)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data[headerEnd:]))
+	if err != nil {
+		t.Fatalf("Failed to open payload: %v", err)
+	}
+	payload, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	if payloadSize := int(binary.BigEndian.Uint32(sig.tags[rpmSigTagPayloadSize])); payloadSize != len(payload) {
+		t.Errorf("Payload size = %d, want %d", payloadSize, len(payload))
+	}
+	entries := readCpio(t, payload)
+	if entries["./opt/demo/bin/demo"] != "#!/bin/sh\necho demo\n" || entries["./opt/demo/run"] != "bin/demo" {
+		t.Errorf("Unexpected payload %v", entries)
+	}
+	if _, ok := entries["TRAILER!!!"]; !ok {
+		t.Errorf("Missing cpio trailer")
+	}
+}
+
+// readCpio returns the contents of the entries of a newc cpio archive
+func readCpio(t *testing.T, data []byte) map[string]string {
+	entries := make(map[string]string)
+	align := func(n int) int { return (n + 3) &^ 3 }
+	for offset := 0; offset < len(data); {
+		if string(data[offset:offset+6]) != "070701" {
+			t.Fatalf("Bad cpio magic at %d", offset)
+		}
+		field := func(i int) int {
+			v, err := strconv.ParseUint(string(data[offset+6+8*i:offset+14+8*i]), 16, 32)
+			if err != nil {
+				t.Fatalf("Bad cpio header field: %v", err)
+			}
+			return int(v)
+		}
+		size, nameSize := field(6), field(11)
+		name := string(data[offset+110 : offset+110+nameSize-1])
+		start := align(offset + 110 + nameSize)
+		entries[name] = string(data[start : start+size])
+		if name == "TRAILER!!!" {
+			break
+		}
+		offset = align(start + size)
+	}
+	return entries
+}
+
+func TestI2PPluginWriter(t *testing.T) {
+	staged := newStagedPackage(t)
+	var warnings []string
+	staged.Warn = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyDir, err := ioutil.TempDir("", "pkgwriter-key-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(keyDir)
+	keyFile := filepath.Join(keyDir, "signing.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	writer := I2PPluginWriter{KeyFile: keyFile}
+	if got := writer.FileName(staged); got != "demo-2.0-3.su3" {
+		t.Errorf("FileName() = %q", got)
+	}
+	var buf bytes.Buffer
+	if err := writer.WritePackage(context.Background(), staged, &buf); err != nil {
+		t.Fatalf("WritePackage() error = %v", err)
+	}
+	if len(warnings) != 3 {
+		t.Errorf("Expected warnings about both scripts and the symlink, got %v", warnings)
+	}
+
+	info, content, err := i2p.ReadSU3(buf.Bytes(), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("ReadSU3() error = %v", err)
+	}
+	if info.Signer != "demo@example.com" || info.Version != "2.0-3" || info.ContentType != i2p.SU3ContentPlugin {
+		t.Errorf("Unexpected su3 info %+v", info)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("Failed to read plugin archive: %v", err)
+	}
+	names := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		names[f.Name] = f
+	}
+	for _, name := range []string{"plugin.config", "bin/demo", "empty/"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("Missing entry %s", name)
+		}
+	}
+	for _, name := range []string{"DEBIAN/control", "run", "opt/demo/bin/demo"} {
+		if _, ok := names[name]; ok {
+			t.Errorf("Unexpected entry %s", name)
+		}
+	}
+	rc, err := names["plugin.config"].Open()
+	if err != nil {
+		t.Fatalf("Failed to open plugin.config: %v", err)
+	}
+	config, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	for _, line := range []string{"name=demo\n", "signer=demo@example.com\n", "version=2.0-3\n", "description=Demo tool\n"} {
+		if !strings.Contains(string(config), line) {
+			t.Errorf("plugin.config lacks %q:\n%s", line, config)
+		}
+	}
+}
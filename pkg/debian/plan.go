@@ -0,0 +1,561 @@
+package debian
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/go-i2p/go-pkginstall/pkg/symlink"
+)
+
+// PlanFormatVersion is the version of the plan document format
+const PlanFormatVersion = 1
+
+// Plan describes everything a build would package, without packaging it.
+// ApplyPlan builds exactly the files, modes, symlinks and scripts it lists.
+type Plan struct {
+	FormatVersion int `json:"format_version"`
+
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Architecture string   `json:"architecture"`
+	Maintainer   string   `json:"maintainer"`
+	Description  string   `json:"description"`
+	Section      string   `json:"section,omitempty"`
+	Priority     string   `json:"priority,omitempty"`
+	Depends      []string `json:"depends,omitempty"`
+	Conflicts    []string `json:"conflicts,omitempty"`
+	Provides     []string `json:"provides,omitempty"`
+	Replaces     []string `json:"replaces,omitempty"`
+
+	SourceDir          string               `json:"source_dir"` // Absolute directory the sources of Files are relative to
+	Layout             *security.PathLayout `json:"layout,omitempty"`
+	Strip              StripOptions         `json:"strip"`
+	Files              []PlannedFile        `json:"files"`
+	Symlinks           []PlannedSymlink     `json:"symlinks,omitempty"` // Created by postinst at install time
+	Scripts            map[string]string    `json:"scripts,omitempty"`  // Maintainer scripts, including generated commands
+	Triggers           string               `json:"triggers,omitempty"` // Contents of DEBIAN/triggers
+	OwnershipConflicts []string             `json:"ownership_conflicts,omitempty"`
+	Findings           []string             `json:"findings,omitempty"` // Warnings, path findings and privileged files
+	Overrides          []string             `json:"overrides,omitempty"`
+	Suggested          []RelationSuggestion `json:"suggested_relations,omitempty"`
+	Sizes              PlanSizes            `json:"sizes"`
+}
+
+// PlannedFile is one payload entry
+type PlannedFile struct {
+	Source     string `json:"source,omitempty"`      // Path relative to SourceDir; empty for generated files
+	Target     string `json:"target"`                // Path in the package
+	Type       string `json:"type"`                  // "file", "dir" or "symlink"
+	Mode       string `json:"mode"`                  // Octal permissions, e.g. "0755"
+	Size       int64  `json:"size,omitempty"`        // Size of the source file in bytes
+	SHA256     string `json:"sha256,omitempty"`      // Checksum of the source file when the plan was made
+	LinkTarget string `json:"link_target,omitempty"` // Contents of packaged symlinks
+	Compress   bool   `json:"compress,omitempty"`    // Packaged gzip-compressed
+	Content    string `json:"content,omitempty"`     // Contents of files generated by the builder
+}
+
+// PlannedSymlink is a symlink postinst creates at install time
+type PlannedSymlink struct {
+	Target string `json:"target"` // Path of the symlink on the installed system
+	Source string `json:"source"` // Packaged file it points to
+}
+
+// PlanSizes are estimates, as compression and stripping happen when the plan
+// is applied
+type PlanSizes struct {
+	Files         int   `json:"files"`          // Files and symlinks in the payload
+	PayloadSize   int64 `json:"payload_size"`   // Total size of the source files in bytes
+	InstalledSize int64 `json:"installed_size"` // Estimated Installed-Size in KiB
+}
+
+// planFileTypes are the valid PlannedFile types
+var planFileTypes = map[string]bool{"file": true, "dir": true, "symlink": true}
+
+// checkPlannable rejects settings whose effect a plan cannot describe
+func (b *Builder) checkPlannable() error {
+	switch {
+	case b.Writer != nil || b.SourcePackage:
+		return fmt.Errorf("plans can only describe .deb packages")
+	case b.Strip.DebugPackage:
+		return fmt.Errorf("plans cannot describe debug symbol packages")
+	case b.PreserveOwner || b.PreserveXattrs:
+		return fmt.Errorf("plans cannot describe preserved owners or extended attributes")
+	case b.Hooks != nil && len(b.Hooks.PreCopy)+len(b.Hooks.PostCopy)+len(b.Hooks.PrePackage)+len(b.Hooks.PostPackage) > 0:
+		return fmt.Errorf("plans cannot describe build hooks, which may change the payload")
+	}
+	return nil
+}
+
+// Plan performs path transformation, validation and symlink planning like
+// Build, but copies and writes nothing, and returns the resulting plan
+func (b *Builder) Plan(ctx context.Context) (*Plan, error) {
+	defer b.Clean()
+
+	if err := b.checkPlannable(); err != nil {
+		return nil, ci.Wrap(ci.ClassUsage, err)
+	}
+	if err := b.validateMetadata(); err != nil {
+		return nil, err
+	}
+	sourceDir, err := filepath.Abs(b.SourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source directory: %w", err)
+	}
+	if err := b.addMetainfo(); err != nil {
+		return nil, err
+	}
+	b.addTmpfiles()
+
+	plan := &Plan{FormatVersion: PlanFormatVersion, SourceDir: sourceDir, Layout: b.layout, Strip: b.Strip}
+	b.md5sums = make(map[string]string)
+	err = b.walkSource(ctx, func(srcPath, packagePath string, info os.FileInfo) error {
+		rel, err := filepath.Rel(b.SourceDir, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		file := PlannedFile{
+			Source: filepath.ToSlash(rel),
+			Target: packagePath,
+			Mode:   formatMode(b.fileMode(srcPath, info)),
+		}
+		switch {
+		case info.IsDir():
+			file.Type = "dir"
+		case info.Mode()&os.ModeSymlink != 0:
+			file.Type, file.Mode = "symlink", formatMode(0777)
+			if file.LinkTarget, err = b.linkTarget(srcPath, packagePath); err != nil {
+				return err
+			}
+		default:
+			file.Type, file.Size, file.Compress = "file", info.Size(), b.compresses(srcPath, info)
+			if file.SHA256, err = fileSHA256(srcPath); err != nil {
+				return err
+			}
+		}
+		if !info.IsDir() {
+			b.PackagedFiles = append(b.PackagedFiles, packagePath)
+		}
+		plan.Files = append(plan.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = b.packageGenerated(func(packagePath string, content []byte, mode os.FileMode) error {
+		plan.Files = append(plan.Files, PlannedFile{
+			Target:  packagePath,
+			Type:    "file",
+			Mode:    formatMode(mode),
+			Size:    int64(len(content)),
+			Content: string(content),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.finalizeArchitecture()
+	if err := b.createPostinstScript(); err != nil {
+		return nil, fmt.Errorf("failed to create postinst script: %w", err)
+	}
+	if err := b.checkOwnershipConflicts(); err != nil {
+		return nil, err
+	}
+
+	plan.Name, plan.Version, plan.Architecture = b.Package.Name, b.Package.Version, b.Package.Architecture
+	plan.Maintainer, plan.Description = b.Package.Maintainer, b.Package.Description
+	plan.Section, plan.Priority, plan.Depends = b.Package.Section, b.Package.Priority, b.Package.Depends
+	plan.Conflicts, plan.Provides, plan.Replaces = b.Conflicts, b.Provides, b.Replaces
+	for _, request := range b.SymlinkProcessor.GetQueuedSymlinks() {
+		plan.Symlinks = append(plan.Symlinks, PlannedSymlink{Target: request.Target, Source: request.Source})
+	}
+	plan.Scripts = b.Scripts
+	plan.Triggers = b.triggersFile()
+	for _, conflict := range b.OwnershipConflicts {
+		plan.OwnershipConflicts = append(plan.OwnershipConflicts, fmt.Sprintf("%s (owned by %s)", conflict.Path, strings.Join(conflict.Owners, ", ")))
+	}
+	plan.Findings = append(append(append(plan.Findings, b.Warnings...), b.PathFindings...), b.ModeFindings...)
+	plan.Overrides = b.Overrides
+	plan.Suggested = b.RelationSuggestions
+	plan.Sizes = planSizes(plan.Files)
+	return plan, nil
+}
+
+// planSizes estimates the sizes of the planned payload like
+// stagedInstalledSize measures a staged one
+func planSizes(files []PlannedFile) PlanSizes {
+	var sizes PlanSizes
+	for _, file := range files {
+		if file.Type == "dir" {
+			sizes.InstalledSize++
+			continue
+		}
+		sizes.Files++
+		sizes.PayloadSize += file.Size
+		if file.Type == "symlink" {
+			sizes.InstalledSize += fileBlocks(int64(len(file.LinkTarget)))
+		} else {
+			sizes.InstalledSize += fileBlocks(file.Size)
+		}
+	}
+	return sizes
+}
+
+// ApplyPlan builds the package a plan describes into OutputDir. The Builder's
+// path mapping and validation are configured from the plan's layout; its
+// security profile, policy and strict mode still decide whether the planned
+// paths and scripts are accepted. A source file that changed since the plan
+// was made fails the build.
+func (b *Builder) ApplyPlan(ctx context.Context, plan *Plan) (string, *BuildReport, error) {
+	outputPath, err := b.applyPlan(ctx, plan)
+	var checksum string
+	var size int64
+	if err == nil {
+		if checksum, size, err = checksumFile(outputPath); err != nil {
+			err = fmt.Errorf("failed to checksum package: %w", err)
+		}
+	}
+	report := b.report(outputPath, err)
+	report.SHA256, report.Size = checksum, size
+	b.complete(outputPath, report, err)
+	return outputPath, report, err
+}
+
+func (b *Builder) applyPlan(ctx context.Context, plan *Plan) (outputPath string, err error) {
+	defer func() {
+		if err != nil && b.KeepBuildDir {
+			b.log("Keeping build directory %s", b.BuildDir)
+			return
+		}
+		b.Clean()
+	}()
+
+	if plan.FormatVersion != PlanFormatVersion {
+		return "", ci.Errorf(ci.ClassValidation, "unsupported plan format version %d (expected %d)", plan.FormatVersion, PlanFormatVersion)
+	}
+	if err := b.checkPlannable(); err != nil {
+		return "", ci.Wrap(ci.ClassUsage, err)
+	}
+	b.Package = plan.Package()
+	b.SourceDir = plan.SourceDir
+	b.Conflicts, b.Provides, b.Replaces = plan.Conflicts, plan.Provides, plan.Replaces
+	if plan.Layout != nil {
+		if err := b.ApplyLayout(plan.Layout); err != nil {
+			return "", ci.Errorf(ci.ClassValidation, "invalid plan layout: %w", err)
+		}
+	}
+	if err := b.SetStrip(plan.Strip); err != nil {
+		return "", err
+	}
+	if err := b.validateMetadata(); err != nil {
+		return "", err
+	}
+
+	// Scripts and symlinks are checked again, as the plan may have been edited
+	b.Scripts = make(map[string]string)
+	for name, content := range plan.Scripts {
+		if err := b.SetMaintainerScript(name, content); err != nil {
+			return "", err
+		}
+	}
+	for _, link := range plan.Symlinks {
+		err := b.SymlinkProcessor.QueueSymlink(symlink.SymlinkRequest{
+			Source:      link.Source,
+			Target:      link.Target,
+			Description: fmt.Sprintf("Symlink from %s to %s", link.Source, link.Target),
+		})
+		if err != nil {
+			return "", ci.Errorf(ci.ClassPolicy, "planned symlink %s -> %s rejected: %w", link.Target, link.Source, err)
+		}
+	}
+
+	unlock, err := b.lockOutput(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if err := b.createDebianDir(); err != nil {
+		return "", err
+	}
+
+	b.startPhase(PhaseCopy)
+	b.md5sums = make(map[string]string)
+	for _, file := range plan.Files {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("package build cancelled: %w", err)
+		}
+		if err := b.stagePlannedFile(ctx, plan, file); err != nil {
+			return "", err
+		}
+	}
+	if b.installedSize, err = stagedInstalledSize(b.BuildDir); err != nil {
+		return "", err
+	}
+
+	outputPath = filepath.Join(b.OutputDir, fmt.Sprintf("%s_%s_%s.deb", b.Package.Name, b.Package.Version, b.Package.Architecture))
+	b.startPhase(PhaseScripts)
+	if err := b.writeDebianFiles(); err != nil {
+		return "", err
+	}
+	// The triggers follow the plan, not what the builder would detect
+	triggersPath := filepath.Join(b.BuildDir, "DEBIAN", "triggers")
+	if plan.Triggers == "" {
+		err = os.Remove(triggersPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(triggersPath, []byte(plan.Triggers), 0644)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write triggers file: %w", err)
+	}
+
+	b.startPhase(PhaseValidate)
+	b.PathValidator.SetPermissionAudit(b.Permissions, b.FixPerms)
+	err = b.validatePackage()
+	if err != nil && b.enforcePaths() {
+		return "", ci.Errorf(ci.ClassPolicy, "package validation failed: %w", err)
+	}
+	if err := b.checkOwnershipConflicts(); err != nil {
+		return "", err
+	}
+
+	b.startPhase(PhaseArchive)
+	if err := b.writeArchive(ctx, outputPath, ""); err != nil {
+		return "", err
+	}
+	b.startPhase(PhaseVerify)
+	if err := b.verifyOutput(ctx, outputPath); err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// stagePlannedFile validates the target of a planned file and places it in
+// the build directory
+func (b *Builder) stagePlannedFile(ctx context.Context, plan *Plan, file PlannedFile) error {
+	if !planFileTypes[file.Type] {
+		return ci.Errorf(ci.ClassValidation, "planned file %s has unknown type %q", file.Target, file.Type)
+	}
+	mode, err := parseMode(file.Mode)
+	if err != nil {
+		return ci.Errorf(ci.ClassValidation, "planned file %s: %w", file.Target, err)
+	}
+	if !filepath.IsAbs(file.Target) {
+		return ci.Errorf(ci.ClassValidation, "planned target %s is not an absolute path", file.Target)
+	}
+	if err := b.checkPlannedTarget(file); err != nil {
+		return err
+	}
+	if err := b.PathValidator.ValidatePathTraversal(file.Target); err != nil {
+		return ci.Errorf(ci.ClassPolicy, "path traversal check failed for %s: %w", file.Target, err)
+	}
+	if err := b.PathValidator.ValidatePath(file.Target); err != nil {
+		if b.enforcePaths() {
+			return ci.Errorf(ci.ClassPolicy, "path validation failed for %s: %w", file.Target, err)
+		}
+		b.reportPath(fmt.Sprintf("path validation failed for %s: %v", file.Target, err))
+	}
+
+	targetPath := filepath.Join(b.BuildDir, file.Target)
+	if file.Type == "dir" {
+		if err := os.MkdirAll(targetPath, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+		}
+		return os.Chmod(targetPath, mode)
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+	}
+	b.PackagedFiles = append(b.PackagedFiles, file.Target)
+	if file.Type == "symlink" {
+		if err := os.Symlink(file.LinkTarget, targetPath); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %w", targetPath, err)
+		}
+		b.fileCopied(file.Target, 0)
+		return nil
+	}
+
+	if file.Source == "" {
+		return b.stageContent(targetPath, file.Target, []byte(file.Content), mode)
+	}
+	srcPath, err := plan.sourcePath(file.Source)
+	if err != nil {
+		return err
+	}
+	if sum, err := fileSHA256(srcPath); err != nil {
+		return err
+	} else if sum != file.SHA256 {
+		return ci.Errorf(ci.ClassValidation, "%s changed since the plan was made", srcPath)
+	}
+
+	contentPath, cleanup := srcPath, func() {}
+	if file.Compress {
+		contentPath, cleanup, err = compressFile(ctx, b.WorkDir, srcPath)
+	} else {
+		contentPath, cleanup, err = b.uncompressedContent(ctx, srcPath, file.Target)
+	}
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	content, err := os.ReadFile(contentPath)
+	if err != nil {
+		return fmt.Errorf("failed to read source file %s: %w", srcPath, err)
+	}
+	return b.stageContent(targetPath, file.Target, content, mode)
+}
+
+// checkPlannedTarget rejects targets the layout would not have produced, so
+// an edited plan cannot place files outside the transformed paths
+func (b *Builder) checkPlannedTarget(file PlannedFile) error {
+	if file.Source == "" {
+		if file.Type != "file" || !b.PathMapper.IsTransformedPath(file.Target) && !isModulePth(file.Target) {
+			return ci.Errorf(ci.ClassPolicy, "generated file %s is outside %s", file.Target, b.PathMapper.GetTransformedRoot())
+		}
+		return nil
+	}
+	absPath := filepath.Join("/", filepath.FromSlash(file.Source))
+	if _, ok := b.wrapperFor(absPath); ok && file.Type == "file" {
+		absPath = b.wrappedPath(absPath)
+	}
+	expected, _, err := b.PathMapper.TransformPath(absPath)
+	if err != nil {
+		expected = absPath
+	}
+	if file.Compress {
+		expected += ".gz"
+	}
+	if file.Target != expected {
+		return ci.Errorf(ci.ClassPolicy, "planned target %s of %s does not match the layout, which maps it to %s", file.Target, file.Source, expected)
+	}
+	return nil
+}
+
+// stageContent writes a payload file and records its checksum
+func (b *Builder) stageContent(targetPath, packagePath string, content []byte, mode os.FileMode) error {
+	if err := os.WriteFile(targetPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", targetPath, err)
+	}
+	if err := os.Chmod(targetPath, mode); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", targetPath, err)
+	}
+	sum := md5.Sum(content)
+	b.md5sums[packagePath] = hex.EncodeToString(sum[:])
+	b.fileCopied(packagePath, int64(len(content)))
+	return nil
+}
+
+// Package returns the package metadata of the plan
+func (p *Plan) Package() *Package {
+	return NewPackage(p.Name, p.Version, p.Architecture, p.Maintainer, p.Description, p.Section, p.Priority, p.Depends)
+}
+
+// sourcePath returns the absolute path of a planned source, which must stay
+// inside SourceDir
+func (p *Plan) sourcePath(rel string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", ci.Errorf(ci.ClassPolicy, "planned source %s is outside the source directory", rel)
+	}
+	return filepath.Join(p.SourceDir, clean), nil
+}
+
+// WriteJSON writes the plan as indented JSON to w
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteFile writes the plan as JSON to path
+func (p *Plan) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
+	err = p.WriteJSON(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan written by WriteFile
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, ci.Errorf(ci.ClassValidation, "invalid plan %s: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// fileSHA256 returns the hex SHA-256 checksum of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// formatMode formats permissions, including setuid, setgid and sticky bits,
+// as an octal string
+func formatMode(mode os.FileMode) string {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return fmt.Sprintf("%04o", perm)
+}
+
+// parseMode is the inverse of formatMode
+func parseMode(s string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	mode := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
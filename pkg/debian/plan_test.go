@@ -0,0 +1,188 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+func TestPlanAndApply(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "plan-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "plan-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	for _, dir := range []string{"usr/bin", "usr/share/man/man1"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	files := map[string]os.FileMode{"usr/bin/app": 0755, "usr/share/man/man1/app.1": 0644}
+	for name, mode := range files {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte(name+"\n"), mode); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.Symlink("app", filepath.Join(srcDir, "usr", "bin", "app-link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	pkg := NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil)
+	builder, err := NewBuilder(pkg, srcDir, outDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	builder.DpkgRoot = srcDir
+	if err := builder.ApplyLayout(&security.PathLayout{Package: "app", PerPackage: true}); err != nil {
+		t.Fatalf("ApplyLayout() error = %v", err)
+	}
+	plan, err := builder.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("Plan() wrote %d entries to the output directory", len(entries))
+	}
+
+	planned := make(map[string]PlannedFile)
+	for _, file := range plan.Files {
+		planned[file.Target] = file
+	}
+	for target, want := range map[string]PlannedFile{
+		"/opt/app/bin/app":                 {Source: "usr/bin/app", Type: "file", Mode: "0755"},
+		"/opt/app/bin/app-link":            {Source: "usr/bin/app-link", Type: "symlink", Mode: "0777", LinkTarget: "app"},
+		"/opt/app/share/man/man1/app.1.gz": {Source: "usr/share/man/man1/app.1", Type: "file", Mode: "0644", Compress: true},
+	} {
+		got, ok := planned[target]
+		if !ok {
+			t.Errorf("plan is missing %s", target)
+			continue
+		}
+		if got.Source != want.Source || got.Type != want.Type || got.Mode != want.Mode || got.LinkTarget != want.LinkTarget || got.Compress != want.Compress {
+			t.Errorf("planned %s = %+v, want %+v", target, got, want)
+		}
+		if got.Type == "file" && got.SHA256 == "" {
+			t.Errorf("planned %s has no checksum", target)
+		}
+	}
+	if len(plan.Symlinks) == 0 || plan.Scripts["postinst"] == "" {
+		t.Errorf("plan has no install-time symlinks or postinst: %+v", plan)
+	}
+	if plan.Sizes.Files != 3 || plan.Sizes.InstalledSize == 0 {
+		t.Errorf("unexpected plan sizes: %+v", plan.Sizes)
+	}
+
+	planPath := filepath.Join(outDir, "app.plan.json")
+	if err := plan.WriteFile(planPath); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	loaded, err := LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("LoadPlan() error = %v", err)
+	}
+	os.Remove(planPath)
+
+	// The plan's metadata and layout win over the applying builder's
+	apply := func() (string, *BuildReport, error) {
+		other, err := NewBuilder(NewPackage("other", "2.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), ".", outDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		other.DpkgRoot = srcDir
+		return other.ApplyPlan(context.Background(), loaded)
+	}
+	outputPath, report, err := apply()
+	if err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+	if filepath.Base(outputPath) != "app_1.0_all.deb" {
+		t.Errorf("ApplyPlan() wrote %s, want app_1.0_all.deb", outputPath)
+	}
+	if report.Files != 3 {
+		t.Errorf("report lists %d files, want 3", report.Files)
+	}
+	os.Remove(outputPath)
+
+	// A source that changed since planning fails the build
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "bin", "app"), []byte("changed\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, _, err := apply(); ci.ClassOf(err) != ci.ClassValidation {
+		t.Errorf("ApplyPlan() error = %v, want a validation error for the changed source", err)
+	}
+}
+
+func TestApplyPlanRejectsEditedPlans(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "plan-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	tests := []struct {
+		name  string
+		file  PlannedFile
+		class ci.Class
+	}{
+		{"SourceOutsideSourceDir", PlannedFile{Source: "../etc/passwd", Target: "/opt/app/passwd", Type: "file", Mode: "0644"}, ci.ClassPolicy},
+		{"TraversingTarget", PlannedFile{Content: "x", Target: "/opt/app/../../etc/cron.d/x", Type: "file", Mode: "0644"}, ci.ClassPolicy},
+		{"SystemTarget", PlannedFile{Content: "x", Target: "/etc/cron.d/x", Type: "file", Mode: "0644"}, ci.ClassPolicy},
+		{"TargetNotFromLayout", PlannedFile{Source: "etc/cron.d/x", Target: "/etc/cron.d/x", Type: "file", Mode: "0644"}, ci.ClassPolicy},
+		{"UnknownType", PlannedFile{Target: "/opt/dev", Type: "device", Mode: "0644"}, ci.ClassValidation},
+		{"BadMode", PlannedFile{Content: "x", Target: "/opt/x", Type: "file", Mode: "rwx"}, ci.ClassValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir, err := ioutil.TempDir("", "plan-out-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(outDir)
+
+			plan := &Plan{
+				FormatVersion: PlanFormatVersion,
+				Name:          "app",
+				Version:       "1.0",
+				Architecture:  "all",
+				Maintainer:    "Test <test@example.com>",
+				Description:   "d",
+				SourceDir:     srcDir,
+				Files:         []PlannedFile{tt.file},
+			}
+			builder, err := NewBuilder(plan.Package(), srcDir, outDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			if _, _, err := builder.ApplyPlan(context.Background(), plan); ci.ClassOf(err) != tt.class {
+				t.Errorf("ApplyPlan() error = %v (%s), want class %s", err, ci.ClassOf(err), tt.class)
+			}
+		})
+	}
+}
+
+func TestPlanModes(t *testing.T) {
+	for _, mode := range []os.FileMode{0644, 0755, 0700 | os.ModeSetuid, 0775 | os.ModeSetgid, 01777&0777 | os.ModeSticky} {
+		parsed, err := parseMode(formatMode(mode))
+		if err != nil || parsed != mode {
+			t.Errorf("parseMode(formatMode(%v)) = %v, %v", mode, parsed, err)
+		}
+	}
+	if got := formatMode(0755 | os.ModeSetuid); got != "4755" {
+		t.Errorf("formatMode() = %s, want 4755", got)
+	}
+	if _, err := parseMode("10000"); err == nil {
+		t.Error("parseMode() accepted a mode above 07777")
+	}
+}
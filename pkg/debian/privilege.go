@@ -0,0 +1,80 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// UserSwitcher switches a root build to an unprivileged user for the copy
+// phase. Switching changes the credentials of the whole process, so only a
+// program that runs one build at a time, such as the command line, may set
+// one; see privilege.Credential.
+type UserSwitcher interface {
+	IDs() (uid, gid int)
+	Switch() (restore func() error, err error)
+}
+
+// elevate records an operation of the build that needed root
+func (b *Builder) elevate(operation string) {
+	b.events.mu.Lock()
+	defer b.events.mu.Unlock()
+	for _, recorded := range b.Elevated {
+		if recorded == operation {
+			return
+		}
+	}
+	b.Elevated = append(b.Elevated, operation)
+}
+
+// dropPrivileges switches a root build to CopyAs for the copy phase, after
+// handing it the directories the copy writes to, and returns the function
+// that switches back to root. The copy keeps root when it has to change
+// file owners.
+func (b *Builder) dropPrivileges() (restore func() error, err error) {
+	restore = func() error { return nil }
+	if b.CopyAs == nil || geteuid() != 0 {
+		return restore, nil
+	}
+	if b.PreserveOwner && b.owners == nil && !b.Streaming {
+		b.log("Copying as root to preserve file owners")
+		return restore, nil
+	}
+
+	uid, gid := b.CopyAs.IDs()
+	for _, dir := range []string{b.BuildDir, b.debugDir} {
+		if dir == "" {
+			continue
+		}
+		if err := chownTree(dir, uid, gid); err != nil {
+			return restore, fmt.Errorf("failed to hand %s to uid %d: %w", dir, uid, err)
+		}
+	}
+	// A staged source directory is created by root and readable only by it;
+	// its contents may be hard links to the user's files, so only the
+	// directory itself is handed over
+	if b.fsSource != "" {
+		if err := os.Chown(b.fsSource, uid, gid); err != nil {
+			return restore, fmt.Errorf("failed to hand %s to uid %d: %w", b.fsSource, uid, err)
+		}
+	}
+
+	restore, err = b.CopyAs.Switch()
+	if err != nil {
+		return func() error { return nil }, fmt.Errorf("failed to drop privileges: %w", err)
+	}
+	b.copiedAs = fmt.Sprintf("%d:%d", uid, gid)
+	b.log("Copying files as %s", b.copiedAs)
+	return restore, nil
+}
+
+// chownTree changes the owner of dir and everything below it, without
+// following symlinks
+func chownTree(dir string, uid, gid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
@@ -0,0 +1,94 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/privilege"
+)
+
+func TestDropPrivileges(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("dropping privileges requires root on Linux")
+	}
+
+	newBuilder := func(t *testing.T, secret bool) (*Builder, func()) {
+		srcDir, err := ioutil.TempDir("", "builder-src-")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		outDir, err := ioutil.TempDir("", "builder-out-")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		cleanup := func() {
+			os.RemoveAll(srcDir)
+			os.RemoveAll(outDir)
+		}
+		if err := os.Chmod(srcDir, 0755); err != nil {
+			t.Fatalf("Failed to chmod dir: %v", err)
+		}
+		appDir := filepath.Join(srcDir, "usr", "share", "app")
+		if err := os.MkdirAll(appDir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(appDir, "data"), []byte("data\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if secret {
+			if err := ioutil.WriteFile(filepath.Join(appDir, "secret"), []byte("root only\n"), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+
+		builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.DpkgRoot = srcDir
+		builder.CopyAs = &privilege.Credential{UID: 65534, GID: 65534}
+		return builder, cleanup
+	}
+
+	builder, cleanup := newBuilder(t, false)
+	defer cleanup()
+	_, report, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if os.Geteuid() != 0 {
+		t.Fatalf("Expected root to be restored after the copy, euid is %d", os.Geteuid())
+	}
+	if !report.Root || report.CopiedAs != "65534:65534" || len(report.Elevated) != 0 {
+		t.Errorf("Unexpected report root=%v copied_as=%q elevated=%v", report.Root, report.CopiedAs, report.Elevated)
+	}
+
+	// Files the invoking user cannot read are not packaged with root's rights
+	builder, cleanup = newBuilder(t, true)
+	defer cleanup()
+	if _, _, err := builder.Build(context.Background()); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the copy of a root-only file to fail, got %v", err)
+	}
+	if os.Geteuid() != 0 {
+		t.Fatalf("Expected root to be restored after a failed copy, euid is %d", os.Geteuid())
+	}
+
+	// Without a user to copy as, preserving owners is done with chown as
+	// root, which is reported
+	builder, cleanup = newBuilder(t, false)
+	defer cleanup()
+	builder.PreserveOwner = true
+	builder.CopyAs = nil
+	_, report, err = builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if report.CopiedAs != "" || len(report.Elevated) != 1 {
+		t.Errorf("Expected the chown to be reported, got copied_as=%q elevated=%v", report.CopiedAs, report.Elevated)
+	}
+}
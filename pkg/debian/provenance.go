@@ -0,0 +1,101 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/provenance"
+)
+
+// provenanceParameters returns the build parameters recorded in the
+// provenance of a package: its metadata and the options that shape its
+// contents
+func provenanceParameters(options *BuildOptions, pkg *Package) map[string]interface{} {
+	params := map[string]interface{}{
+		"package":          pkg.Name,
+		"version":          pkg.Version,
+		"architecture":     pkg.Architecture,
+		"maintainer":       pkg.Maintainer,
+		"type":             options.PackageType,
+		"profile":          options.Profile,
+		"transform_target": options.TransformTarget,
+		"per_package_dir":  options.PerPackageDir,
+		"strict":           options.StrictMode,
+		"strip":            options.StripExecutables || options.StripLibraries,
+	}
+	optional := map[string][]string{
+		"depends":           pkg.Depends,
+		"exclude":           options.ExcludeDirs,
+		"include":           options.IncludePatterns,
+		"symlink_dirs":      options.SymlinkDirs,
+		"allowed_paths":     options.AllowSystemPaths,
+		"config":            nonEmpty(options.ConfigFile),
+		"policy":            nonEmpty(options.PolicyFile),
+		"from_plan":         nonEmpty(options.FromPlan),
+		"maintainer_script": nonEmpty(options.MaintainerScript),
+	}
+	for name, values := range optional {
+		if len(values) > 0 {
+			params[name] = values
+		}
+	}
+	return params
+}
+
+// nonEmpty returns a slice holding s, or nil if s is empty
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// writeProvenance writes the signed provenance of the packages at paths
+// next to the first one: <package>.intoto.jsonl
+func writeProvenance(options *BuildOptions, pkg *Package, sourceDir string, started time.Time, paths ...string) error {
+	keyFile := options.ProvenanceKey
+	if keyFile == "" {
+		var err error
+		if keyFile, err = provenance.DefaultKeyFile(); err != nil {
+			return fmt.Errorf("%w; give a key with --provenance-key or build with --provenance=false", err)
+		}
+	}
+	key, generated, err := provenance.LoadOrGenerateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	if generated {
+		fmt.Fprintf(os.Stderr, "Warning: generated provenance signing key %s; verifiers need %s\n",
+			keyFile, provenance.PublicKeyFile(keyFile))
+	}
+
+	statement := provenance.NewStatement(provenanceParameters(options, pkg), started, time.Now())
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := statement.AddSubject(path); err != nil {
+			return err
+		}
+	}
+	for _, dep := range append([]string{sourceDir}, options.ConfigFile, options.PolicyFile, options.FromPlan, options.MaintainerScript) {
+		if dep == "" {
+			continue
+		}
+		if err := statement.AddDependency(dep); err != nil {
+			return fmt.Errorf("failed to record provenance of %s: %w", dep, err)
+		}
+	}
+
+	envelope, err := provenance.Sign(statement, key)
+	if err != nil {
+		return err
+	}
+	path := paths[0] + provenance.Extension
+	if err := envelope.WriteFile(path); err != nil {
+		return err
+	}
+	fmt.Printf("Provenance: %s\n", path)
+	return nil
+}
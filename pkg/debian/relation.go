@@ -0,0 +1,190 @@
+package debian
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Relation is one package in a relationship field such as Depends, for
+// example "libc6:amd64 (>= 2.31) [amd64]"
+type Relation struct {
+	Name          string   // Package name
+	ArchQualifier string   // Architecture after ':', such as any or amd64
+	Operator      string   // One of <<, <=, =, >=, >>; empty without a version
+	Version       string   // Version the operator compares against
+	Architectures []string // Architecture restriction, such as amd64 or !i386
+	Profiles      []string // Build profile restrictions, such as !nocheck
+}
+
+// Alternatives are relations of which one must be satisfied, written as
+// "a | b"
+type Alternatives []Relation
+
+// Relationships is the value of a relationship field: comma-separated
+// alternatives that must all be satisfied
+type Relationships []Alternatives
+
+// relationPattern splits a relation into package name, architecture
+// qualifier, version operator, version, architecture restriction and build
+// profiles
+var relationPattern = regexp.MustCompile(`^([^\s:(\[<]+)(?::([a-z0-9-]+))?\s*(?:\(\s*([<>=]+)\s*([^\s)]*)\s*\))?\s*(?:\[([^\]]*)\]\s*)?((?:<[^>]*>\s*)*)$`)
+
+// relationOperators are the version operators dpkg accepts
+var relationOperators = []string{"<<", "<=", "=", ">=", ">>"}
+
+// ParseRelation parses a single relation such as "python3:any" or
+// "libc6 (>= 2.31)"
+func ParseRelation(s string) (Relation, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Relation{}, fmt.Errorf("empty package name")
+	}
+	match := relationPattern.FindStringSubmatch(s)
+	if match == nil {
+		return Relation{}, fmt.Errorf("expected \"name\", \"name (op version)\" or \"name:arch\", with op one of %s", strings.Join(relationOperators, ", "))
+	}
+
+	r := Relation{Name: match[1], ArchQualifier: match[2], Operator: match[3], Version: match[4]}
+	if !validName.MatchString(r.Name) {
+		return Relation{}, fmt.Errorf("invalid package name %q", r.Name)
+	}
+	if q := r.ArchQualifier; q != "" && q != "any" && q != "native" && ValidateArchitecture(q) != nil {
+		return Relation{}, fmt.Errorf("unknown architecture qualifier %q", q)
+	}
+	if r.Operator != "" {
+		switch {
+		case r.Operator == "<" || r.Operator == ">":
+			return Relation{}, fmt.Errorf("the operator %s is obsolete: use %s%s or %s=", r.Operator, r.Operator, r.Operator, r.Operator)
+		case !contains(relationOperators, r.Operator):
+			return Relation{}, fmt.Errorf("unknown version operator %q: use %s", r.Operator, strings.Join(relationOperators, ", "))
+		}
+		if err := ValidateVersion(r.Version); err != nil {
+			return Relation{}, err
+		}
+	}
+
+	if match[5] != "" {
+		if r.Architectures = strings.Fields(match[5]); len(r.Architectures) == 0 {
+			return Relation{}, fmt.Errorf("empty architecture restriction")
+		}
+	}
+	negated := 0
+	for _, arch := range r.Architectures {
+		if strings.HasPrefix(arch, "!") {
+			negated++
+		}
+		if name := strings.TrimPrefix(arch, "!"); name == ArchitectureAll || ValidateArchitecture(name) != nil {
+			return Relation{}, fmt.Errorf("unknown architecture %q in restriction", name)
+		}
+	}
+	if negated != 0 && negated != len(r.Architectures) {
+		return Relation{}, fmt.Errorf("an architecture restriction cannot mix negated and plain architectures")
+	}
+	for _, profile := range strings.Split(match[6], ">") {
+		if profile = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(profile), "<")); profile != "" {
+			r.Profiles = append(r.Profiles, profile)
+		}
+	}
+	return r, nil
+}
+
+// ParseRelationships parses a relationship field such as
+// "libc6 (>= 2.31) | musl, python3:any"
+func ParseRelationships(value string) (Relationships, error) {
+	var relationships Relationships
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		var alternatives Alternatives
+		for _, alternative := range strings.Split(entry, "|") {
+			r, err := ParseRelation(alternative)
+			if err != nil {
+				return nil, fmt.Errorf("invalid relationship %q: %w", strings.TrimSpace(entry), err)
+			}
+			alternatives = append(alternatives, r)
+		}
+		relationships = append(relationships, alternatives)
+	}
+	return relationships, nil
+}
+
+// ParseRelationshipList parses relationship entries given one per flag or
+// list item. Each entry may itself be a comma-separated list.
+func ParseRelationshipList(entries []string) (Relationships, error) {
+	return ParseRelationships(strings.Join(entries, ","))
+}
+
+// AppliesTo reports whether the architecture restriction of r includes arch.
+// Architecture: all packages only get relations without a restriction or with
+// a negated one.
+func (r Relation) AppliesTo(arch string) bool {
+	if len(r.Architectures) == 0 {
+		return true
+	}
+	negated := strings.HasPrefix(r.Architectures[0], "!")
+	for _, restriction := range r.Architectures {
+		if strings.TrimPrefix(restriction, "!") == arch {
+			return !negated
+		}
+	}
+	return negated
+}
+
+// String formats r as it appears in a control file
+func (r Relation) String() string {
+	var s strings.Builder
+	s.WriteString(r.Name)
+	if r.ArchQualifier != "" {
+		s.WriteString(":" + r.ArchQualifier)
+	}
+	if r.Operator != "" {
+		fmt.Fprintf(&s, " (%s %s)", r.Operator, r.Version)
+	}
+	if len(r.Architectures) > 0 {
+		fmt.Fprintf(&s, " [%s]", strings.Join(r.Architectures, " "))
+	}
+	for _, profile := range r.Profiles {
+		fmt.Fprintf(&s, " <%s>", profile)
+	}
+	return s.String()
+}
+
+// String formats a as "a | b"
+func (a Alternatives) String() string {
+	parts := make([]string, len(a))
+	for i, r := range a {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, " | ")
+}
+
+// String formats rs as "a, b | c"
+func (rs Relationships) String() string {
+	parts := make([]string, len(rs))
+	for i, alternatives := range rs {
+		parts[i] = alternatives.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ForArchitecture returns the relationships that apply to arch, with the
+// architecture restrictions resolved as dpkg-gencontrol does. Alternatives
+// left without any relation are dropped.
+func (rs Relationships) ForArchitecture(arch string) Relationships {
+	var resolved Relationships
+	for _, alternatives := range rs {
+		var kept Alternatives
+		for _, r := range alternatives {
+			if r.AppliesTo(arch) {
+				r.Architectures = nil
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) > 0 {
+			resolved = append(resolved, kept)
+		}
+	}
+	return resolved
+}
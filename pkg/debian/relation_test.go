@@ -0,0 +1,81 @@
+package debian
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRelationships(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Relationships
+		format  string
+		wantErr bool
+	}{
+		{
+			name:  "Versioned alternatives",
+			value: "libc6 (>=2.31)|musl, python3:any",
+			want: Relationships{
+				{{Name: "libc6", Operator: ">=", Version: "2.31"}, {Name: "musl"}},
+				{{Name: "python3", ArchQualifier: "any"}},
+			},
+			format: "libc6 (>= 2.31) | musl, python3:any",
+		},
+		{
+			name:  "Architecture restriction and profile",
+			value: "libfoo:amd64 ( << 2:1.0-1 ) [amd64 arm64] <!nocheck> <cross>",
+			want: Relationships{{{
+				Name: "libfoo", ArchQualifier: "amd64", Operator: "<<", Version: "2:1.0-1",
+				Architectures: []string{"amd64", "arm64"}, Profiles: []string{"!nocheck", "cross"},
+			}}},
+			format: "libfoo:amd64 (<< 2:1.0-1) [amd64 arm64] <!nocheck> <cross>",
+		},
+		{name: "Empty", value: " , ", format: ""},
+		{name: "Obsolete operator", value: "libc6 (< 2.31)", wantErr: true},
+		{name: "Unknown qualifier", value: "python3:x86", wantErr: true},
+		{name: "Mixed restriction", value: "libfoo [amd64 !i386]", wantErr: true},
+		{name: "Unknown restriction", value: "libfoo [x86]", wantErr: true},
+		{name: "Missing parenthesis", value: "libfoo (>= 1.0", wantErr: true},
+		{name: "Bad version", value: "libfoo (= v1)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRelationships(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRelationships() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRelationships() = %#v, want %#v", got, tt.want)
+			}
+			if got.String() != tt.format {
+				t.Errorf("String() = %q, want %q", got.String(), tt.format)
+			}
+		})
+	}
+}
+
+func TestRelationshipsForArchitecture(t *testing.T) {
+	relationships, err := ParseRelationshipList([]string{
+		"libc6",
+		"libx86 [amd64 i386] | generic",
+		"libnotarm [!arm64], libarm [arm64]",
+	})
+	if err != nil {
+		t.Fatalf("ParseRelationshipList() error = %v", err)
+	}
+
+	for arch, want := range map[string]string{
+		"amd64": "libc6, libx86 | generic, libnotarm",
+		"arm64": "libc6, generic, libarm",
+		"all":   "libc6, generic, libnotarm",
+	} {
+		if got := relationships.ForArchitecture(arch).String(); got != want {
+			t.Errorf("ForArchitecture(%s) = %q, want %q", arch, got, want)
+		}
+	}
+}
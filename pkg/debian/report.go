@@ -0,0 +1,159 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/rpath"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// BuildReport describes the result of a build in a form CI jobs can consume
+type BuildReport struct {
+	Package        string                       `json:"package"`
+	Version        string                       `json:"version"`
+	Architecture   string                       `json:"architecture"`
+	Output         string                       `json:"output,omitempty"`
+	DebugPackage   string                       `json:"debug_package,omitempty"`
+	SHA256         string                       `json:"sha256,omitempty"`              // Checksum of the .deb
+	Size           int64                        `json:"size,omitempty"`                // Size of the .deb in bytes
+	Files          int                          `json:"files"`                         // Files, symlinks and hard links in the payload
+	PayloadSize    int64                        `json:"payload_size"`                  // Total size of the packaged files in bytes
+	InstalledSize  int64                        `json:"installed_size"`                // Installed-Size in KiB
+	CachedFiles    int                          `json:"cached_files,omitempty"`        // Files reused from the previous incremental build
+	SymlinksQueued int                          `json:"symlinks_queued"`               // Symlinks postinst creates at install time
+	Warnings       []string                     `json:"warnings,omitempty"`            // Warnings that did not stop the build
+	PathFindings   []string                     `json:"path_findings,omitempty"`       // Path violations reported but not enforced
+	Privileged     []string                     `json:"privileged,omitempty"`          // Setuid, setgid and world-writable files
+	Permissions    []security.PermissionIssue   `json:"permission_issues,omitempty"`   // Insecure modes found, and fixed with --fix-perms
+	Validation     []security.ValidationFinding `json:"validation,omitempty"`          // Other warnings and errors of the package validation
+	Hardening      []security.Hardening         `json:"hardening,omitempty"`           // ELF files lacking hardening features
+	Secrets        []security.SecretFinding     `json:"secrets,omitempty"`             // Likely credentials in the payload, masked
+	RPath          []rpath.Change               `json:"rpath,omitempty"`               // Library search paths rewritten by --fix-rpath
+	DevFiles       []string                     `json:"dev_files,omitempty"`           // Development files rewritten by --fix-dev-files
+	Conflicts      []string                     `json:"conflicts,omitempty"`           // Paths already owned by installed packages
+	Suggestions    []RelationSuggestion         `json:"suggested_relations,omitempty"` // Relations with packages shipping the same commands
+	Payload        []PayloadFinding             `json:"payload_findings,omitempty"`    // Junk and duplicate files worth excluding or symlinking
+	Overrides      []string                     `json:"overrides,omitempty"`           // Validations that were bypassed
+	Waivers        []security.WaivedFinding     `json:"waivers,omitempty"`             // Script findings accepted by a waiver
+	Root           bool                         `json:"root"`                          // Whether the build ran as root
+	CopiedAs       string                       `json:"copied_as,omitempty"`           // uid:gid the copy phase of a root build ran as
+	Elevated       []string                     `json:"elevated,omitempty"`            // Operations that needed root
+	BuildDir       string                       `json:"build_dir,omitempty"`           // Build directory kept after a failure
+	Error          string                       `json:"error,omitempty"`
+}
+
+// ReportPath returns where the JSON report of the package at debPath is
+// written: next to it, with .report.json in place of .deb
+func ReportPath(debPath string) string {
+	return strings.TrimSuffix(debPath, ".deb") + ".report.json"
+}
+
+// WriteJSON writes r as indented JSON to w
+func (r *BuildReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteFile writes r as JSON to path
+func (r *BuildReport) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create build report: %w", err)
+	}
+	err = r.WriteJSON(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write build report: %w", err)
+	}
+	return nil
+}
+
+// report returns the report of a build that wrote outputPath or failed with err
+func (b *Builder) report(outputPath string, err error) *BuildReport {
+	report := &BuildReport{
+		Package:        b.Package.Name,
+		Version:        b.Package.Version,
+		Architecture:   b.Package.Architecture,
+		Output:         outputPath,
+		DebugPackage:   b.DebugPackagePath,
+		Files:          len(b.PackagedFiles),
+		PayloadSize:    b.payloadSize,
+		InstalledSize:  b.installedSize,
+		CachedFiles:    b.incremental.cachedFiles(),
+		SymlinksQueued: b.SymlinkProcessor.GetQueuedSymlinkCount(),
+		Warnings:       append([]string(nil), b.Warnings...),
+		PathFindings:   append([]string(nil), b.PathFindings...),
+		Privileged:     append([]string(nil), b.ModeFindings...),
+		Permissions:    append([]security.PermissionIssue(nil), b.PermissionIssues...),
+		Validation:     append([]security.ValidationFinding(nil), b.ValidationFindings...),
+		Hardening:      append([]security.Hardening(nil), b.HardeningFindings...),
+		Secrets:        append([]security.SecretFinding(nil), b.SecretFindings...),
+		RPath:          append([]rpath.Change(nil), b.RPathChanges...),
+		DevFiles:       append([]string(nil), b.DevFiles...),
+		Overrides:      append([]string(nil), b.Overrides...),
+		Waivers:        append([]security.WaivedFinding(nil), b.WaivedFindings...),
+		Suggestions:    append([]RelationSuggestion(nil), b.RelationSuggestions...),
+		Payload:        append([]PayloadFinding(nil), b.PayloadFindings...),
+		Root:           geteuid() == 0,
+		CopiedAs:       b.copiedAs,
+		Elevated:       append([]string(nil), b.Elevated...),
+	}
+	for _, conflict := range b.OwnershipConflicts {
+		report.Conflicts = append(report.Conflicts, conflict.String())
+	}
+	if err != nil {
+		report.Error = err.Error()
+		if b.KeepBuildDir {
+			report.BuildDir = b.BuildDir
+		}
+	}
+	return report
+}
+
+// checksumWriter passes writes through to w while computing their SHA-256
+// checksum and size
+type checksumWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, hash: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.hash.Write(p[:n])
+	c.size += int64(n)
+	return n, err
+}
+
+// sum returns the hex-encoded checksum of everything written
+func (c *checksumWriter) sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// checksumFile returns the SHA-256 checksum and size of the file at path
+func checksumFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	c := newChecksumWriter(io.Discard)
+	if _, err := io.Copy(c, f); err != nil {
+		return "", 0, err
+	}
+	return c.sum(), c.size, nil
+}
@@ -0,0 +1,152 @@
+package debian
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+func TestBuildReport(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "report-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "report-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "data"), make([]byte, 3000), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "world"), []byte("x"), 0666); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(srcDir, "usr", "share", "app", "world"), 0666); err != nil {
+		t.Fatalf("Failed to chmod file: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir,
+		WithStreaming(true), WithPreservePerms(true))
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	builder.DpkgRoot = srcDir
+
+	outputPath, report, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	sum, size, err := checksumFile(outputPath)
+	if err != nil {
+		t.Fatalf("checksumFile() error = %v", err)
+	}
+	if report.Output != outputPath || report.SHA256 != sum || report.Size != size {
+		t.Errorf("Report output %s %s %d, want %s %s %d", report.Output, report.SHA256, report.Size, outputPath, sum, size)
+	}
+	if report.Files != 2 || report.PayloadSize != 3001 {
+		t.Errorf("Report has %d files of %d bytes, want 2 files of 3001 bytes", report.Files, report.PayloadSize)
+	}
+	if report.InstalledSize != builder.installedSize || report.Architecture != "all" {
+		t.Errorf("Report has installed size %d and architecture %s", report.InstalledSize, report.Architecture)
+	}
+	if len(report.Privileged) != 1 || len(report.Warnings) == 0 {
+		t.Errorf("Expected the world-writable file in privileged files and warnings, got %v and %v", report.Privileged, report.Warnings)
+	}
+
+	reportPath := ReportPath(outputPath)
+	if filepath.Base(reportPath) != "app_1.0_all.report.json" {
+		t.Errorf("ReportPath() = %s", reportPath)
+	}
+	if err := report.WriteFile(reportPath); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	content, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	var decoded BuildReport
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("Report is not valid JSON: %v", err)
+	}
+	if decoded.SHA256 != report.SHA256 || decoded.Files != report.Files {
+		t.Errorf("Decoded report %+v does not match %+v", decoded, report)
+	}
+}
+
+func TestScriptWaiversRecorded(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "waiver-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "waiver-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "data"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	pkg := NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil)
+	if _, err := NewBuilder(pkg, srcDir, outDir, WithScriptWaivers(security.ScriptWaiver{Rule: security.RuleRiskyCommand})); err == nil {
+		t.Errorf("Expected a waiver without justification to be rejected")
+	}
+
+	logPath := filepath.Join(outDir, "audit.jsonl")
+	auditLog, err := auditlog.New(logPath)
+	if err != nil {
+		t.Fatalf("auditlog.New() error = %v", err)
+	}
+	auditlog.SetDefault(auditLog)
+	defer auditlog.SetDefault(nil)
+
+	builder, err := NewBuilder(pkg, srcDir, outDir,
+		WithStreaming(true),
+		WithScriptWaivers(security.ScriptWaiver{Rule: security.RuleRiskyCommand, Detail: "systemctl", Justification: "restarts the app service"}),
+		WithMaintainerScript("postinst", "#!/bin/sh\nsystemctl restart app\n"))
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	builder.DpkgRoot = srcDir
+
+	_, report, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(report.Waivers) != 1 || report.Waivers[0].Detail != "systemctl" || report.Waivers[0].Script != "postinst" {
+		t.Errorf("Expected the waived systemctl finding in the report, got %+v", report.Waivers)
+	}
+
+	entries, err := auditLog.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var waived []auditlog.Entry
+	for _, entry := range entries {
+		if entry.Action == auditlog.ActionFindingWaived {
+			waived = append(waived, entry)
+		}
+	}
+	if len(waived) != 1 || !strings.Contains(waived[0].Detail, "restarts the app service") {
+		t.Errorf("Expected one waiver in the audit log, got %+v", entries)
+	}
+}
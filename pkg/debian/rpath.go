@@ -0,0 +1,113 @@
+package debian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/rpath"
+)
+
+// relocatedPath returns where a path named in a packaged file, such as a
+// directory of a library search path, is packaged, if the payload ships it
+// and the layout relocates it
+func (b *Builder) relocatedPath(p string) (string, bool) {
+	if _, err := os.Lstat(filepath.Join(b.SourceDir, filepath.FromSlash(p))); err != nil {
+		return "", false
+	}
+	target, _, err := b.PathMapper.TransformPath(p)
+	if err != nil || target == p {
+		return "", false
+	}
+	return target, true
+}
+
+// rewriteRPath rewrites the RUNPATH and RPATH of the ELF file at contentPath,
+// packaged at packagePath, to the relocated library directories. Unless
+// contentPath is a temporary copy, as owned tells, the file is copied first.
+// It returns the file to package and the cleanup of any new copy; files that
+// cannot be rewritten are packaged as they are, with a warning.
+func (b *Builder) rewriteRPath(contentPath string, owned bool, packagePath string) (string, func(), error) {
+	none := func() {}
+	if !b.FixRPath {
+		return contentPath, none, nil
+	}
+	entries, err := rpath.Read(contentPath)
+	if err != nil || len(entries) == 0 {
+		return contentPath, none, nil
+	}
+
+	file, cleanup := contentPath, none
+	if !owned {
+		if file, err = copyToTemp(b.WorkDir, contentPath); err != nil {
+			return "", none, err
+		}
+		cleanup = func() { os.Remove(file) }
+	}
+	changes, err := rpath.Rewrite(file, packagePath, b.relocatedPath)
+	if err != nil {
+		cleanup()
+		hint := ""
+		if errors.Is(err, rpath.ErrNoSpace) {
+			hint = "; a wrapper script can set LD_LIBRARY_PATH instead"
+		}
+		b.warn("Not rewriting the library search path: %v%s", err, hint)
+		return contentPath, none, nil
+	}
+	if len(changes) == 0 {
+		cleanup()
+		return contentPath, none, nil
+	}
+
+	b.rpathMu.Lock()
+	b.RPathChanges = append(b.RPathChanges, changes...)
+	b.rpathMu.Unlock()
+	for _, change := range changes {
+		b.log("Rewrote %s", change)
+	}
+	return file, cleanup, nil
+}
+
+// elfContent returns the file whose content is packaged for the ELF file at
+// srcPath, stripped and with its library search path rewritten as
+// configured. cleanup removes any temporary copy.
+func (b *Builder) elfContent(ctx context.Context, srcPath, packagePath string) (string, func(), error) {
+	contentPath, cleanup, err := b.stripFile(ctx, srcPath, packagePath)
+	if err != nil {
+		return "", cleanup, err
+	}
+	rewritten, rewriteCleanup, err := b.rewriteRPath(contentPath, contentPath != srcPath, packagePath)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return rewritten, func() {
+		rewriteCleanup()
+		cleanup()
+	}, nil
+}
+
+// copyToTemp copies the file at src to a new temporary file in dir
+func copyToTemp(dir, src string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(dir, "pkginstall-rpath-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,72 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/rpath"
+)
+
+func TestFixRPath(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skipf("cc not available: %v", err)
+	}
+	srcDir, err := ioutil.TempDir("", "rpath-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	for _, dir := range []string{"usr/bin", "usr/lib/app"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	source := filepath.Join(srcDir, "main.c")
+	if err := ioutil.WriteFile(source, []byte("int main(void) { return 0; }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	args := []string{"-o", filepath.Join(srcDir, "usr/bin/app"), source, "-Wl,--enable-new-dtags,-rpath,/usr/lib/app:/usr/lib/x86_64-linux-gnu"}
+	if output, err := exec.Command("cc", args...).CombinedOutput(); err != nil {
+		t.Skipf("cc failed: %v: %s", err, output)
+	}
+	os.Remove(source)
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr/lib/app/libapp.so"), []byte("library"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, fix := range []bool{false, true} {
+		builder, err := NewBuilder(NewPackage("app", "1.0", "amd64", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir, WithFixRPath(fix))
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		defer builder.Clean()
+		if err := builder.copyFiles(context.Background()); err != nil {
+			t.Fatalf("copyFiles() error = %v", err)
+		}
+
+		entries, err := rpath.Read(filepath.Join(builder.BuildDir, "opt/usr/bin/app"))
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("rpath.Read() = %v, %v", entries, err)
+		}
+		want := "/usr/lib/app:/usr/lib/x86_64-linux-gnu"
+		if fix {
+			want = "/opt/usr/lib/app"
+		}
+		if entries[0].Value != want {
+			t.Errorf("RUNPATH with fix %v = %s, want %s", fix, entries[0].Value, want)
+		}
+		if fix != (len(builder.RPathChanges) == 1) {
+			t.Errorf("RPathChanges with fix %v = %v", fix, builder.RPathChanges)
+		}
+	}
+
+	// The source tree is never modified
+	entries, err := rpath.Read(filepath.Join(srcDir, "usr/bin/app"))
+	if err != nil || len(entries) != 1 || entries[0].Value != "/usr/lib/app:/usr/lib/x86_64-linux-gnu" {
+		t.Errorf("Source RUNPATH = %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,653 @@
+package debian
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rpmArches maps Debian architectures to RPM ones
+var rpmArches = map[string]string{
+	"amd64":   "x86_64",
+	"i386":    "i686",
+	"arm64":   "aarch64",
+	"armhf":   "armv7hl",
+	"ppc64el": "ppc64le",
+	"all":     "noarch",
+}
+
+// RPM header tag types
+const (
+	rpmInt16       = 3
+	rpmInt32       = 4
+	rpmString      = 6
+	rpmBin         = 7
+	rpmStringArray = 8
+	rpmI18NString  = 9
+)
+
+// RPM header tags
+const (
+	rpmTagHeaderSignatures = 62
+	rpmTagHeaderImmutable  = 63
+	rpmTagI18NTable        = 100
+
+	rpmSigTagSHA1        = 269
+	rpmSigTagSHA256      = 273
+	rpmSigTagSize        = 1000
+	rpmSigTagMD5         = 1004
+	rpmSigTagPayloadSize = 1007
+
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagEpoch             = 1003
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagSize              = 1009
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagPreIn             = 1023
+	rpmTagPostIn            = 1024
+	rpmTagPreUn             = 1025
+	rpmTagPostUn            = 1026
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRDevs         = 1033
+	rpmTagFileMTimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagConflictFlags     = 1053
+	rpmTagConflictName      = 1054
+	rpmTagConflictVersion   = 1055
+	rpmTagPreInProg         = 1085
+	rpmTagPostInProg        = 1086
+	rpmTagPreUnProg         = 1087
+	rpmTagPostUnProg        = 1088
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+)
+
+// RPM dependency flags
+const (
+	rpmSenseLess    = 0x02
+	rpmSenseGreater = 0x04
+	rpmSenseEqual   = 0x08
+	rpmSenseRPMLib  = 0x01000000
+)
+
+// rpmScripts maps maintainer scripts to the RPM script and interpreter tags
+var rpmScripts = []struct {
+	name      string
+	tag, prog int32
+}{
+	{"preinst", rpmTagPreIn, rpmTagPreInProg},
+	{"postinst", rpmTagPostIn, rpmTagPostInProg},
+	{"prerm", rpmTagPreUn, rpmTagPreUnProg},
+	{"postrm", rpmTagPostUn, rpmTagPostUnProg},
+}
+
+// RPMWriter writes basic binary RPM packages: the lead, a signature header
+// with digests, the package header and a gzip-compressed cpio payload. The
+// package is not signed, and Debian dependency names are kept as they are.
+type RPMWriter struct{}
+
+// FileName returns name-version-release.arch.rpm
+func (RPMWriter) FileName(staged *StagedPackage) string {
+	_, version := splitVersion(staged.Package.Version)
+	return fmt.Sprintf("%s-%s-%s.%s.rpm", staged.Package.Name, version, staged.Release, rpmArch(staged.Package.Architecture))
+}
+
+// rpmArch returns the RPM name of a Debian architecture
+func rpmArch(arch string) string {
+	if mapped, ok := rpmArches[arch]; ok {
+		return mapped
+	}
+	return arch
+}
+
+// WritePackage writes the .rpm package to w. The payload is compressed to a
+// temporary file first, since the headers hold its size and digest.
+func (RPMWriter) WritePackage(ctx context.Context, staged *StagedPackage, w io.Writer) error {
+	files, err := staged.files()
+	if err != nil {
+		return err
+	}
+
+	payload, err := os.CreateTemp(staged.WorkDir, ".pkginstall-payload-*.cpio.gz")
+	if err != nil {
+		return fmt.Errorf("failed to create payload: %w", err)
+	}
+	defer os.Remove(payload.Name())
+	defer payload.Close()
+
+	packaged, payloadSize, err := writeRPMPayload(ctx, payload, files)
+	if err != nil {
+		return err
+	}
+	header, err := rpmPackageHeader(staged, packaged)
+	if err != nil {
+		return err
+	}
+
+	// The MD5 digest and size cover the header and the compressed payload
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read payload: %w", err)
+	}
+	digest := md5.New()
+	digest.Write(header)
+	compressedSize, err := io.Copy(digest, payload)
+	if err != nil {
+		return fmt.Errorf("failed to read payload: %w", err)
+	}
+	sha1Sum := sha1.Sum(header)
+	sha256Sum := sha256.Sum256(header)
+
+	sig := &rpmHeader{}
+	sig.addString(rpmSigTagSHA1, hex.EncodeToString(sha1Sum[:]))
+	sig.addString(rpmSigTagSHA256, hex.EncodeToString(sha256Sum[:]))
+	sig.addInt32(rpmSigTagSize, int32(int64(len(header))+compressedSize))
+	sig.addBin(rpmSigTagMD5, digest.Sum(nil))
+	sig.addInt32(rpmSigTagPayloadSize, int32(payloadSize))
+	signature := sig.marshal(rpmTagHeaderSignatures)
+	// The signature header is padded to a multiple of eight bytes
+	if pad := len(signature) % 8; pad != 0 {
+		signature = append(signature, make([]byte, 8-pad)...)
+	}
+
+	if _, err := w.Write(rpmLead(staged)); err != nil {
+		return fmt.Errorf("failed to write lead: %w", err)
+	}
+	if _, err := w.Write(signature); err != nil {
+		return fmt.Errorf("failed to write signature header: %w", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read payload: %w", err)
+	}
+	if _, err := io.Copy(w, contextReader{ctx, payload}); err != nil {
+		return fmt.Errorf("failed to write payload: %w", err)
+	}
+	return nil
+}
+
+// rpmLead returns the 96-byte lead that starts every RPM file
+func rpmLead(staged *StagedPackage) []byte {
+	_, version := splitVersion(staged.Package.Version)
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	// Binary package; the architecture number is only informative
+	archnum := uint16(0)
+	if arch := rpmArch(staged.Package.Architecture); arch == "x86_64" || arch == "i686" {
+		archnum = 1
+	}
+	binary.BigEndian.PutUint16(lead[8:], archnum)
+	name := fmt.Sprintf("%s-%s-%s", staged.Package.Name, version, staged.Release)
+	if len(name) > 65 {
+		name = name[:65]
+	}
+	copy(lead[10:76], name)
+	binary.BigEndian.PutUint16(lead[76:], 1) // Linux
+	binary.BigEndian.PutUint16(lead[78:], 5) // Header-style signature
+	return lead
+}
+
+// rpmFile is a payload entry as listed in the package header
+type rpmFile struct {
+	stagedFile
+	size   int64
+	mode   uint32
+	digest string
+}
+
+// writeRPMPayload writes the gzip-compressed newc cpio payload and returns
+// its entries and uncompressed size. Directories are only packaged when
+// empty, so the package does not claim ownership of shared ones like /opt.
+func writeRPMPayload(ctx context.Context, w io.Writer, files []stagedFile) ([]rpmFile, int64, error) {
+	hasChildren := make(map[string]bool)
+	for _, file := range files {
+		hasChildren[path.Dir(file.Path)] = true
+	}
+
+	gz := gzip.NewWriter(w)
+	cpio := &cpioWriter{w: gz}
+	var packaged []rpmFile
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, fmt.Errorf("package build cancelled: %w", err)
+		}
+		entry := rpmFile{stagedFile: file}
+		perm := uint32(unixMode(file.Info.Mode()))
+		var err error
+		switch {
+		case file.Info.IsDir():
+			if hasChildren[file.Path] {
+				continue
+			}
+			entry.mode, entry.size = 040000|perm, 4096
+			err = cpio.writeEntry(file, uint32(len(packaged)+1), entry.mode, 0, nil)
+		case file.Link != "":
+			entry.mode, entry.size = 0120777, int64(len(file.Link))
+			err = cpio.writeEntry(file, uint32(len(packaged)+1), entry.mode, entry.size, strings.NewReader(file.Link))
+		case file.Info.Mode().IsRegular():
+			entry.mode, entry.size = 0100000|perm, file.Info.Size()
+			entry.digest, err = cpio.writeFile(ctx, file, uint32(len(packaged)+1), entry.mode)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to write %s to payload: %w", file.Path, err)
+		}
+		packaged = append(packaged, entry)
+	}
+	if err := cpio.writeHeader("TRAILER!!!", 0, 0, 0, 0); err != nil {
+		return nil, 0, fmt.Errorf("failed to finish payload: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, fmt.Errorf("failed to finish compressed payload: %w", err)
+	}
+	return packaged, cpio.n, nil
+}
+
+// cpioWriter writes an archive in the "newc" cpio format used by RPM
+type cpioWriter struct {
+	w io.Writer
+	n int64 // Bytes written
+}
+
+func (c *cpioWriter) write(p []byte) error {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return err
+}
+
+// pad writes zero bytes up to the next multiple of four
+func (c *cpioWriter) pad() error {
+	if rem := c.n % 4; rem != 0 {
+		return c.write(make([]byte, 4-rem))
+	}
+	return nil
+}
+
+// writeHeader writes the header and name of an archive entry
+func (c *cpioWriter) writeHeader(name string, ino, mode uint32, mtime, size int64) error {
+	nlink := 1
+	if mode&040000 != 0 {
+		nlink = 2
+	}
+	header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		ino, mode, 0, 0, nlink, mtime, size, 0, 0, 0, 0, len(name)+1, 0)
+	if err := c.write([]byte(header + name + "\x00")); err != nil {
+		return err
+	}
+	return c.pad()
+}
+
+// writeEntry writes a payload entry with its content, named "./path" as
+// the PayloadFilesHavePrefix feature requires
+func (c *cpioWriter) writeEntry(file stagedFile, ino, mode uint32, size int64, content io.Reader) error {
+	if err := c.writeHeader("."+file.Path, ino, mode, file.Info.ModTime().Unix(), size); err != nil {
+		return err
+	}
+	if content != nil {
+		n, err := io.Copy(c.w, content)
+		c.n += n
+		if err != nil {
+			return err
+		}
+	}
+	return c.pad()
+}
+
+// writeFile writes a staged regular file and returns its MD5 checksum
+func (c *cpioWriter) writeFile(ctx context.Context, file stagedFile, ino, mode uint32) (string, error) {
+	src, err := os.Open(file.Src)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	hash := md5.New()
+	if err := c.writeEntry(file, ino, mode, file.Info.Size(), io.TeeReader(contextReader{ctx, src}, hash)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// rpmPackageHeader returns the main header of the package
+func rpmPackageHeader(staged *StagedPackage, files []rpmFile) ([]byte, error) {
+	pkg := staged.Package
+	epoch, version := splitVersion(pkg.Version)
+	synopsis, body, _ := strings.Cut(pkg.Description, "\n")
+	description := rpmDescription(body)
+	if description == "" {
+		description = synopsis
+	}
+	group := pkg.Section
+	if group == "" {
+		group = "Unspecified"
+	}
+
+	h := &rpmHeader{}
+	h.addStrings(rpmTagI18NTable, []string{"C"})
+	h.addString(rpmTagName, pkg.Name)
+	h.addString(rpmTagVersion, version)
+	h.addString(rpmTagRelease, staged.Release)
+	if epoch != "" {
+		n, err := strconv.Atoi(epoch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid epoch in version %s", pkg.Version)
+		}
+		h.addInt32(rpmTagEpoch, int32(n))
+	}
+	h.addI18NString(rpmTagSummary, strings.TrimSpace(synopsis))
+	h.addI18NString(rpmTagDescription, description)
+	h.addInt32(rpmTagBuildTime, int32(staged.BuildTime.Unix()))
+	h.addString(rpmTagLicense, "Unspecified")
+	if pkg.Maintainer != "" {
+		h.addString(rpmTagPackager, pkg.Maintainer)
+	}
+	h.addI18NString(rpmTagGroup, group)
+	h.addString(rpmTagOS, "linux")
+	h.addString(rpmTagArch, rpmArch(pkg.Architecture))
+	h.addString(rpmTagPayloadFormat, "cpio")
+	h.addString(rpmTagPayloadCompressor, "gzip")
+	h.addString(rpmTagPayloadFlags, "9")
+
+	for _, script := range rpmScripts {
+		if content, ok := staged.Scripts[script.name]; ok {
+			h.addString(script.tag, content)
+			h.addString(script.prog, "/bin/sh")
+		}
+	}
+
+	var totalSize int64
+	if len(files) > 0 {
+		var (
+			sizes, mtimes, flags, devices, inodes, dirIndexes []int32
+			modes, rdevs                                      []int16
+			digests, linkTos, users, groups, langs, baseNames []string
+			dirNames                                          []string
+		)
+		dirIndex := make(map[string]int32)
+		for i, file := range files {
+			totalSize += file.size
+			sizes = append(sizes, int32(file.size))
+			modes = append(modes, int16(file.mode))
+			rdevs = append(rdevs, 0)
+			mtimes = append(mtimes, int32(file.Info.ModTime().Unix()))
+			digests = append(digests, file.digest)
+			linkTos = append(linkTos, file.Link)
+			flags = append(flags, 0)
+			users = append(users, "root")
+			groups = append(groups, "root")
+			devices = append(devices, 1)
+			inodes = append(inodes, int32(i+1))
+			langs = append(langs, "")
+
+			dir, base := path.Split(file.Path)
+			index, ok := dirIndex[dir]
+			if !ok {
+				index = int32(len(dirNames))
+				dirIndex[dir] = index
+				dirNames = append(dirNames, dir)
+			}
+			dirIndexes = append(dirIndexes, index)
+			baseNames = append(baseNames, base)
+		}
+		h.addInt32(rpmTagFileSizes, sizes...)
+		h.addInt16(rpmTagFileModes, modes...)
+		h.addInt16(rpmTagFileRDevs, rdevs...)
+		h.addInt32(rpmTagFileMTimes, mtimes...)
+		h.addStrings(rpmTagFileDigests, digests)
+		h.addStrings(rpmTagFileLinkTos, linkTos)
+		h.addInt32(rpmTagFileFlags, flags...)
+		h.addStrings(rpmTagFileUserName, users)
+		h.addStrings(rpmTagFileGroupName, groups)
+		h.addInt32(rpmTagFileDevices, devices...)
+		h.addInt32(rpmTagFileInodes, inodes...)
+		h.addStrings(rpmTagFileLangs, langs)
+		h.addInt32(rpmTagDirIndexes, dirIndexes...)
+		h.addStrings(rpmTagBaseNames, baseNames)
+		h.addStrings(rpmTagDirNames, dirNames)
+		h.addInt32(rpmTagFileDigestAlgo, 1) // MD5
+	}
+	h.addInt32(rpmTagSize, int32(totalSize))
+
+	requires := rpmDependencies{
+		{"rpmlib(CompressedFileNames)", rpmSenseLess | rpmSenseEqual | rpmSenseRPMLib, "3.0.4-1"},
+		{"rpmlib(PayloadFilesHavePrefix)", rpmSenseLess | rpmSenseEqual | rpmSenseRPMLib, "4.0-1"},
+	}
+	depends, err := rpmRelations(staged, "Depends", pkg.Depends)
+	if err != nil {
+		return nil, err
+	}
+	requires = append(requires, depends...)
+	requires.add(h, rpmTagRequireName, rpmTagRequireFlags, rpmTagRequireVersion)
+
+	provides := rpmDependencies{{pkg.Name, rpmSenseEqual, rpmEVR(epoch, version, staged.Release)}}
+	extra, err := rpmRelations(staged, "Provides", staged.Provides)
+	if err != nil {
+		return nil, err
+	}
+	provides = append(provides, extra...)
+	provides.add(h, rpmTagProvideName, rpmTagProvideFlags, rpmTagProvideVersion)
+
+	conflicts, err := rpmRelations(staged, "Conflicts", staged.Conflicts)
+	if err != nil {
+		return nil, err
+	}
+	conflicts.add(h, rpmTagConflictName, rpmTagConflictFlags, rpmTagConflictVersion)
+	if len(staged.Replaces) > 0 {
+		staged.warn("RPM packages have no equivalent of Replaces; %s not recorded", strings.Join(staged.Replaces, ", "))
+	}
+
+	return h.marshal(rpmTagHeaderImmutable), nil
+}
+
+// rpmDescription joins the extended description of a Debian package into
+// paragraphs, dropping the leading space and the " ." separators
+func rpmDescription(body string) string {
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimPrefix(line, " ")
+		if line == "." {
+			line = ""
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// rpmEVR formats an epoch, version and release as RPM compares them
+func rpmEVR(epoch, version, release string) string {
+	evr := version + "-" + release
+	if epoch != "" {
+		evr = epoch + ":" + evr
+	}
+	return evr
+}
+
+// rpmDependency is an entry of a Requires, Provides or Conflicts list
+type rpmDependency struct {
+	name    string
+	flags   int32
+	version string
+}
+
+type rpmDependencies []rpmDependency
+
+// add records the dependencies in the header; empty lists are left out
+func (deps rpmDependencies) add(h *rpmHeader, nameTag, flagsTag, versionTag int32) {
+	if len(deps) == 0 {
+		return
+	}
+	names := make([]string, len(deps))
+	flags := make([]int32, len(deps))
+	versions := make([]string, len(deps))
+	for i, dep := range deps {
+		names[i], flags[i], versions[i] = dep.name, dep.flags, dep.version
+	}
+	h.addStrings(nameTag, names)
+	h.addInt32(flagsTag, flags...)
+	h.addStrings(versionTag, versions)
+}
+
+// rpmRelations converts a Debian relationship field. Only the first of
+// alternatives is kept, since plain RPM dependencies cannot express them.
+func rpmRelations(staged *StagedPackage, field string, entries []string) (rpmDependencies, error) {
+	relationships, err := ParseRelationshipList(entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	var deps rpmDependencies
+	for _, alternatives := range relationships.ForArchitecture(staged.Package.Architecture) {
+		if len(alternatives) > 1 {
+			staged.warn("RPM packages cannot express alternatives; %s %q becomes %q", field, alternatives.String(), alternatives[0].Name)
+		}
+		r := alternatives[0]
+		dep := rpmDependency{name: r.Name, version: r.Version}
+		switch r.Operator {
+		case "<<":
+			dep.flags = rpmSenseLess
+		case "<=":
+			dep.flags = rpmSenseLess | rpmSenseEqual
+		case "=":
+			dep.flags = rpmSenseEqual
+		case ">=":
+			dep.flags = rpmSenseGreater | rpmSenseEqual
+		case ">>":
+			dep.flags = rpmSenseGreater
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+// rpmHeader builds an RPM header structure
+type rpmHeader struct {
+	entries []rpmEntry
+}
+
+// rpmEntry is one tag of a header
+type rpmEntry struct {
+	tag, typ int32
+	count    int
+	data     []byte
+}
+
+func (h *rpmHeader) add(tag, typ int32, count int, data []byte) {
+	h.entries = append(h.entries, rpmEntry{tag: tag, typ: typ, count: count, data: data})
+}
+
+func (h *rpmHeader) addString(tag int32, s string) {
+	h.add(tag, rpmString, 1, append([]byte(s), 0))
+}
+
+func (h *rpmHeader) addI18NString(tag int32, s string) {
+	h.add(tag, rpmI18NString, 1, append([]byte(s), 0))
+}
+
+func (h *rpmHeader) addStrings(tag int32, values []string) {
+	var data []byte
+	for _, s := range values {
+		data = append(append(data, s...), 0)
+	}
+	h.add(tag, rpmStringArray, len(values), data)
+}
+
+func (h *rpmHeader) addInt32(tag int32, values ...int32) {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], uint32(v))
+	}
+	h.add(tag, rpmInt32, len(values), data)
+}
+
+func (h *rpmHeader) addInt16(tag int32, values ...int16) {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	h.add(tag, rpmInt16, len(values), data)
+}
+
+func (h *rpmHeader) addBin(tag int32, data []byte) {
+	h.add(tag, rpmBin, len(data), data)
+}
+
+// marshal returns the header as an immutable region: the region tag comes
+// first and points at a trailer at the end of the data store, which holds
+// the negated size of the index
+func (h *rpmHeader) marshal(region int32) []byte {
+	entries := append([]rpmEntry(nil), h.entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	var index, store bytes.Buffer
+	writeIndex := func(tag, typ, offset int32, count int) {
+		binary.Write(&index, binary.BigEndian, []int32{tag, typ, offset, int32(count)})
+	}
+	offsets := make([]int32, len(entries))
+	for i, entry := range entries {
+		align := 1
+		switch entry.typ {
+		case rpmInt16:
+			align = 2
+		case rpmInt32:
+			align = 4
+		}
+		for store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		offsets[i] = int32(store.Len())
+		store.Write(entry.data)
+	}
+	trailer := int32(store.Len())
+	binary.Write(&store, binary.BigEndian, []int32{region, rpmBin, -int32(16 * (len(entries) + 1)), 16})
+
+	writeIndex(region, rpmBin, trailer, 16)
+	for i, entry := range entries {
+		writeIndex(entry.tag, entry.typ, offsets[i], entry.count)
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&out, binary.BigEndian, []int32{int32(len(entries) + 1), int32(store.Len())})
+	out.Write(index.Bytes())
+	out.Write(store.Bytes())
+	return out.Bytes()
+}
@@ -0,0 +1,29 @@
+package debian
+
+import (
+	"os"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+// checkSecrets scans a packaged file for likely credentials. A secret fails
+// the build in strict mode and is shipped with a warning otherwise; files
+// that cannot be read are left to the copy to report.
+func (b *Builder) checkSecrets(srcPath, packagePath string, info os.FileInfo) error {
+	if b.NoSecretScan || !info.Mode().IsRegular() {
+		return nil
+	}
+	findings, err := b.PathValidator.CheckSecrets(b.systemPath(srcPath), srcPath)
+	if err != nil {
+		return nil
+	}
+	for _, finding := range findings {
+		finding.Path = packagePath
+		if b.StrictMode {
+			return ci.Errorf(ci.ClassPolicy, "strict mode: %s; remove it or list the path in paths.secret_allow", finding)
+		}
+		b.warn("Secret: %s", finding)
+		b.SecretFindings = append(b.SecretFindings, finding)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package debian
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// slackwareArches maps Debian architectures to Slackware ones
+var slackwareArches = map[string]string{
+	"amd64": "x86_64",
+	"i386":  "i586",
+	"arm64": "aarch64",
+	"armhf": "arm",
+	"all":   "noarch",
+}
+
+// slackDescLines is the number of lines installpkg expects in slack-desc
+const slackDescLines = 11
+
+// SlackwareWriter writes Slackware .tgz packages as makepkg does: the payload
+// as a root-owned tar archive, with symlinks created by install/doinst.sh
+// and the description in install/slack-desc
+type SlackwareWriter struct{}
+
+// FileName returns name-version-arch-build.tgz
+func (SlackwareWriter) FileName(staged *StagedPackage) string {
+	_, version := splitVersion(staged.Package.Version)
+	return fmt.Sprintf("%s-%s-%s-%s.tgz", staged.Package.Name, version, slackwareArch(staged.Package.Architecture), staged.Release)
+}
+
+// slackwareArch returns the Slackware name of a Debian architecture
+func slackwareArch(arch string) string {
+	if mapped, ok := slackwareArches[arch]; ok {
+		return mapped
+	}
+	return arch
+}
+
+// WritePackage writes the .tgz package to w
+func (SlackwareWriter) WritePackage(ctx context.Context, staged *StagedPackage, w io.Writer) error {
+	for _, script := range []string{"preinst", "prerm", "postrm"} {
+		if _, ok := staged.Scripts[script]; ok {
+			staged.warn("Slackware packages have no %s script; it is not packaged", script)
+		}
+	}
+
+	files, err := staged.files()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	header := func(name string, typeflag byte, mode int64) *tar.Header {
+		return &tar.Header{
+			Typeflag: typeflag,
+			Name:     name,
+			Mode:     mode,
+			ModTime:  staged.BuildTime,
+			Uname:    "root",
+			Gname:    "root",
+			Format:   tar.FormatGNU,
+		}
+	}
+	addBytes := func(name string, content []byte, mode int64) error {
+		h := header(name, tar.TypeReg, mode)
+		h.Size = int64(len(content))
+		if err := tw.WriteHeader(h); err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", name, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	// makepkg replaces symlinks by commands in doinst.sh
+	var doinst strings.Builder
+	for _, file := range files {
+		if file.Link == "" {
+			continue
+		}
+		dir, name := path.Split(strings.TrimPrefix(file.Path, "/"))
+		dir = strings.TrimSuffix(dir, "/")
+		if dir == "" {
+			dir = "."
+		}
+		fmt.Fprintf(&doinst, "( cd %s ; rm -rf %s )\n", doinstQuote(dir), doinstQuote(name))
+		fmt.Fprintf(&doinst, "( cd %s ; ln -sf %s %s )\n", doinstQuote(dir), doinstQuote(file.Link), doinstQuote(name))
+	}
+	if postinst, ok := staged.Scripts["postinst"]; ok {
+		if doinst.Len() > 0 {
+			doinst.WriteString("\n")
+		}
+		doinst.WriteString(strings.TrimRight(postinst, "\n") + "\n")
+	}
+
+	if err := tw.WriteHeader(header("./", tar.TypeDir, 0755)); err != nil {
+		return fmt.Errorf("failed to write archive root: %w", err)
+	}
+	if err := tw.WriteHeader(header("install/", tar.TypeDir, 0755)); err != nil {
+		return fmt.Errorf("failed to write install directory: %w", err)
+	}
+	if err := addBytes("install/slack-desc", []byte(slackDesc(staged.Package)), 0644); err != nil {
+		return err
+	}
+	if doinst.Len() > 0 {
+		if err := addBytes("install/doinst.sh", []byte(doinst.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("package build cancelled: %w", err)
+		}
+		name := strings.TrimPrefix(file.Path, "/")
+		switch {
+		case file.Info.IsDir():
+			if err := tw.WriteHeader(header(name+"/", tar.TypeDir, unixMode(file.Info.Mode()))); err != nil {
+				return fmt.Errorf("failed to write directory %s: %w", file.Path, err)
+			}
+		case file.Link != "":
+			// Created by doinst.sh
+		case file.Info.Mode().IsRegular():
+			if err := addFile(ctx, tw, header(name, tar.TypeReg, unixMode(file.Info.Mode())), file); err != nil {
+				return err
+			}
+		default:
+			staged.warn("%s is not a regular file, directory or symlink; not packaged", file.Path)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed archive: %w", err)
+	}
+	return nil
+}
+
+// addFile writes a staged regular file under the given header
+func addFile(ctx context.Context, tw *tar.Writer, header *tar.Header, file stagedFile) error {
+	src, err := os.Open(file.Src)
+	if err != nil {
+		return fmt.Errorf("failed to open staged file %s: %w", file.Src, err)
+	}
+	defer src.Close()
+
+	header.Size = file.Info.Size()
+	header.ModTime = file.Info.ModTime()
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.Path, err)
+	}
+	if _, err := io.Copy(tw, contextReader{ctx, src}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.Path, err)
+	}
+	return nil
+}
+
+// slackDesc returns the install/slack-desc of a package: eleven lines
+// prefixed with the package name, the first holding the synopsis
+func slackDesc(pkg *Package) string {
+	synopsis, body, _ := strings.Cut(pkg.Description, "\n")
+	prefix := pkg.Name + ":"
+	lines := []string{fmt.Sprintf("%s %s (%s)", prefix, pkg.Name, strings.TrimSpace(synopsis)), prefix}
+
+	// Fold the extended description, dropping the Debian paragraph markers
+	width := 70
+	var line string
+	for _, word := range strings.Fields(body) {
+		if word == "." {
+			continue
+		}
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, prefix+" "+line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, prefix+" "+line)
+	}
+	if len(lines) > slackDescLines {
+		lines = lines[:slackDescLines]
+	}
+	for len(lines) < slackDescLines {
+		lines = append(lines, prefix)
+	}
+
+	var desc strings.Builder
+	desc.WriteString("# HOW TO EDIT THIS FILE:\n")
+	desc.WriteString("# The \"handy ruler\" below makes it easier to edit a package description.\n")
+	desc.WriteString("# Line up the first '|' above the ':' following the base package name, and\n")
+	desc.WriteString("# the '|' on the right side marks the last column you can put a character in.\n")
+	desc.WriteString("# You must make exactly 11 lines for the formatting to be correct.  It's also\n")
+	desc.WriteString("# customary to leave one space after the ':' except on otherwise blank lines.\n\n")
+	desc.WriteString(strings.Repeat(" ", len(pkg.Name)) + "|-----handy-ruler------------------------------------------------------|\n")
+	desc.WriteString(strings.Join(lines, "\n") + "\n")
+	return desc.String()
+}
+
+// doinstQuote quotes s for doinst.sh only where the shell needs it, since
+// removepkg matches the link commands in their unquoted form
+func doinstQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`;&|<>()*?[]{}~#!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,355 @@
+package debian
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDistribution is the changelog distribution of generated source
+// packages
+const DefaultDistribution = "unstable"
+
+// sourceStandardsVersion is the Debian policy version generated source
+// packages comply with
+const sourceStandardsVersion = "4.6.2"
+
+// sourceFile is a file of the generated debian/ directory
+type sourceFile struct {
+	name    string // Path relative to debian/
+	content string
+	mode    int64
+}
+
+// withoutEpoch returns version without its epoch, as it appears in file names
+func withoutEpoch(version string) string {
+	if _, v, ok := strings.Cut(version, ":"); ok {
+		return v
+	}
+	return version
+}
+
+// sourceVersion returns the upstream part of a version, which names the
+// tarballs, and whether the version lacks a Debian revision, making the
+// source package native
+func sourceVersion(version string) (upstream string, native bool) {
+	version = withoutEpoch(version)
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		return version[:i], false
+	}
+	return version, true
+}
+
+// sourceFileName returns the name of the .dsc file of the package
+func sourceFileName(pkg *Package) string {
+	return fmt.Sprintf("%s_%s.dsc", pkg.Name, withoutEpoch(pkg.Version))
+}
+
+// writeSourcePackage writes a 3.0 source package of the staged payload to
+// OutputDir, described by the .dsc at outputPath: the payload as it was
+// staged, with a debian/ directory whose rules install it unchanged, so the
+// buildd needs nothing but debhelper. Versions with a Debian revision get an
+// orig tarball and a debian tarball, others a single native tarball.
+func (b *Builder) writeSourcePackage(ctx context.Context, outputPath string) error {
+	payload, err := b.staged().files()
+	if err != nil {
+		return err
+	}
+	var topLevel []string
+	for _, file := range payload {
+		if strings.Count(file.Path, "/") == 1 {
+			if file.Path == "/debian" {
+				return fmt.Errorf("the payload cannot contain /debian in a source package")
+			}
+			topLevel = append(topLevel, strings.TrimPrefix(file.Path, "/"))
+		}
+	}
+
+	name := b.Package.Name
+	upstream, native := sourceVersion(b.Package.Version)
+	version := withoutEpoch(b.Package.Version)
+	prefix := fmt.Sprintf("%s-%s/", name, upstream)
+	format := "3.0 (quilt)"
+	if native {
+		format = "3.0 (native)"
+	}
+	debianFiles := append(b.debianSourceFiles(topLevel), sourceFile{name: "source/format", content: format + "\n", mode: 0644})
+
+	type tarball struct {
+		name    string
+		prefix  string
+		payload []stagedFile
+		debian  []sourceFile
+	}
+	tarballs := []tarball{
+		{fmt.Sprintf("%s_%s.orig.tar.gz", name, upstream), prefix, payload, nil},
+		{fmt.Sprintf("%s_%s.debian.tar.gz", name, version), "", nil, debianFiles},
+	}
+	if native {
+		tarballs = []tarball{{fmt.Sprintf("%s_%s.tar.gz", name, version), prefix, payload, debianFiles}}
+	}
+
+	var written []string
+	cleanup := func() {
+		for _, path := range written {
+			os.Remove(path)
+		}
+	}
+	var checksums []sourceChecksum
+	for _, tarball := range tarballs {
+		path := filepath.Join(b.OutputDir, tarball.name)
+		b.log("Writing %s", path)
+		written = append(written, path)
+		if err := writeSourceTarball(ctx, path, tarball.prefix, tarball.payload, tarball.debian); err != nil {
+			cleanup()
+			return err
+		}
+		sum, err := checksumSourceFile(path)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		checksums = append(checksums, sum)
+	}
+
+	b.log("Writing %s", outputPath)
+	if err := os.WriteFile(outputPath, []byte(b.dscFile(format, checksums)), 0644); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(outputPath), err)
+	}
+	return nil
+}
+
+// sourceSection returns the section and priority of the source package
+func (b *Builder) sourceSection() (section, priority string) {
+	section, priority = b.Package.Section, b.Package.Priority
+	if section == "" {
+		section = "misc"
+	}
+	if priority == "" {
+		priority = "optional"
+	}
+	return section, priority
+}
+
+// debianSourceFiles returns the debian/ directory of the source package:
+// control, rules, changelog and the maintainer scripts of the binary package
+func (b *Builder) debianSourceFiles(topLevel []string) []sourceFile {
+	pkg := b.Package
+	section, priority := b.sourceSection()
+
+	var control strings.Builder
+	fmt.Fprintf(&control, "Source: %s\n", pkg.Name)
+	fmt.Fprintf(&control, "Section: %s\n", section)
+	fmt.Fprintf(&control, "Priority: %s\n", priority)
+	fmt.Fprintf(&control, "Maintainer: %s\n", pkg.Maintainer)
+	fmt.Fprintf(&control, "Build-Depends: debhelper-compat (= 13)\n")
+	fmt.Fprintf(&control, "Standards-Version: %s\n", sourceStandardsVersion)
+	fmt.Fprintf(&control, "Rules-Requires-Root: no\n\n")
+	fmt.Fprintf(&control, "Package: %s\n", pkg.Name)
+	fmt.Fprintf(&control, "Architecture: %s\n", pkg.Architecture)
+	depends := "${misc:Depends}, ${shlibs:Depends}"
+	if value := b.relationField(pkg.Depends); value != "" {
+		depends += ", " + value
+	}
+	fmt.Fprintf(&control, "Depends: %s\n", depends)
+	for _, field := range []struct {
+		name    string
+		entries []string
+	}{
+		{"Conflicts", b.Conflicts},
+		{"Provides", b.Provides},
+		{"Replaces", b.Replaces},
+	} {
+		if value := b.relationField(field.entries); value != "" {
+			fmt.Fprintf(&control, "%s: %s\n", field.name, value)
+		}
+	}
+	fmt.Fprintf(&control, "Description: %s\n", pkg.Description)
+
+	// Make needs dollar signs doubled in recipes
+	var copies []string
+	for _, name := range topLevel {
+		copies = append(copies, strings.ReplaceAll(doinstQuote(name), "$", "$$"))
+	}
+	var rules strings.Builder
+	rules.WriteString("#!/usr/bin/make -f\n")
+	rules.WriteString("# Generated by go-pkginstall: the payload is shipped relocated as it was staged\n\n")
+	rules.WriteString("%:\n\tdh $@\n\n")
+	rules.WriteString("override_dh_auto_configure override_dh_auto_build override_dh_auto_test override_dh_auto_clean:\n\n")
+	rules.WriteString("override_dh_auto_install:\n")
+	fmt.Fprintf(&rules, "\tmkdir -p debian/%s\n", pkg.Name)
+	if len(copies) > 0 {
+		fmt.Fprintf(&rules, "\tcp -a --no-preserve=ownership %s debian/%s/\n", strings.Join(copies, " "), pkg.Name)
+	}
+	rules.WriteString("\n# File modes were checked when the payload was staged\n")
+	rules.WriteString("override_dh_fixperms:\n\n")
+	rules.WriteString("# Prebuilt binaries may link against libraries shipped in the payload\n")
+	rules.WriteString("override_dh_shlibdeps:\n\tdh_shlibdeps -- --ignore-missing-info\n")
+
+	distribution := b.Distribution
+	if distribution == "" {
+		distribution = DefaultDistribution
+	}
+	changelog := fmt.Sprintf("%s (%s) %s; urgency=medium\n\n  * Relocated payload of %s %s, packaged by go-pkginstall.\n\n -- %s  %s\n",
+		pkg.Name, pkg.Version, distribution, pkg.Name, pkg.Version, pkg.Maintainer, time.Now().Format(time.RFC1123Z))
+
+	files := []sourceFile{
+		{name: "changelog", content: changelog, mode: 0644},
+		{name: "control", content: control.String(), mode: 0644},
+		{name: "rules", content: rules.String(), mode: 0755},
+	}
+	if triggers := b.triggersFile(); triggers != "" {
+		files = append(files, sourceFile{name: "triggers", content: triggers, mode: 0644})
+	}
+	var scripts []string
+	for name := range b.Scripts {
+		scripts = append(scripts, name)
+	}
+	sort.Strings(scripts)
+	for _, name := range scripts {
+		files = append(files, sourceFile{name: name, content: b.Scripts[name], mode: 0755})
+	}
+	return files
+}
+
+// writeSourceTarball writes a gzip-compressed tarball of the payload below
+// prefix, followed by the debian/ directory
+func writeSourceTarball(ctx context.Context, path, prefix string, payload []stagedFile, debianFiles []sourceFile) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	defer out.Close()
+
+	modTime := time.Now()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	header := func(name string, typeflag byte, mode int64) *tar.Header {
+		return &tar.Header{Typeflag: typeflag, Name: name, Mode: mode, ModTime: modTime, Uname: "root", Gname: "root", Format: tar.FormatGNU}
+	}
+
+	if prefix != "" {
+		if err := tw.WriteHeader(header(prefix, tar.TypeDir, 0755)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", prefix, err)
+		}
+	}
+	for _, file := range payload {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("package build cancelled: %w", err)
+		}
+		name := prefix + strings.TrimPrefix(file.Path, "/")
+		switch {
+		case file.Info.IsDir():
+			err = tw.WriteHeader(header(name+"/", tar.TypeDir, unixMode(file.Info.Mode())))
+		case file.Link != "":
+			h := header(name, tar.TypeSymlink, 0777)
+			h.Linkname = file.Link
+			err = tw.WriteHeader(h)
+		case file.Info.Mode().IsRegular():
+			err = addFile(ctx, tw, header(name, tar.TypeReg, unixMode(file.Info.Mode())), file)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.Path, err)
+		}
+	}
+
+	if len(debianFiles) > 0 {
+		dirs := map[string]bool{}
+		for _, file := range debianFiles {
+			for _, dir := range []string{"debian", filepath.Dir("debian/" + file.name)} {
+				if !dirs[dir] {
+					dirs[dir] = true
+					if err := tw.WriteHeader(header(prefix+dir+"/", tar.TypeDir, 0755)); err != nil {
+						return fmt.Errorf("failed to write %s: %w", dir, err)
+					}
+				}
+			}
+			h := header(prefix+"debian/"+file.name, tar.TypeReg, file.mode)
+			h.Size = int64(len(file.content))
+			if err := tw.WriteHeader(h); err != nil {
+				return fmt.Errorf("failed to write debian/%s: %w", file.name, err)
+			}
+			if _, err := io.WriteString(tw, file.content); err != nil {
+				return fmt.Errorf("failed to write debian/%s: %w", file.name, err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed archive: %w", err)
+	}
+	return out.Close()
+}
+
+// sourceChecksum holds the checksums of a file listed in a .dsc
+type sourceChecksum struct {
+	name              string
+	size              int64
+	md5, sha1, sha256 string
+}
+
+// checksumSourceFile computes the checksums listed in a .dsc
+func checksumSourceFile(path string) (sourceChecksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return sourceChecksum{}, fmt.Errorf("failed to checksum %s: %w", filepath.Base(path), err)
+	}
+	defer f.Close()
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), f)
+	if err != nil {
+		return sourceChecksum{}, fmt.Errorf("failed to checksum %s: %w", filepath.Base(path), err)
+	}
+	return sourceChecksum{
+		name:   filepath.Base(path),
+		size:   size,
+		md5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		sha1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+		sha256: hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// dscFile returns the unsigned .dsc describing the source package
+func (b *Builder) dscFile(format string, files []sourceChecksum) string {
+	pkg := b.Package
+	section, priority := b.sourceSection()
+
+	var dsc strings.Builder
+	fmt.Fprintf(&dsc, "Format: %s\n", format)
+	fmt.Fprintf(&dsc, "Source: %s\n", pkg.Name)
+	fmt.Fprintf(&dsc, "Binary: %s\n", pkg.Name)
+	fmt.Fprintf(&dsc, "Architecture: %s\n", pkg.Architecture)
+	fmt.Fprintf(&dsc, "Version: %s\n", pkg.Version)
+	fmt.Fprintf(&dsc, "Maintainer: %s\n", pkg.Maintainer)
+	fmt.Fprintf(&dsc, "Standards-Version: %s\n", sourceStandardsVersion)
+	fmt.Fprintf(&dsc, "Build-Depends: debhelper-compat (= 13)\n")
+	fmt.Fprintf(&dsc, "Package-List:\n %s deb %s %s arch=%s\n", pkg.Name, section, priority, pkg.Architecture)
+	dsc.WriteString("Checksums-Sha1:\n")
+	for _, file := range files {
+		fmt.Fprintf(&dsc, " %s %d %s\n", file.sha1, file.size, file.name)
+	}
+	dsc.WriteString("Checksums-Sha256:\n")
+	for _, file := range files {
+		fmt.Fprintf(&dsc, " %s %d %s\n", file.sha256, file.size, file.name)
+	}
+	dsc.WriteString("Files:\n")
+	for _, file := range files {
+		fmt.Fprintf(&dsc, " %s %d %s\n", file.md5, file.size, file.name)
+	}
+	return dsc.String()
+}
@@ -0,0 +1,132 @@
+package debian
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		upstream string
+		native   bool
+	}{
+		{"1.0", "1.0", true},
+		{"1.0-1", "1.0", false},
+		{"2:1.0-rc1-3", "1.0-rc1", false},
+		{"1:2.0", "2.0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			upstream, native := sourceVersion(tt.version)
+			if upstream != tt.upstream || native != tt.native {
+				t.Errorf("sourceVersion(%q) = %q, %v, want %q, %v", tt.version, upstream, native, tt.upstream, tt.native)
+			}
+		})
+	}
+}
+
+func TestSourcePackage(t *testing.T) {
+	tests := []struct {
+		version  string
+		tarballs []string
+		format   string
+	}{
+		{"1.0-2", []string{"app_1.0.orig.tar.gz", "app_1.0-2.debian.tar.gz"}, "3.0 (quilt)"},
+		{"1.0", []string{"app_1.0.tar.gz"}, "3.0 (native)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			srcDir, err := ioutil.TempDir("", "builder-src-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(srcDir)
+			outDir, err := ioutil.TempDir("", "builder-out-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(outDir)
+
+			if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "run.sh"), []byte("hello\n"), 0755); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+
+			pkg := NewPackage("app", tt.version, "all", "Test <test@example.com>", "Test app", "utils", "optional", []string{"libc6"})
+			builder, err := NewBuilder(pkg, srcDir, outDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			builder.SourcePackage = true
+			builder.Distribution = "jammy"
+			builder.DpkgRoot = srcDir
+
+			outputPath, _, err := builder.Build(context.Background())
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if filepath.Base(outputPath) != "app_"+tt.version+".dsc" {
+				t.Errorf("Build() = %s", outputPath)
+			}
+
+			dsc, err := ioutil.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to read .dsc: %v", err)
+			}
+			if !strings.Contains(string(dsc), "Format: "+tt.format+"\n") {
+				t.Errorf("Unexpected .dsc:\n%s", dsc)
+			}
+
+			var debianTarball []byte
+			for _, name := range tt.tarballs {
+				data, err := ioutil.ReadFile(filepath.Join(outDir, name))
+				if err != nil {
+					t.Fatalf("Missing %s: %v", name, err)
+				}
+				sum := sha256.Sum256(data)
+				if !strings.Contains(string(dsc), " "+hex.EncodeToString(sum[:])+" ") {
+					t.Errorf("%s checksum not listed in .dsc", name)
+				}
+				debianTarball = data
+			}
+
+			// The debian/ directory comes last, in the native tarball or the
+			// debian tarball
+			prefix := ""
+			if tt.format == "3.0 (native)" {
+				prefix = "app-1.0/"
+				if entries := readTarGz(t, debianTarball); entries[prefix+"opt/usr/share/app/run.sh"] == nil {
+					t.Errorf("Native tarball lacks the relocated payload")
+				}
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(debianTarball))
+			if err != nil {
+				t.Fatalf("Failed to open tarball: %v", err)
+			}
+			contents := readTarContents(t, gz)
+			if !strings.Contains(contents[prefix+"debian/changelog"], "app ("+tt.version+") jammy; urgency=medium") {
+				t.Errorf("Unexpected changelog:\n%s", contents[prefix+"debian/changelog"])
+			}
+			if !strings.Contains(contents[prefix+"debian/control"], "Depends: ${misc:Depends}, ${shlibs:Depends}, libc6\n") {
+				t.Errorf("Unexpected control:\n%s", contents[prefix+"debian/control"])
+			}
+			if !strings.Contains(contents[prefix+"debian/rules"], "cp -a --no-preserve=ownership opt debian/app/") {
+				t.Errorf("Unexpected rules:\n%s", contents[prefix+"debian/rules"])
+			}
+			if contents[prefix+"debian/source/format"] != tt.format+"\n" {
+				t.Errorf("Unexpected source format %q", contents[prefix+"debian/source/format"])
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SpecialFilePolicy decides how sockets, FIFOs and device nodes found in the
+// source tree are packaged
+type SpecialFilePolicy string
+
+const (
+	SpecialFilesSkip     SpecialFilePolicy = "skip"     // Leave them out with a warning
+	SpecialFilesFail     SpecialFilePolicy = "fail"     // Abort the build
+	SpecialFilesRecreate SpecialFilePolicy = "recreate" // Recreate FIFOs in postinst; skip everything else with a warning
+)
+
+// ParseSpecialFilePolicy converts "skip", "fail" or "recreate" to a policy.
+// An empty string selects skip.
+func ParseSpecialFilePolicy(policy string) (SpecialFilePolicy, error) {
+	switch p := SpecialFilePolicy(strings.ToLower(policy)); p {
+	case "":
+		return SpecialFilesSkip, nil
+	case SpecialFilesSkip, SpecialFilesFail, SpecialFilesRecreate:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown special file policy: %s (available: skip, fail, recreate)", policy)
+	}
+}
+
+// specialFileKind describes a special file, or returns "" for regular files,
+// directories and symlinks
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "FIFO"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	}
+	return ""
+}
+
+// fifoRequest is a FIFO recreated by the postinst script
+type fifoRequest struct {
+	path string
+	mode os.FileMode
+}
+
+// handleSpecialFile applies the special file policy to a path that cannot be
+// copied. It returns an error if the build must stop; otherwise the file is left
+// out of the payload.
+func (b *Builder) handleSpecialFile(srcPath, packagePath string, info os.FileInfo) error {
+	absPath := b.systemPath(srcPath)
+	kind := specialFileKind(info.Mode())
+	switch b.SpecialFiles {
+	case SpecialFilesFail:
+		return fmt.Errorf("cannot package %s %s (special file policy: fail)", kind, absPath)
+	case SpecialFilesRecreate:
+		if info.Mode()&os.ModeNamedPipe != 0 {
+			b.log("Recreating FIFO %s in postinst", packagePath)
+			b.fifos = append(b.fifos, fifoRequest{path: packagePath, mode: b.fileMode(srcPath, info)})
+			return nil
+		}
+	}
+	b.warn("Skipping %s %s", kind, absPath)
+	return nil
+}
+
+// fifoSnippet returns the postinst commands that recreate FIFOs
+func (b *Builder) fifoSnippet() string {
+	var snippet strings.Builder
+	for _, fifo := range b.fifos {
+		fmt.Fprintf(&snippet, "# Recreate FIFO %s\n", fifo.path)
+		fmt.Fprintf(&snippet, "mkdir -p $(dirname '%s')\n", fifo.path)
+		fmt.Fprintf(&snippet, "if [ ! -p '%s' ]; then\n", fifo.path)
+		fmt.Fprintf(&snippet, "    mkfifo -m %04o '%s'\n", fifo.mode.Perm(), fifo.path)
+		snippet.WriteString("fi\n\n")
+	}
+	return snippet.String()
+}
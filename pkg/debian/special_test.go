@@ -0,0 +1,116 @@
+package debian
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFileInfo describes a file without creating it
+type fakeFileInfo struct {
+	name string
+	mode os.FileMode
+}
+
+func (f fakeFileInfo) Name() string       { return f.name }
+func (f fakeFileInfo) Size() int64        { return 0 }
+func (f fakeFileInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (f fakeFileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeFileInfo) Sys() interface{}   { return nil }
+
+func TestParseSpecialFilePolicy(t *testing.T) {
+	for input, want := range map[string]SpecialFilePolicy{
+		"":         SpecialFilesSkip,
+		"skip":     SpecialFilesSkip,
+		"FAIL":     SpecialFilesFail,
+		"recreate": SpecialFilesRecreate,
+	} {
+		if got, err := ParseSpecialFilePolicy(input); err != nil || got != want {
+			t.Errorf("ParseSpecialFilePolicy(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseSpecialFilePolicy("copy"); err == nil {
+		t.Errorf("Expected unknown policy to be rejected")
+	}
+}
+
+func TestHandleSpecialFile(t *testing.T) {
+	fifo := fakeFileInfo{name: "pipe", mode: os.ModeNamedPipe | 0640}
+	socket := fakeFileInfo{name: "sock", mode: os.ModeSocket | 0755}
+	device := fakeFileInfo{name: "null", mode: os.ModeDevice | os.ModeCharDevice | 0666}
+
+	tests := []struct {
+		name      string
+		policy    SpecialFilePolicy
+		info      os.FileInfo
+		wantErr   bool
+		wantFIFOs int
+	}{
+		{"Skip FIFO", SpecialFilesSkip, fifo, false, 0},
+		{"Fail on device", SpecialFilesFail, device, true, 0},
+		{"Recreate FIFO", SpecialFilesRecreate, fifo, false, 1},
+		{"Recreate skips sockets", SpecialFilesRecreate, socket, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &Builder{SourceDir: "/src", SpecialFiles: tt.policy, Observer: &recordingObserver{}}
+			err := builder.handleSpecialFile("/src/var/run/"+tt.info.Name(), "/opt/var/run/"+tt.info.Name(), tt.info)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handleSpecialFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(builder.fifos) != tt.wantFIFOs {
+				t.Errorf("Expected %d FIFOs, got %d", tt.wantFIFOs, len(builder.fifos))
+			}
+		})
+	}
+
+	if kind := specialFileKind(device.Mode()); kind != "character device" {
+		t.Errorf("specialFileKind() = %q, want character device", kind)
+	}
+	if kind := specialFileKind(0644); kind != "" {
+		t.Errorf("Expected regular files not to be special, got %q", kind)
+	}
+}
+
+func TestCreatePostinstScript(t *testing.T) {
+	fifo := fifoRequest{path: "/opt/var/run/app.pipe", mode: 0644}
+
+	tests := []struct {
+		name     string
+		user     string
+		wantHead string
+		wantTail string
+	}{
+		{"Generated script", "", "#!/bin/sh\n\n# This script was generated by go-pkginstall", "fi\n\n"},
+		{"Appended to user script", "#!/bin/sh\necho hello\n", "#!/bin/sh\necho hello\n\n# Added by go-pkginstall\n", "fi\n\n"},
+		{"Inserted at token", "#!/bin/sh\n" + postinstToken + "\nexit 0\n", "#!/bin/sh\n# Recreate FIFO", "fi\nexit 0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), os.TempDir(), os.TempDir())
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			if tt.user != "" {
+				builder.Scripts["postinst"] = tt.user
+			}
+			builder.fifos = []fifoRequest{fifo}
+
+			if err := builder.createPostinstScript(); err != nil {
+				t.Fatalf("createPostinstScript() error = %v", err)
+			}
+			script := builder.Scripts["postinst"]
+			if !strings.HasPrefix(script, tt.wantHead) || !strings.HasSuffix(script, tt.wantTail) {
+				t.Errorf("Unexpected postinst:\n%s", script)
+			}
+			if !strings.Contains(script, "mkfifo -m 0644 '/opt/var/run/app.pipe'") {
+				t.Errorf("Expected mkfifo command in postinst:\n%s", script)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package debian
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/tmpfiles"
+)
+
+// SetStateDirs validates and sets the directories created at install time
+// through tmpfiles.d
+func (b *Builder) SetStateDirs(dirs []tmpfiles.StateDir) error {
+	seen := make(map[string]bool)
+	for _, d := range dirs {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		if seen[d.Path] {
+			return fmt.Errorf("state directory %s is declared twice", d.Path)
+		}
+		seen[d.Path] = true
+	}
+	b.StateDirs = dirs
+	return nil
+}
+
+// addTmpfiles queues the tmpfiles.d configuration creating b.StateDirs
+func (b *Builder) addTmpfiles() {
+	if len(b.StateDirs) == 0 {
+		return
+	}
+	confPath := tmpfiles.ConfigPath(b.Package.Name)
+	b.log("Generating tmpfiles.d configuration %s", confPath)
+	b.addGeneratedFile(confPath, tmpfiles.Config(b.Package.Name, b.StateDirs))
+}
+
+// isStateDir reports whether absPath is a declared state directory
+func (b *Builder) isStateDir(absPath string) bool {
+	for _, d := range b.StateDirs {
+		if d.Path == absPath {
+			return true
+		}
+	}
+	return false
+}
+
+// skipStateDir reports whether the source entry at absPath is an empty
+// declared state directory, which tmpfiles.d creates instead of the payload
+func (b *Builder) skipStateDir(srcPath, absPath string, info os.FileInfo) bool {
+	if !info.IsDir() || !b.isStateDir(absPath) {
+		return false
+	}
+	entries, err := ioutil.ReadDir(srcPath)
+	if err != nil || len(entries) > 0 {
+		b.warn("State directory %s is not empty; its contents are packaged as usual", absPath)
+		return false
+	}
+	b.log("Not packaging %s: the state directory is created at install time", absPath)
+	return true
+}
+
+// stateDirSnippet returns the postinst commands creating the state
+// directories. systemd-tmpfiles reads the packaged configuration; without
+// it the directories are created with mkdir, chown and chmod.
+func (b *Builder) stateDirSnippet() string {
+	if len(b.StateDirs) == 0 {
+		return ""
+	}
+	confPath := tmpfiles.ConfigPath(b.Package.Name)
+	conf, _, err := b.PathMapper.TransformPath(confPath)
+	if err != nil {
+		conf = confPath
+	}
+
+	var snippet strings.Builder
+	fmt.Fprintf(&snippet, "# Create the state directories of %s\n", b.Package.Name)
+	snippet.WriteString("if command -v systemd-tmpfiles >/dev/null 2>&1; then\n")
+	fmt.Fprintf(&snippet, "    systemd-tmpfiles --create '%s' || true\n", conf)
+	snippet.WriteString("else\n")
+	for _, d := range b.StateDirs {
+		d = d.WithDefaults()
+		fmt.Fprintf(&snippet, "    mkdir -p '%s'\n", d.Path)
+		fmt.Fprintf(&snippet, "    chown '%s:%s' '%s'\n", d.User, d.Group, d.Path)
+		fmt.Fprintf(&snippet, "    chmod %s '%s'\n", d.Mode, d.Path)
+	}
+	snippet.WriteString("fi\n\n")
+	return snippet.String()
+}
@@ -0,0 +1,67 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/tmpfiles"
+)
+
+func TestStateDirs(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	for _, dir := range []string{"var/lib/app", "var/log/app", "usr/bin"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "bin", "app"), []byte("app"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+	if err := builder.SetStateDirs([]tmpfiles.StateDir{{Path: "/var/lib/app", Mode: "0750", User: "app", Group: "app"}, {Path: "/var/lib/app"}}); err == nil {
+		t.Errorf("Expected a duplicate state directory to be rejected")
+	}
+	if err := builder.SetStateDirs([]tmpfiles.StateDir{{Path: "/var/lib/app", Mode: "0750", User: "app", Group: "app"}}); err != nil {
+		t.Fatalf("SetStateDirs() error = %v", err)
+	}
+	builder.addTmpfiles()
+	if err := builder.copyFiles(context.Background()); err != nil {
+		t.Fatalf("copyFiles() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(builder.BuildDir, "opt", "var", "lib", "app")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty state directory to be left out, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(builder.BuildDir, "opt", "var", "log", "app")); err != nil {
+		t.Errorf("Expected undeclared directories to be packaged: %v", err)
+	}
+	conf, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, "/opt/usr/lib/tmpfiles.d/app.conf"))
+	if err != nil || !strings.Contains(string(conf), "d /var/lib/app 0750 app app -") {
+		t.Errorf("Expected a tmpfiles.d configuration, got %q, %v", conf, err)
+	}
+
+	snippet := builder.stateDirSnippet()
+	for _, want := range []string{
+		"systemd-tmpfiles --create '/opt/usr/lib/tmpfiles.d/app.conf'",
+		"mkdir -p '/var/lib/app'",
+		"chown 'app:app' '/var/lib/app'",
+		"chmod 0750 '/var/lib/app'",
+	} {
+		if !strings.Contains(snippet, want) {
+			t.Errorf("stateDirSnippet() is missing %q:\n%s", want, snippet)
+		}
+	}
+}
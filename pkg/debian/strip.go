@@ -0,0 +1,253 @@
+package debian
+
+import (
+	"context"
+	"crypto/md5"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+)
+
+// StripOptions selects the ELF files whose symbols are stripped while they are
+// packaged
+type StripOptions struct {
+	Executables  bool     `json:"executables,omitempty"`   // Strip ELF executables
+	Libraries    bool     `json:"libraries,omitempty"`     // Strip shared libraries
+	DebugPackage bool     `json:"debug_package,omitempty"` // Keep the stripped debug info in a <name>-dbgsym package
+	Exclude      []string `json:"exclude,omitempty"`       // Globs of system paths that are never stripped, in exclude pattern syntax
+}
+
+// enabled reports whether any files are stripped
+func (s StripOptions) enabled() bool {
+	return s.Executables || s.Libraries
+}
+
+// SetStrip enables stripping and checks that the required tools are installed
+func (b *Builder) SetStrip(opts StripOptions) error {
+	if opts.DebugPackage && !opts.enabled() {
+		return fmt.Errorf("a debug symbol package requires stripping executables or shared libraries")
+	}
+	matcher, err := pattern.NewMatcher(opts.Exclude, nil)
+	if err != nil {
+		return fmt.Errorf("invalid strip exclusion: %w", err)
+	}
+	if opts.enabled() {
+		tools := []string{"strip"}
+		if opts.DebugPackage {
+			tools = append(tools, "objcopy")
+		}
+		for _, tool := range tools {
+			if _, err := exec.LookPath(tool); err != nil {
+				return fmt.Errorf("stripping requires %s (binutils): %w", tool, err)
+			}
+		}
+	}
+	b.Strip = opts
+	b.stripExclude = matcher
+	return nil
+}
+
+// elfKind classifies a file for stripping
+type elfKind int
+
+const (
+	elfNone       elfKind = iota // Not ELF, not strippable, or already stripped
+	elfExecutable                // Executables, including position-independent ones
+	elfLibrary                   // Shared libraries
+)
+
+// detectELF reports whether path is an ELF executable or shared library that
+// still has symbols to strip
+func detectELF(path string) elfKind {
+	f, err := elf.Open(path)
+	if err != nil {
+		return elfNone
+	}
+	defer f.Close()
+
+	if f.Section(".symtab") == nil && f.Section(".debug_info") == nil {
+		return elfNone
+	}
+	switch f.Type {
+	case elf.ET_EXEC:
+		return elfExecutable
+	case elf.ET_DYN:
+		// PIE executables are shared objects with an interpreter
+		for _, prog := range f.Progs {
+			if prog.Type == elf.PT_INTERP {
+				return elfExecutable
+			}
+		}
+		return elfLibrary
+	}
+	return elfNone
+}
+
+// stripFile writes a stripped copy of srcPath to a temporary file and returns
+// its path, or srcPath itself if the file is not stripped. cleanup removes the
+// temporary file. With DebugPackage the debug info is kept next to the file's
+// packaged location as .debug/<name>.debug, where debuggers look for it.
+func (b *Builder) stripFile(ctx context.Context, srcPath, packagePath string) (string, func(), error) {
+	none := func() {}
+	if !b.Strip.enabled() {
+		return srcPath, none, nil
+	}
+	if b.stripExclude != nil && b.stripExclude.Excluded(strings.TrimPrefix(filepath.ToSlash(b.systemPath(srcPath)), "/"), false) {
+		b.log("Not stripping excluded file %s", packagePath)
+		return srcPath, none, nil
+	}
+
+	// Same sections as dh_strip removes
+	args := []string{"--remove-section=.comment", "--remove-section=.note"}
+	switch detectELF(srcPath) {
+	case elfExecutable:
+		if !b.Strip.Executables {
+			return srcPath, none, nil
+		}
+	case elfLibrary:
+		if !b.Strip.Libraries {
+			return srcPath, none, nil
+		}
+		args = append(args, "--strip-unneeded")
+	default:
+		return srcPath, none, nil
+	}
+
+	tmp, err := os.CreateTemp(b.WorkDir, "pkginstall-strip-*")
+	if err != nil {
+		return "", none, fmt.Errorf("failed to create temporary file for stripping: %w", err)
+	}
+	tmp.Close()
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	var debugPath string
+	if b.Strip.DebugPackage {
+		debugPackagePath := filepath.Join(filepath.Dir(packagePath), ".debug", filepath.Base(packagePath)+".debug")
+		debugPath = filepath.Join(b.debugDir, debugPackagePath)
+		if err := os.MkdirAll(filepath.Dir(debugPath), 0755); err != nil {
+			cleanup()
+			return "", none, fmt.Errorf("failed to create debug symbol directory: %w", err)
+		}
+		if err := runTool(ctx, "objcopy", "--only-keep-debug", srcPath, debugPath); err != nil {
+			cleanup()
+			return "", none, err
+		}
+		if err := os.Chmod(debugPath, 0644); err != nil {
+			cleanup()
+			return "", none, fmt.Errorf("failed to set permissions on %s: %w", debugPath, err)
+		}
+		b.debugMu.Lock()
+		b.debugFiles = append(b.debugFiles, debugPackagePath)
+		b.debugMu.Unlock()
+	}
+
+	if err := runTool(ctx, "strip", append(args, "-o", tmp.Name(), srcPath)...); err != nil {
+		cleanup()
+		return "", none, err
+	}
+	if debugPath != "" {
+		if err := runTool(ctx, "objcopy", "--add-gnu-debuglink="+debugPath, tmp.Name()); err != nil {
+			cleanup()
+			return "", none, err
+		}
+	}
+	b.log("Stripped %s", packagePath)
+	return tmp.Name(), cleanup, nil
+}
+
+// runTool runs a binutils command, including its output in errors
+func runTool(ctx context.Context, name string, args ...string) error {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// buildDebugPackage builds <name>-dbgsym from the debug info split off while
+// stripping and returns its path, or "" if no debug info was kept
+func (b *Builder) buildDebugPackage(ctx context.Context) (string, error) {
+	if len(b.debugFiles) == 0 {
+		b.log("No debug symbols were split off, skipping the debug symbol package")
+		return "", nil
+	}
+	sort.Strings(b.debugFiles)
+
+	installedSize, err := stagedInstalledSize(b.debugDir)
+	if err != nil {
+		return "", err
+	}
+	var md5sums strings.Builder
+	for _, debugFile := range b.debugFiles {
+		sum, err := hashFile(filepath.Join(b.debugDir, debugFile))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&md5sums, "%s  %s\n", sum, strings.TrimPrefix(debugFile, "/"))
+	}
+
+	name := b.Package.Name + "-dbgsym"
+	control := strings.Join([]string{
+		fmt.Sprintf("Package: %s", name),
+		fmt.Sprintf("Version: %s", b.Package.Version),
+		fmt.Sprintf("Architecture: %s", b.Package.Architecture),
+		fmt.Sprintf("Maintainer: %s", b.Package.Maintainer),
+		fmt.Sprintf("Description: debug symbols for %s", b.Package.Name),
+		"Section: debug",
+		"Priority: optional",
+		fmt.Sprintf("Depends: %s", Relation{Name: b.Package.Name, Operator: "=", Version: b.Package.Version}),
+		"Auto-Built-Package: debug-symbols",
+		fmt.Sprintf("Installed-Size: %d", installedSize),
+	}, "\n") + "\n"
+
+	// The staging directory is the package root
+	if err := os.Chmod(b.debugDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to set permissions on %s: %w", b.debugDir, err)
+	}
+	debianDir := filepath.Join(b.debugDir, "DEBIAN")
+	if err := os.MkdirAll(debianDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create DEBIAN directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(debianDir, "control"), []byte(control), 0644); err != nil {
+		return "", fmt.Errorf("failed to write control file: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(debianDir, "md5sums"), []byte(md5sums.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write md5sums file: %w", err)
+	}
+
+	outputPath := filepath.Join(b.OutputDir, fmt.Sprintf("%s_%s_%s.deb", name, b.Package.Version, b.Package.Architecture))
+	b.log("Writing debug symbols to %s", outputPath)
+	cmd := exec.CommandContext(ctx, "dpkg-deb", "--build", "--root-owner-group", b.debugDir, outputPath)
+	cmd.Stdout = logging.Writer(b.logOutput(), slog.LevelInfo)
+	cmd.Stderr = logging.Writer(b.logOutput(), slog.LevelWarn)
+	if err := cmd.Run(); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to build debug symbol package: %w", err)
+	}
+	return outputPath, nil
+}
+
+// hashFile returns the MD5 checksum of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
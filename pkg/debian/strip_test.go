@@ -0,0 +1,139 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// buildELFTree compiles an executable and a shared library with debug info
+// into srcDir/usr/bin and srcDir/usr/lib, skipping the test without a compiler
+func buildELFTree(t *testing.T, srcDir string) {
+	for _, tool := range []string{"cc", "strip", "objcopy"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available: %v", tool, err)
+		}
+	}
+
+	for _, dir := range []string{"usr/bin", "usr/lib"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	source := filepath.Join(srcDir, "main.c")
+	if err := ioutil.WriteFile(source, []byte("int answer(void) { return 42; }\nint main(void) { return answer() - 42; }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	defer os.Remove(source)
+
+	for _, args := range [][]string{
+		{"-g", "-o", filepath.Join(srcDir, "usr/bin/tool"), source},
+		{"-g", "-shared", "-fPIC", "-o", filepath.Join(srcDir, "usr/lib/libtool.so"), source},
+	} {
+		if output, err := exec.Command("cc", args...).CombinedOutput(); err != nil {
+			t.Skipf("cc failed: %v: %s", err, output)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr/bin/script"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+}
+
+func TestDetectELF(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "strip-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	buildELFTree(t, srcDir)
+
+	tests := map[string]elfKind{
+		"usr/bin/tool":       elfExecutable,
+		"usr/lib/libtool.so": elfLibrary,
+		"usr/bin/script":     elfNone,
+	}
+	for path, want := range tests {
+		if got := detectELF(filepath.Join(srcDir, path)); got != want {
+			t.Errorf("detectELF(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestStripFiles(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "strip-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	buildELFTree(t, srcDir)
+
+	for _, streaming := range []bool{false, true} {
+		name := "copy"
+		if streaming {
+			name = "stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("tool", "1.0", "amd64", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			defer builder.Clean()
+			builder.Streaming = streaming
+			if err := builder.SetStrip(StripOptions{Executables: true, DebugPackage: true, Exclude: []string{"/usr/lib/*"}}); err != nil {
+				t.Fatalf("SetStrip() error = %v", err)
+			}
+			builder.debugDir, err = ioutil.TempDir("", "strip-debug-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(builder.debugDir)
+
+			// Stripped files are smaller than their source
+			sizes := make(map[string]int64)
+			if streaming {
+				var buf bytes.Buffer
+				if err := builder.streamData(context.Background(), &buf); err != nil {
+					t.Fatalf("streamData() error = %v", err)
+				}
+				for path, header := range readTarGz(t, buf.Bytes()) {
+					sizes[path[1:]] = header.Size
+				}
+			} else {
+				if err := builder.copyFiles(context.Background()); err != nil {
+					t.Fatalf("copyFiles() error = %v", err)
+				}
+				for _, path := range builder.PackagedFiles {
+					if info, err := os.Stat(filepath.Join(builder.BuildDir, path)); err == nil {
+						sizes[path] = info.Size()
+					}
+				}
+			}
+
+			for path, wantStripped := range map[string]bool{"/usr/bin/tool": true, "/usr/lib/libtool.so": false} {
+				info, err := os.Stat(filepath.Join(srcDir, path))
+				if err != nil {
+					t.Fatalf("Failed to stat source: %v", err)
+				}
+				if stripped := sizes["/opt"+path] < info.Size(); stripped != wantStripped {
+					t.Errorf("%s: stripped = %v, want %v (size %d, source %d)", path, stripped, wantStripped, sizes["/opt"+path], info.Size())
+				}
+			}
+
+			if len(builder.debugFiles) != 1 || builder.debugFiles[0] != "/opt/usr/bin/.debug/tool.debug" {
+				t.Fatalf("Unexpected debug files %v", builder.debugFiles)
+			}
+			if _, err := os.Stat(filepath.Join(builder.debugDir, builder.debugFiles[0])); err != nil {
+				t.Errorf("Expected debug info to be kept: %v", err)
+			}
+		})
+	}
+
+	builder := &Builder{}
+	if err := builder.SetStrip(StripOptions{DebugPackage: true}); err == nil {
+		t.Errorf("Expected a debug package without stripping to be rejected")
+	}
+}
@@ -0,0 +1,142 @@
+package debian
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+)
+
+// RelationSuggestion is a Conflicts, Replaces or Provides entry worth adding
+// because another package ships commands of the same name, such as the
+// distribution's nginx when packaging a custom build of nginx
+type RelationSuggestion struct {
+	Field     string `json:"field"`     // Conflicts, Replaces or Provides
+	Package   string `json:"package"`   // Package the relation names
+	Reason    string `json:"reason"`    // Why the relation is suggested
+	Installed bool   `json:"installed"` // Whether the package is installed on the build host
+}
+
+// String returns the suggestion as a control field entry with its reason
+func (s RelationSuggestion) String() string {
+	return fmt.Sprintf("%s: %s (%s)", s.Field, s.Package, s.Reason)
+}
+
+// shippedCommands collects what another package ships of the commands of
+// the package being built
+type shippedCommands struct {
+	commands  []string // Command names both packages ship
+	samePaths []string // Packaged files the other package ships at the same path
+	installed bool
+}
+
+// suggestRelations compares the commands the package puts on the PATH with
+// the commands of the packages in db and suggests relations with those that
+// ship the same ones. Relations that are already declared are not suggested.
+func (b *Builder) suggestRelations(db *dpkgdb.Database) {
+	b.RelationSuggestions = nil
+	if b.AptContents {
+		if n, err := db.LoadContents(); err != nil {
+			b.warn("Could not read the apt Contents indices: %v", err)
+		} else if n == 0 {
+			b.warn("No apt Contents indices found; run apt-file update to check packages that are not installed")
+		}
+	}
+
+	// Commands are packaged files in a PATH directory or symlinks that
+	// postinst creates in one
+	packaged := make(map[string]bool)
+	commands := make(map[string]bool)
+	for _, file := range b.PackagedFiles {
+		if dpkgdb.IsCommandPath(file) {
+			packaged[path.Clean(file)] = true
+			commands[path.Base(file)] = true
+		}
+	}
+	for _, request := range b.SymlinkProcessor.GetQueuedSymlinks() {
+		if dpkgdb.IsCommandPath(request.Target) {
+			commands[path.Base(request.Target)] = true
+		}
+	}
+
+	others := make(map[string]*shippedCommands)
+	for command := range commands {
+		for _, shipper := range db.Shippers(command) {
+			if shipper.Package == b.Package.Name {
+				continue
+			}
+			other := others[shipper.Package]
+			if other == nil {
+				other = &shippedCommands{}
+				others[shipper.Package] = other
+			}
+			if !contains(other.commands, command) {
+				other.commands = append(other.commands, command)
+			}
+			if packaged[shipper.Path] && !contains(other.samePaths, shipper.Path) {
+				other.samePaths = append(other.samePaths, shipper.Path)
+			}
+			other.installed = other.installed || shipper.Installed
+		}
+	}
+
+	names := make([]string, 0, len(others))
+	for name := range others {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	declared := map[string][]string{
+		"Conflicts": relationNames(b.Conflicts),
+		"Replaces":  relationNames(b.Replaces),
+		"Provides":  relationNames(b.Provides),
+	}
+	suggest := func(field, name, reason string, installed bool) {
+		if contains(declared[field], name) {
+			return
+		}
+		b.RelationSuggestions = append(b.RelationSuggestions, RelationSuggestion{Field: field, Package: name, Reason: reason, Installed: installed})
+	}
+	for _, name := range names {
+		other := others[name]
+		sort.Strings(other.commands)
+		sort.Strings(other.samePaths)
+		if db.Essential(name) {
+			b.log("Not suggesting relations with essential package %s, which also ships %s", name, strings.Join(other.commands, ", "))
+			continue
+		}
+		suggest("Conflicts", name, "both ship "+strings.Join(other.commands, ", "), other.installed)
+		if len(other.samePaths) > 0 {
+			suggest("Replaces", name, "both install "+strings.Join(other.samePaths, ", "), other.installed)
+		}
+		if contains(other.commands, name) || strings.HasPrefix(b.Package.Name, name+"-") || strings.HasSuffix(b.Package.Name, "-"+name) {
+			suggest("Provides", name, "the package is a drop-in replacement for "+name, other.installed)
+		}
+	}
+
+	for _, suggestion := range b.RelationSuggestions {
+		logging.Logf(b.logOutput(), slog.LevelInfo, "Suggested relation: %s", suggestion)
+	}
+}
+
+// relationNames returns the package names of relationship entries; entries
+// that do not parse are skipped, as validation reports them
+func relationNames(entries []string) []string {
+	var names []string
+	for _, entry := range entries {
+		relationships, err := ParseRelationshipList([]string{entry})
+		if err != nil {
+			continue
+		}
+		for _, alternatives := range relationships {
+			for _, r := range alternatives {
+				names = append(names, r.Name)
+			}
+		}
+	}
+	return names
+}
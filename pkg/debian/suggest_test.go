@@ -0,0 +1,95 @@
+package debian
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/symlink"
+)
+
+func TestSuggestRelations(t *testing.T) {
+	root, err := ioutil.TempDir("", "suggest-root-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		dpkgdb.DefaultInfoDir + "/nginx.list":     "/usr/sbin/nginx\n",
+		dpkgdb.DefaultInfoDir + "/coreutils.list": "/usr/bin/ls\n",
+		dpkgdb.DefaultStatusFile:                  "Package: coreutils\nEssential: yes\n",
+		dpkgdb.DefaultListsDir + "/example_dists_stable_main_Contents-amd64": "usr/sbin/nginx httpd/nginx-light\n" +
+			"usr/bin/nginx-tool httpd/nginx-extras\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		aptContents bool
+		conflicts   []string
+		want        []string
+	}{
+		{
+			name: "Installed packages",
+			want: []string{
+				"Conflicts: nginx (both ship nginx)",
+				"Replaces: nginx (both install /usr/sbin/nginx)",
+				"Provides: nginx (the package is a drop-in replacement for nginx)",
+			},
+		},
+		{
+			name:        "Packages available from apt",
+			aptContents: true,
+			conflicts:   []string{"nginx (<< 2.0)"},
+			want: []string{
+				"Replaces: nginx (both install /usr/sbin/nginx)",
+				"Provides: nginx (the package is a drop-in replacement for nginx)",
+				"Conflicts: nginx-extras (both ship nginx-tool)",
+				"Conflicts: nginx-light (both ship nginx)",
+				"Replaces: nginx-light (both install /usr/sbin/nginx)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder, err := NewBuilder(NewPackage("nginx-custom", "1.0", "amd64", "Test <test@example.com>", "d", "httpd", "optional", nil), root, root)
+			if err != nil {
+				t.Fatalf("NewBuilder() error = %v", err)
+			}
+			// ls is shipped by an essential package, which nothing may conflict with
+			builder.PackagedFiles = []string{"/usr/sbin/nginx", "/opt/nginx-custom/bin/nginx-tool", "/usr/bin/ls", "/etc/nginx/nginx.conf"}
+			if err := builder.SymlinkProcessor.QueueSymlink(symlink.SymlinkRequest{Source: "/opt/nginx-custom/bin/nginx-tool", Target: "/usr/local/bin/nginx-tool"}); err != nil {
+				t.Fatalf("QueueSymlink() error = %v", err)
+			}
+			builder.AptContents = tt.aptContents
+			builder.SetConflicts(tt.conflicts)
+
+			db, err := dpkgdb.Open(root)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			builder.suggestRelations(db)
+
+			var got []string
+			for _, suggestion := range builder.RelationSuggestions {
+				got = append(got, suggestion.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RelationSuggestions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package debian
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/telemetry"
+)
+
+// phasePrepare labels failures that happen before the copy phase, such as
+// invalid metadata or a held build lock
+const phasePrepare BuildPhase = "prepare"
+
+// BuildMetrics are the Prometheus metrics of the builds recorded in a registry
+type BuildMetrics struct {
+	builds       *telemetry.Counter
+	failures     *telemetry.Counter
+	duration     *telemetry.Histogram
+	phases       *telemetry.Histogram
+	files        *telemetry.Counter
+	bytes        *telemetry.Counter
+	symlinks     *telemetry.Counter
+	warnings     *telemetry.Counter
+	pathFindings *telemetry.Counter
+}
+
+// NewBuildMetrics registers the build metrics in registry
+func NewBuildMetrics(registry *telemetry.Registry) *BuildMetrics {
+	return &BuildMetrics{
+		builds:       registry.Counter("pkginstall_builds_total", "Package builds by result.", "result"),
+		failures:     registry.Counter("pkginstall_build_failures_total", "Failed package builds by the phase that failed.", "phase"),
+		duration:     registry.Histogram("pkginstall_build_duration_seconds", "Duration of package builds.", telemetry.DefaultBuckets),
+		phases:       registry.Histogram("pkginstall_build_phase_duration_seconds", "Duration of the phases of package builds.", telemetry.DefaultBuckets, "phase"),
+		files:        registry.Counter("pkginstall_packaged_files_total", "Files, symlinks and hard links packaged."),
+		bytes:        registry.Counter("pkginstall_packaged_bytes_total", "Bytes of packaged files."),
+		symlinks:     registry.Counter("pkginstall_symlinks_queued_total", "Symlinks queued for creation at install time."),
+		warnings:     registry.Counter("pkginstall_build_warnings_total", "Warnings reported by package builds."),
+		pathFindings: registry.Counter("pkginstall_path_findings_total", "Path violations reported but not enforced by the security profile."),
+	}
+}
+
+// Observer returns an observer recording one build in the metrics
+func (m *BuildMetrics) Observer() BuildObserver {
+	now := time.Now()
+	return &metricsObserver{metrics: m, phase: phasePrepare, start: now, phaseStart: now}
+}
+
+// metricsObserver records the events of one build in BuildMetrics
+type metricsObserver struct {
+	metrics    *BuildMetrics
+	phase      BuildPhase
+	start      time.Time
+	phaseStart time.Time
+}
+
+// endPhase records the duration of the current phase
+func (o *metricsObserver) endPhase() {
+	now := time.Now()
+	if o.phase != phasePrepare {
+		o.metrics.phases.Observe(now.Sub(o.phaseStart).Seconds(), string(o.phase))
+	}
+	o.phaseStart = now
+}
+
+// OnPhaseStart implements BuildObserver
+func (o *metricsObserver) OnPhaseStart(phase BuildPhase) {
+	o.endPhase()
+	o.phase = phase
+}
+
+// OnFileCopied implements BuildObserver
+func (o *metricsObserver) OnFileCopied(packagePath string, size int64, done, total int) {}
+
+// OnWarning implements BuildObserver
+func (o *metricsObserver) OnWarning(message string) {}
+
+// OnReport implements ReportObserver
+func (o *metricsObserver) OnReport(report *BuildReport) {
+	o.metrics.files.Add(float64(report.Files))
+	o.metrics.bytes.Add(float64(report.PayloadSize))
+	o.metrics.symlinks.Add(float64(report.SymlinksQueued))
+	o.metrics.warnings.Add(float64(len(report.Warnings)))
+	o.metrics.pathFindings.Add(float64(len(report.PathFindings)))
+}
+
+// OnComplete implements BuildObserver
+func (o *metricsObserver) OnComplete(outputPath string, err error) {
+	o.endPhase()
+	o.metrics.duration.Observe(time.Since(o.start).Seconds())
+	if err != nil {
+		o.metrics.builds.Inc("failed")
+		o.metrics.failures.Inc(string(o.phase))
+		return
+	}
+	o.metrics.builds.Inc("succeeded")
+}
+
+// NewTracingObserver returns an observer recording a build as a "build" span
+// with one child span per phase. The build span is a child of the span in
+// ctx, if any. Spans are exported when the tracer is flushed.
+func NewTracingObserver(ctx context.Context, tracer *telemetry.Tracer) BuildObserver {
+	ctx, span := tracer.Start(ctx, "build")
+	return &tracingObserver{ctx: ctx, tracer: tracer, build: span}
+}
+
+// tracingObserver records the phases of one build as spans
+type tracingObserver struct {
+	ctx    context.Context
+	tracer *telemetry.Tracer
+	build  *telemetry.Span
+	phase  *telemetry.Span
+}
+
+// OnPhaseStart implements BuildObserver
+func (o *tracingObserver) OnPhaseStart(phase BuildPhase) {
+	o.phase.End()
+	_, o.phase = o.tracer.Start(o.ctx, "build."+string(phase))
+}
+
+// OnFileCopied implements BuildObserver
+func (o *tracingObserver) OnFileCopied(packagePath string, size int64, done, total int) {}
+
+// OnWarning implements BuildObserver
+func (o *tracingObserver) OnWarning(message string) {}
+
+// OnReport implements ReportObserver
+func (o *tracingObserver) OnReport(report *BuildReport) {
+	o.build.SetAttribute("package.name", report.Package)
+	o.build.SetAttribute("package.version", report.Version)
+	o.build.SetAttribute("package.architecture", report.Architecture)
+	o.build.SetAttribute("package.files", report.Files)
+	o.build.SetAttribute("package.payload_size", report.PayloadSize)
+	o.build.SetAttribute("package.installed_size", report.InstalledSize)
+	o.build.SetAttribute("package.symlinks_queued", report.SymlinksQueued)
+	o.build.SetAttribute("build.warnings", len(report.Warnings))
+	o.build.SetAttribute("build.path_findings", len(report.PathFindings))
+	if report.SHA256 != "" {
+		o.build.SetAttribute("package.sha256", report.SHA256)
+	}
+}
+
+// OnComplete implements BuildObserver
+func (o *tracingObserver) OnComplete(outputPath string, err error) {
+	o.phase.SetError(err)
+	o.phase.End()
+	o.build.SetError(err)
+	o.build.End()
+}
@@ -0,0 +1,116 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/telemetry"
+)
+
+func TestBuildTelemetry(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	outDir, err := ioutil.TempDir("", "builder-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "share", "app"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "share", "app", "README"), []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var exported struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					Name         string `json:"name"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&exported)
+	}))
+	defer collector.Close()
+
+	registry := telemetry.NewRegistry()
+	metrics := NewBuildMetrics(registry)
+	tracer := telemetry.NewTracer("pkginstall", collector.URL, nil)
+
+	build := func(name string) error {
+		builder, err := NewBuilder(NewPackage(name, "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, outDir)
+		if err != nil {
+			t.Fatalf("NewBuilder() error = %v", err)
+		}
+		builder.Observer = MultiObserver{metrics.Observer(), NewTracingObserver(context.Background(), tracer)}
+		_, _, err = builder.Build(context.Background())
+		return err
+	}
+	if err := build("app"); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if err := build(""); err == nil {
+		t.Fatalf("Expected invalid package to fail")
+	}
+
+	var buf bytes.Buffer
+	if err := registry.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+	for _, want := range []string{
+		`pkginstall_builds_total{result="failed"} 1`,
+		`pkginstall_builds_total{result="succeeded"} 1`,
+		`pkginstall_build_failures_total{phase="prepare"} 1`,
+		`pkginstall_build_duration_seconds_count 2`,
+		`pkginstall_build_phase_duration_seconds_count{phase="archive"} 1`,
+		`pkginstall_packaged_files_total 1`,
+		`pkginstall_packaged_bytes_total 6`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Metrics do not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(exported.ResourceSpans) != 1 || len(exported.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected export %+v", exported)
+	}
+	spans := exported.ResourceSpans[0].ScopeSpans[0].Spans
+	ids := make(map[string]string)
+	var failed int
+	for _, span := range spans {
+		ids[span.SpanID] = span.Name
+		if span.Status.Code == 2 {
+			failed++
+		}
+	}
+	for _, span := range spans {
+		if strings.HasPrefix(span.Name, "build.") && ids[span.ParentSpanID] != "build" {
+			t.Errorf("Span %s is not a child of a build span", span.Name)
+		}
+	}
+	if ids := len(ids); ids < 4 || failed != 1 {
+		t.Errorf("Exported %d spans with %d failed, want phase spans and one failed build", ids, failed)
+	}
+}
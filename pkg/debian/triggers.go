@@ -0,0 +1,159 @@
+package debian
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// TriggerMode selects how desktop menus, icon caches and the MIME database are
+// refreshed when a package ships .desktop files, icons or MIME XML
+type TriggerMode string
+
+const (
+	TriggersPostinst TriggerMode = "postinst" // Run the update tools from postinst
+	TriggersDpkg     TriggerMode = "dpkg"     // Activate the file triggers of the tools' packages in DEBIAN/triggers
+	TriggersNone     TriggerMode = "none"     // Leave the caches alone
+)
+
+// ParseTriggerMode converts "postinst", "dpkg" or "none" to a mode. An empty
+// string selects postinst.
+func ParseTriggerMode(mode string) (TriggerMode, error) {
+	switch m := TriggerMode(strings.ToLower(mode)); m {
+	case "":
+		return TriggersPostinst, nil
+	case TriggersPostinst, TriggersDpkg, TriggersNone:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown trigger mode: %s (available: postinst, dpkg, none)", mode)
+	}
+}
+
+// desktopCache describes a cache that is rebuilt from files below prefix
+type desktopCache struct {
+	prefix  string // System directory holding the files, with a trailing slash
+	suffix  string // Suffix the files must have, or "" for any file
+	command string // Postinst command; %s is the cache directory
+	// dir returns the cache directory and the dpkg trigger name for a file at
+	// rel below base, the visible equivalent of prefix
+	dir func(base, rel string) (cacheDir, trigger string)
+}
+
+// desktopCaches are refreshed in this order
+var desktopCaches = []desktopCache{
+	{
+		prefix:  "/usr/share/applications/",
+		suffix:  ".desktop",
+		command: "update-desktop-database -q '%s'",
+		dir: func(base, rel string) (string, string) {
+			dir := strings.TrimSuffix(base, "/")
+			return dir, dir
+		},
+	},
+	{
+		prefix:  "/usr/share/icons/",
+		command: "gtk-update-icon-cache -q -t -f '%s'",
+		dir: func(base, rel string) (string, string) {
+			// Each theme directory has its own cache
+			theme := strings.SplitN(rel, "/", 2)
+			if len(theme) < 2 {
+				return "", ""
+			}
+			dir := base + theme[0]
+			return dir, dir
+		},
+	},
+	{
+		prefix:  "/usr/share/mime/packages/",
+		suffix:  ".xml",
+		command: "update-mime-database '%s'",
+		dir: func(base, rel string) (string, string) {
+			packages := strings.TrimSuffix(base, "/")
+			return path.Dir(packages), packages
+		},
+	},
+}
+
+// cacheUpdate is a cache directory to refresh at install time
+type cacheUpdate struct {
+	cache   int // Index into desktopCaches
+	dir     string
+	trigger string
+}
+
+// noteDesktopFile records the caches to refresh for a packaged file.
+// systemPath is the path before transformation and visiblePath where the file
+// appears on the installed system: its install-time symlink, or its packaged
+// path if it is not linked back.
+func (b *Builder) noteDesktopFile(systemPath, visiblePath string) {
+	if b.DesktopTriggers == TriggersNone {
+		return
+	}
+	for i, cache := range desktopCaches {
+		if !strings.HasPrefix(systemPath, cache.prefix) || !strings.HasSuffix(systemPath, cache.suffix) {
+			continue
+		}
+		rel := strings.TrimPrefix(systemPath, cache.prefix)
+		if !strings.HasSuffix(visiblePath, "/"+rel) {
+			b.log("Not refreshing caches for %s: packaged as %s", systemPath, visiblePath)
+			continue
+		}
+		dir, trigger := cache.dir(strings.TrimSuffix(visiblePath, rel), rel)
+		if dir == "" {
+			continue
+		}
+		update := cacheUpdate{cache: i, dir: dir, trigger: trigger}
+		for _, existing := range b.cacheUpdates {
+			if existing == update {
+				return
+			}
+		}
+		b.log("Refreshing %s at install time for %s", dir, visiblePath)
+		b.cacheUpdates = append(b.cacheUpdates, update)
+	}
+}
+
+// sortedCacheUpdates returns the recorded cache updates in a stable order
+func (b *Builder) sortedCacheUpdates() []cacheUpdate {
+	updates := append([]cacheUpdate(nil), b.cacheUpdates...)
+	sort.Slice(updates, func(i, j int) bool {
+		if updates[i].cache != updates[j].cache {
+			return updates[i].cache < updates[j].cache
+		}
+		return updates[i].dir < updates[j].dir
+	})
+	return updates
+}
+
+// cacheSnippet returns the postinst commands that refresh desktop caches. The
+// tools are optional, so missing ones are skipped and failures are ignored.
+func (b *Builder) cacheSnippet() string {
+	if b.DesktopTriggers != "" && b.DesktopTriggers != TriggersPostinst {
+		return ""
+	}
+	var snippet strings.Builder
+	for _, update := range b.sortedCacheUpdates() {
+		command := fmt.Sprintf(desktopCaches[update.cache].command, update.dir)
+		tool := strings.Fields(command)[0]
+		fmt.Fprintf(&snippet, "# Refresh %s\n", update.dir)
+		fmt.Fprintf(&snippet, "if command -v %s >/dev/null 2>&1; then\n", tool)
+		fmt.Fprintf(&snippet, "    %s || true\n", command)
+		snippet.WriteString("fi\n\n")
+	}
+	return snippet.String()
+}
+
+// triggersFile returns the content of DEBIAN/triggers, activating the file
+// triggers that desktop-file-utils, the icon themes and shared-mime-info
+// declare on their directories
+func (b *Builder) triggersFile() string {
+	if b.DesktopTriggers != TriggersDpkg {
+		return ""
+	}
+	var content strings.Builder
+	for _, update := range b.sortedCacheUpdates() {
+		fmt.Fprintf(&content, "activate-noawait %s\n", update.trigger)
+	}
+	return content.String()
+}
@@ -0,0 +1,101 @@
+package debian
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTriggerMode(t *testing.T) {
+	for input, want := range map[string]TriggerMode{
+		"":         TriggersPostinst,
+		"postinst": TriggersPostinst,
+		"DPKG":     TriggersDpkg,
+		"none":     TriggersNone,
+	} {
+		if got, err := ParseTriggerMode(input); err != nil || got != want {
+			t.Errorf("ParseTriggerMode(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseTriggerMode("always"); err == nil {
+		t.Errorf("Expected unknown mode to be rejected")
+	}
+}
+
+func TestNoteDesktopFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		systemPath  string
+		visiblePath string
+		want        string // "dir trigger", or "" for no update
+	}{
+		{"Linked desktop file", "/usr/share/applications/app.desktop", "/usr/share/applications/app.desktop",
+			"/usr/share/applications /usr/share/applications"},
+		{"Relocated desktop file", "/usr/share/applications/app.desktop", "/opt/usr/share/applications/app.desktop",
+			"/opt/usr/share/applications /opt/usr/share/applications"},
+		{"Other file in applications", "/usr/share/applications/README", "/usr/share/applications/README", ""},
+		{"Icon", "/usr/share/icons/hicolor/48x48/apps/app.png", "/usr/share/icons/hicolor/48x48/apps/app.png",
+			"/usr/share/icons/hicolor /usr/share/icons/hicolor"},
+		{"File directly in icons", "/usr/share/icons/app.png", "/usr/share/icons/app.png", ""},
+		{"MIME package", "/usr/share/mime/packages/app.xml", "/opt/app/share/mime/packages/app.xml",
+			"/opt/app/share/mime /opt/app/share/mime/packages"},
+		{"Renamed by a mapping rule", "/usr/share/applications/app.desktop", "/opt/app/menu/other.desktop", ""},
+		{"Unrelated file", "/usr/bin/app", "/opt/usr/bin/app", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &Builder{}
+			builder.noteDesktopFile(tt.systemPath, tt.visiblePath)
+			var got string
+			if len(builder.cacheUpdates) > 0 {
+				got = builder.cacheUpdates[0].dir + " " + builder.cacheUpdates[0].trigger
+			}
+			if got != tt.want || len(builder.cacheUpdates) > 1 {
+				t.Errorf("cacheUpdates = %+v, want %q", builder.cacheUpdates, tt.want)
+			}
+		})
+	}
+
+	builder := &Builder{DesktopTriggers: TriggersNone}
+	builder.noteDesktopFile("/usr/share/applications/app.desktop", "/usr/share/applications/app.desktop")
+	if len(builder.cacheUpdates) != 0 {
+		t.Errorf("Expected no cache updates with triggers disabled")
+	}
+}
+
+func TestCacheUpdates(t *testing.T) {
+	builder := &Builder{}
+	for _, path := range []string{
+		"/usr/share/mime/packages/app.xml",
+		"/usr/share/icons/hicolor/48x48/apps/app.png",
+		"/usr/share/icons/hicolor/64x64/apps/app.png",
+		"/usr/share/applications/app.desktop",
+	} {
+		builder.noteDesktopFile(path, path)
+	}
+
+	snippet := builder.cacheSnippet()
+	desktop := strings.Index(snippet, "update-desktop-database -q '/usr/share/applications' || true")
+	icons := strings.Index(snippet, "gtk-update-icon-cache -q -t -f '/usr/share/icons/hicolor' || true")
+	mime := strings.Index(snippet, "update-mime-database '/usr/share/mime' || true")
+	if desktop < 0 || icons < desktop || mime < icons {
+		t.Errorf("Unexpected postinst snippet:\n%s", snippet)
+	}
+	if strings.Count(snippet, "gtk-update-icon-cache -q") != 1 {
+		t.Errorf("Expected one icon cache update per theme:\n%s", snippet)
+	}
+	if builder.triggersFile() != "" {
+		t.Errorf("Expected no triggers file in postinst mode")
+	}
+
+	builder.DesktopTriggers = TriggersDpkg
+	want := "activate-noawait /usr/share/applications\n" +
+		"activate-noawait /usr/share/icons/hicolor\n" +
+		"activate-noawait /usr/share/mime/packages\n"
+	if got := builder.triggersFile(); got != want {
+		t.Errorf("triggersFile() = %q, want %q", got, want)
+	}
+	if builder.cacheSnippet() != "" {
+		t.Errorf("Expected no postinst snippet in dpkg mode")
+	}
+}
@@ -0,0 +1,484 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+// VerifyOptions selects what VerifyPackage checks beyond the consistency of
+// the archive itself
+type VerifyOptions struct {
+	Package *Package          // Expected name, version and architecture; nil skips the comparison
+	Files   []string          // Paths expected in the payload; other files are reported
+	Roots   []string          // Install paths the payload must stay under, such as /opt
+	Scripts map[string]string // Expected maintainer scripts; nil skips the comparison
+	Against *ChecksumManifest // Checksum manifest the package must match; nil skips the comparison
+
+	// IgnoreChecksumFile skips the <package>.sha256 file next to the
+	// package, which a rebuild has not rewritten yet
+	IgnoreChecksumFile bool
+}
+
+// VerifyResult is the outcome of VerifyPackage
+type VerifyResult struct {
+	Path     string
+	Control  map[string]string // Fields of the control file
+	Files    int               // Entries in the payload, excluding directories
+	Problems []string          // Everything that does not match; empty if the package is intact
+}
+
+// Err returns an error listing the problems found, or nil
+func (r *VerifyResult) Err() error {
+	if len(r.Problems) == 0 {
+		return nil
+	}
+	return ci.Errorf(ci.ClassValidation, "%s failed verification:\n- %s", r.Path, strings.Join(r.Problems, "\n- "))
+}
+
+// problem records a mismatch
+func (r *VerifyResult) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// requiredControlFields must be present in every binary package
+var requiredControlFields = []string{"Package", "Version", "Architecture", "Maintainer", "Description"}
+
+// VerifyPackage extracts the .deb at debPath and checks that its control file
+// parses, the md5sums match the payload, the payload stays within the
+// expected paths and the maintainer scripts are the expected ones. A
+// <package>.sha256 file next to it must match the archive, unless
+// IgnoreChecksumFile is set, and so must the checksum manifest in
+// opts.Against. Problems are listed in the result; an
+// error means the package could not be read.
+func VerifyPackage(ctx context.Context, debPath string, opts VerifyOptions) (*VerifyResult, error) {
+	f, err := os.Open(debPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer f.Close()
+
+	members, err := readArIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", debPath, err)
+	}
+	result := &VerifyResult{Path: debPath}
+	if len(members) == 0 || members[0].name != "debian-binary" {
+		result.problem("the first archive member is not debian-binary")
+	} else {
+		version := make([]byte, 4)
+		if _, err := members[0].ReadAt(version, 0); err != nil || string(version) != "2.0\n" {
+			result.problem("debian-binary does not contain format version 2.0")
+		}
+	}
+
+	control, err := openMember(ctx, debPath, members, "control.tar")
+	if err != nil {
+		return nil, err
+	}
+	md5sums, scripts, err := readVerifyControl(control, result)
+	if closeErr := control.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read control archive of %s: %w", debPath, err)
+	}
+	result.checkControl(opts.Package)
+	result.checkScripts(scripts, opts.Scripts)
+
+	data, err := openMember(ctx, debPath, members, "data.tar")
+	if err != nil {
+		return nil, err
+	}
+	sums, err := result.readPayload(data, opts)
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data archive of %s: %w", debPath, err)
+	}
+	result.checkMD5Sums(md5sums, sums)
+
+	if !opts.IgnoreChecksumFile {
+		if recorded, err := readChecksumFile(ChecksumPath(debPath), debPath); err == nil {
+			if sum, err := fileSHA256(debPath); err != nil {
+				return nil, err
+			} else if sum != recorded {
+				result.problem("the archive does not match its checksum in %s", ChecksumPath(debPath))
+			}
+		} else if !os.IsNotExist(err) {
+			result.problem("%v", err)
+		}
+	}
+	if opts.Against != nil {
+		manifest, err := NewChecksumManifest(ctx, debPath)
+		if err != nil {
+			return nil, err
+		}
+		result.Problems = append(result.Problems, opts.Against.Compare(manifest)...)
+	}
+	return result, nil
+}
+
+// readVerifyControl reads the control fields, md5sums and maintainer scripts
+// from a control archive. md5sums is nil if the archive has none.
+func readVerifyControl(r io.Reader, result *VerifyResult) (md5sums map[string]string, scripts map[string]string, err error) {
+	scripts = make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch name := path.Base(path.Clean(header.Name)); name {
+		case "control":
+			paragraph, err := ParseControlParagraph(string(content))
+			if err != nil {
+				result.problem("the control file does not parse: %v", err)
+			}
+			result.Control = paragraph.Map()
+		case "md5sums":
+			md5sums = make(map[string]string)
+			for i, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+				fields := strings.SplitN(line, "  ", 2)
+				if len(fields) != 2 || len(fields[0]) != 32 {
+					result.problem("md5sums line %d is malformed", i+1)
+					continue
+				}
+				md5sums[path.Clean("/"+fields[1])] = fields[0]
+			}
+		case "preinst", "postinst", "prerm", "postrm":
+			scripts[name] = string(content)
+		}
+	}
+	return md5sums, scripts, nil
+}
+
+// checkControl checks the control fields against Debian policy and the
+// expected package metadata
+func (r *VerifyResult) checkControl(expected *Package) {
+	if r.Control == nil {
+		r.problem("the control archive has no control file")
+		return
+	}
+	for _, field := range requiredControlFields {
+		if r.Control[field] == "" {
+			r.problem("the control file has no %s field", field)
+		}
+	}
+	validators := map[string]func(string) error{
+		"Package":      ValidatePackageName,
+		"Version":      ValidateVersion,
+		"Architecture": ValidateArchitecture,
+		"Depends":      func(v string) error { return validateRelations("Depends", []string{v}) },
+		"Conflicts":    func(v string) error { return validateRelations("Conflicts", []string{v}) },
+		"Provides":     func(v string) error { return validateRelations("Provides", []string{v}) },
+		"Replaces":     func(v string) error { return validateRelations("Replaces", []string{v}) },
+		"Installed-Size": func(v string) error {
+			if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+				return fmt.Errorf("invalid Installed-Size %q", v)
+			}
+			return nil
+		},
+	}
+	fields := make([]string, 0, len(validators))
+	for field := range validators {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if value := r.Control[field]; value != "" {
+			if err := validators[field](value); err != nil {
+				r.problem("control file: %v", err)
+			}
+		}
+	}
+
+	if expected == nil {
+		return
+	}
+	for _, field := range [][2]string{
+		{"Package", expected.Name},
+		{"Version", expected.Version},
+		{"Architecture", expected.Architecture},
+	} {
+		if got := r.Control[field[0]]; got != field[1] {
+			r.problem("the control file has %s %q instead of %q", field[0], got, field[1])
+		}
+	}
+}
+
+// checkScripts compares the maintainer scripts in the package with the
+// expected ones
+func (r *VerifyResult) checkScripts(scripts, expected map[string]string) {
+	if expected == nil {
+		return
+	}
+	names := make([]string, 0, len(scripts))
+	for name := range scripts {
+		names = append(names, name)
+	}
+	for name := range expected {
+		if _, ok := scripts[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, packaged := scripts[name]
+		want, ok := expected[name]
+		switch {
+		case !ok:
+			r.problem("unexpected %s script", name)
+		case !packaged:
+			r.problem("the %s script is missing", name)
+		case content != want:
+			r.problem("the %s script differs from the validated script", name)
+		}
+	}
+}
+
+// readPayload checks the paths of the data archive and returns the MD5
+// checksums of its files, including hard links
+func (r *VerifyResult) readPayload(data io.Reader, opts VerifyOptions) (map[string]string, error) {
+	var expected map[string]bool
+	if opts.Files != nil {
+		expected = make(map[string]bool, len(opts.Files))
+		for _, file := range opts.Files {
+			expected[path.Clean("/"+file)] = false
+		}
+	}
+
+	sums := make(map[string]string)
+	tr := tar.NewReader(data)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean("/" + header.Name)
+		if strings.Contains("/"+header.Name+"/", "/../") {
+			r.problem("%s escapes the package root", header.Name)
+			continue
+		}
+		if name == "/" {
+			continue
+		}
+		if header.Typeflag == tar.TypeDir {
+			if !underRoots(name, opts.Roots, true) {
+				r.problem("%s is outside the declared roots", name)
+			}
+			continue
+		}
+
+		r.Files++
+		if !underRoots(name, opts.Roots, false) {
+			r.problem("%s is outside the declared roots", name)
+		}
+		if expected != nil {
+			if _, ok := expected[name]; !ok {
+				r.problem("%s was not packaged from the source tree", name)
+			}
+			expected[name] = true
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			hash := md5.New()
+			if _, err := io.Copy(hash, tr); err != nil {
+				return nil, err
+			}
+			sums[name] = hex.EncodeToString(hash.Sum(nil))
+		case tar.TypeLink:
+			target, ok := sums[path.Clean("/"+header.Linkname)]
+			if !ok {
+				r.problem("hard link %s points to %s, which is not an earlier file", name, header.Linkname)
+				continue
+			}
+			sums[name] = target
+		}
+	}
+
+	var missing []string
+	for name, found := range expected {
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		r.problem("%s is missing from the payload", name)
+	}
+	return sums, nil
+}
+
+// underRoots reports whether name is inside one of roots. Directories leading
+// to a root are accepted too. Any path is accepted if there are no roots.
+func underRoots(name string, roots []string, dir bool) bool {
+	if len(roots) == 0 {
+		return true
+	}
+	for _, root := range roots {
+		root = path.Clean("/" + root)
+		if root == "/" || name == root || strings.HasPrefix(name, root+"/") {
+			return true
+		}
+		if dir && strings.HasPrefix(root, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMD5Sums compares the md5sums control file with the payload
+func (r *VerifyResult) checkMD5Sums(md5sums, sums map[string]string) {
+	if md5sums == nil {
+		r.problem("the control archive has no md5sums file")
+		return
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	for name := range md5sums {
+		if _, ok := sums[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		got, packaged := sums[name]
+		want, listed := md5sums[name]
+		switch {
+		case !listed:
+			r.problem("%s is not listed in md5sums", name)
+		case !packaged:
+			r.problem("%s is listed in md5sums but not in the payload", name)
+		case got != want:
+			r.problem("%s does not match its md5sum", name)
+		}
+	}
+}
+
+// arMember is a member of an ar archive
+type arMember struct {
+	*io.SectionReader
+	name   string
+	offset int64 // Position of the contents in the archive, after the header
+}
+
+// readArIndex lists the members of the ar archive in f
+func readArIndex(f *os.File) ([]arMember, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("not a Debian package: missing ar signature")
+	}
+	var members []arMember
+	offset := int64(len(arMagic))
+	header := make([]byte, 60)
+	for {
+		if _, err := f.ReadAt(header, offset); err == io.EOF {
+			return members, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("truncated archive member header: %w", err)
+		}
+		if string(header[58:60]) != "`\n" {
+			return nil, fmt.Errorf("corrupt archive member header at offset %d", offset)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid archive member size at offset %d", offset)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		offset += 60
+		members = append(members, arMember{io.NewSectionReader(f, offset, size), name, offset})
+		offset += size + size%2
+	}
+}
+
+// openMember returns the uncompressed tar stream of the control.tar or
+// data.tar member. gzip-compressed and uncompressed members are read
+// directly, other compressions are extracted with dpkg-deb.
+func openMember(ctx context.Context, debPath string, members []arMember, prefix string) (io.ReadCloser, error) {
+	for _, member := range members {
+		if !strings.HasPrefix(member.name, prefix) {
+			continue
+		}
+		switch strings.TrimPrefix(member.name, prefix) {
+		case "":
+			return io.NopCloser(contextReader{ctx, member}), nil
+		case ".gz":
+			gz, err := gzip.NewReader(contextReader{ctx, member})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s of %s: %w", member.name, debPath, err)
+			}
+			return gz, nil
+		default:
+			flag := "--fsys-tarfile"
+			if prefix == "control.tar" {
+				flag = "--ctrl-tarfile"
+			}
+			return extractWithDpkgDeb(ctx, flag, debPath)
+		}
+	}
+	return nil, fmt.Errorf("%s has no %s member", debPath, prefix)
+}
+
+// dpkgDebOutput streams the output of dpkg-deb
+type dpkgDebOutput struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// Close waits for dpkg-deb and reports its failure
+func (d *dpkgDebOutput) Close() error {
+	io.Copy(io.Discard, d.ReadCloser)
+	if err := d.cmd.Wait(); err != nil {
+		return fmt.Errorf("dpkg-deb failed: %w: %s", err, strings.TrimSpace(d.stderr.String()))
+	}
+	return nil
+}
+
+// extractWithDpkgDeb streams the control or data tar of debPath from
+// dpkg-deb with --ctrl-tarfile or --fsys-tarfile
+func extractWithDpkgDeb(ctx context.Context, flag, debPath string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, "dpkg-deb", flag, debPath)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("extracting %s requires dpkg-deb: %w", debPath, err)
+	}
+	return &dpkgDebOutput{stdout, cmd, stderr}, nil
+}
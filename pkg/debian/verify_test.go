@@ -0,0 +1,188 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEntry is a control or data archive entry of a test package
+type testEntry struct {
+	name     string
+	content  string
+	linkname string
+	typeflag byte
+}
+
+// tarGz returns a gzip-compressed tar archive of entries
+func tarGz(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Typeflag: e.typeflag, Linkname: e.linkname, ModTime: time.Unix(0, 0)}
+		if e.typeflag == tar.TypeReg {
+			header.Size = int64(len(e.content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestDeb writes a .deb with the given control and data entries
+func writeTestDeb(t *testing.T, path string, control, data []testEntry) {
+	var buf bytes.Buffer
+	ar, err := newArWriter(&buf)
+	if err != nil {
+		t.Fatalf("newArWriter() error = %v", err)
+	}
+	for _, member := range []struct {
+		name    string
+		content []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", tarGz(t, control)},
+		{"data.tar.gz", tarGz(t, data)},
+	} {
+		if err := ar.writeMember(member.name, int64(len(member.content)), time.Unix(0, 0), bytes.NewReader(member.content)); err != nil {
+			t.Fatalf("writeMember() error = %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+}
+
+func TestVerifyPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const (
+		control = "Package: app\nVersion: 1.0\nArchitecture: all\nMaintainer: Test <test@example.com>\nDescription: d\n"
+		// md5 of "hello\n"
+		md5sums  = "b1946ac92492d2347c6235b4d2611184  opt/app/run.sh\nb1946ac92492d2347c6235b4d2611184  opt/app/link\n"
+		postinst = "#!/bin/sh\nexit 0\n"
+	)
+	intact := func() ([]testEntry, []testEntry) {
+		return []testEntry{
+			{name: "./control", content: control, typeflag: tar.TypeReg},
+			{name: "./md5sums", content: md5sums, typeflag: tar.TypeReg},
+			{name: "./postinst", content: postinst, typeflag: tar.TypeReg},
+		}, []testEntry{
+			{name: "./", typeflag: tar.TypeDir},
+			{name: "./opt/", typeflag: tar.TypeDir},
+			{name: "./opt/app/", typeflag: tar.TypeDir},
+			{name: "./opt/app/run.sh", content: "hello\n", typeflag: tar.TypeReg},
+			{name: "./opt/app/link", linkname: "./opt/app/run.sh", typeflag: tar.TypeLink},
+			{name: "./opt/app/sym", linkname: "run.sh", typeflag: tar.TypeSymlink},
+		}
+	}
+	expected := VerifyOptions{
+		Package: NewPackage("app", "1.0", "all", "", "", "", "", nil),
+		Files:   []string{"/opt/app/run.sh", "/opt/app/link", "/opt/app/sym"},
+		Roots:   []string{"/opt/app"},
+		Scripts: map[string]string{"postinst": postinst},
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(control, data []testEntry) ([]testEntry, []testEntry)
+		opts    VerifyOptions
+		wantErr string
+	}{
+		{"Intact", nil, expected, ""},
+		{"Intact without expectations", nil, VerifyOptions{}, ""},
+		{"Modified file", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			d[3].content = "HELLO\n"
+			return c, d
+		}, expected, "/opt/app/run.sh does not match its md5sum"},
+		{"Injected file", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			return c, append(d, testEntry{name: "./opt/app/extra", typeflag: tar.TypeReg})
+		}, expected, "/opt/app/extra was not packaged from the source tree"},
+		{"Missing file", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			return c, d[:5]
+		}, expected, "/opt/app/sym is missing from the payload"},
+		{"Changed script", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			c[2].content = "#!/bin/sh\nrm -rf /\n"
+			return c, d
+		}, expected, "postinst script differs"},
+		{"Added script", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			return append(c, testEntry{name: "./prerm", content: "#!/bin/sh\n", typeflag: tar.TypeReg}), d
+		}, expected, "unexpected prerm script"},
+		{"Traversal", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			return c, append(d, testEntry{name: "./opt/../../etc/passwd", typeflag: tar.TypeReg})
+		}, VerifyOptions{}, "escapes the package root"},
+		{"Outside roots", nil, VerifyOptions{Roots: []string{"/usr"}}, "/opt/app/run.sh is outside the declared roots"},
+		{"Wrong version", nil, VerifyOptions{Package: NewPackage("app", "2.0", "all", "", "", "", "", nil)}, `Version "1.0" instead of "2.0"`},
+		{"Bad control", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			c[0].content = "Package: App\nVersion: 1.0\nArchitecture: all\n"
+			return c, d
+		}, VerifyOptions{}, "no Maintainer field"},
+		{"No md5sums", func(c, d []testEntry) ([]testEntry, []testEntry) {
+			return append(c[:1], c[2:]...), d
+		}, VerifyOptions{}, "no md5sums file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, d := intact()
+			if tt.modify != nil {
+				c, d = tt.modify(c, d)
+			}
+			debPath := filepath.Join(dir, "app.deb")
+			writeTestDeb(t, debPath, c, d)
+
+			result, err := VerifyPackage(context.Background(), debPath, tt.opts)
+			if err != nil {
+				t.Fatalf("VerifyPackage() error = %v", err)
+			}
+			err = result.Err()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected problems: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Problems = %v, want %q", result.Problems, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyPackageNotADeb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.deb")
+	if err := ioutil.WriteFile(path, []byte("not a package"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := VerifyPackage(context.Background(), path, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "missing ar signature") {
+		t.Errorf("VerifyPackage() error = %v, want missing ar signature", err)
+	}
+}
@@ -0,0 +1,75 @@
+package debian
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/go-i2p/go-pkginstall/pkg/wrapper"
+)
+
+// SetWrappers validates and sets the executables run through generated
+// wrapper scripts
+func (b *Builder) SetWrappers(wrappers []wrapper.Wrapper) error {
+	names := make(map[string]string)
+	for _, w := range wrappers {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+		name := path.Base(w.Path)
+		if first, ok := names[name]; ok {
+			return fmt.Errorf("wrapped executables %s and %s have the same name", first, w.Path)
+		}
+		names[name] = w.Path
+	}
+	b.Wrappers = wrappers
+	return nil
+}
+
+// wrapperFor returns the wrapper declared for the executable at absPath
+func (b *Builder) wrapperFor(absPath string) (wrapper.Wrapper, bool) {
+	for _, w := range b.Wrappers {
+		if w.Path == absPath {
+			return w, true
+		}
+	}
+	return wrapper.Wrapper{}, false
+}
+
+// wrappedPath returns where a wrapped executable is packaged, before
+// transformation, leaving its own path to the wrapper script
+func (b *Builder) wrappedPath(absPath string) string {
+	return path.Join("/usr/libexec", b.Package.Name, path.Base(absPath))
+}
+
+// redirectWrapped returns the path to package the source entry at absPath
+// at: the wrapped path for executables with a wrapper, absPath otherwise
+func (b *Builder) redirectWrapped(absPath string, info os.FileInfo) string {
+	if _, ok := b.wrapperFor(absPath); !ok || info.IsDir() {
+		return absPath
+	}
+	if !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+		b.warn("Not wrapping %s: it is not an executable file", absPath)
+		return absPath
+	}
+	b.wrapped[absPath] = true
+	return b.wrappedPath(absPath)
+}
+
+// addWrappers queues the wrapper scripts of the executables found by the
+// walk of the source tree. It runs after the walk, when the relocated module
+// directories are known.
+func (b *Builder) addWrappers() {
+	for _, w := range b.Wrappers {
+		if !b.wrapped[w.Path] {
+			b.warn("Wrapped executable %s is not in the payload", w.Path)
+			continue
+		}
+		target, _, err := b.PathMapper.TransformPath(b.wrappedPath(w.Path))
+		if err != nil {
+			target = b.wrappedPath(w.Path)
+		}
+		b.log("Generating wrapper script %s for %s", w.Path, target)
+		b.queueGenerated(generatedFile{systemPath: w.Path, content: w.Script(target, b.ModuleEnv()), mode: 0755})
+	}
+}
@@ -0,0 +1,62 @@
+package debian
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/wrapper"
+)
+
+func TestWrappers(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "builder-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "usr", "bin"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "usr", "bin", "app"), []byte("binary"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	builder, err := NewBuilder(NewPackage("app", "1.0", "all", "Test <test@example.com>", "d", "utils", "optional", nil), srcDir, srcDir)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	defer builder.Clean()
+	duplicate := []wrapper.Wrapper{{Path: "/usr/bin/app"}, {Path: "/usr/sbin/app"}}
+	if err := builder.SetWrappers(duplicate); err == nil {
+		t.Errorf("Expected wrapped executables with the same name to be rejected")
+	}
+	err = builder.SetWrappers([]wrapper.Wrapper{
+		{Path: "/usr/bin/app", Prepend: map[string][]string{"LD_LIBRARY_PATH": {"/opt/usr/lib/app"}}},
+		{Path: "/usr/bin/missing"},
+	})
+	if err != nil {
+		t.Fatalf("SetWrappers() error = %v", err)
+	}
+	if err := builder.copyFiles(context.Background()); err != nil {
+		t.Fatalf("copyFiles() error = %v", err)
+	}
+
+	real, err := ioutil.ReadFile(filepath.Join(builder.BuildDir, "opt", "usr", "libexec", "app", "app"))
+	if err != nil || string(real) != "binary" {
+		t.Errorf("Expected the executable in libexec, got %q, %v", real, err)
+	}
+	scriptPath := filepath.Join(builder.BuildDir, "opt", "usr", "bin", "app")
+	script, err := ioutil.ReadFile(scriptPath)
+	if err != nil || !strings.Contains(string(script), "exec '/opt/usr/libexec/app/app' \"$@\"") {
+		t.Errorf("Expected a wrapper script, got %q, %v", script, err)
+	}
+	if info, err := os.Stat(scriptPath); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected an executable wrapper script, got %v, %v", info, err)
+	}
+	if !strings.Contains(strings.Join(builder.Warnings, "\n"), "Wrapped executable /usr/bin/missing is not in the payload") {
+		t.Errorf("Expected a warning about the missing executable, got %v", builder.Warnings)
+	}
+}
@@ -0,0 +1,193 @@
+// Package delta computes binary deltas: the instructions that rebuild one
+// byte string from another by copying ranges of the old one and inserting
+// new bytes. Matches are found with an rsync-style rolling checksum over
+// fixed-size blocks of the old data, so inserted or removed bytes do not
+// prevent the rest of the data from matching.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MinBlockSize is the smallest run of bytes the delta copies from the old
+// data. Larger inputs use larger blocks to bound the size of the index.
+const MinBlockSize = 64
+
+// maxBlocks bounds the number of indexed blocks of the old data
+const maxBlocks = 1 << 20
+
+// Operation codes of the delta stream
+const (
+	opCopy   = 'C' // Offset and length of a range of the old data
+	opInsert = 'I' // Length followed by the bytes to insert
+	opEnd    = 'E' // End of the delta
+)
+
+// ErrCorrupt reports a delta stream that cannot be applied
+var ErrCorrupt = errors.New("corrupt delta")
+
+// Stats describes a computed delta
+type Stats struct {
+	Copied   int64 // Bytes copied from the old data
+	Inserted int64 // Bytes inserted from the delta
+}
+
+// blockSize returns the block size for old data of the given length
+func blockSize(n int) int {
+	size := MinBlockSize
+	for n/size > maxBlocks {
+		size *= 2
+	}
+	return size
+}
+
+// rolling is the rsync weak checksum of a window of bytes
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRolling(window []byte) rolling {
+	r := rolling{n: uint32(len(window))}
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+	return r
+}
+
+// roll moves the window one byte forward, dropping out and adding in
+func (r *rolling) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// Diff writes the delta that rebuilds newData from oldData to w
+func Diff(oldData, newData []byte, w io.Writer) (Stats, error) {
+	var stats Stats
+	bw := bufio.NewWriter(w)
+	size := blockSize(len(oldData))
+
+	index := make(map[uint32]int, len(oldData)/size)
+	for off := 0; off+size <= len(oldData); off += size {
+		sum := newRolling(oldData[off : off+size]).sum()
+		if _, ok := index[sum]; !ok {
+			index[sum] = off
+		}
+	}
+
+	pos, literal := 0, 0
+	var window rolling
+	if len(newData) >= size {
+		window = newRolling(newData[:size])
+	}
+	for pos+size <= len(newData) {
+		if off, ok := index[window.sum()]; ok && bytes.Equal(oldData[off:off+size], newData[pos:pos+size]) {
+			// Grow the match backwards into the pending literal and forwards
+			for off > 0 && pos > literal && oldData[off-1] == newData[pos-1] {
+				off--
+				pos--
+			}
+			n := size
+			for off+n < len(oldData) && pos+n < len(newData) && oldData[off+n] == newData[pos+n] {
+				n++
+			}
+			writeInsert(bw, newData[literal:pos], &stats)
+			writeCopy(bw, off, n, &stats)
+			pos += n
+			literal = pos
+			if pos+size <= len(newData) {
+				window = newRolling(newData[pos : pos+size])
+			}
+			continue
+		}
+		if pos+size < len(newData) {
+			window.roll(newData[pos], newData[pos+size])
+		}
+		pos++
+	}
+	writeInsert(bw, newData[literal:], &stats)
+	bw.WriteByte(opEnd)
+	// bufio.Writer keeps the first write error for Flush
+	return stats, bw.Flush()
+}
+
+func writeCopy(w *bufio.Writer, off, n int, stats *Stats) {
+	w.WriteByte(opCopy)
+	writeUvarint(w, uint64(off))
+	writeUvarint(w, uint64(n))
+	stats.Copied += int64(n)
+}
+
+func writeInsert(w *bufio.Writer, data []byte, stats *Stats) {
+	if len(data) == 0 {
+		return
+	}
+	w.WriteByte(opInsert)
+	writeUvarint(w, uint64(len(data)))
+	w.Write(data)
+	stats.Inserted += int64(len(data))
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+// Reader is what Patch reads deltas from, such as a *bufio.Reader
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Patch reads a delta written by Diff from r, applies it to oldData and
+// writes the result to w. Patch stops after the end of the delta, so r may
+// hold further data.
+func Patch(oldData []byte, r Reader, w io.Writer) (int64, error) {
+	var written int64
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return written, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		switch op {
+		case opEnd:
+			return written, nil
+		case opCopy:
+			off, err1 := binary.ReadUvarint(r)
+			n, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || off > uint64(len(oldData)) || n > uint64(len(oldData))-off {
+				return written, fmt.Errorf("%w: copy out of range", ErrCorrupt)
+			}
+			m, err := w.Write(oldData[off : off+n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		case opInsert:
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return written, fmt.Errorf("%w: %v", ErrCorrupt, err)
+			}
+			m, err := io.CopyN(w, r, int64(n))
+			written += m
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return written, fmt.Errorf("%w: truncated insert", ErrCorrupt)
+				}
+				return written, err
+			}
+		default:
+			return written, fmt.Errorf("%w: unknown operation %q", ErrCorrupt, op)
+		}
+	}
+}
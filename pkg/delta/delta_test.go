@@ -0,0 +1,80 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestDiffPatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rng.Read(data)
+		return data
+	}
+	base := random(64 * 1024)
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name        string
+		old, new    []byte
+		maxInserted int64
+	}{
+		{"Identical", base, base, 0},
+		{"Empty", nil, nil, 0},
+		{"FromEmpty", nil, base[:1000], 1000},
+		{"ToEmpty", base, nil, 0},
+		{"Shorter than a block", base[:10], base[:20], 20},
+		{"Inserted", base, join(base[:30000], []byte("inserted bytes"), base[30000:]), 14},
+		{"Removed", base, join(base[:1000], base[1100:]), 0},
+		{"Replaced", base, join(base[:5000], random(500), base[5500:]), 500 + 2*MinBlockSize},
+		{"Moved", base, join(base[40000:], base[:40000]), 0},
+		{"Unrelated", base[:4096], random(4096), 4096},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var encoded bytes.Buffer
+			stats, err := Diff(tt.old, tt.new, &encoded)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			if stats.Copied+stats.Inserted != int64(len(tt.new)) {
+				t.Errorf("stats %+v do not add up to %d bytes", stats, len(tt.new))
+			}
+			if stats.Inserted > tt.maxInserted {
+				t.Errorf("Diff() inserted %d bytes, want at most %d", stats.Inserted, tt.maxInserted)
+			}
+
+			var out bytes.Buffer
+			n, err := Patch(tt.old, bufio.NewReader(&encoded), &out)
+			if err != nil {
+				t.Fatalf("Patch() error = %v", err)
+			}
+			if n != int64(len(tt.new)) || !bytes.Equal(out.Bytes(), tt.new) {
+				t.Errorf("Patch() rebuilt %d bytes that differ from the new data", n)
+			}
+		})
+	}
+}
+
+func TestPatchCorrupt(t *testing.T) {
+	tests := map[string][]byte{
+		"Truncated":      {opCopy, 0},
+		"OutOfRange":     {opCopy, 10, 100, opEnd},
+		"ShortInsert":    {opInsert, 10, 'a'},
+		"UnknownOp":      {'X'},
+		"MissingEndMark": {opInsert, 1, 'a'},
+	}
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Patch(make([]byte, 50), bufio.NewReader(bytes.NewReader(encoded)), &bytes.Buffer{})
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Patch() error = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Package devfiles rewrites the paths embedded in development files, such as
+// pkg-config files, libtool archives and CMake package configurations, after
+// the files they name were relocated.
+package devfiles
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathToken matches an absolute path and what precedes it: the start of a
+// line, a separator, a quote or a compiler flag such as -L or -I. Paths
+// starting with // are the rest of URLs and are not matched.
+var pathToken = regexp.MustCompile(`(?m)(^|[\s=:;,'"(]|-[LIR])(/[A-Za-z0-9._+@-][A-Za-z0-9._+@/-]*)`)
+
+// IsDevFile reports whether path is a pkg-config file, a libtool archive or
+// a CMake package configuration file
+func IsDevFile(p string) bool {
+	dir, name := path.Base(path.Dir(p)), path.Base(p)
+	switch {
+	case strings.HasSuffix(name, ".pc"):
+		return dir == "pkgconfig"
+	case strings.HasSuffix(name, ".la"):
+		return true
+	case strings.HasSuffix(name, ".cmake"):
+		return strings.Contains(p, "/cmake/")
+	}
+	return false
+}
+
+// Rewrite replaces the absolute paths in content that relocate maps, such as
+// prefix=/usr in a pkg-config file or libdir='/usr/lib' in a libtool
+// archive, and returns the new content and the number of replaced paths.
+// relocate is called with cleaned paths; a trailing slash is kept.
+func Rewrite(content []byte, relocate func(path string) (string, bool)) ([]byte, int) {
+	replaced := 0
+	rewritten := pathToken.ReplaceAllStringFunc(string(content), func(match string) string {
+		groups := pathToken.FindStringSubmatch(match)
+		lead, p := groups[1], groups[2]
+		target, ok := relocate(path.Clean(p))
+		if !ok {
+			return match
+		}
+		if strings.HasSuffix(p, "/") && !strings.HasSuffix(target, "/") {
+			target += "/"
+		}
+		replaced++
+		return lead + target
+	})
+	return []byte(rewritten), replaced
+}
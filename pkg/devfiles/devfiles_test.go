@@ -0,0 +1,57 @@
+package devfiles
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsDevFile(t *testing.T) {
+	tests := map[string]bool{
+		"/usr/lib/x86_64-linux-gnu/pkgconfig/app.pc": true,
+		"/usr/share/pkgconfig/app-data.pc":           true,
+		"/usr/lib/libapp.la":                         true,
+		"/usr/lib/cmake/App/AppConfig.cmake":         true,
+		"/usr/share/cmake/App/app-targets.cmake":     true,
+		"/usr/share/app/slides.pc":                   false,
+		"/usr/share/app/build.cmake":                 false,
+		"/usr/lib/libapp.so":                         false,
+	}
+	for path, want := range tests {
+		if got := IsDevFile(path); got != want {
+			t.Errorf("IsDevFile(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	relocate := func(path string) (string, bool) {
+		if path == "/usr" || strings.HasPrefix(path, "/usr/") && !strings.HasPrefix(path, "/usr/lib/x86_64-linux-gnu/libz") {
+			return "/opt" + path, true
+		}
+		return "", false
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		count int
+	}{
+		{"pkg-config", "prefix=/usr\nlibdir=${prefix}/lib\nCflags: -I${prefix}/include -I/usr/include/app\n",
+			"prefix=/opt/usr\nlibdir=${prefix}/lib\nCflags: -I${prefix}/include -I/opt/usr/include/app\n", 2},
+		{"libtool", "libdir='/usr/lib/x86_64-linux-gnu'\ndependency_libs=' -L/usr/lib/app /usr/lib/x86_64-linux-gnu/libz.la'\n",
+			"libdir='/opt/usr/lib/x86_64-linux-gnu'\ndependency_libs=' -L/opt/usr/lib/app /usr/lib/x86_64-linux-gnu/libz.la'\n", 2},
+		{"CMake", `set_target_properties(App::app PROPERTIES IMPORTED_LOCATION "/usr/lib/libapp.so.1")` + "\n",
+			`set_target_properties(App::app PROPERTIES IMPORTED_LOCATION "/opt/usr/lib/libapp.so.1")` + "\n", 1},
+		{"Trailing slash and list", "dirs=/usr/share/app/:/srv/app\n", "dirs=/opt/usr/share/app/:/srv/app\n", 1},
+		{"URL", "URL: https://example.org/usr/app\n", "URL: https://example.org/usr/app\n", 0},
+		{"Variable", "includedir=${prefix}/usr/include\n", "includedir=${prefix}/usr/include\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count := Rewrite([]byte(tt.input), relocate)
+			if string(got) != tt.want || count != tt.count {
+				t.Errorf("Rewrite() = %q, %d, want %q, %d", got, count, tt.want, tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/spf13/cobra"
+)
+
+// CommandOptions contains options for the doctor command
+type CommandOptions struct {
+	Options
+	Format string
+}
+
+// NewDoctorCommand creates a command checking the build environment
+func NewDoctorCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the environment can build and install packages",
+		Long: `Check the environment pkginstall runs in and suggest a fix for each problem:
+
+  - dpkg-deb is installed and supports --root-owner-group (fakeroot is not needed)
+  - the output and work directories are writable
+  - /opt exists and only root can write to it
+  - the kernel lets unprivileged users create user namespaces, which
+    audit run-script and rootless checkinstall --in-container need
+
+Failed checks make the command exit with an error; warnings only affect
+optional features.
+
+Examples:
+  pkginstall doctor
+  pkginstall doctor --output dist --work-dir /var/tmp
+  pkginstall doctor --format json
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Failed checks are not usage errors
+			cmd.SilenceUsage = true
+			return runDoctorCommand(options, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&options.OutputDirs, "output", "o", []string{"."}, "Output directory to check (can be repeated)")
+	cmd.Flags().StringVar(&options.WorkDir, "work-dir", "", "Work directory to check (default: system temp dir)")
+	cmd.Flags().StringVarP(&options.Format, "format", "f", "text", "Output format (text, json)")
+
+	return cmd
+}
+
+// runDoctorCommand runs the checks and reports them
+func runDoctorCommand(options *CommandOptions, w io.Writer) error {
+	if options.Format != "text" && options.Format != "json" {
+		return fmt.Errorf("unknown format %q (available: text, json)", options.Format)
+	}
+	checks := Run(options.Options)
+
+	if options.Format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checks); err != nil {
+			return err
+		}
+	} else {
+		Print(w, checks)
+	}
+
+	if failed := Failed(checks); failed > 0 {
+		return ci.Errorf(ci.ClassEnvironment, "%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn" // An optional feature will not work
+	StatusFail Status = "fail" // Builds or installs will fail
+)
+
+// Check is the result of one environment check
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"` // How to fix a warning or failure
+}
+
+// Options selects what the checks look at
+type Options struct {
+	OutputDirs []string // Directories packages are written to (default: ".")
+	WorkDir    string   // Build directory parent (default: the system temp dir)
+	OptDir     string   // Relocation root (default: "/opt")
+	ProcDir    string   // Kernel settings (default: "/proc")
+}
+
+// lookPath and dpkgDebVersion are replaced in tests
+var (
+	lookPath       = exec.LookPath
+	dpkgDebVersion = func() (string, error) {
+		out, err := exec.Command("dpkg-deb", "--version").Output()
+		return string(out), err
+	}
+)
+
+// minDpkgDeb is the first dpkg-deb release with --root-owner-group, which
+// builds use instead of fakeroot
+var minDpkgDeb = []int{1, 19, 0}
+
+// Run checks the environment and returns the result of each check
+func Run(opts Options) []Check {
+	if len(opts.OutputDirs) == 0 {
+		opts.OutputDirs = []string{"."}
+	}
+	if opts.WorkDir == "" {
+		opts.WorkDir = os.TempDir()
+	}
+	if opts.OptDir == "" {
+		opts.OptDir = "/opt"
+	}
+	if opts.ProcDir == "" {
+		opts.ProcDir = "/proc"
+	}
+
+	checks := []Check{checkDpkgDeb(), checkFakeroot()}
+	for _, dir := range opts.OutputDirs {
+		checks = append(checks, checkWritable("Output directory", dir,
+			"choose another directory with --output, or make it writable: sudo chown $USER "+dir))
+	}
+	checks = append(checks, checkWritable("Work directory", opts.WorkDir,
+		"choose another directory with --work-dir or TMPDIR"))
+	checks = append(checks, checkOptDir(opts.OptDir), checkUserNamespaces(opts.ProcDir))
+	return checks
+}
+
+// Failed returns the number of failed checks
+func Failed(checks []Check) int {
+	failed := 0
+	for _, check := range checks {
+		if check.Status == StatusFail {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Print writes one line per check, followed by the fix for each problem
+func Print(w io.Writer, checks []Check) {
+	for _, check := range checks {
+		fmt.Fprintf(w, "%-6s %s: %s\n", "["+string(check.Status)+"]", check.Name, check.Detail)
+		if check.Hint != "" && check.Status != StatusOK {
+			fmt.Fprintf(w, "       fix: %s\n", check.Hint)
+		}
+	}
+}
+
+// checkDpkgDeb checks that dpkg-deb exists and supports --root-owner-group
+func checkDpkgDeb() Check {
+	check := Check{Name: "dpkg-deb"}
+	path, err := lookPath("dpkg-deb")
+	if err != nil {
+		check.Status = StatusFail
+		check.Detail = "not found; it builds and verifies every package"
+		check.Hint = "sudo apt install dpkg"
+		return check
+	}
+	out, err := dpkgDebVersion()
+	if err != nil {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("%s does not run: %v", path, err)
+		check.Hint = "reinstall dpkg: sudo apt install --reinstall dpkg"
+		return check
+	}
+	version := parseDpkgVersion(out)
+	if version == "" {
+		check.Status = StatusWarn
+		check.Detail = fmt.Sprintf("%s: unrecognised version output", path)
+		check.Hint = "dpkg 1.19 or later is needed for --root-owner-group"
+		return check
+	}
+	if !versionAtLeast(version, minDpkgDeb) {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("%s %s does not support --root-owner-group", path, version)
+		check.Hint = "upgrade to dpkg 1.19 or later"
+		return check
+	}
+	check.Status = StatusOK
+	check.Detail = fmt.Sprintf("%s %s", path, version)
+	return check
+}
+
+var dpkgVersionPattern = regexp.MustCompile(`version (\d+(?:\.\d+)+)`)
+
+// parseDpkgVersion returns the version in the output of dpkg-deb --version
+func parseDpkgVersion(out string) string {
+	if m := dpkgVersionPattern.FindStringSubmatch(out); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// versionAtLeast compares a dotted version with a minimum
+func versionAtLeast(version string, min []int) bool {
+	parts := strings.Split(version, ".")
+	for i, want := range min {
+		got := 0
+		if i < len(parts) {
+			got, _ = strconv.Atoi(parts[i])
+		}
+		if got != want {
+			return got > want
+		}
+	}
+	return true
+}
+
+// checkFakeroot reports fakeroot, which builds do not need
+func checkFakeroot() Check {
+	check := Check{Name: "fakeroot", Status: StatusOK}
+	if path, err := lookPath("fakeroot"); err == nil {
+		check.Detail = path + " (not required)"
+	} else {
+		check.Detail = "not installed; not required, dpkg-deb --root-owner-group sets file ownership"
+	}
+	return check
+}
+
+// checkWritable checks that a file can be created in dir, or in the
+// nearest existing parent that it would be created in
+func checkWritable(name, dir, hint string) Check {
+	check := Check{Name: name, Hint: hint}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	existing := abs
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				check.Status = StatusFail
+				check.Detail = fmt.Sprintf("%s is not a directory", existing)
+				return check
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(existing) == existing {
+			check.Status = StatusFail
+			check.Detail = fmt.Sprintf("cannot access %s: %v", existing, err)
+			return check
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	f, err := os.CreateTemp(existing, ".pkginstall-doctor-")
+	if err != nil {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("%s is not writable: %v", existing, err)
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	check.Status = StatusOK
+	check.Detail = abs + " is writable"
+	if existing != abs {
+		check.Detail = fmt.Sprintf("%s will be created in %s", abs, existing)
+	}
+	return check
+}
+
+// checkOptDir checks the directory relocated files are installed below.
+// dpkg creates it when missing, but a directory others can write to lets
+// them replace relocated files.
+func checkOptDir(dir string) Check {
+	check := Check{Name: "Relocation root"}
+	info, err := os.Lstat(dir)
+	switch {
+	case os.IsNotExist(err):
+		check.Status = StatusWarn
+		check.Detail = dir + " does not exist; installing a package creates it"
+		check.Hint = "sudo mkdir -m 0755 " + dir
+	case err != nil:
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("cannot access %s: %v", dir, err)
+	case info.Mode()&os.ModeSymlink != 0:
+		target, _ := filepath.EvalSymlinks(dir)
+		check.Status = StatusWarn
+		check.Detail = fmt.Sprintf("%s is a symlink to %s", dir, target)
+		check.Hint = "make sure " + target + " is only writable by root"
+	case !info.IsDir():
+		check.Status = StatusFail
+		check.Detail = dir + " is not a directory"
+		check.Hint = "move it away and run: sudo mkdir -m 0755 " + dir
+	case info.Mode().Perm()&0022 != 0:
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("%s is writable by other users (%04o)", dir, info.Mode().Perm())
+		check.Hint = "sudo chmod go-w " + dir
+	default:
+		check.Status = StatusOK
+		check.Detail = fmt.Sprintf("%s exists (%04o)", dir, info.Mode().Perm())
+	}
+	return check
+}
+
+// checkUserNamespaces checks that unprivileged users can create user
+// namespaces, which bubblewrap (audit run-script) and rootless podman
+// (checkinstall --in-container) need
+func checkUserNamespaces(procDir string) Check {
+	check := Check{Name: "User namespaces", Status: StatusWarn}
+	read := func(name string) (string, bool) {
+		content, err := os.ReadFile(filepath.Join(procDir, "sys", filepath.FromSlash(name)))
+		return strings.TrimSpace(string(content)), err == nil
+	}
+
+	max, ok := read("user/max_user_namespaces")
+	switch {
+	case !ok:
+		check.Detail = "not supported by the kernel; audit run-script and rootless containers are unavailable"
+		check.Hint = "use a kernel built with CONFIG_USER_NS, or run audit run-script as root"
+	case max == "0":
+		check.Detail = "disabled (user.max_user_namespaces = 0)"
+		check.Hint = "sudo sysctl -w user.max_user_namespaces=15000"
+	default:
+		if value, ok := read("kernel/unprivileged_userns_clone"); ok && value == "0" {
+			check.Detail = "disabled for unprivileged users (kernel.unprivileged_userns_clone = 0)"
+			check.Hint = "sudo sysctl -w kernel.unprivileged_userns_clone=1"
+			break
+		}
+		if value, ok := read("kernel/apparmor_restrict_unprivileged_userns"); ok && value == "1" {
+			check.Detail = "restricted by AppArmor to profiled programs (kernel.apparmor_restrict_unprivileged_userns = 1)"
+			check.Hint = "install an AppArmor profile for bwrap, or run: sudo sysctl -w kernel.apparmor_restrict_unprivileged_userns=0"
+			break
+		}
+		check.Status = StatusOK
+		check.Detail = fmt.Sprintf("available (up to %s)", max)
+	}
+	return check
+}
@@ -0,0 +1,198 @@
+package doctor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubTools replaces the dpkg-deb lookup for the duration of a test
+func stubTools(t *testing.T, found map[string]bool, version string) {
+	origLookPath, origVersion := lookPath, dpkgDebVersion
+	t.Cleanup(func() { lookPath, dpkgDebVersion = origLookPath, origVersion })
+	lookPath = func(name string) (string, error) {
+		if found[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", fmt.Errorf("%s not found", name)
+	}
+	dpkgDebVersion = func() (string, error) {
+		return "Debian 'dpkg-deb' package archive backend version " + version + " (amd64).\n", nil
+	}
+}
+
+// writeProc creates a fake /proc with the given sys settings
+func writeProc(t *testing.T, dir string, settings map[string]string) string {
+	proc := filepath.Join(dir, "proc")
+	for name, value := range settings {
+		path := filepath.Join(proc, "sys", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return proc
+}
+
+func findCheck(checks []Check, name string) Check {
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return Check{}
+}
+
+func TestRun(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "doctor-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	opt := filepath.Join(tempDir, "opt")
+	if err := os.Mkdir(opt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	openOpt := filepath.Join(tempDir, "open-opt")
+	if err := os.Mkdir(openOpt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(openOpt, 0777); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(tempDir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		found      map[string]bool
+		version    string
+		opts       Options
+		proc       map[string]string
+		wantStatus map[string]Status
+		wantHint   string
+	}{
+		{
+			name:    "Healthy",
+			found:   map[string]bool{"dpkg-deb": true},
+			version: "1.21.22",
+			opts:    Options{OutputDirs: []string{filepath.Join(tempDir, "dist", "new")}, OptDir: opt},
+			proc:    map[string]string{"user/max_user_namespaces": "1000"},
+			wantStatus: map[string]Status{
+				"dpkg-deb": StatusOK, "fakeroot": StatusOK, "Output directory": StatusOK,
+				"Work directory": StatusOK, "Relocation root": StatusOK, "User namespaces": StatusOK,
+			},
+		},
+		{
+			name:       "No dpkg-deb",
+			found:      map[string]bool{},
+			opts:       Options{OptDir: opt},
+			wantStatus: map[string]Status{"dpkg-deb": StatusFail},
+			wantHint:   "sudo apt install dpkg",
+		},
+		{
+			name:       "Old dpkg-deb",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.18.25",
+			opts:       Options{OptDir: opt},
+			wantStatus: map[string]Status{"dpkg-deb": StatusFail},
+			wantHint:   "dpkg 1.19 or later",
+		},
+		{
+			name:       "Output path is a file",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.19.0",
+			opts:       Options{OutputDirs: []string{file}, OptDir: opt},
+			wantStatus: map[string]Status{"Output directory": StatusFail},
+			wantHint:   "--output",
+		},
+		{
+			name:       "Missing /opt",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.19.0",
+			opts:       Options{OptDir: filepath.Join(tempDir, "missing")},
+			wantStatus: map[string]Status{"Relocation root": StatusWarn},
+			wantHint:   "sudo mkdir",
+		},
+		{
+			name:       "World-writable /opt",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.19.0",
+			opts:       Options{OptDir: openOpt},
+			wantStatus: map[string]Status{"Relocation root": StatusFail},
+			wantHint:   "chmod go-w",
+		},
+		{
+			name:       "No user namespaces",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.19.0",
+			opts:       Options{OptDir: opt},
+			wantStatus: map[string]Status{"User namespaces": StatusWarn},
+			wantHint:   "CONFIG_USER_NS",
+		},
+		{
+			name:       "Unprivileged user namespaces disabled",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.19.0",
+			opts:       Options{OptDir: opt},
+			proc:       map[string]string{"user/max_user_namespaces": "1000", "kernel/unprivileged_userns_clone": "0"},
+			wantStatus: map[string]Status{"User namespaces": StatusWarn},
+			wantHint:   "kernel.unprivileged_userns_clone=1",
+		},
+		{
+			name:       "AppArmor restriction",
+			found:      map[string]bool{"dpkg-deb": true},
+			version:    "1.19.0",
+			opts:       Options{OptDir: opt},
+			proc:       map[string]string{"user/max_user_namespaces": "1000", "kernel/apparmor_restrict_unprivileged_userns": "1"},
+			wantStatus: map[string]Status{"User namespaces": StatusWarn},
+			wantHint:   "AppArmor profile for bwrap",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubTools(t, tt.found, tt.version)
+			tt.opts.ProcDir = writeProc(t, filepath.Join(tempDir, fmt.Sprint(i)), tt.proc)
+			checks := Run(tt.opts)
+
+			for name, want := range tt.wantStatus {
+				if got := findCheck(checks, name); got.Status != want {
+					t.Errorf("%s: status = %q (%s), want %q", name, got.Status, got.Detail, want)
+				}
+			}
+			var out strings.Builder
+			Print(&out, checks)
+			if !strings.Contains(out.String(), tt.wantHint) {
+				t.Errorf("Output lacks the fix %q:\n%s", tt.wantHint, out.String())
+			}
+		})
+	}
+}
+
+func TestNewBuildInfo(t *testing.T) {
+	info := NewBuildInfo("1.2.3", "abc123", "2024-01-02T03:04:05Z")
+	if info.Version != "1.2.3" || info.Commit != "abc123" || info.Date != "2024-01-02T03:04:05Z" {
+		t.Errorf("Values set at build time were replaced: %+v", info)
+	}
+	if info.GoVersion == "" || info.Platform == "" {
+		t.Errorf("Missing Go version or platform: %+v", info)
+	}
+
+	// Test binaries carry no VCS stamp, so the defaults are used
+	info = NewBuildInfo("", "", "")
+	if info.Version == "" || info.Commit == "" || info.Date == "" {
+		t.Errorf("Empty fields were not filled in: %+v", info)
+	}
+	if !strings.Contains(info.String(), info.GoVersion) {
+		t.Errorf("String() = %q, want the Go version", info.String())
+	}
+}
@@ -0,0 +1,108 @@
+// Package doctor reports the pkginstall version and checks that the host can
+// build and install packages.
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// BuildInfo describes the pkginstall binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// NewBuildInfo returns the build information of the running binary. Empty
+// values, as in binaries built without -ldflags "-X main.commit=...", are
+// taken from the VCS stamp the Go toolchain embeds.
+func NewBuildInfo(version, commit, date string) BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		modified := false
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && commit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String returns the one-line form used by pkginstall --version
+func (i BuildInfo) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s %s)", i.Version, i.Commit, i.Date, i.GoVersion, i.Platform)
+}
+
+// Print writes the build information, one field per line
+func (i BuildInfo) Print(w io.Writer) {
+	fmt.Fprintf(w, "pkginstall %s\n", i.Version)
+	fmt.Fprintf(w, "  Commit:     %s\n", i.Commit)
+	fmt.Fprintf(w, "  Built:      %s\n", i.Date)
+	fmt.Fprintf(w, "  Go version: %s\n", i.GoVersion)
+	fmt.Fprintf(w, "  Platform:   %s\n", i.Platform)
+}
+
+// NewVersionCommand creates a command printing the build information
+func NewVersionCommand(info BuildInfo) *cobra.Command {
+	var short bool
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show the pkginstall version",
+		Long: `Show the pkginstall version, the git commit and date it was built from,
+and the Go version it was built with.
+
+Examples:
+  pkginstall version
+  pkginstall version --short
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if short {
+				fmt.Fprintln(cmd.OutOrStdout(), info.Version)
+				return nil
+			}
+			info.Print(cmd.OutOrStdout())
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&short, "short", "s", false, "Print only the version number")
+	return cmd
+}
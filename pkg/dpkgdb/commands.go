@@ -0,0 +1,193 @@
+package dpkgdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultListsDir is the location of the apt indices relative to the filesystem root
+const DefaultListsDir = "var/lib/apt/lists"
+
+// aptHelper decompresses apt indices in formats the standard library cannot
+// read, such as the lz4-compressed Contents files apt-file downloads
+const aptHelper = "usr/lib/apt/apt-helper"
+
+// CommandDirs are the directories whose files are commands on the default PATH
+var CommandDirs = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin", "/usr/games"}
+
+// Shipper is a package that ships a command
+type Shipper struct {
+	Package   string // Package name
+	Path      string // Path of the command in the package
+	Installed bool   // Whether the package is installed, rather than only available from apt
+}
+
+// IsCommandPath reports whether p is a file in one of CommandDirs
+func IsCommandPath(p string) bool {
+	dir := path.Dir(path.Clean(p))
+	for _, commandDir := range CommandDirs {
+		if dir == commandDir {
+			return true
+		}
+	}
+	return false
+}
+
+// commandIndex returns the commands of the installed packages, indexing
+// them on first use
+func (db *Database) commandIndex() map[string][]Shipper {
+	if db.commands != nil {
+		return db.commands
+	}
+	db.commands = make(map[string][]Shipper)
+	for p, owners := range db.owners {
+		if !IsCommandPath(p) {
+			continue
+		}
+		for _, owner := range owners {
+			db.addCommand(Shipper{Package: owner, Path: p, Installed: true})
+		}
+	}
+	return db.commands
+}
+
+// addCommand records s unless the package is already known to ship the
+// command at the same path
+func (db *Database) addCommand(s Shipper) {
+	name := path.Base(s.Path)
+	for _, known := range db.commands[name] {
+		if known.Package == s.Package && known.Path == s.Path {
+			return
+		}
+	}
+	db.commands[name] = append(db.commands[name], s)
+}
+
+// Shippers returns the packages that ship a command called name in one of
+// CommandDirs, sorted by package and path
+func (db *Database) Shippers(name string) []Shipper {
+	shippers := append([]Shipper{}, db.commandIndex()[name]...)
+	sort.Slice(shippers, func(i, j int) bool {
+		if shippers[i].Package != shippers[j].Package {
+			return shippers[i].Package < shippers[j].Package
+		}
+		return shippers[i].Path < shippers[j].Path
+	})
+	return shippers
+}
+
+// LoadContents adds the commands of the packages available from apt, read
+// from the Contents indices that apt-file downloads to /var/lib/apt/lists.
+// It returns the number of indices read; without any the index only covers
+// installed packages.
+func (db *Database) LoadContents() (int, error) {
+	db.commandIndex()
+	files, err := filepath.Glob(filepath.Join(db.root, DefaultListsDir, "*_Contents-*"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list apt Contents indices: %w", err)
+	}
+	read := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, ".diff_Index") {
+			continue
+		}
+		if err := db.readContents(file); err != nil {
+			return read, err
+		}
+		read++
+	}
+	return read, nil
+}
+
+// readContents adds the commands listed in one Contents index. Each line is
+// a path without the leading slash followed by the packages shipping it, as
+// in "usr/sbin/nginx   httpd/nginx-core,httpd/nginx-full".
+func (db *Database) readContents(file string) error {
+	r, closeIndex, err := db.openIndex(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	defer closeIndex()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		sep := strings.LastIndexAny(line, " \t")
+		if sep < 0 {
+			continue
+		}
+		p := "/" + strings.TrimSpace(line[:sep])
+		if !IsCommandPath(p) {
+			continue
+		}
+		for _, location := range strings.Split(line[sep+1:], ",") {
+			pkg := location[strings.LastIndex(location, "/")+1:]
+			// Qualified names such as pkg:amd64 are used for multi-arch packages
+			if idx := strings.Index(pkg, ":"); idx > 0 {
+				pkg = pkg[:idx]
+			}
+			if pkg == "" {
+				continue
+			}
+			s := Shipper{Package: pkg, Path: p}
+			for _, owner := range db.owners[p] {
+				s.Installed = s.Installed || owner == pkg
+			}
+			db.addCommand(s)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return nil
+}
+
+// openIndex opens an apt index, decompressing it with gzip or, for other
+// compressions, with apt-helper
+func (db *Database) openIndex(file string) (io.Reader, func() error, error) {
+	switch filepath.Ext(file) {
+	case ".lz4", ".xz", ".zst", ".bz2", ".lzma":
+		helper := filepath.Join(db.root, aptHelper)
+		if _, err := os.Stat(helper); err != nil {
+			return nil, nil, fmt.Errorf("apt-helper is needed to decompress it: %w", err)
+		}
+		cmd := exec.Command(helper, "cat-file", file)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return stdout, func() error {
+			io.Copy(io.Discard, stdout)
+			return cmd.Wait()
+		}, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filepath.Ext(file) != ".gz" {
+		return f, f.Close, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return gz, func() error {
+		gz.Close()
+		return f.Close()
+	}, nil
+}
@@ -0,0 +1,189 @@
+package dpkgdb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/control"
+)
+
+// DefaultInfoDir is the location of the dpkg file lists relative to the filesystem root
+const DefaultInfoDir = "var/lib/dpkg/info"
+
+// DefaultStatusFile is the location of the dpkg status file relative to the filesystem root
+const DefaultStatusFile = "var/lib/dpkg/status"
+
+// Conflict describes a path that is already owned by an installed package
+type Conflict struct {
+	Path   string   // Path that would be written
+	Kind   string   // "file" or "symlink"
+	Owners []string // Installed packages that own the path
+}
+
+// String returns a human-readable description of the conflict
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s %s is owned by installed package %s", c.Kind, c.Path, strings.Join(c.Owners, ", "))
+}
+
+// Database is a read-only index of which installed packages own which paths,
+// built from the dpkg file lists in /var/lib/dpkg/info.
+type Database struct {
+	root      string
+	owners    map[string][]string
+	packages  map[string]bool        // Packages with a file list
+	files     map[string][]string    // Paths listed for each package
+	commands  map[string][]Shipper   // Command name to the packages shipping it, built on demand
+	essential map[string]bool        // Installed packages marked Essential, read on demand
+	managed   map[string]ManagedLink // Alternative links and diversions, read on demand
+}
+
+// Open reads the dpkg database below the given filesystem root ("/" for the host).
+// A missing database (e.g. on a non-Debian build host) yields an empty Database.
+func Open(root string) (*Database, error) {
+	if root == "" {
+		root = "/"
+	}
+
+	db := &Database{
+		root:     root,
+		owners:   make(map[string][]string),
+		packages: make(map[string]bool),
+		files:    make(map[string][]string),
+	}
+
+	infoDir := filepath.Join(root, DefaultInfoDir)
+	lists, err := filepath.Glob(filepath.Join(infoDir, "*.list"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dpkg database: %w", err)
+	}
+
+	for _, list := range lists {
+		pkg := strings.TrimSuffix(filepath.Base(list), ".list")
+		// Multi-arch packages are recorded as name:arch.list
+		if idx := strings.Index(pkg, ":"); idx > 0 {
+			pkg = pkg[:idx]
+		}
+		if err := db.readList(list, pkg); err != nil {
+			return nil, err
+		}
+		db.packages[pkg] = true
+	}
+
+	return db, nil
+}
+
+// readList adds the paths of a single .list file to the index
+func (db *Database) readList(listPath, pkg string) error {
+	f, err := os.Open(listPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", listPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" || path == "/." {
+			continue
+		}
+		db.owners[path] = append(db.owners[path], pkg)
+		db.files[pkg] = append(db.files[pkg], path)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", listPath, err)
+	}
+	return nil
+}
+
+// Owners returns the installed packages that own path, sorted by name
+func (db *Database) Owners(path string) []string {
+	owners := append([]string{}, db.owners[filepath.Clean(path)]...)
+	sort.Strings(owners)
+	return owners
+}
+
+// Installed reports whether pkg is installed, that is has a file list
+func (db *Database) Installed(pkg string) bool {
+	return db.packages[pkg]
+}
+
+// Files returns the paths installed by pkg, directories included, in the
+// order of its file list
+func (db *Database) Files(pkg string) []string {
+	return append([]string{}, db.files[pkg]...)
+}
+
+// Fields returns the fields of pkg in the dpkg status file, such as Version,
+// Architecture and Depends. Continuation lines are joined with newlines.
+func (db *Database) Fields(pkg string) (map[string]string, bool) {
+	for _, paragraph := range db.status() {
+		if paragraph.Value("Package") == pkg {
+			return paragraph.Map(), true
+		}
+	}
+	return nil, false
+}
+
+// status returns the paragraphs of the dpkg status file, or nil if it
+// cannot be read or parsed
+func (db *Database) status() []control.Paragraph {
+	f, err := os.Open(filepath.Join(db.root, DefaultStatusFile))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	paragraphs, err := control.Parse(f)
+	if err != nil {
+		return nil
+	}
+	return paragraphs
+}
+
+// PackageCount returns the number of distinct packages in the index
+func (db *Database) PackageCount() int {
+	seen := make(map[string]bool)
+	for _, owners := range db.owners {
+		for _, owner := range owners {
+			seen[owner] = true
+		}
+	}
+	return len(seen)
+}
+
+// FindConflicts returns the paths that are owned by an installed package other than
+// exclude (normally the package being built or upgraded). Directories shared between
+// packages are normal in dpkg, so callers should only pass non-directory paths.
+func (db *Database) FindConflicts(paths []string, kind, exclude string) []Conflict {
+	var conflicts []Conflict
+	for _, path := range paths {
+		var others []string
+		for _, owner := range db.Owners(path) {
+			if owner != exclude {
+				others = append(others, owner)
+			}
+		}
+		if len(others) > 0 {
+			conflicts = append(conflicts, Conflict{Path: filepath.Clean(path), Kind: kind, Owners: others})
+		}
+	}
+	return conflicts
+}
+
+// Essential reports whether pkg is installed and marked Essential in the dpkg
+// status file. Such packages cannot be removed, so nothing may conflict with them.
+func (db *Database) Essential(pkg string) bool {
+	if db.essential == nil {
+		db.essential = make(map[string]bool)
+		for _, paragraph := range db.status() {
+			if name := paragraph.Value("Package"); name != "" {
+				db.essential[name] = paragraph.Value("Essential") == "yes"
+			}
+		}
+	}
+	return db.essential[pkg]
+}
@@ -0,0 +1,205 @@
+package dpkgdb
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDatabase(t *testing.T) {
+	root, err := ioutil.TempDir("", "dpkgdb-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	infoDir := filepath.Join(root, DefaultInfoDir)
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		t.Fatalf("Failed to create info dir: %v", err)
+	}
+
+	lists := map[string]string{
+		"coreutils.list":      "/.\n/usr\n/usr/bin\n/usr/bin/ls\n",
+		"libfoo:amd64.list":   "/usr\n/usr/lib/libfoo.so.1\n",
+		"myapp.list":          "/opt/myapp/bin/myapp\n",
+		"myapp.md5sums":       "ignored\n",
+		"busybox-static.list": "/usr/bin/ls\n",
+	}
+	for name, content := range lists {
+		if err := ioutil.WriteFile(filepath.Join(infoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	db, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if got := db.PackageCount(); got != 4 {
+		t.Errorf("PackageCount() = %d, want 4", got)
+	}
+	if !db.Installed("libfoo") || !db.Installed("myapp") || db.Installed("missing") {
+		t.Errorf("Installed() does not match the file lists")
+	}
+
+	owners := db.Owners("/usr/bin/ls")
+	if len(owners) != 2 || owners[0] != "busybox-static" || owners[1] != "coreutils" {
+		t.Errorf("Owners(/usr/bin/ls) = %v", owners)
+	}
+	if owners := db.Owners("/usr/lib/libfoo.so.1"); len(owners) != 1 || owners[0] != "libfoo" {
+		t.Errorf("Expected multi-arch owner libfoo, got %v", owners)
+	}
+
+	conflicts := db.FindConflicts([]string{"/usr/bin/ls", "/opt/myapp/bin/myapp", "/opt/other"}, "file", "myapp")
+	if len(conflicts) != 1 || conflicts[0].Path != "/usr/bin/ls" {
+		t.Fatalf("Unexpected conflicts: %v", conflicts)
+	}
+	if conflicts[0].String() != "file /usr/bin/ls is owned by installed package busybox-static, coreutils" {
+		t.Errorf("Unexpected conflict description: %s", conflicts[0])
+	}
+}
+
+func TestOpenMissingDatabase(t *testing.T) {
+	db, err := Open("/nonexistent/root")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if len(db.Owners("/usr/bin/ls")) != 0 {
+		t.Errorf("Expected empty database")
+	}
+}
+
+func TestShippers(t *testing.T) {
+	root, err := ioutil.TempDir("", "dpkgdb-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		DefaultInfoDir + "/nginx-core.list": "/usr\n/usr/sbin\n/usr/sbin/nginx\n/usr/share/nginx/html/index.html\n",
+		DefaultInfoDir + "/coreutils.list":  "/usr/bin/ls\n",
+		DefaultStatusFile:                   "Package: coreutils\nEssential: yes\nStatus: install ok installed\n\nPackage: nginx-core\nStatus: install ok installed\n",
+		DefaultListsDir + "/deb.debian.org_debian_dists_stable_main_Contents-amd64": "usr/sbin/nginx    httpd/nginx-core,httpd/nginx-light\n" +
+			"usr/share/doc/nginx/README   doc/nginx-doc\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// Contents indices apt-file downloaded with gzip compression are read too
+	gzPath := filepath.Join(root, DefaultListsDir, "deb.debian.org_debian_dists_stable_contrib_Contents-all.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", gzPath, err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("usr/games/nginx-game contrib/games/nginx-fun\nusr/bin/nginx-debug\tcontrib/httpd/nginx-dbg:amd64\n"))
+	gz.Close()
+	f.Close()
+
+	db, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	want := []Shipper{{Package: "nginx-core", Path: "/usr/sbin/nginx", Installed: true}}
+	if got := db.Shippers("nginx"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Shippers(nginx) = %+v, want %+v", got, want)
+	}
+
+	n, err := db.LoadContents()
+	if err != nil || n != 2 {
+		t.Fatalf("LoadContents() = %d, %v, want 2 indices", n, err)
+	}
+	want = append(want, Shipper{Package: "nginx-light", Path: "/usr/sbin/nginx"})
+	if got := db.Shippers("nginx"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Shippers(nginx) = %+v, want %+v", got, want)
+	}
+	if got := db.Shippers("nginx-debug"); len(got) != 1 || got[0].Package != "nginx-dbg" {
+		t.Errorf("Shippers(nginx-debug) = %+v, want the multi-arch package without qualifier", got)
+	}
+	if got := db.Shippers("README"); len(got) != 0 {
+		t.Errorf("Shippers(README) = %+v, want files outside PATH directories ignored", got)
+	}
+
+	if !db.Essential("coreutils") || db.Essential("nginx-core") || db.Essential("missing") {
+		t.Errorf("Essential() does not match the status file")
+	}
+
+	if fields, ok := db.Fields("nginx-core"); !ok || fields["Status"] != "install ok installed" {
+		t.Errorf("Fields(nginx-core) = %v, %v", fields, ok)
+	}
+	if fields, ok := db.Fields("coreutils"); !ok || fields["Essential"] != "yes" {
+		t.Errorf("Fields(coreutils) = %v, %v", fields, ok)
+	}
+	if _, ok := db.Fields("missing"); ok {
+		t.Errorf("Expected no status fields for a package that is not installed")
+	}
+	if files := db.Files("nginx-core"); len(files) != 4 || files[2] != "/usr/sbin/nginx" {
+		t.Errorf("Files(nginx-core) = %v", files)
+	}
+}
+
+func TestManaged(t *testing.T) {
+	root, err := ioutil.TempDir("", "dpkgdb-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		filepath.Join(DefaultAlternativesDir, "editor"): "auto\n/usr/bin/editor\neditor.1.gz\n/usr/share/man/man1/editor.1.gz\n\n/bin/nano\n40\n/usr/share/man/man1/nano.1.gz\n",
+		DefaultDiversionsFile:                           "/bin/sh\n/bin/sh.distrib\ndash\n/usr/bin/local-tool\n/usr/bin/local-tool.orig\n:\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	db, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	tests := []struct {
+		path        string
+		want        ManagedLink
+		installable bool
+	}{
+		{"/usr/bin/editor", ManagedLink{Path: "/usr/bin/editor", Kind: ManagedAlternative, Name: "editor"}, true},
+		{"/usr/share/man/man1/editor.1.gz", ManagedLink{Path: "/usr/share/man/man1/editor.1.gz", Kind: ManagedAlternative, Name: "editor", Slave: "editor.1.gz"}, false},
+		{"/etc/alternatives/editor", ManagedLink{Path: "/etc/alternatives/editor", Kind: ManagedAlternative, Name: "editor"}, false},
+		{"/bin/sh", ManagedLink{Path: "/bin/sh", Kind: ManagedDiversion, Name: "/bin/sh.distrib", Package: "dash"}, false},
+		{"/usr/bin/local-tool", ManagedLink{Path: "/usr/bin/local-tool", Kind: ManagedDiversion, Name: "/usr/bin/local-tool.orig"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := db.Managed(tt.path)
+			if !ok || got != tt.want {
+				t.Fatalf("Managed(%s) = %+v, %v, want %+v", tt.path, got, ok, tt.want)
+			}
+			if got.Installable() != tt.installable {
+				t.Errorf("Installable() = %v, want %v", got.Installable(), tt.installable)
+			}
+		})
+	}
+
+	if _, ok := db.Managed("/bin/nano"); ok {
+		t.Errorf("Expected alternative choices not to be managed")
+	}
+}
@@ -0,0 +1,136 @@
+package dpkgdb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultAlternativesDir is the location of the update-alternatives
+// administrative files relative to the filesystem root
+const DefaultAlternativesDir = "var/lib/dpkg/alternatives"
+
+// DefaultDiversionsFile is the location of the dpkg-divert database relative
+// to the filesystem root
+const DefaultDiversionsFile = "var/lib/dpkg/diversions"
+
+// alternativesLinkDir holds the links that alternative links point to
+const alternativesLinkDir = "/etc/alternatives"
+
+// Kinds of ManagedLink
+const (
+	ManagedAlternative = "alternative"
+	ManagedDiversion   = "diversion"
+)
+
+// ManagedLink is a path maintained by update-alternatives or dpkg-divert.
+// Creating a symlink there by hand fights with dpkg, which will overwrite or
+// misplace it on the next package operation.
+type ManagedLink struct {
+	Path    string
+	Kind    string // ManagedAlternative or ManagedDiversion
+	Name    string // Alternative name, or the path the original file was diverted to
+	Slave   string // Slave name when Path is a slave link of the alternative
+	Package string // Package holding a diversion; empty for local diversions
+}
+
+// String returns a human-readable description of the managed path
+func (m ManagedLink) String() string {
+	if m.Kind == ManagedAlternative && m.Slave != "" {
+		return fmt.Sprintf("%s is managed by update-alternatives (%s, slave of %s)", m.Path, m.Slave, m.Name)
+	}
+	if m.Kind == ManagedAlternative {
+		return fmt.Sprintf("%s is managed by update-alternatives (%s)", m.Path, m.Name)
+	}
+	holder := "a local diversion"
+	if m.Package != "" {
+		holder = "package " + m.Package
+	}
+	return fmt.Sprintf("%s is diverted to %s by %s", m.Path, m.Name, holder)
+}
+
+// Installable reports whether a symlink can be registered at the path with
+// update-alternatives --install: it is the master link of an alternative
+func (m ManagedLink) Installable() bool {
+	return m.Kind == ManagedAlternative && m.Slave == "" && filepath.Dir(m.Path) != alternativesLinkDir
+}
+
+// Managed returns how dpkg manages path, if it does: as the master or slave
+// link of an alternative, as a link in /etc/alternatives, or as a diverted
+// file. The alternatives and diversions are read on first use.
+func (db *Database) Managed(path string) (ManagedLink, bool) {
+	if db.managed == nil {
+		db.managed = make(map[string]ManagedLink)
+		db.readAlternatives()
+		db.readDiversions()
+	}
+	path = filepath.Clean(path)
+	if link, ok := db.managed[path]; ok {
+		return link, true
+	}
+	if filepath.Dir(path) == alternativesLinkDir {
+		return ManagedLink{Path: path, Kind: ManagedAlternative, Name: filepath.Base(path)}, true
+	}
+	return ManagedLink{}, false
+}
+
+// readAlternatives indexes the master and slave links of every alternative.
+// Each administrative file starts with the mode and the master link,
+// followed by pairs of slave name and slave link up to an empty line.
+func (db *Database) readAlternatives() {
+	files, _ := filepath.Glob(filepath.Join(db.root, DefaultAlternativesDir, "*"))
+	for _, file := range files {
+		lines, err := readLines(file)
+		if err != nil || len(lines) < 2 {
+			continue
+		}
+		name := filepath.Base(file)
+		db.addManaged(ManagedLink{Path: lines[1], Kind: ManagedAlternative, Name: name})
+		for i := 2; i+1 < len(lines) && lines[i] != ""; i += 2 {
+			db.addManaged(ManagedLink{Path: lines[i+1], Kind: ManagedAlternative, Name: name, Slave: lines[i]})
+		}
+	}
+}
+
+// readDiversions indexes the diverted files. The database holds three lines
+// per diversion: the diverted path, where it was diverted to, and the
+// package holding the diversion (":" for local diversions).
+func (db *Database) readDiversions() {
+	lines, err := readLines(filepath.Join(db.root, DefaultDiversionsFile))
+	if err != nil {
+		return
+	}
+	for i := 0; i+2 < len(lines); i += 3 {
+		pkg := lines[i+2]
+		if pkg == ":" {
+			pkg = ""
+		}
+		db.addManaged(ManagedLink{Path: lines[i], Kind: ManagedDiversion, Name: lines[i+1], Package: pkg})
+	}
+}
+
+// addManaged records a managed path; relative paths are malformed and ignored
+func (db *Database) addManaged(link ManagedLink) {
+	if filepath.IsAbs(link.Path) {
+		link.Path = filepath.Clean(link.Path)
+		db.managed[link.Path] = link
+	}
+}
+
+// readLines returns the lines of a file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,96 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// CommandOptions contains options for the history command
+type CommandOptions struct {
+	Limit   int
+	Details bool
+	File    string
+}
+
+// NewHistoryCommand creates a command for reviewing past run summaries
+func NewHistoryCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show summaries of previous pkginstall runs",
+		Long: `Show the summaries recorded after each pkginstall run.
+
+Every build, symlink or checkinstall run records which files were packaged,
+which symlinks will be created at install time, which maintainer scripts were
+generated and which validations were overridden.
+
+Examples:
+  pkginstall history
+  pkginstall history --limit 5 --details
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistoryCommand(options)
+		},
+	}
+
+	cmd.Flags().IntVarP(&options.Limit, "limit", "l", 20, "Maximum number of entries to show (0 for all)")
+	cmd.Flags().BoolVar(&options.Details, "details", false, "Print the full summary block for each entry")
+	cmd.Flags().StringVar(&options.File, "file", "", "History file to read (default: $XDG_STATE_HOME/pkginstall/history.jsonl)")
+
+	return cmd
+}
+
+// runHistoryCommand handles the history listing logic
+func runHistoryCommand(options *CommandOptions) error {
+	store, err := NewStore(options.File)
+	if err != nil {
+		return err
+	}
+	summaries, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	if len(summaries) == 0 {
+		fmt.Printf("No history recorded in %s\n", store.Path())
+		return nil
+	}
+
+	if options.Limit > 0 && len(summaries) > options.Limit {
+		summaries = summaries[len(summaries)-options.Limit:]
+	}
+
+	if options.Details {
+		for _, s := range summaries {
+			fmt.Printf("\n%s", s.Time.Local().Format("2006-01-02 15:04:05"))
+			s.Print(os.Stdout)
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCOMMAND\tPACKAGE\tFILES\tSYMLINKS\tSCRIPTS\tOVERRIDES\tRESULT")
+	for _, s := range summaries {
+		result := "ok"
+		if s.Error != "" {
+			result = "failed"
+		} else if s.DryRun {
+			result = "dry-run"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%d\t%s\n",
+			s.Time.Local().Format("2006-01-02 15:04:05"),
+			s.Command,
+			s.Package,
+			len(s.FilesPackaged),
+			len(s.Symlinks),
+			strings.Join(s.Scripts, ","),
+			len(s.Overrides),
+			result)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,208 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/state"
+)
+
+// Summary records the system-affecting actions performed (or planned) by a single
+// pkginstall run, so users always know what a run did or will do to their system.
+type Summary struct {
+	Command       string    `json:"command"`
+	Time          time.Time `json:"time"`
+	Package       string    `json:"package,omitempty"`
+	Output        string    `json:"output,omitempty"`
+	FilesPackaged []string  `json:"files_packaged,omitempty"`
+	Symlinks      []string  `json:"symlinks,omitempty"`   // "target -> source"
+	Scripts       []string  `json:"scripts,omitempty"`    // Maintainer scripts included in the package
+	Overrides     []string  `json:"overrides,omitempty"`  // Validations that were overridden by the user
+	Actions       []string  `json:"actions,omitempty"`    // Other changes made directly to the system
+	Conflicts     []string  `json:"conflicts,omitempty"`  // Paths already owned by installed packages
+	Privileged    []string  `json:"privileged,omitempty"` // Setuid, setgid and world-writable files
+	DryRun        bool      `json:"dry_run,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// NewSummary creates a Summary for the named command stamped with the current time.
+func NewSummary(command string) *Summary {
+	return &Summary{
+		Command: command,
+		Time:    time.Now().UTC(),
+	}
+}
+
+// AddSymlink records a symlink that was or will be created.
+func (s *Summary) AddSymlink(target, source string) {
+	s.Symlinks = append(s.Symlinks, fmt.Sprintf("%s -> %s", target, source))
+}
+
+// AddOverride records a validation that was bypassed.
+func (s *Summary) AddOverride(override string) {
+	s.Overrides = append(s.Overrides, override)
+}
+
+// AddAction records a direct change to the system.
+func (s *Summary) AddAction(action string) {
+	s.Actions = append(s.Actions, action)
+}
+
+// SetError records the error that ended the run, if any.
+func (s *Summary) SetError(err error) {
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Print writes a concise human-readable summary block to w.
+func (s *Summary) Print(w io.Writer) {
+	title := "Summary"
+	if s.DryRun {
+		title = "Summary (dry run, nothing was changed)"
+	}
+
+	fmt.Fprintf(w, "\n=== %s: %s ===\n", title, s.Command)
+	if s.Package != "" {
+		printField(w, "Package", s.Package)
+	}
+	if s.Output != "" {
+		printField(w, "Output", s.Output)
+	}
+	if s.Command == "build" || len(s.FilesPackaged) > 0 {
+		printField(w, "Files packaged", len(s.FilesPackaged))
+	}
+	printList(w, "Install-time symlinks", s.Symlinks)
+	printList(w, "Maintainer scripts", s.Scripts)
+	printList(w, "System changes", s.Actions)
+	printList(w, "Overridden checks", s.Overrides)
+	if len(s.Conflicts) > 0 {
+		printList(w, "Ownership conflicts", s.Conflicts)
+	}
+	if len(s.Privileged) > 0 {
+		printList(w, "Privileged files", s.Privileged)
+	}
+	if s.Error != "" {
+		printField(w, "Result", "FAILED ("+s.Error+")")
+	} else {
+		printField(w, "Result", "OK")
+	}
+}
+
+// printField prints a single aligned label and value
+func printField(w io.Writer, label string, value interface{}) {
+	fmt.Fprintf(w, "%-24s%v\n", label+":", value)
+}
+
+// printList prints a labelled list, or "none" if it is empty
+func printList(w io.Writer, label string, items []string) {
+	if len(items) == 0 {
+		printField(w, label, "none")
+		return
+	}
+	printField(w, label, len(items))
+	for _, item := range items {
+		fmt.Fprintf(w, "  - %s\n", item)
+	}
+}
+
+// Store persists run summaries as JSON lines in a file.
+type Store struct {
+	path string
+}
+
+// DefaultPath returns the default history file location, honouring
+// $XDG_STATE_HOME and falling back to ~/.local/state.
+func DefaultPath() (string, error) {
+	return state.Dir("history.jsonl")
+}
+
+// NewStore creates a Store backed by the given file. An empty path uses DefaultPath.
+func NewStore(path string) (*Store, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{path: path}, nil
+}
+
+// Path returns the file backing the store.
+func (st *Store) Path() string {
+	return st.path
+}
+
+// Append adds a summary to the history file, creating it if necessary.
+func (st *Store) Append(summary *Summary) error {
+	if err := os.MkdirAll(filepath.Dir(st.path), 0700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+
+	f, err := os.OpenFile(st.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	return nil
+}
+
+// List returns all stored summaries, oldest first. A missing file yields no entries.
+func (st *Store) List() ([]*Summary, error) {
+	f, err := os.Open(st.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	var summaries []*Summary
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var summary Summary
+		if err := json.Unmarshal([]byte(line), &summary); err != nil {
+			return nil, fmt.Errorf("corrupt history entry: %w", err)
+		}
+		summaries = append(summaries, &summary)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history file: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// Record prints the summary to w and appends it to the default history store.
+// Failing to persist the summary is reported to w but is not fatal.
+func Record(w io.Writer, summary *Summary) {
+	summary.Print(w)
+	store, err := NewStore("")
+	if err == nil {
+		err = store.Append(summary)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Warning: could not save run history: %v\n", err)
+	}
+}
@@ -0,0 +1,94 @@
+package history
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreAppendAndList(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "history-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore(filepath.Join(tmpDir, "nested", "history.jsonl"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	// Listing a missing file is not an error
+	summaries, err := store.List()
+	if err != nil || len(summaries) != 0 {
+		t.Fatalf("Expected empty history, got %v (err %v)", summaries, err)
+	}
+
+	first := NewSummary("build")
+	first.Package = "myapp_1.0_amd64"
+	first.FilesPackaged = []string{"/opt/bin/myapp"}
+	first.AddSymlink("/usr/bin/myapp", "/opt/bin/myapp")
+
+	second := NewSummary("symlink create")
+	second.DryRun = true
+	second.SetError(errors.New("boom"))
+
+	for _, s := range []*Summary{first, second} {
+		if err := store.Append(s); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	summaries, err = store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(summaries))
+	}
+	if summaries[0].Package != "myapp_1.0_amd64" || summaries[0].Symlinks[0] != "/usr/bin/myapp -> /opt/bin/myapp" {
+		t.Errorf("Unexpected first entry: %+v", summaries[0])
+	}
+	if !summaries[1].DryRun || summaries[1].Error != "boom" {
+		t.Errorf("Unexpected second entry: %+v", summaries[1])
+	}
+}
+
+func TestSummaryPrint(t *testing.T) {
+	s := NewSummary("build")
+	s.Package = "myapp_1.0_amd64"
+	s.FilesPackaged = []string{"/opt/bin/myapp", "/opt/etc/myapp.conf"}
+	s.Scripts = []string{"postinst"}
+	s.AddOverride("postinst script validation ignored")
+
+	var buf bytes.Buffer
+	s.Print(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"=== Summary: build ===",
+		"Files packaged:         2",
+		"Install-time symlinks:  none",
+		"Maintainer scripts:     1\n  - postinst",
+		"Overridden checks:      1\n  - postinst script validation ignored",
+		"Result:                 OK",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Print() output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	orig := os.Getenv("XDG_STATE_HOME")
+	defer os.Setenv("XDG_STATE_HOME", orig)
+
+	os.Setenv("XDG_STATE_HOME", "/tmp/state")
+	if got, err := DefaultPath(); err != nil || got != "/tmp/state/pkginstall/history.jsonl" {
+		t.Errorf("DefaultPath() = %s, %v", got, err)
+	}
+}
@@ -0,0 +1,106 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+)
+
+// The built-in actions take gitignore-style patterns matched against paths
+// relative to the staging directory, such as "*.map" or
+// "opt/usr/share/app/**/*.md". The DEBIAN control directory is never matched.
+
+// removeAction deletes the staged files and directories matching the patterns
+func removeAction(ctx context.Context, env Env, args []string) error {
+	matches, err := stagedMatches(ctx, env.StagingDir, args)
+	if err != nil {
+		return err
+	}
+	for _, relPath := range matches {
+		if err := os.RemoveAll(filepath.Join(env.StagingDir, filepath.FromSlash(relPath))); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", relPath, err)
+		}
+	}
+	return nil
+}
+
+// requireAction fails unless every pattern matches at least one staged path
+func requireAction(ctx context.Context, env Env, args []string) error {
+	var missing []string
+	for _, p := range args {
+		matches, err := stagedMatches(ctx, env.StagingDir, []string{p})
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no staged path matches %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// forbidAction fails if any staged path matches the patterns
+func forbidAction(ctx context.Context, env Env, args []string) error {
+	matches, err := stagedMatches(ctx, env.StagingDir, args)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 0 {
+		return fmt.Errorf("forbidden paths staged: %s", strings.Join(matches, ", "))
+	}
+	return nil
+}
+
+// stagedMatches returns the slash-separated paths below stagingDir matching
+// patterns. A matched directory is returned without its contents.
+func stagedMatches(ctx context.Context, stagingDir string, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("at least one pattern is required")
+	}
+	if stagingDir == "" {
+		return nil, fmt.Errorf("no staging directory")
+	}
+	matcher, err := pattern.NewMatcher(patterns, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err = filepath.Walk(stagingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(stagingDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." {
+			return nil
+		}
+		if relPath == "DEBIAN" && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if matcher.Excluded(relPath, info.IsDir()) {
+			matches = append(matches, relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan staging directory: %w", err)
+	}
+	return matches, nil
+}
@@ -0,0 +1,211 @@
+// Package hooks runs user-defined steps at fixed points of a package build,
+// such as minifying assets once the payload is staged or running extra
+// validation before the archive is written.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Point is a stage of the build at which hooks run
+type Point string
+
+const (
+	PreCopy     Point = "pre_copy"     // Before the payload is copied into the staging directory
+	PostCopy    Point = "post_copy"    // After the payload is staged, before the control files are written
+	PrePackage  Point = "pre_package"  // After the control files are written and validated, before the archive
+	PostPackage Point = "post_package" // After the .deb is written and verified
+)
+
+// Points lists the hook points in the order they run
+var Points = []Point{PreCopy, PostCopy, PrePackage, PostPackage}
+
+// Hook is a build step: an executable, or a built-in action.
+//
+// Example configuration:
+//
+//	hooks:
+//	  post_copy:
+//	    - name: minify
+//	      command: ./scripts/minify.sh
+//	    - action: remove
+//	      args: ["*.map"]
+//	  pre_package:
+//	    - action: require
+//	      args: [opt/usr/bin/myapp]
+type Hook struct {
+	Name    string   `mapstructure:"name"`    // Label used in logs and errors
+	Command string   `mapstructure:"command"` // Executable to run, relative to the working directory
+	Action  string   `mapstructure:"action"`  // Built-in action, see ActionNames
+	Args    []string `mapstructure:"args"`    // Arguments of the command or action
+}
+
+// label returns the name shown for h in logs and errors
+func (h Hook) label() string {
+	switch {
+	case h.Name != "":
+		return h.Name
+	case h.Command != "":
+		return h.Command
+	default:
+		return h.Action
+	}
+}
+
+// Hooks lists the hooks run at each point, in order
+type Hooks struct {
+	PreCopy     []Hook `mapstructure:"pre_copy"`
+	PostCopy    []Hook `mapstructure:"post_copy"`
+	PrePackage  []Hook `mapstructure:"pre_package"`
+	PostPackage []Hook `mapstructure:"post_package"`
+}
+
+// At returns the hooks that run at point
+func (h *Hooks) At(point Point) []Hook {
+	if h == nil {
+		return nil
+	}
+	switch point {
+	case PreCopy:
+		return h.PreCopy
+	case PostCopy:
+		return h.PostCopy
+	case PrePackage:
+		return h.PrePackage
+	case PostPackage:
+		return h.PostPackage
+	}
+	return nil
+}
+
+// Validate checks that every hook has either a command or a known action
+func (h *Hooks) Validate() error {
+	for _, point := range Points {
+		for i, hook := range h.At(point) {
+			switch {
+			case hook.Command != "" && hook.Action != "":
+				return fmt.Errorf("%s hook %d: set either command or action, not both", point, i+1)
+			case hook.Command == "" && hook.Action == "":
+				return fmt.Errorf("%s hook %d: command or action is required", point, i+1)
+			case hook.Action != "" && lookupAction(hook.Action) == nil:
+				return fmt.Errorf("%s hook %d: unknown action %q (available: %s)", point, i+1, hook.Action, strings.Join(ActionNames(), ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// Env describes the build a hook runs in
+type Env struct {
+	Point        Point
+	StagingDir   string // Package root being assembled, with the DEBIAN directory inside
+	SourceDir    string // Directory the payload is copied from
+	OutputPath   string // The written .deb; only set for post_package hooks of file builds
+	Package      string
+	Version      string
+	Architecture string
+	Output       io.Writer // Receives the output of commands; nil discards it
+}
+
+// Variables returns the environment variables describing env, which are
+// passed to commands
+func (e Env) Variables() []string {
+	return []string{
+		"PKGINSTALL_HOOK=" + string(e.Point),
+		"PKGINSTALL_STAGING_DIR=" + e.StagingDir,
+		"PKGINSTALL_SOURCE_DIR=" + e.SourceDir,
+		"PKGINSTALL_OUTPUT=" + e.OutputPath,
+		"PKGINSTALL_PACKAGE=" + e.Package,
+		"PKGINSTALL_VERSION=" + e.Version,
+		"PKGINSTALL_ARCH=" + e.Architecture,
+	}
+}
+
+// Run runs hooks in order and stops at the first failure
+func Run(ctx context.Context, hooks []Hook, env Env) error {
+	for _, hook := range hooks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if hook.Command != "" {
+			err = runCommand(ctx, hook, env)
+		} else if action := lookupAction(hook.Action); action != nil {
+			err = action(ctx, env, hook.Args)
+		} else {
+			err = fmt.Errorf("unknown action %q", hook.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", env.Point, hook.label(), err)
+		}
+	}
+	return nil
+}
+
+// runCommand runs the executable of hook with the build described in the
+// environment
+func runCommand(ctx context.Context, hook Hook, env Env) error {
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Env = append(os.Environ(), env.Variables()...)
+	output := env.Output
+	if output == nil {
+		output = io.Discard
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return cmd.Run()
+}
+
+// Action is a built-in hook step. It receives the build environment and the
+// arguments of the hook.
+type Action func(ctx context.Context, env Env, args []string) error
+
+var (
+	actionsMu sync.RWMutex
+	actions   = map[string]Action{
+		"remove":  removeAction,
+		"require": requireAction,
+		"forbid":  forbidAction,
+	}
+)
+
+// RegisterAction makes action available to hooks under name, so programs
+// embedding the builder can provide their own steps
+func RegisterAction(name string, action Action) error {
+	if name == "" || action == nil {
+		return fmt.Errorf("action name and function are required")
+	}
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	if _, exists := actions[name]; exists {
+		return fmt.Errorf("action %q is already registered", name)
+	}
+	actions[name] = action
+	return nil
+}
+
+// ActionNames returns the names of the available actions, sorted
+func ActionNames() []string {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupAction returns the named action, or nil
+func lookupAction(name string) Action {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	return actions[name]
+}
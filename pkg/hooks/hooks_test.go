@@ -0,0 +1,149 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stage creates a staging directory holding files
+func stage(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir("", "hooks-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	for _, name := range append(files, "DEBIAN/control") {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   *Hooks
+		wantErr string
+	}{
+		{"Nil", nil, ""},
+		{"Command and action", &Hooks{PostCopy: []Hook{{Command: "true"}, {Action: "remove", Args: []string{"*.map"}}}}, ""},
+		{"Both", &Hooks{PreCopy: []Hook{{Command: "true", Action: "remove"}}}, "pre_copy hook 1: set either command or action"},
+		{"Neither", &Hooks{PostPackage: []Hook{{Name: "empty"}}}, "post_package hook 1: command or action is required"},
+		{"Unknown action", &Hooks{PrePackage: []Hook{{Action: "minify"}}}, `unknown action "minify"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.hooks.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestActions(t *testing.T) {
+	files := []string{"opt/app/run.sh", "opt/app/app.js", "opt/app/app.js.map", "opt/app/docs/README.md"}
+	tests := []struct {
+		name    string
+		hook    Hook
+		gone    []string
+		wantErr string
+	}{
+		{"Remove by name", Hook{Action: "remove", Args: []string{"*.map"}}, []string{"opt/app/app.js.map"}, ""},
+		{"Remove directory", Hook{Action: "remove", Args: []string{"opt/app/docs"}}, []string{"opt/app/docs"}, ""},
+		{"Remove ignores control files", Hook{Action: "remove", Args: []string{"control"}}, nil, ""},
+		{"Require present", Hook{Action: "require", Args: []string{"opt/app/run.sh", "*.js"}}, nil, ""},
+		{"Require missing", Hook{Action: "require", Args: []string{"opt/app/run.sh", "*.css"}}, nil, "no staged path matches *.css"},
+		{"Forbid absent", Hook{Action: "forbid", Args: []string{"*.pem"}}, nil, ""},
+		{"Forbid present", Hook{Action: "forbid", Args: []string{"*.map"}}, nil, "forbidden paths staged: opt/app/app.js.map"},
+		{"No patterns", Hook{Action: "forbid"}, nil, "at least one pattern is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := stage(t, files...)
+			defer os.RemoveAll(dir)
+
+			err := Run(context.Background(), []Hook{tt.hook}, Env{Point: PostCopy, StagingDir: dir})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+
+			gone := make(map[string]bool)
+			for _, name := range tt.gone {
+				gone[name] = true
+			}
+			for _, name := range append(files, "DEBIAN/control") {
+				_, statErr := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+				if removed := os.IsNotExist(statErr); removed != (gone[name] || gone[filepath.ToSlash(filepath.Dir(name))]) {
+					t.Errorf("%s removed = %v", name, removed)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	dir := stage(t, "opt/app/run.sh")
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$PKGINSTALL_HOOK $PKGINSTALL_PACKAGE $1\"\ntouch \"$PKGINSTALL_STAGING_DIR/opt/app/generated\"\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write hook: %v", err)
+	}
+
+	var output bytes.Buffer
+	env := Env{Point: PostCopy, StagingDir: dir, Package: "app", Output: &output}
+	if err := Run(context.Background(), []Hook{{Command: script, Args: []string{"arg"}}}, env); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != "post_copy app arg" {
+		t.Errorf("Hook output = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "opt", "app", "generated")); err != nil {
+		t.Errorf("Hook did not change the staging directory: %v", err)
+	}
+
+	err := Run(context.Background(), []Hook{{Name: "fail", Command: "false"}, {Command: script}}, env)
+	if err == nil || !strings.Contains(err.Error(), "post_copy hook fail failed") {
+		t.Errorf("Run() error = %v, want the failing hook", err)
+	}
+}
+
+func TestRegisterAction(t *testing.T) {
+	called := false
+	action := func(ctx context.Context, env Env, args []string) error {
+		called = true
+		return nil
+	}
+	if err := RegisterAction("test-action", action); err != nil {
+		t.Fatalf("RegisterAction() error = %v", err)
+	}
+	if err := RegisterAction("remove", action); err == nil {
+		t.Errorf("Expected registering a built-in name to fail")
+	}
+	if err := Run(context.Background(), []Hook{{Action: "test-action"}}, Env{}); err != nil || !called {
+		t.Errorf("Run() error = %v, called = %v", err, called)
+	}
+}
@@ -0,0 +1,421 @@
+// Package i2p connects to the I2P network through the SAM v3 bridge of a
+// local router (Java I2P or i2pd), so packages can be served from and
+// fetched over eepsites without an HTTP proxy.
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/state"
+)
+
+// DefaultSAMAddress is where routers listen for SAM clients by default
+const DefaultSAMAddress = "127.0.0.1:7656"
+
+// signatureType selects Ed25519 destinations
+const signatureType = "7"
+
+// encoding is the base64 alphabet of I2P destinations
+var encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+var b32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Error is a failure reported by the SAM bridge
+type Error struct {
+	Result  string // RESULT value, such as CANT_REACH_PEER
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("SAM bridge: %s: %s", e.Result, e.Message)
+	}
+	return "SAM bridge: " + e.Result
+}
+
+// Addr is an I2P address, such as a .b32.i2p host name
+type Addr string
+
+// Network returns "i2p"
+func (a Addr) Network() string { return "i2p" }
+
+func (a Addr) String() string { return string(a) }
+
+// B32Address returns the .b32.i2p host name of a base64 destination
+func B32Address(dest string) (string, error) {
+	raw, err := encoding.DecodeString(dest)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return strings.ToLower(b32Encoding.EncodeToString(sum[:])) + ".b32.i2p", nil
+}
+
+// Keys are the destination and private keys of a service. Keeping them
+// keeps its .b32.i2p address.
+type Keys struct {
+	Public  string `json:"public"`  // Base64 destination peers connect to
+	Private string `json:"private"` // Base64 destination followed by the private keys
+}
+
+// Address returns the .b32.i2p host name of the keys
+func (k *Keys) Address() (string, error) {
+	return B32Address(k.Public)
+}
+
+// DefaultKeyFile returns where the keys of a named service are kept:
+// $XDG_STATE_HOME/pkginstall/i2p/<name>.keys, falling back to ~/.local/state
+func DefaultKeyFile(name string) (string, error) {
+	return state.Dir("i2p", name+".keys")
+}
+
+// GenerateKeys asks the SAM bridge for a new destination
+func GenerateKeys(ctx context.Context, samAddr string) (*Keys, error) {
+	conn, err := dialSAM(ctx, samAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	fields, err := conn.command(ctx, "DEST GENERATE SIGNATURE_TYPE="+signatureType, "DEST", "REPLY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate keys: %w", err)
+	}
+	if fields["PUB"] == "" || fields["PRIV"] == "" {
+		return nil, fmt.Errorf("failed to generate keys: incomplete reply from the SAM bridge")
+	}
+	return &Keys{Public: fields["PUB"], Private: fields["PRIV"]}, nil
+}
+
+// LoadKeys reads keys saved with Save
+func LoadKeys(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys Keys
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	if keys.Public == "" || keys.Private == "" {
+		return nil, fmt.Errorf("invalid key file %s: missing keys", path)
+	}
+	return &keys, nil
+}
+
+// Save writes the keys to path, readable only by the owner
+func (k *Keys) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to save keys: %w", err)
+	}
+	return nil
+}
+
+// LoadOrGenerateKeys reads the keys at path, or generates and saves new ones
+// if the file does not exist
+func LoadOrGenerateKeys(ctx context.Context, samAddr, path string) (*Keys, error) {
+	keys, err := LoadKeys(path)
+	if err == nil || !os.IsNotExist(err) {
+		return keys, err
+	}
+	if keys, err = GenerateKeys(ctx, samAddr); err != nil {
+		return nil, err
+	}
+	return keys, keys.Save(path)
+}
+
+// samConn is a connection to the SAM bridge that has completed the handshake
+type samConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// dialSAM connects to the SAM bridge and negotiates the protocol version
+func dialSAM(ctx context.Context, samAddr string) (*samConn, error) {
+	if samAddr == "" {
+		samAddr = DefaultSAMAddress
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", samAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SAM bridge at %s (is the I2P router running with SAM enabled?): %w", samAddr, err)
+	}
+	c := &samConn{Conn: conn, r: bufio.NewReader(conn)}
+	if _, err := c.command(ctx, "HELLO VERSION MIN=3.1 MAX=3.3", "HELLO", "REPLY"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// command sends a line and reads the reply, which must start with topic
+// and kind and, if it has a result, report success
+func (c *samConn) command(ctx context.Context, line, topic, kind string) (map[string]string, error) {
+	// Cancelling the context interrupts the exchange
+	stop := context.AfterFunc(ctx, func() { c.Conn.SetDeadline(time.Unix(1, 0)) })
+	reply, err := c.exchange(line)
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	words, fields := parseReply(reply)
+	if len(words) < 2 || words[0] != topic || words[1] != kind {
+		return nil, fmt.Errorf("unexpected reply from the SAM bridge: %q", strings.TrimSpace(reply))
+	}
+	if result, ok := fields["RESULT"]; ok && result != "OK" {
+		return nil, &Error{Result: result, Message: fields["MESSAGE"]}
+	}
+	return fields, nil
+}
+
+func (c *samConn) exchange(line string) (string, error) {
+	if _, err := io.WriteString(c.Conn, line+"\n"); err != nil {
+		return "", fmt.Errorf("failed to send to the SAM bridge: %w", err)
+	}
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("SAM bridge closed the connection: %w", err)
+	}
+	return reply, nil
+}
+
+// parseReply splits a reply line into its leading words and KEY=VALUE
+// fields. Values may be quoted.
+func parseReply(line string) ([]string, map[string]string) {
+	var words []string
+	fields := make(map[string]string)
+	line = strings.TrimRight(line, "\r\n")
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		var token strings.Builder
+		quoted := false
+	scan:
+		for ; i < len(line); i++ {
+			switch c := line[i]; {
+			case c == '\\' && quoted && i+1 < len(line):
+				i++
+				token.WriteByte(line[i])
+			case c == '"':
+				quoted = !quoted
+			case c == ' ' && !quoted:
+				break scan
+			default:
+				token.WriteByte(c)
+			}
+		}
+		if key, value, ok := strings.Cut(token.String(), "="); ok {
+			fields[key] = value
+		} else {
+			words = append(words, token.String())
+		}
+	}
+	return words, fields
+}
+
+// Session is a stream session of the SAM bridge. Its destination lives as
+// long as the session.
+type Session struct {
+	samAddr string
+	id      string
+	keys    Keys
+	address string
+
+	mu   sync.Mutex // Serializes commands on ctrl
+	ctrl *samConn
+}
+
+// NewSession creates a stream session with the given keys, or with a new
+// transient destination if keys is nil. Building the tunnels may take a
+// minute on a fresh router.
+func NewSession(ctx context.Context, samAddr string, keys *Keys) (*Session, error) {
+	if samAddr == "" {
+		samAddr = DefaultSAMAddress
+	}
+	ctrl, err := dialSAM(ctx, samAddr)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 6)
+	rand.Read(id)
+	s := &Session{samAddr: samAddr, id: "pkginstall-" + hex.EncodeToString(id), ctrl: ctrl}
+
+	destination := "TRANSIENT"
+	if keys != nil {
+		destination = keys.Private
+	}
+	fields, err := ctrl.command(ctx, fmt.Sprintf("SESSION CREATE STYLE=STREAM ID=%s DESTINATION=%s SIGNATURE_TYPE=%s", s.id, destination, signatureType), "SESSION", "STATUS")
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("failed to create I2P session: %w", err)
+	}
+	s.keys.Private = fields["DESTINATION"]
+	if keys != nil {
+		s.keys = *keys
+	} else if s.keys.Public, err = s.Lookup(ctx, "ME"); err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if s.address, err = B32Address(s.keys.Public); err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Address returns the .b32.i2p host name of the session
+func (s *Session) Address() string {
+	return s.address
+}
+
+// Close ends the session and the streams it accepted
+func (s *Session) Close() error {
+	return s.ctrl.Close()
+}
+
+// Lookup resolves a host name, such as example.i2p or a .b32.i2p name, to
+// its base64 destination
+func (s *Session) Lookup(ctx context.Context, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields, err := s.ctrl.command(ctx, "NAMING LOOKUP NAME="+name, "NAMING", "REPLY")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	return fields["VALUE"], nil
+}
+
+// DialContext opens a stream to the I2P host in addr. The port is ignored.
+// It can serve as the DialContext of an http.Transport.
+func (s *Session) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	destination, err := s.Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialSAM(ctx, s.samAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.command(ctx, fmt.Sprintf("STREAM CONNECT ID=%s DESTINATION=%s SILENT=false", s.id, destination), "STREAM", "STATUS"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+	return &streamConn{samConn: conn, local: Addr(s.address), remote: Addr(host)}, nil
+}
+
+// Listen returns a listener for streams to the session's destination
+func (s *Session) Listen() net.Listener {
+	return &listener{session: s, closed: make(chan struct{})}
+}
+
+// streamConn is a stream through the SAM bridge
+type streamConn struct {
+	*samConn
+	local, remote Addr
+}
+
+// Read reads from the buffer that may already hold stream data
+func (c *streamConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+// listener accepts one stream per SAM connection
+type listener struct {
+	session *Session
+	once    sync.Once
+	closed  chan struct{}
+}
+
+// Accept waits for the next peer
+func (l *listener) Accept() (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, err := dialSAM(ctx, l.session.samAddr)
+	if err != nil {
+		return nil, l.closedOr(err)
+	}
+	if _, err := conn.command(ctx, "STREAM ACCEPT ID="+l.session.id+" SILENT=false", "STREAM", "STATUS"); err != nil {
+		conn.Close()
+		return nil, l.closedOr(err)
+	}
+	// The bridge sends the peer's destination when a stream arrives
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	line, err := conn.r.ReadString('\n')
+	if !stop() || err != nil {
+		conn.Close()
+		return nil, l.closedOr(fmt.Errorf("SAM bridge closed the connection: %w", err))
+	}
+	remote := Addr("unknown")
+	// Destinations may end in base64 padding, so this is not a KEY=VALUE field
+	if peer := strings.Fields(line); len(peer) > 0 {
+		if address, err := B32Address(peer[0]); err == nil {
+			remote = Addr(address)
+		}
+	}
+	return &streamConn{samConn: conn, local: Addr(l.session.address), remote: remote}, nil
+}
+
+// closedOr returns net.ErrClosed after Close, and err otherwise
+func (l *listener) closedOr(err error) error {
+	select {
+	case <-l.closed:
+		return net.ErrClosed
+	default:
+		return err
+	}
+}
+
+// Close stops accepting streams. The session stays open.
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return Addr(l.session.address) }
+
+// IsI2PHost reports whether host, with or without a port, is on the I2P
+// network
+func IsI2PHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.HasSuffix(strings.ToLower(host), ".i2p")
+}
@@ -0,0 +1,234 @@
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBridge implements enough of SAM v3 to connect sessions to each other
+type fakeBridge struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	sessions map[string]string        // Session ID to public destination
+	accepts  map[string]chan *samConn // Public destination to waiting acceptors
+}
+
+func newFakeBridge(t *testing.T) *fakeBridge {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	b := &fakeBridge{ln: ln, sessions: map[string]string{}, accepts: map[string]chan *samConn{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&samConn{Conn: conn, r: bufio.NewReader(conn)})
+		}
+	}()
+	return b
+}
+
+func fakeDestination() string {
+	raw := make([]byte, 391)
+	rand.Read(raw)
+	return encoding.EncodeToString(raw)
+}
+
+func (b *fakeBridge) acceptors(dest string) chan *samConn {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.accepts[dest] == nil {
+		b.accepts[dest] = make(chan *samConn, 4)
+	}
+	return b.accepts[dest]
+}
+
+func (b *fakeBridge) serve(conn *samConn) {
+	var public string
+	for {
+		line, err := conn.r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return
+		}
+		words, fields := parseReply(line)
+		reply := func(format string, args ...interface{}) { fmt.Fprintf(conn, format+"\n", args...) }
+		switch strings.Join(words, " ") {
+		case "HELLO VERSION":
+			reply("HELLO REPLY RESULT=OK VERSION=3.3")
+		case "DEST GENERATE":
+			dest := fakeDestination()
+			reply("DEST REPLY PUB=%s PRIV=%sPRIV", dest, dest)
+		case "SESSION CREATE":
+			public = strings.TrimSuffix(fields["DESTINATION"], "PRIV")
+			if public == "TRANSIENT" {
+				public = fakeDestination()
+			}
+			b.mu.Lock()
+			b.sessions[fields["ID"]] = public
+			b.mu.Unlock()
+			reply("SESSION STATUS RESULT=OK DESTINATION=%sPRIV", public)
+		case "NAMING LOOKUP":
+			name := fields["NAME"]
+			if name == "ME" {
+				reply("NAMING REPLY RESULT=OK NAME=ME VALUE=%s", public)
+				continue
+			}
+			b.mu.Lock()
+			var found string
+			for _, dest := range b.sessions {
+				if address, _ := B32Address(dest); address == name {
+					found = dest
+				}
+			}
+			b.mu.Unlock()
+			if found == "" {
+				reply("NAMING REPLY RESULT=KEY_NOT_FOUND NAME=%s MESSAGE=\"no such host\"", name)
+			} else {
+				reply("NAMING REPLY RESULT=OK NAME=%s VALUE=%s", name, found)
+			}
+		case "STREAM ACCEPT":
+			b.mu.Lock()
+			dest := b.sessions[fields["ID"]]
+			b.mu.Unlock()
+			reply("STREAM STATUS RESULT=OK")
+			b.acceptors(dest) <- conn
+			return
+		case "STREAM CONNECT":
+			b.mu.Lock()
+			from := b.sessions[fields["ID"]]
+			b.mu.Unlock()
+			var peer *samConn
+			select {
+			case peer = <-b.acceptors(fields["DESTINATION"]):
+			case <-time.After(2 * time.Second):
+				reply("STREAM STATUS RESULT=CANT_REACH_PEER")
+				continue
+			}
+			reply("STREAM STATUS RESULT=OK")
+			fmt.Fprintf(peer, "%s FROM_PORT=0 TO_PORT=0\n", from)
+			go func() {
+				io.Copy(peer, conn.r)
+				peer.Close()
+			}()
+			io.Copy(conn, peer.r)
+			conn.Close()
+			return
+		default:
+			reply("%s RESULT=I2P_ERROR MESSAGE=\"unknown command\"", strings.Join(words, " "))
+		}
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		line   string
+		words  []string
+		fields map[string]string
+	}{
+		{"HELLO REPLY RESULT=OK VERSION=3.3\n", []string{"HELLO", "REPLY"}, map[string]string{"RESULT": "OK", "VERSION": "3.3"}},
+		{`SESSION STATUS RESULT=I2P_ERROR MESSAGE="tunnel build \"failed\""`, []string{"SESSION", "STATUS"},
+			map[string]string{"RESULT": "I2P_ERROR", "MESSAGE": `tunnel build "failed"`}},
+		{"NAMING  REPLY VALUE=a=b\r\n", []string{"NAMING", "REPLY"}, map[string]string{"VALUE": "a=b"}},
+	}
+	for _, tt := range tests {
+		words, fields := parseReply(tt.line)
+		if !reflect.DeepEqual(words, tt.words) || !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("parseReply(%q) = %v, %v; want %v, %v", tt.line, words, fields, tt.words, tt.fields)
+		}
+	}
+}
+
+func TestSessionStreams(t *testing.T) {
+	bridge := newFakeBridge(t)
+	defer bridge.ln.Close()
+	samAddr := bridge.ln.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tmpDir, err := ioutil.TempDir("", "i2p-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	keyFile := filepath.Join(tmpDir, "keys", "repo.keys")
+	keys, err := LoadOrGenerateKeys(ctx, samAddr, keyFile)
+	if err != nil {
+		t.Fatalf("LoadOrGenerateKeys() error = %v", err)
+	}
+	if again, err := LoadOrGenerateKeys(ctx, samAddr, keyFile); err != nil || again.Public != keys.Public {
+		t.Fatalf("saved keys were not reused: %v", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file is not private: %v", err)
+	}
+
+	server, err := NewSession(ctx, samAddr, keys)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer server.Close()
+	if address, _ := keys.Address(); server.Address() != address || !strings.HasSuffix(address, ".b32.i2p") {
+		t.Errorf("session address = %s, want %s", server.Address(), address)
+	}
+	client, err := NewSession(ctx, samAddr, nil)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer client.Close()
+
+	listener := server.Listen()
+	defer listener.Close()
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.RemoteAddr)
+	}))
+
+	httpClient := &http.Client{Transport: &http.Transport{DialContext: client.DialContext}}
+	resp, err := httpClient.Get("http://" + server.Address() + "/")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := "hello " + client.Address(); string(body) != want {
+		t.Errorf("response = %q, want %q", body, want)
+	}
+
+	_, err = client.DialContext(ctx, "tcp", "unknown.b32.i2p:80")
+	var samErr *Error
+	if !errors.As(err, &samErr) || samErr.Result != "KEY_NOT_FOUND" {
+		t.Errorf("DialContext() error = %v, want KEY_NOT_FOUND", err)
+	}
+}
+
+func TestIsI2PHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"example.i2p":         true,
+		"abc.b32.i2p:80":      true,
+		"EXAMPLE.I2P":         true,
+		"example.org":         false,
+		"127.0.0.1:8080":      false,
+		"i2p.example.org:443": false,
+	} {
+		if got := IsI2PHost(host); got != want {
+			t.Errorf("IsI2PHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
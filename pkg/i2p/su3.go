@@ -0,0 +1,266 @@
+package i2p
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/state"
+)
+
+// su3Magic starts every su3 file
+const su3Magic = "I2Psu3"
+
+// su3HeaderSize is the size of the fixed su3 header
+const su3HeaderSize = 40
+
+// su3MinVersionLength is the minimum length of the padded version field
+const su3MinVersionLength = 16
+
+// DefaultSigningKeyBits is the size of generated su3 signing keys; routers
+// expect RSA-SHA512-4096 for plugins
+const DefaultSigningKeyBits = 4096
+
+// File types of su3 content
+const (
+	SU3FileZip = 0x00
+	SU3FileXML = 0x01
+)
+
+// Content types of su3 files
+const (
+	SU3ContentUnknown      = 0x00
+	SU3ContentRouterUpdate = 0x01
+	SU3ContentPlugin       = 0x02
+	SU3ContentReseed       = 0x03
+)
+
+// su3SigTypes are the RSA signature types by key size
+var su3SigTypes = map[int]struct {
+	code uint16
+	hash crypto.Hash
+}{
+	2048: {0x0004, crypto.SHA256},
+	3072: {0x0005, crypto.SHA384},
+	4096: {0x0006, crypto.SHA512},
+}
+
+// ErrSU3Signature reports an su3 file whose signature does not verify
+var ErrSU3Signature = errors.New("su3 signature does not verify")
+
+// SU3Info describes the content of an su3 file
+type SU3Info struct {
+	Version     string // Version of the content, such as the plugin version
+	Signer      string // ID of the signer, such as an email address
+	FileType    byte
+	ContentType byte
+}
+
+// WriteSU3 writes content of the given size, read from r, as an su3 file
+// signed with key to w
+func WriteSU3(w io.Writer, info SU3Info, r io.Reader, size int64, key *rsa.PrivateKey) error {
+	sigType, ok := su3SigTypes[key.N.BitLen()]
+	if !ok {
+		return fmt.Errorf("su3 signing keys must have 2048, 3072 or 4096 bits, not %d", key.N.BitLen())
+	}
+	header, err := su3Header(info, size, sigType.code, uint16(key.Size()))
+	if err != nil {
+		return err
+	}
+
+	hash := sigType.hash.New()
+	hash.Write(header)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	n, err := io.Copy(io.MultiWriter(w, hash), r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("su3 content has %d bytes, expected %d", n, size)
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, sigType.hash, hash.Sum(nil))
+	if err != nil {
+		return fmt.Errorf("failed to sign su3 file: %w", err)
+	}
+	_, err = w.Write(signature)
+	return err
+}
+
+// su3Header returns the header, version and signer ID that precede the content
+func su3Header(info SU3Info, size int64, sigType, sigLength uint16) ([]byte, error) {
+	if info.Signer == "" || len(info.Signer) > 255 {
+		return nil, fmt.Errorf("su3 signer ID must have 1 to 255 bytes")
+	}
+	versionLength := len(info.Version)
+	if versionLength > 255 {
+		return nil, fmt.Errorf("su3 version must have at most 255 bytes")
+	}
+	if versionLength < su3MinVersionLength {
+		versionLength = su3MinVersionLength
+	}
+
+	header := make([]byte, su3HeaderSize, su3HeaderSize+versionLength+len(info.Signer))
+	copy(header, su3Magic)
+	binary.BigEndian.PutUint16(header[8:], sigType)
+	binary.BigEndian.PutUint16(header[10:], sigLength)
+	header[13] = byte(versionLength)
+	header[15] = byte(len(info.Signer))
+	binary.BigEndian.PutUint64(header[16:], uint64(size))
+	header[25] = info.FileType
+	header[27] = info.ContentType
+
+	version := make([]byte, versionLength)
+	copy(version, info.Version)
+	header = append(header, version...)
+	return append(header, info.Signer...), nil
+}
+
+// ReadSU3 parses an su3 file, verifies its signature with key and returns
+// its description and content
+func ReadSU3(data []byte, key *rsa.PublicKey) (*SU3Info, []byte, error) {
+	if len(data) < su3HeaderSize || string(data[:len(su3Magic)]) != su3Magic {
+		return nil, nil, fmt.Errorf("not an su3 file")
+	}
+	sigType := binary.BigEndian.Uint16(data[8:])
+	sigLength := int(binary.BigEndian.Uint16(data[10:]))
+	versionLength, signerLength := int(data[13]), int(data[15])
+	size := binary.BigEndian.Uint64(data[16:])
+	contentStart := su3HeaderSize + versionLength + signerLength
+	if size > uint64(len(data)) || contentStart+int(size)+sigLength != len(data) {
+		return nil, nil, fmt.Errorf("su3 file is truncated or has trailing data")
+	}
+	contentEnd := contentStart + int(size)
+
+	var hash crypto.Hash
+	for _, t := range su3SigTypes {
+		if t.code == sigType {
+			hash = t.hash
+		}
+	}
+	if hash == 0 {
+		return nil, nil, fmt.Errorf("unsupported su3 signature type %d", sigType)
+	}
+	h := hash.New()
+	h.Write(data[:contentEnd])
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), data[contentEnd:]); err != nil {
+		return nil, nil, ErrSU3Signature
+	}
+
+	info := &SU3Info{
+		Version:     string(bytes.TrimRight(data[su3HeaderSize:su3HeaderSize+versionLength], "\x00")),
+		Signer:      string(data[su3HeaderSize+versionLength : contentStart]),
+		FileType:    data[25],
+		ContentType: data[27],
+	}
+	return info, data[contentStart:contentEnd], nil
+}
+
+// DefaultSigningKeyFile returns where the su3 signing key of a signer is
+// kept: $XDG_STATE_HOME/pkginstall/i2p/<signer>.su3.pem
+func DefaultSigningKeyFile(signer string) (string, error) {
+	return state.Dir("i2p", signer+".su3.pem")
+}
+
+// CertificateFile returns the name routers expect for the certificate of a
+// signer in their certificates/plugin directory
+func CertificateFile(signer string) string {
+	return strings.ReplaceAll(signer, "@", "_at_") + ".crt"
+}
+
+// LoadOrGenerateSigningKey reads the PEM RSA key at path. If it does not
+// exist, a new key is generated and saved, with a self-signed certificate
+// for signer next to it. It reports whether the key was generated.
+func LoadOrGenerateSigningKey(path, signer string) (*rsa.PrivateKey, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid signing key %s: %w", path, err)
+		}
+		return key, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, DefaultSigningKeyBits)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, false, fmt.Errorf("failed to save signing key: %w", err)
+	}
+	if err := WriteCertificate(filepath.Join(filepath.Dir(path), CertificateFile(signer)), key, signer); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// parseSigningKey parses a PKCS#8 or PKCS#1 PEM RSA private key
+func parseSigningKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("su3 signing keys must be RSA keys")
+	}
+	return key, nil
+}
+
+// WriteCertificate writes a self-signed certificate of key for signer, to be
+// copied into the certificates/plugin directory of routers that install
+// the signer's plugins
+func WriteCertificate(path string, key *rsa.PrivateKey, signer string) error {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: signer, Organization: []string{"I2P Anonymous Network"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package i2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSU3RoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	content := []byte("plugin archive")
+	info := SU3Info{Version: "1.2", Signer: "demo@example.com", FileType: SU3FileZip, ContentType: SU3ContentPlugin}
+
+	var buf bytes.Buffer
+	if err := WriteSU3(&buf, info, bytes.NewReader(content), int64(len(content)), key); err != nil {
+		t.Fatalf("WriteSU3() error = %v", err)
+	}
+	got, data, err := ReadSU3(buf.Bytes(), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("ReadSU3() error = %v", err)
+	}
+	if *got != info || !bytes.Equal(data, content) {
+		t.Errorf("ReadSU3() = %+v, %q", got, data)
+	}
+
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[len(tampered)-key.Size()-1] ^= 0xff
+	if _, _, err := ReadSU3(tampered, &key.PublicKey); !errors.Is(err, ErrSU3Signature) {
+		t.Errorf("ReadSU3() of tampered content error = %v", err)
+	}
+	if err := WriteSU3(&buf, info, bytes.NewReader(content), int64(len(content))+1, key); err == nil {
+		t.Errorf("WriteSU3() with a wrong size should fail")
+	}
+}
+
+func TestLoadOrGenerateSigningKeyExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "su3-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	path := filepath.Join(dir, "demo.su3.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	got, generated, err := LoadOrGenerateSigningKey(path, "demo@example.com")
+	if err != nil || generated {
+		t.Fatalf("LoadOrGenerateSigningKey() = %v, %v", generated, err)
+	}
+	if !got.Equal(key) {
+		t.Errorf("LoadOrGenerateSigningKey() returned a different key")
+	}
+	if err := WriteCertificate(filepath.Join(dir, CertificateFile("demo@example.com")), key, "demo@example.com"); err != nil {
+		t.Fatalf("WriteCertificate() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "demo_at_example.com.crt")); err != nil {
+		t.Errorf("Missing certificate: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/spf13/cobra"
+)
+
+// CommandOptions contains options for the install and remove commands
+type CommandOptions struct {
+	Verbose bool
+	DryRun  bool
+	Force   bool
+	Root    string
+	Purge   bool
+	List    bool
+	Policy  string
+	Profile string
+}
+
+// NewInstallCommand creates a command that installs a .deb with dpkg after pre-flight checks
+func NewInstallCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "install [flags] <package.deb>",
+		Short: "Install a package with dpkg after safety checks",
+		Long: `Install a .deb package with dpkg after running pre-flight checks.
+
+Before dpkg is invoked, the package payload is compared against the files
+already present on the system and every maintainer script is run through
+the script validator. Installation is refused if files would be overwritten
+or a script fails validation, unless --force is given.
+
+Examples:
+  pkginstall install --dry-run myapp_1.0_amd64.deb
+  sudo pkginstall install myapp_1.0_amd64.deb
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInstallCommand(args[0], options)
+		},
+	}
+
+	addCommonFlags(cmd, options)
+	return cmd
+}
+
+// NewRemoveCommand creates a command that removes an installed package with dpkg
+func NewRemoveCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "remove [flags] <package-name>",
+		Short: "Remove an installed package with dpkg after safety checks",
+		Long: `Remove an installed package with dpkg after running pre-flight checks.
+
+The files owned by the package are listed and its prerm/postrm scripts are
+run through the script validator before dpkg is invoked.
+
+Examples:
+  pkginstall remove --dry-run myapp
+  sudo pkginstall remove --purge myapp
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRemoveCommand(args[0], options)
+		},
+	}
+
+	addCommonFlags(cmd, options)
+	cmd.Flags().BoolVar(&options.Purge, "purge", false, "Also remove configuration files (dpkg -P)")
+	return cmd
+}
+
+// NewRollbackCommand creates a command that undoes a recorded installation
+func NewRollbackCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "rollback [flags] [manifest-id]",
+		Short: "Undo an installation or forced symlink creation",
+		Long: `Restore the state recorded before a previous pkginstall run.
+
+Every "install" and "symlink create" run saves a manifest of the files,
+symlinks and maintainer scripts it touched, together with backups of any
+files it displaced. Rollback removes the installed package (or reinstalls
+the previous version from the apt cache), removes created symlinks and
+restores the displaced files. Without an ID the most recent run that has
+not yet been rolled back is used.
+
+Examples:
+  pkginstall rollback --list
+  pkginstall rollback --dry-run
+  sudo pkginstall rollback 20240101T120000.000000000-install
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := ""
+			if len(args) > 0 {
+				id = args[0]
+			}
+			return runRollbackCommand(id, options)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
+	cmd.Flags().BoolVarP(&options.DryRun, "dry-run", "n", false, "Show what would be restored without changing the system")
+	cmd.Flags().BoolVarP(&options.Force, "force", "f", false, "Proceed even if pre-flight checks on removal scripts fail (NOT RECOMMENDED)")
+	cmd.Flags().BoolVarP(&options.List, "list", "l", false, "List recorded manifests instead of rolling back")
+	cmd.Flags().StringVar(&options.Policy, "policy", "", "Security policy file (YAML or JSON) used to validate removal scripts")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
+	return cmd
+}
+
+// addCommonFlags registers the flags shared by install and remove
+func addCommonFlags(cmd *cobra.Command, options *CommandOptions) {
+	cmd.Flags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
+	cmd.Flags().BoolVarP(&options.DryRun, "dry-run", "n", false, "Show the pre-flight report and planned dpkg call without changing the system")
+	cmd.Flags().BoolVarP(&options.Force, "force", "f", false, "Proceed even if pre-flight checks fail (NOT RECOMMENDED)")
+	cmd.Flags().StringVar(&options.Root, "root", "/", "Alternate filesystem root passed to dpkg --root")
+	cmd.Flags().StringVar(&options.Policy, "policy", "", "Security policy file (YAML or JSON) used to validate maintainer scripts")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
+}
+
+// newInstallerFromOptions creates an Installer configured from command options
+func newInstallerFromOptions(options *CommandOptions) (*Installer, error) {
+	store, err := manifest.NewStore("")
+	if err != nil {
+		return nil, fmt.Errorf("cannot record changes for rollback: %w", err)
+	}
+	opts := []InstallerOption{
+		WithRoot(options.Root),
+		WithDryRun(options.DryRun),
+		WithForce(options.Force),
+		WithInstallerVerbose(options.Verbose),
+		WithManifestStore(store),
+	}
+
+	profile, err := security.LookupProfile(options.Profile)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithScriptValidatorOptions(profile.ScriptValidatorOptions()...))
+
+	if options.Policy != "" {
+		policy, err := security.LoadPolicyFile(options.Policy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithScriptValidatorOptions(policy.ScriptValidatorOptions()...))
+	}
+
+	return NewInstaller(opts...), nil
+}
+
+// runInstallCommand handles the install logic
+func runInstallCommand(debPath string, options *CommandOptions) error {
+	absPath, err := filepath.Abs(debPath)
+	if err != nil {
+		return fmt.Errorf("invalid package path: %w", err)
+	}
+	if _, err := os.Stat(absPath); err != nil {
+		return fmt.Errorf("package file error: %w", err)
+	}
+
+	installer, err := newInstallerFromOptions(options)
+	if err != nil {
+		return err
+	}
+
+	summary, err := installer.Install(absPath)
+	summary.SetError(err)
+	history.Record(os.Stdout, summary)
+	return err
+}
+
+// runRemoveCommand handles the remove logic
+func runRemoveCommand(name string, options *CommandOptions) error {
+	installer, err := newInstallerFromOptions(options)
+	if err != nil {
+		return err
+	}
+
+	summary, err := installer.Remove(name, options.Purge)
+	summary.SetError(err)
+	history.Record(os.Stdout, summary)
+	return err
+}
+
+// runRollbackCommand handles the rollback logic
+func runRollbackCommand(id string, options *CommandOptions) error {
+	store, err := manifest.NewStore("")
+	if err != nil {
+		return err
+	}
+
+	if options.List {
+		manifests, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(manifests) == 0 {
+			fmt.Printf("No manifests recorded in %s\n", store.Dir())
+		}
+		for _, m := range manifests {
+			state := ""
+			if m.RolledBack {
+				state = " (rolled back)"
+			}
+			fmt.Printf("%s  %-16s %s%s\n", m.ID, m.Command, m.Package, state)
+		}
+		return nil
+	}
+
+	var m *manifest.Manifest
+	if id == "" {
+		m, err = store.Latest()
+	} else {
+		m, err = store.Load(id)
+	}
+	if err != nil {
+		return err
+	}
+	if m.RolledBack {
+		return fmt.Errorf("manifest %s has already been rolled back", m.ID)
+	}
+
+	// Operate on the filesystem root the original run used
+	options.Root = m.Root
+	installer, err := newInstallerFromOptions(options)
+	if err != nil {
+		return err
+	}
+
+	summary, err := installer.Rollback(m)
+	summary.SetError(err)
+	history.Record(os.Stdout, summary)
+	return err
+}
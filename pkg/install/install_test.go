@@ -0,0 +1,339 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+)
+
+// buildTestDeb creates a small .deb with dpkg-deb, skipping the test if it is unavailable
+func buildTestDeb(t *testing.T, dir string, scripts map[string]string) string {
+	if _, err := exec.LookPath("dpkg-deb"); err != nil {
+		t.Skip("dpkg-deb not available")
+	}
+
+	root := filepath.Join(dir, "pkgroot")
+	files := map[string]string{
+		"DEBIAN/control":          "Package: testpkg\nVersion: 1.0\nArchitecture: all\nMaintainer: Test <test@example.com>\nDescription: test\n",
+		"opt/testpkg/bin/app":     "#!/bin/sh\necho hi\n",
+		"opt/testpkg/etc/app.cfg": "key=value\n",
+	}
+	for name, content := range scripts {
+		files["DEBIAN/"+name] = content
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	debPath := filepath.Join(dir, "testpkg_1.0_all.deb")
+	out, err := exec.Command("dpkg-deb", "--root-owner-group", "--build", root, debPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("dpkg-deb failed: %v: %s", err, out)
+	}
+	return debPath
+}
+
+// stubDpkg replaces the dpkg helpers for the duration of a test
+func stubDpkg(t *testing.T) *[]string {
+	origRun, origQuery, origEuid := runDpkg, dpkgQuery, geteuid
+	t.Cleanup(func() { runDpkg, dpkgQuery, geteuid = origRun, origQuery, origEuid })
+
+	var calls []string
+	runDpkg = func(args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return nil
+	}
+	dpkgQuery = func(args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("not installed")
+	}
+	geteuid = func() int { return 1000 }
+	return &calls
+}
+
+func TestInspect(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "install-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	debPath := buildTestDeb(t, tmpDir, map[string]string{"postinst": "#!/bin/sh\necho installed\n"})
+
+	info, err := Inspect(debPath)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+
+	if info.Name != "testpkg" || info.Version != "1.0" || info.Architecture != "all" {
+		t.Errorf("Unexpected metadata: %s %s %s", info.Name, info.Version, info.Architecture)
+	}
+	if _, ok := info.Scripts["postinst"]; !ok {
+		t.Errorf("Expected postinst script to be extracted")
+	}
+
+	var paths []string
+	for _, f := range info.Files {
+		if !f.IsDir {
+			paths = append(paths, f.Path)
+		}
+	}
+	if strings.Join(paths, ",") != "/opt/testpkg/bin/app,/opt/testpkg/etc/app.cfg" {
+		t.Errorf("Unexpected payload files: %v", paths)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "install-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	debPath := buildTestDeb(t, tmpDir, nil)
+	root := filepath.Join(tmpDir, "root")
+
+	t.Run("Clean install", func(t *testing.T) {
+		calls := stubDpkg(t)
+		var out bytes.Buffer
+		installer := NewInstaller(WithRoot(root), WithOutput(&out))
+
+		summary, err := installer.Install(debPath)
+		if err != nil {
+			t.Fatalf("Install() error = %v", err)
+		}
+		if len(*calls) != 1 || !strings.HasPrefix((*calls)[0], "--root="+root+" -i ") {
+			t.Errorf("Unexpected dpkg calls: %v", *calls)
+		}
+		if len(summary.FilesPackaged) != 2 || len(summary.Actions) != 1 {
+			t.Errorf("Unexpected summary: %+v", summary)
+		}
+	})
+
+	t.Run("Dry run does not call dpkg", func(t *testing.T) {
+		calls := stubDpkg(t)
+		var out bytes.Buffer
+		installer := NewInstaller(WithRoot(root), WithDryRun(true), WithOutput(&out))
+
+		if _, err := installer.Install(debPath); err != nil {
+			t.Fatalf("Install() error = %v", err)
+		}
+		if len(*calls) != 0 {
+			t.Errorf("Expected no dpkg calls in dry run, got %v", *calls)
+		}
+		if !strings.Contains(out.String(), "[DRY RUN] Would run: dpkg") {
+			t.Errorf("Expected dry run preview, got:\n%s", out.String())
+		}
+	})
+
+	// Create a file that the package would overwrite
+	existing := filepath.Join(root, "opt", "testpkg", "etc", "app.cfg")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("local"), 0644); err != nil {
+		t.Fatalf("Failed to write existing file: %v", err)
+	}
+
+	t.Run("Conflict blocks install", func(t *testing.T) {
+		calls := stubDpkg(t)
+		var out bytes.Buffer
+		installer := NewInstaller(WithRoot(root), WithOutput(&out))
+
+		if _, err := installer.Install(debPath); err == nil {
+			t.Fatalf("Expected conflict to block installation")
+		}
+		if len(*calls) != 0 {
+			t.Errorf("Expected no dpkg calls, got %v", *calls)
+		}
+		if !strings.Contains(out.String(), "/opt/testpkg/etc/app.cfg: file already exists") {
+			t.Errorf("Expected conflict in report, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Force overrides conflict", func(t *testing.T) {
+		calls := stubDpkg(t)
+		var out bytes.Buffer
+		installer := NewInstaller(WithRoot(root), WithForce(true), WithOutput(&out))
+
+		summary, err := installer.Install(debPath)
+		if err != nil {
+			t.Fatalf("Install() error = %v", err)
+		}
+		if len(*calls) != 1 || len(summary.Overrides) != 1 {
+			t.Errorf("Expected forced install with recorded override, calls %v, summary %+v", *calls, summary)
+		}
+	})
+
+	// Record ownership of the file in the dpkg database below root
+	infoDir := filepath.Join(root, "var", "lib", "dpkg", "info")
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		t.Fatalf("Failed to create dpkg info dir: %v", err)
+	}
+	listPath := filepath.Join(infoDir, "testpkg.list")
+	if err := ioutil.WriteFile(listPath, []byte("/opt/testpkg\n/opt/testpkg/etc/app.cfg\n"), 0644); err != nil {
+		t.Fatalf("Failed to write list file: %v", err)
+	}
+
+	t.Run("Own files are not conflicts on upgrade", func(t *testing.T) {
+		stubDpkg(t)
+		info, err := Inspect(debPath)
+		if err != nil {
+			t.Fatalf("Inspect() error = %v", err)
+		}
+		report := NewInstaller(WithRoot(root)).Preflight(info)
+		if report.Blocked() {
+			t.Errorf("Expected upgrade to be allowed, problems: %v", report.Problems)
+		}
+	})
+
+	if err := os.Rename(listPath, filepath.Join(infoDir, "otherpkg.list")); err != nil {
+		t.Fatalf("Failed to rename list file: %v", err)
+	}
+
+	t.Run("Files owned by other packages are reported", func(t *testing.T) {
+		stubDpkg(t)
+		info, err := Inspect(debPath)
+		if err != nil {
+			t.Fatalf("Inspect() error = %v", err)
+		}
+		report := NewInstaller(WithRoot(root)).Preflight(info)
+		if len(report.Conflicts) != 1 || len(report.Conflicts[0].Owners) != 1 || report.Conflicts[0].Owners[0] != "otherpkg" {
+			t.Errorf("Expected conflict owned by otherpkg, got %+v", report.Conflicts)
+		}
+	})
+}
+
+func TestInstallRiskyScript(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "install-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	debPath := buildTestDeb(t, tmpDir, map[string]string{"postinst": "#!/bin/sh\nrm -rf /usr/bin\n"})
+
+	calls := stubDpkg(t)
+	var out bytes.Buffer
+	installer := NewInstaller(WithRoot(filepath.Join(tmpDir, "root")), WithOutput(&out))
+
+	if _, err := installer.Install(debPath); err == nil {
+		t.Fatalf("Expected risky postinst to block installation")
+	}
+	if len(*calls) != 0 {
+		t.Errorf("Expected no dpkg calls, got %v", *calls)
+	}
+	if !strings.Contains(out.String(), "BLOCKING: postinst failed script validation") {
+		t.Errorf("Expected script problem in report, got:\n%s", out.String())
+	}
+}
+
+func TestInstallRequiresRoot(t *testing.T) {
+	stubDpkg(t)
+	installer := NewInstaller()
+	if err := installer.checkPrivileges("install"); err == nil {
+		t.Errorf("Expected privilege error for non-root user on /")
+	}
+}
+
+func TestInstallRollback(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "install-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	debPath := buildTestDeb(t, tmpDir, map[string]string{"postinst": "#!/bin/sh\necho installed\n"})
+	root := filepath.Join(tmpDir, "root")
+	store, err := manifest.NewStore(filepath.Join(tmpDir, "manifests"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	// A local file the forced install will overwrite
+	existing := filepath.Join(root, "opt", "testpkg", "etc", "app.cfg")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("local"), 0644); err != nil {
+		t.Fatalf("Failed to write existing file: %v", err)
+	}
+
+	calls := stubDpkg(t)
+	var out bytes.Buffer
+	installer := NewInstaller(WithRoot(root), WithForce(true), WithOutput(&out), WithManifestStore(store))
+	if _, err := installer.Install(debPath); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	m, err := store.Latest()
+	if err != nil {
+		t.Fatalf("Expected manifest to be saved: %v", err)
+	}
+	if m.Package != "testpkg" || len(m.Files) != 2 || len(m.Displaced) != 1 || len(m.Scripts) != 1 {
+		t.Fatalf("Unexpected manifest: %+v", m)
+	}
+
+	// Simulate dpkg overwriting and then removing the file
+	if err := os.Remove(existing); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	dpkgQuery = func(args ...string) ([]byte, error) {
+		return []byte("1.0 all"), nil
+	}
+
+	*calls = nil
+	if _, err := installer.Rollback(m); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if len(*calls) != 1 || (*calls)[0] != "--root="+root+" -r testpkg" {
+		t.Errorf("Unexpected dpkg calls: %v", *calls)
+	}
+	if content, err := ioutil.ReadFile(existing); err != nil || string(content) != "local" {
+		t.Errorf("Expected displaced file restored, got %q, %v", content, err)
+	}
+	if _, err := store.Latest(); err == nil {
+		t.Errorf("Expected manifest to be marked as rolled back")
+	}
+}
+
+func TestRollbackUpgradeWithoutCache(t *testing.T) {
+	stubDpkg(t)
+	tmpDir, err := ioutil.TempDir("", "install-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	m := manifest.New("install")
+	m.Package, m.Version, m.PreviousVersion = "testpkg", "2.0", "1:1.0"
+
+	var out bytes.Buffer
+	installer := NewInstaller(WithRoot(tmpDir), WithOutput(&out))
+	if _, err := installer.Rollback(m); err == nil || !strings.Contains(err.Error(), "no cached package") {
+		t.Errorf("Expected missing cache error, got %v", err)
+	}
+
+	cached := filepath.Join(tmpDir, aptArchiveDir, "testpkg_1%3a1.0_all.deb")
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		t.Fatalf("Failed to create cache dir: %v", err)
+	}
+	if err := ioutil.WriteFile(cached, nil, 0644); err != nil {
+		t.Fatalf("Failed to write cached package: %v", err)
+	}
+	if path, err := installer.cachedPackage("testpkg", "1:1.0"); err != nil || path != cached {
+		t.Errorf("cachedPackage() = %q, %v", path, err)
+	}
+}
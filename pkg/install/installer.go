@@ -0,0 +1,549 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// Conflict describes a payload file that would overwrite something already on the system
+type Conflict struct {
+	Path   string
+	Reason string
+	Owners []string // Installed packages owning the path, if known
+}
+
+// PreflightReport contains the result of the checks performed before dpkg is invoked
+type PreflightReport struct {
+	Package       *PackageInfo
+	Conflicts     []Conflict
+	ScriptResults map[string]*security.ScriptValidationResult
+	Problems      []string // Reasons the operation is blocked without --force
+}
+
+// Blocked reports whether the pre-flight checks found problems that require --force
+func (r *PreflightReport) Blocked() bool {
+	return len(r.Problems) > 0
+}
+
+// InstallerOption is a function that modifies an Installer
+type InstallerOption func(*Installer)
+
+// WithRoot sets the filesystem root used for conflict checks and passed to dpkg
+func WithRoot(root string) InstallerOption {
+	return func(i *Installer) {
+		if root != "" {
+			i.root = root
+		}
+	}
+}
+
+// WithDryRun enables preview mode where dpkg is never invoked
+func WithDryRun(dryRun bool) InstallerOption {
+	return func(i *Installer) {
+		i.dryRun = dryRun
+	}
+}
+
+// WithForce allows installation to proceed despite pre-flight problems
+func WithForce(force bool) InstallerOption {
+	return func(i *Installer) {
+		i.force = force
+	}
+}
+
+// WithInstallerVerbose enables verbose output
+func WithInstallerVerbose(verbose bool) InstallerOption {
+	return func(i *Installer) {
+		i.verbose = verbose
+	}
+}
+
+// WithInstallerLogger sets the logger for verbose messages
+func WithInstallerLogger(logger *slog.Logger) InstallerOption {
+	return func(i *Installer) {
+		i.logger = logger
+	}
+}
+
+// WithOutput sets the writer used for reports and progress messages
+func WithOutput(w io.Writer) InstallerOption {
+	return func(i *Installer) {
+		if w != nil {
+			i.out = w
+		}
+	}
+}
+
+// WithManifestStore records a rollback manifest for each installation in store
+func WithManifestStore(store *manifest.Store) InstallerOption {
+	return func(i *Installer) {
+		i.manifests = store
+	}
+}
+
+// WithScriptValidatorOptions adds options used when validating maintainer scripts
+func WithScriptValidatorOptions(opts ...security.ScriptValidatorOption) InstallerOption {
+	return func(i *Installer) {
+		i.scriptOptions = append(i.scriptOptions, opts...)
+	}
+}
+
+// Installer installs and removes packages with dpkg after running safety checks
+type Installer struct {
+	root            string
+	dryRun          bool
+	force           bool
+	verbose         bool
+	logger          *slog.Logger // Verbose messages; default: slog.Default()
+	out             io.Writer
+	manifests       *manifest.Store
+	scriptOptions   []security.ScriptValidatorOption
+	scriptValidator *security.ScriptValidator
+}
+
+// runDpkg invokes dpkg with the given arguments, streaming its output.
+// It is a variable so tests can substitute it.
+var runDpkg = func(args ...string) error {
+	if !ci.Interactive() {
+		// Keep modified configuration files instead of asking
+		args = append([]string{"--force-confdef", "--force-confold"}, args...)
+	}
+	cmd := exec.Command("dpkg", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// dpkgQuery runs dpkg-query and returns its stdout.
+// It is a variable so tests can substitute it.
+var dpkgQuery = func(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("dpkg-query", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("dpkg-query %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// geteuid returns the effective user ID. It is a variable so tests can substitute it.
+var geteuid = os.Geteuid
+
+// NewInstaller creates an Installer with the given options
+func NewInstaller(opts ...InstallerOption) *Installer {
+	i := &Installer{
+		root: "/",
+		out:  os.Stdout,
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	i.scriptValidator = security.NewScriptValidator(append([]security.ScriptValidatorOption{
+		security.WithSecurityLevel(security.SecurityLevelMedium),
+		security.WithScriptVerbose(i.verbose),
+		security.WithScriptLogger(i.logger),
+	}, i.scriptOptions...)...)
+
+	return i
+}
+
+// log outputs a message at info level if verbose logging is enabled, and at
+// debug level otherwise
+func (i *Installer) log(format string, args ...interface{}) {
+	logging.Logf(i.logger, logging.Verbose(i.verbose), format, args...)
+}
+
+// dpkgArgs prepends the --root option when operating on an alternate root
+func (i *Installer) dpkgArgs(args ...string) []string {
+	if filepath.Clean(i.root) != "/" {
+		return append([]string{"--root=" + i.root}, args...)
+	}
+	return args
+}
+
+// installedFiles returns the files dpkg records for an installed package.
+// A package that is not installed yields an empty set.
+func (i *Installer) installedFiles(name string) map[string]bool {
+	files := make(map[string]bool)
+	out, err := dpkgQuery(i.dpkgArgs("-L", name)...)
+	if err != nil {
+		return files
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files[line] = true
+		}
+	}
+	return files
+}
+
+// Preflight checks a package for file collisions and risky maintainer scripts
+func (i *Installer) Preflight(info *PackageInfo) *PreflightReport {
+	report := &PreflightReport{
+		Package:       info,
+		ScriptResults: make(map[string]*security.ScriptValidationResult),
+	}
+
+	db, err := dpkgdb.Open(i.root)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("could not read dpkg database: %v", err))
+		db = &dpkgdb.Database{}
+	}
+
+	var paths []string
+	for _, file := range info.Files {
+		if !file.IsDir {
+			paths = append(paths, file.Path)
+		}
+	}
+
+	// Paths owned by another installed package would be silently taken over
+	owned := make(map[string]bool)
+	for _, conflict := range db.FindConflicts(paths, "file", info.Name) {
+		owned[conflict.Path] = true
+		report.Conflicts = append(report.Conflicts, Conflict{
+			Path:   conflict.Path,
+			Reason: "owned by installed package " + strings.Join(conflict.Owners, ", "),
+			Owners: conflict.Owners,
+		})
+	}
+
+	// Unowned files that exist on disk would be overwritten; files already owned
+	// by an installed version of this package are upgrades, not conflicts
+	for _, path := range paths {
+		if owned[path] || containsString(db.Owners(path), info.Name) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(i.root, path)); err == nil {
+			report.Conflicts = append(report.Conflicts, Conflict{
+				Path:   path,
+				Reason: "file already exists on the system",
+			})
+		}
+	}
+	if len(report.Conflicts) > 0 {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("%d payload file(s) would overwrite existing files", len(report.Conflicts)))
+	}
+
+	i.validateScripts(info.Scripts, report)
+	return report
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScripts runs the script validator against each maintainer script
+func (i *Installer) validateScripts(scripts map[string]string, report *PreflightReport) {
+	for _, name := range maintainerScripts {
+		content, ok := scripts[name]
+		if !ok {
+			continue
+		}
+		result, err := i.scriptValidator.ValidateScript(name, content)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s could not be validated: %v", name, err))
+			continue
+		}
+		report.ScriptResults[name] = result
+		if !result.Valid {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s failed script validation", name))
+		}
+	}
+}
+
+// PrintReport writes a human-readable pre-flight report
+func (i *Installer) PrintReport(report *PreflightReport, action string) {
+	info := report.Package
+	fmt.Fprintf(i.out, "Pre-flight report for %s %s (%s %s)\n", action, info.Name, info.Version, info.Architecture)
+
+	var files []string
+	for _, file := range info.Files {
+		if !file.IsDir {
+			files = append(files, file.Path)
+		}
+	}
+	fmt.Fprintf(i.out, "  Files: %d\n", len(files))
+	if i.verbose || i.dryRun {
+		for _, f := range files {
+			fmt.Fprintf(i.out, "    %s\n", f)
+		}
+	}
+
+	if len(report.Conflicts) == 0 {
+		fmt.Fprintf(i.out, "  Conflicts: none\n")
+	} else {
+		fmt.Fprintf(i.out, "  Conflicts: %d\n", len(report.Conflicts))
+		for _, c := range report.Conflicts {
+			fmt.Fprintf(i.out, "    %s: %s\n", c.Path, c.Reason)
+		}
+	}
+
+	if len(report.ScriptResults) == 0 {
+		fmt.Fprintf(i.out, "  Maintainer scripts: none\n")
+	}
+	names := make([]string, 0, len(report.ScriptResults))
+	for name := range report.ScriptResults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result := report.ScriptResults[name]
+		assessment := strings.ReplaceAll(i.scriptValidator.GetRiskAssessment(result), "\n", "; ")
+		fmt.Fprintf(i.out, "  %s: %s\n", name, assessment)
+		for _, e := range result.Errors {
+			fmt.Fprintf(i.out, "    error: %s\n", e)
+		}
+		if i.verbose {
+			for _, w := range result.Warnings {
+				fmt.Fprintf(i.out, "    warning: %s\n", w)
+			}
+		}
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Fprintf(i.out, "  BLOCKING: %s\n", problem)
+	}
+}
+
+// checkPrivileges ensures dpkg will be able to modify the system
+func (i *Installer) checkPrivileges(action string) error {
+	if geteuid() != 0 && filepath.Clean(i.root) == "/" {
+		return ci.Errorf(ci.ClassEnvironment, "%s requires root privileges; re-run with sudo or use --dry-run to preview", action)
+	}
+	return nil
+}
+
+// Install runs pre-flight checks and installs the package with dpkg -i
+func (i *Installer) Install(debPath string) (*history.Summary, error) {
+	summary := history.NewSummary("install")
+	summary.DryRun = i.dryRun
+
+	info, err := Inspect(debPath)
+	if err != nil {
+		return summary, err
+	}
+	summary.Package = fmt.Sprintf("%s_%s_%s", info.Name, info.Version, info.Architecture)
+	for _, file := range info.Files {
+		if !file.IsDir {
+			summary.FilesPackaged = append(summary.FilesPackaged, file.Path)
+		}
+		if file.Linkname != "" {
+			summary.AddSymlink(file.Path, file.Linkname)
+		}
+	}
+	for _, name := range maintainerScripts {
+		if _, ok := info.Scripts[name]; ok {
+			summary.Scripts = append(summary.Scripts, name)
+		}
+	}
+
+	report := i.Preflight(info)
+	i.PrintReport(report, "installing")
+	for _, conflict := range report.Conflicts {
+		summary.Conflicts = append(summary.Conflicts, conflict.Path+": "+conflict.Reason)
+	}
+
+	if report.Blocked() {
+		if !i.force {
+			return summary, ci.Errorf(ci.ClassPolicy, "pre-flight checks failed for %s (use --force to install anyway)", info.Name)
+		}
+		for _, problem := range report.Problems {
+			summary.AddOverride(problem + " (--force)")
+		}
+	}
+
+	if i.dryRun {
+		fmt.Fprintf(i.out, "[DRY RUN] Would run: dpkg %s\n", strings.Join(i.dpkgArgs("-i", debPath), " "))
+		return summary, nil
+	}
+
+	if err := i.checkPrivileges("install"); err != nil {
+		return summary, err
+	}
+
+	record, err := i.newInstallManifest(info, report)
+	if err != nil {
+		return summary, err
+	}
+
+	i.log("Running: dpkg %s", strings.Join(i.dpkgArgs("-i", debPath), " "))
+	if err := runDpkg(i.dpkgArgs("-i", debPath)...); err != nil {
+		return summary, fmt.Errorf("dpkg failed to install %s: %w", debPath, err)
+	}
+	summary.AddAction(fmt.Sprintf("installed %s with dpkg", summary.Package))
+	auditlog.Record(auditlog.Entry{
+		Action:  auditlog.ActionPackageInstalled,
+		Path:    debPath,
+		Package: info.Name,
+		Version: info.Version,
+		Detail:  overridesDetail(summary),
+	})
+
+	if record != nil {
+		if err := i.manifests.Save(record); err != nil {
+			fmt.Fprintf(i.out, "Warning: failed to save rollback manifest: %v\n", err)
+		} else {
+			fmt.Fprintf(i.out, "Rollback manifest: %s\n", record.ID)
+		}
+	}
+
+	return summary, nil
+}
+
+// newInstallManifest prepares the rollback manifest for an installation, backing up
+// unowned files that dpkg is about to overwrite. It returns nil when no store is set.
+func (i *Installer) newInstallManifest(info *PackageInfo, report *PreflightReport) (*manifest.Manifest, error) {
+	if i.manifests == nil {
+		return nil, nil
+	}
+
+	record := manifest.New("install")
+	record.Package = info.Name
+	record.Version = info.Version
+	record.Root = i.root
+	if out, err := dpkgQuery(i.dpkgArgs("-W", "-f=${Version}", info.Name)...); err == nil {
+		record.PreviousVersion = strings.TrimSpace(string(out))
+	}
+
+	for _, file := range info.Files {
+		if !file.IsDir {
+			record.Files = append(record.Files, file.Path)
+		}
+	}
+	for _, name := range []string{"preinst", "postinst"} {
+		if _, ok := info.Scripts[name]; ok {
+			record.Scripts = append(record.Scripts, name)
+		}
+	}
+
+	// Files owned by another package are restored by reinstalling that package;
+	// only unowned files need a copy of their current contents
+	for _, conflict := range report.Conflicts {
+		if len(conflict.Owners) > 0 {
+			continue
+		}
+		if err := i.manifests.Displace(record, filepath.Join(i.root, conflict.Path)); err != nil {
+			return nil, fmt.Errorf("failed to back up %s before installing: %w", conflict.Path, err)
+		}
+	}
+
+	return record, nil
+}
+
+// Remove runs pre-flight checks on the installed removal scripts and removes the
+// package with dpkg -r (or dpkg -P when purge is set)
+func (i *Installer) Remove(name string, purge bool) (*history.Summary, error) {
+	summary := history.NewSummary("remove")
+	summary.DryRun = i.dryRun
+	summary.Package = name
+
+	out, err := dpkgQuery(i.dpkgArgs("-W", "-f=${Version} ${Architecture}", name)...)
+	if err != nil {
+		return summary, fmt.Errorf("package %s is not installed: %w", name, err)
+	}
+	fields := strings.Fields(string(out))
+
+	info := &PackageInfo{Name: name, Scripts: make(map[string]string)}
+	if len(fields) == 2 {
+		info.Version, info.Architecture = fields[0], fields[1]
+		summary.Package = fmt.Sprintf("%s_%s_%s", name, info.Version, info.Architecture)
+	}
+	for path := range i.installedFiles(name) {
+		if st, err := os.Lstat(filepath.Join(i.root, path)); err == nil && !st.IsDir() {
+			info.Files = append(info.Files, PackageFile{Path: path})
+		}
+	}
+	sort.Slice(info.Files, func(a, b int) bool { return info.Files[a].Path < info.Files[b].Path })
+
+	// Removal runs prerm and postrm from the dpkg database
+	infoDir := filepath.Join(i.root, "var", "lib", "dpkg", "info")
+	for _, script := range []string{"prerm", "postrm"} {
+		for _, candidate := range []string{name + "." + script, name + ":" + info.Architecture + "." + script} {
+			if content, err := os.ReadFile(filepath.Join(infoDir, candidate)); err == nil {
+				info.Scripts[script] = string(content)
+				summary.Scripts = append(summary.Scripts, script)
+				break
+			}
+		}
+	}
+
+	report := &PreflightReport{
+		Package:       info,
+		ScriptResults: make(map[string]*security.ScriptValidationResult),
+	}
+	i.validateScripts(info.Scripts, report)
+	i.PrintReport(report, "removing")
+
+	if report.Blocked() {
+		if !i.force {
+			return summary, ci.Errorf(ci.ClassPolicy, "pre-flight checks failed for %s (use --force to remove anyway)", name)
+		}
+		for _, problem := range report.Problems {
+			summary.AddOverride(problem + " (--force)")
+		}
+	}
+
+	flag := "-r"
+	if purge {
+		flag = "-P"
+	}
+
+	if i.dryRun {
+		fmt.Fprintf(i.out, "[DRY RUN] Would run: dpkg %s\n", strings.Join(i.dpkgArgs(flag, name), " "))
+		return summary, nil
+	}
+
+	if err := i.checkPrivileges("remove"); err != nil {
+		return summary, err
+	}
+
+	i.log("Running: dpkg %s", strings.Join(i.dpkgArgs(flag, name), " "))
+	if err := runDpkg(i.dpkgArgs(flag, name)...); err != nil {
+		return summary, fmt.Errorf("dpkg failed to remove %s: %w", name, err)
+	}
+	for _, file := range info.Files {
+		summary.AddAction("removed " + file.Path)
+	}
+	entry := auditlog.Entry{Action: auditlog.ActionPackageRemoved, Package: name, Version: info.Version, Detail: overridesDetail(summary)}
+	if purge {
+		entry.Detail = strings.TrimPrefix(entry.Detail+"; purged", "; ")
+	}
+	auditlog.Record(entry)
+
+	return summary, nil
+}
+
+// overridesDetail describes the checks --force overrode, for the audit log
+func overridesDetail(summary *history.Summary) string {
+	if len(summary.Overrides) == 0 {
+		return ""
+	}
+	return "overridden: " + strings.Join(summary.Overrides, "; ")
+}
@@ -0,0 +1,152 @@
+package install
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/control"
+)
+
+// maintainerScripts lists the control members that are executed by dpkg
+var maintainerScripts = []string{"preinst", "postinst", "prerm", "postrm"}
+
+// PackageFile describes a single entry of a package payload
+type PackageFile struct {
+	Path     string // Absolute install path
+	IsDir    bool
+	Linkname string // Symlink target, if the entry is a symlink
+	Mode     int64
+}
+
+// PackageInfo holds the metadata, payload listing and maintainer scripts of a .deb
+type PackageInfo struct {
+	Path         string
+	Name         string
+	Version      string
+	Architecture string
+	Control      map[string]string
+	Files        []PackageFile
+	Scripts      map[string]string
+}
+
+// dpkgDebReader runs dpkg-deb with the given arguments and returns its stdout.
+// It is a variable so tests can substitute it.
+var dpkgDebReader = func(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("dpkg-deb", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("dpkg-deb %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Inspect reads the control data, maintainer scripts and payload listing of a .deb file
+func Inspect(debPath string) (*PackageInfo, error) {
+	info := &PackageInfo{
+		Path:    debPath,
+		Control: make(map[string]string),
+		Scripts: make(map[string]string),
+	}
+
+	controlTar, err := dpkgDebReader("--ctrl-tarfile", debPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := readControlTar(bytes.NewReader(controlTar), info); err != nil {
+		return nil, fmt.Errorf("failed to read control archive of %s: %w", debPath, err)
+	}
+
+	dataTar, err := dpkgDebReader("--fsys-tarfile", debPath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := readDataTar(bytes.NewReader(dataTar))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data archive of %s: %w", debPath, err)
+	}
+	info.Files = files
+
+	info.Name = info.Control["Package"]
+	info.Version = info.Control["Version"]
+	info.Architecture = info.Control["Architecture"]
+	if info.Name == "" {
+		return nil, fmt.Errorf("package %s has no Package field", debPath)
+	}
+
+	return info, nil
+}
+
+// readControlTar extracts the control fields and maintainer scripts from a control archive
+func readControlTar(r io.Reader, info *PackageInfo) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Base(path.Clean(hdr.Name))
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		if name == "control" {
+			paragraph, err := control.ParseParagraph(string(content))
+			if err != nil {
+				return fmt.Errorf("invalid control file: %w", err)
+			}
+			info.Control = paragraph.Map()
+			continue
+		}
+		for _, script := range maintainerScripts {
+			if name == script {
+				info.Scripts[name] = string(content)
+			}
+		}
+	}
+}
+
+// readDataTar lists the entries of a data archive as absolute install paths
+func readDataTar(r io.Reader) ([]PackageFile, error) {
+	var files []PackageFile
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p := path.Clean("/" + hdr.Name)
+		if p == "/" {
+			continue
+		}
+		files = append(files, PackageFile{
+			Path:     p,
+			IsDir:    hdr.Typeflag == tar.TypeDir,
+			Linkname: hdr.Linkname,
+			Mode:     hdr.Mode,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
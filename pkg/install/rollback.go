@@ -0,0 +1,99 @@
+package install
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+)
+
+// aptArchiveDir is where apt caches downloaded packages, relative to the root
+const aptArchiveDir = "var/cache/apt/archives"
+
+// Rollback restores the system to the state recorded before the run described by
+// the manifest. Packages installed fresh are removed; upgraded packages are
+// downgraded from the apt cache when the previous .deb is available. Symlinks
+// created by the run are removed and every displaced file is restored.
+func (i *Installer) Rollback(m *manifest.Manifest) (*history.Summary, error) {
+	summary := history.NewSummary("rollback")
+	summary.DryRun = i.dryRun
+	summary.Package = m.Package
+
+	fmt.Fprintf(i.out, "Rolling back %s (%s, %s)\n", m.ID, m.Command, m.Time.Local().Format("2006-01-02 15:04:05"))
+
+	if m.Package != "" {
+		if err := i.rollbackPackage(m, summary); err != nil {
+			return summary, err
+		}
+	}
+
+	actions, err := manifest.RollbackFiles(m, i.dryRun)
+	for _, action := range actions {
+		if i.dryRun {
+			fmt.Fprintf(i.out, "[DRY RUN] Planned: %s\n", action)
+			continue
+		}
+		fmt.Fprintf(i.out, "%s\n", action)
+		summary.AddAction(action)
+	}
+	if err != nil {
+		return summary, err
+	}
+
+	if !i.dryRun && i.manifests != nil {
+		if err := i.manifests.MarkRolledBack(m); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// rollbackPackage undoes the dpkg side of an installation
+func (i *Installer) rollbackPackage(m *manifest.Manifest, summary *history.Summary) error {
+	if m.PreviousVersion == "" {
+		removal, err := i.Remove(m.Package, false)
+		summary.Scripts = removal.Scripts
+		summary.Overrides = append(summary.Overrides, removal.Overrides...)
+		summary.Actions = append(summary.Actions, removal.Actions...)
+		return err
+	}
+
+	debPath, err := i.cachedPackage(m.Package, m.PreviousVersion)
+	if err != nil {
+		return err
+	}
+	if i.dryRun {
+		fmt.Fprintf(i.out, "[DRY RUN] Would run: dpkg %s\n", strings.Join(i.dpkgArgs("-i", debPath), " "))
+		return nil
+	}
+	if err := i.checkPrivileges("rollback"); err != nil {
+		return err
+	}
+	if err := runDpkg(i.dpkgArgs("-i", debPath)...); err != nil {
+		return fmt.Errorf("dpkg failed to reinstall %s %s: %w", m.Package, m.PreviousVersion, err)
+	}
+	summary.AddAction(fmt.Sprintf("reinstalled %s %s from %s", m.Package, m.PreviousVersion, debPath))
+	auditlog.Record(auditlog.Entry{
+		Action:  auditlog.ActionPackageInstalled,
+		Path:    debPath,
+		Package: m.Package,
+		Version: m.PreviousVersion,
+		Detail:  "rolled back " + m.ID,
+	})
+	return nil
+}
+
+// cachedPackage locates the .deb for a previous version of a package in the apt cache
+func (i *Installer) cachedPackage(name, version string) (string, error) {
+	// apt encodes the epoch separator in archive file names
+	pattern := fmt.Sprintf("%s_%s_*.deb", name, strings.ReplaceAll(version, ":", "%3a"))
+	matches, err := filepath.Glob(filepath.Join(i.root, aptArchiveDir, pattern))
+	if err != nil || len(matches) == 0 {
+		return "", fmt.Errorf("cannot restore %s %s: no cached package in %s; reinstall it manually",
+			name, version, filepath.Join(i.root, aptArchiveDir))
+	}
+	return matches[0], nil
+}
@@ -0,0 +1,148 @@
+// Package installmap reads dh_install(1) style install files, which map
+// files of a project tree to the directories they are installed in, so the
+// tree does not have to mirror the installed filesystem layout.
+package installmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Entry is a line of an install file: one or more source globs, relative to
+// the project directory, and the directory they are installed in, relative
+// to the root of the installed system. A line with a single source installs
+// it at the same relative path.
+//
+// Example install file:
+//
+//	build/nginx usr/sbin
+//	conf/* etc/nginx/
+//	usr/share/doc/nginx/README
+type Entry struct {
+	Sources []string
+	Dest    string
+	Line    int
+}
+
+// Mapping is a file, directory or symlink matched by an install file and the
+// absolute path it is installed at
+type Mapping struct {
+	Source string // Path relative to the project directory
+	Target string
+	Line   int // Line of the install file that matched it
+}
+
+// ParseFile reads the install file named name
+func ParseFile(name string) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open install file: %w", err)
+	}
+	defer f.Close()
+	entries, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// Parse reads the entries of an install file. Empty lines and lines
+// starting with # are skipped.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		entry := Entry{Sources: fields, Line: line}
+		if len(fields) > 1 {
+			entry.Sources, entry.Dest = fields[:len(fields)-1], fields[len(fields)-1]
+		} else {
+			entry.Dest = path.Dir(fields[0])
+		}
+		if err := entry.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read install file: %w", err)
+	}
+	return entries, nil
+}
+
+// Validate checks that the sources stay within the project directory and
+// that the destination does not escape the installed system's root
+func (e Entry) Validate() error {
+	for _, source := range e.Sources {
+		if path.IsAbs(source) || escapes(source) {
+			return fmt.Errorf("source %s must be relative to the project directory", source)
+		}
+		if _, err := path.Match(source, ""); err != nil {
+			return fmt.Errorf("invalid source pattern %s: %w", source, err)
+		}
+	}
+	if escapes(strings.TrimPrefix(e.Dest, "/")) {
+		return fmt.Errorf("destination %s escapes the root directory", e.Dest)
+	}
+	return nil
+}
+
+// escapes reports whether the relative path p has a .. component
+func escapes(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve expands the source globs of entries in dir. Each match is
+// installed in its entry's destination under its base name; directories are
+// installed with their contents. A source that matches nothing and two
+// sources installed at the same path are errors.
+func Resolve(dir string, entries []Entry) ([]Mapping, error) {
+	var mappings []Mapping
+	targets := make(map[string]Mapping)
+	for _, entry := range entries {
+		dest := path.Join("/", entry.Dest)
+		for _, source := range entry.Sources {
+			matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(source)))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid source pattern %s: %w", entry.Line, source, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("line %d: %s matches no files", entry.Line, source)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				rel, err := filepath.Rel(dir, match)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", entry.Line, err)
+				}
+				mapping := Mapping{
+					Source: filepath.ToSlash(rel),
+					Target: path.Join(dest, path.Base(filepath.ToSlash(rel))),
+					Line:   entry.Line,
+				}
+				if other, ok := targets[mapping.Target]; ok {
+					return nil, fmt.Errorf("line %d: %s and %s (line %d) are both installed at %s",
+						entry.Line, mapping.Source, other.Source, other.Line, mapping.Target)
+				}
+				targets[mapping.Target] = mapping
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+	return mappings, nil
+}
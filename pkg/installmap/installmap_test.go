@@ -0,0 +1,74 @@
+package installmap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	entries, err := Parse(strings.NewReader("# comment\nbuild/nginx usr/sbin\n\nconf/* extra.conf etc/nginx/\nusr/share/doc/README\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []Entry{
+		{Sources: []string{"build/nginx"}, Dest: "usr/sbin", Line: 2},
+		{Sources: []string{"conf/*", "extra.conf"}, Dest: "etc/nginx/", Line: 4},
+		{Sources: []string{"usr/share/doc/README"}, Dest: "usr/share/doc", Line: 5},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Parse() = %+v, want %+v", entries, want)
+	}
+
+	for _, content := range []string{"/etc/passwd etc", "../secret etc", "build/app ../../etc", "conf/[ etc"} {
+		if _, err := Parse(strings.NewReader(content)); err == nil {
+			t.Errorf("Parse(%q) expected an error", content)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := os.MkdirTemp("", "installmap-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"build/nginx", "conf/nginx.conf", "conf/sites/default", "other/nginx"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	mappings, err := Resolve(dir, []Entry{
+		{Sources: []string{"build/nginx"}, Dest: "usr/sbin", Line: 1},
+		{Sources: []string{"conf/*"}, Dest: "etc/nginx/", Line: 2},
+	})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := []Mapping{
+		{Source: "build/nginx", Target: "/usr/sbin/nginx", Line: 1},
+		{Source: "conf/nginx.conf", Target: "/etc/nginx/nginx.conf", Line: 2},
+		{Source: "conf/sites", Target: "/etc/nginx/sites", Line: 2},
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("Resolve() = %+v, want %+v", mappings, want)
+	}
+
+	if _, err := Resolve(dir, []Entry{{Sources: []string{"missing/*"}, Dest: "usr/bin", Line: 1}}); err == nil {
+		t.Error("Expected an error for a source that matches nothing")
+	}
+	_, err = Resolve(dir, []Entry{
+		{Sources: []string{"build/nginx"}, Dest: "usr/sbin", Line: 1},
+		{Sources: []string{"other/nginx"}, Dest: "usr/sbin", Line: 2},
+	})
+	if err == nil || !strings.Contains(err.Error(), "both installed at /usr/sbin/nginx") {
+		t.Errorf("Expected an error for two sources installed at the same path, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package logging sets up the leveled logger that pkginstall components
+// write their diagnostics to. Components take a *slog.Logger and fall back
+// to slog.Default, which the command line configures with --log-level and
+// --log-format.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Formats lists the output formats of New
+var Formats = []string{"text", "json"}
+
+// ParseLevel converts "debug", "info", "warn" or "error" to a level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %s (available: debug, info, warn, error)", name)
+	}
+}
+
+// New returns a logger writing records at or above level to w. The text
+// format writes "2006/01/02 15:04:05 WARN message key=value" lines like the
+// standard log package; json writes one object per record.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(NewTextHandler(w, level)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s (available: %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Discard returns a logger that drops every record
+func Discard() *slog.Logger {
+	return slog.New(NewTextHandler(io.Discard, slog.Level(127)))
+}
+
+// Verbose returns the level of a component's verbose messages: info when
+// verbose output was asked for, so they are shown by default, and debug
+// otherwise, so --log-level debug shows them too
+func Verbose(verbose bool) slog.Level {
+	if verbose {
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+// Or returns logger, or slog.Default() if it is nil
+func Or(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// Logf formats a message and logs it at level with logger, or with the
+// default logger if it is nil
+func Logf(logger *slog.Logger, level slog.Level, format string, args ...interface{}) {
+	logger = Or(logger)
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+}
+
+// Writer returns a writer that logs each line written to it at level, for
+// the output of external tools
+func Writer(logger *slog.Logger, level slog.Level) io.Writer {
+	return &lineWriter{logger: logger, level: level}
+}
+
+type lineWriter struct {
+	logger *slog.Logger
+	level  slog.Level
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(w.buf[:i]), "\r"); line != "" {
+			w.logger.Log(context.Background(), w.level, line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Options are the values of the --log-level and --log-format flags
+type Options struct {
+	Level  string
+	Format string
+}
+
+// AddFlags registers --log-level and --log-format, usually on the root
+// command's persistent flags
+func AddFlags(flags *pflag.FlagSet) *Options {
+	options := &Options{}
+	flags.StringVar(&options.Level, "log-level", "info",
+		"Minimum level of log messages: debug, info, warn or error")
+	flags.StringVar(&options.Format, "log-format", "text",
+		"Log format: text, or json for one object per line on stderr; pkginstall build also writes progress events in it")
+	return options
+}
+
+// Setup makes a logger for the options the default logger, which also
+// receives the output of the standard log package
+func (o *Options) Setup(w io.Writer) error {
+	level, err := ParseLevel(o.Level)
+	if err != nil {
+		return err
+	}
+	logger, err := New(w, level, o.Format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// TextHandler writes records as "2006/01/02 15:04:05 LEVEL message key=value"
+// lines. Info records carry no level, as in the output of the log package.
+type TextHandler struct {
+	w      io.Writer
+	level  slog.Leveler
+	mu     *sync.Mutex
+	attrs  string // Preformatted attributes added with WithAttrs
+	groups string // Prefix of attribute keys added with WithGroup
+}
+
+// NewTextHandler returns a handler writing records at or above level to w
+func NewTextHandler(w io.Writer, level slog.Leveler) *TextHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &TextHandler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+// Enabled reports whether records at level are written
+func (h *TextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes a record
+func (h *TextHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	line.WriteString(t.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		line.WriteString(r.Level.String() + " ")
+	}
+	line.WriteString(strings.TrimRight(r.Message, "\n"))
+	line.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&line, h.groups, a)
+		return true
+	})
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *TextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	var line strings.Builder
+	for _, a := range attrs {
+		writeAttr(&line, h.groups, a)
+	}
+	clone.attrs += line.String()
+	return &clone
+}
+
+// WithGroup returns a handler that qualifies later attribute keys with name
+func (h *TextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.groups += name + "."
+	return &clone
+}
+
+// writeAttr appends " key=value", quoting values with spaces
+func writeAttr(line *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			writeAttr(line, prefix, member)
+		}
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(line, " %s%s=%s", prefix, a.Key, value)
+}
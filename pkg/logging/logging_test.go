@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"", slog.LevelInfo, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"trace", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelInfo, "text")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("Built package", "path", "out/app_1.0_all.deb")
+	logger.With("component", "builder").WithGroup("file").Warn("Odd mode", "mode", "0777", "note", "has spaces")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], " Built package path=out/app_1.0_all.deb") || strings.Contains(lines[0], "INFO") {
+		t.Errorf("Unexpected info line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], ` WARN Odd mode component=builder file.mode=0777 file.note="has spaces"`) {
+		t.Errorf("Unexpected warning line %q", lines[1])
+	}
+
+	buf.Reset()
+	logger, err = New(&buf, slog.LevelDebug, "json")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	Logf(logger, slog.LevelDebug, "Transformed %s\n", "/etc")
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", buf.String(), err)
+	}
+	if record["level"] != "DEBUG" || record["msg"] != "Transformed /etc" {
+		t.Errorf("Unexpected record %v", record)
+	}
+
+	if _, err := New(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewTextHandler(&buf, slog.LevelInfo))
+	Logf(logger, Verbose(false), "quiet")
+	Logf(logger, Verbose(true), "verbose")
+	if strings.Contains(buf.String(), "quiet") || !strings.Contains(buf.String(), "verbose") {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := Writer(slog.New(NewTextHandler(&buf, slog.LevelInfo)), slog.LevelWarn)
+	fmt.Fprint(w, "dpkg-deb: building package 'app'\nwarn")
+	fmt.Fprint(w, "ing: odd\r\n\n")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "WARN dpkg-deb: building package 'app'") || !strings.HasSuffix(lines[1], "WARN warning: odd") {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
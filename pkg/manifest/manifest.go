@@ -0,0 +1,381 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/state"
+)
+
+// SymlinkRecord describes a symlink created by pkginstall
+type SymlinkRecord struct {
+	Target string `json:"target"` // Path of the symlink
+	Source string `json:"source"` // Path the symlink points to
+}
+
+// DisplacedFile describes a file that was removed or replaced by pkginstall
+// and saved so it can be restored on rollback
+type DisplacedFile struct {
+	Path       string      `json:"path"`
+	Backup     string      `json:"backup,omitempty"`      // Location of the saved copy (regular files)
+	LinkTarget string      `json:"link_target,omitempty"` // Original destination (symlinks)
+	Mode       os.FileMode `json:"mode"`
+}
+
+// Manifest records everything a single pkginstall run changed on the system
+type Manifest struct {
+	ID              string          `json:"id"`
+	Command         string          `json:"command"`
+	Time            time.Time       `json:"time"`
+	Package         string          `json:"package,omitempty"`          // Package name for install runs
+	Version         string          `json:"version,omitempty"`          // Installed version
+	PreviousVersion string          `json:"previous_version,omitempty"` // Version installed before this run, if any
+	Root            string          `json:"root,omitempty"`             // Filesystem root the run operated on
+	Files           []string        `json:"files,omitempty"`
+	Symlinks        []SymlinkRecord `json:"symlinks,omitempty"`
+	Scripts         []string        `json:"scripts,omitempty"` // Maintainer scripts executed
+	Displaced       []DisplacedFile `json:"displaced,omitempty"`
+	RolledBack      bool            `json:"rolled_back,omitempty"`
+	RolledBackAt    *time.Time      `json:"rolled_back_at,omitempty"`
+}
+
+// New creates an empty manifest for the named command with a unique ID
+func New(command string) *Manifest {
+	now := time.Now().UTC()
+	return &Manifest{
+		ID:      fmt.Sprintf("%s-%s", now.Format("20060102T150405.000000000"), strings.ReplaceAll(command, " ", "-")),
+		Command: command,
+		Time:    now,
+		Root:    "/",
+	}
+}
+
+// AddSymlink records a created symlink
+func (m *Manifest) AddSymlink(target, source string) {
+	m.Symlinks = append(m.Symlinks, SymlinkRecord{Target: target, Source: source})
+}
+
+// Store persists manifests and backups of displaced files in a directory.
+// Each manifest is stored as <id>.json with its backups under <id>/.
+type Store struct {
+	dir string
+}
+
+// DefaultDir returns the default manifest directory, honouring $XDG_STATE_HOME
+// and falling back to ~/.local/state.
+func DefaultDir() (string, error) {
+	return state.Dir("manifests")
+}
+
+// NewStore creates a Store in dir. An empty dir uses DefaultDir.
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory backing the store
+func (st *Store) Dir() string {
+	return st.dir
+}
+
+// Save writes the manifest to the store, replacing any previous version
+func (st *Store) Save(m *Manifest) error {
+	if err := os.MkdirAll(st.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	path := filepath.Join(st.dir, m.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// Load reads the manifest with the given ID
+func (st *Store) Load(id string) (*Manifest, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("invalid manifest id: %q", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(st.dir, id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", id, err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("corrupt manifest %s: %w", id, err)
+	}
+	return &m, nil
+}
+
+// List returns all stored manifests, oldest first
+func (st *Store) List() ([]*Manifest, error) {
+	paths, err := filepath.Glob(filepath.Join(st.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	var manifests []*Manifest
+	for _, path := range paths {
+		m, err := st.Load(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Time.Before(manifests[j].Time) })
+	return manifests, nil
+}
+
+// Latest returns the most recent manifest that has not been rolled back
+func (st *Store) Latest() (*Manifest, error) {
+	manifests, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(manifests) - 1; i >= 0; i-- {
+		if !manifests[i].RolledBack {
+			return manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no manifests available for rollback in %s", st.dir)
+}
+
+// Displace saves a copy of the file or symlink at path before pkginstall removes
+// or replaces it, and records it in the manifest. Directories are not supported.
+func (st *Store) Displace(m *Manifest, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	displaced := DisplacedFile{Path: path, Mode: info.Mode()}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+		displaced.LinkTarget = target
+	case info.Mode().IsRegular():
+		backup := filepath.Join(st.dir, m.ID, "backup", filepath.Clean("/"+path))
+		if err := copyFile(path, backup, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		displaced.Backup = backup
+	default:
+		return fmt.Errorf("cannot back up %s: only regular files and symlinks are supported", path)
+	}
+
+	m.Displaced = append(m.Displaced, displaced)
+	entry := auditlog.Entry{Action: auditlog.ActionFileDisplaced, Path: path, Target: displaced.Backup, Package: m.Package}
+	if displaced.LinkTarget != "" {
+		entry.Detail = "symlink to " + displaced.LinkTarget
+	}
+	auditlog.Record(entry)
+	return nil
+}
+
+// RollbackFiles removes the symlinks recorded in the manifest and restores every
+// displaced file. Symlinks that no longer point to the recorded source are left
+// alone so that later changes are not clobbered. It returns a description of each
+// action taken; in dry-run mode nothing is changed.
+func RollbackFiles(m *Manifest, dryRun bool) ([]string, error) {
+	var actions []string
+	var errs []string
+	freed := make(map[string]bool) // Paths cleared by this rollback, even in dry-run mode
+
+	// Removing a freshly installed package clears its payload before files are restored
+	if m.Package != "" && m.PreviousVersion == "" {
+		for _, file := range m.Files {
+			freed[filepath.Join(m.Root, file)] = true
+		}
+	}
+
+	for i := len(m.Symlinks) - 1; i >= 0; i-- {
+		link := m.Symlinks[i]
+		current, err := os.Readlink(link.Target)
+		if err != nil {
+			actions = append(actions, fmt.Sprintf("skipped %s: no longer a symlink", link.Target))
+			continue
+		}
+		if current != link.Source {
+			actions = append(actions, fmt.Sprintf("skipped %s: now points to %s", link.Target, current))
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(link.Target); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to remove %s: %v", link.Target, err))
+				continue
+			}
+			auditlog.Record(auditlog.Entry{Action: auditlog.ActionSymlinkRemoved, Path: link.Target, Target: link.Source,
+				Package: m.Package, Detail: "rolled back " + m.ID})
+		}
+		freed[link.Target] = true
+		actions = append(actions, fmt.Sprintf("removed symlink %s -> %s", link.Target, link.Source))
+	}
+
+	for i := len(m.Displaced) - 1; i >= 0; i-- {
+		d := m.Displaced[i]
+		if _, err := os.Lstat(d.Path); err == nil && !(dryRun && freed[d.Path]) {
+			errs = append(errs, fmt.Sprintf("cannot restore %s: path is occupied", d.Path))
+			continue
+		}
+		if !dryRun {
+			if err := restore(d); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+		}
+		actions = append(actions, fmt.Sprintf("restored %s", d.Path))
+	}
+
+	if len(errs) > 0 {
+		return actions, fmt.Errorf("rollback incomplete:\n- %s", strings.Join(errs, "\n- "))
+	}
+	return actions, nil
+}
+
+// FindSymlink returns the most recent manifest that has not been rolled back
+// and records a symlink at target
+func (st *Store) FindSymlink(target string) (*Manifest, error) {
+	manifests, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(manifests) - 1; i >= 0; i-- {
+		if manifests[i].RolledBack {
+			continue
+		}
+		for _, link := range manifests[i].Symlinks {
+			if link.Target == target {
+				return manifests[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// RemoveSymlink removes the symlink the manifest records at target and
+// restores the file it displaced, if any. Both records are dropped from the
+// manifest so a later rollback leaves the path alone; the caller saves it.
+// A symlink that no longer points to the recorded source is left in place.
+func RemoveSymlink(m *Manifest, target string, dryRun bool) ([]string, error) {
+	index := -1
+	for i, link := range m.Symlinks {
+		if link.Target == target {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("manifest %s records no symlink at %s", m.ID, target)
+	}
+	link := m.Symlinks[index]
+
+	current, err := os.Readlink(target)
+	if err != nil {
+		return nil, fmt.Errorf("%s is no longer a symlink", target)
+	}
+	if current != link.Source {
+		return nil, fmt.Errorf("%s now points to %s instead of %s", target, current, link.Source)
+	}
+
+	actions := []string{fmt.Sprintf("removed symlink %s -> %s", target, link.Source)}
+	displaced := -1
+	for i, d := range m.Displaced {
+		if d.Path == target {
+			displaced = i
+			actions = append(actions, fmt.Sprintf("restored %s", target))
+		}
+	}
+	if dryRun {
+		return actions, nil
+	}
+
+	if err := os.Remove(target); err != nil {
+		return nil, fmt.Errorf("failed to remove %s: %w", target, err)
+	}
+	auditlog.Record(auditlog.Entry{Action: auditlog.ActionSymlinkRemoved, Path: target, Target: link.Source, Package: m.Package})
+	m.Symlinks = append(m.Symlinks[:index], m.Symlinks[index+1:]...)
+	if displaced >= 0 {
+		if err := restore(m.Displaced[displaced]); err != nil {
+			return actions[:1], err
+		}
+		m.Displaced = append(m.Displaced[:displaced], m.Displaced[displaced+1:]...)
+	}
+	return actions, nil
+}
+
+// restore puts a displaced file back from its backup record
+func restore(d DisplacedFile) error {
+	if err := os.MkdirAll(filepath.Dir(d.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create parent of %s: %v", d.Path, err)
+	}
+	var err error
+	if d.LinkTarget != "" {
+		err = os.Symlink(d.LinkTarget, d.Path)
+	} else {
+		err = copyFile(d.Backup, d.Path, d.Mode.Perm())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %v", d.Path, err)
+	}
+	auditlog.Record(auditlog.Entry{Action: auditlog.ActionFileRestored, Path: d.Path, Target: d.Backup})
+	return nil
+}
+
+// MarkRolledBack flags the manifest as rolled back and saves it
+func (st *Store) MarkRolledBack(m *Manifest) error {
+	now := time.Now().UTC()
+	m.RolledBack = true
+	m.RolledBackAt = &now
+	return st.Save(m)
+}
+
+// copyFile copies src to dst, creating parent directories of dst
+func copyFile(src, dst string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
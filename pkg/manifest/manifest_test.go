@@ -0,0 +1,223 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "manifest-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore(filepath.Join(tmpDir, "manifests"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	first := New("install")
+	first.Package = "myapp"
+	second := New("symlink create")
+	second.Time = first.Time.Add(1)
+	for _, m := range []*Manifest{second, first} {
+		if err := store.Save(m); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	manifests, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(manifests) != 2 || manifests[0].ID != first.ID {
+		t.Fatalf("Expected manifests oldest first, got %+v", manifests)
+	}
+
+	latest, err := store.Latest()
+	if err != nil || latest.ID != second.ID {
+		t.Fatalf("Latest() = %v, %v; want %s", latest, err, second.ID)
+	}
+
+	if err := store.MarkRolledBack(latest); err != nil {
+		t.Fatalf("MarkRolledBack() error = %v", err)
+	}
+	if latest, err = store.Latest(); err != nil || latest.ID != first.ID {
+		t.Errorf("Expected rolled back manifest to be skipped, got %v, %v", latest, err)
+	}
+
+	if _, err := store.Load("../escape"); err == nil {
+		t.Errorf("Expected invalid manifest id to be rejected")
+	}
+}
+
+func TestRollbackFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "manifest-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore(filepath.Join(tmpDir, "manifests"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	m := New("symlink create")
+
+	source := filepath.Join(tmpDir, "opt", "myapp")
+	target := filepath.Join(tmpDir, "bin", "myapp")
+	oldLink := filepath.Join(tmpDir, "bin", "oldlink")
+	for _, dir := range []string{filepath.Dir(source), filepath.Dir(target)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(target, []byte("original"), 0750); err != nil {
+		t.Fatalf("Failed to write target: %v", err)
+	}
+	if err := os.Symlink("/somewhere", oldLink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	// Simulate a forced symlink creation over both paths
+	for _, path := range []string{target, oldLink} {
+		if err := store.Displace(m, path); err != nil {
+			t.Fatalf("Displace(%s) error = %v", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("Failed to remove %s: %v", path, err)
+		}
+		if err := os.Symlink(source, path); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		m.AddSymlink(path, source)
+	}
+
+	t.Run("Dry run changes nothing", func(t *testing.T) {
+		actions, err := RollbackFiles(m, true)
+		if err != nil {
+			t.Fatalf("RollbackFiles() error = %v", err)
+		}
+		if len(actions) != 4 {
+			t.Errorf("Expected 4 planned actions, got %v", actions)
+		}
+		if link, _ := os.Readlink(target); link != source {
+			t.Errorf("Dry run modified %s", target)
+		}
+	})
+
+	t.Run("Restores displaced files", func(t *testing.T) {
+		actions, err := RollbackFiles(m, false)
+		if err != nil {
+			t.Fatalf("RollbackFiles() error = %v", err)
+		}
+		if len(actions) != 4 {
+			t.Errorf("Expected 4 actions, got %v", actions)
+		}
+
+		content, err := ioutil.ReadFile(target)
+		if err != nil || string(content) != "original" {
+			t.Errorf("Expected original content restored, got %q, %v", content, err)
+		}
+		if info, err := os.Lstat(target); err != nil || info.Mode().Perm() != 0750 {
+			t.Errorf("Expected mode 0750 restored, got %v, %v", info.Mode(), err)
+		}
+		if link, err := os.Readlink(oldLink); err != nil || link != "/somewhere" {
+			t.Errorf("Expected original symlink restored, got %q, %v", link, err)
+		}
+	})
+}
+
+func TestRemoveSymlink(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "manifest-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore(filepath.Join(tmpDir, "manifests"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	m := New("symlink create")
+
+	source := filepath.Join(tmpDir, "opt", "myapp")
+	replaced := filepath.Join(tmpDir, "bin", "myapp")
+	created := filepath.Join(tmpDir, "bin", "myapp-helper")
+	changed := filepath.Join(tmpDir, "bin", "myapp-changed")
+	if err := os.MkdirAll(filepath.Dir(replaced), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(replaced, []byte("original"), 0755); err != nil {
+		t.Fatalf("Failed to write target: %v", err)
+	}
+	if err := store.Displace(m, replaced); err != nil {
+		t.Fatalf("Displace() error = %v", err)
+	}
+	for _, path := range []string{replaced, created, changed} {
+		os.Remove(path)
+		if err := os.Symlink(source, path); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		m.AddSymlink(path, source)
+	}
+	// Changed by someone else after it was created
+	os.Remove(changed)
+	if err := os.Symlink("/elsewhere", changed); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		wantActions int
+		wantErr     bool
+		wantContent string // Content restored at target
+	}{
+		{"Restores the displaced file", replaced, 2, false, "original"},
+		{"Removes a symlink that displaced nothing", created, 1, false, ""},
+		{"Leaves changed symlinks alone", changed, 0, true, ""},
+		{"Symlink not in the manifest", filepath.Join(tmpDir, "bin", "other"), 0, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := RemoveSymlink(m, tt.target, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RemoveSymlink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(actions) != tt.wantActions {
+				t.Errorf("Expected %d actions, got %v", tt.wantActions, actions)
+			}
+			if tt.wantErr {
+				return
+			}
+			content, err := ioutil.ReadFile(tt.target)
+			if tt.wantContent == "" && !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be removed, got %v", tt.target, err)
+			}
+			if tt.wantContent != "" && string(content) != tt.wantContent {
+				t.Errorf("Expected %q restored, got %q, %v", tt.wantContent, content, err)
+			}
+		})
+	}
+
+	// Only the changed symlink is left for rollback
+	if len(m.Symlinks) != 1 || m.Symlinks[0].Target != changed || len(m.Displaced) != 0 {
+		t.Errorf("Expected only %s left in the manifest, got %+v and %+v", changed, m.Symlinks, m.Displaced)
+	}
+
+	if err := store.Save(m); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	found, err := store.FindSymlink(changed)
+	if err != nil || found == nil || found.ID != m.ID {
+		t.Errorf("FindSymlink() = %v, %v, want %s", found, err, m.ID)
+	}
+	if found, err := store.FindSymlink(replaced); err != nil || found != nil {
+		t.Errorf("FindSymlink(%s) = %v, %v, want nil", replaced, found, err)
+	}
+}
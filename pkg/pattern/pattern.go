@@ -0,0 +1,227 @@
+// Package pattern matches package source paths against exclude and include
+// patterns using gitignore-style globs.
+package pattern
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the name of the exclude pattern file read from the source directory
+const IgnoreFileName = ".pkgignore"
+
+// rule is a single compiled pattern
+type rule struct {
+	pattern  string
+	re       *regexp.Regexp
+	negated  bool // "!pattern" re-includes paths excluded by earlier patterns
+	dirOnly  bool // "pattern/" only matches directories
+	basename bool // Patterns without a slash match the name at any depth
+}
+
+// Matcher decides which source paths are excluded from a package.
+//
+// Patterns follow .gitignore conventions:
+//   - "*" and "?" match within a path segment, "**" matches across segments
+//   - a pattern without a slash matches a file or directory name at any depth
+//   - a leading "/" or an inner slash anchors the pattern at the source root
+//   - a trailing "/" matches directories only
+//   - "!pattern" re-includes paths excluded by an earlier pattern
+//
+// A matched directory excludes everything below it unless a later pattern
+// matches the nested path. Include patterns take precedence over all exclude
+// patterns.
+type Matcher struct {
+	excludes []rule
+	includes []rule
+}
+
+// NewMatcher compiles exclude and include patterns
+func NewMatcher(excludes, includes []string) (*Matcher, error) {
+	m := &Matcher{}
+	if err := m.AddExcludes(excludes...); err != nil {
+		return nil, err
+	}
+	if err := m.AddIncludes(includes...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddExcludes appends exclude patterns; later patterns override earlier ones
+func (m *Matcher) AddExcludes(patterns ...string) error {
+	for _, p := range patterns {
+		r, err := compile(p)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			m.excludes = append(m.excludes, *r)
+		}
+	}
+	return nil
+}
+
+// AddIncludes appends include patterns, which take precedence over excludes
+func (m *Matcher) AddIncludes(patterns ...string) error {
+	for _, p := range patterns {
+		r, err := compile(p)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			if r.negated {
+				return fmt.Errorf("include pattern cannot be negated: %s", p)
+			}
+			m.includes = append(m.includes, *r)
+		}
+	}
+	return nil
+}
+
+// CanPrune reports whether an excluded directory can be skipped entirely,
+// which is the case unless an include or negated pattern could re-include
+// something below it
+func (m *Matcher) CanPrune() bool {
+	if len(m.includes) > 0 {
+		return false
+	}
+	for _, r := range m.excludes {
+		if r.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// Excluded reports whether relPath, a slash-separated path relative to the
+// source root, is excluded from the package
+func (m *Matcher) Excluded(relPath string, isDir bool) bool {
+	relPath = strings.Trim(path.Clean("/"+relPath), "/")
+	if relPath == "" {
+		return false
+	}
+
+	// Walk from the top-level directory down to the path itself; the last
+	// pattern matching a level decides, and unmatched levels inherit the state
+	// of their parent
+	segments := strings.Split(relPath, "/")
+	excluded, included := false, false
+	for i := range segments {
+		current := strings.Join(segments[:i+1], "/")
+		dir := isDir || i < len(segments)-1
+		for _, r := range m.excludes {
+			if r.matches(current, segments[i], dir) {
+				excluded = !r.negated
+			}
+		}
+		for _, r := range m.includes {
+			if r.matches(current, segments[i], dir) {
+				included = true
+			}
+		}
+	}
+	return excluded && !included
+}
+
+// matches reports whether the rule matches a path at one level of the walk
+func (r *rule) matches(relPath, name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.basename {
+		return r.re.MatchString(name)
+	}
+	return r.re.MatchString(relPath)
+}
+
+// compile converts a pattern to a rule; blank lines and comments yield nil
+func compile(pattern string) (*rule, error) {
+	p := strings.TrimSpace(pattern)
+	if p == "" || strings.HasPrefix(p, "#") {
+		return nil, nil
+	}
+
+	r := &rule{pattern: p}
+	if strings.HasPrefix(p, "!") {
+		r.negated = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	r.basename = !strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil, fmt.Errorf("invalid pattern: %s", pattern)
+	}
+
+	re, err := regexp.Compile(globToRegexp(p))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	r.re = re
+	return r, nil
+}
+
+// globToRegexp converts a glob to an anchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// "**/" also matches zero directories
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					b.WriteString("(.*/)?")
+					i++
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ReadPatternFile reads one pattern per line, skipping blank lines and # comments
+func ReadPatternFile(filePath string) ([]string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, nil
+}
@@ -0,0 +1,110 @@
+package pattern
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcherExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		excludes []string
+		includes []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"Basename at any depth", []string{"*.log"}, nil, "var/log/app/debug.log", false, true},
+		{"Basename does not match other names", []string{"*.log"}, nil, "var/log/app/debug.txt", false, false},
+		{"Anchored pattern", []string{"/usr/share/doc"}, nil, "usr/share/doc/app/README", false, true},
+		{"Anchored pattern only at root", []string{"/doc"}, nil, "usr/doc", true, false},
+		{"Inner slash anchors", []string{"share/doc"}, nil, "usr/share/doc", true, false},
+		{"Star stays in segment", []string{"/usr/*/doc"}, nil, "usr/share/x/doc", true, false},
+		{"Double star crosses segments", []string{"/usr/**/doc"}, nil, "usr/share/x/doc/a", false, true},
+		{"Double star matches zero segments", []string{"**/doc"}, nil, "doc/a", false, true},
+		{"Question mark", []string{"man?"}, nil, "usr/share/man/man1", true, true},
+		{"Character class", []string{"*.[ch]"}, nil, "src/main.c", false, true},
+		{"Negated character class", []string{"*.[!ch]"}, nil, "src/main.c", false, false},
+		{"Directory-only pattern skips files", []string{"build/"}, nil, "build", false, false},
+		{"Directory-only pattern matches directories", []string{"build/"}, nil, "build/out.bin", false, true},
+		{"Negation re-includes", []string{"*.log", "!keep.log"}, nil, "var/keep.log", false, false},
+		{"Last match wins", []string{"!keep.log", "*.log"}, nil, "var/keep.log", false, true},
+		{"Negation below excluded directory", []string{"/doc", "!/doc/LICENSE"}, nil, "doc/LICENSE", false, false},
+		{"Include takes precedence", []string{"/usr/share/doc"}, []string{"copyright"}, "usr/share/doc/app/copyright", false, false},
+		{"Include does not affect siblings", []string{"/usr/share/doc"}, []string{"copyright"}, "usr/share/doc/app/README", false, true},
+		{"Comments and blanks are ignored", []string{"# *.log", " "}, nil, "debug.log", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMatcher(tt.excludes, tt.includes)
+			if err != nil {
+				t.Fatalf("NewMatcher() error = %v", err)
+			}
+			if got := m.Excluded(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Excluded(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherCanPrune(t *testing.T) {
+	tests := []struct {
+		name     string
+		excludes []string
+		includes []string
+		want     bool
+	}{
+		{"Plain excludes", []string{"build/"}, nil, true},
+		{"Negated exclude", []string{"build/", "!build/keep"}, nil, false},
+		{"Includes", []string{"build/"}, []string{"keep"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMatcher(tt.excludes, tt.includes)
+			if err != nil {
+				t.Fatalf("NewMatcher() error = %v", err)
+			}
+			if got := m.CanPrune(); got != tt.want {
+				t.Errorf("CanPrune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherErrors(t *testing.T) {
+	if _, err := NewMatcher([]string{"!"}, nil); err == nil {
+		t.Errorf("Expected error for empty negated pattern")
+	}
+	if _, err := NewMatcher(nil, []string{"!keep"}); err == nil {
+		t.Errorf("Expected error for negated include pattern")
+	}
+}
+
+func TestReadPatternFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "pattern-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, IgnoreFileName)
+	if err := ioutil.WriteFile(path, []byte("# build output\nbuild/\n\n  *.log  \n!keep.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write pattern file: %v", err)
+	}
+
+	patterns, err := ReadPatternFile(path)
+	if err != nil {
+		t.Fatalf("ReadPatternFile() error = %v", err)
+	}
+	if len(patterns) != 3 || patterns[0] != "build/" || patterns[1] != "*.log" || patterns[2] != "!keep.log" {
+		t.Errorf("Unexpected patterns: %q", patterns)
+	}
+
+	if _, err := ReadPatternFile(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
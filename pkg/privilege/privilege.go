@@ -0,0 +1,90 @@
+// Package privilege keeps pkginstall commands from building packages as
+// root. CheckRoot refuses a root build unless it is allowed, and a
+// Credential switches a root process started through sudo to the invoking
+// user for the parts of a build that only read the user's files.
+package privilege
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+// AllowRootUsage is the help text of the --allow-root flag of commands that
+// build packages
+const AllowRootUsage = "Allow building as root, which builds do not need"
+
+// geteuid returns the effective user ID. It is a variable so tests can substitute it.
+var geteuid = os.Geteuid
+
+// CheckRoot refuses to build as root unless allow is set. Builds do not need
+// root: preserved owners are set with fakeroot or the built-in archive writer.
+func CheckRoot(allow bool) error {
+	if geteuid() == 0 && !allow {
+		return ci.Errorf(ci.ClassUsage, "refusing to build as root, which the build does not need; run as a regular user or pass --allow-root")
+	}
+	return nil
+}
+
+// Credential is an unprivileged user a root process switches to
+type Credential struct {
+	UID    int
+	GID    int
+	Groups []int // Supplementary groups; only GID if empty
+}
+
+// String returns the credential as uid:gid
+func (c *Credential) String() string {
+	return fmt.Sprintf("%d:%d", c.UID, c.GID)
+}
+
+// IDs returns the user and group ID of the credential
+func (c *Credential) IDs() (uid, gid int) {
+	return c.UID, c.GID
+}
+
+// Switch changes the effective user and groups of the whole process to the
+// credential and returns the function that switches back to root. Root
+// stays the saved user ID. Since every goroutine is affected, only a process
+// that runs one build at a time, such as the command line, may switch.
+func (c *Credential) Switch() (restore func() error, err error) {
+	rootGroups, err := setEffectiveIDs(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to switch to %s: %w", c, err)
+	}
+	return func() error {
+		if err := restoreRootIDs(rootGroups); err != nil {
+			return fmt.Errorf("failed to switch back to root: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// SudoCredential returns the user that started the process through sudo,
+// from SUDO_UID and SUDO_GID, or nil if it was not started through sudo by a
+// regular user
+func SudoCredential() *Credential {
+	uid, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+	if err != nil || uid <= 0 {
+		return nil
+	}
+	gid, err := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err != nil || gid < 0 {
+		return nil
+	}
+	cred := &Credential{UID: uid, GID: gid}
+	// The supplementary groups let the copy read group-readable sources
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if group, err := strconv.Atoi(id); err == nil {
+					cred.Groups = append(cred.Groups, group)
+				}
+			}
+		}
+	}
+	return cred
+}
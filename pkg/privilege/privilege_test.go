@@ -0,0 +1,53 @@
+package privilege
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+)
+
+func TestCheckRoot(t *testing.T) {
+	defer func() { geteuid = os.Geteuid }()
+
+	geteuid = func() int { return 1000 }
+	if err := CheckRoot(false); err != nil {
+		t.Errorf("CheckRoot() as a regular user error = %v", err)
+	}
+
+	geteuid = func() int { return 0 }
+	err := CheckRoot(false)
+	if err == nil {
+		t.Fatalf("Expected CheckRoot() to refuse root")
+	}
+	if ci.ExitCode(err) != ci.ExitUsage {
+		t.Errorf("Expected a usage error, got exit code %d", ci.ExitCode(err))
+	}
+	if err := CheckRoot(true); err != nil {
+		t.Errorf("CheckRoot() with --allow-root error = %v", err)
+	}
+}
+
+func TestSudoCredential(t *testing.T) {
+	for _, env := range []struct {
+		uid, gid string
+		want     bool
+	}{
+		{"1000", "1000", true},
+		{"0", "0", false},
+		{"", "1000", false},
+		{"1000", "x", false},
+	} {
+		os.Setenv("SUDO_UID", env.uid)
+		os.Setenv("SUDO_GID", env.gid)
+		cred := SudoCredential()
+		if (cred != nil) != env.want {
+			t.Errorf("SudoCredential() with SUDO_UID=%q SUDO_GID=%q = %v, want credential %v", env.uid, env.gid, cred, env.want)
+		}
+		if cred != nil && cred.String() != env.uid+":"+env.gid {
+			t.Errorf("Credential = %s, want %s:%s", cred, env.uid, env.gid)
+		}
+	}
+	os.Unsetenv("SUDO_UID")
+	os.Unsetenv("SUDO_GID")
+}
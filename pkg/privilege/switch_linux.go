@@ -0,0 +1,43 @@
+package privilege
+
+import (
+	"syscall"
+)
+
+// setEffectiveIDs switches the effective user and groups of every thread to
+// cred. Root stays the saved user ID, so restoreRootIDs can switch back. The
+// supplementary groups it replaced are returned.
+func setEffectiveIDs(cred *Credential) ([]int, error) {
+	rootGroups, err := syscall.Getgroups()
+	if err != nil {
+		return nil, err
+	}
+	groups := cred.Groups
+	if len(groups) == 0 {
+		groups = []int{cred.GID}
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return nil, err
+	}
+	if err := syscall.Setegid(cred.GID); err != nil {
+		restoreRootIDs(rootGroups)
+		return nil, err
+	}
+	if err := syscall.Seteuid(cred.UID); err != nil {
+		restoreRootIDs(rootGroups)
+		return nil, err
+	}
+	return rootGroups, nil
+}
+
+// restoreRootIDs switches every thread back to root and its supplementary
+// groups
+func restoreRootIDs(groups []int) error {
+	if err := syscall.Seteuid(0); err != nil {
+		return err
+	}
+	if err := syscall.Setegid(0); err != nil {
+		return err
+	}
+	return syscall.Setgroups(groups)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package privilege
+
+import (
+	"fmt"
+)
+
+// setEffectiveIDs is not supported on this platform
+func setEffectiveIDs(cred *Credential) ([]int, error) {
+	return nil, fmt.Errorf("dropping privileges is only supported on Linux")
+}
+
+// restoreRootIDs is not supported on this platform
+func restoreRootIDs(groups []int) error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package provenance
+
+import (
+	"fmt"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/spf13/cobra"
+)
+
+// NewProvenanceCommand creates a command for checking build provenance
+func NewProvenanceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "provenance",
+		Short: "Check the SLSA provenance of built packages",
+		Long: `Check the SLSA provenance pkginstall build writes next to each package.
+
+Every build writes <package>.intoto.jsonl: an in-toto statement with the
+package digest, the source directory digest, the build parameters and the
+pkginstall version, signed with the key in
+$XDG_STATE_HOME/pkginstall/provenance.key (~/.local/state/pkginstall without
+XDG_STATE_HOME), or the one given with pkginstall build --provenance-key.
+Its public key is written next to it with a .pub extension.
+
+Examples:
+  pkginstall provenance verify --key provenance.key.pub myapp_1.0_amd64.deb
+`,
+	}
+	cmd.AddCommand(newVerifyCommand())
+	return cmd
+}
+
+// newVerifyCommand creates a subcommand verifying the provenance of packages
+func newVerifyCommand() *cobra.Command {
+	var keyFile string
+	cmd := &cobra.Command{
+		Use:   "verify --key PUBLIC_KEY PACKAGE...",
+		Short: "Verify the signed provenance of packages",
+		Long: `Verify that the provenance next to each package is signed with the public
+key and names the package with its current SHA-256 digest.
+
+Examples:
+  pkginstall provenance verify --key builder.pub dist/*.deb
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" {
+				defaultKey, err := DefaultKeyFile()
+				if err != nil {
+					return ci.Errorf(ci.ClassUsage, "%v; give the public key with --key", err)
+				}
+				keyFile = PublicKeyFile(defaultKey)
+			}
+			pub, err := LoadPublicKey(keyFile)
+			if err != nil {
+				return ci.Wrap(ci.ClassUsage, err)
+			}
+			failed := 0
+			for _, path := range args {
+				s, err := VerifyFile(path, pub)
+				if err != nil {
+					fmt.Fprintf(cmd.OutOrStdout(), "FAILED %s: %v\n", path, err)
+					failed++
+					continue
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "OK     %s (built by pkginstall %s on %s)\n",
+					path, s.Predicate.RunDetails.Builder.Version["pkginstall"],
+					s.Predicate.RunDetails.Metadata.FinishedOn.Local().Format("2006-01-02 15:04:05"))
+			}
+			if failed > 0 {
+				return ci.Errorf(ci.ClassValidation, "provenance of %d of %d packages did not verify", failed, len(args))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&keyFile, "key", "", "Public key the provenance must be signed with (default: $XDG_STATE_HOME/pkginstall/provenance.key.pub)")
+	return cmd
+}
@@ -0,0 +1,441 @@
+// Package provenance writes SLSA provenance for built packages: an in-toto
+// statement naming the package digests, the source directory digest, the
+// build parameters and the builder, signed as a DSSE envelope with an
+// Ed25519 key. Anyone holding the public key can check that a package was
+// built by pkginstall from a given source tree.
+package provenance
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/doctor"
+	"github.com/go-i2p/go-pkginstall/pkg/state"
+)
+
+// Types of the in-toto statement, SLSA predicate and DSSE payload
+const (
+	StatementType = "https://in-toto.io/Statement/v1"
+	PredicateType = "https://slsa.dev/provenance/v1"
+	PayloadType   = "application/vnd.in-toto+json"
+)
+
+// BuildType identifies the meaning of the parameters of pkginstall builds
+const BuildType = "https://github.com/go-i2p/go-pkginstall/build/v1"
+
+// BuilderID identifies pkginstall as the builder
+const BuilderID = "https://github.com/go-i2p/go-pkginstall"
+
+// Extension is the file extension of provenance written next to a package
+const Extension = ".intoto.jsonl"
+
+// ErrSignature reports an envelope without a valid signature of the key
+var ErrSignature = errors.New("provenance signature does not verify")
+
+// environmentVariables are recorded in the provenance when set; they change
+// build output and never hold secrets
+var environmentVariables = []string{"SOURCE_DATE_EPOCH", "DEB_BUILD_OPTIONS", "LANG", "LC_ALL", "TZ", "CI"}
+
+// builder describes the running pkginstall binary
+var builder = doctor.NewBuildInfo("", "", "")
+
+// SetBuilderInfo sets the pkginstall version recorded as the builder, which
+// the main package knows from its link-time flags
+func SetBuilderInfo(info doctor.BuildInfo) {
+	builder = info
+}
+
+// Statement is an in-toto statement with a SLSA provenance predicate
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Resource `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Resource is a named artifact with its digests
+type Resource struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is a SLSA v1 provenance predicate
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of a build
+type BuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+	ResolvedDependencies []Resource             `json:"resolvedDependencies,omitempty"`
+}
+
+// RunDetails describes the builder and the run
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Builder identifies the program that built the packages
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// Metadata describes one build run
+type Metadata struct {
+	InvocationID string    `json:"invocationId,omitempty"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// NewStatement returns the provenance of a build run by this binary on this
+// host, with the parameters it was given and no subjects yet
+func NewStatement(parameters map[string]interface{}, started, finished time.Time) *Statement {
+	return &Statement{
+		Type:          StatementType,
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType:          BuildType,
+				ExternalParameters: parameters,
+				InternalParameters: Environment(),
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{
+					ID:      BuilderID,
+					Version: map[string]string{"pkginstall": builder.Version, "commit": builder.Commit, "go": builder.GoVersion},
+				},
+				Metadata: Metadata{
+					InvocationID: fmt.Sprintf("%s-%d", started.UTC().Format("20060102T150405.000000000"), os.Getpid()),
+					StartedOn:    started.UTC(),
+					FinishedOn:   finished.UTC(),
+				},
+			},
+		},
+	}
+}
+
+// Environment returns the platform and the build-relevant environment
+// variables that are set
+func Environment() map[string]interface{} {
+	env := map[string]interface{}{"platform": runtime.GOOS + "/" + runtime.GOARCH}
+	vars := make(map[string]string)
+	for _, name := range environmentVariables {
+		if value, ok := os.LookupEnv(name); ok {
+			vars[name] = value
+		}
+	}
+	if len(vars) > 0 {
+		env["environment"] = vars
+	}
+	return env
+}
+
+// AddSubject adds a file to the subjects of the statement
+func (s *Statement) AddSubject(path string) error {
+	digest, err := FileDigest(path)
+	if err != nil {
+		return err
+	}
+	s.Subject = append(s.Subject, Resource{Name: filepath.Base(path), Digest: map[string]string{"sha256": digest}})
+	return nil
+}
+
+// AddDependency adds a file or directory the build read to the resolved
+// dependencies of the statement
+func (s *Statement) AddDependency(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	var digest string
+	if info.IsDir() {
+		digest, err = DirectoryDigest(abs)
+	} else {
+		digest, err = FileDigest(abs)
+	}
+	if err != nil {
+		return err
+	}
+	deps := &s.Predicate.BuildDefinition.ResolvedDependencies
+	*deps = append(*deps, Resource{URI: "file://" + filepath.ToSlash(abs), Digest: map[string]string{"sha256": digest}})
+	return nil
+}
+
+// FileDigest returns the hex SHA-256 of a file
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DirectoryDigest returns the hex SHA-256 of a directory tree: of one line
+// per entry, in path order, with its relative path, type and permission
+// bits, and the SHA-256 of a regular file or the target of a symlink. It
+// changes when any file, link or mode changes, but not with timestamps.
+func DirectoryDigest(dir string) (string, error) {
+	var lines []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		mode := info.Mode()
+		var content string
+		switch {
+		case mode.IsRegular():
+			if content, err = FileDigest(path); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			if content, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s\x00%s\x00%s\n", filepath.ToSlash(rel), mode.Type()|mode.Perm(), content))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", dir, err)
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Envelope is a DSSE envelope holding a signed statement
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"` // Base64 statement
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is one signature of a DSSE envelope
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"` // Base64 Ed25519 signature of the PAE
+}
+
+// pae returns the DSSE pre-authentication encoding of a payload
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// KeyID returns the ID of a public key: the hex SHA-256 of its bytes
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the statement signed with key
+func Sign(s *Statement, key ed25519.PrivateKey) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(key, pae(PayloadType, payload))
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{{KeyID: KeyID(key.Public().(ed25519.PublicKey)), Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, nil
+}
+
+// Verify checks that the envelope is signed by pub and returns its statement
+func Verify(e *Envelope, pub ed25519.PublicKey) (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, fmt.Errorf("unexpected payload type %q", e.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	verified := false
+	for _, signature := range e.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err == nil && ed25519.Verify(pub, pae(e.PayloadType, payload), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+	var s Statement
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, fmt.Errorf("invalid statement: %w", err)
+	}
+	if s.Type != StatementType || s.PredicateType != PredicateType {
+		return nil, fmt.Errorf("unexpected statement type %q with predicate %q", s.Type, s.PredicateType)
+	}
+	return &s, nil
+}
+
+// WriteFile writes the envelope as one JSON line to path
+func (e *Envelope) WriteFile(path string) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write provenance: %w", err)
+	}
+	return nil
+}
+
+// ReadEnvelope reads the first envelope of a provenance file
+func ReadEnvelope(path string) (*Envelope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provenance: %w", err)
+	}
+	line, _, _ := bytes.Cut(bytes.TrimSpace(data), []byte("\n"))
+	var e Envelope
+	if err := json.Unmarshal(line, &e); err != nil {
+		return nil, fmt.Errorf("invalid provenance %s: %w", path, err)
+	}
+	return &e, nil
+}
+
+// DefaultKeyFile returns where the provenance signing key is kept:
+// $XDG_STATE_HOME/pkginstall/provenance.key, falling back to ~/.local/state.
+// It fails when neither is available rather than keep the secret key in a
+// shared directory such as /tmp.
+func DefaultKeyFile() (string, error) {
+	return state.Dir("provenance.key")
+}
+
+// PublicKeyFile returns where the public key of a key file is written
+func PublicKeyFile(keyFile string) string {
+	return keyFile + ".pub"
+}
+
+// LoadOrGenerateKey reads the PEM Ed25519 key at path. If it does not exist,
+// a new key is generated and saved, with its public key next to it. It
+// reports whether the key was generated.
+func LoadOrGenerateKey(path string) (ed25519.PrivateKey, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, false, fmt.Errorf("invalid provenance key %s: no PEM data", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid provenance key %s: %w", path, err)
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, false, fmt.Errorf("provenance key %s is not an Ed25519 key", path)
+		}
+		return key, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate provenance key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, false, fmt.Errorf("failed to save provenance key: %w", err)
+	}
+	if err := os.WriteFile(PublicKeyFile(path), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return nil, false, fmt.Errorf("failed to save provenance public key: %w", err)
+	}
+	return key, true, nil
+}
+
+// LoadPublicKey reads a PEM Ed25519 public key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key %s: no PEM data", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// VerifyFile checks the provenance of a package written next to it with
+// pub, and that the package is one of its subjects
+func VerifyFile(packagePath string, pub ed25519.PublicKey) (*Statement, error) {
+	e, err := ReadEnvelope(packagePath + Extension)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Verify(e, pub)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := FileDigest(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(packagePath)
+	for _, subject := range s.Subject {
+		if subject.Name == name && subject.Digest["sha256"] == digest {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not match the subjects of its provenance", name)
+}
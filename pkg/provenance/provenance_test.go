@@ -0,0 +1,126 @@
+package provenance
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDir returns a temporary directory removed after the test
+func newTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "provenance-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestDirectoryDigest(t *testing.T) {
+	dir := newTestDir(t)
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	tool := filepath.Join(dir, "bin", "tool")
+	if err := ioutil.WriteFile(tool, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Symlink("bin/tool", filepath.Join(dir, "run")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	digest, err := DirectoryDigest(dir)
+	if err != nil {
+		t.Fatalf("DirectoryDigest() error = %v", err)
+	}
+	if err := os.Chtimes(tool, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatalf("Failed to change times: %v", err)
+	}
+	if again, _ := DirectoryDigest(dir); again != digest {
+		t.Errorf("Timestamps should not change the digest")
+	}
+	if err := os.Chmod(tool, 0644); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	if changed, _ := DirectoryDigest(dir); changed == digest {
+		t.Errorf("A mode change should change the digest")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	dir := newTestDir(t)
+	deb := filepath.Join(dir, "demo_1.0_amd64.deb")
+	if err := ioutil.WriteFile(deb, []byte("package"), 0644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+
+	keyFile := filepath.Join(dir, "keys", "provenance.key")
+	key, generated, err := LoadOrGenerateKey(keyFile)
+	if err != nil || !generated {
+		t.Fatalf("LoadOrGenerateKey() = %v, %v", generated, err)
+	}
+	again, generated, err := LoadOrGenerateKey(keyFile)
+	if err != nil || generated || !again.Equal(key) {
+		t.Fatalf("LoadOrGenerateKey() of the saved key = %v, %v", generated, err)
+	}
+	pub, err := LoadPublicKey(PublicKeyFile(keyFile))
+	if err != nil || !pub.Equal(key.Public()) {
+		t.Fatalf("LoadPublicKey() = %v", err)
+	}
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewStatement(map[string]interface{}{"package": "demo"}, started, started.Add(time.Minute))
+	if err := s.AddSubject(deb); err != nil {
+		t.Fatalf("AddSubject() error = %v", err)
+	}
+	if err := s.AddDependency(dir); err != nil {
+		t.Fatalf("AddDependency() error = %v", err)
+	}
+	envelope, err := Sign(s, key)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := envelope.WriteFile(deb + Extension); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	got, err := VerifyFile(deb, pub)
+	if err != nil {
+		t.Fatalf("VerifyFile() error = %v", err)
+	}
+	if got.Predicate.BuildDefinition.ExternalParameters["package"] != "demo" ||
+		got.Predicate.RunDetails.Builder.ID != BuilderID ||
+		len(got.Predicate.BuildDefinition.ResolvedDependencies) != 1 {
+		t.Errorf("Unexpected statement %+v", got)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := VerifyFile(deb, otherPub); !errors.Is(err, ErrSignature) {
+		t.Errorf("VerifyFile() with another key error = %v", err)
+	}
+	if err := ioutil.WriteFile(deb, []byte("tampered"), 0644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if _, err := VerifyFile(deb, pub); err == nil {
+		t.Errorf("VerifyFile() of a changed package should fail")
+	}
+}
+
+func TestDefaultKeyFile(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/srv/state")
+	if got, err := DefaultKeyFile(); err != nil || got != "/srv/state/pkginstall/provenance.key" {
+		t.Errorf("DefaultKeyFile() = %s, %v", got, err)
+	}
+
+	// Without a state or home directory the key is not put in a shared one
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "")
+	if got, err := DefaultKeyFile(); err == nil {
+		t.Errorf("DefaultKeyFile() = %s, want an error without a home directory", got)
+	}
+}
@@ -0,0 +1,129 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AptlyPublisher uploads packages through the aptly REST API, adds them to a
+// local repository and optionally refreshes the published distribution.
+type AptlyPublisher struct {
+	target *Target
+}
+
+// newAptlyPublisher creates a publisher for an aptly API server
+func newAptlyPublisher(target *Target) (Publisher, error) {
+	if target.URL == "" || target.Repo == "" {
+		return nil, fmt.Errorf("aptly target requires a URL and a local repository name")
+	}
+	return &AptlyPublisher{target: target}, nil
+}
+
+// Name returns the publisher type
+func (p *AptlyPublisher) Name() string {
+	return "aptly"
+}
+
+// Publish uploads the package, adds it to the repository and updates the publication
+func (p *AptlyPublisher) Publish(debPath string) error {
+	if err := checkDeb(debPath); err != nil {
+		return err
+	}
+
+	base := strings.TrimRight(p.target.URL, "/") + "/api"
+	uploadDir := fmt.Sprintf("pkginstall-%d", time.Now().UnixNano())
+
+	if err := p.upload(base+"/files/"+uploadDir, debPath); err != nil {
+		return fmt.Errorf("aptly upload failed: %w", err)
+	}
+
+	addReq, err := p.newRequest(http.MethodPost, fmt.Sprintf("%s/repos/%s/file/%s", base, p.target.Repo, uploadDir), nil)
+	if err != nil {
+		return err
+	}
+	if err := doRequest(addReq); err != nil {
+		return fmt.Errorf("aptly failed to add package to %s: %w", p.target.Repo, err)
+	}
+
+	if p.target.Distribution == "" {
+		return nil
+	}
+
+	// Refresh the published repository so clients see the new package
+	prefix := p.target.Prefix
+	if prefix == "" {
+		prefix = "."
+	}
+	publishURL := fmt.Sprintf("%s/publish/%s/%s", base, aptlyEscapePrefix(prefix), p.target.Distribution)
+	updateReq, err := p.newRequest(http.MethodPut, publishURL, strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	updateReq.Header.Set("Content-Type", "application/json")
+	if err := doRequest(updateReq); err != nil {
+		return fmt.Errorf("aptly failed to update publication: %w", err)
+	}
+
+	return nil
+}
+
+// upload sends the package file as a multipart form to the aptly files API
+func (p *AptlyPublisher) upload(url, debPath string) error {
+	f, err := os.Open(debPath)
+	if err != nil {
+		return fmt.Errorf("failed to open package: %w", err)
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filepath.Base(debPath))
+	if err != nil {
+		return fmt.Errorf("failed to create form: %w", err)
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return fmt.Errorf("failed to read package: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finalize form: %w", err)
+	}
+
+	req, err := p.newRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return doRequest(req)
+}
+
+// newRequest creates a request with the target's credentials applied
+func (p *AptlyPublisher) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if p.target.Username != "" {
+		req.SetBasicAuth(p.target.Username, p.target.Password)
+	}
+	if p.target.Verbose {
+		fmt.Printf("%s %s\n", method, url)
+	}
+	return req, nil
+}
+
+// aptlyEscapePrefix encodes a publish prefix the way the aptly API expects:
+// "_" becomes "__", "/" becomes "_" and "." is written as ":."
+func aptlyEscapePrefix(prefix string) string {
+	if prefix == "." {
+		return ":."
+	}
+	prefix = strings.ReplaceAll(prefix, "_", "__")
+	return strings.ReplaceAll(prefix, "/", "_")
+}
@@ -0,0 +1,212 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/i2p"
+	"github.com/spf13/cobra"
+)
+
+// passwordEnv is consulted when no password is given on the command line,
+// so credentials do not have to appear in CI logs or shell history.
+const passwordEnv = "PKGINSTALL_PUBLISH_PASSWORD"
+
+// NewPublishCommand creates a command for uploading packages to remote repositories
+func NewPublishCommand() *cobra.Command {
+	target := &Target{}
+
+	cmd := &cobra.Command{
+		Use:   "publish [flags] <package>...",
+		Short: "Upload built packages to a remote repository",
+		Long: `Upload one or more .deb files to a remote repository, or packages to a
+Debian upload queue.
+
+Supported target types:
+  aptly        aptly REST API (--url, --repo, optional --distribution/--prefix to refresh the publication)
+  reprepro     reprepro includedeb over SSH (--ssh-host, --basedir, --distribution)
+  artifactory  Artifactory Debian repository (--url, --repo, --distribution, --component)
+  nexus        Nexus repository accepting HTTP PUT (--url)
+  webdav/http  Any server accepting HTTP PUT uploads (--url)
+  s3           S3 or S3-compatible object storage (--repo as bucket, optional --url, --region, --prefix)
+  ppa          Launchpad PPA upload queue (--repo user/name, --distribution, optional --sign-key)
+  dput         dak or other upload queue (--url ftp://, http(s)://, scp:// or sftp://, --distribution, optional --sign-key)
+  i2p          APT repository in --basedir served as an eepsite with --seed, or an eepsite accepting HTTP PUT (--url http://<host>.i2p/)
+
+The dput and ppa targets take a .dsc (source upload), a .deb (binary upload)
+or an existing .changes file. For a .dsc or .deb, a .changes file listing the
+checksums is written next to it and clearsigned with gpg; the listed files are
+uploaded first and the .changes file last. Launchpad only accepts source uploads.
+
+Passwords and secret keys may be supplied with the ` + passwordEnv + `
+environment variable instead of --password. S3 credentials also fall back to
+AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+
+The i2p target talks to the SAM bridge of a local I2P router (--sam). With
+--basedir, packages are added to a flat APT repository whose indexes are
+regenerated on every upload, and --seed then serves it over I2P until
+interrupted; the eepsite keys are kept in --i2p-keys so its .b32.i2p address
+stays the same. pkginstall fetch retrieves and verifies packages from it.
+
+Examples:
+  pkginstall publish --type aptly --url http://aptly:8080 --repo myrepo --distribution stable myapp_1.0_amd64.deb
+  pkginstall publish --type reprepro --ssh-host deploy@repo.example.org --basedir /srv/apt --distribution bookworm *.deb
+  pkginstall publish --type artifactory --url https://example.jfrog.io/artifactory --repo debian-local --user ci myapp_1.0_amd64.deb
+  pkginstall publish --type s3 --repo my-apt-bucket --prefix pool/main myapp_1.0_amd64.deb
+  pkginstall publish --type ppa --repo alice/tools --distribution jammy --sign-key alice@example.org myapp_1.0-1.dsc
+  pkginstall publish --type dput --url scp://upload@dak.example.org/srv/queue --distribution unstable myapp_1.0-1_amd64.deb
+  pkginstall publish --type i2p --basedir ./eepsite --seed myapp_1.0_amd64.deb
+`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if target.Seed {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPublishCommand(cmd.Context(), target, args)
+		},
+	}
+
+	cmd.Flags().StringVarP(&target.Type, "type", "t", "", "Target type ("+strings.Join(PublisherTypes(), ", ")+")")
+	cmd.Flags().StringVar(&target.URL, "url", "", "Base URL of the remote service")
+	cmd.Flags().StringVar(&target.Repo, "repo", "", "Repository name, repository key or bucket")
+	cmd.Flags().StringVar(&target.Distribution, "distribution", "", "Distribution/codename to publish to")
+	cmd.Flags().StringVar(&target.Component, "component", "", "Archive component (e.g. main)")
+	cmd.Flags().StringVar(&target.Prefix, "prefix", "", "Publish prefix (aptly) or key prefix (s3)")
+	cmd.Flags().StringVar(&target.Username, "user", "", "Username or access key")
+	cmd.Flags().StringVar(&target.Password, "password", "", "Password or secret key (prefer $"+passwordEnv+")")
+	cmd.Flags().StringVar(&target.Region, "region", "", "S3 region")
+	cmd.Flags().StringVar(&target.SSHHost, "ssh-host", "", "SSH destination for reprepro (user@host)")
+	cmd.Flags().StringVar(&target.BaseDir, "basedir", "", "reprepro base directory on the remote host, or local repository directory (i2p)")
+	cmd.Flags().StringVar(&target.SignKey, "sign-key", "",
+		"GPG key ID for signing .changes files (dput, ppa; default: gpg's default key) or the repository Release file (i2p)")
+	cmd.Flags().StringVar(&target.SAMAddress, "sam", i2p.DefaultSAMAddress, "SAM bridge of the I2P router (i2p)")
+	cmd.Flags().StringVar(&target.KeyFile, "i2p-keys", "",
+		"Eepsite key file, created if missing (i2p; default: $XDG_STATE_HOME/pkginstall/i2p/<basedir name>.keys)")
+	cmd.Flags().BoolVar(&target.Seed, "seed", false, "Keep serving the repository over I2P after publishing, until interrupted (i2p)")
+	cmd.Flags().BoolVarP(&target.Verbose, "verbose", "V", false, "Enable verbose output")
+
+	cmd.MarkFlagRequired("type")
+
+	return cmd
+}
+
+// runPublishCommand uploads every package given on the command line
+func runPublishCommand(ctx context.Context, target *Target, packages []string) error {
+	if target.Password == "" {
+		target.Password = os.Getenv(passwordEnv)
+	}
+
+	publisher, err := NewPublisher(target)
+	if err != nil {
+		return err
+	}
+	if closer, ok := publisher.(io.Closer); ok {
+		defer closer.Close()
+	}
+	seeder, ok := publisher.(Seeder)
+	if target.Seed && !ok {
+		return fmt.Errorf("--seed is not supported by %s targets", publisher.Name())
+	}
+
+	for _, debPath := range packages {
+		fmt.Printf("Publishing %s to %s target...\n", debPath, publisher.Name())
+		if err := publisher.Publish(debPath); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", debPath, err)
+		}
+	}
+
+	if len(packages) > 0 {
+		fmt.Printf("Successfully published %d package(s)\n", len(packages))
+	}
+	if target.Seed {
+		return seeder.Seed(ctx)
+	}
+	return nil
+}
+
+// FetchCommandOptions contains options for the fetch command
+type FetchCommandOptions struct {
+	FetchOptions
+	Repo         string
+	OutputDir    string
+	Architecture string
+}
+
+// NewFetchCommand creates a command that downloads and verifies packages
+// from a repository, over I2P for .i2p hosts
+func NewFetchCommand() *cobra.Command {
+	options := &FetchCommandOptions{OutputDir: "."}
+
+	cmd := &cobra.Command{
+		Use:   "fetch [flags] <package>[=<version>]...",
+		Short: "Download and verify packages from a repository, over I2P for .i2p hosts",
+		Long: `Download packages from a flat APT repository, such as one published with
+pkginstall publish --type i2p --seed.
+
+Repositories on .i2p hosts are reached anonymously through the SAM bridge of
+the local I2P router (--sam); other hosts are contacted directly. The
+Packages index must match its checksum in the Release file, and every package
+must match the size and SHA256 in the index. The Release file must also carry
+a valid signature: Release.gpg from a key in the --keyring, Release.minisig
+for the --minisign-key public key, or Release.sig for the --signify-key public
+key, as written by pkginstall repo generate. Every key given is checked.
+--insecure fetches from a repository without checking its signature.
+
+Examples:
+  pkginstall fetch --repo http://abcdef...xyz.b32.i2p/ myapp
+  pkginstall fetch --repo http://abcdef...xyz.b32.i2p/ --minisign-key repo.pub myapp
+  pkginstall fetch --repo http://apt.example.i2p/ --keyring repo.gpg --arch amd64 myapp=1.1 -o dist
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFetchCommand(cmd.Context(), options, args)
+		},
+	}
+
+	cmd.Flags().StringVar(&options.Repo, "repo", "", "URL of the repository")
+	cmd.Flags().StringVarP(&options.OutputDir, "output", "o", options.OutputDir, "Directory the packages are written to")
+	cmd.Flags().StringVar(&options.Architecture, "arch", "", "Architecture of the packages (default: any)")
+	cmd.Flags().StringVar(&options.Keyring, "keyring", "", "GPG keyring the Release file must be signed with")
+	cmd.Flags().StringVar(&options.MinisignKey, "minisign-key", "", "minisign public key checking Release.minisig")
+	cmd.Flags().StringVar(&options.SignifyKey, "signify-key", "", "signify public key checking Release.sig")
+	cmd.Flags().BoolVar(&options.Insecure, "insecure", false, "Fetch without checking the Release signature when no key is given")
+	cmd.Flags().StringVar(&options.SAMAddress, "sam", i2p.DefaultSAMAddress, "SAM bridge of the I2P router")
+	cmd.Flags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
+
+	cmd.MarkFlagRequired("repo")
+
+	return cmd
+}
+
+// runFetchCommand downloads every package given on the command line
+func runFetchCommand(ctx context.Context, options *FetchCommandOptions, packages []string) error {
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	fetcher, err := NewFetcher(ctx, options.Repo, options.FetchOptions)
+	if err != nil {
+		return err
+	}
+	defer fetcher.Close()
+	if options.Keyring == "" && options.MinisignKey == "" && options.SignifyKey == "" {
+		fmt.Fprintf(os.Stderr, "Warning: not checking the Release signature of %s (--insecure)\n", options.Repo)
+	}
+
+	index, err := fetcher.Index(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range packages {
+		path, err := fetcher.Fetch(ctx, index, spec, options.Architecture, options.OutputDir)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", spec, err)
+		}
+		fmt.Printf("Fetched and verified %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,538 @@
+package publish
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/control"
+)
+
+// launchpadUploadHost is the anonymous FTP upload queue of Launchpad PPAs
+const launchpadUploadHost = "ppa.launchpad.net"
+
+// ftpTimeout bounds connection setup and every FTP command
+const ftpTimeout = 2 * time.Minute
+
+// runGPG invokes gpg with the given arguments.
+// It is a variable so tests can substitute it.
+var runGPG = func(args ...string) error {
+	cmd := exec.Command("gpg", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// debControl returns the control file of a .deb.
+// It is a variable so tests can substitute it.
+var debControl = func(debPath string) (string, error) {
+	out, err := exec.Command("dpkg-deb", "--field", debPath).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read control file of %s: %w", debPath, err)
+	}
+	return string(out), nil
+}
+
+// DputPublisher uploads Debian packages to an upload queue the way dput does:
+// it writes and signs a .changes file, uploads the files it lists and the
+// .changes last, so Launchpad or dak only processes complete uploads.
+type DputPublisher struct {
+	target *Target
+	queue  *url.URL
+	name   string
+}
+
+// newDputPublisher creates a publisher for an upload queue given as an
+// ftp://, http(s)://, scp:// or sftp:// URL
+func newDputPublisher(target *Target) (Publisher, error) {
+	if target.URL == "" {
+		return nil, fmt.Errorf("dput target requires an upload queue URL")
+	}
+	return newUploadQueue(target, "dput", target.URL)
+}
+
+// newPPAPublisher creates a publisher for a Launchpad PPA given as
+// --repo user/name (or ppa:user/name)
+func newPPAPublisher(target *Target) (Publisher, error) {
+	if target.URL != "" {
+		return newUploadQueue(target, "ppa", target.URL)
+	}
+	repo := strings.TrimPrefix(strings.TrimPrefix(target.Repo, "ppa:"), "~")
+	user, name, ok := strings.Cut(repo, "/")
+	if !ok || user == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("ppa target requires --repo user/name")
+	}
+	return newUploadQueue(target, "ppa", fmt.Sprintf("ftp://%s/~%s/ubuntu/%s", launchpadUploadHost, user, name))
+}
+
+// newUploadQueue parses the upload queue URL of a dput or ppa target
+func newUploadQueue(target *Target, name, rawURL string) (Publisher, error) {
+	queue, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload queue URL: %w", err)
+	}
+	switch queue.Scheme {
+	case "ftp", "http", "https", "scp", "sftp":
+	default:
+		return nil, fmt.Errorf("unsupported upload method %q (use ftp, http, https, scp or sftp)", queue.Scheme)
+	}
+	if queue.Host == "" || strings.HasPrefix(queue.Host, "-") {
+		return nil, fmt.Errorf("invalid upload queue host: %q", queue.Host)
+	}
+	return &DputPublisher{target: target, queue: queue, name: name}, nil
+}
+
+// Name returns the publisher type
+func (p *DputPublisher) Name() string {
+	return p.name
+}
+
+// Publish uploads a .dsc (source upload), a .deb (binary upload) or an
+// existing .changes file together with the files it lists
+func (p *DputPublisher) Publish(path string) error {
+	changesPath := path
+	if !strings.HasSuffix(path, ".changes") {
+		var err error
+		if changesPath, err = p.writeChanges(path); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", changesPath)
+	}
+
+	content, err := ioutil.ReadFile(changesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", changesPath, err)
+	}
+	paragraph, err := control.ParseParagraph(stripSignature(string(content)))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", filepath.Base(changesPath), err)
+	}
+	fields := paragraph.Map()
+	dir := filepath.Dir(changesPath)
+	var files []string
+	for _, line := range strings.Split(fields["Files"], "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 5 || strings.ContainsAny(parts[4], "/\\") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, parts[4]))
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s lists no files", changesPath)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("file listed in %s is missing: %w", filepath.Base(changesPath), err)
+		}
+	}
+	files = append(files, changesPath)
+
+	switch p.queue.Scheme {
+	case "ftp":
+		return p.uploadFTP(files)
+	case "http", "https":
+		return p.uploadHTTP(files)
+	default:
+		return p.uploadSCP(files)
+	}
+}
+
+// changesFile describes one file listed in a .changes file
+type changesFile struct {
+	path     string
+	section  string
+	priority string
+}
+
+// writeChanges creates and signs the .changes file for a .dsc or .deb next
+// to it and returns its path
+func (p *DputPublisher) writeChanges(path string) (string, error) {
+	if p.target.Distribution == "" {
+		return "", fmt.Errorf("%s target requires a distribution to write a .changes file", p.name)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("package file error: %w", err)
+	}
+
+	var fields map[string]string
+	var files []changesFile
+	var architecture, description string
+	switch {
+	case strings.HasSuffix(path, ".dsc"):
+		paragraph, err := control.ParseParagraph(stripSignature(string(content)))
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %w", filepath.Base(path), err)
+		}
+		fields = paragraph.Map()
+		section, priority := "misc", "optional"
+		// Package-List: <name> <type> <section> <priority> [arch=...]
+		if list := strings.Fields(strings.SplitN(strings.TrimSpace(fields["Package-List"]), "\n", 2)[0]); len(list) >= 4 {
+			section, priority = list[2], list[3]
+		}
+		files = append(files, changesFile{path, section, priority})
+		for _, line := range strings.Split(fields["Files"], "\n") {
+			parts := strings.Fields(line)
+			if len(parts) != 3 || strings.ContainsAny(parts[2], "/\\") {
+				continue
+			}
+			files = append(files, changesFile{filepath.Join(filepath.Dir(path), parts[2]), section, priority})
+		}
+		architecture = "source"
+	case strings.HasSuffix(path, ".deb"):
+		controlData, err := debControl(path)
+		if err != nil {
+			return "", err
+		}
+		paragraph, err := control.ParseParagraph(controlData)
+		if err != nil {
+			return "", fmt.Errorf("invalid control file in %s: %w", filepath.Base(path), err)
+		}
+		fields = paragraph.Map()
+		fields["Binary"] = fields["Package"]
+		if fields["Source"] == "" {
+			fields["Source"] = fields["Package"]
+		}
+		// Source: name (version) names a source version differing from the binary one
+		if source := strings.Fields(fields["Source"]); len(source) > 0 {
+			fields["Source"] = source[0]
+		}
+		section, priority := fields["Section"], fields["Priority"]
+		if section == "" {
+			section = "misc"
+		}
+		if priority == "" {
+			priority = "optional"
+		}
+		files = append(files, changesFile{path, section, priority})
+		architecture = fields["Architecture"]
+		synopsis, _, _ := strings.Cut(fields["Description"], "\n")
+		description = fmt.Sprintf("%s - %s", fields["Package"], synopsis)
+	default:
+		return "", fmt.Errorf("not a .dsc, .deb or .changes file: %s", path)
+	}
+
+	if fields["Source"] == "" || fields["Version"] == "" || fields["Maintainer"] == "" {
+		return "", fmt.Errorf("%s lacks a Source, Version or Maintainer field", filepath.Base(path))
+	}
+
+	version := fields["Version"]
+	if _, rest, ok := strings.Cut(version, ":"); ok {
+		version = rest
+	}
+	changesPath := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s_%s_%s.changes", fields["Source"], version, architecture))
+
+	var changes strings.Builder
+	fmt.Fprintf(&changes, "Format: 1.8\n")
+	fmt.Fprintf(&changes, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&changes, "Source: %s\n", fields["Source"])
+	fmt.Fprintf(&changes, "Binary: %s\n", strings.Join(strings.FieldsFunc(fields["Binary"], func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }), " "))
+	fmt.Fprintf(&changes, "Architecture: %s\n", architecture)
+	fmt.Fprintf(&changes, "Version: %s\n", fields["Version"])
+	fmt.Fprintf(&changes, "Distribution: %s\n", p.target.Distribution)
+	fmt.Fprintf(&changes, "Urgency: medium\n")
+	fmt.Fprintf(&changes, "Maintainer: %s\n", fields["Maintainer"])
+	fmt.Fprintf(&changes, "Changed-By: %s\n", fields["Maintainer"])
+	if description != "" {
+		fmt.Fprintf(&changes, "Description:\n %s\n", description)
+	}
+	fmt.Fprintf(&changes, "Changes:\n %s (%s) %s; urgency=medium\n .\n   * Upload of %s %s.\n",
+		fields["Source"], fields["Version"], p.target.Distribution, fields["Source"], fields["Version"])
+
+	var sha1Lines, sha256Lines, md5Lines strings.Builder
+	for _, file := range files {
+		sums, size, err := fileChecksums(file.path)
+		if err != nil {
+			return "", err
+		}
+		name := filepath.Base(file.path)
+		fmt.Fprintf(&sha1Lines, " %s %d %s\n", sums[1], size, name)
+		fmt.Fprintf(&sha256Lines, " %s %d %s\n", sums[2], size, name)
+		fmt.Fprintf(&md5Lines, " %s %d %s %s %s\n", sums[0], size, file.section, file.priority, name)
+	}
+	changes.WriteString("Checksums-Sha1:\n" + sha1Lines.String())
+	changes.WriteString("Checksums-Sha256:\n" + sha256Lines.String())
+	changes.WriteString("Files:\n" + md5Lines.String())
+
+	if err := p.signChanges(changesPath, changes.String()); err != nil {
+		return "", err
+	}
+	return changesPath, nil
+}
+
+// signChanges writes the clearsigned .changes file; the upload queues
+// reject unsigned uploads
+func (p *DputPublisher) signChanges(changesPath, content string) error {
+	unsigned := changesPath + ".unsigned"
+	if err := ioutil.WriteFile(unsigned, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", unsigned, err)
+	}
+	defer os.Remove(unsigned)
+
+	args := []string{"--batch", "--yes"}
+	if p.target.SignKey != "" {
+		args = append(args, "--local-user", p.target.SignKey)
+	}
+	args = append(args, "--clearsign", "--output", changesPath, unsigned)
+	if err := runGPG(args...); err != nil {
+		return fmt.Errorf("failed to sign %s: %w", filepath.Base(changesPath), err)
+	}
+	return nil
+}
+
+// fileChecksums returns the MD5, SHA-1 and SHA-256 of a file and its size
+func fileChecksums(path string) ([3]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [3]string{}, 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), f)
+	if err != nil {
+		return [3]string{}, 0, fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	return [3]string{
+		hex.EncodeToString(md5Hash.Sum(nil)),
+		hex.EncodeToString(sha1Hash.Sum(nil)),
+		hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, size, nil
+}
+
+// stripSignature returns the signed text of a clearsigned message, or the
+// content unchanged when it is not signed
+func stripSignature(content string) string {
+	if !strings.HasPrefix(content, "-----BEGIN PGP SIGNED MESSAGE-----") {
+		return content
+	}
+	_, body, _ := strings.Cut(content, "\n\n")
+	body, _, _ = strings.Cut(body, "-----BEGIN PGP SIGNATURE-----")
+	return body
+}
+
+// uploadFTP uploads the files to the incoming directory of an FTP queue,
+// anonymously unless a user is given
+func (p *DputPublisher) uploadFTP(files []string) error {
+	host := p.queue.Host
+	if p.queue.Port() == "" {
+		host = net.JoinHostPort(p.queue.Hostname(), "21")
+	}
+	conn, err := net.DialTimeout("tcp", host, ftpTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+	defer conn.Close()
+	ftp := &ftpConn{conn: conn, text: textproto.NewConn(conn), host: p.queue.Hostname()}
+
+	username, password := p.target.Username, p.target.Password
+	if username == "" {
+		username, password = "anonymous", "anonymous@"
+	}
+	if _, err := ftp.response(2); err != nil {
+		return err
+	}
+	code, err := ftp.cmd(0, "USER %s", username)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		if _, err := ftp.cmd(2, "PASS %s", password); err != nil {
+			return err
+		}
+	} else if code/100 != 2 {
+		return fmt.Errorf("FTP login as %s failed with code %d", username, code)
+	}
+	if _, err := ftp.cmd(2, "TYPE I"); err != nil {
+		return err
+	}
+	if incoming := strings.Trim(p.queue.Path, "/"); incoming != "" {
+		if _, err := ftp.cmd(2, "CWD %s", incoming); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if p.target.Verbose {
+			fmt.Printf("STOR %s\n", filepath.Base(file))
+		}
+		if err := ftp.store(file); err != nil {
+			return err
+		}
+	}
+	ftp.cmd(0, "QUIT")
+	return nil
+}
+
+// ftpConn is the control connection of a passive-mode FTP upload
+type ftpConn struct {
+	conn net.Conn
+	text *textproto.Conn
+	host string
+}
+
+// cmd sends a command and reads its reply, which must start with expect
+// unless expect is 0
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, error) {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return 0, fmt.Errorf("FTP command failed: %w", err)
+	}
+	return c.response(expect)
+}
+
+// response reads one reply from the server
+func (c *ftpConn) response(expect int) (int, error) {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	code, msg, err := c.text.ReadResponse(expect)
+	if err != nil {
+		return code, fmt.Errorf("FTP server: %w", err)
+	}
+	if expect == 0 && code >= 400 {
+		return code, fmt.Errorf("FTP server: %d %s", code, msg)
+	}
+	return code, nil
+}
+
+// store uploads a file over a passive data connection
+func (c *ftpConn) store(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if err := c.text.PrintfLine("PASV"); err != nil {
+		return fmt.Errorf("FTP command failed: %w", err)
+	}
+	_, msg, err := c.text.ReadResponse(227)
+	if err != nil {
+		return fmt.Errorf("FTP server: %w", err)
+	}
+	port, err := parsePASV(msg)
+	if err != nil {
+		return err
+	}
+	// The data connection goes to the control host: the address in the
+	// PASV reply is often private or unreachable behind NAT
+	data, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), ftpTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to open FTP data connection: %w", err)
+	}
+	defer data.Close()
+
+	if _, err := c.cmd(1, "STOR %s", filepath.Base(path)); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(data)
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filepath.Base(path), err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filepath.Base(path), err)
+	}
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filepath.Base(path), err)
+	}
+	if _, err := c.response(2); err != nil {
+		return fmt.Errorf("upload of %s failed: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// parsePASV returns the data port of a 227 reply such as
+// "Entering Passive Mode (192,0,2,1,195,80)"
+func parsePASV(msg string) (int, error) {
+	start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("unexpected PASV reply: %s", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return 0, fmt.Errorf("unexpected PASV reply: %s", msg)
+	}
+	high, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	low, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("unexpected PASV reply: %s", msg)
+	}
+	return high<<8 | low, nil
+}
+
+// uploadHTTP uploads every file with an HTTP PUT, as dput's http method does
+func (p *DputPublisher) uploadHTTP(files []string) error {
+	base := strings.TrimRight(p.queue.String(), "/")
+	for _, file := range files {
+		if err := func() error {
+			f, err := os.Open(file)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", file, err)
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", file, err)
+			}
+
+			dest := base + "/" + url.PathEscape(filepath.Base(file))
+			req, err := http.NewRequest(http.MethodPut, dest, f)
+			if err != nil {
+				return fmt.Errorf("failed to create upload request: %w", err)
+			}
+			req.ContentLength = info.Size()
+			if p.target.Username != "" {
+				req.SetBasicAuth(p.target.Username, p.target.Password)
+			}
+			if p.target.Verbose {
+				fmt.Printf("PUT %s\n", dest)
+			}
+			return doRequest(req)
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadSCP copies the files into the queue directory with scp, the
+// .changes file in a second call so it arrives last
+func (p *DputPublisher) uploadSCP(files []string) error {
+	host := p.queue.Hostname()
+	if p.queue.User != nil {
+		host = p.queue.User.Username() + "@" + p.queue.Hostname()
+	}
+	dest := host + ":" + strings.TrimSuffix(p.queue.Path, "/") + "/"
+	args := []string{"-q"}
+	if port := p.queue.Port(); port != "" {
+		args = append(args, "-P", port)
+	}
+	args = append(args, "--")
+
+	last := len(files) - 1
+	if p.target.Verbose {
+		fmt.Printf("scp %s %s\n", strings.Join(files, " "), dest)
+	}
+	if err := runCommand("scp", append(append(append([]string{}, args...), files[:last]...), dest)...); err != nil {
+		return fmt.Errorf("failed to copy files to %s: %w", dest, err)
+	}
+	if err := runCommand("scp", append(append(append([]string{}, args...), files[last]), dest)...); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", filepath.Base(files[last]), dest, err)
+	}
+	return nil
+}
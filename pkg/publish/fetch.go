@@ -0,0 +1,315 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/control"
+	"github.com/go-i2p/go-pkginstall/pkg/i2p"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/signature"
+)
+
+// Fetcher downloads packages from a flat APT repository, such as one seeded
+// by the i2p publisher, and verifies them against its indexes. Repositories
+// on .i2p hosts are reached through the SAM bridge.
+type Fetcher struct {
+	base    *url.URL
+	client  *http.Client
+	session *i2p.Session
+	options FetchOptions
+}
+
+// FetchOptions configures a Fetcher. The Release file must carry a valid
+// signature for every key given, and at least one key is required unless
+// Insecure is set.
+type FetchOptions struct {
+	SAMAddress  string // SAM bridge of the I2P router
+	Keyring     string // GPG keyring checking Release.gpg
+	MinisignKey string // minisign public key checking Release.minisig
+	SignifyKey  string // signify public key checking Release.sig
+	Insecure    bool   // Accept an unchecked Release file when no key is given
+	Verbose     bool
+	Logger      *slog.Logger // Logger for verbose messages (default: slog.Default())
+}
+
+// Bounds on the files a fetch downloads before they can be checked, so a
+// hostile mirror cannot exhaust memory or disk. Packages indexes and .deb
+// files are bounded by the sizes listed for them; a Packages index larger
+// than maxPackagesSize is refused even if the Release file lists it.
+const (
+	maxReleaseSize   = 16 << 20
+	maxSignatureSize = 64 << 10
+	maxPackagesSize  = 512 << 20
+)
+
+// verifySignature checks a minisign or signify signature.
+// It is a variable so tests can substitute it.
+var verifySignature = signature.Verify
+
+// NewFetcher creates a Fetcher for the repository at repoURL
+func NewFetcher(ctx context.Context, repoURL string, options FetchOptions) (*Fetcher, error) {
+	base, err := url.Parse(repoURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("repository URL %s is not an http:// URL", repoURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	if options.Keyring == "" && options.MinisignKey == "" && options.SignifyKey == "" && !options.Insecure {
+		return nil, ci.Errorf(ci.ClassUsage, "the Release signature of %s cannot be checked without --keyring, --minisign-key or --signify-key; use --insecure to fetch without checking it", repoURL)
+	}
+	f := &Fetcher{base: base, client: httpClient, options: options}
+	if i2p.IsI2PHost(base.Host) {
+		ctx, cancel := context.WithTimeout(ctx, sessionTimeout)
+		defer cancel()
+		if f.session, err = i2p.NewSession(ctx, options.SAMAddress, nil); err != nil {
+			return nil, err
+		}
+		f.client = &http.Client{
+			Timeout:   httpClient.Timeout,
+			Transport: &http.Transport{DialContext: f.session.DialContext},
+		}
+	}
+	return f, nil
+}
+
+// Close ends the I2P session of the fetcher
+func (f *Fetcher) Close() error {
+	if f.session == nil {
+		return nil
+	}
+	return f.session.Close()
+}
+
+// get downloads a file of the repository into w, failing once it is larger
+// than limit bytes
+func (f *Fetcher) get(ctx context.Context, name string, w io.Writer, limit int64) error {
+	u := f.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(name, "./")})
+	logging.Logf(f.options.Logger, logging.Verbose(f.options.Verbose), "GET %s", u.Redacted())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", u.Redacted(), resp.Status)
+	}
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%s is larger than %d bytes", u.Redacted(), limit)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", u.Redacted(), err)
+	}
+	if n > limit {
+		return fmt.Errorf("%s is larger than %d bytes", u.Redacted(), limit)
+	}
+	return nil
+}
+
+// Index downloads the Packages index and returns its paragraphs. The index
+// must match its checksum in the Release file, which must be signed by the
+// configured keys.
+func (f *Fetcher) Index(ctx context.Context) ([]map[string]string, error) {
+	var release strings.Builder
+	if err := f.get(ctx, "Release", &release, maxReleaseSize); err != nil {
+		return nil, err
+	}
+	if err := f.verifyRelease(ctx, release.String()); err != nil {
+		return nil, err
+	}
+	size, sum, ok := releaseChecksum(release.String(), "Packages")
+	if !ok {
+		return nil, fmt.Errorf("Release file does not list a SHA256 checksum of Packages")
+	}
+	if size > maxPackagesSize {
+		return nil, fmt.Errorf("Packages index of %d bytes is larger than the limit of %d bytes", size, maxPackagesSize)
+	}
+
+	var packages strings.Builder
+	if err := f.get(ctx, "Packages", &packages, size); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(packages.String()))
+	if int64(packages.Len()) != size || hex.EncodeToString(digest[:]) != sum {
+		return nil, fmt.Errorf("Packages index does not match its checksum in the Release file")
+	}
+	paragraphs, err := control.Parse(strings.NewReader(packages.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Packages index: %w", err)
+	}
+	index := make([]map[string]string, 0, len(paragraphs))
+	for _, paragraph := range paragraphs {
+		index = append(index, paragraph.Map())
+	}
+	return index, nil
+}
+
+// verifyRelease checks the detached signatures of the Release file: with
+// gpgv for the keyring, and with minisign or signify for their public keys
+func (f *Fetcher) verifyRelease(ctx context.Context, release string) error {
+	keys := []struct {
+		scheme signature.Scheme
+		key    string
+	}{
+		{signature.Minisign, f.options.MinisignKey},
+		{signature.Signify, f.options.SignifyKey},
+	}
+	if f.options.Keyring == "" && keys[0].key == "" && keys[1].key == "" {
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pkginstall-fetch-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	releaseFile := filepath.Join(tmpDir, "Release")
+	if err := os.WriteFile(releaseFile, []byte(release), 0644); err != nil {
+		return err
+	}
+	// download fetches the signature name of the Release file next to the copy
+	download := func(name string) (string, error) {
+		sigPath := filepath.Join(tmpDir, name)
+		sigFile, err := os.Create(sigPath)
+		if err != nil {
+			return "", err
+		}
+		err = f.get(ctx, name, sigFile, maxSignatureSize)
+		sigFile.Close()
+		if err != nil {
+			return "", fmt.Errorf("repository is not signed: %w", err)
+		}
+		return sigPath, nil
+	}
+
+	if f.options.Keyring != "" {
+		sigPath, err := download("Release.gpg")
+		if err != nil {
+			return err
+		}
+		if err := runCommand("gpgv", "--keyring", f.options.Keyring, sigPath, releaseFile); err != nil {
+			return fmt.Errorf("Release signature does not verify with %s: %w", f.options.Keyring, err)
+		}
+	}
+	for _, key := range keys {
+		if key.key == "" {
+			continue
+		}
+		if _, err := download("Release" + key.scheme.Extension()); err != nil {
+			return err
+		}
+		if err := verifySignature(key.scheme, key.key, releaseFile); err != nil {
+			return fmt.Errorf("Release signature does not verify: %w", err)
+		}
+	}
+	return nil
+}
+
+// Fetch downloads the package named by spec, name or name=version, of the
+// given architecture (any if empty) into outputDir and returns its path.
+// Packages that do not match their size and SHA256 in the index are deleted.
+func (f *Fetcher) Fetch(ctx context.Context, index []map[string]string, spec, arch, outputDir string) (string, error) {
+	name, version, _ := strings.Cut(spec, "=")
+	var matches []map[string]string
+	for _, entry := range index {
+		if entry["Package"] != name || (version != "" && entry["Version"] != version) {
+			continue
+		}
+		if arch != "" && entry["Architecture"] != arch && entry["Architecture"] != "all" {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	switch {
+	case len(matches) == 0:
+		return "", fmt.Errorf("repository has no package %s", spec)
+	case len(matches) > 1:
+		var versions []string
+		for _, entry := range matches {
+			versions = append(versions, entry["Version"]+" ("+entry["Architecture"]+")")
+		}
+		return "", fmt.Errorf("repository has several packages %s: %s; select one with name=version and --arch", spec, strings.Join(versions, ", "))
+	}
+	entry := matches[0]
+	size, err := strconv.ParseInt(entry["Size"], 10, 64)
+	if err != nil || entry["SHA256"] == "" || entry["Filename"] == "" {
+		return "", fmt.Errorf("index entry of %s has no Filename, Size or SHA256", spec)
+	}
+	fileName := path.Base(entry["Filename"])
+	if fileName == "." || fileName == "/" || fileName == ".." {
+		return "", fmt.Errorf("index entry of %s has an invalid Filename %q", spec, entry["Filename"])
+	}
+
+	tmp, err := os.CreateTemp(outputDir, ".fetch-*.deb")
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(tmp, hash))
+	err = f.get(ctx, entry["Filename"], w, size)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	if info.Size() != size || hex.EncodeToString(hash.Sum(nil)) != entry["SHA256"] {
+		return "", fmt.Errorf("%s does not match its checksum in the repository index", entry["Filename"])
+	}
+	dest := filepath.Join(outputDir, fileName)
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", fmt.Errorf("failed to write package: %w", err)
+	}
+	return dest, nil
+}
+
+// releaseChecksum returns the size and SHA256 of a file listed in a Release file
+func releaseChecksum(release, name string) (int64, string, bool) {
+	inSection := false
+	for _, line := range strings.Split(release, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			inSection = strings.TrimSpace(line) == "SHA256:"
+			continue
+		}
+		parts := strings.Fields(line)
+		if !inSection || len(parts) != 3 || parts[2] != name {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, "", false
+		}
+		return size, parts[0], true
+	}
+	return 0, "", false
+}
@@ -0,0 +1,152 @@
+package publish
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// httpClient is shared by all HTTP based publishers
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
+// HTTPPublisher uploads packages with a plain HTTP PUT. It works with WebDAV
+// servers, Nexus raw/apt repositories and any endpoint accepting PUT uploads.
+type HTTPPublisher struct {
+	target *Target
+	// matrixParams are appended to the upload URL (used by Artifactory)
+	matrixParams func(debFields) string
+	name         string
+	// client sends the uploads; nil uses httpClient
+	client *http.Client
+}
+
+// newHTTPPublisher creates a publisher for generic HTTP PUT targets
+func newHTTPPublisher(target *Target) (Publisher, error) {
+	if target.URL == "" {
+		return nil, fmt.Errorf("%s target requires a URL", target.Type)
+	}
+	return &HTTPPublisher{target: target, name: strings.ToLower(target.Type)}, nil
+}
+
+// newArtifactoryPublisher creates a publisher for Artifactory Debian repositories.
+// Artifactory indexes packages using deb.* matrix parameters on the upload URL.
+func newArtifactoryPublisher(target *Target) (Publisher, error) {
+	if target.URL == "" || target.Repo == "" {
+		return nil, fmt.Errorf("artifactory target requires a URL and a repository key")
+	}
+
+	distribution := target.Distribution
+	if distribution == "" {
+		distribution = "stable"
+	}
+	component := target.Component
+	if component == "" {
+		component = "main"
+	}
+
+	return &HTTPPublisher{
+		target: target,
+		name:   "artifactory",
+		matrixParams: func(fields debFields) string {
+			return fmt.Sprintf(";deb.distribution=%s;deb.component=%s;deb.architecture=%s",
+				distribution, component, fields.Architecture)
+		},
+	}, nil
+}
+
+// Name returns the publisher type
+func (p *HTTPPublisher) Name() string {
+	return p.name
+}
+
+// uploadURL builds the destination URL for a package file
+func (p *HTTPPublisher) uploadURL(debPath string) (string, error) {
+	base := strings.TrimRight(p.target.URL, "/")
+	if p.name == "artifactory" {
+		base += "/" + p.target.Repo
+	}
+
+	fileName := filepath.Base(debPath)
+	var dest string
+	if p.name == "artifactory" {
+		fields, err := parseDebFilename(debPath)
+		if err != nil {
+			return "", err
+		}
+		// Follow the pool layout so Artifactory stores packages like a Debian archive
+		dest = fmt.Sprintf("%s/pool/%s/%s%s", base, fields.Name, fileName, p.matrixParams(fields))
+	} else {
+		dest = base + "/" + fileName
+	}
+	return dest, nil
+}
+
+// Publish uploads the package with an HTTP PUT request
+func (p *HTTPPublisher) Publish(debPath string) error {
+	if err := checkDeb(debPath); err != nil {
+		return err
+	}
+
+	dest, err := p.uploadURL(debPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(debPath)
+	if err != nil {
+		return fmt.Errorf("failed to open package: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat package: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, dest, f)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/vnd.debian.binary-package")
+	if p.target.Username != "" {
+		req.SetBasicAuth(p.target.Username, p.target.Password)
+	}
+
+	if p.target.Verbose {
+		fmt.Printf("PUT %s\n", dest)
+	}
+
+	client := p.client
+	if client == nil {
+		client = httpClient
+	}
+	return doRequestWith(client, req)
+}
+
+// doRequest sends a request and converts non-2xx responses into errors
+func doRequest(req *http.Request) error {
+	return doRequestWith(httpClient, req)
+}
+
+// doRequestWith sends a request with the given client, see doRequest
+func doRequestWith(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s returned %s: %s",
+			req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
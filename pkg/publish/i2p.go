@@ -0,0 +1,202 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/i2p"
+	"github.com/go-i2p/go-pkginstall/pkg/repo"
+)
+
+// sessionTimeout bounds how long the router may take to build tunnels
+const sessionTimeout = 3 * time.Minute
+
+// Seeder is a Publisher that can keep serving what it published until the
+// context is cancelled
+type Seeder interface {
+	Seed(ctx context.Context) error
+}
+
+// I2PPublisher distributes packages over I2P. With a base directory it adds
+// them to a local APT repository that Seed serves as an eepsite; with a URL
+// it uploads them with HTTP PUT to an eepsite through the SAM bridge.
+type I2PPublisher struct {
+	target  *Target
+	upload  *HTTPPublisher
+	session *i2p.Session
+}
+
+// newI2PPublisher creates a publisher for I2P targets
+func newI2PPublisher(target *Target) (Publisher, error) {
+	switch {
+	case target.BaseDir == "" && target.URL == "":
+		return nil, fmt.Errorf("i2p target requires a repository directory (--basedir) or an eepsite URL (--url)")
+	case target.BaseDir != "" && target.URL != "":
+		return nil, fmt.Errorf("i2p target takes either a repository directory or an eepsite URL, not both")
+	case target.URL != "":
+		u, err := url.Parse(target.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !i2p.IsI2PHost(u.Host) {
+			return nil, fmt.Errorf("i2p target URL %s is not an http:// URL of an .i2p host", target.URL)
+		}
+		return &I2PPublisher{target: target, upload: &HTTPPublisher{target: target, name: "i2p"}}, nil
+	}
+	return &I2PPublisher{target: target}, nil
+}
+
+// Name returns the publisher type
+func (p *I2PPublisher) Name() string {
+	return "i2p"
+}
+
+// Publish adds the package to the local repository, or uploads it to the
+// eepsite
+func (p *I2PPublisher) Publish(debPath string) error {
+	if err := checkDeb(debPath); err != nil {
+		return err
+	}
+	if p.upload != nil {
+		if p.session == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), sessionTimeout)
+			defer cancel()
+			session, err := i2p.NewSession(ctx, p.target.SAMAddress, nil)
+			if err != nil {
+				return err
+			}
+			p.session = session
+			p.upload.client = &http.Client{
+				Timeout:   httpClient.Timeout,
+				Transport: &http.Transport{DialContext: session.DialContext},
+			}
+		}
+		return p.upload.Publish(debPath)
+	}
+	return p.addToRepository(debPath)
+}
+
+// addToRepository copies the package into the pool of the repository and
+// regenerates its indexes
+func (p *I2PPublisher) addToRepository(debPath string) error {
+	fields, err := parseDebFilename(debPath)
+	if err != nil {
+		return err
+	}
+	poolDir := filepath.Join(p.target.BaseDir, "pool", fields.Name)
+	if err := os.MkdirAll(poolDir, 0755); err != nil {
+		return fmt.Errorf("failed to create pool directory: %w", err)
+	}
+	dest := filepath.Join(poolDir, filepath.Base(debPath))
+	if err := copyFile(debPath, dest); err != nil {
+		return err
+	}
+	if p.target.Verbose {
+		fmt.Printf("Added %s\n", dest)
+	}
+
+	generator, err := repo.NewGenerator(p.target.BaseDir, repo.WithRepoVerbose(p.target.Verbose), repo.WithSignKey(p.target.SignKey))
+	if err != nil {
+		return err
+	}
+	if _, err := generator.Generate(); err != nil {
+		return fmt.Errorf("failed to update repository indexes: %w", err)
+	}
+	return nil
+}
+
+// copyFile copies src to dest through a temporary file, so the repository
+// never serves a partial package
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open package: %w", err)
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy package to %s: %w", dest, err)
+	}
+	return nil
+}
+
+// Seed serves the repository directory as an eepsite until ctx is cancelled.
+// The keys in KeyFile, created on first use, keep its address stable.
+func (p *I2PPublisher) Seed(ctx context.Context) error {
+	if p.target.BaseDir == "" {
+		return fmt.Errorf("seeding requires a repository directory (--basedir)")
+	}
+	keyFile := p.target.KeyFile
+	if keyFile == "" {
+		abs, err := filepath.Abs(p.target.BaseDir)
+		if err != nil {
+			return err
+		}
+		if keyFile, err = i2p.DefaultKeyFile(filepath.Base(abs)); err != nil {
+			return fmt.Errorf("%w; give the eepsite key file with --i2p-keys", err)
+		}
+	}
+	keys, err := i2p.LoadOrGenerateKeys(ctx, p.target.SAMAddress, keyFile)
+	if err != nil {
+		return err
+	}
+	session, err := i2p.NewSession(ctx, p.target.SAMAddress, keys)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	fmt.Printf("Seeding %s at http://%s/ (keys: %s)\n", p.target.BaseDir, session.Address(), keyFile)
+	fmt.Printf("APT source: deb http://%s/ ./\n", session.Address())
+	server := &http.Server{Handler: repositoryHandler(p.target.BaseDir)}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(session.Listen()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("eepsite stopped: %w", err)
+	}
+	return nil
+}
+
+// Close ends the I2P session used for uploads
+func (p *I2PPublisher) Close() error {
+	if p.session == nil {
+		return nil
+	}
+	return p.session.Close()
+}
+
+// repositoryHandler serves the files of a repository directory, hiding
+// dot files such as in-progress uploads
+func repositoryHandler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, segment := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(segment, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,195 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/signature"
+)
+
+// buildTestDeb builds a minimal package with dpkg-deb
+func buildTestDeb(t *testing.T, dir, name, version string) string {
+	if _, err := exec.LookPath("dpkg-deb"); err != nil {
+		t.Skip("dpkg-deb not available")
+	}
+	root := filepath.Join(dir, name+"-"+version)
+	if err := os.MkdirAll(filepath.Join(root, "DEBIAN"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	control := "Package: " + name + "\nVersion: " + version + "\nArchitecture: all\nMaintainer: Test <test@example.com>\nDescription: test\n long description\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "DEBIAN", "control"), []byte(control), 0644); err != nil {
+		t.Fatalf("Failed to write control: %v", err)
+	}
+	debPath := filepath.Join(dir, name+"_"+version+"_all.deb")
+	if out, err := exec.Command("dpkg-deb", "--build", "--root-owner-group", root, debPath).CombinedOutput(); err != nil {
+		t.Fatalf("dpkg-deb failed: %v: %s", err, out)
+	}
+	return debPath
+}
+
+func TestI2PRepositoryFetch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "publish-i2p-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	repoDir := filepath.Join(tmpDir, "repo")
+
+	publisher, err := NewPublisher(&Target{Type: "i2p", BaseDir: repoDir})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	for _, version := range []string{"1.0", "1.1"} {
+		if err := publisher.Publish(buildTestDeb(t, tmpDir, "myapp", version)); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	for _, name := range []string{"Packages", "Release", "pool/myapp/myapp_1.1_all.deb"} {
+		if _, err := os.Stat(filepath.Join(repoDir, name)); err != nil {
+			t.Errorf("repository is missing %s: %v", name, err)
+		}
+	}
+
+	// The seeded eepsite serves the directory with this handler
+	if err := ioutil.WriteFile(filepath.Join(repoDir, ".upload-partial"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	server := httptest.NewServer(repositoryHandler(repoDir))
+	defer server.Close()
+	if resp, err := http.Get(server.URL + "/.upload-partial"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("dot file was served: %v", err)
+	}
+
+	ctx := context.Background()
+	fetcher, err := NewFetcher(ctx, server.URL, FetchOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+	defer fetcher.Close()
+	index, err := fetcher.Index(ctx)
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	if len(index) != 2 || index[0]["Description"] != "test\n long description" {
+		t.Errorf("Index() = %v, want both versions with their extended descriptions", index)
+	}
+	outDir := filepath.Join(tmpDir, "out")
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	if _, err := fetcher.Fetch(ctx, index, "myapp", "", outDir); err == nil || !strings.Contains(err.Error(), "several") {
+		t.Errorf("Fetch() of an ambiguous name error = %v", err)
+	}
+	path, err := fetcher.Fetch(ctx, index, "myapp=1.1", "amd64", outDir)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	got, _ := ioutil.ReadFile(path)
+	want, _ := ioutil.ReadFile(filepath.Join(repoDir, "pool", "myapp", "myapp_1.1_all.deb"))
+	if filepath.Base(path) != "myapp_1.1_all.deb" || string(got) != string(want) {
+		t.Errorf("Fetch() wrote %s with different content", path)
+	}
+
+	// A package changed after indexing is rejected and not kept
+	if err := ioutil.WriteFile(filepath.Join(repoDir, "pool", "myapp", "myapp_1.0_all.deb"), []byte("tampered"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := fetcher.Fetch(ctx, index, "myapp=1.0", "", outDir); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Fetch() of a tampered package error = %v", err)
+	}
+	// A package larger than its indexed size is cut off rather than downloaded whole
+	if err := ioutil.WriteFile(filepath.Join(repoDir, "pool", "myapp", "myapp_1.0_all.deb"), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := fetcher.Fetch(ctx, index, "myapp=1.0", "", outDir); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Fetch() of an oversized package error = %v", err)
+	}
+	entries, _ := ioutil.ReadDir(outDir)
+	if len(entries) != 1 {
+		t.Errorf("output directory has %d files, want only the verified package", len(entries))
+	}
+
+	// So is an index that does not match the Release file
+	if err := ioutil.WriteFile(filepath.Join(repoDir, "Packages"), []byte("Package: evil\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := fetcher.Index(ctx); err == nil {
+		t.Error("Index() accepted a Packages file that does not match Release")
+	}
+}
+
+func TestFetchReleaseSignature(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "publish-fetch-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	repoDir := filepath.Join(tmpDir, "repo")
+
+	publisher, err := NewPublisher(&Target{Type: "i2p", BaseDir: repoDir})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	if err := publisher.Publish(buildTestDeb(t, tmpDir, "myapp", "1.0")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	server := httptest.NewServer(repositoryHandler(repoDir))
+	defer server.Close()
+	ctx := context.Background()
+
+	// Without a key the signature cannot be checked, which must be opted into
+	if _, err := NewFetcher(ctx, server.URL, FetchOptions{}); ci.ExitCode(err) != 2 {
+		t.Errorf("NewFetcher() without a key error = %v, want a usage error", err)
+	}
+
+	var verified []string
+	verifySignature = func(scheme signature.Scheme, publicKey, file string) error {
+		verified = append(verified, string(scheme)+" "+publicKey+" "+filepath.Base(file))
+		if _, err := os.Stat(scheme.Path(file)); err != nil {
+			return err
+		}
+		if publicKey != "repo.pub" {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	defer func() { verifySignature = signature.Verify }()
+
+	fetcher, err := NewFetcher(ctx, server.URL, FetchOptions{MinisignKey: "repo.pub"})
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+	defer fetcher.Close()
+	if _, err := fetcher.Index(ctx); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("Index() of an unsigned repository error = %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repoDir, "Release.minisig"), []byte("signature"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := fetcher.Index(ctx); err != nil {
+		t.Errorf("Index() of a signed repository error = %v", err)
+	}
+	if len(verified) != 1 || verified[0] != "minisign repo.pub Release" {
+		t.Errorf("Verified %v, want the Release file with the minisign key", verified)
+	}
+
+	fetcher, err = NewFetcher(ctx, server.URL, FetchOptions{MinisignKey: "other.pub"})
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+	defer fetcher.Close()
+	if _, err := fetcher.Index(ctx); err == nil || !strings.Contains(err.Error(), "does not verify") {
+		t.Errorf("Index() with the wrong key error = %v", err)
+	}
+}
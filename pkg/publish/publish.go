@@ -0,0 +1,129 @@
+package publish
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Target describes a remote repository that packages can be published to.
+// Not every field is used by every publisher type.
+type Target struct {
+	Type         string // Publisher type (aptly, reprepro, artifactory, http, webdav, s3, dput, ppa, i2p)
+	URL          string // Base URL of the remote service or bucket endpoint
+	Repo         string // Repository name (aptly local repo, Artifactory repo key, S3 bucket)
+	Distribution string // Distribution/codename the package is added to
+	Component    string // Archive component (main, contrib, ...)
+	Prefix       string // Publish prefix (aptly) or key prefix (S3)
+	Username     string
+	Password     string
+	Region       string // S3 region
+	SSHHost      string // user@host for reprepro over SSH
+	BaseDir      string // reprepro base directory on the remote host, or local repository (i2p)
+	SignKey      string // GPG key signing .changes files (dput, ppa) or Release files (i2p)
+	SAMAddress   string // SAM bridge of the I2P router (i2p)
+	KeyFile      string // Keys of the eepsite (i2p)
+	Seed         bool   // Keep serving the repository after publishing (i2p)
+	Verbose      bool
+}
+
+// Publisher uploads a package file to a remote repository.
+type Publisher interface {
+	// Name returns the publisher type
+	Name() string
+	// Publish uploads the .deb at debPath and makes it available in the repository
+	Publish(debPath string) error
+}
+
+// PublisherFactory creates a Publisher for a target.
+type PublisherFactory func(target *Target) (Publisher, error)
+
+var publishers = map[string]PublisherFactory{}
+
+// RegisterPublisher makes a publisher type available under the given name.
+// Registering an existing name replaces the previous factory.
+func RegisterPublisher(name string, factory PublisherFactory) {
+	publishers[strings.ToLower(name)] = factory
+}
+
+// PublisherTypes returns the sorted names of all registered publisher types.
+func PublisherTypes() []string {
+	names := make([]string, 0, len(publishers))
+	for name := range publishers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewPublisher creates a Publisher for the given target using the registered factories.
+func NewPublisher(target *Target) (Publisher, error) {
+	if target == nil {
+		return nil, fmt.Errorf("publish target cannot be nil")
+	}
+
+	factory, ok := publishers[strings.ToLower(target.Type)]
+	if !ok {
+		return nil, fmt.Errorf("unknown publish target type %q (available: %s)",
+			target.Type, strings.Join(PublisherTypes(), ", "))
+	}
+
+	return factory(target)
+}
+
+func init() {
+	RegisterPublisher("aptly", newAptlyPublisher)
+	RegisterPublisher("reprepro", newRepreproPublisher)
+	RegisterPublisher("artifactory", newArtifactoryPublisher)
+	RegisterPublisher("http", newHTTPPublisher)
+	RegisterPublisher("webdav", newHTTPPublisher)
+	RegisterPublisher("nexus", newHTTPPublisher)
+	RegisterPublisher("s3", newS3Publisher)
+	RegisterPublisher("dput", newDputPublisher)
+	RegisterPublisher("ppa", newPPAPublisher)
+	RegisterPublisher("i2p", newI2PPublisher)
+}
+
+// runCommand executes an external command, streaming its output.
+// It is a variable so tests can substitute it.
+var runCommand = func(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// debFields holds the name, version and architecture encoded in a .deb file name
+type debFields struct {
+	Name         string
+	Version      string
+	Architecture string
+}
+
+// parseDebFilename splits a name_version_arch.deb file name into its parts
+func parseDebFilename(debPath string) (debFields, error) {
+	base := strings.TrimSuffix(filepath.Base(debPath), ".deb")
+	parts := strings.Split(base, "_")
+	if len(parts) != 3 {
+		return debFields{}, fmt.Errorf("package file name %s does not follow name_version_arch.deb", filepath.Base(debPath))
+	}
+	return debFields{Name: parts[0], Version: parts[1], Architecture: parts[2]}, nil
+}
+
+// checkDeb verifies that debPath refers to a readable .deb file
+func checkDeb(debPath string) error {
+	if !strings.HasSuffix(debPath, ".deb") {
+		return fmt.Errorf("not a .deb file: %s", debPath)
+	}
+	info, err := os.Stat(debPath)
+	if err != nil {
+		return fmt.Errorf("package file error: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("package path is a directory: %s", debPath)
+	}
+	return nil
+}
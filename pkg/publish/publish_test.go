@@ -0,0 +1,427 @@
+package publish
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestDeb creates a dummy .deb file for upload tests
+func writeTestDeb(t *testing.T) (string, func()) {
+	tmpDir, err := ioutil.TempDir("", "publish-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	debPath := filepath.Join(tmpDir, "myapp_1.0_amd64.deb")
+	if err := ioutil.WriteFile(debPath, []byte("!<arch>\ndummy"), 0644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	return debPath, func() { os.RemoveAll(tmpDir) }
+}
+
+// recordingServer records the method and path of every request it receives
+type recordingServer struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   []string
+	headers  []http.Header
+}
+
+func (rs *recordingServer) handler(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rs.mu.Lock()
+	rs.requests = append(rs.requests, r.Method+" "+r.URL.RequestURI())
+	rs.bodies = append(rs.bodies, string(body))
+	rs.headers = append(rs.headers, r.Header.Clone())
+	rs.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestNewPublisher(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  Target
+		wantErr bool
+	}{
+		{"Unknown type", Target{Type: "ftp"}, true},
+		{"HTTP without URL", Target{Type: "http"}, true},
+		{"WebDAV", Target{Type: "webdav", URL: "http://dav"}, false},
+		{"Aptly without repo", Target{Type: "aptly", URL: "http://aptly"}, true},
+		{"Reprepro without distribution", Target{Type: "reprepro", SSHHost: "host", BaseDir: "/srv"}, true},
+		{"Reprepro option injection", Target{Type: "reprepro", SSHHost: "-oProxyCommand=x", BaseDir: "/srv", Distribution: "stable"}, true},
+		{"S3 with credentials", Target{Type: "S3", Repo: "bucket", Username: "AK", Password: "SK"}, false},
+		{"PPA", Target{Type: "ppa", Repo: "ppa:alice/tools"}, false},
+		{"PPA without name", Target{Type: "ppa", Repo: "alice"}, true},
+		{"Dput unsupported method", Target{Type: "dput", URL: "rsync://upload.example.org/queue"}, true},
+		{"Dput option injection", Target{Type: "dput", URL: "scp://-oProxyCommand=x/queue"}, true},
+		{"I2P without directory or URL", Target{Type: "i2p"}, true},
+		{"I2P clearnet URL", Target{Type: "i2p", URL: "http://repo.example.org/"}, true},
+		{"I2P eepsite", Target{Type: "i2p", URL: "http://repo.i2p/upload"}, false},
+		{"I2P repository", Target{Type: "i2p", BaseDir: "/srv/eepsite"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			_, err := NewPublisher(&target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewPublisher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPPublishers(t *testing.T) {
+	debPath, cleanup := writeTestDeb(t)
+	defer cleanup()
+
+	rs := &recordingServer{}
+	server := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer server.Close()
+
+	webdav, _ := NewPublisher(&Target{Type: "webdav", URL: server.URL + "/debs/", Username: "u", Password: "p"})
+	if err := webdav.Publish(debPath); err != nil {
+		t.Fatalf("webdav Publish() error = %v", err)
+	}
+
+	artifactory, _ := NewPublisher(&Target{Type: "artifactory", URL: server.URL, Repo: "debian-local", Distribution: "bookworm"})
+	if err := artifactory.Publish(debPath); err != nil {
+		t.Fatalf("artifactory Publish() error = %v", err)
+	}
+
+	want := []string{
+		"PUT /debs/myapp_1.0_amd64.deb",
+		"PUT /debian-local/pool/myapp/myapp_1.0_amd64.deb;deb.distribution=bookworm;deb.component=main;deb.architecture=amd64",
+	}
+	if strings.Join(rs.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected requests:\n%s\nwant:\n%s", strings.Join(rs.requests, "\n"), strings.Join(want, "\n"))
+	}
+	if rs.bodies[0] != "!<arch>\ndummy" {
+		t.Errorf("Unexpected upload body: %q", rs.bodies[0])
+	}
+	if rs.headers[0].Get("Authorization") == "" {
+		t.Errorf("Expected basic auth header on webdav upload")
+	}
+}
+
+func TestAptlyPublisher(t *testing.T) {
+	debPath, cleanup := writeTestDeb(t)
+	defer cleanup()
+
+	rs := &recordingServer{}
+	server := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer server.Close()
+
+	publisher, _ := NewPublisher(&Target{Type: "aptly", URL: server.URL, Repo: "myrepo", Distribution: "stable"})
+	if err := publisher.Publish(debPath); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if len(rs.requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %v", rs.requests)
+	}
+	if !strings.HasPrefix(rs.requests[0], "POST /api/files/pkginstall-") {
+		t.Errorf("Unexpected upload request: %s", rs.requests[0])
+	}
+	if !strings.HasPrefix(rs.requests[1], "POST /api/repos/myrepo/file/pkginstall-") {
+		t.Errorf("Unexpected add request: %s", rs.requests[1])
+	}
+	if rs.requests[2] != "PUT /api/publish/:./stable" {
+		t.Errorf("Unexpected publish request: %s", rs.requests[2])
+	}
+}
+
+func TestAptlyEscapePrefix(t *testing.T) {
+	tests := map[string]string{
+		".":          ":.",
+		"debian":     "debian",
+		"ppa/stable": "ppa_stable",
+		"my_ppa":     "my__ppa",
+	}
+	for prefix, want := range tests {
+		if got := aptlyEscapePrefix(prefix); got != want {
+			t.Errorf("aptlyEscapePrefix(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+func TestRepreproPublisher(t *testing.T) {
+	debPath, cleanup := writeTestDeb(t)
+	defer cleanup()
+
+	origRunCommand := runCommand
+	defer func() { runCommand = origRunCommand }()
+	var calls []string
+	runCommand = func(name string, args ...string) error {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return nil
+	}
+
+	publisher, _ := NewPublisher(&Target{Type: "reprepro", SSHHost: "deploy@repo", BaseDir: "/srv/apt", Distribution: "bookworm"})
+	if err := publisher.Publish(debPath); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("Expected scp and ssh calls, got %v", calls)
+	}
+	if !strings.HasPrefix(calls[0], "scp -q -- ") || !strings.HasSuffix(calls[0], "deploy@repo:/tmp/myapp_1.0_amd64.deb") {
+		t.Errorf("Unexpected scp call: %s", calls[0])
+	}
+	if !strings.Contains(calls[1], "reprepro -b '/srv/apt' includedeb 'bookworm' '/tmp/myapp_1.0_amd64.deb'") {
+		t.Errorf("Unexpected ssh call: %s", calls[1])
+	}
+}
+
+func TestS3Publisher(t *testing.T) {
+	debPath, cleanup := writeTestDeb(t)
+	defer cleanup()
+
+	rs := &recordingServer{}
+	server := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer server.Close()
+
+	publisher, err := NewPublisher(&Target{Type: "s3", URL: server.URL, Repo: "bucket", Prefix: "pool/main",
+		Username: "AKIDEXAMPLE", Password: "secret", Region: "eu-west-1"})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	publisher.(*S3Publisher).now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	if err := publisher.Publish(debPath); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if rs.requests[0] != "PUT /bucket/pool/main/myapp_1.0_amd64.deb" {
+		t.Errorf("Unexpected request: %s", rs.requests[0])
+	}
+	auth := rs.headers[0].Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/eu-west-1/s3/aws4_request") {
+		t.Errorf("Unexpected Authorization header: %s", auth)
+	}
+	if rs.headers[0].Get("X-Amz-Date") != "20240102T030405Z" {
+		t.Errorf("Unexpected X-Amz-Date header: %s", rs.headers[0].Get("X-Amz-Date"))
+	}
+}
+
+// writeTestSource creates a source package for upload tests
+func writeTestSource(t *testing.T, dir string) string {
+	tarball := []byte("dummy tarball")
+	if err := ioutil.WriteFile(filepath.Join(dir, "myapp_1.0.tar.gz"), tarball, 0644); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+	dsc := fmt.Sprintf(`Format: 3.0 (native)
+Source: myapp
+Binary: myapp
+Architecture: any
+Version: 1.0
+Maintainer: Test <test@example.com>
+Package-List:
+ myapp deb utils optional arch=any
+Files:
+ 0123456789abcdef0123456789abcdef %d myapp_1.0.tar.gz
+`, len(tarball))
+	dscPath := filepath.Join(dir, "myapp_1.0.dsc")
+	if err := ioutil.WriteFile(dscPath, []byte(dsc), 0644); err != nil {
+		t.Fatalf("Failed to write .dsc: %v", err)
+	}
+	return dscPath
+}
+
+// stubGPG replaces gpg with a fake clearsign and returns a restore function
+func stubGPG(t *testing.T, calls *[]string) func() {
+	origRunGPG := runGPG
+	runGPG = func(args ...string) error {
+		*calls = append(*calls, strings.Join(args, " "))
+		content, err := ioutil.ReadFile(args[len(args)-1])
+		if err != nil {
+			return err
+		}
+		signed := "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n" + string(content) +
+			"-----BEGIN PGP SIGNATURE-----\n\nsig\n-----END PGP SIGNATURE-----\n"
+		return ioutil.WriteFile(args[len(args)-2], []byte(signed), 0644)
+	}
+	return func() { runGPG = origRunGPG }
+}
+
+// ftpServer is a minimal passive-mode FTP server recording uploads
+type ftpServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	commands []string
+	uploads  []string
+	files    map[string]string
+}
+
+func newFTPServer(t *testing.T) *ftpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &ftpServer{listener: listener, files: make(map[string]string)}
+	go server.serve()
+	return server
+}
+
+func (s *ftpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "220 ready\r\n")
+
+	var data net.Listener
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "USER":
+			fmt.Fprintf(conn, "331 password please\r\n")
+		case "PASS":
+			fmt.Fprintf(conn, "230 logged in\r\n")
+		case "TYPE", "CWD":
+			fmt.Fprintf(conn, "200 ok\r\n")
+		case "PASV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := data.Addr().(*net.TCPAddr).Port
+			fmt.Fprintf(conn, "227 Entering Passive Mode (10,0,0,1,%d,%d)\r\n", port>>8, port&0xff)
+		case "STOR":
+			fmt.Fprintf(conn, "150 send it\r\n")
+			dataConn, err := data.Accept()
+			if err != nil {
+				return
+			}
+			content, _ := ioutil.ReadAll(dataConn)
+			dataConn.Close()
+			data.Close()
+			s.mu.Lock()
+			s.uploads = append(s.uploads, arg)
+			s.files[arg] = string(content)
+			s.mu.Unlock()
+			fmt.Fprintf(conn, "226 stored\r\n")
+		case "QUIT":
+			fmt.Fprintf(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprintf(conn, "502 not implemented\r\n")
+		}
+	}
+}
+
+func TestDputPublisherFTP(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "publish-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dscPath := writeTestSource(t, tmpDir)
+
+	var gpgCalls []string
+	defer stubGPG(t, &gpgCalls)()
+
+	server := newFTPServer(t)
+	defer server.listener.Close()
+
+	publisher, err := NewPublisher(&Target{Type: "ppa", URL: "ftp://" + server.listener.Addr().String() + "/~alice/ubuntu/tools",
+		Distribution: "jammy", SignKey: "alice@example.org"})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	if err := publisher.Publish(dscPath); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if len(gpgCalls) != 1 || !strings.Contains(gpgCalls[0], "--local-user alice@example.org --clearsign") {
+		t.Errorf("Unexpected gpg calls: %v", gpgCalls)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if strings.Join(server.commands[:3], "|") != "USER anonymous|PASS anonymous@|TYPE I" || server.commands[3] != "CWD ~alice/ubuntu/tools" {
+		t.Errorf("Unexpected FTP commands: %v", server.commands)
+	}
+	want := []string{"myapp_1.0.dsc", "myapp_1.0.tar.gz", "myapp_1.0_source.changes"}
+	if strings.Join(server.uploads, " ") != strings.Join(want, " ") {
+		t.Fatalf("Uploaded %v, want %v", server.uploads, want)
+	}
+
+	changes := server.files["myapp_1.0_source.changes"]
+	dscContent, _ := ioutil.ReadFile(dscPath)
+	dscSum := sha256.Sum256(dscContent)
+	for _, expected := range []string{
+		"-----BEGIN PGP SIGNED MESSAGE-----",
+		"Architecture: source\n",
+		"Distribution: jammy\n",
+		" myapp (1.0) jammy; urgency=medium\n",
+		fmt.Sprintf(" %s %d myapp_1.0.dsc\n", hex.EncodeToString(dscSum[:]), len(dscContent)),
+		" utils optional myapp_1.0.tar.gz\n",
+	} {
+		if !strings.Contains(changes, expected) {
+			t.Errorf("Uploaded .changes lacks %q:\n%s", expected, changes)
+		}
+	}
+}
+
+func TestDputPublisherHTTP(t *testing.T) {
+	debPath, cleanup := writeTestDeb(t)
+	defer cleanup()
+
+	origDebControl := debControl
+	defer func() { debControl = origDebControl }()
+	debControl = func(string) (string, error) {
+		return "Package: myapp\nVersion: 1:1.0\nArchitecture: amd64\nMaintainer: Test <test@example.com>\nSection: net\nDescription: My app\n Longer text.\n", nil
+	}
+	var gpgCalls []string
+	defer stubGPG(t, &gpgCalls)()
+
+	rs := &recordingServer{}
+	server := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer server.Close()
+
+	publisher, _ := NewPublisher(&Target{Type: "dput", URL: server.URL + "/upload", Distribution: "unstable"})
+	if err := publisher.Publish(debPath); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if len(rs.requests) != 2 || rs.requests[0] != "PUT /upload/myapp_1.0_amd64.deb" || rs.requests[1] != "PUT /upload/myapp_1.0_amd64.changes" {
+		t.Fatalf("Unexpected requests: %v", rs.requests)
+	}
+	for _, expected := range []string{"Version: 1:1.0\n", "Description:\n myapp - My app\n", " net optional myapp_1.0_amd64.deb\n"} {
+		if !strings.Contains(rs.bodies[1], expected) {
+			t.Errorf("Uploaded .changes lacks %q:\n%s", expected, rs.bodies[1])
+		}
+	}
+	if strings.Contains(gpgCalls[0], "--local-user") {
+		t.Errorf("Expected gpg's default key, got %s", gpgCalls[0])
+	}
+
+	// An existing .changes file is uploaded as it is
+	rs.requests = nil
+	if err := publisher.Publish(filepath.Join(filepath.Dir(debPath), "myapp_1.0_amd64.changes")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if len(rs.requests) != 2 || len(gpgCalls) != 1 {
+		t.Errorf("Unexpected requests %v or gpg calls %v", rs.requests, gpgCalls)
+	}
+}
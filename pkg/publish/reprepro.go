@@ -0,0 +1,66 @@
+package publish
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RepreproPublisher copies packages to a remote host with scp and adds them to a
+// reprepro-managed archive with `reprepro includedeb` over SSH.
+type RepreproPublisher struct {
+	target *Target
+}
+
+// newRepreproPublisher creates a publisher for reprepro over SSH
+func newRepreproPublisher(target *Target) (Publisher, error) {
+	if target.SSHHost == "" || target.BaseDir == "" {
+		return nil, fmt.Errorf("reprepro target requires an SSH host and a base directory")
+	}
+	if target.Distribution == "" {
+		return nil, fmt.Errorf("reprepro target requires a distribution")
+	}
+	if strings.HasPrefix(target.SSHHost, "-") {
+		return nil, fmt.Errorf("invalid SSH host: %s", target.SSHHost)
+	}
+	return &RepreproPublisher{target: target}, nil
+}
+
+// Name returns the publisher type
+func (p *RepreproPublisher) Name() string {
+	return "reprepro"
+}
+
+// Publish uploads the package to a temporary location and includes it in the archive
+func (p *RepreproPublisher) Publish(debPath string) error {
+	if err := checkDeb(debPath); err != nil {
+		return err
+	}
+
+	remotePath := "/tmp/" + filepath.Base(debPath)
+	if err := runCommand("scp", "-q", "--", debPath, p.target.SSHHost+":"+remotePath); err != nil {
+		return fmt.Errorf("failed to copy package to %s: %w", p.target.SSHHost, err)
+	}
+
+	args := []string{"reprepro", "-b", shellQuote(p.target.BaseDir)}
+	if p.target.Component != "" {
+		args = append(args, "-C", shellQuote(p.target.Component))
+	}
+	args = append(args, "includedeb", shellQuote(p.target.Distribution), shellQuote(remotePath))
+	remoteCmd := strings.Join(args, " ") + "; status=$?; rm -f " + shellQuote(remotePath) + "; exit $status"
+
+	if p.target.Verbose {
+		fmt.Printf("ssh %s %s\n", p.target.SSHHost, remoteCmd)
+	}
+
+	if err := runCommand("ssh", "--", p.target.SSHHost, remoteCmd); err != nil {
+		return fmt.Errorf("reprepro includedeb failed on %s: %w", p.target.SSHHost, err)
+	}
+
+	return nil
+}
+
+// shellQuote quotes a value for safe use in a remote POSIX shell command line
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,160 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// S3Publisher uploads packages to an S3-compatible object store using
+// AWS Signature Version 4. Credentials default to the standard
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+type S3Publisher struct {
+	target    *Target
+	accessKey string
+	secretKey string
+	endpoint  string
+	region    string
+	now       func() time.Time
+}
+
+// newS3Publisher creates a publisher for S3 and S3-compatible stores
+func newS3Publisher(target *Target) (Publisher, error) {
+	if target.Repo == "" {
+		return nil, fmt.Errorf("s3 target requires a bucket name")
+	}
+
+	accessKey := target.Username
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	secretKey := target.Password
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("s3 target requires credentials (--user/--password or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+
+	region := target.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := strings.TrimRight(target.URL, "/")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	return &S3Publisher{
+		target:    target,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		endpoint:  endpoint,
+		region:    region,
+		now:       time.Now,
+	}, nil
+}
+
+// Name returns the publisher type
+func (p *S3Publisher) Name() string {
+	return "s3"
+}
+
+// Publish uploads the package with a signed path-style PUT request
+func (p *S3Publisher) Publish(debPath string) error {
+	if err := checkDeb(debPath); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(debPath)
+	if err != nil {
+		return fmt.Errorf("failed to read package: %w", err)
+	}
+
+	key := path.Join(p.target.Prefix, filepath.Base(debPath))
+	dest, err := url.Parse(fmt.Sprintf("%s/%s/%s", p.endpoint, p.target.Repo, key))
+	if err != nil {
+		return fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, dest.String(), bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.debian.binary-package")
+	p.sign(req, content)
+
+	if p.target.Verbose {
+		fmt.Printf("PUT %s\n", dest.String())
+	}
+
+	return doRequest(req)
+}
+
+// sign adds AWS Signature Version 4 headers to the request
+func (p *S3Publisher) sign(req *http.Request, payload []byte) {
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.Header.Get("Content-Type"), req.URL.Host, payloadHash, amzDate)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, p.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+p.secretKey), dateStamp)
+	key = hmacSHA256(key, p.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKey, scope, signedHeaders, signature))
+}
+
+// sha256Hex returns the hex-encoded SHA256 digest of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 computes an HMAC-SHA256 of data using key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/signature"
+	"github.com/spf13/cobra"
+)
+
+// CommandOptions contains options for the repo command
+type CommandOptions struct {
+	Verbose bool
+
+	// Generate command options
+	Origin      string
+	Label       string
+	Suite       string
+	Codename    string
+	Description string
+	SignKey     string
+	MinisignKey string
+	SignifyKey  string
+}
+
+// NewRepoCommand creates a new command for managing APT repositories
+func NewRepoCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Manage APT repositories of built packages",
+		Long: `Manage APT repositories built from go-pkginstall output.
+
+This command turns a directory of .deb files into a flat APT repository
+that can be served over HTTP or used directly from the filesystem.
+
+Examples:
+  pkginstall repo generate ./dist
+  pkginstall repo generate ./dist --sign-key 0xDEADBEEF
+`,
+	}
+
+	cmd.PersistentFlags().BoolVarP(&options.Verbose, "verbose", "V", false, "Enable verbose output")
+
+	cmd.AddCommand(newGenerateCommand(options))
+
+	return cmd
+}
+
+// newGenerateCommand creates a subcommand for generating repository indexes
+func newGenerateCommand(options *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate [repo_dir]",
+		Short: "Generate Packages and Release files for a directory of .debs",
+		Long: `Generate the index files of a flat APT repository.
+
+This command scans the directory for .deb files and writes Packages,
+Packages.gz and Release. When a signing key is given, InRelease and
+Release.gpg are created with gpg as well. --minisign-key and --signify-key
+write Release.minisig and Release.sig with minisign or signify, and the
+Packages entry of every .deb with such a signature next to it names the
+signature in a Minisign or Signify field.
+
+The resulting repository can be used with a sources.list entry such as:
+  deb [trusted=yes] file:/path/to/dist ./
+
+Examples:
+  pkginstall repo generate ./dist
+  pkginstall repo generate ./dist --origin myorg --suite unstable
+  pkginstall repo generate ./dist --sign-key releases@example.org
+  pkginstall repo generate ./dist --minisign-key ~/.minisign/minisign.key
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoDir := "."
+			if len(args) > 0 {
+				repoDir = args[0]
+			}
+			return runGenerateCommand(repoDir, options)
+		},
+	}
+
+	cmd.Flags().StringVar(&options.Origin, "origin", "pkginstall", "Origin field of the Release file")
+	cmd.Flags().StringVar(&options.Label, "label", "pkginstall", "Label field of the Release file")
+	cmd.Flags().StringVar(&options.Suite, "suite", "stable", "Suite field of the Release file")
+	cmd.Flags().StringVar(&options.Codename, "codename", "stable", "Codename field of the Release file")
+	cmd.Flags().StringVar(&options.Description, "description", "Repository generated by go-pkginstall", "Description field of the Release file")
+	cmd.Flags().StringVar(&options.SignKey, "sign-key", "", "GPG key ID used to sign the Release file")
+	cmd.Flags().StringVar(&options.MinisignKey, "minisign-key", "", "minisign secret key writing Release.minisig")
+	cmd.Flags().StringVar(&options.SignifyKey, "signify-key", "", "signify secret key writing Release.sig")
+
+	return cmd
+}
+
+// runGenerateCommand handles the repository generation logic
+func runGenerateCommand(repoDir string, options *CommandOptions) error {
+	absDir, err := filepath.Abs(repoDir)
+	if err != nil {
+		return fmt.Errorf("invalid repository directory: %w", err)
+	}
+
+	generator, err := NewGenerator(absDir,
+		WithOrigin(options.Origin),
+		WithLabel(options.Label),
+		WithSuite(options.Suite),
+		WithCodename(options.Codename),
+		WithDescription(options.Description),
+		WithSignKey(options.SignKey),
+		WithSignatureKeys(signature.Keys(options.MinisignKey, options.SignifyKey)...),
+		WithRepoVerbose(options.Verbose),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create repository generator: %w", err)
+	}
+
+	result, err := generator.Generate()
+	if err != nil {
+		return fmt.Errorf("repository generation failed: %w", err)
+	}
+
+	fmt.Printf("Indexed %d packages in %s\n", len(result.Packages), absDir)
+	for _, file := range result.Files {
+		fmt.Printf("  wrote %s\n", file)
+	}
+	if !result.Signed {
+		fmt.Println("Release file is unsigned; clients must use [trusted=yes] or a signing key")
+	}
+
+	return nil
+}
@@ -0,0 +1,513 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/control"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/signature"
+)
+
+// PackageEntry describes a single .deb file indexed in the repository.
+type PackageEntry struct {
+	Name         string
+	Version      string
+	Architecture string
+	Filename     string // Path of the .deb relative to the repository root
+	Size         int64
+	MD5Sum       string
+	SHA1         string
+	SHA256       string
+	Control      string            // Raw control paragraph extracted from the .deb
+	Signatures   map[string]string // Field name to path of a minisign or signify signature next to the .deb
+}
+
+// IndexFile describes a generated index file listed in the Release file.
+type IndexFile struct {
+	Name   string
+	Size   int64
+	MD5Sum string
+	SHA1   string
+	SHA256 string
+}
+
+// Result summarizes the outcome of a repository generation run.
+type Result struct {
+	Packages []*PackageEntry
+	Files    []string // Absolute paths of all files written
+	Signed   bool
+}
+
+// GeneratorOption is a function that modifies a Generator
+type GeneratorOption func(*Generator)
+
+// WithOrigin sets the Origin field of the Release file
+func WithOrigin(origin string) GeneratorOption {
+	return func(g *Generator) {
+		g.origin = origin
+	}
+}
+
+// WithLabel sets the Label field of the Release file
+func WithLabel(label string) GeneratorOption {
+	return func(g *Generator) {
+		g.label = label
+	}
+}
+
+// WithSuite sets the Suite field of the Release file
+func WithSuite(suite string) GeneratorOption {
+	return func(g *Generator) {
+		g.suite = suite
+	}
+}
+
+// WithCodename sets the Codename field of the Release file
+func WithCodename(codename string) GeneratorOption {
+	return func(g *Generator) {
+		g.codename = codename
+	}
+}
+
+// WithDescription sets the Description field of the Release file
+func WithDescription(description string) GeneratorOption {
+	return func(g *Generator) {
+		g.description = description
+	}
+}
+
+// WithSignKey enables GPG signing of the Release file with the given key ID.
+// An empty key disables signing.
+func WithSignKey(keyID string) GeneratorOption {
+	return func(g *Generator) {
+		g.signKey = keyID
+	}
+}
+
+// WithSignatureKeys signs the Release file with minisign or signify keys as
+// well, writing Release.minisig or Release.sig
+func WithSignatureKeys(keys ...signature.Key) GeneratorOption {
+	return func(g *Generator) {
+		g.signatureKeys = keys
+	}
+}
+
+// WithRepoVerbose enables verbose logging for repository generation
+func WithRepoVerbose(verbose bool) GeneratorOption {
+	return func(g *Generator) {
+		g.verbose = verbose
+	}
+}
+
+// WithRepoLogger sets the logger for verbose messages
+func WithRepoLogger(logger *slog.Logger) GeneratorOption {
+	return func(g *Generator) {
+		g.logger = logger
+	}
+}
+
+// Generator creates the index files of a flat APT repository
+// (Packages, Packages.gz, Release and optionally InRelease/Release.gpg)
+// from a directory of .deb files.
+type Generator struct {
+	repoDir       string
+	origin        string
+	label         string
+	suite         string
+	codename      string
+	description   string
+	signKey       string
+	signatureKeys []signature.Key
+	verbose       bool
+	logger        *slog.Logger // Default: slog.Default()
+	now           func() time.Time
+}
+
+// readControl extracts the control paragraph from a .deb file.
+// It is a variable so tests can substitute it.
+var readControl = func(debPath string) (string, error) {
+	out, err := exec.Command("dpkg-deb", "--field", debPath).Output()
+	if err != nil {
+		return "", fmt.Errorf("dpkg-deb failed for %s: %w", debPath, err)
+	}
+	return string(out), nil
+}
+
+// runGPG invokes gpg with the given arguments.
+// It is a variable so tests can substitute it.
+var runGPG = func(args ...string) error {
+	cmd := exec.Command("gpg", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// NewGenerator creates a Generator for the repository rooted at repoDir.
+func NewGenerator(repoDir string, opts ...GeneratorOption) (*Generator, error) {
+	if repoDir == "" {
+		return nil, fmt.Errorf("repository directory cannot be empty")
+	}
+
+	info, err := os.Stat(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("repository directory error: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("repository path is not a directory: %s", repoDir)
+	}
+
+	g := &Generator{
+		repoDir:     repoDir,
+		origin:      "pkginstall",
+		label:       "pkginstall",
+		suite:       "stable",
+		codename:    "stable",
+		description: "Repository generated by go-pkginstall",
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g, nil
+}
+
+// log outputs messages at info level when verbose mode is enabled, and at
+// debug level otherwise
+func (g *Generator) log(format string, args ...interface{}) {
+	logging.Logf(g.logger, logging.Verbose(g.verbose), format, args...)
+}
+
+// Scan walks the repository directory and returns an entry for every .deb file found.
+// Entries are sorted by package name, version and architecture.
+func (g *Generator) Scan() ([]*PackageEntry, error) {
+	var entries []*PackageEntry
+
+	err := filepath.Walk(g.repoDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".deb") {
+			return nil
+		}
+
+		entry, err := g.scanPackage(path)
+		if err != nil {
+			return err
+		}
+		g.log("Indexed %s %s (%s)", entry.Name, entry.Version, entry.Architecture)
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan repository: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		if entries[i].Version != entries[j].Version {
+			return entries[i].Version < entries[j].Version
+		}
+		return entries[i].Architecture < entries[j].Architecture
+	})
+
+	return entries, nil
+}
+
+// scanPackage reads the control data and checksums of a single .deb file
+func (g *Generator) scanPackage(path string) (*PackageEntry, error) {
+	controlData, err := readControl(path)
+	if err != nil {
+		return nil, err
+	}
+	controlData = strings.TrimRight(controlData, "\n")
+
+	fields, err := control.ParseParagraph(controlData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control data in %s: %w", path, err)
+	}
+	if fields.Value("Package") == "" || fields.Value("Version") == "" {
+		return nil, fmt.Errorf("control data of %s is missing Package or Version", path)
+	}
+
+	sums, err := checksumFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	relPath, err := filepath.Rel(g.repoDir, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get relative path: %w", err)
+	}
+
+	signatures := make(map[string]string)
+	for _, scheme := range []signature.Scheme{signature.Minisign, signature.Signify} {
+		if _, err := os.Stat(scheme.Path(path)); err == nil {
+			signatures[signatureFields[scheme]] = "./" + filepath.ToSlash(relPath) + scheme.Extension()
+		}
+	}
+
+	return &PackageEntry{
+		Name:         fields.Value("Package"),
+		Version:      fields.Value("Version"),
+		Architecture: fields.Value("Architecture"),
+		Filename:     "./" + filepath.ToSlash(relPath),
+		Size:         sums.Size,
+		MD5Sum:       sums.MD5Sum,
+		SHA1:         sums.SHA1,
+		SHA256:       sums.SHA256,
+		Control:      controlData,
+		Signatures:   signatures,
+	}, nil
+}
+
+// signatureFields are the Packages fields naming the detached signature of
+// a .deb, so clients can find and check it before installing
+var signatureFields = map[signature.Scheme]string{
+	signature.Minisign: "Minisign",
+	signature.Signify:  "Signify",
+}
+
+// Generate scans the repository and writes Packages, Packages.gz and Release.
+// If a signing key is configured, InRelease and Release.gpg are written as well,
+// and Release.minisig or Release.sig for minisign or signify keys.
+func (g *Generator) Generate() (*Result, error) {
+	entries, err := g.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Packages: entries}
+
+	packages := []byte(BuildPackagesIndex(entries))
+	var gz bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	if _, err := zw.Write(packages); err != nil {
+		return nil, fmt.Errorf("failed to compress Packages: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress Packages: %w", err)
+	}
+
+	indexes := []struct {
+		name    string
+		content []byte
+	}{
+		{"Packages", packages},
+		{"Packages.gz", gz.Bytes()},
+	}
+
+	var indexFiles []IndexFile
+	for _, idx := range indexes {
+		path := filepath.Join(g.repoDir, idx.name)
+		if err := os.WriteFile(path, idx.content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", idx.name, err)
+		}
+		result.Files = append(result.Files, path)
+
+		file := checksums(idx.content)
+		file.Name = idx.name
+		indexFiles = append(indexFiles, file)
+	}
+
+	// Signatures of an earlier Release that are not rewritten would make
+	// clients keep trusting the old index, apt preferring InRelease
+	if err := g.removeStaleSignatures(); err != nil {
+		return nil, err
+	}
+	releasePath := filepath.Join(g.repoDir, "Release")
+	if err := os.WriteFile(releasePath, []byte(g.buildRelease(entries, indexFiles)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write Release: %w", err)
+	}
+	result.Files = append(result.Files, releasePath)
+
+	if g.signKey != "" {
+		signed, err := g.sign(releasePath)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, signed...)
+		result.Signed = true
+	}
+	for _, key := range g.signatureKeys {
+		g.log("Signing Release with %s key %s", key.Scheme, key.File)
+		sigPath, err := signature.Sign(key, releasePath)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, sigPath)
+	}
+
+	return result, nil
+}
+
+// removeStaleSignatures removes the Release signatures that Generate will
+// not write with the configured keys
+func (g *Generator) removeStaleSignatures() error {
+	written := make(map[string]bool)
+	if g.signKey != "" {
+		written["InRelease"], written["Release.gpg"] = true, true
+	}
+	for _, key := range g.signatureKeys {
+		written[key.Scheme.Path("Release")] = true
+	}
+	for _, name := range []string{"InRelease", "Release.gpg", signature.Minisign.Path("Release"), signature.Signify.Path("Release")} {
+		if written[name] {
+			continue
+		}
+		err := os.Remove(filepath.Join(g.repoDir, name))
+		if err == nil {
+			g.log("Removed stale signature %s", name)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale signature %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sign writes InRelease (clearsigned) and Release.gpg (detached) for the Release file
+func (g *Generator) sign(releasePath string) ([]string, error) {
+	inRelease := filepath.Join(g.repoDir, "InRelease")
+	detached := filepath.Join(g.repoDir, "Release.gpg")
+
+	g.log("Signing Release with key %s", g.signKey)
+	if err := runGPG("--batch", "--yes", "--local-user", g.signKey,
+		"--clearsign", "--output", inRelease, releasePath); err != nil {
+		return nil, fmt.Errorf("failed to create InRelease: %w", err)
+	}
+	if err := runGPG("--batch", "--yes", "--local-user", g.signKey,
+		"--armor", "--detach-sign", "--output", detached, releasePath); err != nil {
+		return nil, fmt.Errorf("failed to create Release.gpg: %w", err)
+	}
+
+	return []string{inRelease, detached}, nil
+}
+
+// buildRelease creates the Release file content for the given packages and index files
+func (g *Generator) buildRelease(entries []*PackageEntry, files []IndexFile) string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("Origin: %s", g.origin))
+	lines = append(lines, fmt.Sprintf("Label: %s", g.label))
+	lines = append(lines, fmt.Sprintf("Suite: %s", g.suite))
+	lines = append(lines, fmt.Sprintf("Codename: %s", g.codename))
+	lines = append(lines, fmt.Sprintf("Date: %s", g.now().UTC().Format(time.RFC1123)))
+	if archs := architectures(entries); len(archs) > 0 {
+		lines = append(lines, fmt.Sprintf("Architectures: %s", strings.Join(archs, " ")))
+	}
+	lines = append(lines, fmt.Sprintf("Description: %s", g.description))
+
+	sections := []struct {
+		name string
+		sum  func(IndexFile) string
+	}{
+		{"MD5Sum", func(f IndexFile) string { return f.MD5Sum }},
+		{"SHA1", func(f IndexFile) string { return f.SHA1 }},
+		{"SHA256", func(f IndexFile) string { return f.SHA256 }},
+	}
+	for _, section := range sections {
+		lines = append(lines, section.name+":")
+		for _, f := range files {
+			lines = append(lines, fmt.Sprintf(" %s %d %s", section.sum(f), f.Size, f.Name))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// BuildPackagesIndex renders the Packages index for the given entries
+func BuildPackagesIndex(entries []*PackageEntry) string {
+	var b strings.Builder
+
+	for i, entry := range entries {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(entry.Control)
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "Filename: %s\n", entry.Filename)
+		fmt.Fprintf(&b, "Size: %d\n", entry.Size)
+		fmt.Fprintf(&b, "MD5sum: %s\n", entry.MD5Sum)
+		fmt.Fprintf(&b, "SHA1: %s\n", entry.SHA1)
+		fmt.Fprintf(&b, "SHA256: %s\n", entry.SHA256)
+		fields := make([]string, 0, len(entry.Signatures))
+		for field := range entry.Signatures {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fmt.Fprintf(&b, "%s: %s\n", field, entry.Signatures[field])
+		}
+	}
+
+	return b.String()
+}
+
+// architectures returns the sorted set of architectures used by the entries
+func architectures(entries []*PackageEntry) []string {
+	seen := make(map[string]bool)
+	var archs []string
+	for _, entry := range entries {
+		if entry.Architecture != "" && !seen[entry.Architecture] {
+			seen[entry.Architecture] = true
+			archs = append(archs, entry.Architecture)
+		}
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// checksums computes the size and MD5, SHA1 and SHA256 digests of content
+func checksums(content []byte) IndexFile {
+	sums, _ := checksumReader(bytes.NewReader(content))
+	return sums
+}
+
+// checksumFile computes the size and checksums of the file at path without
+// reading it into memory
+func checksumFile(path string) (IndexFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return IndexFile{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+	sums, err := checksumReader(f)
+	if err != nil {
+		return IndexFile{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return sums, nil
+}
+
+// checksumReader computes the size and checksums of everything read from r
+func checksumReader(r io.Reader) (IndexFile, error) {
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), r)
+	if err != nil {
+		return IndexFile{}, err
+	}
+	return IndexFile{
+		Size:   size,
+		MD5Sum: hex.EncodeToString(md5Hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,247 @@
+package repo
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/signature"
+)
+
+func TestGenerate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "repo-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	debs := map[string]string{
+		"zeta_1.0_amd64.deb":  "Package: zeta\nVersion: 1.0\nArchitecture: amd64\nDescription: zeta\n",
+		"alpha_2.0_all.deb":   "Package: alpha\nVersion: 2.0\nArchitecture: all\nDescription: alpha\n multi-line\n",
+		"notes.txt":           "",
+		"sub/beta_1_i386.deb": "Package: beta\nVersion: 1\nArchitecture: i386\n",
+	}
+	for name := range debs {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("payload of "+name), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	origReadControl := readControl
+	defer func() { readControl = origReadControl }()
+	readControl = func(debPath string) (string, error) {
+		rel, _ := filepath.Rel(tmpDir, debPath)
+		return debs[rel], nil
+	}
+
+	g, err := NewGenerator(tmpDir, WithOrigin("test-origin"))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+	g.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	result, err := g.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if len(result.Packages) != 3 {
+		t.Fatalf("Expected 3 packages, got %d", len(result.Packages))
+	}
+	if result.Packages[0].Name != "alpha" || result.Packages[2].Name != "zeta" {
+		t.Errorf("Expected packages sorted by name, got %s..%s", result.Packages[0].Name, result.Packages[2].Name)
+	}
+	if result.Signed {
+		t.Errorf("Expected unsigned repository without a sign key")
+	}
+
+	packages, err := ioutil.ReadFile(filepath.Join(tmpDir, "Packages"))
+	if err != nil {
+		t.Fatalf("Failed to read Packages: %v", err)
+	}
+	for _, want := range []string{
+		"Package: alpha\nVersion: 2.0\nArchitecture: all\nDescription: alpha\n multi-line\nFilename: ./alpha_2.0_all.deb\n",
+		"Filename: ./sub/beta_1_i386.deb\n",
+		"\n\nPackage: zeta\n",
+	} {
+		if !strings.Contains(string(packages), want) {
+			t.Errorf("Packages index missing %q:\n%s", want, packages)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(tmpDir, "Packages.gz"))
+	if err != nil {
+		t.Fatalf("Failed to open Packages.gz: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read Packages.gz: %v", err)
+	}
+	unzipped, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Failed to decompress Packages.gz: %v", err)
+	}
+	if string(unzipped) != string(packages) {
+		t.Errorf("Packages.gz does not match Packages")
+	}
+
+	release, err := ioutil.ReadFile(filepath.Join(tmpDir, "Release"))
+	if err != nil {
+		t.Fatalf("Failed to read Release: %v", err)
+	}
+	sums := checksums(packages)
+	for _, want := range []string{
+		"Origin: test-origin\n",
+		"Date: Tue, 02 Jan 2024 03:04:05 UTC\n",
+		"Architectures: all amd64 i386\n",
+		"SHA256:\n " + sums.SHA256,
+	} {
+		if !strings.Contains(string(release), want) {
+			t.Errorf("Release missing %q:\n%s", want, release)
+		}
+	}
+}
+
+func TestGenerateSigned(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "repo-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origRunGPG := runGPG
+	defer func() { runGPG = origRunGPG }()
+	var calls [][]string
+	runGPG = func(args ...string) error {
+		calls = append(calls, args)
+		return nil
+	}
+
+	g, err := NewGenerator(tmpDir, WithSignKey("ABCDEF"))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+
+	result, err := g.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if !result.Signed {
+		t.Errorf("Expected signed repository")
+	}
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 gpg invocations, got %d", len(calls))
+	}
+	if !strings.Contains(strings.Join(calls[0], " "), "--local-user ABCDEF --clearsign") {
+		t.Errorf("Unexpected clearsign arguments: %v", calls[0])
+	}
+
+	// Signatures that are not rewritten are removed, so clients do not keep
+	// the old signed Release
+	signatures := []string{"InRelease", "Release.gpg", "Release.minisig", "Release.sig"}
+	for _, name := range signatures {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte("old"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if _, err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for i, name := range signatures {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if kept := i < 2; kept != (err == nil) {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+	unsigned, err := NewGenerator(tmpDir)
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+	if _, err := unsigned.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for _, name := range signatures {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected the stale %s to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestNewGeneratorErrors(t *testing.T) {
+	if _, err := NewGenerator(""); err == nil {
+		t.Errorf("Expected error for empty directory")
+	}
+	if _, err := NewGenerator("/nonexistent/repo/dir"); err == nil {
+		t.Errorf("Expected error for missing directory")
+	}
+}
+
+func TestGenerateMinisign(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "repo-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A fake minisign writes the message path into the -x signature file
+	binDir := filepath.Join(tmpDir, "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	script := "#!/bin/sh\nwhile [ $# -gt 0 ]; do case $1 in -m) m=$2 ;; -x) x=$2 ;; esac; shift; done\necho \"$m\" > \"$x\"\n"
+	if err := ioutil.WriteFile(filepath.Join(binDir, "minisign"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake minisign: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+
+	repoDir := filepath.Join(tmpDir, "repo")
+	if err := os.Mkdir(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"demo_1.0_all.deb", "demo_1.0_all.deb.minisig", "minisign.key"} {
+		if err := ioutil.WriteFile(filepath.Join(repoDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	origReadControl := readControl
+	defer func() { readControl = origReadControl }()
+	readControl = func(debPath string) (string, error) {
+		return "Package: demo\nVersion: 1.0\nArchitecture: all\n", nil
+	}
+
+	key := signature.Key{Scheme: signature.Minisign, File: filepath.Join(repoDir, "minisign.key")}
+	g, err := NewGenerator(repoDir, WithSignatureKeys(key))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+	result, err := g.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if result.Signed {
+		t.Errorf("A minisign signature should not mark the repository as GPG signed")
+	}
+	sig, err := ioutil.ReadFile(filepath.Join(repoDir, "Release.minisig"))
+	if err != nil || strings.TrimSpace(string(sig)) != filepath.Join(repoDir, "Release") {
+		t.Errorf("Release.minisig = %q, %v", sig, err)
+	}
+	packages, err := ioutil.ReadFile(filepath.Join(repoDir, "Packages"))
+	if err != nil {
+		t.Fatalf("Failed to read Packages: %v", err)
+	}
+	if !strings.Contains(string(packages), "Minisign: ./demo_1.0_all.deb.minisig\n") {
+		t.Errorf("Packages index does not name the package signature:\n%s", packages)
+	}
+}
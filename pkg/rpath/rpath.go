@@ -0,0 +1,392 @@
+// Package rpath rewrites the library search paths, DT_RUNPATH and DT_RPATH,
+// of ELF files whose libraries were relocated, as patchelf --set-rpath does.
+// The new search path is written over the old one in the dynamic string
+// table, so it must not be longer; entries the dynamic linker searches anyway
+// are dropped, and $ORIGIN-relative paths are tried, to make it fit.
+package rpath
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrNoSpace is returned when the rewritten search path is longer than the
+// space the old one takes in the file
+var ErrNoSpace = errors.New("rewritten search path does not fit")
+
+// Entry is a DT_RUNPATH or DT_RPATH entry of an ELF file
+type Entry struct {
+	Tag   string `json:"tag"` // RUNPATH or RPATH
+	Value string `json:"value"`
+}
+
+// Change is a rewritten search path
+type Change struct {
+	Path string `json:"path"` // Installed path of the ELF file
+	Tag  string `json:"tag"`  // RUNPATH or RPATH
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// String describes the change on one line
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s %s -> %s", c.Path, c.Tag, c.Old, c.New)
+}
+
+// defaultDir matches the directories the dynamic linker searches after the
+// search path, which the search path can do without
+var defaultDir = regexp.MustCompile(`^(/usr)?/lib(32|64)?(/[a-z0-9_]+-linux-[a-z0-9_]+)?/?$`)
+
+// dynEntry is an entry of the dynamic section
+type dynEntry struct {
+	Tag elf.DynTag
+	Val uint64
+}
+
+// dynamicTable is the parsed dynamic section of an ELF file
+type dynamicTable struct {
+	entries []dynEntry
+	strtab  *elf.Section
+	strings []byte
+	refs    map[uint64]bool // Offsets of the strings referenced from outside search path entries
+}
+
+// Read returns the search path entries of the ELF file at file. Files that
+// are not ELF or have no dynamic section have none.
+func Read(file string) ([]Entry, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	table, err := readDynamic(f)
+	if table == nil || err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, dyn := range table.entries {
+		if tag, ok := searchPathTag(dyn.Tag); ok {
+			entries = append(entries, Entry{Tag: tag, Value: cString(table.strings, dyn.Val)})
+		}
+	}
+	return entries, nil
+}
+
+// Rewrite rewrites the search path entries of the ELF file at file, which is
+// installed at installedPath, in place. relocate returns where a directory
+// of the search path was relocated to, if it was; $ORIGIN-relative entries
+// are kept. The file is left unchanged if any entry cannot be rewritten.
+func Rewrite(file, installedPath string, relocate func(dir string) (string, bool)) ([]Change, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	table, err := readDynamic(f)
+	f.Close()
+	if table == nil || err != nil {
+		return nil, err
+	}
+
+	type patch struct {
+		offset int64
+		data   []byte
+	}
+	var changes []Change
+	var patches []patch
+	for _, dyn := range table.entries {
+		tag, ok := searchPathTag(dyn.Tag)
+		if !ok {
+			continue
+		}
+		old := cString(table.strings, dyn.Val)
+		updated, err := rewriteSearchPath(old, path.Dir(installedPath), relocate)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s of %s: %w", tag, old, installedPath, err)
+		}
+		if updated == old {
+			continue
+		}
+		// A linker may let other strings share the end of this one
+		for ref := range table.refs {
+			if ref >= dyn.Val && ref < dyn.Val+uint64(len(old)) {
+				return nil, fmt.Errorf("%s %s of %s shares its bytes with other strings", tag, old, installedPath)
+			}
+		}
+		data := make([]byte, len(old))
+		copy(data, updated)
+		patches = append(patches, patch{offset: int64(table.strtab.Offset + dyn.Val), data: data})
+		changes = append(changes, Change{Path: installedPath, Tag: tag, Old: old, New: updated})
+	}
+	if len(patches) == 0 {
+		return nil, nil
+	}
+
+	out, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range patches {
+		if _, err := out.WriteAt(p.data, p.offset); err != nil {
+			out.Close()
+			return nil, err
+		}
+	}
+	return changes, out.Close()
+}
+
+// rewriteSearchPath relocates the directories of a colon-separated search
+// path, for an ELF file installed in originDir. The plain rewrite is tried
+// first; without the default directories and then with $ORIGIN-relative
+// directories, the result is shorter.
+func rewriteSearchPath(old, originDir string, relocate func(dir string) (string, bool)) (string, error) {
+	dirs := strings.Split(old, ":")
+	relocated := make([]string, len(dirs))
+	moved := make([]bool, len(dirs))
+	changed := false
+	for i, dir := range dirs {
+		relocated[i] = dir
+		// $ORIGIN-relative entries move with the file
+		if !path.IsAbs(dir) {
+			continue
+		}
+		if target, ok := relocate(path.Clean(dir)); ok {
+			relocated[i], moved[i], changed = target, true, true
+		}
+	}
+	if !changed {
+		return old, nil
+	}
+
+	withoutDefaults := func(entries []string) []string {
+		var kept []string
+		for i, dir := range entries {
+			if moved[i] || !defaultDir.MatchString(dir) {
+				kept = append(kept, dir)
+			}
+		}
+		return kept
+	}
+	originRelative := make([]string, len(relocated))
+	for i, dir := range relocated {
+		originRelative[i] = dir
+		if moved[i] {
+			originRelative[i] = originPath(originDir, dir)
+		}
+	}
+
+	candidates := [][]string{relocated, withoutDefaults(relocated), withoutDefaults(originRelative)}
+	var shortest string
+	for i, entries := range candidates {
+		candidate := strings.Join(unique(entries), ":")
+		if len(candidate) <= len(old) {
+			return candidate, nil
+		}
+		if i == 0 || len(candidate) < len(shortest) {
+			shortest = candidate
+		}
+	}
+	return "", fmt.Errorf("%w: %s needs %d bytes, the old search path has %d", ErrNoSpace, shortest, len(shortest), len(old))
+}
+
+// originPath returns dir relative to originDir, as a $ORIGIN path
+func originPath(originDir, dir string) string {
+	from := strings.Split(strings.Trim(originDir, "/"), "/")
+	to := strings.Split(strings.Trim(dir, "/"), "/")
+	common := 0
+	for common < len(from) && common < len(to) && from[common] == to[common] && from[common] != "" {
+		common++
+	}
+	// path.Join would clean away the .. elements
+	parts := []string{"$ORIGIN"}
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to[common:]...)
+	return strings.Join(parts, "/")
+}
+
+// unique removes repeated entries, keeping the first
+func unique(entries []string) []string {
+	seen := make(map[string]bool)
+	var kept []string
+	for _, entry := range entries {
+		if !seen[entry] {
+			seen[entry] = true
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// searchPathTag returns the name of a search path tag
+func searchPathTag(tag elf.DynTag) (string, bool) {
+	switch tag {
+	case elf.DT_RUNPATH:
+		return "RUNPATH", true
+	case elf.DT_RPATH:
+		return "RPATH", true
+	}
+	return "", false
+}
+
+// cString returns the NUL-terminated string at offset of strtab
+func cString(strtab []byte, offset uint64) string {
+	if offset >= uint64(len(strtab)) {
+		return ""
+	}
+	s := strtab[offset:]
+	if end := strings.IndexByte(string(s), 0); end >= 0 {
+		s = s[:end]
+	}
+	return string(s)
+}
+
+// readDynamic parses the dynamic section of f and collects the offsets of
+// the strings of its string table that are referenced from anywhere but a
+// search path entry. It returns nil for files without a dynamic section.
+func readDynamic(f *elf.File) (*dynamicTable, error) {
+	dynamic := f.SectionByType(elf.SHT_DYNAMIC)
+	if dynamic == nil {
+		return nil, nil
+	}
+	if int(dynamic.Link) >= len(f.Sections) {
+		return nil, fmt.Errorf("dynamic section links to missing section %d", dynamic.Link)
+	}
+	table := &dynamicTable{strtab: f.Sections[dynamic.Link], refs: make(map[uint64]bool)}
+	var err error
+	if table.strings, err = table.strtab.Data(); err != nil {
+		return nil, err
+	}
+	data, err := dynamic.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	order := f.ByteOrder
+	size := 16
+	if f.Class == elf.ELFCLASS32 {
+		size = 8
+	}
+	for off := 0; off+size <= len(data); off += size {
+		var dyn dynEntry
+		if size == 16 {
+			dyn = dynEntry{Tag: elf.DynTag(order.Uint64(data[off:])), Val: order.Uint64(data[off+8:])}
+		} else {
+			dyn = dynEntry{Tag: elf.DynTag(int32(order.Uint32(data[off:]))), Val: uint64(order.Uint32(data[off+4:]))}
+		}
+		if dyn.Tag == elf.DT_NULL {
+			break
+		}
+		table.entries = append(table.entries, dyn)
+		switch dyn.Tag {
+		case elf.DT_NEEDED, elf.DT_SONAME, elf.DT_AUXILIARY, elf.DT_FILTER, elf.DT_CONFIG, elf.DT_DEPAUDIT, elf.DT_AUDIT:
+			table.refs[dyn.Val] = true
+		}
+	}
+
+	for i, section := range f.Sections {
+		if int(section.Link) != int(dynamic.Link) || uint32(i) == dynamic.Link {
+			continue
+		}
+		var err error
+		switch section.Type {
+		case elf.SHT_DYNSYM:
+			err = table.symbolRefs(section, f.Class, order)
+		case elf.SHT_GNU_VERNEED:
+			err = table.versionRefs(section, order, true)
+		case elf.SHT_GNU_VERDEF:
+			err = table.versionRefs(section, order, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// symbolRefs records the names of the symbols of a dynamic symbol table
+func (t *dynamicTable) symbolRefs(section *elf.Section, class elf.Class, order binary.ByteOrder) error {
+	data, err := section.Data()
+	if err != nil {
+		return err
+	}
+	size := 24
+	if class == elf.ELFCLASS32 {
+		size = 16
+	}
+	// st_name is the first field of both symbol layouts
+	for off := 0; off+size <= len(data); off += size {
+		if name := order.Uint32(data[off:]); name != 0 {
+			t.refs[uint64(name)] = true
+		}
+	}
+	return nil
+}
+
+// versionRefs records the file and version names of a version needs
+// (SHT_GNU_VERNEED) or version definitions (SHT_GNU_VERDEF) section, whose
+// layouts are the same for both classes
+func (t *dynamicTable) versionRefs(section *elf.Section, order binary.ByteOrder, needs bool) error {
+	data, err := section.Data()
+	if err != nil {
+		return err
+	}
+	malformed := fmt.Errorf("malformed %s section", section.Name)
+	for off := 0; ; {
+		var count, aux, next int
+		if needs {
+			// Elf_Verneed: version, count, file, aux, next
+			if off+16 > len(data) {
+				return malformed
+			}
+			count = int(order.Uint16(data[off+2:]))
+			t.refs[uint64(order.Uint32(data[off+4:]))] = true
+			aux = off + int(order.Uint32(data[off+8:]))
+			next = int(order.Uint32(data[off+12:]))
+		} else {
+			// Elf_Verdef: version, flags, index, count, hash, aux, next
+			if off+20 > len(data) {
+				return malformed
+			}
+			count = int(order.Uint16(data[off+6:]))
+			aux = off + int(order.Uint32(data[off+12:]))
+			next = int(order.Uint32(data[off+16:]))
+		}
+		for i := 0; i < count; i++ {
+			if needs {
+				// Elf_Vernaux: hash, flags, other, name, next
+				if aux+16 > len(data) {
+					return malformed
+				}
+				t.refs[uint64(order.Uint32(data[aux+8:]))] = true
+				aux += int(order.Uint32(data[aux+12:]))
+			} else {
+				// Elf_Verdaux: name, next
+				if aux+8 > len(data) {
+					return malformed
+				}
+				t.refs[uint64(order.Uint32(data[aux:]))] = true
+				aux += int(order.Uint32(data[aux+4:]))
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		off += next
+	}
+}
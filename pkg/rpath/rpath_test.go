@@ -0,0 +1,102 @@
+package rpath
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// relocateUsrLib relocates the directories below /usr/lib/app to /opt
+func relocateUsrLib(dir string) (string, bool) {
+	if dir == "/usr/lib/app" || strings.HasPrefix(dir, "/usr/lib/app/") {
+		return "/opt" + dir, true
+	}
+	return "", false
+}
+
+func TestRewriteSearchPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		origin  string
+		want    string
+		wantErr error
+	}{
+		{"Unrelated", "/usr/lib/other", "/opt/usr/bin", "/usr/lib/other", nil},
+		{"Origin", "$ORIGIN/../lib", "/opt/usr/bin", "$ORIGIN/../lib", nil},
+		{"Duplicates merged", "/usr/lib/app:/usr/lib/app/", "/opt/usr/bin", "/opt/usr/lib/app", nil},
+		{"Defaults dropped", "/usr/lib/app:/usr/lib", "/opt/usr/bin", "/opt/usr/lib/app", nil},
+		{"Other entries kept", "/usr/lib/app:/usr/lib:/srv/lib", "/opt/usr/bin", "/opt/usr/lib/app:/srv/lib", nil},
+		{"Origin relative", "/usr/lib/app/plugins", "/opt/usr/lib/app", "$ORIGIN/plugins", nil},
+		{"No space", "/usr/lib/app", "/opt/usr/bin", "", ErrNoSpace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteSearchPath(tt.old, tt.origin, relocateUsrLib)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("rewriteSearchPath(%s) = %q, %v, want %q, %v", tt.old, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOriginPath(t *testing.T) {
+	for _, tt := range []struct{ from, to, want string }{
+		{"/opt/usr/bin", "/opt/usr/lib/app", "$ORIGIN/../lib/app"},
+		{"/opt/usr/lib/app", "/opt/usr/lib/app", "$ORIGIN"},
+		{"/opt/usr/lib", "/opt/usr/lib/app/plugins", "$ORIGIN/app/plugins"},
+	} {
+		if got := originPath(tt.from, tt.to); got != tt.want {
+			t.Errorf("originPath(%s, %s) = %s, want %s", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not installed")
+	}
+	dir, err := ioutil.TempDir("", "rpath-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "main.c")
+	if err := ioutil.WriteFile(src, []byte("int main(void) { return 0; }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	bin := filepath.Join(dir, "main")
+	args := []string{"-o", bin, src, "-Wl,--enable-new-dtags,-rpath,/usr/lib/app/private:/usr/lib/x86_64-linux-gnu"}
+	if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+		t.Skipf("cc cannot build with a RUNPATH: %v\n%s", err, out)
+	}
+
+	entries, err := Read(bin)
+	if err != nil || len(entries) != 1 || entries[0].Tag != "RUNPATH" {
+		t.Fatalf("Read() = %v, %v, want one RUNPATH", entries, err)
+	}
+	changes, err := Rewrite(bin, "/opt/usr/bin/main", relocateUsrLib)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("Rewrite() = %v, %v", changes, err)
+	}
+	if changes[0].New != "/opt/usr/lib/app/private" {
+		t.Errorf("Unexpected change %s", changes[0])
+	}
+	entries, err = Read(bin)
+	if err != nil || len(entries) != 1 || entries[0].Value != "/opt/usr/lib/app/private" {
+		t.Errorf("Read() after Rewrite() = %v, %v", entries, err)
+	}
+	if out, err := exec.Command(bin).CombinedOutput(); err != nil {
+		t.Errorf("Rewritten executable does not run: %v\n%s", err, out)
+	}
+
+	// Files that are not ELF are left alone
+	if changes, err := Rewrite(src, "/opt/usr/src/main.c", relocateUsrLib); err != nil || changes != nil {
+		t.Errorf("Rewrite() of a C source = %v, %v", changes, err)
+	}
+}
@@ -0,0 +1,342 @@
+// Package sandbox runs maintainer scripts against a throwaway fake root
+// filesystem, isolated with bubblewrap or a chroot in new namespaces, and
+// records every file write and program execution they attempt.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// Sandbox backends
+const (
+	BackendBwrap  = "bwrap"
+	BackendChroot = "chroot"
+)
+
+// Backends lists the supported backends in order of preference
+var Backends = []string{BackendBwrap, BackendChroot}
+
+// DefaultTimeout bounds the run time of a script
+const DefaultTimeout = time.Minute
+
+// controlDir holds the script and trace files inside the fake root; it is
+// not part of the recorded changes
+const controlDir = "/.pkginstall"
+
+// setupFailed is the exit status of the chroot wrapper when the sandbox
+// cannot be set up
+const setupFailed = 125
+
+// hostDirs are mounted read-only from the host, or recreated as symlinks
+// where the host has merged them into /usr
+var hostDirs = []string{"usr", "bin", "sbin", "lib", "lib32", "lib64", "libx32"}
+
+// seedFiles are copied from the host's /etc so that user lookups and the
+// dynamic linker work in the fake root
+var seedFiles = []string{"passwd", "group", "nsswitch.conf", "hosts", "ld.so.cache", "ld.so.conf"}
+
+// defaultPath is the PATH of the script
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Options configures RunScript
+type Options struct {
+	Backend string        // Sandbox backend; default: bwrap if installed, else chroot when running as root
+	Args    []string      // Script arguments, such as "configure" for a postinst
+	Package string        // Value of DPKG_MAINTSCRIPT_PACKAGE
+	Root    string        // Directory copied into the fake root first, such as an unpacked payload
+	Env     []string      // Extra KEY=VALUE variables for the script
+	Timeout time.Duration // Maximum run time (default: DefaultTimeout)
+	Output  io.Writer     // Receives the output of the script (default: os.Stderr)
+}
+
+// Result is what a script did in the sandbox
+type Result struct {
+	Backend  string                   `json:"backend"`
+	Tracer   string                   `json:"tracer"` // "strace", or "snapshot" when only changes to the fake root were recorded
+	ExitCode int                      `json:"exit_code"`
+	Actions  []security.RuntimeAction `json:"actions"`
+}
+
+// LookupBackend returns the backend to use for name, which may be empty to
+// pick the first available one
+func LookupBackend(name string) (string, error) {
+	switch name {
+	case BackendBwrap:
+		if _, err := exec.LookPath("bwrap"); err != nil {
+			return "", ci.Errorf(ci.ClassEnvironment, "bubblewrap (bwrap) not found: %w", err)
+		}
+		return name, nil
+	case BackendChroot:
+		if os.Geteuid() != 0 {
+			return "", ci.Errorf(ci.ClassEnvironment, "the chroot backend must run as root")
+		}
+		if _, err := exec.LookPath("unshare"); err != nil {
+			return "", ci.Errorf(ci.ClassEnvironment, "the chroot backend needs unshare: %w", err)
+		}
+		return name, nil
+	case "":
+		for _, backend := range Backends {
+			if _, err := LookupBackend(backend); err == nil {
+				return backend, nil
+			}
+		}
+		return "", ci.Errorf(ci.ClassEnvironment, "no sandbox available: install bubblewrap (bwrap), or run as root to use the chroot backend")
+	default:
+		return "", fmt.Errorf("unknown sandbox backend: %s (available: %s)", name, strings.Join(Backends, ", "))
+	}
+}
+
+// RunScript runs the script at scriptPath in a fake root filesystem and
+// returns its exit status and the actions it attempted. The host's /usr and
+// library directories are mounted read-only, so writes to them fail but are
+// still recorded. The script has no network access.
+func RunScript(ctx context.Context, scriptPath string, opts Options) (*Result, error) {
+	backend, err := LookupBackend(opts.Backend)
+	if err != nil {
+		return nil, err
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	output := opts.Output
+	if output == nil {
+		output = os.Stderr
+	}
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+
+	root, err := os.MkdirTemp("", "pkginstall-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake root: %w", err)
+	}
+	defer os.RemoveAll(root)
+	name := filepath.Base(scriptPath)
+	if err := setupRoot(root, opts.Root, name, script); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Backend: backend, Tracer: "snapshot"}
+	command := []string{controlDir + "/" + name}
+	command = append(command, opts.Args...)
+	if strace, err := exec.LookPath("strace"); err == nil && onHostDirs(strace) {
+		result.Tracer = "strace"
+		command = append([]string{strace, "-ff", "-qq", "-y", "-ttt", "-s", "4096", "-e", "signal=none",
+			"-e", "trace=%file", "-o", controlDir + "/trace/t"}, command...)
+	}
+	env := []string{"/usr/bin/env", "-i", "PATH=" + defaultPath, "HOME=/root", "LANG=C",
+		"DPKG_MAINTSCRIPT_NAME=" + name, "DPKG_MAINTSCRIPT_PACKAGE=" + opts.Package, "DPKG_ROOT="}
+	command = append(append(env, opts.Env...), command...)
+
+	var before snapshot
+	if result.Tracer == "snapshot" {
+		if before, err = takeSnapshot(root); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if backend == BackendBwrap {
+		cmd = exec.CommandContext(ctx, "bwrap", append(bwrapArgs(root), command...)...)
+	} else {
+		args := []string{"--mount", "--net", "--pid", "--ipc", "--uts", "--fork", "/bin/sh", "-c", chrootWrapper, "sh", root}
+		cmd = exec.CommandContext(ctx, "unshare", append(args, command...)...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = output
+	cmd.Stderr = io.MultiWriter(output, &stderr)
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("script did not finish within %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		if backend == BackendChroot && result.ExitCode == setupFailed {
+			return nil, fmt.Errorf("failed to set up the sandbox: %s", strings.TrimSpace(stderr.String()))
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to run sandbox: %w", err)
+	}
+
+	if result.Tracer == "strace" {
+		result.Actions, err = readTraces(filepath.Join(root, controlDir, "trace"))
+	} else {
+		var after snapshot
+		if after, err = takeSnapshot(root); err == nil {
+			result.Actions = before.diff(after)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// setupRoot creates the fake root: mount points for the host directories,
+// writable system directories, seed files from the host, the contents of
+// seed and the script itself
+func setupRoot(root, seed, name string, script []byte) error {
+	for _, dir := range hostDirs {
+		info, err := os.Lstat("/" + dir)
+		switch {
+		case err != nil:
+			continue
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink("/" + dir)
+			if err == nil {
+				err = os.Symlink(target, filepath.Join(root, dir))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create fake root: %w", err)
+			}
+		case info.IsDir():
+			if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+				return fmt.Errorf("failed to create fake root: %w", err)
+			}
+		}
+	}
+	for _, dir := range []string{"etc", "var/lib", "var/log", "var/cache", "opt", "srv", "home", "root", "run", "proc", "dev", "tmp", "var/tmp", controlDir + "/trace"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return fmt.Errorf("failed to create fake root: %w", err)
+		}
+	}
+	for _, dir := range []string{"tmp", "var/tmp"} {
+		if err := os.Chmod(filepath.Join(root, dir), 01777); err != nil {
+			return fmt.Errorf("failed to create fake root: %w", err)
+		}
+	}
+	if err := os.Chmod(root, 0755); err != nil {
+		return fmt.Errorf("failed to create fake root: %w", err)
+	}
+	for _, file := range seedFiles {
+		content, err := os.ReadFile(filepath.Join("/etc", file))
+		if err != nil {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(root, "etc", file), content, 0644); err != nil {
+			return fmt.Errorf("failed to create fake root: %w", err)
+		}
+	}
+	if seed != "" {
+		if err := copyTree(seed, root); err != nil {
+			return fmt.Errorf("failed to copy %s into the fake root: %w", seed, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, controlDir, name), script, 0755); err != nil {
+		return fmt.Errorf("failed to create fake root: %w", err)
+	}
+	return nil
+}
+
+// copyTree copies the files, directories and symlinks below src into dst.
+// The host directories are skipped, as they are mounted over.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if isHostDir("/" + filepath.ToSlash(rel)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, content, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// isHostDir reports whether name is, or is below, a directory mounted from
+// the host or the control directory
+func isHostDir(name string) bool {
+	for _, dir := range append(append([]string(nil), hostDirs...), "proc", "dev", strings.TrimPrefix(controlDir, "/")) {
+		if name == "/"+dir || strings.HasPrefix(name, "/"+dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// onHostDirs reports whether the host path name is visible in the sandbox
+func onHostDirs(name string) bool {
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return false
+	}
+	return isHostDir(resolved) && !strings.HasPrefix(resolved, "/proc/") && !strings.HasPrefix(resolved, "/dev/")
+}
+
+// bwrapArgs returns the bubblewrap arguments mounting the fake root
+func bwrapArgs(root string) []string {
+	args := []string{"--unshare-all", "--die-with-parent", "--new-session", "--bind", root, "/"}
+	for _, dir := range hostDirs {
+		info, err := os.Lstat("/" + dir)
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			continue // Symlinks were recreated in the fake root
+		}
+		args = append(args, "--ro-bind", "/"+dir, "/"+dir)
+	}
+	return append(args, "--proc", "/proc", "--dev", "/dev", "--chdir", "/", "--")
+}
+
+// chrootWrapper sets up the mounts of the chroot backend in new mount, PID
+// and network namespaces, then runs the command in the fake root. Its
+// arguments are the fake root and the command.
+var chrootWrapper = `root=$1; shift
+fail() { echo "sandbox: $*" >&2; exit ` + fmt.Sprint(setupFailed) + `; }
+for dir in ` + strings.Join(hostDirs, " ") + `; do
+	if [ -d "/$dir" ] && [ ! -L "/$dir" ]; then
+		mount --bind "/$dir" "$root/$dir" || fail "cannot mount /$dir"
+		mount -o remount,bind,ro "$root/$dir" || fail "cannot remount /$dir read-only"
+	fi
+done
+mount -t proc proc "$root/proc" || fail "cannot mount /proc"
+for dev in null zero full random urandom tty; do
+	touch "$root/dev/$dev" && mount --bind "/dev/$dev" "$root/dev/$dev" || fail "cannot mount /dev/$dev"
+done
+ln -s /proc/self/fd "$root/dev/fd"
+cd "$root" || fail "cannot enter the fake root"
+exec chroot "$root" "$@"
+`
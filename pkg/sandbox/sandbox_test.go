@@ -0,0 +1,219 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+func TestParseTraceLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want *security.RuntimeAction
+	}{
+		{
+			name: "Exec",
+			line: `1700000000.000100 execve("/usr/bin/useradd", ["useradd", "-r", "my \"app\""], 0x7ffc /* 5 vars */) = 0`,
+			want: &security.RuntimeAction{Op: security.RuntimeExec, Path: "/usr/bin/useradd", Args: []string{"useradd", "-r", `my "app"`}},
+		},
+		{
+			name: "Write relative to a directory descriptor",
+			line: `1700000000.000200 openat(3</etc/myapp>, "app.conf", O_WRONLY|O_CREAT|O_TRUNC, 0666) = 4</etc/myapp/app.conf>`,
+			want: &security.RuntimeAction{Op: security.RuntimeWrite, Path: "/etc/myapp/app.conf"},
+		},
+		{
+			name: "Failed write",
+			line: `1700000000.000300 openat(AT_FDCWD</>, "/usr/bin/evil", O_WRONLY|O_CREAT|O_NOCTTY|O_NONBLOCK, 0666) = -1 EROFS (Read-only file system)`,
+			want: &security.RuntimeAction{Op: security.RuntimeWrite, Path: "/usr/bin/evil", Error: "EROFS"},
+		},
+		{
+			name: "Read is ignored",
+			line: `1700000000.000400 openat(AT_FDCWD, "/etc/passwd", O_RDONLY|O_CLOEXEC) = 3</etc/passwd>`,
+		},
+		{
+			name: "Null device is ignored",
+			line: `1700000000.000500 openat(AT_FDCWD, "/dev/null", O_WRONLY|O_CREAT|O_TRUNC, 0666) = 3</dev/null>`,
+		},
+		{
+			name: "Rename",
+			line: `1700000000.000600 renameat2(AT_FDCWD, "/etc/passwd+", AT_FDCWD, "/etc/passwd", 0) = 0`,
+			want: &security.RuntimeAction{Op: security.RuntimeRename, Path: "/etc/passwd+", Target: "/etc/passwd"},
+		},
+		{
+			name: "Delete",
+			line: `1700000000.000700 unlinkat(AT_FDCWD, "/etc/hosts", 0) = 0`,
+			want: &security.RuntimeAction{Op: security.RuntimeDelete, Path: "/etc/hosts"},
+		},
+		{
+			name: "Symlink",
+			line: `1700000000.000800 symlinkat("/opt/app/bin/app", AT_FDCWD, "/usr/local/bin/app") = 0`,
+			want: &security.RuntimeAction{Op: security.RuntimeLink, Path: "/usr/local/bin/app", Target: "/opt/app/bin/app"},
+		},
+		{
+			name: "Stat is ignored",
+			line: `1700000000.000900 newfstatat(AT_FDCWD, "/etc", {st_mode=S_IFDIR|0755, st_size=4096, ...}, 0) = 0`,
+		},
+		{
+			name: "Unfinished call is ignored",
+			line: `1700000000.001000 execve("/bin/sh", ["sh"], 0x7ffc /* 5 vars */ <unfinished ...>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, ok := parseTraceLine(tt.line)
+			if tt.want == nil {
+				if ok {
+					t.Errorf("parseTraceLine() = %+v, want nothing", got)
+				}
+				return
+			}
+			if !ok || !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("parseTraceLine() = %+v, %v, want %+v", got, ok, *tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTraces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandbox-trace-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	traces := map[string]string{
+		"t.100": `1700000000.000001 execve("/.pkginstall/postinst", ["/.pkginstall/postinst", "configure"], 0x1 /* 3 vars */) = 0
+1700000000.000004 mkdir("/var/lib/app", 0755) = 0
+`,
+		"t.101": `1700000000.000002 execve("/usr/local/sbin/systemctl", ["systemctl", "enable", "app"], 0x1 /* 3 vars */) = -1 ENOENT (No such file or directory)
+1700000000.000003 execve("/usr/bin/systemctl", ["systemctl", "enable", "app"], 0x1 /* 3 vars */) = 0
+`,
+	}
+	for name, content := range traces {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write trace: %v", err)
+		}
+	}
+
+	actions, err := readTraces(dir)
+	if err != nil {
+		t.Fatalf("readTraces() error = %v", err)
+	}
+	var got []string
+	for _, action := range actions {
+		got = append(got, action.String())
+	}
+	// The script itself and the failed PATH lookup are not reported
+	want := []string{
+		"exec /usr/bin/systemctl: systemctl enable app",
+		"mkdir /var/lib/app",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readTraces() = %q, want %q", got, want)
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	root, err := ioutil.TempDir("", "sandbox-root-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"etc", "usr/bin", ".pkginstall"} {
+		os.MkdirAll(filepath.Join(root, dir), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(root, "etc/hosts"), []byte("127.0.0.1 localhost\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "etc/group"), []byte("root:x:0:\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "etc/motd"), []byte("hi\n"), 0644)
+
+	before, err := takeSnapshot(root)
+	if err != nil {
+		t.Fatalf("takeSnapshot() error = %v", err)
+	}
+	os.Remove(filepath.Join(root, "etc/hosts"))
+	ioutil.WriteFile(filepath.Join(root, "etc/group"), []byte("root:x:0:\napp:x:999:\n"), 0644)
+	os.Chmod(filepath.Join(root, "etc/motd"), 0600)
+	os.MkdirAll(filepath.Join(root, "opt/app"), 0755)
+	os.Symlink("/opt/app", filepath.Join(root, "etc/app"))
+	ioutil.WriteFile(filepath.Join(root, "usr/bin/ignored"), []byte("x"), 0755)
+	ioutil.WriteFile(filepath.Join(root, ".pkginstall/ignored"), []byte("x"), 0644)
+	after, err := takeSnapshot(root)
+	if err != nil {
+		t.Fatalf("takeSnapshot() error = %v", err)
+	}
+
+	var got []string
+	for _, action := range before.diff(after) {
+		got = append(got, action.String())
+	}
+	want := []string{
+		"link /etc/app -> /opt/app",
+		"write /etc/group",
+		"delete /etc/hosts",
+		"chmod /etc/motd",
+		"mkdir /opt",
+		"mkdir /opt/app",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff() = %q, want %q", got, want)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	if _, err := LookupBackend(""); err != nil {
+		t.Skipf("No sandbox available: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "sandbox-script-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "postinst")
+	content := `#!/bin/sh
+echo "$DPKG_MAINTSCRIPT_PACKAGE $1"
+mkdir -p /var/lib/app
+echo state > /var/lib/app/state
+touch /usr/bin/app-evil 2>/dev/null || echo "usr is read-only"
+test -f /opt/app/seeded || exit 3
+exit 2
+`
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	seed := filepath.Join(dir, "payload")
+	os.MkdirAll(filepath.Join(seed, "opt/app"), 0755)
+	ioutil.WriteFile(filepath.Join(seed, "opt/app/seeded"), []byte("x"), 0644)
+
+	var output bytes.Buffer
+	result, err := RunScript(context.Background(), script, Options{Args: []string{"configure"}, Package: "app", Root: seed, Output: &output})
+	if err != nil {
+		t.Fatalf("RunScript() error = %v", err)
+	}
+	if result.ExitCode != 2 {
+		t.Errorf("ExitCode = %d, want 2; output:\n%s", result.ExitCode, output.String())
+	}
+	if !strings.Contains(output.String(), "app configure") || !strings.Contains(output.String(), "usr is read-only") {
+		t.Errorf("Unexpected output:\n%s", output.String())
+	}
+	if _, err := os.Stat("/usr/bin/app-evil"); err == nil {
+		t.Errorf("Script wrote to the host's /usr")
+	}
+	var writes []string
+	for _, action := range result.Actions {
+		if action.Op == security.RuntimeWrite && action.Error == "" {
+			writes = append(writes, action.Path)
+		}
+	}
+	if !reflect.DeepEqual(writes, []string{"/var/lib/app/state"}) {
+		t.Errorf("Writes = %q, want the state file", writes)
+	}
+}
@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// entry is the state of one path of the fake root
+type entry struct {
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	link    string
+}
+
+// snapshot maps the paths of the fake root, such as /etc/passwd, to their
+// state; the host directories and the control directory are left out
+type snapshot map[string]entry
+
+// takeSnapshot records the state of the fake root
+func takeSnapshot(root string) (snapshot, error) {
+	snap := make(snapshot)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		if isHostDir(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		e := entry{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+		if info.IsDir() {
+			e.size, e.modTime = 0, time.Time{} // Only changes of the contents matter
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			e.link, _ = os.Readlink(path)
+		}
+		snap[name] = e
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record the fake root: %w", err)
+	}
+	return snap, nil
+}
+
+// diff returns the changes from s to after as actions, sorted by path
+func (s snapshot) diff(after snapshot) []security.RuntimeAction {
+	actions := []security.RuntimeAction{}
+	for name, e := range after {
+		old, existed := s[name]
+		switch {
+		case !existed && e.mode.IsDir():
+			actions = append(actions, security.RuntimeAction{Op: security.RuntimeMkdir, Path: name})
+		case !existed && e.link != "":
+			actions = append(actions, security.RuntimeAction{Op: security.RuntimeLink, Path: name, Target: e.link})
+		case !existed || old.size != e.size || !old.modTime.Equal(e.modTime) || old.link != e.link ||
+			old.mode.Type() != e.mode.Type():
+			actions = append(actions, security.RuntimeAction{Op: security.RuntimeWrite, Path: name})
+		case old.mode != e.mode:
+			actions = append(actions, security.RuntimeAction{Op: security.RuntimeChmod, Path: name})
+		}
+	}
+	for name := range s {
+		if _, ok := after[name]; !ok {
+			actions = append(actions, security.RuntimeAction{Op: security.RuntimeDelete, Path: name})
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Path < actions[j].Path })
+	return actions
+}
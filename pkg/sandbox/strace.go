@@ -0,0 +1,243 @@
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// traceLine matches a completed system call in strace -ttt output, such as
+// `1700000000.000001 openat(AT_FDCWD, "/etc/x", O_WRONLY|O_CREAT, 0644) = 3`
+var traceLine = regexp.MustCompile(`^(\d+\.\d+) (\w+)\((.*)\) += (-?\d+|\?)(?: (E[A-Z0-9]+))?`)
+
+// ignoredPaths are written by nearly every script and never reported
+var ignoredPaths = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty", "/dev/stdout", "/dev/stderr", "/dev/fd", "/proc/self", controlDir}
+
+// timedAction is an action with the time of its system call
+type timedAction struct {
+	time   float64
+	action security.RuntimeAction
+}
+
+// readTraces parses the per-process strace output files in dir into the
+// actions of all processes, ordered by time
+func readTraces(dir string) ([]security.RuntimeAction, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "t.*"))
+	if err != nil {
+		return nil, err
+	}
+	var timed []timedAction
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trace: %w", err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if t, action, ok := parseTraceLine(scanner.Text()); ok {
+				timed = append(timed, timedAction{t, action})
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trace: %w", err)
+		}
+	}
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].time < timed[j].time })
+
+	actions := []security.RuntimeAction{}
+	for _, t := range timed {
+		// A PATH search tries one directory after another; keep only the
+		// last attempt of a command that was not found
+		if n := len(actions); n > 0 && t.action.Op == security.RuntimeExec && notFound(actions[n-1]) &&
+			strings.Join(actions[n-1].Args, "\x00") == strings.Join(t.action.Args, "\x00") {
+			actions[n-1] = t.action
+			continue
+		}
+		actions = append(actions, t.action)
+	}
+	return actions, nil
+}
+
+// parseTraceLine converts a system call that executes a program or changes
+// the file system into an action
+func parseTraceLine(line string) (float64, security.RuntimeAction, bool) {
+	var action security.RuntimeAction
+	m := traceLine.FindStringSubmatch(line)
+	if m == nil {
+		return 0, action, false
+	}
+	t, _ := strconv.ParseFloat(m[1], 64)
+	syscall, args := m[2], splitArgs(m[3])
+	action.Error = m[5]
+
+	// pathAt resolves the path argument i, relative to the directory
+	// argument i-1 of *at system calls
+	pathAt := func(i int) string {
+		if i >= len(args) {
+			return ""
+		}
+		name := unquote(args[i])
+		if i > 0 && (strings.HasSuffix(syscall, "at") || strings.HasSuffix(syscall, "at2")) {
+			if dir := fdPath(args[i-1]); dir != "" && !path.IsAbs(name) {
+				name = path.Join(dir, name)
+			}
+		}
+		return name
+	}
+
+	switch syscall {
+	case "execve", "execveat":
+		i := 0
+		if syscall == "execveat" {
+			i = 1
+		}
+		action.Op, action.Path = security.RuntimeExec, pathAt(i)
+		if i+1 < len(args) {
+			action.Args = splitList(args[i+1])
+		}
+	case "open", "creat", "openat", "truncate":
+		i := 0
+		if syscall == "openat" {
+			i = 1
+		}
+		if (syscall == "open" || syscall == "openat") && (i+1 >= len(args) || !writeFlags(args[i+1])) {
+			return 0, action, false
+		}
+		action.Op, action.Path = security.RuntimeWrite, pathAt(i)
+	case "unlink", "rmdir":
+		action.Op, action.Path = security.RuntimeDelete, pathAt(0)
+	case "unlinkat":
+		action.Op, action.Path = security.RuntimeDelete, pathAt(1)
+	case "mkdir":
+		action.Op, action.Path = security.RuntimeMkdir, pathAt(0)
+	case "mkdirat":
+		action.Op, action.Path = security.RuntimeMkdir, pathAt(1)
+	case "rename":
+		action.Op, action.Path, action.Target = security.RuntimeRename, pathAt(0), pathAt(1)
+	case "renameat", "renameat2":
+		action.Op, action.Path, action.Target = security.RuntimeRename, pathAt(1), pathAt(3)
+	case "chmod":
+		action.Op, action.Path = security.RuntimeChmod, pathAt(0)
+	case "fchmodat":
+		action.Op, action.Path = security.RuntimeChmod, pathAt(1)
+	case "chown", "lchown":
+		action.Op, action.Path = security.RuntimeChown, pathAt(0)
+	case "fchownat":
+		action.Op, action.Path = security.RuntimeChown, pathAt(1)
+	case "symlink":
+		action.Op, action.Target, action.Path = security.RuntimeLink, pathAt(0), pathAt(1)
+	case "symlinkat":
+		action.Op, action.Target, action.Path = security.RuntimeLink, unquote(args[0]), pathAt(2)
+	case "link":
+		action.Op, action.Target, action.Path = security.RuntimeLink, pathAt(0), pathAt(1)
+	case "linkat":
+		action.Op, action.Target, action.Path = security.RuntimeLink, pathAt(1), pathAt(3)
+	default:
+		return 0, action, false
+	}
+	if action.Path == "" || ignored(action.Path) {
+		return 0, action, false
+	}
+	return t, action, true
+}
+
+// notFound reports whether action is an exec of a program that does not exist
+func notFound(action security.RuntimeAction) bool {
+	return action.Op == security.RuntimeExec && action.Error == "ENOENT"
+}
+
+// ignored reports whether name is one of ignoredPaths or below one
+func ignored(name string) bool {
+	for _, prefix := range ignoredPaths {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFlags reports whether open flags such as O_WRONLY|O_CREAT can change
+// the file
+func writeFlags(flags string) bool {
+	for _, flag := range strings.Split(flags, "|") {
+		switch strings.TrimSpace(flag) {
+		case "O_WRONLY", "O_RDWR", "O_CREAT", "O_TRUNC", "O_APPEND":
+			return true
+		}
+	}
+	return false
+}
+
+// fdPath returns the path strace -y prints for a directory descriptor, as in
+// `3</etc>` or `AT_FDCWD</>`
+func fdPath(arg string) string {
+	start, end := strings.Index(arg, "<"), strings.LastIndex(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+// splitArgs splits system call arguments at top-level commas, keeping quoted
+// strings, arrays and structures whole
+func splitArgs(s string) []string {
+	var args []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		args = append(args, rest)
+	}
+	return args
+}
+
+// splitList parses an array argument such as ["ls", "-l"]
+func splitList(s string) []string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil
+	}
+	var items []string
+	for _, item := range splitArgs(s[1 : len(s)-1]) {
+		items = append(items, unquote(item))
+	}
+	return items
+}
+
+// unquote decodes a C-escaped string argument; a descriptor path printed by
+// -y, as in `3</etc/passwd>`, is returned as the path
+func unquote(s string) string {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "...")
+	if !strings.HasPrefix(s, `"`) {
+		return fdPath(s)
+	}
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return strings.Trim(s, `"`)
+}
@@ -0,0 +1,110 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/spf13/cobra"
+)
+
+// CommandOptions contains options for the template command
+type CommandOptions struct {
+	Snippets []string
+	Options
+	Output  string
+	Profile string
+	Policy  string
+	Strict  bool
+}
+
+// NewTemplateCommand creates a command that writes maintainer script skeletons
+func NewTemplateCommand() *cobra.Command {
+	options := &CommandOptions{}
+
+	var snippetHelp strings.Builder
+	for _, name := range SnippetNames() {
+		snippet, _ := LookupSnippet(name)
+		fmt.Fprintf(&snippetHelp, "  %-14s %s\n", name, snippet.Description)
+	}
+
+	cmd := &cobra.Command{
+		Use:   "template <" + strings.Join(Scripts, "|") + "> [flags]",
+		Short: "Generate a maintainer script skeleton from vetted snippets",
+		Long: `Generate a maintainer script composed from vetted snippets.
+
+The script handles every action dpkg may call it with, and each --with adds
+the commands of a snippet to the matching actions. The result is checked by
+the script validator with the selected profile and policy, like the scripts
+given to pkginstall build, and is only written if it passes. A generated
+postinst contains a #PKGINSTALL# line where pkginstall build inserts its own
+install-time steps.
+
+Snippets:
+` + snippetHelp.String() + `
+Examples:
+  pkginstall template postinst --name myapp --with systemd --with user-creation
+  pkginstall template prerm --name myapp --with systemd -o debian/prerm
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTemplateCommand(args[0], options)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&options.Snippets, "with", "w", nil, "Snippet to include (repeatable): "+strings.Join(SnippetNames(), ", "))
+	cmd.Flags().StringVarP(&options.Package, "name", "n", "", "Package name, the default for --service and --user")
+	cmd.Flags().StringVar(&options.Service, "service", "", "systemd unit of the systemd snippet (default: <name>.service)")
+	cmd.Flags().StringVar(&options.User, "user", "", "System user of the user-creation snippet (default: <name>)")
+	cmd.Flags().StringVarP(&options.Output, "output", "o", "", "Write the script to this file (mode 0755) instead of stdout")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile ("+strings.Join(security.ProfileNames(), ", ")+")")
+	cmd.Flags().StringVar(&options.Policy, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.Flags().BoolVar(&options.Strict, "strict", false, "Validate at the high security level, as pkginstall build --strict does")
+	return cmd
+}
+
+// runTemplateCommand generates, validates and writes the script
+func runTemplateCommand(script string, options *CommandOptions) error {
+	content, err := Generate(script, options.Snippets, options.Options)
+	if err != nil {
+		return err
+	}
+
+	profile, err := security.LookupProfile(options.Profile)
+	if err != nil {
+		return err
+	}
+	opts := append([]security.ScriptValidatorOption{security.WithSecurityLevel(security.SecurityLevelMedium)},
+		profile.ScriptValidatorOptions()...)
+	if options.Policy != "" {
+		policy, err := security.LoadPolicyFile(options.Policy)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, policy.ScriptValidatorOptions()...)
+	}
+	if options.Strict {
+		opts = append(opts, security.WithSecurityLevel(security.SecurityLevelHigh))
+	}
+	validator := security.NewScriptValidator(opts...)
+	result, err := validator.ValidateScript(script, content)
+	if err != nil {
+		return fmt.Errorf("failed to validate %s: %w", script, err)
+	}
+	if !result.Valid {
+		return fmt.Errorf("the generated %s fails validation under this policy (%s):\n- %s",
+			script, validator.GetRiskAssessment(result), strings.Join(append(result.Errors, result.Warnings...), "\n- "))
+	}
+
+	if options.Output == "" {
+		fmt.Print(content)
+		return nil
+	}
+	if err := os.WriteFile(options.Output, []byte(content), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", options.Output, err)
+	}
+	fmt.Printf("Wrote %s\n", options.Output)
+	return nil
+}
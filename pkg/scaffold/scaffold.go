@@ -0,0 +1,285 @@
+// Package scaffold generates maintainer script skeletons from vetted
+// snippets, so common install-time tasks need no hand-written shell.
+package scaffold
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Scripts lists the maintainer scripts a skeleton can be generated for
+var Scripts = []string{"preinst", "postinst", "prerm", "postrm"}
+
+// scriptActions lists the case arms of each maintainer script, as the
+// skeletons in dh_make and debhelper spell them
+var scriptActions = map[string][]string{
+	"preinst":  {"install|upgrade", "abort-upgrade"},
+	"postinst": {"configure", "abort-upgrade|abort-remove|abort-deconfigure"},
+	"prerm":    {"remove|upgrade|deconfigure", "failed-upgrade"},
+	"postrm":   {"remove", "purge", "upgrade|failed-upgrade|abort-install|abort-upgrade|disappear"},
+}
+
+// Options are the values snippets are filled in with
+type Options struct {
+	Package string // Package name
+	Service string // systemd unit (default: <package>.service)
+	User    string // System user (default: the package name)
+}
+
+// Snippet contributes commands to the case arms of maintainer scripts
+type Snippet struct {
+	Name        string
+	Description string
+	// Order places the snippet in installation scripts, lowest first,
+	// whatever the order it was asked for in
+	Order int
+	// Needs lists the Options fields the snippet requires: "service" or "user"
+	Needs []string
+	// Setup returns commands run before the case statement, such as
+	// variable assignments; nil or "" adds nothing
+	Setup func(script string, opts Options) string
+	// Code returns the commands for a script's case arm, or "" if the
+	// snippet adds nothing there
+	Code func(script, action string, opts Options) string
+}
+
+var snippets = map[string]Snippet{}
+
+// RegisterSnippet makes a snippet available under its name. Registering an
+// existing name replaces the previous snippet.
+func RegisterSnippet(snippet Snippet) {
+	snippets[snippet.Name] = snippet
+}
+
+// SnippetNames returns the sorted names of all registered snippets
+func SnippetNames() []string {
+	names := make([]string, 0, len(snippets))
+	for name := range snippets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupSnippet returns the named snippet
+func LookupSnippet(name string) (Snippet, error) {
+	snippet, ok := snippets[strings.ToLower(name)]
+	if !ok {
+		return Snippet{}, fmt.Errorf("unknown snippet %q (available: %s)", name, strings.Join(SnippetNames(), ", "))
+	}
+	return snippet, nil
+}
+
+// Generate returns the skeleton of a maintainer script composed from the
+// named snippets, ordered by their Order: a user is created before a
+// service that runs as it is started. Removal scripts run them in reverse
+// order, so that what is set up last is torn down first. The postinst keeps a
+// #PKGINSTALL# line where pkginstall build inserts its generated steps.
+func Generate(script string, names []string, opts Options) (string, error) {
+	actions, ok := scriptActions[script]
+	if !ok {
+		return "", fmt.Errorf("unknown maintainer script %q (available: %s)", script, strings.Join(Scripts, ", "))
+	}
+	if opts.Service == "" && opts.Package != "" {
+		opts.Service = opts.Package + ".service"
+	}
+	if opts.User == "" {
+		opts.User = opts.Package
+	}
+
+	var selected []Snippet
+	seen := make(map[string]bool)
+	for _, name := range names {
+		snippet, err := LookupSnippet(name)
+		if err != nil {
+			return "", err
+		}
+		if seen[snippet.Name] {
+			continue
+		}
+		seen[snippet.Name] = true
+		for _, need := range snippet.Needs {
+			if err := checkOption(snippet.Name, need, opts); err != nil {
+				return "", err
+			}
+		}
+		selected = append(selected, snippet)
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Order < selected[j].Order })
+	var out strings.Builder
+	out.WriteString("#!/bin/sh\n")
+	if opts.Package != "" {
+		fmt.Fprintf(&out, "# %s script for %s\n", script, opts.Package)
+	}
+	out.WriteString("# Generated by pkginstall template")
+	if len(selected) > 0 {
+		selectedNames := make([]string, len(selected))
+		for i, snippet := range selected {
+			selectedNames[i] = snippet.Name
+		}
+		fmt.Fprintf(&out, " with: %s", strings.Join(selectedNames, ", "))
+	}
+	if script == "prerm" || script == "postrm" {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+	out.WriteString("\n\nset -e\n\n")
+	for _, snippet := range selected {
+		if snippet.Setup != nil {
+			if setup := snippet.Setup(script, opts); setup != "" {
+				out.WriteString(setup + "\n")
+			}
+		}
+	}
+	out.WriteString("case \"$1\" in\n")
+	for _, action := range actions {
+		fmt.Fprintf(&out, "    %s)\n", action)
+		// Symlinks and other generated steps come first, so that services
+		// started by the snippets find them
+		if script == "postinst" && action == "configure" {
+			out.WriteString("        #PKGINSTALL#\n")
+		}
+		for _, snippet := range selected {
+			if code := snippet.Code(script, action, opts); code != "" {
+				out.WriteString(indent(code, "        "))
+			}
+		}
+		out.WriteString("    ;;\n\n")
+	}
+	fmt.Fprintf(&out, "    *)\n        echo \"%s called with unknown argument \\`$1'\" >&2\n        exit 1\n    ;;\nesac\n\nexit 0\n", script)
+	return out.String(), nil
+}
+
+// checkOption reports a missing or unsafe value a snippet needs
+func checkOption(snippet, need string, opts Options) error {
+	var value, flag string
+	switch need {
+	case "service":
+		value, flag = opts.Service, "--service"
+	case "user":
+		value, flag = opts.User, "--user"
+	}
+	if value == "" {
+		return fmt.Errorf("the %s snippet requires --name or %s", snippet, flag)
+	}
+	// Values are pasted into shell commands
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._@+-", r)) {
+			return fmt.Errorf("invalid %s value %q for the %s snippet", flag, value, snippet)
+		}
+	}
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("invalid %s value %q for the %s snippet", flag, value, snippet)
+	}
+	return nil
+}
+
+// indent prefixes every non-empty line of code
+func indent(code, prefix string) string {
+	var out strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(code, "\n"), "\n") {
+		if line != "" {
+			out.WriteString(prefix)
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
+
+func init() {
+	RegisterSnippet(Snippet{
+		Name:        "systemd",
+		Order:       30,
+		Description: "enable and start a systemd unit, stop it on removal (--service)",
+		Needs:       []string{"service"},
+		Setup:       systemdSetup,
+		Code:        systemdCode,
+	})
+	RegisterSnippet(Snippet{
+		Name:        "user-creation",
+		Order:       10,
+		Description: "create a system user and group for the package (--user)",
+		Needs:       []string{"user"},
+		Code:        userCode,
+	})
+	RegisterSnippet(Snippet{
+		Name:        "ldconfig",
+		Order:       20,
+		Description: "refresh the shared library cache after installation and removal",
+		Code:        ldconfigCode,
+	})
+}
+
+// systemdSetup names the unit once, in the scripts that manage it
+func systemdSetup(script string, opts Options) string {
+	if script == "preinst" {
+		return ""
+	}
+	return "UNIT='" + opts.Service + "'\n"
+}
+
+// systemdCode follows what dh_installsystemd generates: deb-systemd-helper
+// tracks the enable state, deb-systemd-invoke honours policy-rc.d
+func systemdCode(script, action string, opts Options) string {
+	unit := `"$UNIT"`
+	switch script + " " + action {
+	case "postinst configure":
+		return `deb-systemd-helper --quiet unmask ` + unit + ` || true
+# was-enabled is true for units it has never seen, so new installations enable the unit
+if deb-systemd-helper --quiet was-enabled ` + unit + `; then
+    deb-systemd-helper --quiet enable ` + unit + ` || true
+else
+    deb-systemd-helper --quiet update-state ` + unit + ` || true
+fi
+if [ -d /run/systemd/system ]; then
+    systemctl --system daemon-reload || true
+    deb-systemd-invoke restart ` + unit + ` || true
+fi
+`
+	case "prerm remove|upgrade|deconfigure":
+		return `if [ -d /run/systemd/system ] && [ "$1" = remove ]; then
+    deb-systemd-invoke stop ` + unit + ` || true
+fi
+`
+	case "postrm remove":
+		return `if [ -d /run/systemd/system ]; then
+    systemctl --system daemon-reload || true
+fi
+if [ -n "$(command -v deb-systemd-helper)" ]; then
+    deb-systemd-helper --quiet mask ` + unit + ` || true
+fi
+`
+	case "postrm purge":
+		return `if [ -n "$(command -v deb-systemd-helper)" ]; then
+    deb-systemd-helper --quiet purge ` + unit + ` || true
+    deb-systemd-helper --quiet unmask ` + unit + ` || true
+fi
+`
+	}
+	return ""
+}
+
+// userCode creates a system user without a home directory or login shell.
+// The user is kept on purge, as Debian policy recommends, since files it
+// owns may remain.
+func userCode(script, action string, opts Options) string {
+	if script+" "+action != "postinst configure" {
+		return ""
+	}
+	user := "'" + opts.User + "'"
+	return `if [ -z "$(getent passwd ` + user + `)" ]; then
+    adduser --system --group --quiet --no-create-home --home /nonexistent ` + user + `
+fi
+`
+}
+
+// ldconfigCode refreshes the linker cache where libraries appear or go away
+func ldconfigCode(script, action string, opts Options) string {
+	switch script + " " + action {
+	case "postinst configure", "postrm remove":
+		return "ldconfig\n"
+	}
+	return ""
+}
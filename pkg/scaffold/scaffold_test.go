@@ -0,0 +1,88 @@
+package scaffold
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+func TestGenerate(t *testing.T) {
+	all := SnippetNames()
+	for _, script := range Scripts {
+		t.Run(script, func(t *testing.T) {
+			content, err := Generate(script, all, Options{Package: "myapp"})
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			// The skeletons must pass the strictest built-in validation
+			validator := security.NewScriptValidator(security.WithSecurityLevel(security.SecurityLevelHigh))
+			result, err := validator.ValidateScript(script, content)
+			if err != nil {
+				t.Fatalf("ValidateScript() error = %v", err)
+			}
+			if !result.Valid {
+				t.Errorf("Generated %s fails validation: %v %v\n%s", script, result.Errors, result.Warnings, content)
+			}
+
+			if sh, err := exec.LookPath("sh"); err == nil {
+				cmd := exec.Command(sh, "-n")
+				cmd.Stdin = strings.NewReader(content)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Errorf("Generated %s is not valid shell: %v: %s", script, err, out)
+				}
+			}
+
+			if strings.Contains(content, "#PKGINSTALL#") != (script == "postinst") {
+				t.Errorf("Unexpected #PKGINSTALL# placement:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestGenerateOrder(t *testing.T) {
+	postinst, err := Generate("postinst", []string{"systemd", "user-creation"}, Options{Package: "myapp"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if strings.Index(postinst, "adduser") > strings.Index(postinst, "deb-systemd-invoke restart") {
+		t.Errorf("The user is created after the service starts:\n%s", postinst)
+	}
+
+	postrm, err := Generate("postrm", []string{"systemd", "ldconfig"}, Options{Package: "myapp", Service: "myapp-worker.service"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(postrm, "UNIT='myapp-worker.service'\n") {
+		t.Errorf("--service is not used:\n%s", postrm)
+	}
+	if strings.Index(postrm, "daemon-reload") > strings.Index(postrm, "        ldconfig\n") {
+		t.Errorf("Removal does not run in reverse order:\n%s", postrm)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		with    []string
+		opts    Options
+		wantErr string
+	}{
+		{"Unknown script", "config", nil, Options{}, "unknown maintainer script"},
+		{"Unknown snippet", "postinst", []string{"cron"}, Options{Package: "myapp"}, "unknown snippet"},
+		{"Missing name", "postinst", []string{"systemd"}, Options{}, "requires --name or --service"},
+		{"Shell injection", "postinst", []string{"user-creation"}, Options{Package: "myapp", User: "x'; rm -rf /; '"}, "invalid --user"},
+		{"Option injection", "postinst", []string{"user-creation"}, Options{User: "--uid=0"}, "invalid --user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(tt.script, tt.with, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Generate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package security
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+)
+
+// FileType is the kind of content of a file, detected from its first bytes
+// rather than its name
+type FileType string
+
+const (
+	FileTypeELF     FileType = "elf"     // Executables, shared libraries and objects
+	FileTypeScript  FileType = "script"  // Files starting with a #! line
+	FileTypeArchive FileType = "archive" // Compressed files and archives, including static libraries
+	FileTypeImage   FileType = "image"   // Binary image formats
+	FileTypeText    FileType = "text"    // UTF-8 text without a #! line
+	FileTypeData    FileType = "data"    // Any other binary content
+)
+
+// FileTypes lists the detectable types
+var FileTypes = []FileType{FileTypeELF, FileTypeScript, FileTypeArchive, FileTypeImage, FileTypeText, FileTypeData}
+
+// sniffLength is the number of bytes read to detect a file type; tar headers
+// carry their magic at offset 257
+const sniffLength = 512
+
+// magic maps leading bytes to the type they identify
+var magic = []struct {
+	offset int
+	prefix string
+	kind   FileType
+}{
+	{0, "\x7fELF", FileTypeELF},
+	{0, "#!", FileTypeScript},
+	{0, "\x1f\x8b", FileTypeArchive},           // gzip
+	{0, "BZh", FileTypeArchive},                // bzip2
+	{0, "\xfd7zXZ\x00", FileTypeArchive},       // xz
+	{0, "\x28\xb5\x2f\xfd", FileTypeArchive},   // zstd
+	{0, "\x04\x22\x4d\x18", FileTypeArchive},   // lz4
+	{0, "PK\x03\x04", FileTypeArchive},         // zip, jar
+	{0, "7z\xbc\xaf\x27\x1c", FileTypeArchive}, // 7-Zip
+	{0, "!<arch>\n", FileTypeArchive},          // ar: static libraries and .deb files
+	{257, "ustar", FileTypeArchive},            // tar
+	{0, "\x89PNG\r\n\x1a\n", FileTypeImage},    // PNG
+	{0, "\xff\xd8\xff", FileTypeImage},         // JPEG
+	{0, "GIF87a", FileTypeImage},               // GIF
+	{0, "GIF89a", FileTypeImage},               // GIF
+	{0, "\x00\x00\x01\x00", FileTypeImage},     // ICO
+}
+
+// DetectFileType returns the type of content starting with header
+func DetectFileType(header []byte) FileType {
+	for _, m := range magic {
+		if len(header) >= m.offset+len(m.prefix) && string(header[m.offset:m.offset+len(m.prefix)]) == m.prefix {
+			return m.kind
+		}
+	}
+	if len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP" {
+		return FileTypeImage
+	}
+	if bytes.IndexByte(header, 0) >= 0 {
+		return FileTypeData
+	}
+	// The header may end in the middle of a multi-byte character
+	for i := 0; i < utf8.UTFMax && len(header) > 0 && !utf8.Valid(header); i++ {
+		header = header[:len(header)-1]
+	}
+	if !utf8.Valid(header) {
+		return FileTypeData
+	}
+	return FileTypeText
+}
+
+// DetectFile returns the type of the file at path
+func DetectFile(path string) (FileType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return DetectFileType(header[:n]), nil
+}
+
+// ParseFileType checks that name is one of FileTypes
+func ParseFileType(name string) (FileType, error) {
+	for _, t := range FileTypes {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	names := make([]string, len(FileTypes))
+	for i, t := range FileTypes {
+		names[i] = string(t)
+	}
+	return "", fmt.Errorf("unknown file type %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// DefaultFileTypeLocations returns where files of each type are expected, as
+// exclude-style patterns (see pattern.Matcher): "bin/" matches every bin
+// directory. Types without an entry are expected anywhere.
+func DefaultFileTypeLocations() map[FileType][]string {
+	return map[FileType][]string{
+		FileTypeELF: {"bin/", "sbin/", "lib/", "lib32/", "lib64/", "libx32/", "libexec/", "games/", "*.so", "*.so.*"},
+		FileTypeArchive: {"lib/", "lib32/", "lib64/", "libx32/", "share/", "doc/", "/var/cache/", "/var/lib/",
+			"*.gz", "*.bz2", "*.xz", "*.zst", "*.zip", "*.jar", "*.war", "*.tar", "*.tgz", "*.a"},
+	}
+}
+
+// extensionTypes are the types of content a file extension promises
+var extensionTypes = map[string][]FileType{
+	".png": {FileTypeImage}, ".jpg": {FileTypeImage}, ".jpeg": {FileTypeImage}, ".gif": {FileTypeImage},
+	".webp": {FileTypeImage}, ".ico": {FileTypeImage},
+	".so": {FileTypeELF},
+	".gz": {FileTypeArchive}, ".tgz": {FileTypeArchive}, ".bz2": {FileTypeArchive}, ".xz": {FileTypeArchive},
+	".zst": {FileTypeArchive}, ".zip": {FileTypeArchive}, ".jar": {FileTypeArchive}, ".tar": {FileTypeArchive},
+	".a": {FileTypeArchive}, ".deb": {FileTypeArchive},
+	".sh": {FileTypeScript, FileTypeText}, ".bash": {FileTypeScript, FileTypeText}, ".py": {FileTypeScript, FileTypeText},
+	".pl": {FileTypeScript, FileTypeText}, ".rb": {FileTypeScript, FileTypeText},
+	".txt": {FileTypeText}, ".md": {FileTypeText}, ".conf": {FileTypeText}, ".cfg": {FileTypeText}, ".ini": {FileTypeText},
+	".json": {FileTypeText}, ".yml": {FileTypeText}, ".yaml": {FileTypeText}, ".xml": {FileTypeText}, ".svg": {FileTypeText},
+	".html": {FileTypeText}, ".css": {FileTypeText}, ".js": {FileTypeText, FileTypeScript},
+	".service": {FileTypeText}, ".socket": {FileTypeText}, ".target": {FileTypeText}, ".timer": {FileTypeText},
+	".desktop": {FileTypeText},
+}
+
+// typeDescriptions name the types in findings
+var typeDescriptions = map[FileType]string{
+	FileTypeELF:     "an ELF binary",
+	FileTypeScript:  "a script",
+	FileTypeArchive: "an archive",
+	FileTypeImage:   "an image",
+	FileTypeText:    "text",
+	FileTypeData:    "binary data",
+}
+
+// compileFileTypeLocations compiles the expected locations of each type
+func compileFileTypeLocations(locations map[FileType][]string) (map[FileType]*pattern.Matcher, error) {
+	matchers := make(map[FileType]*pattern.Matcher, len(locations))
+	for kind, patterns := range locations {
+		if len(patterns) == 0 {
+			continue
+		}
+		m, err := pattern.NewMatcher(patterns, nil)
+		if err != nil {
+			return nil, fmt.Errorf("file type %s: %w", kind, err)
+		}
+		matchers[kind] = m
+	}
+	return matchers, nil
+}
+
+// CheckFileType detects the type of the file at filePath, which is packaged
+// as path, and returns findings when the content is unexpected at that
+// location or does not match the file extension, such as an ELF binary in
+// /etc or a .png file that is a script. Extensionless files are judged by
+// their content alone.
+func (v *Validator) CheckFileType(path, filePath string) (FileType, []string, error) {
+	kind, err := DetectFile(filePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to detect the type of %s: %w", path, err)
+	}
+
+	var findings []string
+	if m, ok := v.typeLocations[kind]; ok && !m.Excluded(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/"), false) {
+		findings = append(findings, fmt.Sprintf("%s is %s outside the locations expected for %s files", path, typeDescriptions[kind], kind))
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if want, ok := extensionTypes[ext]; ok && !containsType(want, kind) {
+		names := make([]string, len(want))
+		for i, t := range want {
+			names[i] = string(t)
+		}
+		sort.Strings(names)
+		findings = append(findings, fmt.Sprintf("%s is %s, but the %s extension promises %s", path, typeDescriptions[kind], ext, strings.Join(names, " or ")))
+	}
+	return kind, findings, nil
+}
+
+// containsType reports whether types contains kind
+func containsType(types []FileType, kind FileType) bool {
+	for _, t := range types {
+		if t == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package security
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectFileType(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   FileType
+	}{
+		{"ELF", []byte("\x7fELF\x02\x01\x01\x00"), FileTypeELF},
+		{"Shell script", []byte("#!/bin/sh\necho hi\n"), FileTypeScript},
+		{"Gzip", []byte("\x1f\x8b\x08\x00"), FileTypeArchive},
+		{"Tar", tar, FileTypeArchive},
+		{"Static library", []byte("!<arch>\nlibx.o/"), FileTypeArchive},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00"), FileTypeImage},
+		{"Text", []byte("listen 80;\n"), FileTypeText},
+		{"Text cut in a character", []byte("caf\xc3"), FileTypeText},
+		{"Empty", nil, FileTypeText},
+		{"Binary data", []byte("SQLite format 3\x00"), FileTypeData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFileType(tt.header); got != tt.want {
+				t.Errorf("DetectFileType() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFileType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetype-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{
+		"elf":    "\x7fELF\x02\x01\x01\x00",
+		"script": "#!/bin/sh\nexit 0\n",
+		"text":   "key = value\n",
+		"data":   "\x00\x01\x02",
+		"gzip":   "\x1f\x8b\x08\x00",
+	}
+	for name, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		content  string
+		wantType FileType
+		want     []string
+	}{
+		{"Extensionless binary", "/usr/local/bin/app", "elf", FileTypeELF, nil},
+		{"Versioned library", "/opt/app/plugins/libx.so.1", "elf", FileTypeELF, nil},
+		{"Binary in /etc", "/etc/app/helper", "elf", FileTypeELF,
+			[]string{"/etc/app/helper is an ELF binary outside the locations expected for elf files"}},
+		{"Data file without extension", "/var/lib/app/state", "data", FileTypeData, nil},
+		{"Compressed man page", "/usr/share/man/man1/app.1.gz", "gzip", FileTypeArchive, nil},
+		{"Archive in /etc", "/etc/app/bundle", "gzip", FileTypeArchive,
+			[]string{"/etc/app/bundle is an archive outside the locations expected for archive files"}},
+		{"Disguised script", "/usr/share/app/logo.png", "script", FileTypeScript,
+			[]string{"/usr/share/app/logo.png is a script, but the .png extension promises image"}},
+		{"Config with a script", "/etc/app/app.conf", "script", FileTypeScript,
+			[]string{"/etc/app/app.conf is a script, but the .conf extension promises text"}},
+		{"Python module", "/usr/lib/app/mod.py", "text", FileTypeText, nil},
+	}
+
+	v := NewValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, findings, err := v.CheckFileType(tt.path, filepath.Join(dir, tt.content))
+			if err != nil {
+				t.Fatalf("CheckFileType() error = %v", err)
+			}
+			if kind != tt.wantType || !reflect.DeepEqual(findings, tt.want) {
+				t.Errorf("CheckFileType() = %s, %q, want %s, %q", kind, findings, tt.wantType, tt.want)
+			}
+		})
+	}
+
+	t.Run("Policy adds locations", func(t *testing.T) {
+		policy := &PolicyFile{Paths: PathPolicy{FileTypes: map[string][]string{"elf": {"/etc/app/"}}}}
+		_, findings, err := NewValidator(policy.ValidatorOptions()...).CheckFileType("/etc/app/helper", filepath.Join(dir, "elf"))
+		if err != nil || len(findings) != 0 {
+			t.Errorf("CheckFileType() = %q, %v, want the policy location accepted", findings, err)
+		}
+		_, findings, _ = NewValidator(policy.ValidatorOptions()...).CheckFileType("/etc/other/helper", filepath.Join(dir, "elf"))
+		if len(findings) != 1 || !strings.Contains(findings[0], "outside the locations") {
+			t.Errorf("CheckFileType() = %q, want the default locations kept", findings)
+		}
+	})
+}
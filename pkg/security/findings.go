@@ -0,0 +1,182 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Codes of the findings reported by the Validator. Like the script rule IDs
+// they are stable, so callers can filter on them rather than on messages.
+const (
+	CodeInvalidPath         = "PKV001" // Empty or relative path
+	CodePathTooLong         = "PKV002"
+	CodeDotDot              = "PKV003"
+	CodeForbiddenPath       = "PKV004"
+	CodeRestrictedPath      = "PKV005"
+	CodeExemptPath          = "PKV006"
+	CodePathTraversal       = "PKV007" // Traversal, encoded traversal or a NUL byte
+	CodeUnusualPathElement  = "PKV008" // ~, $ or a backquote in a path
+	CodeInvalidSymlink      = "PKV009"
+	CodePackageStructure    = "PKV010" // Missing DEBIAN directory or control file, or an unexpected DEBIAN file
+	CodeScriptFile          = "PKV011"
+	CodeInsecurePermissions = "PKV012"
+	CodePluginRejection     = "PKV013"
+)
+
+// ValidationFinding is a single structured result of the Validator
+type ValidationFinding struct {
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path,omitempty"` // Installed path the finding is about, if any
+	Message  string   `json:"message"`
+}
+
+// String returns the finding on one line
+func (f ValidationFinding) String() string {
+	return fmt.Sprintf("%s %s: %s", f.Code, f.Severity, f.Message)
+}
+
+// severityRank orders severities from least to most severe
+var severityRank = map[Severity]int{
+	SeverityNote:    0,
+	SeverityWarning: 1,
+	SeverityError:   2,
+}
+
+// AtLeast reports whether s is as severe as min or more
+func (s Severity) AtLeast(min Severity) bool {
+	return severityRank[s] >= severityRank[min]
+}
+
+// ValidationError is the error returned for a finding that fails
+// validation. Callers get the finding with errors.As instead of matching
+// the message.
+type ValidationError struct {
+	Finding ValidationFinding
+	Report  *Report // Every finding of the package, for errors of ValidatePackage
+}
+
+func (e *ValidationError) Error() string { return e.Finding.Message }
+
+// FindingOf returns the finding carried by err or any error it wraps
+func FindingOf(err error) (ValidationFinding, bool) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Finding, true
+	}
+	return ValidationFinding{}, false
+}
+
+// newFinding formats a finding of code and severity about path
+func newFinding(code string, severity Severity, path, format string, args ...interface{}) ValidationFinding {
+	return ValidationFinding{Code: code, Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// failure returns the error of a finding of code about path
+func failure(code, path, format string, args ...interface{}) error {
+	return &ValidationError{Finding: newFinding(code, SeverityError, path, format, args...)}
+}
+
+// Report holds the findings of a validation, in the order they were found
+// unless sorted
+type Report struct {
+	Findings    []ValidationFinding `json:"findings"`
+	Permissions []PermissionIssue   `json:"permissions,omitempty"` // Insecure modes found by the permissions audit, sorted by path
+}
+
+// Add appends findings to the report
+func (r *Report) Add(findings ...ValidationFinding) {
+	r.Findings = append(r.Findings, findings...)
+}
+
+// Filter returns the findings keep accepts
+func (r *Report) Filter(keep func(ValidationFinding) bool) []ValidationFinding {
+	var found []ValidationFinding
+	for _, f := range r.Findings {
+		if keep(f) {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// AtLeast returns the findings as severe as min or more
+func (r *Report) AtLeast(min Severity) []ValidationFinding {
+	return r.Filter(func(f ValidationFinding) bool { return f.Severity.AtLeast(min) })
+}
+
+// WithCode returns the findings of one of codes
+func (r *Report) WithCode(codes ...string) []ValidationFinding {
+	return r.Filter(func(f ValidationFinding) bool {
+		for _, code := range codes {
+			if f.Code == code {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Errors returns the findings that fail validation
+func (r *Report) Errors() []ValidationFinding {
+	return r.AtLeast(SeverityError)
+}
+
+// Valid reports whether no finding fails validation
+func (r *Report) Valid() bool {
+	return len(r.Errors()) == 0
+}
+
+// Sort orders the findings by path, then by code
+func (r *Report) Sort() {
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		if r.Findings[i].Path != r.Findings[j].Path {
+			return r.Findings[i].Path < r.Findings[j].Path
+		}
+		return r.Findings[i].Code < r.Findings[j].Code
+	})
+}
+
+// Err returns the error ValidatePackage returns for the report of a package,
+// or nil if nothing in it fails validation. Invalid files are reported
+// first, then insecure permissions and then the findings of plugins.
+func (r *Report) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	var invalid, permissions, plugins []ValidationFinding
+	for _, f := range errs {
+		switch f.Code {
+		case CodeInsecurePermissions:
+			permissions = append(permissions, f)
+		case CodePluginRejection:
+			plugins = append(plugins, f)
+		default:
+			invalid = append(invalid, f)
+		}
+	}
+
+	var summary ValidationFinding
+	switch {
+	case len(invalid) > 0:
+		// A missing DEBIAN directory or control file is the only finding
+		summary = invalid[0]
+		if summary.Path != "/DEBIAN" && summary.Path != "/DEBIAN/control" {
+			summary.Message = fmt.Sprintf("package contains %d invalid files", len(invalid))
+		}
+	case len(permissions) > 0:
+		summary = permissions[0]
+		summary.Message = fmt.Sprintf("package contains %d path(s) with insecure permissions, such as %s", len(permissions), permissions[0].Message)
+	default:
+		summary = plugins[0]
+		problems := make([]string, len(plugins))
+		for i, f := range plugins {
+			problems[i] = f.Message
+		}
+		summary.Message = "package rejected by validator plugins: " + strings.Join(problems, "; ")
+	}
+	return &ValidationError{Finding: summary, Report: r}
+}
@@ -0,0 +1,70 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckPathFindings(t *testing.T) {
+	v := NewValidator()
+
+	findings := v.CheckPath("/etc/ssh/sshd_config")
+	if len(findings) != 1 || findings[0].Code != CodeRestrictedPath || findings[0].Severity != SeverityWarning {
+		t.Errorf("CheckPath() of a restricted path = %v, want one warning", findings)
+	}
+
+	err := v.ValidatePath("/usr/bin/tool")
+	finding, ok := FindingOf(fmt.Errorf("wrapped: %w", err))
+	if !ok || finding.Code != CodeForbiddenPath || finding.Path != "/usr/bin/tool" || finding.Severity != SeverityError {
+		t.Errorf("FindingOf(%v) = %+v, %v; want a forbidden path error", err, finding, ok)
+	}
+
+	err = v.ValidateSymlink("/opt/app/link", "/usr/bin/tool")
+	if finding, ok := FindingOf(err); !ok || finding.Code != CodeForbiddenPath {
+		t.Errorf("FindingOf(%v) = %+v, %v; want a forbidden path error", err, finding, ok)
+	}
+
+	if _, ok := FindingOf(errors.New("other")); ok {
+		t.Errorf("FindingOf() of a plain error should find nothing")
+	}
+}
+
+func TestValidatePackageReport(t *testing.T) {
+	dir := writePackageTree(t, []string{"usr/bin/tool", "usr/sbin/daemon", "DEBIAN/junk", "opt/app/run.sh", "etc/ssh/app.conf"})
+	report, err := NewValidator().ValidatePackageReport(dir)
+	if err != nil {
+		t.Fatalf("ValidatePackageReport() error = %v", err)
+	}
+
+	if errs := report.Errors(); len(errs) != 5 {
+		t.Errorf("Errors() = %v, want the DEBIAN file and 4 forbidden paths", errs)
+	}
+	if forbidden := report.WithCode(CodeForbiddenPath); len(forbidden) != 4 || forbidden[0].Path != "/usr/bin" || forbidden[1].Path != "/usr/bin/tool" {
+		t.Errorf("WithCode() = %v, want the forbidden directories and files sorted", forbidden)
+	}
+	if warnings := report.AtLeast(SeverityWarning); len(warnings) != 7 {
+		t.Errorf("AtLeast(warning) = %v, want 5 errors and 2 restricted paths", warnings)
+	}
+	if notes := report.WithCode(CodeScriptFile); len(notes) != 1 || notes[0].Severity != SeverityNote {
+		t.Errorf("WithCode(%s) = %v, want one note", CodeScriptFile, notes)
+	}
+
+	err = report.Err()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Report != report || err.Error() != "package contains 5 invalid files" {
+		t.Errorf("Err() = %v, want the invalid files with the report", err)
+	}
+}
+
+func TestValidatePackageReportMissingControl(t *testing.T) {
+	dir := writePackageTree(t, nil)
+	report, err := NewValidator().ValidatePackageReport(dir + "/DEBIAN")
+	if err != nil {
+		t.Fatalf("ValidatePackageReport() error = %v", err)
+	}
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "DEBIAN directory missing") {
+		t.Errorf("Err() = %v, want the missing DEBIAN directory", err)
+	}
+}
@@ -0,0 +1,162 @@
+package security
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Hardening describes the hardening features of an ELF executable or shared
+// library, as hardening-check reports them
+type Hardening struct {
+	Path           string   `json:"path"`
+	Type           string   `json:"type"`              // executable or shared library
+	PIE            bool     `json:"pie"`               // Position independent executable; always true for libraries
+	RELRO          string   `json:"relro"`             // full, partial or none
+	NX             bool     `json:"nx"`                // Non-executable stack
+	StackProtector bool     `json:"stack_protector"`   // Calls the stack protector; true for Go binaries, which need none
+	Missing        []string `json:"missing,omitempty"` // Features the file lacks
+}
+
+// String lists the missing features of the file
+func (h *Hardening) String() string {
+	if len(h.Missing) == 0 {
+		return fmt.Sprintf("%s: fully hardened %s", h.Path, h.Type)
+	}
+	return fmt.Sprintf("%s: %s without %s", h.Path, h.Type, strings.Join(h.Missing, ", "))
+}
+
+// Dynamic section flags that request immediate binding or mark a PIE
+const (
+	dfBindNow = 0x8        // DF_BIND_NOW in DT_FLAGS
+	df1Now    = 0x1        // DF_1_NOW in DT_FLAGS_1
+	df1PIE    = 0x08000000 // DF_1_PIE in DT_FLAGS_1
+)
+
+// stackProtectorSymbols are the symbols code built with -fstack-protector uses
+var stackProtectorSymbols = map[string]bool{
+	"__stack_chk_fail":        true,
+	"__stack_chk_fail_local":  true,
+	"__stack_chk_guard":       true,
+	"__intel_security_cookie": true,
+}
+
+// CheckHardening reads the ELF file at path and reports its hardening
+// features. It returns nil for files that are not ELF executables or shared
+// libraries, such as object files and core dumps.
+func CheckHardening(path string) (*Hardening, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return checkHardening(path, f)
+}
+
+// checkHardening is CheckHardening for an open file
+func checkHardening(path string, r io.ReaderAt) (*Hardening, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return nil, nil
+	}
+
+	// Without a PT_GNU_STACK header the stack is executable
+	var interp, relro, nx bool
+	for _, prog := range f.Progs {
+		switch prog.Type {
+		case elf.PT_INTERP:
+			interp = true
+		case elf.PT_GNU_RELRO:
+			relro = true
+		case elf.PT_GNU_STACK:
+			nx = prog.Flags&elf.PF_X == 0
+		}
+	}
+
+	flags, flags1 := dynFlags(f, elf.DT_FLAGS), dynFlags(f, elf.DT_FLAGS_1)
+	bindNow := flags&dfBindNow != 0 || flags1&df1Now != 0 || hasDynTag(f, elf.DT_BIND_NOW)
+
+	h := &Hardening{Path: path, Type: "executable", NX: nx, RELRO: "none"}
+	switch {
+	case f.Type == elf.ET_EXEC:
+		h.PIE = false
+	case interp || flags1&df1PIE != 0:
+		h.PIE = true
+	default:
+		// Position independent code is all a shared library can be
+		h.Type, h.PIE = "shared library", true
+	}
+	if relro {
+		h.RELRO = "partial"
+		if bindNow {
+			h.RELRO = "full"
+		}
+	}
+	h.StackProtector = isGoBinary(f) || hasStackProtector(f)
+
+	if !h.PIE {
+		h.Missing = append(h.Missing, "PIE")
+	}
+	switch h.RELRO {
+	case "none":
+		h.Missing = append(h.Missing, "RELRO")
+	case "partial":
+		h.Missing = append(h.Missing, "immediate binding (full RELRO)")
+	}
+	if !h.NX {
+		h.Missing = append(h.Missing, "non-executable stack")
+	}
+	if !h.StackProtector {
+		h.Missing = append(h.Missing, "stack protector")
+	}
+	return h, nil
+}
+
+// dynFlags returns the value of a flags tag of the dynamic section, 0 if
+// there is none
+func dynFlags(f *elf.File, tag elf.DynTag) uint64 {
+	values, err := f.DynValue(tag)
+	if err != nil || len(values) == 0 {
+		return 0
+	}
+	return values[0]
+}
+
+// hasDynTag reports whether the dynamic section has tag
+func hasDynTag(f *elf.File, tag elf.DynTag) bool {
+	values, err := f.DynValue(tag)
+	return err == nil && len(values) > 0
+}
+
+// isGoBinary reports whether f was built by the Go toolchain, whose code
+// checks stack bounds itself and never uses the C stack protector
+func isGoBinary(f *elf.File) bool {
+	return f.Section(".go.buildinfo") != nil || f.Section(".note.go.buildid") != nil
+}
+
+// hasStackProtector reports whether f uses the stack protector, by the
+// symbols it imports or, for static binaries, defines
+func hasStackProtector(f *elf.File) bool {
+	for _, list := range []func() ([]elf.Symbol, error){f.DynamicSymbols, f.Symbols} {
+		symbols, err := list()
+		if err != nil {
+			continue
+		}
+		for _, symbol := range symbols {
+			if stackProtectorSymbols[symbol.Name] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package security
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckHardening(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hardening-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Run("Not an ELF file", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "script.sh")
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if h, err := CheckHardening(path); h != nil || err != nil {
+			t.Errorf("CheckHardening() = %v, %v; want nil", h, err)
+		}
+	})
+
+	t.Run("Go binary needs no stack protector", func(t *testing.T) {
+		exe, err := os.Executable()
+		if err != nil {
+			t.Skipf("No test executable: %v", err)
+		}
+		h, err := CheckHardening(exe)
+		if err != nil || h == nil {
+			t.Fatalf("CheckHardening() = %v, %v", h, err)
+		}
+		if !h.StackProtector || !h.NX {
+			t.Errorf("Unexpected hardening %+v", h)
+		}
+	})
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not installed")
+	}
+	source := filepath.Join(tmpDir, "hello.c")
+	code := "#include <stdio.h>\n#include <string.h>\nint main(int argc, char **argv) { char buf[64]; strcpy(buf, argv[0]); puts(buf); return 0; }\n"
+	if err := ioutil.WriteFile(source, []byte(code), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		flags   []string
+		missing string
+	}{
+		{"Hardened", []string{"-fPIE", "-pie", "-fstack-protector-all", "-Wl,-z,relro,-z,now", "-Wl,-z,noexecstack"}, ""},
+		{"Partial RELRO", []string{"-fPIE", "-pie", "-fstack-protector-all", "-Wl,-z,relro,-z,lazy"}, "immediate binding (full RELRO)"},
+		{"Unhardened", []string{"-no-pie", "-fno-stack-protector", "-Wl,-z,norelro", "-Wl,-z,execstack"},
+			"PIE, RELRO, non-executable stack, stack protector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "-"))
+			args := append(append([]string{"-o", binary}, tt.flags...), source)
+			if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+				t.Skipf("cc cannot build with %v: %v\n%s", tt.flags, err, out)
+			}
+			h, err := CheckHardening(binary)
+			if err != nil || h == nil {
+				t.Fatalf("CheckHardening() = %v, %v", h, err)
+			}
+			if got := strings.Join(h.Missing, ", "); got != tt.missing {
+				t.Errorf("Missing = %q, want %q", got, tt.missing)
+			}
+		})
+	}
+}
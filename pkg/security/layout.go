@@ -0,0 +1,138 @@
+package security
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// TransformTarget selects the directory hierarchy system paths are relocated to
+type TransformTarget string
+
+const (
+	TargetOpt      TransformTarget = "opt"       // /opt, or /opt/<pkg> with per-package directories
+	TargetUsrLocal TransformTarget = "usr-local" // /usr/local, or /usr/local/<pkg>
+	TargetSrv      TransformTarget = "srv"       // /srv/<pkg>; always per package
+)
+
+// targetRoots maps each transform target to its root directory
+var targetRoots = map[TransformTarget]string{
+	TargetOpt:      "/opt",
+	TargetUsrLocal: "/usr/local",
+	TargetSrv:      "/srv",
+}
+
+// ParseTransformTarget converts "opt", "usr-local" or "srv" to a TransformTarget.
+// An empty string selects the default /opt target.
+func ParseTransformTarget(target string) (TransformTarget, error) {
+	if target == "" {
+		return TargetOpt, nil
+	}
+	t := TransformTarget(strings.ToLower(target))
+	if _, ok := targetRoots[t]; !ok {
+		return "", fmt.Errorf("unknown transform target: %s (available: opt, usr-local, srv)", target)
+	}
+	return t, nil
+}
+
+// PathLayout describes where the PathMapper relocates system paths
+type PathLayout struct {
+	Target     TransformTarget   `json:"target,omitempty"`
+	Package    string            `json:"package,omitempty"`     // Package name used for per-package directories
+	PerPackage bool              `json:"per_package,omitempty"` // Relocate into <root>/<pkg> rather than a shared root
+	Mappings   []PathMapping     `json:"mappings,omitempty"`    // Extra mappings applied on top of the layout
+	Rules      []MappingRuleSpec `json:"rules,omitempty"`       // Ordered pattern rules tried before the mappings
+	Exempt     []string          `json:"exempt,omitempty"`      // Paths shipped at their real location
+}
+
+// Validate checks that the layout can be turned into a mapping table
+func (l *PathLayout) Validate() error {
+	if _, ok := targetRoots[l.target()]; !ok {
+		return fmt.Errorf("unknown transform target: %s", l.Target)
+	}
+	if l.perPackage() {
+		if l.Package == "" || strings.ContainsAny(l.Package, "/\\") || l.Package == "." || l.Package == ".." {
+			return fmt.Errorf("invalid package name for per-package directory: %q", l.Package)
+		}
+	}
+	for _, m := range l.Mappings {
+		if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Target) {
+			return fmt.Errorf("path mapping %q -> %q must use absolute paths", m.Source, m.Target)
+		}
+	}
+	for _, path := range l.Exempt {
+		if !filepath.IsAbs(path) || filepath.Clean(path) == "/" {
+			return fmt.Errorf("exempt path %q must be an absolute path below /", path)
+		}
+	}
+	_, err := l.MappingRules()
+	return err
+}
+
+func (l *PathLayout) target() TransformTarget {
+	if l.Target == "" {
+		return TargetOpt
+	}
+	return l.Target
+}
+
+func (l *PathLayout) perPackage() bool {
+	return l.PerPackage || l.target() == TargetSrv
+}
+
+// Root returns the directory transformed paths are placed under
+func (l *PathLayout) Root() string {
+	root := targetRoots[l.target()]
+	if l.perPackage() {
+		root = filepath.Join(root, l.Package)
+	}
+	return root
+}
+
+// SystemDirMappings returns the mapping table for the layout. The shared /opt
+// layout mirrors system paths (/usr/bin -> /opt/usr/bin); every other layout
+// follows the FHS convention and folds /usr into the root (/usr/bin -> /usr/local/bin).
+func (l *PathLayout) SystemDirMappings() map[string]string {
+	root := l.Root()
+	mappings := make(map[string]string)
+	for _, sysDir := range []string{"/bin", "/etc", "/var", "/usr", "/lib", "/lib64", "/sbin", "/home", "/share", "/include"} {
+		mappings[sysDir] = root + sysDir
+	}
+	if l.target() != TargetOpt || l.perPackage() {
+		mappings["/usr"] = root
+	}
+	for _, m := range l.Mappings {
+		mappings[filepath.Clean(m.Source)] = filepath.Clean(m.Target)
+	}
+	return mappings
+}
+
+// MappingRules compiles the layout's pattern rules in order
+func (l *PathLayout) MappingRules() ([]*MappingRule, error) {
+	rules := make([]*MappingRule, 0, len(l.Rules))
+	for _, spec := range l.Rules {
+		rule, err := NewMappingRule(spec, l.Package)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// PathMapperOptions returns the PathMapper options described by the layout.
+// Rules are omitted if any fails to compile; call Validate first to report it.
+func (l *PathLayout) PathMapperOptions() []PathMapperOption {
+	rules, _ := l.MappingRules()
+	return []PathMapperOption{
+		WithBaseTransformDir(l.Root()),
+		WithSystemDirMappings(l.SystemDirMappings()),
+		WithMappingRules(rules...),
+		WithUntransformedPaths(l.Exempt),
+	}
+}
+
+// ValidatorOptions returns the Validator options described by the layout
+func (l *PathLayout) ValidatorOptions() []ValidatorOption {
+	return []ValidatorOption{WithTransformedDir(l.Root()), WithExemptPaths(l.Exempt)}
+}
@@ -0,0 +1,129 @@
+package security
+
+import (
+	"testing"
+)
+
+func TestPathLayout(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout PathLayout
+		root   string
+		paths  map[string]string
+	}{
+		{
+			name:   "Shared opt mirrors system paths",
+			layout: PathLayout{},
+			root:   "/opt",
+			paths:  map[string]string{"/usr/bin/tool": "/opt/usr/bin/tool", "/etc/tool.conf": "/opt/etc/tool.conf"},
+		},
+		{
+			name:   "Per-package opt",
+			layout: PathLayout{Target: TargetOpt, Package: "tool", PerPackage: true},
+			root:   "/opt/tool",
+			paths:  map[string]string{"/usr/bin/tool": "/opt/tool/bin/tool", "/etc/tool.conf": "/opt/tool/etc/tool.conf"},
+		},
+		{
+			name:   "usr-local folds /usr",
+			layout: PathLayout{Target: TargetUsrLocal},
+			root:   "/usr/local",
+			paths:  map[string]string{"/usr/bin/tool": "/usr/local/bin/tool", "/usr/share/man/tool.1": "/usr/local/share/man/tool.1", "/usr/local/bin/x": "/usr/local/bin/x"},
+		},
+		{
+			name:   "srv is always per package",
+			layout: PathLayout{Target: TargetSrv, Package: "tool"},
+			root:   "/srv/tool",
+			paths:  map[string]string{"/var/lib/tool/db": "/srv/tool/var/lib/tool/db"},
+		},
+		{
+			name:   "Extra mappings",
+			layout: PathLayout{Mappings: []PathMapping{{Source: "/usr/lib/python3", Target: "/opt/python"}}},
+			root:   "/opt",
+			paths:  map[string]string{"/usr/lib/python3/mod.py": "/opt/python/mod.py", "/usr/lib/libx.so": "/opt/usr/lib/libx.so"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.layout.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if root := tt.layout.Root(); root != tt.root {
+				t.Errorf("Root() = %q, want %q", root, tt.root)
+			}
+			pm := NewPathMapper(tt.layout.PathMapperOptions()...)
+			for path, want := range tt.paths {
+				got, _, err := pm.TransformPath(path)
+				if err != nil || got != want {
+					t.Errorf("TransformPath(%q) = %q, %v, want %q", path, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPathLayoutValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout PathLayout
+	}{
+		{"Unknown target", PathLayout{Target: "home"}},
+		{"srv without package", PathLayout{Target: TargetSrv}},
+		{"Per-package traversal", PathLayout{PerPackage: true, Package: ".."}},
+		{"Relative mapping", PathLayout{Mappings: []PathMapping{{Source: "usr", Target: "/opt/usr"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.layout.Validate(); err == nil {
+				t.Errorf("Expected Validate() to fail")
+			}
+		})
+	}
+
+	if _, err := ParseTransformTarget("USR-LOCAL"); err != nil {
+		t.Errorf("ParseTransformTarget() error = %v", err)
+	}
+	if _, err := ParseTransformTarget("home"); err == nil {
+		t.Errorf("Expected unknown target error")
+	}
+}
+
+func TestPathLayoutExemptions(t *testing.T) {
+	layout := PathLayout{Exempt: []string{"/etc/cron.d/myapp", "/usr/bin/myapp"}}
+	if err := layout.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	pm := NewPathMapper(layout.PathMapperOptions()...)
+	for path, want := range map[string]string{
+		"/etc/cron.d/myapp":  "/etc/cron.d/myapp",
+		"/etc/cron.d/other":  "/opt/etc/cron.d/other",
+		"/usr/bin/myapp":     "/usr/bin/myapp",
+		"/usr/bin/myapp-cli": "/opt/usr/bin/myapp-cli",
+	} {
+		got, needsSymlink, err := pm.TransformPath(path)
+		if err != nil || got != want {
+			t.Errorf("TransformPath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+		if got == path && needsSymlink {
+			t.Errorf("Expected no symlink for exempt path %q", path)
+		}
+	}
+
+	v := NewValidator(layout.ValidatorOptions()...)
+	for _, path := range []string{"/usr/bin/myapp", "/usr/bin", "/usr"} {
+		if err := v.ValidatePath(path); err != nil {
+			t.Errorf("ValidatePath(%q) error = %v", path, err)
+		}
+	}
+	if err := v.ValidatePath("/usr/bin/other"); err == nil {
+		t.Errorf("Expected non-exempt system path to be rejected")
+	}
+
+	for _, bad := range []string{"/", "etc/cron.d/myapp"} {
+		if err := (&PathLayout{Exempt: []string{bad}}).Validate(); err == nil {
+			t.Errorf("Expected exempt path %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Default payload limits. They only stop runaway payloads, such as a build
+// tree or node_modules installed by mistake; policies can lower or lift them.
+const (
+	DefaultMaxFileCount   = 250000
+	DefaultMaxFileSize    = 2 << 30 // 2 GiB
+	DefaultMaxPayloadSize = 4 << 30 // 4 GiB
+)
+
+// ErrPayloadLimit is wrapped by the errors of CheckPayloadFile
+var ErrPayloadLimit = errors.New("payload limit exceeded")
+
+// PayloadUsage counts the files and bytes of a payload as they are checked
+// against the policy limits. The zero value is ready to use; it is not safe
+// for concurrent use.
+type PayloadUsage struct {
+	Files int   // Files, symlinks and other non-directories
+	Size  int64 // Bytes of regular files
+
+	dirs map[string]*dirUsage // Files and bytes below each directory
+}
+
+// dirUsage counts the files and bytes below a directory
+type dirUsage struct {
+	files int
+	size  int64
+}
+
+// add counts a file at path for the payload and each directory above it
+func (u *PayloadUsage) add(file string, size int64) {
+	if u.dirs == nil {
+		u.dirs = make(map[string]*dirUsage)
+	}
+	u.Files++
+	u.Size += size
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		d := u.dirs[dir]
+		if d == nil {
+			d = &dirUsage{}
+			u.dirs[dir] = d
+		}
+		d.files++
+		d.size += size
+		if dir == "/" || dir == "." {
+			return
+		}
+	}
+}
+
+// heaviest returns the deepest directory holding more than half of the
+// payload's files, or of its bytes when bySize is set, which usually is the
+// tree that should not have been packaged
+func (u *PayloadUsage) heaviest(bySize bool) (string, dirUsage) {
+	weight := func(d *dirUsage) int64 {
+		if bySize {
+			return d.size
+		}
+		return int64(d.files)
+	}
+
+	total := weight(&dirUsage{files: u.Files, size: u.Size})
+	best, found := "", dirUsage{}
+	for dir, d := range u.dirs {
+		if dir == "/" || dir == "." || weight(d)*2 <= total {
+			continue
+		}
+		// Directories above the half mark form a chain; the deepest is the longest
+		if len(dir) > len(best) {
+			best, found = dir, *d
+		}
+	}
+	return best, found
+}
+
+// CheckPayloadFile counts a packaged file of size bytes at path and checks it
+// and the payload so far against the policy's MaxFileSize, MaxFileCount and
+// MaxPayloadSize. Directories are not counted.
+func (v *Validator) CheckPayloadFile(usage *PayloadUsage, path string, size int64) error {
+	policy := v.policy
+	if policy.MaxFileSize > 0 && size > policy.MaxFileSize {
+		return fmt.Errorf("%w: %s is %s, more than the %s allowed for a single file (paths.max_file_size)",
+			ErrPayloadLimit, path, FormatSize(size), FormatSize(policy.MaxFileSize))
+	}
+
+	usage.add(path, size)
+	if policy.MaxFileCount > 0 && usage.Files > policy.MaxFileCount {
+		hint := ""
+		if dir, d := usage.heaviest(false); dir != "" {
+			hint = fmt.Sprintf("; %d of them are below %s, exclude it if it should not be packaged", d.files, dir)
+		}
+		return fmt.Errorf("%w: the payload has more than %d files (paths.max_file_count)%s",
+			ErrPayloadLimit, policy.MaxFileCount, hint)
+	}
+	if policy.MaxPayloadSize > 0 && usage.Size > policy.MaxPayloadSize {
+		hint := ""
+		if dir, d := usage.heaviest(true); dir != "" {
+			hint = fmt.Sprintf("; %s of it is below %s, exclude it if it should not be packaged", FormatSize(d.size), dir)
+		}
+		return fmt.Errorf("%w: the payload is larger than %s (paths.max_payload_size)%s",
+			ErrPayloadLimit, FormatSize(policy.MaxPayloadSize), hint)
+	}
+	return nil
+}
+
+// sizeUnits are the binary size suffixes ParseSize accepts, largest first
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseSize parses a size in bytes with an optional binary suffix, such as
+// 512M, 2G or 1.5GiB. "unlimited" and -1 return -1.
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	if strings.EqualFold(value, "unlimited") || value == "-1" {
+		return -1, nil
+	}
+
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "I")
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, multiplier = strings.TrimSuffix(upper, unit.suffix), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatSize formats a size in bytes with a binary suffix, such as 1.5 GiB
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size >= unit.bytes {
+			value := math.Round(float64(size)/float64(unit.bytes)*10) / 10
+			return strconv.FormatFloat(value, 'f', -1, 64) + " " + unit.suffix + "iB"
+		}
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,118 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"10K", 10 << 10, false},
+		{"500M", 500 << 20, false},
+		{"2G", 2 << 30, false},
+		{"1.5GiB", 3 << 29, false},
+		{"1tb", 1 << 40, false},
+		{"unlimited", -1, false},
+		{"-1", -1, false},
+		{"", 0, true},
+		{"-5M", 0, true},
+		{"5 apples", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if got := FormatSize(3 << 29); got != "1.5 GiB" {
+		t.Errorf("FormatSize() = %q, want 1.5 GiB", got)
+	}
+	if got := FormatSize(100); got != "100 bytes" {
+		t.Errorf("FormatSize() = %q, want 100 bytes", got)
+	}
+}
+
+func TestCheckPayloadFile(t *testing.T) {
+	policy := DefaultSecurityPolicy()
+	policy.MaxFileCount = 10
+	policy.MaxFileSize = 1 << 20
+	policy.MaxPayloadSize = 4 << 20
+	v := NewValidator(WithPolicy(policy))
+
+	t.Run("File size", func(t *testing.T) {
+		err := v.CheckPayloadFile(&PayloadUsage{}, "/opt/app/big.iso", 2<<20)
+		if !errors.Is(err, ErrPayloadLimit) || !strings.Contains(err.Error(), "max_file_size") {
+			t.Errorf("CheckPayloadFile() error = %v, want file size limit", err)
+		}
+	})
+
+	t.Run("File count names the heaviest directory", func(t *testing.T) {
+		usage := &PayloadUsage{}
+		var err error
+		v.CheckPayloadFile(usage, "/opt/app/bin/app", 100)
+		for i := 0; i < 10 && err == nil; i++ {
+			err = v.CheckPayloadFile(usage, fmt.Sprintf("/opt/app/node_modules/left-pad/%d.js", i), 100)
+		}
+		if !errors.Is(err, ErrPayloadLimit) || !strings.Contains(err.Error(), "below /opt/app/node_modules/left-pad") {
+			t.Errorf("CheckPayloadFile() error = %v, want file count limit naming node_modules", err)
+		}
+	})
+
+	t.Run("Payload size", func(t *testing.T) {
+		usage := &PayloadUsage{}
+		var err error
+		for i := 0; i < 5 && err == nil; i++ {
+			err = v.CheckPayloadFile(usage, fmt.Sprintf("/opt/app/data/%d.bin", i), 1<<20)
+		}
+		if !errors.Is(err, ErrPayloadLimit) || !strings.Contains(err.Error(), "max_payload_size") {
+			t.Errorf("CheckPayloadFile() error = %v, want payload size limit", err)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		unlimited := NewValidator(WithPolicy(&SecurityPolicy{}))
+		usage := &PayloadUsage{}
+		for i := 0; i < 20; i++ {
+			if err := unlimited.CheckPayloadFile(usage, fmt.Sprintf("/opt/app/%d", i), 8<<30); err != nil {
+				t.Fatalf("CheckPayloadFile() error = %v", err)
+			}
+		}
+		if usage.Files != 20 || usage.Size != 160<<30 {
+			t.Errorf("Unexpected usage: %d files, %d bytes", usage.Files, usage.Size)
+		}
+	})
+}
+
+func TestPolicyFilePayloadLimits(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "limits-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := writePolicy(t, tmpDir, "policy.yaml", `
+paths:
+  max_file_count: 1000
+  max_file_size: unlimited
+  max_payload_size: 500M
+`)
+	policy, err := LoadPolicyFile(path)
+	if err != nil {
+		t.Fatalf("LoadPolicyFile() error = %v", err)
+	}
+	sp := policy.SecurityPolicy()
+	if sp.MaxFileCount != 1000 || sp.MaxFileSize != 0 || sp.MaxPayloadSize != 500<<20 {
+		t.Errorf("Unexpected limits: count %d, file %d, payload %d", sp.MaxFileCount, sp.MaxFileSize, sp.MaxPayloadSize)
+	}
+}
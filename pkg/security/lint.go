@@ -0,0 +1,249 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// LintMode selects how maintainer scripts are linted for shell quality issues
+type LintMode int
+
+const (
+	// LintOff skips linting
+	LintOff LintMode = iota
+	// LintAuto uses shellcheck when it is installed and the built-in checks otherwise
+	LintAuto
+	// LintShellcheck requires shellcheck
+	LintShellcheck
+	// LintBuiltin uses the built-in checks, a small subset of shellcheck's
+	LintBuiltin
+)
+
+// ParseLintMode parses off, auto, shellcheck or builtin
+func ParseLintMode(mode string) (LintMode, error) {
+	switch strings.ToLower(mode) {
+	case "off", "":
+		return LintOff, nil
+	case "auto":
+		return LintAuto, nil
+	case "shellcheck":
+		return LintShellcheck, nil
+	case "builtin":
+		return LintBuiltin, nil
+	default:
+		return 0, fmt.Errorf("unknown lint mode: %s (must be off, auto, shellcheck or builtin)", mode)
+	}
+}
+
+// WithLint lints scripts for shell quality issues. Lint findings are reported
+// under RuleLint with the shellcheck code as detail; they add no risk, and
+// shellcheck's info and style comments are notes that do not count as warnings.
+func WithLint(mode LintMode) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.lintMode = mode
+	}
+}
+
+// shellcheckPath returns the shellcheck binary, replaced in tests
+var shellcheckPath = func() (string, error) {
+	return exec.LookPath("shellcheck")
+}
+
+// runShellcheck runs shellcheck on a script and returns its json1 output,
+// replaced in tests
+var runShellcheck = func(path, shell, content string) ([]byte, error) {
+	cmd := exec.Command(path, "--format=json1", "--shell="+shell, "-")
+	cmd.Stdin = strings.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	// shellcheck exits with status 1 when it reports comments
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("shellcheck failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// shellcheckComment is a comment in shellcheck's json1 output
+type shellcheckComment struct {
+	Line    int    `json:"line"`
+	Level   string `json:"level"` // error, warning, info or style
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// lintShell returns the shell dialect of a script: bash, sh, or an empty
+// string when the interpreter is not a POSIX shell
+func lintShell(content string) string {
+	if !strings.HasPrefix(content, "#!") {
+		return "sh"
+	}
+	shebang := strings.SplitN(content, "\n", 2)[0]
+	switch {
+	case strings.Contains(shebang, "bash"):
+		return "bash"
+	case posixShellRe.MatchString(shebang):
+		return "sh"
+	default:
+		return ""
+	}
+}
+
+// lint reports shell quality issues according to the validator's lint mode
+func (sv *ScriptValidator) lint(content string, lines []scriptLine, result *ScriptValidationResult) error {
+	if sv.lintMode == LintOff {
+		return nil
+	}
+	shell := lintShell(content)
+	if shell == "" {
+		return nil
+	}
+
+	path, err := shellcheckPath()
+	switch {
+	case sv.lintMode == LintShellcheck && err != nil:
+		return fmt.Errorf("shellcheck is required for linting: %w", err)
+	case sv.lintMode == LintBuiltin || err != nil:
+		sv.log("Linting with the built-in checks")
+		lintBuiltin(shell, content, lines, result)
+		return nil
+	}
+
+	out, err := runShellcheck(path, shell, content)
+	if err != nil {
+		return err
+	}
+	var report struct {
+		Comments []shellcheckComment `json:"comments"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return fmt.Errorf("failed to parse shellcheck output: %w", err)
+	}
+	for _, c := range report.Comments {
+		severity := SeverityNote
+		switch c.Level {
+		case "error":
+			severity = SeverityError
+		case "warning":
+			severity = SeverityWarning
+		}
+		result.addLintFinding(c.Line, fmt.Sprintf("SC%d", c.Code), c.Message, severity)
+	}
+	return nil
+}
+
+var (
+	// posixShellRe matches the interpreter line of a POSIX shell script
+	posixShellRe = regexp.MustCompile(`\b(da|k)?sh\b`)
+
+	// rmVarRootRe matches rm of a variable followed by a slash, which removes
+	// from / when the variable is empty
+	rmVarRootRe = regexp.MustCompile(`\brm\s+(-[A-Za-z]+\s+)*["']?\$\{?[A-Za-z_][A-Za-z0-9_]*\}?["']?/`)
+
+	// fileCommandRe matches commands that take file names
+	fileCommandRe = regexp.MustCompile(`(^|[;&|(]\s*|\s)(rm|rmdir|mv|cp|chmod|chown|chgrp|ln|mkdir|touch)\s`)
+
+	// cdRe matches a cd command
+	cdRe = regexp.MustCompile(`(^|[;&|(]\s*)cd\s+\S`)
+
+	// setERe matches set -e, which makes a failed cd abort the script
+	setERe = regexp.MustCompile(`(?m)^\s*set\s+(-[A-Za-z]*e|-o\s+errexit)|^#!.*\s-[A-Za-z]*e\b`)
+)
+
+// lintBuiltin runs the built-in checks, which mirror a few shellcheck
+// warnings that matter for maintainer scripts
+func lintBuiltin(shell, content string, lines []scriptLine, result *ScriptValidationResult) {
+	errexit := setERe.MatchString(content)
+
+	for _, l := range lines {
+		line := strings.TrimSpace(l.text)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if rmVarRootRe.MatchString(line) {
+			result.addLintFinding(l.number, "SC2115", `Use "${var:?}" to ensure this never expands to /`, SeverityWarning)
+		} else if fileCommandRe.MatchString(line) && unquotedExpansion(line) {
+			result.addLintFinding(l.number, "SC2086", "Double quote to prevent globbing and word splitting", SeverityNote)
+		}
+		if !errexit && cdRe.MatchString(line) && !strings.Contains(line, "||") && !strings.Contains(line, "&&") {
+			result.addLintFinding(l.number, "SC2164", "Use 'cd ... || exit' in case cd fails", SeverityWarning)
+		}
+		if strings.Contains(outsideSingleQuotes(line), "`") {
+			result.addLintFinding(l.number, "SC2006", "Use $(...) notation instead of legacy backticks", SeverityNote)
+		}
+		if shell == "sh" && strings.Contains(outsideSingleQuotes(line), "[[") {
+			result.addLintFinding(l.number, "SC3010", "In POSIX sh, [[ ]] is undefined", SeverityWarning)
+		}
+	}
+}
+
+// outsideSingleQuotes returns line without its single-quoted parts
+func outsideSingleQuotes(line string) string {
+	var b strings.Builder
+	quoted := false
+	for _, r := range line {
+		if r == '\'' {
+			quoted = !quoted
+			continue
+		}
+		if !quoted {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// unquotedExpansion reports whether line expands a variable outside quotes
+func unquotedExpansion(line string) bool {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '$' && i+1 < len(line):
+			next := line[i+1]
+			if next == '{' || next == '_' || next >= 'A' && next <= 'Z' || next >= 'a' && next <= 'z' || next >= '0' && next <= '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addLintFinding records a lint finding at the severity it was reported with
+func (r *ScriptValidationResult) addLintFinding(line int, code, message string, severity Severity) {
+	finding := Finding{
+		RuleID:   RuleLint,
+		Severity: severity,
+		Line:     line,
+		Message:  code + ": " + message,
+		Detail:   code,
+	}
+	if r.waive(finding) {
+		return
+	}
+	r.Findings = append(r.Findings, finding)
+
+	message = fmt.Sprintf("Line %d: %s", line, finding.Message)
+	switch severity {
+	case SeverityError:
+		r.Errors = append(r.Errors, message)
+	case SeverityWarning:
+		r.Warnings = append(r.Warnings, message)
+	}
+}
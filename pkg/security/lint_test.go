@@ -0,0 +1,141 @@
+package security
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// lintCodes returns the codes of the lint findings in result by line
+func lintCodes(result *ScriptValidationResult) map[int][]string {
+	codes := make(map[int][]string)
+	for _, f := range result.Findings {
+		if f.RuleID == RuleLint {
+			codes[f.Line] = append(codes[f.Line], f.Detail)
+		}
+	}
+	return codes
+}
+
+func TestLintBuiltin(t *testing.T) {
+	sv := NewScriptValidator(WithLint(LintBuiltin))
+
+	script := strings.Join([]string{
+		"#!/bin/sh",
+		"rm -rf \"$DATA_DIR/\"",
+		"cp $SRC /opt/app/",
+		"cd /opt/app",
+		"VERSION=`cat /opt/app/VERSION`",
+		"if [[ -n \"$VERSION\" ]]; then echo ok; fi",
+		"echo '`not code` [[ either'",
+		"cat > /opt/app/env <<EOF",
+		"cd $HOME",
+		"EOF",
+	}, "\n") + "\n"
+
+	result, err := sv.ValidateScript("postinst", script)
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+	codes := lintCodes(result)
+	want := map[int]string{2: "SC2115", 3: "SC2086", 4: "SC2164", 5: "SC2006", 6: "SC3010"}
+	for line, code := range want {
+		if len(codes[line]) != 1 || codes[line][0] != code {
+			t.Errorf("Line %d: got %v, want %s", line, codes[line], code)
+		}
+	}
+	if len(codes) != len(want) {
+		t.Errorf("Unexpected lint findings: %v", codes)
+	}
+
+	t.Run("set -e and bash", func(t *testing.T) {
+		result, err := sv.ValidateScript("postinst", "#!/bin/bash\nset -e\ncd /opt/app\n[[ -d x ]] && echo x\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if codes := lintCodes(result); len(codes) != 0 {
+			t.Errorf("Unexpected lint findings: %v", codes)
+		}
+	})
+
+	t.Run("Notes are not warnings", func(t *testing.T) {
+		result, err := sv.ValidateScript("postinst", "#!/bin/sh\nset -e\nmkdir -p $DIR\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if len(lintCodes(result)) != 1 || len(result.Warnings) != 0 {
+			t.Errorf("Expected one note and no warnings, got %+v and %v", result.Findings, result.Warnings)
+		}
+	})
+}
+
+func TestLintShellcheck(t *testing.T) {
+	oldPath, oldRun := shellcheckPath, runShellcheck
+	defer func() { shellcheckPath, runShellcheck = oldPath, oldRun }()
+
+	var gotShell string
+	shellcheckPath = func() (string, error) { return "/usr/bin/shellcheck", nil }
+	runShellcheck = func(path, shell, content string) ([]byte, error) {
+		gotShell = shell
+		return []byte(`{"comments":[
+			{"file":"-","line":2,"level":"error","code":1009,"message":"The mentioned syntax error was in this if expression."},
+			{"file":"-","line":3,"level":"warning","code":2164,"message":"Use 'cd ... || exit' or 'cd ... || return' in case cd fails."},
+			{"file":"-","line":4,"level":"style","code":2006,"message":"Use $(...) notation instead of legacy backticks."}]}`), nil
+	}
+
+	result, err := NewScriptValidator(WithLint(LintAuto)).ValidateScript("postinst", "#!/bin/bash\nif\ncd x\n`y`\n")
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+	if gotShell != "bash" {
+		t.Errorf("shellcheck run for %q, want bash", gotShell)
+	}
+	severities := make(map[string]Severity)
+	for _, f := range result.Findings {
+		if f.RuleID == RuleLint {
+			severities[f.Detail] = f.Severity
+		}
+	}
+	if severities["SC1009"] != SeverityError || severities["SC2164"] != SeverityWarning || severities["SC2006"] != SeverityNote {
+		t.Errorf("Unexpected severities: %v", severities)
+	}
+	if result.Valid {
+		t.Errorf("Expected a shellcheck error to reject the script")
+	}
+
+	t.Run("Waived", func(t *testing.T) {
+		sv := NewScriptValidator(WithLint(LintAuto), WithScriptWaivers(ScriptWaiver{Rule: "lint", Detail: "SC1009", Justification: "false positive"}))
+		result, err := sv.ValidateScript("postinst", "#!/bin/bash\nif\ncd x\n`y`\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if len(result.Waived) != 1 || len(result.Errors) != 0 {
+			t.Errorf("Expected SC1009 to be waived, got %+v", result.Findings)
+		}
+	})
+
+	t.Run("Missing shellcheck", func(t *testing.T) {
+		shellcheckPath = func() (string, error) { return "", errors.New("not found") }
+		if _, err := NewScriptValidator(WithLint(LintShellcheck)).ValidateScript("postinst", "#!/bin/sh\ntrue\n"); err == nil {
+			t.Errorf("Expected an error without shellcheck")
+		}
+		result, err := NewScriptValidator(WithLint(LintAuto)).ValidateScript("postinst", "#!/bin/sh\ncd /opt\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if codes := lintCodes(result); len(codes[2]) != 1 || codes[2][0] != "SC2164" {
+			t.Errorf("Expected the built-in checks to run, got %v", codes)
+		}
+	})
+}
+
+func TestParseLintMode(t *testing.T) {
+	for name, want := range map[string]LintMode{"": LintOff, "off": LintOff, "Auto": LintAuto, "shellcheck": LintShellcheck, "builtin": LintBuiltin} {
+		if got, err := ParseLintMode(name); err != nil || got != want {
+			t.Errorf("ParseLintMode(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLintMode("strict"); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,113 @@
+package security
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MappingRuleSpec is the configuration form of a MappingRule. Exactly one of
+// Glob or Regex must be set. Target may reference capture groups as $1 or ${1}
+// and the package name as <pkg>.
+//
+// Example:
+//
+//	mapping_rules:
+//	  - glob: /usr/lib/python3/*
+//	    target: /opt/<pkg>/pythonlib/$1
+//	  - regex: ^/usr/share/doc/([^/]+)
+//	    target: /opt/<pkg>/doc/$1
+type MappingRuleSpec struct {
+	Glob   string `mapstructure:"glob" json:"glob,omitempty"`
+	Regex  string `mapstructure:"regex" json:"regex,omitempty"`
+	Target string `mapstructure:"target" json:"target"`
+}
+
+// MappingRule rewrites paths matching a glob or regular expression. A rule
+// that matches a directory also applies to everything below it.
+type MappingRule struct {
+	pattern string
+	re      *regexp.Regexp
+	target  string
+}
+
+// NewMappingRule compiles a rule from its specification, replacing <pkg> in
+// the target with pkgName
+func NewMappingRule(spec MappingRuleSpec, pkgName string) (*MappingRule, error) {
+	if (spec.Glob == "") == (spec.Regex == "") {
+		return nil, fmt.Errorf("mapping rule must set exactly one of glob or regex")
+	}
+	if spec.Target == "" {
+		return nil, fmt.Errorf("mapping rule %s has no target", spec.Glob+spec.Regex)
+	}
+	if strings.Contains(spec.Target, "<pkg>") && pkgName == "" {
+		return nil, fmt.Errorf("mapping rule target %s requires a package name", spec.Target)
+	}
+	target := strings.ReplaceAll(spec.Target, "<pkg>", pkgName)
+	if !filepath.IsAbs(target) {
+		return nil, fmt.Errorf("mapping rule target %s must be an absolute path", spec.Target)
+	}
+
+	expr := spec.Regex
+	pattern := spec.Regex
+	if spec.Glob != "" {
+		if !filepath.IsAbs(spec.Glob) {
+			return nil, fmt.Errorf("mapping rule glob %s must be an absolute path", spec.Glob)
+		}
+		expr = globToRegexp(filepath.Clean(spec.Glob))
+		pattern = spec.Glob
+	} else if !strings.HasPrefix(expr, "^") {
+		expr = "^" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping rule %s: %w", pattern, err)
+	}
+	return &MappingRule{pattern: pattern, re: re, target: target}, nil
+}
+
+// globToRegexp converts a glob to an anchored regular expression in which every
+// wildcard is a capture group: * and ? stay within one path segment, ** crosses them
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString("(.*)")
+				i++
+			} else {
+				b.WriteString("([^/]*)")
+			}
+		case '?':
+			b.WriteString("([^/])")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Pattern returns the glob or regular expression the rule was created from
+func (r *MappingRule) Pattern() string {
+	return r.pattern
+}
+
+// Apply rewrites path if the rule matches it or one of its parent directories
+func (r *MappingRule) Apply(path string) (string, bool) {
+	loc := r.re.FindStringSubmatchIndex(path)
+	if loc == nil || loc[0] != 0 {
+		return "", false
+	}
+	rest := path[loc[1]:]
+	if rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(path[:loc[1]], "/") {
+		// The match ended in the middle of a path segment
+		return "", false
+	}
+
+	expanded := r.re.ExpandString(nil, r.target, path, loc)
+	return filepath.Clean(string(expanded) + "/" + rest), true
+}
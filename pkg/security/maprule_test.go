@@ -0,0 +1,92 @@
+package security
+
+import (
+	"testing"
+)
+
+func TestMappingRule(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   MappingRuleSpec
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{"Glob capture", MappingRuleSpec{Glob: "/usr/lib/python3/*", Target: "/opt/<pkg>/pythonlib/$1"},
+			"/usr/lib/python3/requests", "/opt/tool/pythonlib/requests", true},
+		{"Glob applies below match", MappingRuleSpec{Glob: "/usr/lib/python3/*", Target: "/opt/<pkg>/pythonlib/$1"},
+			"/usr/lib/python3/requests/api.py", "/opt/tool/pythonlib/requests/api.py", true},
+		{"Glob stops at segment", MappingRuleSpec{Glob: "/usr/lib/python3/*", Target: "/opt/<pkg>/pythonlib/$1"},
+			"/usr/lib/python3.11/site.py", "", false},
+		{"Double star", MappingRuleSpec{Glob: "/usr/share/**/*.desktop", Target: "/opt/<pkg>/desktop/$2.desktop"},
+			"/usr/share/applications/kde/tool.desktop", "/opt/tool/desktop/tool.desktop", true},
+		{"Question mark", MappingRuleSpec{Glob: "/usr/share/man/man?", Target: "/opt/<pkg>/man/$1"},
+			"/usr/share/man/man1/tool.1", "/opt/tool/man/1/tool.1", true},
+		{"Regex", MappingRuleSpec{Regex: `/usr/share/doc/([^/]+)`, Target: "/opt/<pkg>/doc/${1}-docs"},
+			"/usr/share/doc/tool/README", "/opt/tool/doc/tool-docs/README", true},
+		{"Regex must match from the start", MappingRuleSpec{Regex: `/share/doc`, Target: "/opt/doc"},
+			"/usr/share/doc/tool", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewMappingRule(tt.spec, "tool")
+			if err != nil {
+				t.Fatalf("NewMappingRule() error = %v", err)
+			}
+			got, ok := rule.Apply(tt.path)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Apply(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMappingRuleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec MappingRuleSpec
+		pkg  string
+	}{
+		{"Neither glob nor regex", MappingRuleSpec{Target: "/opt/x"}, "tool"},
+		{"Both glob and regex", MappingRuleSpec{Glob: "/usr/*", Regex: "^/usr", Target: "/opt/x"}, "tool"},
+		{"Missing target", MappingRuleSpec{Glob: "/usr/*"}, "tool"},
+		{"Relative target", MappingRuleSpec{Glob: "/usr/*", Target: "opt/$1"}, "tool"},
+		{"Relative glob", MappingRuleSpec{Glob: "usr/*", Target: "/opt/$1"}, "tool"},
+		{"Bad regex", MappingRuleSpec{Regex: "(", Target: "/opt/x"}, "tool"},
+		{"Package placeholder without package", MappingRuleSpec{Glob: "/usr/*", Target: "/opt/<pkg>/$1"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMappingRule(tt.spec, tt.pkg); err == nil {
+				t.Errorf("Expected NewMappingRule() to fail")
+			}
+		})
+	}
+}
+
+func TestMappingRulePrecedence(t *testing.T) {
+	layout := PathLayout{
+		Package: "tool",
+		Rules: []MappingRuleSpec{
+			{Glob: "/usr/lib/python3/dist-packages", Target: "/opt/<pkg>/dist"},
+			{Glob: "/usr/lib/python3/*", Target: "/opt/<pkg>/pythonlib/$1"},
+		},
+	}
+	if err := layout.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	pm := NewPathMapper(layout.PathMapperOptions()...)
+
+	for path, want := range map[string]string{
+		"/usr/lib/python3/dist-packages/x.py": "/opt/tool/dist/x.py",
+		"/usr/lib/python3/json/x.py":          "/opt/tool/pythonlib/json/x.py",
+		"/usr/lib/libx.so":                    "/opt/usr/lib/libx.so",
+	} {
+		got, _, err := pm.TransformPath(path)
+		if err != nil || got != want {
+			t.Errorf("TransformPath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
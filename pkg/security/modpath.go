@@ -0,0 +1,43 @@
+package security
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ModulePath is an interpreter module directory found in a payload path,
+// such as a Python site-packages directory. Interpreters only search their
+// built-in directories, so modules relocated out of one cannot be imported
+// without further configuration.
+type ModulePath struct {
+	Language string // python, perl or ruby
+	Root     string // The module directory, such as /usr/lib/python3/dist-packages
+	EnvVar   string // Environment variable adding a directory to the search path
+}
+
+// modulePathRule recognises the module directories of one language
+type modulePathRule struct {
+	language string
+	envVar   string
+	re       *regexp.Regexp
+}
+
+// modulePathRules match module directories at the start of a path; the
+// first rule that matches wins
+var modulePathRules = []modulePathRule{
+	{"python", "PYTHONPATH", regexp.MustCompile(`^/usr(/local)?/lib(64)?/python[0-9.]*/(site|dist)-packages(/|$)`)},
+	{"perl", "PERL5LIB", regexp.MustCompile(`^/usr(/local)?/(share|lib(64)?)(/[a-z0-9_]+-linux-[a-z0-9_]+)?/perl5?(/[0-9.]+)?(/(vendor|site)_perl)?(/|$)`)},
+	{"ruby", "GEM_PATH", regexp.MustCompile(`^(/usr(/local)?/lib(64)?/ruby/gems/[0-9.]+|/var/lib/gems/[0-9.]+|/usr/share/rubygems-integration/[a-z0-9.]+|/usr/share/gems)(/|$)`)},
+	{"ruby", "RUBYLIB", regexp.MustCompile(`^/usr(/local)?/lib(64)?/ruby/(vendor_ruby|site_ruby)(/[0-9.]+)?(/|$)`)},
+}
+
+// DetectModulePath returns the interpreter module directory path lies in,
+// or is
+func DetectModulePath(path string) (ModulePath, bool) {
+	for _, rule := range modulePathRules {
+		if match := rule.re.FindString(path); match != "" {
+			return ModulePath{Language: rule.language, Root: strings.TrimSuffix(match, "/"), EnvVar: rule.envVar}, true
+		}
+	}
+	return ModulePath{}, false
+}
@@ -0,0 +1,30 @@
+package security
+
+import "testing"
+
+func TestDetectModulePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		language string
+		root     string
+	}{
+		{"/usr/lib/python3/dist-packages/app/__init__.py", "python", "/usr/lib/python3/dist-packages"},
+		{"/usr/local/lib/python3.11/site-packages/app.py", "python", "/usr/local/lib/python3.11/site-packages"},
+		{"/usr/lib64/python3.12/site-packages", "python", "/usr/lib64/python3.12/site-packages"},
+		{"/usr/share/perl5/App/Module.pm", "perl", "/usr/share/perl5"},
+		{"/usr/lib/perl5/vendor_perl/App.pm", "perl", "/usr/lib/perl5/vendor_perl"},
+		{"/usr/lib/x86_64-linux-gnu/perl5/5.36/App.pm", "perl", "/usr/lib/x86_64-linux-gnu/perl5/5.36"},
+		{"/usr/lib/ruby/gems/3.1.0/gems/app-1.0/lib/app.rb", "ruby", "/usr/lib/ruby/gems/3.1.0"},
+		{"/var/lib/gems/3.1.0/specifications/app.gemspec", "ruby", "/var/lib/gems/3.1.0"},
+		{"/usr/lib/ruby/vendor_ruby/app.rb", "ruby", "/usr/lib/ruby/vendor_ruby"},
+		{"/usr/lib/python3-app/module.py", "", ""},
+		{"/usr/share/perl-tools/script", "", ""},
+		{"/usr/bin/python3", "", ""},
+	}
+	for _, tt := range tests {
+		module, ok := DetectModulePath(tt.path)
+		if ok != (tt.language != "") || module.Language != tt.language || module.Root != tt.root {
+			t.Errorf("DetectModulePath(%s) = %+v, %v, want %s in %s", tt.path, module, ok, tt.language, tt.root)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package security
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// Decoders whose output is piped straight into a shell
+	decodeToShellRe = regexp.MustCompile(`\b(base64\s+(-d|-D|--decode)|base32\s+(-d|--decode)|xxd\s+(-r|-p\s+-r|-r\s+-p)|openssl\s+(enc\s+)?(-d\s+)?-?base64\s+-d|(printf|echo\s+-e)\s+["']?[^|]*\\x[0-9a-fA-F]{2})[^|]*\|\s*(sudo\s+)?(/usr)?(/bin/)?(ba|da|z|k)?sh\b`)
+
+	// ANSI-C quoted strings containing hex or octal escapes, e.g. $'\x72\x6d'
+	ansiEscapeRe = regexp.MustCompile(`\$'[^']*\\(x[0-9a-fA-F]{1,2}|[0-7]{3})[^']*'`)
+
+	// eval of a command substitution that builds the code at runtime
+	evalBuiltRe = regexp.MustCompile("\\beval\\s+[\"']?(\\$\\(|`)\\s*(printf|echo|base64|base32|xxd|rev|tr|openssl|gunzip|zcat)\\b")
+
+	// Braced variable references, normalized to the plain $NAME form
+	bracedVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+	// Long runs of base64 alphabet characters embedded in the script
+	base64BlobRe = regexp.MustCompile(`[A-Za-z0-9+/]{80,}={0,2}`)
+
+	// Downloads written to a file: curl -o FILE, wget -O FILE or a redirect
+	downloadOutputRe = regexp.MustCompile(`\b(curl|wget)\b.*?(\s-o\s*|\s--output[=\s]\s*|\s-O\s*|\s--output-document[=\s]\s*|\s>\s*)("[^"]+"|'[^']+'|[^\s;&|]+)`)
+
+	// Assignments of a temporary file name, e.g. TMP=$(mktemp)
+	mktempAssignRe = regexp.MustCompile("\\b([A-Za-z_][A-Za-z0-9_]*)=[\"']?(\\$\\(|`)\\s*mktemp\\b")
+)
+
+// normalizeShellWord strips quotes and braces so "${TMP}" and $TMP compare equal
+func normalizeShellWord(word string) string {
+	word = strings.Trim(word, `"'`)
+	return bracedVarRe.ReplaceAllString(word, `$$$1`)
+}
+
+// isTempPath reports whether a path is in a world-writable temporary location
+// or held in a variable assigned from mktemp
+func isTempPath(path string, tempVars map[string]bool) bool {
+	for _, prefix := range []string{"/tmp/", "/var/tmp/", "/dev/shm/", "$TMPDIR"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	if strings.HasPrefix(path, "$") {
+		name := strings.TrimPrefix(path, "$")
+		if idx := strings.IndexAny(name, "/."); idx >= 0 {
+			name = name[:idx]
+		}
+		return tempVars[name]
+	}
+	return false
+}
+
+// executesFile reports whether line runs the given file directly or through a shell
+func executesFile(line, file string) bool {
+	quoted := `["']?` + regexp.QuoteMeta(file) + `["']?`
+	re := regexp.MustCompile(`(^|[;&|]\s*|\b(ba|da|z|k)?sh\s+(-[a-z]+\s+)*|\bsource\s+|(^|\s)\.\s+|\bexec\s+)` + quoted + `(\s|;|$)`)
+	return re.MatchString(strings.TrimSpace(line))
+}
+
+// obfuscationState tracks information that spans lines of a script
+type obfuscationState struct {
+	tempVars  map[string]bool
+	downloads map[string]int // Downloaded file -> line number
+}
+
+func newObfuscationState() *obfuscationState {
+	return &obfuscationState{
+		tempVars:  make(map[string]bool),
+		downloads: make(map[string]int),
+	}
+}
+
+// checkObfuscation looks for encoded payloads and code that is assembled or
+// downloaded at runtime, which hides its behaviour from the plain-text checks
+func (sv *ScriptValidator) checkObfuscation(line string, lineNumber int, state *obfuscationState, result *ScriptValidationResult) {
+	if match := decodeToShellRe.FindString(line); match != "" {
+		result.addFinding(RuleEncodedPayload, lineNumber, match, "Encoded payload is decoded and piped to a shell", 6)
+		sv.log("Line %d: Encoded payload is decoded and piped to a shell", lineNumber)
+	} else if match := base64BlobRe.FindString(line); match != "" {
+		result.addFinding(RuleEncodedBlob, lineNumber, match[:16]+"...", "Script embeds a long base64-encoded blob", 2)
+		sv.log("Line %d: Script embeds a long base64-encoded blob", lineNumber)
+	}
+
+	if match := ansiEscapeRe.FindString(line); match != "" {
+		result.addFinding(RuleEscapedString, lineNumber, match, "ANSI-C quoted string uses hex or octal escapes", 3)
+		sv.log("Line %d: ANSI-C quoted string uses hex or octal escapes", lineNumber)
+	}
+
+	if match := evalBuiltRe.FindString(line); match != "" {
+		result.addFinding(RuleEvalBuiltCode, lineNumber, match, "eval executes code built at runtime", 5)
+		sv.log("Line %d: eval executes code built at runtime", lineNumber)
+	}
+
+	for _, m := range mktempAssignRe.FindAllStringSubmatch(line, -1) {
+		state.tempVars[m[1]] = true
+	}
+
+	// Text in which each tracked download might be executed; for a download on
+	// this line only the text after it counts, e.g. "curl -o f && sh f"
+	pending := make(map[string]string)
+	for file := range state.downloads {
+		pending[file] = line
+	}
+	for _, loc := range downloadOutputRe.FindAllStringSubmatchIndex(line, -1) {
+		file := normalizeShellWord(line[loc[6]:loc[7]])
+		if file == "-" || file == "/dev/null" {
+			continue
+		}
+		state.downloads[file] = lineNumber
+		pending[file] = line[loc[1]:]
+	}
+
+	files := make([]string, 0, len(pending))
+	for file := range pending {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if !executesFile(normalizeShellWord(pending[file]), file) {
+			continue
+		}
+		message := "Downloaded file is executed: " + file
+		if isTempPath(file, state.tempVars) {
+			message = "Downloaded temporary file is executed: " + file
+		}
+		result.addFinding(RuleDownloadExecute, lineNumber, file, message, 6)
+		sv.log("Line %d: %s (downloaded on line %d)", lineNumber, message, state.downloads[file])
+		delete(state.downloads, file)
+	}
+}
@@ -2,8 +2,13 @@ package security
 
 import (
 	"fmt"
+	"log/slog"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
 )
 
 // PathMapperOption is a function type that modifies a PathMapper's configuration.
@@ -27,15 +32,59 @@ func WithCustomMapping(source, target string) PathMapperOption {
 	}
 }
 
+// WithSystemDirMappings replaces the system directory mappings.
+func WithSystemDirMappings(mappings map[string]string) PathMapperOption {
+	return func(pm *PathMapper) {
+		pm.systemDirs = make(map[string]string, len(mappings))
+		for source, target := range mappings {
+			pm.systemDirs[source] = target
+		}
+	}
+}
+
+// WithMappingRules appends pattern rules. Rules are tried in order before the
+// system directory mappings and the first match wins.
+func WithMappingRules(rules ...*MappingRule) PathMapperOption {
+	return func(pm *PathMapper) {
+		pm.rules = append(pm.rules, rules...)
+	}
+}
+
+// WithUntransformedPaths keeps the given paths and everything below them at their
+// real location instead of transforming them.
+func WithUntransformedPaths(paths []string) PathMapperOption {
+	return func(pm *PathMapper) {
+		for _, path := range paths {
+			pm.exemptPaths = append(pm.exemptPaths, filepath.Clean(path))
+		}
+	}
+}
+
 // WithSymlinkDir adds a directory to the list of directories where symlinks are allowed.
 func WithSymlinkDir(dir string) PathMapperOption {
 	return func(pm *PathMapper) {
-		if dir != "" {
-			pm.symlinkDirs = append(pm.symlinkDirs, dir)
+		pm.symlinkDirs = appendSymlinkDirs(pm.symlinkDirs, dir)
+	}
+}
+
+// WithSymlinkDirs replaces the list of directories where symlinks are allowed.
+// An empty list keeps the defaults.
+func WithSymlinkDirs(dirs []string) PathMapperOption {
+	return func(pm *PathMapper) {
+		if len(dirs) > 0 {
+			pm.symlinkDirs = appendSymlinkDirs(nil, dirs...)
 		}
 	}
 }
 
+// WithTransformDisabled leaves system paths untransformed, so files are
+// installed at their original locations and no symlinks are required.
+func WithTransformDisabled(disabled bool) PathMapperOption {
+	return func(pm *PathMapper) {
+		pm.disabled = disabled
+	}
+}
+
 // WithVerboseLogging enables verbose logging for path operations.
 func WithVerboseLogging(verbose bool) PathMapperOption {
 	return func(pm *PathMapper) {
@@ -45,21 +94,77 @@ func WithVerboseLogging(verbose bool) PathMapperOption {
 
 // PathMapper handles secure transformation of installation paths by redirecting
 // operations targeting sensitive system directories to safer alternatives.
+// It is safe for concurrent use; Snapshot returns a copy that later changes
+// to the mapper do not affect.
 type PathMapper struct {
+	// Guards the fields below once the mapper is created
+	mu sync.RWMutex
+
 	// Map of system directories to their secure alternatives
 	systemDirs map[string]string
 
+	// systemDirs in resolution order, rebuilt whenever it changes
+	ordered []dirMapping
+
+	// Ordered pattern rules that take precedence over systemDirs
+	rules []*MappingRule
+
+	// Paths shipped at their real location
+	exemptPaths []string
+
 	// Directories where symlinks are allowed to be created
 	symlinkDirs []string
 
 	// Base directory for transformed paths (default: /opt)
 	baseTransformDir string
 
+	// Whether path transformation is disabled
+	disabled bool
+
 	// Whether to enable verbose logging
 	verbose bool
 
-	// Function used for logging
-	logFunc func(format string, args ...interface{}) (int, error)
+	// Logger for verbose messages (default: slog.Default())
+	logger *slog.Logger
+}
+
+// DefaultSymlinkDirs returns the default list of directories where symlinks are allowed.
+func DefaultSymlinkDirs() []string {
+	return []string{
+		"/etc/systemd/system",
+		"/etc/init.d",
+		"/usr/share/applications",
+		"/usr/share/icons",
+		"/usr/share/man",
+		"/usr/share/metainfo",
+		"/usr/local/bin",
+		"/usr/bin",
+		"/bin",
+	}
+}
+
+// ResolveSymlinkDirs merges configured and extra symlink directories into the
+// effective list. Configured directories replace the defaults; extra directories
+// (typically from the command line) are appended. Duplicates are removed.
+func ResolveSymlinkDirs(configured, extra []string) []string {
+	base := configured
+	if len(base) == 0 {
+		base = DefaultSymlinkDirs()
+	}
+
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, dir := range append(append([]string{}, base...), extra...) {
+		if dir == "" {
+			continue
+		}
+		dir = filepath.Clean(dir)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
 }
 
 // NewPathMapper creates a configured PathMapper with default settings and applies
@@ -78,45 +183,128 @@ func NewPathMapper(opts ...PathMapperOption) *PathMapper {
 			"/share":   "/opt/share",
 			"/include": "/opt/include",
 		},
-		symlinkDirs: []string{
-			"/etc/systemd/system",
-			"/etc/init.d",
-			"/usr/share/applications",
-			"/usr/share/icons",
-			"/usr/share/man",
-			"/usr/local/bin",
-			"/usr/bin",
-			"/bin",
-		},
+		symlinkDirs:      DefaultSymlinkDirs(),
 		baseTransformDir: "/opt",
 		verbose:          false,
-		logFunc:          fmt.Printf,
 	}
 
 	// Apply configuration options
 	for _, opt := range opts {
 		opt(pm)
 	}
+	pm.sortMappings()
 
 	return pm
 }
 
-// SetLogger sets the function used for logging.
-func (pm *PathMapper) SetLogger(logFunc func(format string, args ...interface{}) (int, error)) {
-	if logFunc != nil {
-		pm.logFunc = logFunc
+// dirMapping is a system directory mapping, with the directory cleaned
+type dirMapping struct {
+	source, target string
+}
+
+// sortMappings rebuilds the resolution order of the system directory
+// mappings: the longest directory first, so /usr/local wins over /usr, and
+// directories of the same length in lexical order. The order does not
+// depend on map iteration, so the same mappings always give the same result.
+func (pm *PathMapper) sortMappings() {
+	ordered := make([]dirMapping, 0, len(pm.systemDirs))
+	for source, target := range pm.systemDirs {
+		ordered = append(ordered, dirMapping{filepath.Clean(source), target})
 	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if len(a.source) != len(b.source) {
+			return len(a.source) > len(b.source)
+		}
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		// Keys such as /usr and /usr/ clean to the same directory
+		return a.target < b.target
+	})
+	pm.ordered = ordered
 }
 
-// log logs a message if verbose logging is enabled.
-func (pm *PathMapper) log(format string, args ...interface{}) {
-	if pm.verbose {
-		pm.logFunc(format, args...)
+// matchSystemDir returns the mapping of the longest system directory that
+// is path or contains it
+func (pm *PathMapper) matchSystemDir(path string) (dirMapping, bool) {
+	for _, mapping := range pm.ordered {
+		if within(path, mapping.source) {
+			return mapping, true
+		}
+	}
+	return dirMapping{}, false
+}
+
+// appendSymlinkDirs appends the non-empty dirs to list, cleaned, skipping
+// directories it already holds
+func appendSymlinkDirs(list []string, dirs ...string) []string {
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dir = filepath.Clean(dir)
+		duplicate := false
+		for _, existing := range list {
+			if existing == dir {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			list = append(list, dir)
+		}
+	}
+	return list
+}
+
+// Snapshot returns an independent copy of the mapper. Changes made to either
+// afterwards, such as AddSystemDirMapping, do not affect the other, so a
+// build can hand a fixed configuration to its workers.
+func (pm *PathMapper) Snapshot() *PathMapper {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	systemDirs := make(map[string]string, len(pm.systemDirs))
+	for source, target := range pm.systemDirs {
+		systemDirs[source] = target
+	}
+	return &PathMapper{
+		systemDirs:       systemDirs,
+		ordered:          pm.ordered, // Replaced, never modified, when the mappings change
+		rules:            append([]*MappingRule(nil), pm.rules...),
+		exemptPaths:      append([]string(nil), pm.exemptPaths...),
+		symlinkDirs:      append([]string(nil), pm.symlinkDirs...),
+		baseTransformDir: pm.baseTransformDir,
+		disabled:         pm.disabled,
+		verbose:          pm.verbose,
+		logger:           pm.logger,
+	}
+}
+
+// SetLogger sets the logger used for logging.
+func (pm *PathMapper) SetLogger(logger *slog.Logger) {
+	if logger != nil {
+		pm.mu.Lock()
+		pm.logger = logger
+		pm.mu.Unlock()
 	}
 }
 
+// log logs a message at info level if verbose logging is enabled, and at
+// debug level otherwise.
+func (pm *PathMapper) log(format string, args ...interface{}) {
+	logging.Logf(pm.logger, logging.Verbose(pm.verbose), format, args...)
+}
+
 // IsTransformedPath checks if a path has already been transformed.
 func (pm *PathMapper) IsTransformedPath(path string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.isTransformedPath(path)
+}
+
+// isTransformedPath is IsTransformedPath with the lock held
+func (pm *PathMapper) isTransformedPath(path string) bool {
 	if path == "" {
 		return false
 	}
@@ -124,8 +312,26 @@ func (pm *PathMapper) IsTransformedPath(path string) bool {
 	// Normalize the path first
 	norm := filepath.Clean(path)
 
-	// Check if the path starts with the base transform directory
-	return strings.HasPrefix(norm, pm.baseTransformDir)
+	// Check if the path is the base transform directory or lies below it
+	return within(norm, pm.baseTransformDir)
+}
+
+// IsExemptPath checks if a path is exempt from transformation.
+func (pm *PathMapper) IsExemptPath(path string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.isExemptPath(path)
+}
+
+// isExemptPath is IsExemptPath with the lock held
+func (pm *PathMapper) isExemptPath(path string) bool {
+	norm := filepath.Clean(path)
+	for _, exempt := range pm.exemptPaths {
+		if norm == exempt || strings.HasPrefix(norm, exempt+"/") {
+			return true
+		}
+	}
+	return false
 }
 
 // IsSystemPath checks if a path is in a system directory that needs transformation.
@@ -136,13 +342,10 @@ func (pm *PathMapper) IsSystemPath(path string) bool {
 
 	norm := filepath.Clean(path)
 
-	for sysDir := range pm.systemDirs {
-		if norm == sysDir || strings.HasPrefix(norm, sysDir+"/") {
-			return true
-		}
-	}
-
-	return false
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	_, ok := pm.matchSystemDir(norm)
+	return ok
 }
 
 // TransformPath maps a system path to its secure equivalent.
@@ -158,30 +361,42 @@ func (pm *PathMapper) TransformPath(path string) (string, bool, error) {
 	// Normalize the path first
 	normPath := filepath.Clean(path)
 
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if pm.disabled {
+		pm.log("Path transformation disabled, keeping: %s", normPath)
+		return normPath, false, nil
+	}
+
 	// If the path is already transformed, return it as is
-	if pm.IsTransformedPath(normPath) {
+	if pm.isTransformedPath(normPath) {
 		pm.log("Path already transformed: %s", normPath)
 		return normPath, false, nil
 	}
 
-	// Try to find a matching system directory prefix
-	transformed := false
-	transformedPath := normPath
+	if pm.isExemptPath(normPath) {
+		pm.log("Path exempt from transformation: %s", normPath)
+		return normPath, false, nil
+	}
 
-	for sysDir, secureDir := range pm.systemDirs {
-		if normPath == sysDir || strings.HasPrefix(normPath, sysDir+"/") {
-			// Replace the system directory prefix with the secure equivalent
-			transformedPath = strings.Replace(normPath, sysDir, secureDir, 1)
-			transformed = true
-			pm.log("Transformed path: %s -> %s", normPath, transformedPath)
-			break
+	// Pattern rules are tried first, in order
+	for _, rule := range pm.rules {
+		if rewritten, ok := rule.Apply(normPath); ok {
+			pm.log("Transformed path by rule %s: %s -> %s", rule.Pattern(), normPath, rewritten)
+			return rewritten, pm.shouldCreateSymlink(normPath), nil
 		}
 	}
 
-	if !transformed {
+	// The most specific system directory wins, so /usr/local wins over /usr
+	mapping, ok := pm.matchSystemDir(normPath)
+	if !ok {
 		// If no transformation rule matched, return an error
 		return "", false, fmt.Errorf("no transformation rule matched for path: %s", path)
 	}
+	rel, _ := filepath.Rel(mapping.source, normPath)
+	transformedPath := filepath.Join(mapping.target, rel)
+	pm.log("Transformed path: %s -> %s", normPath, transformedPath)
 
 	// Check if a symlink should be created for this path
 	createSymlink := pm.shouldCreateSymlink(normPath)
@@ -202,12 +417,16 @@ func (pm *PathMapper) shouldCreateSymlink(path string) bool {
 
 // GetTransformedRoot returns the base directory for transformed paths.
 func (pm *PathMapper) GetTransformedRoot() string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 	return pm.baseTransformDir
 }
 
 // GetSystemDirMappings returns a copy of the system directory mappings.
 func (pm *PathMapper) GetSystemDirMappings() map[string]string {
 	// Return a copy to prevent modification of internal state
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 	mappings := make(map[string]string, len(pm.systemDirs))
 	for k, v := range pm.systemDirs {
 		mappings[k] = v
@@ -218,6 +437,8 @@ func (pm *PathMapper) GetSystemDirMappings() map[string]string {
 // GetSymlinkDirs returns a copy of the directories where symlinks are allowed.
 func (pm *PathMapper) GetSymlinkDirs() []string {
 	// Return a copy to prevent modification of internal state
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 	dirs := make([]string, len(pm.symlinkDirs))
 	copy(dirs, pm.symlinkDirs)
 	return dirs
@@ -226,13 +447,25 @@ func (pm *PathMapper) GetSymlinkDirs() []string {
 // AddSystemDirMapping adds or updates a system directory mapping.
 func (pm *PathMapper) AddSystemDirMapping(sourceDir, targetDir string) {
 	if sourceDir != "" && targetDir != "" {
+		pm.mu.Lock()
 		pm.systemDirs[sourceDir] = targetDir
+		pm.sortMappings()
+		pm.mu.Unlock()
 	}
 }
 
-// AddSymlinkDir adds a directory to the list of directories where symlinks are allowed.
+// SetSymlinkDirs replaces the list of directories where symlinks are allowed.
+// Duplicates are dropped.
+func (pm *PathMapper) SetSymlinkDirs(dirs []string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.symlinkDirs = appendSymlinkDirs([]string{}, dirs...)
+}
+
+// AddSymlinkDir adds a directory to the list of directories where symlinks
+// are allowed, unless it is already listed.
 func (pm *PathMapper) AddSymlinkDir(dir string) {
-	if dir != "" {
-		pm.symlinkDirs = append(pm.symlinkDirs, dir)
-	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.symlinkDirs = appendSymlinkDirs(pm.symlinkDirs, dir)
 }
@@ -3,8 +3,12 @@ package security
 import (
 	"bytes"
 	"fmt"
+	"log/slog"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
 )
 
 func TestPathMapperOptions(t *testing.T) {
@@ -145,9 +149,7 @@ func TestSetLogger(t *testing.T) {
 
 	// Custom logger
 	var buf bytes.Buffer
-	customLogger := func(format string, args ...interface{}) (int, error) {
-		return fmt.Fprintf(&buf, format, args...)
-	}
+	customLogger := slog.New(logging.NewTextHandler(&buf, slog.LevelInfo))
 
 	pm.SetLogger(customLogger)
 	pm.verbose = true
@@ -487,16 +489,105 @@ func TestAddSymlinkDir(t *testing.T) {
 		}
 	}
 
-	if dupCount != 2 {
-		t.Errorf("Expected duplicate to be added (no uniqueness check in implementation)")
+	if dupCount != 1 {
+		t.Errorf("Expected duplicate to be skipped, found %d copies", dupCount)
+	}
+}
+
+func TestSetSymlinkDirsDeduplicates(t *testing.T) {
+	pm := NewPathMapper(WithSymlinkDirs([]string{"/usr/bin", "/usr/bin/", "/etc/init.d"}))
+	if dirs := pm.GetSymlinkDirs(); len(dirs) != 2 {
+		t.Errorf("WithSymlinkDirs() kept %v, want 2 directories", dirs)
+	}
+	pm.SetSymlinkDirs([]string{"/usr/local/bin", "/usr/local/bin", ""})
+	if dirs := pm.GetSymlinkDirs(); len(dirs) != 1 || dirs[0] != "/usr/local/bin" {
+		t.Errorf("SetSymlinkDirs() kept %v, want [/usr/local/bin]", dirs)
+	}
+}
+
+func TestPathMapperSnapshot(t *testing.T) {
+	pm := NewPathMapper()
+	snapshot := pm.Snapshot()
+	pm.AddSystemDirMapping("/srv", "/opt/srv")
+	pm.AddSymlinkDir("/srv/www")
+
+	if _, _, err := snapshot.TransformPath("/srv/www/index.html"); err == nil {
+		t.Errorf("Snapshot should not see mappings added after it was taken")
+	}
+	if path, _, err := pm.TransformPath("/srv/www/index.html"); err != nil || path != "/opt/srv/www/index.html" {
+		t.Errorf("TransformPath() = %s, %v", path, err)
+	}
+}
+
+func TestPathMapperConcurrentUse(t *testing.T) {
+	pm := NewPathMapper()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			pm.AddSystemDirMapping(fmt.Sprintf("/srv%d", i), fmt.Sprintf("/opt/srv%d", i))
+			pm.AddSymlinkDir(fmt.Sprintf("/srv%d/bin", i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, _, err := pm.TransformPath("/usr/share/app/file"); err != nil {
+					t.Errorf("TransformPath() error = %v", err)
+					return
+				}
+				pm.GetSymlinkDirs()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(pm.GetSystemDirMappings()); got != len(NewPathMapper().GetSystemDirMappings())+8 {
+		t.Errorf("Expected 8 added mappings, have %d in total", got)
+	}
+}
+
+func TestResolveSymlinkDirs(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured []string
+		extra      []string
+		want       []string
+	}{
+		{"Defaults", nil, nil, DefaultSymlinkDirs()},
+		{"Defaults plus extra", nil, []string{"/srv/links"}, append(DefaultSymlinkDirs(), "/srv/links")},
+		{"Configured replaces defaults", []string{"/etc/init.d"}, nil, []string{"/etc/init.d"}},
+		{"Duplicates and empties removed", []string{"/etc/init.d/", ""}, []string{"/etc/init.d", "/usr/bin"}, []string{"/etc/init.d", "/usr/bin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolveSymlinkDirs(tt.configured, tt.extra)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ResolveSymlinkDirs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetSymlinkDirs(t *testing.T) {
+	pm := NewPathMapper(WithSymlinkDirs([]string{"/etc/init.d"}))
+
+	if _, needsSymlink, _ := pm.TransformPath("/usr/bin/app"); needsSymlink {
+		t.Errorf("Expected no symlink for /usr/bin/app with replaced symlink dirs")
+	}
+	if _, needsSymlink, _ := pm.TransformPath("/etc/init.d/app"); !needsSymlink {
+		t.Errorf("Expected symlink for /etc/init.d/app")
+	}
+
+	pm.SetSymlinkDirs([]string{"/usr/bin"})
+	if dirs := pm.GetSymlinkDirs(); len(dirs) != 1 || dirs[0] != "/usr/bin" {
+		t.Errorf("Expected symlink dirs to be replaced, got %v", dirs)
 	}
 }
 
 func TestLogging(t *testing.T) {
 	var buf bytes.Buffer
-	logger := func(format string, args ...interface{}) (int, error) {
-		return fmt.Fprintf(&buf, format, args...)
-	}
+	logger := slog.New(logging.NewTextHandler(&buf, slog.LevelInfo))
 
 	pm := NewPathMapper(WithVerboseLogging(true))
 	pm.SetLogger(logger)
@@ -514,4 +605,67 @@ func TestLogging(t *testing.T) {
 	if buf.Len() > 0 {
 		t.Errorf("Expected no output when verbose is false")
 	}
+
+	// Unless debug messages are logged
+	pm.SetLogger(slog.New(logging.NewTextHandler(&buf, slog.LevelDebug)))
+	pm.log("Debug message")
+	if !strings.Contains(buf.String(), "DEBUG Debug message") {
+		t.Errorf("Expected a debug message, got %q", buf.String())
+	}
+}
+
+func TestTransformPathPrefersLongestMapping(t *testing.T) {
+	pm := NewPathMapper(WithCustomMapping("/usr/local", "/opt/local"))
+
+	// Repeat to guard against map iteration order deciding the match
+	for i := 0; i < 20; i++ {
+		transformed, _, err := pm.TransformPath("/usr/local/bin/tool")
+		if err != nil || transformed != "/opt/local/bin/tool" {
+			t.Fatalf("TransformPath() = %q, %v; want /opt/local/bin/tool", transformed, err)
+		}
+	}
+	if transformed, _, _ := pm.TransformPath("/usr/bin/tool"); transformed != "/opt/usr/bin/tool" {
+		t.Errorf("Expected /usr mapping for /usr/bin/tool, got %q", transformed)
+	}
+}
+
+func TestTransformPathLongestPrefix(t *testing.T) {
+	mappings := map[string]string{
+		"/usr":           "/opt/usr",
+		"/usr/local":     "/opt/local",
+		"/usr/local/":    "/opt/local-slash",
+		"/usr/local/lib": "/opt/locallib",
+		"/usr/lib":       "/opt/lib",
+		"/":              "/opt/root",
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/usr/bin/app", "/opt/usr/bin/app"},
+		{"/usr/local", "/opt/local"},
+		{"/usr/local/bin/app", "/opt/local/bin/app"},
+		{"/usr/local/lib/libx.so", "/opt/locallib/libx.so"},
+		{"/usr/localized/file", "/opt/usr/localized/file"},
+		{"/usr/lib/x", "/opt/lib/x"},
+		{"/srv/data", "/opt/root/srv/data"},
+	}
+
+	// Map iteration order varies between runs and mappers; the result must not
+	for i := 0; i < 20; i++ {
+		pm := NewPathMapper(WithSystemDirMappings(mappings), WithBaseTransformDir("/opt"))
+		for _, tt := range tests {
+			got, _, err := pm.TransformPath(tt.path)
+			if err != nil || got != tt.want {
+				t.Fatalf("TransformPath(%s) = %s, %v; want %s", tt.path, got, err, tt.want)
+			}
+		}
+	}
+
+	pm := NewPathMapper(WithSystemDirMappings(map[string]string{"/usr": "/opt/usr"}))
+	pm.AddSystemDirMapping("/usr/share", "/opt/share")
+	if got, _, _ := pm.TransformPath("/usr/share/doc/app"); got != "/opt/share/doc/app" {
+		t.Errorf("TransformPath() after AddSystemDirMapping = %s, want /opt/share/doc/app", got)
+	}
 }
@@ -0,0 +1,123 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// PermissionIssue is an insecure mode found in a package by the permissions
+// audit of ValidatePackage
+type PermissionIssue struct {
+	Path    string `json:"path"`    // Installed path
+	Mode    string `json:"mode"`    // Octal mode found in the package
+	Problem string `json:"problem"` // world-writable, group-writable config and/or executable documentation
+	Fix     string `json:"fix"`     // Octal mode that resolves the issue
+	Fixed   bool   `json:"fixed"`   // Whether the fix was applied to the package
+}
+
+// String describes the issue and its fix
+func (i PermissionIssue) String() string {
+	if i.Fixed {
+		return fmt.Sprintf("%s is %s (mode %s); changed to %s", i.Path, i.Problem, i.Mode, i.Fix)
+	}
+	return fmt.Sprintf("%s is %s (mode %s); --fix-perms changes it to %s", i.Path, i.Problem, i.Mode, i.Fix)
+}
+
+// octalMode formats the permission, setuid, setgid and sticky bits of mode
+// as an octal Unix mode, such as 0755
+func octalMode(mode os.FileMode) string {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return fmt.Sprintf("%04o", perm)
+}
+
+// WithPermissionsPolicy lets the permissions audit accept the world-writable
+// paths the policy allows
+func WithPermissionsPolicy(policy *PermissionsPolicy) ValidatorOption {
+	return func(v *Validator) {
+		v.permissions = policy
+	}
+}
+
+// WithFixPermissions makes ValidatePackage change insecure modes it finds
+// instead of reporting them as problems
+func WithFixPermissions(fix bool) ValidatorOption {
+	return func(v *Validator) {
+		v.fixPerms = fix
+	}
+}
+
+// SetPermissionAudit sets the permissions policy and whether insecure modes
+// are fixed, as WithPermissionsPolicy and WithFixPermissions do
+func (v *Validator) SetPermissionAudit(policy *PermissionsPolicy, fix bool) {
+	v.permissions, v.fixPerms = policy, fix
+}
+
+// auditPermissions checks the mode of the package entry at file, installed
+// at absPath, and fixes it if the validator is set to. Symlinks are not
+// checked.
+func (v *Validator) auditPermissions(file, absPath string, info os.FileInfo) (*PermissionIssue, error) {
+	mode := info.Mode()
+	if mode&os.ModeSymlink != 0 {
+		return nil, nil
+	}
+
+	var problems []string
+	fix := mode
+	if mode&0002 != 0 && !(info.IsDir() && mode&os.ModeSticky != 0) && !v.worldWritableAllowed(absPath, info.IsDir()) {
+		problems, fix = append(problems, "world-writable"), fix&^0002
+	}
+	if !info.IsDir() && mode&0020 != 0 && v.isConfigPath(absPath) {
+		problems, fix = append(problems, "group-writable config"), fix&^0020
+	}
+	if !info.IsDir() && mode&0111 != 0 && isDocPath(absPath) {
+		problems, fix = append(problems, "executable documentation"), fix&^0111
+	}
+	if len(problems) == 0 {
+		return nil, nil
+	}
+
+	issue := &PermissionIssue{Path: absPath, Mode: octalMode(mode), Problem: strings.Join(problems, ", "), Fix: octalMode(fix)}
+	if v.fixPerms {
+		if err := os.Chmod(file, fix); err != nil {
+			return nil, fmt.Errorf("failed to fix permissions of %s: %w", absPath, err)
+		}
+		issue.Fixed = true
+	}
+	return issue, nil
+}
+
+// worldWritableAllowed reports whether the permissions policy allows path to
+// be world-writable. The policy names paths before transformation, so the
+// path is also tried without the transformed directory.
+func (v *Validator) worldWritableAllowed(absPath string, isDir bool) bool {
+	if v.permissions.WorldWritableAllowed(absPath, isDir) {
+		return true
+	}
+	if v.transformedDir != "" && strings.HasPrefix(absPath, v.transformedDir+"/") {
+		return v.permissions.WorldWritableAllowed(strings.TrimPrefix(absPath, v.transformedDir), isDir)
+	}
+	return false
+}
+
+// isConfigPath reports whether path is below /etc, or below the etc
+// directory of the transformed directory
+func (v *Validator) isConfigPath(absPath string) bool {
+	return within(absPath, "/etc") || v.transformedDir != "" && within(absPath, path.Join(v.transformedDir, "etc"))
+}
+
+// isDocPath reports whether path is below a documentation or manual page
+// directory, such as /usr/share/doc or /opt/myapp/share/man
+func isDocPath(absPath string) bool {
+	return strings.Contains(absPath, "/share/doc/") || strings.Contains(absPath, "/share/man/")
+}
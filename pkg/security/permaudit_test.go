@@ -0,0 +1,132 @@
+package security
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePermissionPackage writes a staged package with the given file modes
+func writePermissionPackage(t *testing.T, dir string, modes map[string]os.FileMode) {
+	files := map[string]os.FileMode{"DEBIAN/control": 0644}
+	for name, mode := range modes {
+		files[name] = mode
+	}
+	for name, mode := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Failed to set mode: %v", err)
+		}
+	}
+}
+
+func TestPermissionAudit(t *testing.T) {
+	modes := map[string]os.FileMode{
+		"opt/app/bin/app":                0755,
+		"opt/app/var/spool":              0666,
+		"opt/app/var/shared":             0666,
+		"opt/etc/app/app.conf":           0664,
+		"opt/usr/share/doc/app/README":   0755,
+		"opt/usr/share/doc/app/NEWS":     0644,
+		"opt/usr/share/man/man1/app.1":   0777,
+		"opt/app/lib/group-writable.so":  0664,
+		"opt/app/share/app/default.conf": 0644,
+	}
+	permissions := &PermissionsPolicy{AllowWorldWritable: []string{"/app/var/shared"}}
+	if err := permissions.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	want := map[string]string{
+		"/opt/app/var/spool":            "world-writable",
+		"/opt/etc/app/app.conf":         "group-writable config",
+		"/opt/usr/share/doc/app/README": "executable documentation",
+		"/opt/usr/share/man/man1/app.1": "world-writable, executable documentation",
+	}
+	checkIssues := func(t *testing.T, issues []PermissionIssue, fixed bool) {
+		if len(issues) != len(want) {
+			t.Fatalf("Report.Permissions = %v, want %d issues", issues, len(want))
+		}
+		for _, issue := range issues {
+			if want[issue.Path] != issue.Problem || issue.Fixed != fixed {
+				t.Errorf("Unexpected issue %+v", issue)
+			}
+		}
+	}
+
+	t.Run("Warns without strict mode", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "permissions-test-")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		writePermissionPackage(t, dir, modes)
+
+		report, err := NewValidator(WithPermissionsPolicy(permissions)).ValidatePackageReport(dir)
+		if err == nil {
+			err = report.Err()
+		}
+		if err != nil {
+			t.Fatalf("ValidatePackageReport() error = %v", err)
+		}
+		checkIssues(t, report.Permissions, false)
+		if issue := report.Permissions[1]; issue.Fix != "0644" || !strings.Contains(issue.String(), "--fix-perms") {
+			t.Errorf("Unexpected fix %+v", issue)
+		}
+	})
+
+	t.Run("Fails in strict mode", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "permissions-test-")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		writePermissionPackage(t, dir, modes)
+
+		v := NewValidator(WithPermissionsPolicy(permissions), WithStrictPaths(true))
+		if err := v.ValidatePackage(dir); err == nil || !strings.Contains(err.Error(), "4 path(s) with insecure permissions") {
+			t.Errorf("ValidatePackage() error = %v, want insecure permissions", err)
+		}
+	})
+
+	t.Run("Fixes modes", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "permissions-test-")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		writePermissionPackage(t, dir, modes)
+
+		v := NewValidator(WithPermissionsPolicy(permissions), WithStrictPaths(true), WithFixPermissions(true))
+		report, err := v.ValidatePackageReport(dir)
+		if err == nil {
+			err = report.Err()
+		}
+		if err != nil {
+			t.Fatalf("ValidatePackageReport() error = %v", err)
+		}
+		checkIssues(t, report.Permissions, true)
+
+		fixed := map[string]os.FileMode{
+			"opt/app/var/spool":            0664,
+			"opt/app/var/shared":           0666,
+			"opt/etc/app/app.conf":         0644,
+			"opt/usr/share/doc/app/README": 0644,
+			"opt/usr/share/man/man1/app.1": 0664,
+		}
+		for name, mode := range fixed {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil || info.Mode().Perm() != mode {
+				t.Errorf("%s: mode = %v, %v; want %04o", name, info.Mode(), err, mode)
+			}
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+)
+
+// ErrRuleWithoutGlob is returned for a permissions rule that has no glob
+var ErrRuleWithoutGlob = errors.New("permissions rule has no glob")
+
+// PermissionRule sets the mode of packaged paths matching a glob. Globs use
+// the same syntax as exclude patterns, so "*.sh" matches at any depth, and
+// match paths before transformation.
+type PermissionRule struct {
+	Glob string `mapstructure:"glob"`
+	Mode string `mapstructure:"mode"` // Octal mode; quote it in YAML, e.g. "0750"
+}
+
+// PermissionsPolicy declares the modes of packaged files. A file gets the
+// default mode of the deepest listed directory containing it, then the mode of
+// the last matching rule. Setuid and setgid bits are only kept for paths
+// listed in AllowSetuid, and strict builds reject world-writable paths not
+// listed in AllowWorldWritable.
+//
+// Example:
+//
+//	permissions:
+//	  directories:
+//	    /usr/lib/myapp/plugins: "0644"
+//	  rules:
+//	    - glob: /usr/lib/myapp/bin/*
+//	      mode: "0755"
+//	    - glob: /usr/bin/myapp-helper
+//	      mode: "4755"
+//	  allow_setuid:
+//	    - /usr/bin/myapp-helper
+//	  allow_world_writable:
+//	    - /var/lib/myapp/spool
+type PermissionsPolicy struct {
+	Directories map[string]string `mapstructure:"directories"`  // Default file mode below each directory
+	Rules       []PermissionRule  `mapstructure:"rules"`        // Later rules override earlier ones
+	AllowSetuid []string          `mapstructure:"allow_setuid"` // Globs that may ship setuid or setgid
+
+	AllowWorldWritable []string `mapstructure:"allow_world_writable"` // Globs that may ship world-writable
+
+	dirs          []dirMode
+	rules         []permissionRule
+	setuid        *pattern.Matcher
+	worldWritable *pattern.Matcher
+	compiled      bool
+}
+
+type dirMode struct {
+	dir  string
+	mode os.FileMode
+}
+
+type permissionRule struct {
+	matcher *pattern.Matcher
+	mode    os.FileMode
+}
+
+// ParseMode converts an octal mode such as "0755" or "4755" to an os.FileMode,
+// translating the setuid, setgid and sticky bits
+func ParseMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(strings.TrimSpace(mode), 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid file mode %q: expected octal such as 0755", mode)
+	}
+	m := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m, nil
+}
+
+// Validate compiles the policy and reports invalid directories, globs or modes
+func (p *PermissionsPolicy) Validate() error {
+	if p == nil || p.compiled {
+		return nil
+	}
+
+	p.dirs = nil
+	for dir, modeStr := range p.Directories {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("permissions directory %s must be an absolute path", dir)
+		}
+		mode, err := ParseMode(modeStr)
+		if err != nil {
+			return fmt.Errorf("permissions directory %s: %w", dir, err)
+		}
+		p.dirs = append(p.dirs, dirMode{dir: filepath.Clean(dir), mode: mode})
+	}
+	// Deepest directories first
+	sort.Slice(p.dirs, func(i, j int) bool { return len(p.dirs[i].dir) > len(p.dirs[j].dir) })
+
+	p.rules = nil
+	for _, rule := range p.Rules {
+		if rule.Glob == "" {
+			return ErrRuleWithoutGlob
+		}
+		mode, err := ParseMode(rule.Mode)
+		if err != nil {
+			return fmt.Errorf("permissions rule %s: %w", rule.Glob, err)
+		}
+		matcher, err := pattern.NewMatcher([]string{rule.Glob}, nil)
+		if err != nil {
+			return fmt.Errorf("permissions rule %s: %w", rule.Glob, err)
+		}
+		p.rules = append(p.rules, permissionRule{matcher: matcher, mode: mode})
+	}
+
+	setuid, err := pattern.NewMatcher(p.AllowSetuid, nil)
+	if err != nil {
+		return fmt.Errorf("allow_setuid: %w", err)
+	}
+	worldWritable, err := pattern.NewMatcher(p.AllowWorldWritable, nil)
+	if err != nil {
+		return fmt.Errorf("allow_world_writable: %w", err)
+	}
+	p.setuid = setuid
+	p.worldWritable = worldWritable
+	p.compiled = true
+	return nil
+}
+
+// FileMode returns the mode for a packaged file or directory at path, a
+// system path before transformation, starting from the default mode.
+// The policy has no effect until Validate has succeeded.
+func (p *PermissionsPolicy) FileMode(path string, isDir bool, mode os.FileMode) os.FileMode {
+	if p == nil || !p.compiled {
+		return mode
+	}
+	path = filepath.Clean(path)
+	if !isDir {
+		for _, d := range p.dirs {
+			if strings.HasPrefix(path, d.dir+"/") || d.dir == "/" {
+				mode = d.mode
+				break
+			}
+		}
+	}
+	relPath := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	for _, rule := range p.rules {
+		if rule.matcher.Excluded(relPath, isDir) {
+			mode = rule.mode
+		}
+	}
+	return mode
+}
+
+// SetuidAllowed reports whether path may ship with setuid or setgid bits
+func (p *PermissionsPolicy) SetuidAllowed(path string) bool {
+	if p == nil || !p.compiled {
+		return false
+	}
+	return p.setuid.Excluded(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/"), false)
+}
+
+// WorldWritableAllowed reports whether path may ship writable by all users
+func (p *PermissionsPolicy) WorldWritableAllowed(path string, isDir bool) bool {
+	if p == nil || !p.compiled {
+		return false
+	}
+	return p.worldWritable.Excluded(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/"), isDir)
+}
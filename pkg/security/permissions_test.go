@@ -0,0 +1,114 @@
+package security
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"0755", 0755, false},
+		{"644", 0644, false},
+		{"4755", 0755 | os.ModeSetuid, false},
+		{"2750", 0750 | os.ModeSetgid, false},
+		{"1777", 0777 | os.ModeSticky, false},
+		{"0799", 0, true},
+		{"17777", 0, true},
+		{"rwx", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMode(tt.input)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseMode(%q) = %v, %v, want %v, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPermissionsPolicy(t *testing.T) {
+	policy := &PermissionsPolicy{
+		Directories: map[string]string{
+			"/usr/lib/app":         "0644",
+			"/usr/lib/app/plugins": "0600",
+		},
+		Rules: []PermissionRule{
+			{Glob: "/usr/lib/app/bin/*", Mode: "0755"},
+			{Glob: "*.sh", Mode: "0750"},
+			{Glob: "/usr/bin/helper", Mode: "4755"},
+		},
+		AllowSetuid:        []string{"/usr/bin/helper"},
+		AllowWorldWritable: []string{"/var/spool/app"},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  os.FileMode
+	}{
+		{"/usr/lib/app/data.txt", false, 0644},
+		{"/usr/lib/app/plugins/x.so", false, 0600},
+		{"/usr/lib/app/bin/tool", false, 0755},
+		{"/usr/lib/app/plugins/run.sh", false, 0750},
+		{"/usr/lib/app/plugins", true, 0755},
+		{"/usr/share/doc/readme", false, 0700},
+		{"/usr/bin/helper", false, 0755 | os.ModeSetuid},
+	}
+	for _, tt := range tests {
+		start := os.FileMode(0700)
+		if tt.isDir {
+			start = 0755
+		}
+		if got := policy.FileMode(tt.path, tt.isDir, start); got != tt.want {
+			t.Errorf("FileMode(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if !policy.SetuidAllowed("/usr/bin/helper") || policy.SetuidAllowed("/usr/bin/other") {
+		t.Errorf("Expected only /usr/bin/helper to allow setuid")
+	}
+	if !policy.WorldWritableAllowed("/var/spool/app", true) || policy.WorldWritableAllowed("/var/spool/other", true) {
+		t.Errorf("Expected only /var/spool/app to allow world-writable")
+	}
+
+	var none *PermissionsPolicy
+	if none.FileMode("/usr/bin/x", false, 0644) != 0644 || none.SetuidAllowed("/usr/bin/x") {
+		t.Errorf("Expected nil policy to change nothing")
+	}
+}
+
+func TestPermissionsPolicyValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PermissionsPolicy
+	}{
+		{"Relative directory", PermissionsPolicy{Directories: map[string]string{"usr/lib": "0644"}}},
+		{"Bad directory mode", PermissionsPolicy{Directories: map[string]string{"/usr/lib": "rw"}}},
+		{"Empty rule glob", PermissionsPolicy{Rules: []PermissionRule{{Mode: "0755"}}}},
+		{"Bad rule mode", PermissionsPolicy{Rules: []PermissionRule{{Glob: "/usr/bin/*", Mode: "9"}}}},
+		{"Invalid setuid glob", PermissionsPolicy{AllowSetuid: []string{"/"}}},
+		{"Invalid world-writable glob", PermissionsPolicy{AllowWorldWritable: []string{"/"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); err == nil {
+				t.Errorf("Expected Validate() to fail")
+			}
+		})
+	}
+
+	policy := PermissionsPolicy{Rules: []PermissionRule{{Mode: "0755"}}}
+	if err := policy.Validate(); !errors.Is(err, ErrRuleWithoutGlob) {
+		t.Errorf("Validate() of a rule without a glob error = %v, want ErrRuleWithoutGlob", err)
+	}
+}
@@ -0,0 +1,178 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidatorPlugin adds custom checks, such as internal path conventions, to
+// Validator.ValidatePackage. It receives the staged package root and the
+// absolute packaged paths, sorted, and returns one message per problem.
+type ValidatorPlugin interface {
+	Name() string
+	ValidatePackage(packageDir string, paths []string) ([]string, error)
+}
+
+// ScriptValidatorPlugin adds custom checks to ScriptValidator.ValidateScript.
+// Findings with SeverityError count as errors of the script.
+type ScriptValidatorPlugin interface {
+	Name() string
+	ValidateScript(scriptName, content string) ([]Finding, error)
+}
+
+var (
+	pluginsMu              sync.RWMutex
+	validatorPlugins       []ValidatorPlugin
+	scriptValidatorPlugins []ScriptValidatorPlugin
+)
+
+// RegisterValidatorPlugin adds plugin to every Validator created afterwards
+func RegisterValidatorPlugin(plugin ValidatorPlugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	validatorPlugins = append(validatorPlugins, plugin)
+}
+
+// RegisterScriptValidatorPlugin adds plugin to every ScriptValidator created
+// afterwards
+func RegisterScriptValidatorPlugin(plugin ScriptValidatorPlugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	scriptValidatorPlugins = append(scriptValidatorPlugins, plugin)
+}
+
+// registeredValidatorPlugins returns a copy of the registered validator plugins
+func registeredValidatorPlugins() []ValidatorPlugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return append([]ValidatorPlugin(nil), validatorPlugins...)
+}
+
+// registeredScriptValidatorPlugins returns a copy of the registered script
+// validator plugins
+func registeredScriptValidatorPlugins() []ScriptValidatorPlugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return append([]ScriptValidatorPlugin(nil), scriptValidatorPlugins...)
+}
+
+// WithValidatorPlugins adds plugins to the Validator
+func WithValidatorPlugins(plugins ...ValidatorPlugin) ValidatorOption {
+	return func(v *Validator) {
+		v.plugins = append(v.plugins, plugins...)
+	}
+}
+
+// WithScriptValidatorPlugins adds plugins to the ScriptValidator
+func WithScriptValidatorPlugins(plugins ...ScriptValidatorPlugin) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.plugins = append(sv.plugins, plugins...)
+	}
+}
+
+// DefaultPluginTimeout bounds each run of an ExecPlugin
+const DefaultPluginTimeout = 30 * time.Second
+
+// ExecPlugin runs an executable as a validator and script validator plugin.
+//
+// Each check starts the executable, writes one JSON request to its standard
+// input and reads one JSON response from its standard output:
+//
+//	{"type": "package", "root": "/tmp/build", "paths": ["/opt/app/bin/app"]}
+//	-> {"problems": ["/opt/app/bin/app: binaries belong in /opt/acme"]}
+//
+//	{"type": "script", "script": "postinst", "content": "#!/bin/sh\n..."}
+//	-> {"findings": [{"rule_id": "ACME001", "severity": "error", "line": 3, "message": "..."}]}
+//
+// A plugin ignores request types it does not handle by returning {}. A
+// non-zero exit status fails the validation.
+type ExecPlugin struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration // Zero means DefaultPluginTimeout
+}
+
+// NewExecPlugin creates a plugin running the executable at path
+func NewExecPlugin(path string, args ...string) *ExecPlugin {
+	return &ExecPlugin{Path: path, Args: args}
+}
+
+// pluginRequest is the request written to an ExecPlugin
+type pluginRequest struct {
+	Type    string   `json:"type"`
+	Root    string   `json:"root,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	Script  string   `json:"script,omitempty"`
+	Content string   `json:"content,omitempty"`
+}
+
+// pluginResponse is the response read from an ExecPlugin
+type pluginResponse struct {
+	Problems []string  `json:"problems"`
+	Findings []Finding `json:"findings"`
+}
+
+// Name returns the file name of the executable
+func (p *ExecPlugin) Name() string {
+	return filepath.Base(p.Path)
+}
+
+// ValidatePackage sends the packaged paths to the executable
+func (p *ExecPlugin) ValidatePackage(packageDir string, paths []string) ([]string, error) {
+	response, err := p.run(pluginRequest{Type: "package", Root: packageDir, Paths: paths})
+	if err != nil {
+		return nil, err
+	}
+	return response.Problems, nil
+}
+
+// ValidateScript sends a maintainer script to the executable
+func (p *ExecPlugin) ValidateScript(scriptName, content string) ([]Finding, error) {
+	response, err := p.run(pluginRequest{Type: "script", Script: scriptName, Content: content})
+	if err != nil {
+		return nil, err
+	}
+	return response.Findings, nil
+}
+
+// run exchanges one request and response with the executable
+func (p *ExecPlugin) run(request pluginRequest) (*pluginResponse, error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, p.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("plugin %s timed out after %v", p.Name(), timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("plugin %s failed: %w: %s", p.Name(), err, msg)
+		}
+		return nil, fmt.Errorf("plugin %s failed: %w", p.Name(), err)
+	}
+
+	response := &pluginResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return nil, fmt.Errorf("plugin %s returned an invalid response: %w", p.Name(), err)
+	}
+	return response, nil
+}
@@ -0,0 +1,164 @@
+package security
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// prefixPlugin requires every packaged file to live below a prefix and
+// rejects scripts calling a forbidden command
+type prefixPlugin struct {
+	prefix  string
+	command string
+}
+
+func (p prefixPlugin) Name() string { return "prefix" }
+
+func (p prefixPlugin) ValidatePackage(packageDir string, paths []string) ([]string, error) {
+	var problems []string
+	for _, path := range paths {
+		if !strings.HasPrefix(path, p.prefix) && !strings.HasPrefix(p.prefix, path+"/") {
+			problems = append(problems, path+" is outside "+p.prefix)
+		}
+	}
+	return problems, nil
+}
+
+func (p prefixPlugin) ValidateScript(scriptName, content string) ([]Finding, error) {
+	for i, line := range strings.Split(content, "\n") {
+		if strings.Contains(line, p.command) {
+			return []Finding{{RuleID: "ACME001", Severity: SeverityError, Line: i + 1, Message: p.command + " is not allowed"}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// stagePackage creates a staged package holding files
+func stagePackage(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir("", "plugin-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	for _, name := range append(files, "DEBIAN/control") {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestValidatorPlugins(t *testing.T) {
+	plugin := prefixPlugin{prefix: "/opt/acme", command: "curl"}
+
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr string
+	}{
+		{"Conforming package", []string{"opt/acme/bin/app"}, ""},
+		{"Violating package", []string{"opt/acme/bin/app", "opt/other/lib"}, "prefix: /opt/other is outside /opt/acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := stagePackage(t, tt.files...)
+			defer os.RemoveAll(dir)
+
+			err := NewValidator(WithValidatorPlugins(plugin)).ValidatePackage(dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidatePackage() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidatePackage() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Script findings", func(t *testing.T) {
+		sv := NewScriptValidator(WithScriptValidatorPlugins(plugin))
+		result, err := sv.ValidateScript("postinst", "#!/bin/sh\necho hi\ncurl -o /tmp/x https://example.org\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if result.Valid {
+			t.Errorf("Expected the plugin error to invalidate the script")
+		}
+		found := false
+		for _, e := range result.Errors {
+			if e == "Line 3: curl is not allowed (prefix)" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Plugin finding missing from errors %v", result.Errors)
+		}
+
+		var sarif bytes.Buffer
+		if err := WriteSARIFReport(&sarif, []ScriptReport{NewScriptReport("postinst", result)}); err != nil {
+			t.Fatalf("WriteSARIFReport() error = %v", err)
+		}
+		if !strings.Contains(sarif.String(), `"id": "ACME001"`) {
+			t.Errorf("SARIF report does not describe the plugin rule")
+		}
+	})
+
+	t.Run("Registered plugins", func(t *testing.T) {
+		defer func(saved []ValidatorPlugin) { validatorPlugins = saved }(validatorPlugins)
+		RegisterValidatorPlugin(plugin)
+
+		dir := stagePackage(t, "srv/app")
+		defer os.RemoveAll(dir)
+		if err := NewValidator().ValidatePackage(dir); err == nil {
+			t.Errorf("Expected the registered plugin to reject the package")
+		}
+	})
+}
+
+func TestExecPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatalf("Failed to write plugin: %v", err)
+		}
+		return path
+	}
+
+	checker := NewExecPlugin(script("checker", `input=$(cat)
+case "$input" in
+*'"type":"package"'*) echo '{"problems": ["'"$(echo "$input" | grep -o '/srv[^"]*')"' is not allowed"]}' ;;
+*'"type":"script"'*) echo '{"findings": [{"rule_id": "ACME002", "severity": "warning", "message": "reviewed"}]}' ;;
+esac
+`))
+	problems, err := checker.ValidatePackage("/build", []string{"/opt/app", "/srv/data"})
+	if err != nil || fmt.Sprint(problems) != "[/srv/data is not allowed]" {
+		t.Errorf("ValidatePackage() = %v, %v", problems, err)
+	}
+	findings, err := checker.ValidateScript("postinst", "#!/bin/sh\n")
+	if err != nil || len(findings) != 1 || findings[0].RuleID != "ACME002" {
+		t.Errorf("ValidateScript() = %v, %v", findings, err)
+	}
+
+	if _, err := NewExecPlugin(script("failing", "echo broken >&2\nexit 3\n")).ValidatePackage("/build", nil); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("ValidatePackage() error = %v, want the plugin's stderr", err)
+	}
+	if _, err := NewExecPlugin(script("garbage", "echo not json\n")).ValidateScript("postinst", ""); err == nil || !strings.Contains(err.Error(), "invalid response") {
+		t.Errorf("ValidateScript() error = %v, want an invalid response", err)
+	}
+}
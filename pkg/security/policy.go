@@ -0,0 +1,356 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
+	"github.com/spf13/viper"
+)
+
+// PolicyFile is an organisation-defined security policy loaded from YAML or JSON.
+// Each section extends the built-in defaults unless its replace flag is set.
+//
+// Example:
+//
+//	paths:
+//	  forbidden_paths: [/srv/secure]
+//	  max_payload_size: 500M
+//	  secret_allow: ["**/tests/fixtures/"]
+//	  file_types:
+//	    elf: [plugins/]
+//	scripts:
+//	  security_level: high
+//	  dangerous_commands: {nc: 8}
+//	  allowed_commands: [systemctl]
+//	  lint: auto
+//	  types:
+//	    postrm:
+//	      actions:
+//	        purge: {allowed_commands: [rm, deluser], disabled_patterns: [rm-root]}
+//	path_mapping:
+//	  transform_root: /opt
+//	  mappings:
+//	    - {source: /srv, target: /opt/srv}
+//	  symlink_dirs: [/usr/local/bin]
+//	plugins: [./acme-conventions]
+type PolicyFile struct {
+	Paths       PathPolicy    `mapstructure:"paths"`
+	Scripts     ScriptPolicy  `mapstructure:"scripts"`
+	PathMapping MappingPolicy `mapstructure:"path_mapping"`
+	// Executables run as validator and script validator plugins (see
+	// ExecPlugin); relative paths are resolved against the policy file
+	Plugins []string `mapstructure:"plugins"`
+
+	path string // File the policy was loaded from
+}
+
+// PathPolicy configures the SecurityPolicy used by the Validator
+type PathPolicy struct {
+	Replace           bool     `mapstructure:"replace"`
+	ForbiddenPaths    []string `mapstructure:"forbidden_paths"`
+	RestrictedPaths   []string `mapstructure:"restricted_paths"`
+	AllowedExtensions []string `mapstructure:"allowed_extensions"` // Deprecated: use file_types
+	// FileTypes adds locations where files of a detected type (elf, script,
+	// archive, image, text, data) are expected, as exclude-style patterns
+	FileTypes      map[string][]string `mapstructure:"file_types"`
+	MaxPathLength  int                 `mapstructure:"max_path_length"`
+	DisallowDotDot *bool               `mapstructure:"disallow_dot_dot"`
+	// Payload limits; -1 (or "unlimited" for the sizes) lifts a limit, and
+	// sizes take binary suffixes such as 500M or 8G
+	MaxFileCount   int    `mapstructure:"max_file_count"`
+	MaxFileSize    string `mapstructure:"max_file_size"`
+	MaxPayloadSize string `mapstructure:"max_payload_size"`
+	// SecretAllow lists exclude-style patterns of packaged paths that are not
+	// scanned for secrets
+	SecretAllow []string `mapstructure:"secret_allow"`
+}
+
+// ScriptPolicy configures the ScriptValidator
+type ScriptPolicy struct {
+	Replace           bool           `mapstructure:"replace"`
+	SecurityLevel     string         `mapstructure:"security_level"`
+	DangerousPatterns []string       `mapstructure:"dangerous_patterns"`
+	DangerousCommands map[string]int `mapstructure:"dangerous_commands"`
+	ProtectedPaths    []string       `mapstructure:"protected_paths"`
+	AllowedCommands   []string       `mapstructure:"allowed_commands"`
+	Lint              string         `mapstructure:"lint"` // off, auto, shellcheck or builtin
+	// Types sets the policy for a maintainer script type (preinst, postinst,
+	// prerm or postrm), replacing its default policy
+	Types map[string]ScriptTypePolicy `mapstructure:"types"`
+}
+
+// PathMapping maps a system directory to its secure replacement
+type PathMapping struct {
+	Source string `mapstructure:"source" json:"source"`
+	Target string `mapstructure:"target" json:"target"`
+}
+
+// MappingPolicy configures the PathMapper
+type MappingPolicy struct {
+	Replace       bool          `mapstructure:"replace"`
+	TransformRoot string        `mapstructure:"transform_root"`
+	Mappings      []PathMapping `mapstructure:"mappings"`
+	SymlinkDirs   []string      `mapstructure:"symlink_dirs"`
+}
+
+// LoadPolicyFile reads and validates a policy file. The format is chosen from
+// the file extension (.yaml, .yml or .json).
+func LoadPolicyFile(path string) (*PolicyFile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", path, err)
+	}
+
+	policy := &PolicyFile{path: path}
+	if err := v.UnmarshalExact(policy); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Path returns the file the policy was loaded from
+func (p *PolicyFile) Path() string {
+	return p.path
+}
+
+// Validate checks the policy for values the validators cannot use
+func (p *PolicyFile) Validate() error {
+	if p.Paths.MaxPathLength < 0 {
+		return fmt.Errorf("paths.max_path_length must not be negative")
+	}
+	if p.Paths.MaxFileCount < -1 {
+		return fmt.Errorf("paths.max_file_count must be positive, or -1 for no limit")
+	}
+	if p.Paths.MaxFileSize != "" {
+		if _, err := ParseSize(p.Paths.MaxFileSize); err != nil {
+			return fmt.Errorf("paths.max_file_size: %w", err)
+		}
+	}
+	if p.Paths.MaxPayloadSize != "" {
+		if _, err := ParseSize(p.Paths.MaxPayloadSize); err != nil {
+			return fmt.Errorf("paths.max_payload_size: %w", err)
+		}
+	}
+	for _, path := range append(append([]string{}, p.Paths.ForbiddenPaths...), p.Paths.RestrictedPaths...) {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("paths: %q is not an absolute path", path)
+		}
+	}
+	locations := make(map[FileType][]string)
+	for name, patterns := range p.Paths.FileTypes {
+		kind, err := ParseFileType(name)
+		if err != nil {
+			return fmt.Errorf("paths.file_types: %w", err)
+		}
+		locations[kind] = patterns
+	}
+	if _, err := compileFileTypeLocations(locations); err != nil {
+		return fmt.Errorf("paths.file_types: %w", err)
+	}
+	if _, err := pattern.NewMatcher(p.Paths.SecretAllow, nil); err != nil {
+		return fmt.Errorf("paths.secret_allow: %w", err)
+	}
+
+	if p.Scripts.SecurityLevel != "" {
+		if _, err := ParseScriptSecurityLevel(p.Scripts.SecurityLevel); err != nil {
+			return fmt.Errorf("scripts.security_level: %w", err)
+		}
+	}
+	if _, err := ParseLintMode(p.Scripts.Lint); err != nil {
+		return fmt.Errorf("scripts.lint: %w", err)
+	}
+	for _, pattern := range p.Scripts.DangerousPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("scripts.dangerous_patterns: %w", err)
+		}
+	}
+	for cmd, risk := range p.Scripts.DangerousCommands {
+		if risk < 0 || risk > 10 {
+			return fmt.Errorf("scripts.dangerous_commands: risk for %s must be between 0 and 10", cmd)
+		}
+	}
+	for name, policy := range p.Scripts.Types {
+		if scriptType(name) != name {
+			return fmt.Errorf("scripts.types: unknown maintainer script %q, must be one of %s", name, strings.Join(MaintainerScriptTypes, ", "))
+		}
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("scripts.types.%s: %w", name, err)
+		}
+	}
+
+	if p.PathMapping.TransformRoot != "" && !filepath.IsAbs(p.PathMapping.TransformRoot) {
+		return fmt.Errorf("path_mapping.transform_root must be an absolute path")
+	}
+	for _, m := range p.PathMapping.Mappings {
+		if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Target) {
+			return fmt.Errorf("path_mapping.mappings: %q -> %q must both be absolute paths", m.Source, m.Target)
+		}
+	}
+	for _, dir := range p.PathMapping.SymlinkDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("path_mapping.symlink_dirs: %q is not an absolute path", dir)
+		}
+	}
+	for _, plugin := range p.execPlugins() {
+		info, err := os.Stat(plugin.Path)
+		if err != nil {
+			return fmt.Errorf("plugins: %w", err)
+		}
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return fmt.Errorf("plugins: %s is not executable", plugin.Path)
+		}
+	}
+	return nil
+}
+
+// execPlugins returns the plugins listed in the policy file
+func (p *PolicyFile) execPlugins() []*ExecPlugin {
+	plugins := make([]*ExecPlugin, 0, len(p.Plugins))
+	for _, path := range p.Plugins {
+		if !filepath.IsAbs(path) && p.path != "" {
+			path = filepath.Join(filepath.Dir(p.path), path)
+		}
+		// exec only searches $PATH for names without a separator
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		plugins = append(plugins, NewExecPlugin(path))
+	}
+	return plugins
+}
+
+// ParseScriptSecurityLevel converts "low", "medium" or "high" to a ScriptSecurityLevel
+func ParseScriptSecurityLevel(level string) (ScriptSecurityLevel, error) {
+	switch strings.ToLower(level) {
+	case "low":
+		return SecurityLevelLow, nil
+	case "medium":
+		return SecurityLevelMedium, nil
+	case "high":
+		return SecurityLevelHigh, nil
+	default:
+		return 0, fmt.Errorf("unknown security level: %s", level)
+	}
+}
+
+// SecurityPolicy returns the Validator policy described by the policy file
+func (p *PolicyFile) SecurityPolicy() *SecurityPolicy {
+	policy := DefaultSecurityPolicy()
+	if p.Paths.Replace {
+		policy.ForbiddenPaths = nil
+		policy.RestrictedPaths = nil
+		policy.AllowedExtensions = nil
+		policy.FileTypeLocations = make(map[FileType][]string)
+	}
+
+	policy.ForbiddenPaths = append(policy.ForbiddenPaths, p.Paths.ForbiddenPaths...)
+	policy.RestrictedPaths = append(policy.RestrictedPaths, p.Paths.RestrictedPaths...)
+	policy.AllowedExtensions = append(policy.AllowedExtensions, p.Paths.AllowedExtensions...)
+	policy.SecretAllowPaths = append(policy.SecretAllowPaths, p.Paths.SecretAllow...)
+	for name, patterns := range p.Paths.FileTypes {
+		kind := FileType(name)
+		policy.FileTypeLocations[kind] = append(policy.FileTypeLocations[kind], patterns...)
+	}
+	if p.Paths.MaxPathLength > 0 {
+		policy.MaxPathLength = p.Paths.MaxPathLength
+	}
+	if p.Paths.DisallowDotDot != nil {
+		policy.DisallowDotDot = *p.Paths.DisallowDotDot
+	}
+	if p.Paths.MaxFileCount != 0 {
+		policy.MaxFileCount = max(p.Paths.MaxFileCount, 0)
+	}
+	if size, err := ParseSize(p.Paths.MaxFileSize); err == nil && p.Paths.MaxFileSize != "" {
+		policy.MaxFileSize = max(size, 0)
+	}
+	if size, err := ParseSize(p.Paths.MaxPayloadSize); err == nil && p.Paths.MaxPayloadSize != "" {
+		policy.MaxPayloadSize = max(size, 0)
+	}
+	return policy
+}
+
+// ValidatorOptions returns the Validator options described by the policy file
+func (p *PolicyFile) ValidatorOptions() []ValidatorOption {
+	opts := []ValidatorOption{WithPolicy(p.SecurityPolicy())}
+	if p.PathMapping.TransformRoot != "" {
+		opts = append(opts, WithTransformedDir(p.PathMapping.TransformRoot))
+	}
+	for _, plugin := range p.execPlugins() {
+		opts = append(opts, WithValidatorPlugins(plugin))
+	}
+	return opts
+}
+
+// ScriptValidatorOptions returns the ScriptValidator options described by the policy file
+func (p *PolicyFile) ScriptValidatorOptions() []ScriptValidatorOption {
+	var opts []ScriptValidatorOption
+
+	if p.Scripts.SecurityLevel != "" {
+		level, _ := ParseScriptSecurityLevel(p.Scripts.SecurityLevel)
+		opts = append(opts, WithSecurityLevel(level))
+	}
+
+	if p.Scripts.Replace {
+		opts = append(opts,
+			WithDangerousPatterns(p.Scripts.DangerousPatterns),
+			WithDangerousCommands(p.Scripts.DangerousCommands),
+			WithProtectedPaths(p.Scripts.ProtectedPaths),
+			WithScriptTypePolicies(p.Scripts.Types),
+		)
+	} else {
+		opts = append(opts,
+			WithAdditionalDangerousPatterns(p.Scripts.DangerousPatterns),
+			WithAdditionalDangerousCommands(p.Scripts.DangerousCommands),
+			WithAdditionalProtectedPaths(p.Scripts.ProtectedPaths),
+		)
+		for name, policy := range p.Scripts.Types {
+			opts = append(opts, WithScriptTypePolicy(name, policy))
+		}
+	}
+
+	if len(p.Scripts.AllowedCommands) > 0 {
+		opts = append(opts, WithAllowedCommands(p.Scripts.AllowedCommands))
+	}
+	if p.Scripts.Lint != "" {
+		mode, _ := ParseLintMode(p.Scripts.Lint)
+		opts = append(opts, WithLint(mode))
+	}
+	for _, plugin := range p.execPlugins() {
+		opts = append(opts, WithScriptValidatorPlugins(plugin))
+	}
+	return opts
+}
+
+// PathMapperOptions returns the PathMapper options described by the policy file
+func (p *PolicyFile) PathMapperOptions() []PathMapperOption {
+	var opts []PathMapperOption
+
+	if p.PathMapping.TransformRoot != "" {
+		opts = append(opts, WithBaseTransformDir(p.PathMapping.TransformRoot))
+	}
+
+	mappings := make(map[string]string, len(p.PathMapping.Mappings))
+	for _, m := range p.PathMapping.Mappings {
+		mappings[filepath.Clean(m.Source)] = filepath.Clean(m.Target)
+	}
+	if p.PathMapping.Replace {
+		opts = append(opts, WithSystemDirMappings(mappings))
+	} else {
+		for source, target := range mappings {
+			opts = append(opts, WithCustomMapping(source, target))
+		}
+	}
+
+	if len(p.PathMapping.SymlinkDirs) > 0 {
+		opts = append(opts, WithSymlinkDirs(p.PathMapping.SymlinkDirs))
+	}
+	return opts
+}
@@ -0,0 +1,167 @@
+package security
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePolicy writes a policy file into dir and returns its path
+func writePolicy(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	return path
+}
+
+func TestLoadPolicyFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "policy-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	yamlPolicy := writePolicy(t, tmpDir, "policy.yaml", `
+paths:
+  forbidden_paths: [/srv/secure]
+  max_path_length: 255
+  disallow_dot_dot: false
+scripts:
+  security_level: high
+  dangerous_commands:
+    nc: 8
+    curl: 0
+  protected_paths: [/srv/secure]
+  allowed_commands: [systemctl]
+path_mapping:
+  mappings:
+    - {source: /srv, target: /opt/srv}
+  symlink_dirs: [/usr/local/bin]
+`)
+
+	policy, err := LoadPolicyFile(yamlPolicy)
+	if err != nil {
+		t.Fatalf("LoadPolicyFile() error = %v", err)
+	}
+
+	t.Run("Security policy extends defaults", func(t *testing.T) {
+		sp := policy.SecurityPolicy()
+		if sp.MaxPathLength != 255 || sp.DisallowDotDot {
+			t.Errorf("Unexpected scalar settings: %+v", sp)
+		}
+		joined := strings.Join(sp.ForbiddenPaths, ",")
+		if !strings.Contains(joined, "/usr/bin") || !strings.Contains(joined, "/srv/secure") {
+			t.Errorf("Expected defaults plus policy paths, got %v", sp.ForbiddenPaths)
+		}
+		if err := NewValidator(policy.ValidatorOptions()...).ValidatePath("/srv/secure/key"); err == nil {
+			t.Errorf("Expected policy forbidden path to be rejected")
+		}
+	})
+
+	t.Run("Script options", func(t *testing.T) {
+		sv := NewScriptValidator(policy.ScriptValidatorOptions()...)
+		if sv.securityLevel != SecurityLevelHigh {
+			t.Errorf("Expected high security level")
+		}
+		if _, ok := sv.dangerousCommands["curl"]; ok {
+			t.Errorf("Expected curl to be removed by risk 0")
+		}
+
+		result, err := sv.ValidateScript("postinst", "#!/bin/sh\nsystemctl daemon-reload\nnc -l 8080\nrm /srv/secure/key\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		var commands, protected []string
+		for _, f := range result.Findings {
+			switch f.RuleID {
+			case RuleRiskyCommand:
+				commands = append(commands, f.Detail)
+			case RuleProtectedPath:
+				protected = append(protected, f.Detail)
+			}
+		}
+		joined := strings.Join(commands, ",")
+		if strings.Contains(joined, "systemctl") || !strings.Contains(joined, "nc") {
+			t.Errorf("Expected nc but not systemctl to be reported, got %v", commands)
+		}
+		if len(protected) != 1 || protected[0] != "/srv/secure" {
+			t.Errorf("Expected policy protected path to be reported, got %v", protected)
+		}
+	})
+
+	t.Run("Path mapper options", func(t *testing.T) {
+		pm := NewPathMapper(policy.PathMapperOptions()...)
+		transformed, _, err := pm.TransformPath("/srv/data")
+		if err != nil || transformed != "/opt/srv/data" {
+			t.Errorf("TransformPath() = %q, %v", transformed, err)
+		}
+		if dirs := pm.GetSymlinkDirs(); len(dirs) != 1 || dirs[0] != "/usr/local/bin" {
+			t.Errorf("Unexpected symlink dirs: %v", dirs)
+		}
+	})
+
+	t.Run("JSON with replace", func(t *testing.T) {
+		jsonPolicy := writePolicy(t, tmpDir, "policy.json", `{
+  "scripts": {"replace": true, "dangerous_commands": {"nc": 8}},
+  "path_mapping": {"replace": true, "mappings": [{"source": "/etc", "target": "/opt/etc"}]}
+}`)
+		policy, err := LoadPolicyFile(jsonPolicy)
+		if err != nil {
+			t.Fatalf("LoadPolicyFile() error = %v", err)
+		}
+
+		sv := NewScriptValidator(policy.ScriptValidatorOptions()...)
+		if len(sv.dangerousCommands) != 1 || len(sv.dangerousPatterns) != 0 || len(sv.protectedPaths) != 0 {
+			t.Errorf("Expected script defaults to be replaced, got %v", sv.dangerousCommands)
+		}
+		if mappings := NewPathMapper(policy.PathMapperOptions()...).GetSystemDirMappings(); len(mappings) != 1 {
+			t.Errorf("Expected mappings to be replaced, got %v", mappings)
+		}
+	})
+}
+
+func TestPolicyFileValidation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "policy-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"Unknown key", "scripts:\n  dangerous_command: {nc: 8}\n", "invalid policy file"},
+		{"Bad regex", "scripts:\n  dangerous_patterns: ['(']\n", "dangerous_patterns"},
+		{"Risk out of range", "scripts:\n  dangerous_commands: {nc: 11}\n", "between 0 and 10"},
+		{"Unknown level", "scripts:\n  security_level: paranoid\n", "unknown security level"},
+		{"Relative mapping", "path_mapping:\n  mappings:\n    - {source: srv, target: /opt/srv}\n", "absolute"},
+		{"Missing plugin", "plugins: [./acme-check]\n", "plugins:"},
+		{"Plugin not executable", "plugins: [policy.yaml]\n", "is not executable"},
+		{"Unknown file type", "paths:\n  file_types: {exe: [bin/]}\n", "unknown file type"},
+		{"Unknown lint mode", "scripts:\n  lint: pedantic\n", "scripts.lint"},
+		{"Unknown script type", "scripts:\n  types:\n    config: {allowed_commands: [rm]}\n", "unknown maintainer script"},
+		{"Bad size", "paths:\n  max_payload_size: 5 apples\n", "max_payload_size"},
+		{"Negative count", "paths:\n  max_file_count: -2\n", "max_file_count"},
+		{"Empty secret allow pattern", "paths:\n  secret_allow: ['/']\n", "secret_allow"},
+		{"Nested actions", "scripts:\n  types:\n    postrm:\n      actions:\n        purge: {actions: {remove: {allowed_commands: [rm]}}}\n", "cannot be nested"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writePolicy(t, tmpDir, "policy.yaml", tt.content)
+			_, err := LoadPolicyFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadPolicyFile() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadPolicyFile(filepath.Join(tmpDir, "missing.yaml")); err == nil {
+		t.Errorf("Expected error for missing policy file")
+	}
+}
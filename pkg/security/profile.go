@@ -0,0 +1,107 @@
+package security
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile is a named bundle of Validator, ScriptValidator and PathMapper
+// settings selected with --profile
+type Profile struct {
+	Name        string
+	Description string
+
+	ScriptLevel     ScriptSecurityLevel // Level used to decide script validity
+	StrictMode      bool                // Treat warnings as failures
+	StrictPaths     bool                // Reject restricted paths instead of warning
+	Transform       bool                // Redirect system paths under the transform root
+	EnforcePaths    bool                // Fail on path violations instead of only reporting them
+	FailOnConflicts bool                // Fail on paths owned by installed packages
+}
+
+// DefaultProfileName is the profile used when none is selected
+const DefaultProfileName = "standard"
+
+// profiles holds the built-in profiles, keyed by name
+var profiles = map[string]Profile{
+	"strict": {
+		Name:            "strict",
+		Description:     "High-security script validation, restricted paths rejected, warnings and ownership conflicts fail",
+		ScriptLevel:     SecurityLevelHigh,
+		StrictMode:      true,
+		StrictPaths:     true,
+		Transform:       true,
+		EnforcePaths:    true,
+		FailOnConflicts: true,
+	},
+	"standard": {
+		Name:         "standard",
+		Description:  "Default behaviour: system paths transformed, medium script validation",
+		ScriptLevel:  SecurityLevelMedium,
+		Transform:    true,
+		EnforcePaths: true,
+	},
+	"permissive": {
+		Name:         "permissive",
+		Description:  "System paths transformed, only high-risk scripts rejected",
+		ScriptLevel:  SecurityLevelLow,
+		Transform:    true,
+		EnforcePaths: true,
+	},
+	"checkinstall-compat": {
+		Name:        "checkinstall-compat",
+		Description: "Files installed at their original paths like checkinstall; violations reported but not enforced",
+		ScriptLevel: SecurityLevelLow,
+	},
+}
+
+// LookupProfile returns the built-in profile with the given name. An empty
+// name selects the standard profile.
+func LookupProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+	profile, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown security profile: %s (available: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return &profile, nil
+}
+
+// ProfileNames returns the names of the built-in profiles in sorted order
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidatorOptions returns the Validator options described by the profile
+func (p *Profile) ValidatorOptions() []ValidatorOption {
+	return []ValidatorOption{WithStrictPaths(p.StrictPaths)}
+}
+
+// ScriptValidatorOptions returns the ScriptValidator options described by the profile
+func (p *Profile) ScriptValidatorOptions() []ScriptValidatorOption {
+	return []ScriptValidatorOption{WithSecurityLevel(p.ScriptLevel)}
+}
+
+// PathMapperOptions returns the PathMapper options described by the profile
+func (p *Profile) PathMapperOptions() []PathMapperOption {
+	return []PathMapperOption{WithTransformDisabled(!p.Transform)}
+}
+
+// AtLeastAsStrictAs reports whether p validates at least as strictly as
+// other: its script level is not lower and it enables every check other
+// enables
+func (p *Profile) AtLeastAsStrictAs(other *Profile) bool {
+	return p.ScriptLevel >= other.ScriptLevel &&
+		(p.StrictMode || !other.StrictMode) &&
+		(p.StrictPaths || !other.StrictPaths) &&
+		(p.Transform || !other.Transform) &&
+		(p.EnforcePaths || !other.EnforcePaths) &&
+		(p.FailOnConflicts || !other.FailOnConflicts)
+}
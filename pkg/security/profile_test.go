@@ -0,0 +1,89 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupProfile(t *testing.T) {
+	profile, err := LookupProfile("")
+	if err != nil || profile.Name != DefaultProfileName {
+		t.Fatalf("LookupProfile(\"\") = %v, %v", profile, err)
+	}
+
+	if _, err := LookupProfile("Strict"); err != nil {
+		t.Errorf("Expected profile names to be case-insensitive: %v", err)
+	}
+
+	_, err = LookupProfile("paranoid")
+	if err == nil || !strings.Contains(err.Error(), "checkinstall-compat") {
+		t.Errorf("Expected unknown profile error listing the available profiles, got %v", err)
+	}
+
+	if names := ProfileNames(); len(names) != 4 || names[0] != "checkinstall-compat" {
+		t.Errorf("Unexpected profile names: %v", names)
+	}
+}
+
+func TestProfileSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		level         ScriptSecurityLevel
+		transformed   string
+		restrictedErr bool
+	}{
+		{"strict", SecurityLevelHigh, "/opt/bin/tool", true},
+		{"standard", SecurityLevelMedium, "/opt/bin/tool", false},
+		{"permissive", SecurityLevelLow, "/opt/bin/tool", false},
+		{"checkinstall-compat", SecurityLevelLow, "/usr/bin/tool", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := LookupProfile(tt.name)
+			if err != nil {
+				t.Fatalf("LookupProfile() error = %v", err)
+			}
+
+			sv := NewScriptValidator(profile.ScriptValidatorOptions()...)
+			if sv.securityLevel != tt.level {
+				t.Errorf("Script level = %v, want %v", sv.securityLevel, tt.level)
+			}
+
+			pm := NewPathMapper(append(profile.PathMapperOptions(), WithCustomMapping("/usr/bin", "/opt/bin"))...)
+			transformed, needsSymlink, err := pm.TransformPath("/usr/bin/tool")
+			if err != nil || transformed != tt.transformed {
+				t.Errorf("TransformPath() = %q, %v, want %q", transformed, err, tt.transformed)
+			}
+			if !profile.Transform && needsSymlink {
+				t.Errorf("Expected no symlink when transformation is disabled")
+			}
+
+			err = NewValidator(profile.ValidatorOptions()...).ValidatePath("/etc/ssh/sshd_config")
+			if (err != nil) != tt.restrictedErr {
+				t.Errorf("ValidatePath() on restricted path error = %v, want error %v", err, tt.restrictedErr)
+			}
+		})
+	}
+}
+
+func TestProfileAtLeastAsStrictAs(t *testing.T) {
+	tests := []struct {
+		profile, other string
+		want           bool
+	}{
+		{"strict", "standard", true},
+		{"standard", "standard", true},
+		{"permissive", "standard", false},
+		{"checkinstall-compat", "permissive", false},
+		{"permissive", "checkinstall-compat", true},
+	}
+
+	for _, tt := range tests {
+		profile, _ := LookupProfile(tt.profile)
+		other, _ := LookupProfile(tt.other)
+		if got := profile.AtLeastAsStrictAs(other); got != tt.want {
+			t.Errorf("%s.AtLeastAsStrictAs(%s) = %v, want %v", tt.profile, tt.other, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxSymlinkDepth is the number of symlinks followed while resolving a path,
+// the limit Linux applies before failing with ELOOP
+const MaxSymlinkDepth = 40
+
+// ErrSymlinkLoop is returned when resolving a path runs into a symlink loop
+// or follows more than MaxSymlinkDepth symlinks
+var ErrSymlinkLoop = errors.New("symlink loop")
+
+// ResolveSymlinks returns the absolute path that path refers to, following
+// the symlinks of every existing component like the kernel would. Unlike
+// filepath.EvalSymlinks it accepts paths that do not exist yet: components
+// from the first missing one on are kept as they are.
+func ResolveSymlinks(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path must be absolute: %s", path)
+	}
+
+	resolved := "/"
+	// Not cleaned: ".." after a symlink refers to the parent of its target
+	rest := strings.Split(path, "/")
+	followed := 0
+	// A symlink reached again with the same remaining components can only
+	// lead to itself
+	seen := make(map[string]bool)
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		key := next + "\x00" + strings.Join(rest, "/")
+		if seen[key] {
+			return "", fmt.Errorf("%w: %s leads back to itself", ErrSymlinkLoop, next)
+		}
+		seen[key] = true
+		if followed++; followed > MaxSymlinkDepth {
+			return "", fmt.Errorf("%w: more than %d symlinks followed resolving %s", ErrSymlinkLoop, MaxSymlinkDepth, path)
+		}
+
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink %s: %w", next, err)
+		}
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(link, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// within reports whether path is dir or lies below it
+func within(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
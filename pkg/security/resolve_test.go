@@ -0,0 +1,73 @@
+package security
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSymlinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "resolve-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	// The temporary directory may itself be reached through a symlink
+	if tmpDir, err = filepath.EvalSymlinks(tmpDir); err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+
+	os.MkdirAll(filepath.Join(tmpDir, "real/lib"), 0755)
+	links := map[string]string{
+		"abs":   filepath.Join(tmpDir, "real"),
+		"rel":   "real/lib",
+		"chain": "abs",
+		"up":    "real/../real",
+		"self":  "self",
+		"a":     "b/x",
+		"b":     "a",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		want     string
+		wantLoop bool
+	}{
+		{"Plain path", "real/lib", "real/lib", false},
+		{"Missing path", "missing/file", "missing/file", false},
+		{"Absolute symlink", "abs/lib/libx.so", "real/lib/libx.so", false},
+		{"Relative symlink", "rel/libx.so", "real/lib/libx.so", false},
+		{"Chain", "chain/lib", "real/lib", false},
+		{"Dot-dot in a symlink", "up/lib", "real/lib", false},
+		{"Dot-dot after a symlink", "rel/../bin", "real/bin", false},
+		{"Self loop", "self/x", "", true},
+		{"Loop between two symlinks", "a", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveSymlinks(tmpDir + "/" + tt.path) // Join would clean away the ..
+			if tt.wantLoop {
+				if !errors.Is(err, ErrSymlinkLoop) {
+					t.Errorf("ResolveSymlinks() = %q, %v, want a symlink loop", got, err)
+				}
+				return
+			}
+			if err != nil || got != filepath.Join(tmpDir, tt.want) {
+				t.Errorf("ResolveSymlinks() = %q, %v, want %q", got, err, filepath.Join(tmpDir, tt.want))
+			}
+		})
+	}
+
+	if _, err := ResolveSymlinks("relative/path"); err == nil {
+		t.Errorf("Expected an error for a relative path")
+	}
+}
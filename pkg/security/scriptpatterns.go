@@ -0,0 +1,290 @@
+package security
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ScriptPattern is a dangerous pattern checked against each line of a
+// maintainer script. Patterns added without an ID are keyed by their
+// expression.
+type ScriptPattern struct {
+	ID      string `json:"id"`
+	Pattern string `json:"pattern"`
+}
+
+// scriptPattern is a registered pattern together with its compiled form
+type scriptPattern struct {
+	ScriptPattern
+	re *regexp.Regexp // nil until compiled
+}
+
+// scriptCommand is a risky command together with its compiled word match
+type scriptCommand struct {
+	name   string
+	risk   int
+	listed bool // In dangerousCommands rather than only in a script type policy
+	re     *regexp.Regexp
+}
+
+// defaultScriptPatterns returns the built-in dangerous patterns
+func defaultScriptPatterns() []*scriptPattern {
+	patterns := []ScriptPattern{
+		{"rm-root", `rm\s+(-[rf]+\s+)?/`},                 // rm with root paths
+		{"chmod-root", `chmod\s+([0-7]+\s+)?/`},           // chmod of root paths
+		{"chown-root", `chown\s+([^/]+\s+)?/`},            // chown of root paths
+		{"wget-pipe-shell", `wget\s+.+\s+\|\s+([ba])?sh`}, // piping wget to shell
+		{"curl-pipe-shell", `curl\s+.+\s+\|\s+([ba])?sh`}, // piping curl to shell
+		{"sudo", `sudo`},                                          // sudo usage
+		{"su-root", `su\s+(-[a-z]+\s+)?root`},                     // su to root
+		{"eval-string", `eval\s+["']`},                            // eval usage
+		{"exec-fd", `exec\s+[0-9]+`},                              // exec usage with file descriptors
+		{"setuid", `set(uid|gid)`},                                // setuid/setgid mentions
+		{"write-etc", `>\s*/etc/`},                                // writing to /etc
+		{"append-etc", `>>\s*/etc/`},                              // appending to /etc
+		{"apt-install", `apt(-get)?\s+(install|remove)`},          // package installation
+		{"dpkg-install", `dpkg\s+(-i|--install)`},                 // dpkg installation
+		{"update-alternatives", `update-alternatives`},            // changing system alternatives
+		{"init-script", `/etc/init.d/`},                           // init script manipulation
+		{"systemctl-enable", `systemctl\s+(enable|disable|mask)`}, // systemd service manipulation
+	}
+
+	registry := make([]*scriptPattern, len(patterns))
+	for i, p := range patterns {
+		registry[i] = &scriptPattern{ScriptPattern: p, re: regexp.MustCompile(p.Pattern)}
+	}
+	return registry
+}
+
+// setPattern adds the pattern, or replaces the expression of the pattern
+// registered under the same ID. The pattern is compiled by compilePatterns.
+func (sv *ScriptValidator) setPattern(id, expr string) {
+	if id == "" {
+		id = expr
+	}
+	for _, p := range sv.dangerousPatterns {
+		if p.ID == id {
+			p.Pattern, p.re = expr, nil
+			return
+		}
+	}
+	sv.dangerousPatterns = append(sv.dangerousPatterns, &scriptPattern{
+		ScriptPattern: ScriptPattern{ID: id, Pattern: expr},
+	})
+}
+
+// removePattern removes the pattern registered under id
+func (sv *ScriptValidator) removePattern(id string) bool {
+	for i, p := range sv.dangerousPatterns {
+		if p.ID == id {
+			sv.dangerousPatterns = append(sv.dangerousPatterns[:i], sv.dangerousPatterns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles the patterns added since the last call and the
+// risky commands of the validator and its script type policies. An invalid pattern is kept in patternErr and returned by
+// every ValidateScript call, as the options that add patterns cannot fail.
+func (sv *ScriptValidator) compilePatterns() {
+	sv.patternErr = nil
+	for _, p := range sv.dangerousPatterns {
+		if p.re != nil {
+			continue
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			sv.patternErr = fmt.Errorf("invalid dangerous pattern %s: %w", p.ID, err)
+			continue
+		}
+		p.re = re
+	}
+
+	names := make(map[string]bool, len(sv.dangerousCommands))
+	for cmd := range sv.dangerousCommands {
+		names[cmd] = true
+	}
+	for _, policy := range sv.typePolicies {
+		for cmd := range policy.DangerousCommands {
+			names[cmd] = true
+		}
+		for _, action := range policy.Actions {
+			for cmd := range action.DangerousCommands {
+				names[cmd] = true
+			}
+		}
+	}
+
+	sv.commands = make([]scriptCommand, 0, len(names))
+	for cmd := range names {
+		risk, listed := sv.dangerousCommands[cmd]
+		sv.commands = append(sv.commands, scriptCommand{
+			name:   cmd,
+			risk:   risk,
+			listed: listed,
+			re:     regexp.MustCompile(`\b` + regexp.QuoteMeta(cmd) + `\b`),
+		})
+	}
+	sort.Slice(sv.commands, func(i, j int) bool { return sv.commands[i].name < sv.commands[j].name })
+}
+
+// AddPattern registers a dangerous pattern under id, replacing any pattern
+// already registered under it. It must not be called while scripts are being
+// validated.
+func (sv *ScriptValidator) AddPattern(id, pattern string) error {
+	if id == "" {
+		return fmt.Errorf("pattern ID must not be empty")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid dangerous pattern %s: %w", id, err)
+	}
+	sv.setPattern(id, pattern)
+	for _, p := range sv.dangerousPatterns {
+		if p.ID == id {
+			p.re = re
+		}
+	}
+	return nil
+}
+
+// RemovePattern removes the dangerous pattern registered under id and reports
+// whether it was registered. It must not be called while scripts are being
+// validated.
+func (sv *ScriptValidator) RemovePattern(id string) bool {
+	removed := sv.removePattern(id)
+	if removed {
+		sv.compilePatterns()
+	}
+	return removed
+}
+
+// Patterns returns the registered dangerous patterns in the order they are checked
+func (sv *ScriptValidator) Patterns() []ScriptPattern {
+	patterns := make([]ScriptPattern, len(sv.dangerousPatterns))
+	for i, p := range sv.dangerousPatterns {
+		patterns[i] = p.ScriptPattern
+	}
+	return patterns
+}
+
+// scriptLine is a logical line of a script: physical lines joined at line
+// continuations, numbered by the first of them
+type scriptLine struct {
+	number int
+	text   string
+}
+
+var (
+	// heredocRe matches a here-document redirection and its delimiter word
+	heredocRe = regexp.MustCompile(`<<(-?)\s*(?:'([^']+)'|"([^"]+)"|\\?([A-Za-z_][A-Za-z0-9_.-]*))`)
+
+	// shellCommandRe matches text ending in a command that runs a shell, such
+	// as the text before the redirection in "sh -s <<EOF"
+	shellCommandRe = regexp.MustCompile(`(^\s*|[;&|(]\s*)(sudo\s+|exec\s+)?(/usr)?(/bin/)?(ba|da|z|k)?sh\b[^;&|]*$`)
+
+	// pipeToShellRe matches a pipe into a shell, as in "cat <<EOF | sh"
+	pipeToShellRe = regexp.MustCompile(`^[^;&]*\|\s*(sudo\s+)?(/usr)?(/bin/)?(ba|da|z|k)?sh\b`)
+)
+
+// heredoc is a pending here-document body
+type heredoc struct {
+	delimiter string
+	stripTabs bool // <<- strips leading tabs from the body and delimiter
+	script    bool // the body is run by a shell
+}
+
+// logicalLines splits a script into logical lines. Backslash-newline
+// continuations are joined, and the bodies of here-documents are dropped
+// unless they are fed to a shell, so that data written to files is not
+// mistaken for commands.
+func logicalLines(content string) ([]scriptLine, error) {
+	var (
+		lines   []scriptLine
+		pending []heredoc // Here-documents opened by the previous logical line
+		current *scriptLine
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		if len(pending) > 0 && current == nil {
+			doc := pending[0]
+			end := line
+			if doc.stripTabs {
+				end = strings.TrimLeft(end, "\t")
+			}
+			if end == doc.delimiter {
+				pending = pending[1:]
+				continue
+			}
+			if doc.script {
+				lines = append(lines, scriptLine{number: lineNumber, text: line})
+			}
+			continue
+		}
+
+		if current == nil {
+			current = &scriptLine{number: lineNumber}
+		}
+		if continued(line) {
+			current.text += line[:len(line)-1]
+			continue
+		}
+		current.text += line
+		lines = append(lines, *current)
+		pending = append(pending, heredocs(current.text)...)
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		lines = append(lines, *current)
+	}
+	return lines, nil
+}
+
+// continued reports whether line ends in an unescaped backslash
+func continued(line string) bool {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return false
+	}
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// heredocs returns the here-documents a logical line opens, in order
+func heredocs(line string) []heredoc {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return nil
+	}
+
+	var docs []heredoc
+	for _, loc := range heredocRe.FindAllStringSubmatchIndex(line, -1) {
+		// <<< is a here-string, which has no body
+		if loc[0] > 0 && line[loc[0]-1] == '<' {
+			continue
+		}
+		delimiter := ""
+		for g := 2; g <= 4; g++ {
+			if loc[2*g] >= 0 {
+				delimiter = line[loc[2*g]:loc[2*g+1]]
+				break
+			}
+		}
+		docs = append(docs, heredoc{
+			delimiter: delimiter,
+			stripTabs: loc[3] > loc[2],
+			script:    shellCommandRe.MatchString(line[:loc[0]]) || pipeToShellRe.MatchString(line[loc[1]:]),
+		})
+	}
+	return docs
+}
@@ -0,0 +1,303 @@
+package security
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Severity is the severity of a script validation finding. The values match
+// the SARIF result levels.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+// Rule IDs reported by the ScriptValidator
+const (
+	RuleEmptyScript         = "PKI001"
+	RuleMissingShebang      = "PKI002"
+	RuleDangerousPattern    = "PKI003"
+	RuleRiskyCommand        = "PKI004"
+	RuleProtectedPath       = "PKI005"
+	RuleUntransformablePath = "PKI006"
+	RuleSymlinkRequired     = "PKI007"
+	RuleEncodedPayload      = "PKI008"
+	RuleEscapedString       = "PKI009"
+	RuleEvalBuiltCode       = "PKI010"
+	RuleDownloadExecute     = "PKI011"
+	RuleEncodedBlob         = "PKI012"
+	RuleRuntimeFailure      = "PKI013"
+	RuleRuntimeRiskyExec    = "PKI014"
+	RuleRuntimeProtected    = "PKI015"
+	RuleLint                = "PKI016"
+	RuleEmbeddedSecret      = "PKI017"
+)
+
+// ScriptRule describes a check performed by the ScriptValidator
+type ScriptRule struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Severity    Severity `json:"severity"`
+	FileLevel   bool     `json:"-"` // Applies to the script as a whole rather than a single line
+}
+
+// scriptRules is the catalogue of rules, keyed by ID
+var scriptRules = map[string]ScriptRule{
+	RuleEmptyScript: {
+		ID: RuleEmptyScript, Name: "empty-script", Severity: SeverityNote, FileLevel: true,
+		Description: "Maintainer script has no content",
+	},
+	RuleMissingShebang: {
+		ID: RuleMissingShebang, Name: "missing-shebang", Severity: SeverityWarning, FileLevel: true,
+		Description: "Script does not start with a recognised shell interpreter line",
+	},
+	RuleDangerousPattern: {
+		ID: RuleDangerousPattern, Name: "dangerous-pattern", Severity: SeverityWarning,
+		Description: "Line matches a pattern associated with unsafe system modification",
+	},
+	RuleRiskyCommand: {
+		ID: RuleRiskyCommand, Name: "risky-command", Severity: SeverityWarning,
+		Description: "Line invokes a command that can modify the system",
+	},
+	RuleProtectedPath: {
+		ID: RuleProtectedPath, Name: "protected-path", Severity: SeverityError,
+		Description: "Risky command operates on a protected system path",
+	},
+	RuleUntransformablePath: {
+		ID: RuleUntransformablePath, Name: "untransformable-path", Severity: SeverityWarning,
+		Description: "Path referenced by the script cannot be mapped to a secure location",
+	},
+	RuleSymlinkRequired: {
+		ID: RuleSymlinkRequired, Name: "symlink-required", Severity: SeverityWarning,
+		Description: "Path referenced by the script would require a symlink into a system directory",
+	},
+	RuleEncodedPayload: {
+		ID: RuleEncodedPayload, Name: "encoded-payload", Severity: SeverityError,
+		Description: "Script decodes an encoded payload and pipes it to a shell",
+	},
+	RuleEscapedString: {
+		ID: RuleEscapedString, Name: "escaped-string", Severity: SeverityWarning,
+		Description: "ANSI-C quoted string hides its content behind hex or octal escapes",
+	},
+	RuleEvalBuiltCode: {
+		ID: RuleEvalBuiltCode, Name: "eval-built-code", Severity: SeverityError,
+		Description: "eval runs code assembled at runtime by printf, echo or a decoder",
+	},
+	RuleDownloadExecute: {
+		ID: RuleDownloadExecute, Name: "download-execute", Severity: SeverityError,
+		Description: "File downloaded by the script is executed",
+	},
+	RuleEncodedBlob: {
+		ID: RuleEncodedBlob, Name: "encoded-blob", Severity: SeverityWarning,
+		Description: "Script embeds a long base64-encoded blob",
+	},
+	RuleRuntimeFailure: {
+		ID: RuleRuntimeFailure, Name: "runtime-failure", Severity: SeverityError, FileLevel: true,
+		Description: "Script exited with a non-zero status when run in a sandbox",
+	},
+	RuleRuntimeRiskyExec: {
+		ID: RuleRuntimeRiskyExec, Name: "runtime-risky-exec", Severity: SeverityWarning, FileLevel: true,
+		Description: "Script executed a command that can modify the system when run in a sandbox",
+	},
+	RuleRuntimeProtected: {
+		ID: RuleRuntimeProtected, Name: "runtime-protected-write", Severity: SeverityError, FileLevel: true,
+		Description: "Script changed or tried to change a protected system path when run in a sandbox",
+	},
+	RuleLint: {
+		ID: RuleLint, Name: "lint", Severity: SeverityWarning,
+		Description: "Shell quality issue reported by shellcheck or the built-in lint checks",
+	},
+	RuleEmbeddedSecret: {
+		ID: RuleEmbeddedSecret, Name: "embedded-secret", Severity: SeverityWarning,
+		Description: "Script contains a likely credential, such as a private key or an access token",
+	},
+}
+
+// ScriptRules returns the rule catalogue sorted by ID
+func ScriptRules() []ScriptRule {
+	rules := make([]ScriptRule, 0, len(scriptRules))
+	for _, rule := range scriptRules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// Finding is a single structured result of script validation
+type Finding struct {
+	RuleID   string   `json:"rule_id"`
+	Severity Severity `json:"severity"`
+	Line     int      `json:"line,omitempty"` // 1-based; 0 when the finding applies to the whole script
+	Message  string   `json:"message"`
+	Detail   string   `json:"detail,omitempty"` // Matched pattern, command or path
+}
+
+// ScriptReport is the validation result for a single script file
+type ScriptReport struct {
+	Path      string    `json:"path"`
+	Valid     bool      `json:"valid"`
+	RiskLevel int       `json:"risk_level"`
+	Findings  []Finding `json:"findings"`
+}
+
+// NewScriptReport creates a report entry for the script at path
+func NewScriptReport(path string, result *ScriptValidationResult) ScriptReport {
+	findings := result.Findings
+	if findings == nil {
+		findings = []Finding{}
+	}
+	return ScriptReport{
+		Path:      path,
+		Valid:     result.Valid,
+		RiskLevel: result.RiskLevel,
+		Findings:  findings,
+	}
+}
+
+// WriteJSONReport writes the reports as an indented JSON document
+func WriteJSONReport(w io.Writer, reports []ScriptReport) error {
+	doc := struct {
+		Rules   []ScriptRule   `json:"rules"`
+		Scripts []ScriptReport `json:"scripts"`
+	}{
+		Rules:   ScriptRules(),
+		Scripts: reports,
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write JSON report: %w", err)
+	}
+	return nil
+}
+
+// SARIF 2.1.0 document structure, limited to the fields pkginstall emits
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level Severity `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     Severity        `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// WriteSARIFReport writes the reports as a SARIF 2.1.0 log suitable for
+// code-scanning dashboards
+func WriteSARIFReport(w io.Writer, reports []ScriptReport) error {
+	rules := ScriptRules()
+	ruleIndex := make(map[string]int, len(rules))
+	driver := sarifDriver{
+		Name:           "pkginstall",
+		InformationURI: "https://github.com/go-i2p/go-pkginstall",
+	}
+	for i, rule := range rules {
+		ruleIndex[rule.ID] = i
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   rule.ID,
+			Name:                 rule.Name,
+			ShortDescription:     sarifMessage{Text: rule.Description},
+			DefaultConfiguration: sarifConfiguration{Level: rule.Severity},
+		})
+	}
+
+	run := sarifRun{Tool: sarifTool{Driver: driver}, Results: []sarifResult{}}
+	for _, report := range reports {
+		for _, finding := range report.Findings {
+			location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: report.Path},
+			}}
+			if finding.Line > 0 {
+				location.PhysicalLocation.Region = &sarifRegion{StartLine: finding.Line}
+			}
+			// Rules of validator plugins are not in the catalogue
+			if _, ok := ruleIndex[finding.RuleID]; !ok {
+				ruleIndex[finding.RuleID] = len(run.Tool.Driver.Rules)
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+					ID:                   finding.RuleID,
+					Name:                 finding.RuleID,
+					ShortDescription:     sarifMessage{Text: "Reported by a validator plugin"},
+					DefaultConfiguration: sarifConfiguration{Level: finding.Severity},
+				})
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    finding.RuleID,
+				RuleIndex: ruleIndex[finding.RuleID],
+				Level:     finding.Severity,
+				Message:   sarifMessage{Text: finding.Message},
+				Locations: []sarifLocation{location},
+			})
+		}
+	}
+
+	doc := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write SARIF report: %w", err)
+	}
+	return nil
+}
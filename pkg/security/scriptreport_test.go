@@ -0,0 +1,96 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestScriptFindings(t *testing.T) {
+	validator := NewScriptValidator(WithSecurityLevel(SecurityLevelMedium))
+
+	result, err := validator.ValidateScript("postinst", "echo start\nchmod 777 /etc/passwd\n")
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+
+	if len(result.Findings) != len(result.Warnings)+len(result.Errors) {
+		t.Errorf("Expected one finding per warning and error, got %d findings for %d warnings and %d errors",
+			len(result.Findings), len(result.Warnings), len(result.Errors))
+	}
+
+	rules := make(map[string]Finding)
+	for _, f := range result.Findings {
+		rules[f.RuleID] = f
+	}
+	if f, ok := rules[RuleMissingShebang]; !ok || f.Line != 1 || f.Severity != SeverityWarning {
+		t.Errorf("Expected missing shebang warning on line 1, got %+v", f)
+	}
+	if f, ok := rules[RuleProtectedPath]; !ok || f.Line != 2 || f.Severity != SeverityError || f.Detail != "/etc/passwd" {
+		t.Errorf("Expected protected path error on line 2, got %+v", f)
+	}
+
+	// Legacy string output is unchanged
+	if result.Warnings[0] != "Script does not start with a valid shell interpreter line (shebang)" {
+		t.Errorf("Unexpected shebang warning text: %q", result.Warnings[0])
+	}
+	if result.Errors[0] != "Line 2: Command operates on protected path: /etc/passwd" {
+		t.Errorf("Unexpected error text: %q", result.Errors[0])
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	validator := NewScriptValidator()
+	result, err := validator.ValidateScript("postinst", "#!/bin/sh\nchmod 777 /etc/passwd\n")
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+	reports := []ScriptReport{NewScriptReport("debian/postinst", result)}
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteJSONReport(&buf, reports); err != nil {
+			t.Fatalf("WriteJSONReport() error = %v", err)
+		}
+
+		var doc struct {
+			Rules   []ScriptRule   `json:"rules"`
+			Scripts []ScriptReport `json:"scripts"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		if len(doc.Rules) != len(scriptRules) || len(doc.Scripts) != 1 || doc.Scripts[0].Valid {
+			t.Errorf("Unexpected JSON report: %s", buf.String())
+		}
+	})
+
+	t.Run("SARIF", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteSARIFReport(&buf, reports); err != nil {
+			t.Fatalf("WriteSARIFReport() error = %v", err)
+		}
+
+		var doc sarifLog
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("Invalid SARIF: %v", err)
+		}
+		if doc.Version != "2.1.0" || len(doc.Runs) != 1 {
+			t.Fatalf("Unexpected SARIF envelope: %s", buf.String())
+		}
+
+		run := doc.Runs[0]
+		if len(run.Results) != len(result.Findings) {
+			t.Fatalf("Expected %d results, got %d", len(result.Findings), len(run.Results))
+		}
+		for _, r := range run.Results {
+			if run.Tool.Driver.Rules[r.RuleIndex].ID != r.RuleID {
+				t.Errorf("Rule index %d does not match rule %s", r.RuleIndex, r.RuleID)
+			}
+			loc := r.Locations[0].PhysicalLocation
+			if loc.ArtifactLocation.URI != "debian/postinst" || loc.Region == nil || loc.Region.StartLine != 2 {
+				t.Errorf("Unexpected location: %+v", loc)
+			}
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RuntimeOp is the kind of a RuntimeAction
+type RuntimeOp string
+
+const (
+	RuntimeExec   RuntimeOp = "exec"
+	RuntimeWrite  RuntimeOp = "write"
+	RuntimeDelete RuntimeOp = "delete"
+	RuntimeRename RuntimeOp = "rename"
+	RuntimeMkdir  RuntimeOp = "mkdir"
+	RuntimeChmod  RuntimeOp = "chmod"
+	RuntimeChown  RuntimeOp = "chown"
+	RuntimeLink   RuntimeOp = "link"
+)
+
+// RuntimeAction is a program execution or file system change a script
+// attempted while it ran in a sandbox
+type RuntimeAction struct {
+	Op     RuntimeOp `json:"op"`
+	Path   string    `json:"path"`
+	Target string    `json:"target,omitempty"` // Rename destination or link target
+	Args   []string  `json:"args,omitempty"`   // Command line of an exec
+	Error  string    `json:"error,omitempty"`  // Error name, such as EROFS, when the attempt failed
+}
+
+// String describes the action in one line
+func (a RuntimeAction) String() string {
+	var s string
+	switch {
+	case a.Op == RuntimeExec && len(a.Args) > 0:
+		s = fmt.Sprintf("exec %s: %s", a.Path, strings.Join(a.Args, " "))
+	case a.Target != "":
+		s = fmt.Sprintf("%s %s -> %s", a.Op, a.Path, a.Target)
+	default:
+		s = fmt.Sprintf("%s %s", a.Op, a.Path)
+	}
+	if a.Error != "" {
+		s += " (" + a.Error + ")"
+	}
+	return s
+}
+
+// ValidateRuntime checks what a script did when it was run in a sandbox:
+// whether it exited successfully, which risky commands it executed and
+// whether it changed, or tried to change, protected paths. Failed attempts
+// count like successful ones, as they would have succeeded on a real system.
+func (sv *ScriptValidator) ValidateRuntime(scriptName string, exitCode int, actions []RuntimeAction) *ScriptValidationResult {
+	result := sv.newResult(scriptName)
+
+	if exitCode != 0 {
+		result.addFinding(RuleRuntimeFailure, 0, fmt.Sprint(exitCode), fmt.Sprintf("Script exited with status %d", exitCode), 2)
+	}
+
+	reported := make(map[string]bool)
+	var modified []string
+	for _, action := range actions {
+		if action.Op == RuntimeExec {
+			cmd := path.Base(action.Path)
+			risk, ok := sv.dangerousCommands[cmd]
+			if !ok || sv.allowedCommands[cmd] || reported["exec "+cmd] {
+				continue
+			}
+			reported["exec "+cmd] = true
+			result.addFinding(RuleRuntimeRiskyExec, 0, cmd, "Executed risky command: "+strings.Join(action.Args, " "), risk/3)
+			sv.log("Runtime: executed risky command: %s", cmd)
+			continue
+		}
+
+		// A rename changes both paths; a link target is only read
+		names := []string{action.Path}
+		if action.Op == RuntimeRename {
+			names = append(names, action.Target)
+		}
+		for _, name := range names {
+			protected := sv.protectedPath(name)
+			if protected == "" || reported[string(action.Op)+" "+name] {
+				continue
+			}
+			reported[string(action.Op)+" "+name] = true
+			message := fmt.Sprintf("Attempted %s of protected path %s", action.Op, name)
+			if action.Error == "" {
+				message = fmt.Sprintf("Performed %s of protected path %s", action.Op, name)
+			}
+			result.addFinding(RuleRuntimeProtected, 0, protected, message, 3)
+			modified = append(modified, name)
+			sv.log("Runtime: %s", message)
+		}
+	}
+	if result.RiskLevel > 10 {
+		result.RiskLevel = 10
+	}
+	result.DetailedInfo["path_modifications"] = modified
+
+	sv.decide(result)
+	return result
+}
+
+// protectedPath returns the protected path that name is, or is below, or ""
+func (sv *ScriptValidator) protectedPath(name string) string {
+	name = path.Clean(name)
+	for _, protected := range sv.protectedPaths {
+		if name == protected || strings.HasPrefix(name, strings.TrimSuffix(protected, "/")+"/") {
+			return protected
+		}
+	}
+	return ""
+}
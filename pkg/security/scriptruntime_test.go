@@ -0,0 +1,69 @@
+package security
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	tests := []struct {
+		name      string
+		exitCode  int
+		actions   []RuntimeAction
+		wantValid bool
+		wantRules []string
+	}{
+		{
+			name:     "Harmless script",
+			exitCode: 0,
+			actions: []RuntimeAction{
+				{Op: RuntimeExec, Path: "/usr/bin/mkdir", Args: []string{"mkdir", "-p", "/var/lib/app"}},
+				{Op: RuntimeMkdir, Path: "/var/lib/app"},
+				{Op: RuntimeWrite, Path: "/var/lib/app/state"},
+			},
+			wantValid: true,
+		},
+		{
+			name:      "Failing script",
+			exitCode:  1,
+			wantValid: false,
+			wantRules: []string{RuleRuntimeFailure},
+		},
+		{
+			name:     "Risky commands are reported once",
+			exitCode: 0,
+			actions: []RuntimeAction{
+				{Op: RuntimeExec, Path: "/usr/bin/systemctl", Args: []string{"systemctl", "enable", "app"}},
+				{Op: RuntimeExec, Path: "/usr/bin/systemctl", Args: []string{"systemctl", "start", "app"}},
+				{Op: RuntimeExec, Path: "/bin/echo", Args: []string{"echo", "done"}},
+			},
+			wantValid: true,
+			wantRules: []string{RuleRuntimeRiskyExec},
+		},
+		{
+			name:     "Attempted write of a protected path",
+			exitCode: 0,
+			actions: []RuntimeAction{
+				{Op: RuntimeWrite, Path: "/usr/bin/evil", Error: "EROFS"},
+				{Op: RuntimeRename, Path: "/etc/shadow+", Target: "/etc/shadow"},
+				{Op: RuntimeLink, Path: "/usr/local/bin/app", Target: "/usr/bin/app"},
+			},
+			wantValid: false,
+			wantRules: []string{RuleRuntimeProtected, RuleRuntimeProtected},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewScriptValidator().ValidateRuntime("postinst", tt.exitCode, tt.actions)
+			var rules []string
+			for _, finding := range result.Findings {
+				rules = append(rules, finding.RuleID)
+			}
+			if result.Valid != tt.wantValid || !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("ValidateRuntime() valid = %v, rules = %v, want %v, %v; findings %+v",
+					result.Valid, rules, tt.wantValid, tt.wantRules, result.Findings)
+			}
+		})
+	}
+}
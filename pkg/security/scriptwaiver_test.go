@@ -0,0 +1,73 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptWaivers(t *testing.T) {
+	script := "#!/bin/sh\nsystemctl restart myapp\nuseradd --system myapp\n"
+
+	t.Run("Waived findings do not count", func(t *testing.T) {
+		sv := NewScriptValidator(WithScriptWaivers(
+			ScriptWaiver{Rule: RuleRiskyCommand, Detail: "systemctl", Justification: "restarts our own service"},
+			ScriptWaiver{Rule: "risky-command", Script: "postinst", Detail: "useradd", Justification: "creates the service user"},
+		))
+		result, err := sv.ValidateScript("postinst", script)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		for _, f := range result.Findings {
+			if f.RuleID == RuleRiskyCommand {
+				t.Errorf("Unexpected risky command finding: %+v", f)
+			}
+		}
+		if len(result.Waived) != 2 {
+			t.Fatalf("Expected 2 waived findings, got %+v", result.Waived)
+		}
+		waived := result.Waived[0]
+		if waived.Script != "postinst" || waived.Line != 2 || waived.Justification != "restarts our own service" {
+			t.Errorf("Unexpected waived finding: %+v", waived)
+		}
+		if s := waived.String(); !strings.Contains(s, "line 2") || !strings.Contains(s, "waived: restarts our own service") {
+			t.Errorf("String() = %q", s)
+		}
+		if result.RiskLevel != 0 {
+			t.Errorf("Expected waived findings not to add risk, got %d", result.RiskLevel)
+		}
+	})
+
+	t.Run("Waiver limited to another script", func(t *testing.T) {
+		sv := NewScriptValidator(WithScriptWaivers(
+			ScriptWaiver{Rule: RuleRiskyCommand, Script: "postrm", Justification: "cleanup"},
+		))
+		result, err := sv.ValidateScript("debian/myapp.postinst", script)
+		if err != nil {
+			t.Fatalf("ValidateScript() error = %v", err)
+		}
+		if len(result.Waived) != 0 {
+			t.Errorf("Expected no waived findings, got %+v", result.Waived)
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		tests := []struct {
+			waiver  ScriptWaiver
+			wantErr string
+		}{
+			{ScriptWaiver{Justification: "x"}, "no rule"},
+			{ScriptWaiver{Rule: RuleRiskyCommand}, "no justification"},
+			{ScriptWaiver{Rule: RuleRiskyCommand, Justification: "  "}, "no justification"},
+			{ScriptWaiver{Rule: RuleRiskyCommand, Script: "config", Justification: "x"}, "unknown maintainer script"},
+		}
+		for _, tt := range tests {
+			err := tt.waiver.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate(%+v) error = %v, want %q", tt.waiver, err, tt.wantErr)
+			}
+		}
+		if err := (ScriptWaiver{Rule: RuleRiskyCommand, Script: "postrm", Justification: "x"}).Validate(); err != nil {
+			t.Errorf("Validate() error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SecretFinding is a likely credential found in a packaged file or a
+// maintainer script
+type SecretFinding struct {
+	Path  string `json:"path"`
+	Line  int    `json:"line"`
+	Rule  string `json:"rule"`  // Secret rule name, such as aws-access-key
+	Match string `json:"match"` // The secret with all but its first characters masked
+}
+
+// String describes where the secret is, without revealing it
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s:%d: possible %s (%s)", f.Path, f.Line, f.Rule, f.Match)
+}
+
+// secretRule matches one kind of secret. The secret is the expression's
+// "secret" group, or the whole match; it must reach minEntropy bits per
+// character, which rules out placeholders such as "changeme".
+type secretRule struct {
+	name       string
+	re         *regexp.Regexp
+	minEntropy float64
+}
+
+// secretRules are the rules ScanSecrets applies to each line
+var secretRules = []secretRule{
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`), 0},
+	{"aws-access-key", regexp.MustCompile(`\b(?P<secret>(?:AKIA|ASIA)[0-9A-Z]{16})\b`), 3},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws.{0,20}(?:secret|private).{0,20}?[=:]\s*["']?(?P<secret>[A-Za-z0-9/+]{40})\b`), 4},
+	{"github-token", regexp.MustCompile(`\b(?P<secret>gh[pousr]_[A-Za-z0-9]{36,})\b`), 3},
+	{"slack-token", regexp.MustCompile(`\b(?P<secret>xox[abprs]-[A-Za-z0-9-]{10,})`), 3},
+	{"bearer-token", regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{20,}=*)`), 3.5},
+	{"password-assignment", regexp.MustCompile(`(?i)(?:password|passwd|secret|api_?key|access_?token|auth_?token)["']?\s*[:=]\s*["']?(?P<secret>[^\s"'$]{12,})`), 3.5},
+}
+
+// maxSecretScanSize is the size above which files are not scanned for
+// secrets; credentials live in small text files
+const maxSecretScanSize = 8 << 20
+
+// ScanSecrets scans text for likely secrets. Binary content, recognised by a
+// NUL byte near the start, is not scanned, and a line longer than 1 MiB, as in
+// minified code, ends the scan.
+func ScanSecrets(path string, r io.Reader) ([]SecretFinding, error) {
+	reader := bufio.NewReader(r)
+	head, err := reader.Peek(8192)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var findings []SecretFinding
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		for _, rule := range secretRules {
+			if secret, ok := rule.find(line); ok {
+				findings = append(findings, SecretFinding{Path: path, Line: lineNumber, Rule: rule.name, Match: maskSecret(secret)})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return findings, err
+	}
+	return findings, nil
+}
+
+// ScanSecretsFile scans the file at file, installed at path, for likely
+// secrets. Files larger than maxSecretScanSize are skipped.
+func ScanSecretsFile(path, file string) ([]SecretFinding, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() || info.Size() > maxSecretScanSize {
+		return nil, err
+	}
+	return ScanSecrets(path, f)
+}
+
+// find returns the secret the rule matches in line, if any
+func (rule secretRule) find(line string) (string, bool) {
+	for _, m := range rule.re.FindAllStringSubmatch(line, -1) {
+		secret := m[0]
+		if i := rule.re.SubexpIndex("secret"); i > 0 {
+			secret = m[i]
+		}
+		if shannonEntropy(secret) >= rule.minEntropy {
+			return secret, true
+		}
+	}
+	return "", false
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len([]rune(s)))
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// maskSecret keeps the first four characters of a secret, enough to
+// recognise it, and masks the rest. Private key headers hold no secret.
+func maskSecret(secret string) string {
+	if strings.HasPrefix(secret, "-----BEGIN") {
+		return secret
+	}
+	runes := []rune(secret)
+	if len(runes) <= 8 {
+		return "********"
+	}
+	return string(runes[:4]) + "********"
+}
+
+// CheckSecrets scans the file at file, packaged as path before
+// transformation, for likely secrets unless the policy's SecretAllowPaths
+// cover the path
+func (v *Validator) CheckSecrets(path, file string) ([]SecretFinding, error) {
+	if v.secretAllow != nil && v.secretAllow.Excluded(strings.TrimPrefix(path, "/"), false) {
+		return nil, nil
+	}
+	findings, err := ScanSecretsFile(path, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s for secrets: %w", path, err)
+	}
+	return findings, nil
+}
@@ -1,21 +1,46 @@
 package security
 
 import (
-	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/pattern"
 )
 
 // SecurityPolicy defines rules for path validation
 type SecurityPolicy struct {
-	ForbiddenPaths    []string // Paths that should never be accessed
-	RestrictedPaths   []string // Paths that require special permissions
-	AllowedExtensions []string // Allowed file extensions
-	MaxPathLength     int      // Maximum allowed path length
-	DisallowDotDot    bool     // Whether to disallow ".." in paths
+	ForbiddenPaths  []string // Paths that should never be accessed
+	RestrictedPaths []string // Paths that require special permissions
+	MaxPathLength   int      // Maximum allowed path length
+	DisallowDotDot  bool     // Whether to disallow ".." in paths
+
+	// Payload limits checked by CheckPayloadFile; 0 means unlimited
+	MaxFileCount   int   // Files, symlinks and other non-directories
+	MaxFileSize    int64 // Bytes of a single file
+	MaxPayloadSize int64 // Bytes of all files
+
+	// FileTypeLocations lists where files of each detected type are expected
+	// (see DefaultFileTypeLocations); CheckFileType reports files elsewhere
+	FileTypeLocations map[FileType][]string
+
+	// SecretAllowPaths are exclude-style patterns of packaged paths, before
+	// transformation, that are not scanned for secrets, such as test
+	// fixtures with sample keys
+	SecretAllowPaths []string
+
+	// AllowedExtensions is no longer checked: extensions say little about
+	// content, so files are judged by their detected type instead.
+	//
+	// Deprecated: use FileTypeLocations.
+	AllowedExtensions []string
 }
 
 // DefaultSecurityPolicy returns the default security policy
@@ -29,30 +54,38 @@ func DefaultSecurityPolicy() *SecurityPolicy {
 			"/etc/passwd", "/etc/shadow", "/etc/sudoers",
 			"/etc/ssh", "/etc/ssl/private",
 		},
-		AllowedExtensions: []string{
-			// Allow common file types
-			".txt", ".conf", ".service", ".socket", ".target", ".sh",
-			".xml", ".json", ".yml", ".yaml", ".desktop", ".png", ".svg",
-			".jpg", ".jpeg", ".gif", ".md", ".html", ".css", ".js",
-		},
-		MaxPathLength:  4096,
-		DisallowDotDot: true,
+		FileTypeLocations: DefaultFileTypeLocations(),
+		MaxPathLength:     4096,
+		DisallowDotDot:    true,
+		MaxFileCount:      DefaultMaxFileCount,
+		MaxFileSize:       DefaultMaxFileSize,
+		MaxPayloadSize:    DefaultMaxPayloadSize,
 	}
 }
 
 // ValidationResult contains the result of a validation check
 type ValidationResult struct {
-	Valid   bool
-	Message string
-	Errors  []error
+	Valid    bool
+	Message  string
+	Errors   []error
+	Findings []ValidationFinding // Structured form of Errors, with the warnings and notes
 }
 
 // Validator provides methods for validating paths and package creation compliance.
 type Validator struct {
 	policy         *SecurityPolicy
-	logFunc        func(string, ...interface{})
-	transformedDir string // Root directory for transformed paths
+	logger         *slog.Logger // Default: slog.Default()
+	transformedDir string       // Root directory for transformed paths
 	verbose        bool
+	strictPaths    bool     // Whether restricted paths are rejected rather than logged
+	exemptPaths    []string // System paths the user accepted shipping at their real location
+	plugins        []ValidatorPlugin
+	typeLocations  map[FileType]*pattern.Matcher // Compiled FileTypeLocations of the policy
+	secretAllow    *pattern.Matcher              // Compiled SecretAllowPaths of the policy
+	workers        int                           // Directories ValidatePackage reads at once (default: number of CPUs)
+
+	permissions *PermissionsPolicy // World-writable paths the permissions audit accepts
+	fixPerms    bool               // Whether the permissions audit fixes insecure modes
 }
 
 // ValidatorOption is a function that modifies a Validator
@@ -65,10 +98,10 @@ func WithPolicy(policy *SecurityPolicy) ValidatorOption {
 	}
 }
 
-// WithLogger sets a custom logging function
-func WithLogger(logFunc func(string, ...interface{})) ValidatorOption {
+// WithLogger sets a custom logger
+func WithLogger(logger *slog.Logger) ValidatorOption {
 	return func(v *Validator) {
-		v.logFunc = logFunc
+		v.logger = logger
 	}
 }
 
@@ -86,13 +119,37 @@ func WithTransformedDir(dir string) ValidatorOption {
 	}
 }
 
+// WithStrictPaths rejects restricted paths instead of only logging a warning
+func WithStrictPaths(strict bool) ValidatorOption {
+	return func(v *Validator) {
+		v.strictPaths = strict
+	}
+}
+
+// WithExemptPaths exempts paths, everything below them and their parent
+// directories from the forbidden and restricted path checks
+func WithExemptPaths(paths []string) ValidatorOption {
+	return func(v *Validator) {
+		for _, path := range paths {
+			v.exemptPaths = append(v.exemptPaths, filepath.Clean(path))
+		}
+	}
+}
+
+// WithWorkers sets the number of directories ValidatePackage reads at once
+func WithWorkers(n int) ValidatorOption {
+	return func(v *Validator) {
+		v.workers = n
+	}
+}
+
 // NewValidator creates a new instance of Validator with optional configuration.
 func NewValidator(opts ...ValidatorOption) *Validator {
 	v := &Validator{
 		policy:         DefaultSecurityPolicy(),
 		transformedDir: "/opt",
-		logFunc:        func(format string, args ...interface{}) { fmt.Printf(format+"\n", args...) },
 		verbose:        false,
+		plugins:        registeredValidatorPlugins(),
 	}
 
 	// Apply options
@@ -100,31 +157,65 @@ func NewValidator(opts ...ValidatorOption) *Validator {
 		opt(v)
 	}
 
+	locations, err := compileFileTypeLocations(v.policy.FileTypeLocations)
+	if err != nil {
+		logging.Logf(v.logger, slog.LevelWarn, "ignoring invalid file type locations: %v", err)
+	}
+	v.typeLocations = locations
+
+	if len(v.policy.SecretAllowPaths) > 0 {
+		allow, err := pattern.NewMatcher(v.policy.SecretAllowPaths, nil)
+		if err != nil {
+			logging.Logf(v.logger, slog.LevelWarn, "ignoring invalid secret allow paths: %v", err)
+		}
+		v.secretAllow = allow
+	}
+
 	return v
 }
 
-// log writes messages to the configured log function if verbose is enabled
-func (v *Validator) log(format string, args ...interface{}) {
-	if v.verbose {
-		v.logFunc(format, args...)
+// SetLogger sets the logger for verbose messages
+func (v *Validator) SetLogger(logger *slog.Logger) {
+	if logger != nil {
+		v.logger = logger
 	}
 }
 
+// SetWorkers sets the number of directories ValidatePackage reads at once;
+// 0 uses the number of CPUs
+func (v *Validator) SetWorkers(n int) {
+	v.workers = n
+}
+
+// log writes messages to the logger at info level if verbose is enabled,
+// and at debug level otherwise
+func (v *Validator) log(format string, args ...interface{}) {
+	logging.Logf(v.logger, logging.Verbose(v.verbose), format, args...)
+}
+
 // ValidatePath checks if the provided path is compliant with security policies.
-// It returns an error if the path is invalid or if it violates any security rules.
+// It returns an error if the path is invalid or if it violates any security rules;
+// the finding behind it is available through FindingOf.
 func (v *Validator) ValidatePath(path string) error {
+	return v.firstError(v.CheckPath(path))
+}
+
+// CheckPath checks path against the security policy and returns its
+// findings, up to the first error. Warnings and notes, such as a restricted
+// path outside strict mode, do not stop the check.
+func (v *Validator) CheckPath(path string) []ValidationFinding {
 	if path == "" {
-		return errors.New("path cannot be empty")
+		return []ValidationFinding{newFinding(CodeInvalidPath, SeverityError, path, "path cannot be empty")}
 	}
 
 	// Path must be absolute
 	if !filepath.IsAbs(path) {
-		return errors.New("path must be absolute")
+		return []ValidationFinding{newFinding(CodeInvalidPath, SeverityError, path, "path must be absolute")}
 	}
 
 	// Check path length
 	if len(path) > v.policy.MaxPathLength {
-		return fmt.Errorf("path exceeds maximum length of %d characters", v.policy.MaxPathLength)
+		return []ValidationFinding{newFinding(CodePathTooLong, SeverityError, path, "path exceeds maximum length of %d characters", v.policy.MaxPathLength)}
 	}
 
 	// Normalize the path (clean up any . or .. segments)
@@ -135,62 +226,73 @@ func (v *Validator) ValidatePath(path string) error {
 	if cleanPath != path && v.policy.DisallowDotDot {
 		// Some slight differences are acceptable (like trailing slashes), so check if dots were involved
 		if strings.Contains(path, "..") {
-			return fmt.Errorf("path contains forbidden '..' sequences: %s", path)
+			return []ValidationFinding{newFinding(CodeDotDot, SeverityError, path, "path contains forbidden '..' sequences: %s", path)}
 		}
 	}
 
+	if v.isExempt(cleanPath) {
+		return []ValidationFinding{newFinding(CodeExemptPath, SeverityNote, path, "Exempted system path: %s", path)}
+	}
+
 	// Check for forbidden paths
 	for _, forbiddenPath := range v.policy.ForbiddenPaths {
 		if cleanPath == forbiddenPath || strings.HasPrefix(cleanPath, forbiddenPath+"/") {
-			return fmt.Errorf("path access forbidden: %s", path)
+			return []ValidationFinding{newFinding(CodeForbiddenPath, SeverityError, path, "path access forbidden: %s", path)}
 		}
 	}
 
-	// Check for restricted paths
+	// Check for restricted paths; outside strict mode they are only a warning
+	var findings []ValidationFinding
 	for _, restrictedPath := range v.policy.RestrictedPaths {
 		if cleanPath == restrictedPath || strings.HasPrefix(cleanPath, restrictedPath+"/") {
-			v.log("Warning: Accessing restricted path: %s", path)
-			// We don't return an error here, just log a warning
+			if v.strictPaths {
+				return append(findings, newFinding(CodeRestrictedPath, SeverityError, path, "path access restricted: %s", path))
+			}
+			findings = append(findings, newFinding(CodeRestrictedPath, SeverityWarning, path, "Accessing restricted path: %s", path))
 		}
 	}
 
-	// Check if this path is within the transformed directory structure
-	if strings.HasPrefix(cleanPath, v.transformedDir) {
-		// This is already a transformed path, which is allowed
-		return nil
-	}
-
-	// At this point, the path should be scheduled for transformation
-	// We'll still validate further aspects
-
-	// File extension check for non-directories
-	// Skip this check if the path looks like a directory (ends with /)
-	if !strings.HasSuffix(cleanPath, "/") {
-		ext := filepath.Ext(cleanPath)
-		if ext != "" {
-			validExt := false
-			for _, allowedExt := range v.policy.AllowedExtensions {
-				if ext == allowedExt {
-					validExt = true
-					break
-				}
-			}
+	// Paths within the transformed directory structure are already
+	// transformed; others are scheduled for transformation. The content of
+	// files is checked by CheckFileType, which unlike the name tells an
+	// extensionless binary from a data file.
+	return findings
+}
 
-			if !validExt {
-				v.log("Warning: File has potentially unsafe extension: %s", ext)
-				// We don't fail here, just log a warning
-			}
+// firstError logs the warnings and notes among findings, and returns the
+// first error as a *ValidationError
+func (v *Validator) firstError(findings []ValidationFinding) error {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return &ValidationError{Finding: f}
 		}
+		v.log("Warning: %s", f.Message)
 	}
-
 	return nil
 }
 
+// isExempt reports whether path is an exempted path, lies below one, or is a
+// parent directory of one
+func (v *Validator) isExempt(path string) bool {
+	for _, exempt := range v.exemptPaths {
+		if path == exempt || strings.HasPrefix(path, exempt+"/") || strings.HasPrefix(exempt, strings.TrimSuffix(path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidatePathTraversal provides an in-depth check for path traversal attempts
 // with comprehensive detection of encoding variations and evasion techniques.
 func (v *Validator) ValidatePathTraversal(path string) error {
+	return v.firstError(v.CheckPathTraversal(path))
+}
+
+// CheckPathTraversal returns the findings of ValidatePathTraversal, up to
+// the first error
+func (v *Validator) CheckPathTraversal(path string) []ValidationFinding {
 	if path == "" {
-		return errors.New("path cannot be empty")
+		return []ValidationFinding{newFinding(CodeInvalidPath, SeverityError, path, "path cannot be empty")}
 	}
 
 	// Normalize path for consistent checking
@@ -202,7 +304,7 @@ func (v *Validator) ValidatePathTraversal(path string) error {
 		parts := strings.Split(normalizedPath, "/")
 		for i, part := range parts {
 			if part == ".." && i > 0 {
-				return errors.New("path traversal detected: contains '..' patterns")
+				return []ValidationFinding{newFinding(CodePathTraversal, SeverityError, path, "path traversal detected: contains '..' patterns")}
 			}
 		}
 	}
@@ -224,7 +326,7 @@ func (v *Validator) ValidatePathTraversal(path string) error {
 
 	for _, encoded := range encodedDotDot {
 		if strings.Contains(path, encoded) {
-			return fmt.Errorf("encoded path traversal attempt detected: contains '%s'", encoded)
+			return []ValidationFinding{newFinding(CodePathTraversal, SeverityError, path, "encoded path traversal attempt detected: contains '%s'", encoded)}
 		}
 	}
 
@@ -239,7 +341,7 @@ func (v *Validator) ValidatePathTraversal(path string) error {
 
 	for _, unicode := range unicodeDotDot {
 		if strings.Contains(path, unicode) {
-			return fmt.Errorf("unicode path traversal attempt detected: contains '%s'", unicode)
+			return []ValidationFinding{newFinding(CodePathTraversal, SeverityError, path, "unicode path traversal attempt detected: contains '%s'", unicode)}
 		}
 	}
 
@@ -252,14 +354,14 @@ func (v *Validator) ValidatePathTraversal(path string) error {
 
 		for _, segment := range segments {
 			if segment == ".." {
-				return errors.New("path traversal detected with multiple slashes")
+				return []ValidationFinding{newFinding(CodePathTraversal, SeverityError, path, "path traversal detected with multiple slashes")}
 			}
 		}
 	}
 
 	// Check for null byte injection which could truncate paths in some systems
 	if strings.Contains(path, "\x00") {
-		return errors.New("null byte detected in path")
+		return []ValidationFinding{newFinding(CodePathTraversal, SeverityError, path, "null byte detected in path")}
 	}
 
 	// Check for unusual path elements that might be interpreted specially
@@ -269,18 +371,34 @@ func (v *Validator) ValidatePathTraversal(path string) error {
 		"`", // Command substitution in some contexts
 	}
 
+	// Don't fail but warn as these might be legitimate in some contexts
+	var findings []ValidationFinding
 	for _, element := range unusualElements {
 		if strings.Contains(path, element) {
-			v.log("Warning: Path contains potentially problematic element: %s", element)
-			// Don't fail but log a warning as these might be legitimate in some contexts
+			findings = append(findings, newFinding(CodeUnusualPathElement, SeverityWarning, path, "Path contains potentially problematic element: %s", element))
 		}
 	}
 
-	return nil
+	return findings
 }
 
-// ValidateSymlink checks if a symlink from source to target is allowed
+// ValidateSymlink checks if a symlink from source to target is allowed.
+// Existing symlinks along both paths are followed, up to MaxSymlinkDepth: a
+// source in the transformed root must still resolve inside it, the resolved
+// target must not be forbidden, and neither may lead back to the other.
 func (v *Validator) ValidateSymlink(source, target string) error {
+	return v.validateSymlink(source, target, false)
+}
+
+// ValidateSymlinkReplacement is ValidateSymlink for a symlink that replaces
+// the file or symlink at target, as with symlink create --force. Directories
+// are never replaced.
+func (v *Validator) ValidateSymlinkReplacement(source, target string) error {
+	return v.validateSymlink(source, target, true)
+}
+
+// validateSymlink implements ValidateSymlink and ValidateSymlinkReplacement
+func (v *Validator) validateSymlink(source, target string, replace bool) error {
 	// First validate both paths
 	if err := v.ValidatePath(source); err != nil {
 		return fmt.Errorf("invalid symlink source: %w", err)
@@ -291,35 +409,82 @@ func (v *Validator) ValidateSymlink(source, target string) error {
 	}
 
 	// Ensure the target is not a forbidden path
-	for _, forbiddenPath := range v.policy.ForbiddenPaths {
-		if target == forbiddenPath || strings.HasPrefix(target, forbiddenPath+"/") {
-			return fmt.Errorf("symlink target points to forbidden path: %s", target)
-		}
+	if forbidden := v.forbiddenPath(target); forbidden != "" {
+		return failure(CodeForbiddenPath, target, "symlink target points to forbidden path: %s", target)
 	}
 
 	// If target already exists, prevent overwriting
-	if _, err := os.Lstat(target); err == nil {
-		return fmt.Errorf("symlink target already exists: %s", target)
+	if info, err := os.Lstat(target); err == nil {
+		if !replace {
+			return failure(CodeInvalidSymlink, target, "symlink target already exists: %s", target)
+		}
+		if info.IsDir() {
+			return failure(CodeInvalidSymlink, target, "symlink target is a directory: %s", target)
+		}
+	}
+
+	resolvedSource, err := ResolveSymlinks(source)
+	if err != nil {
+		return fmt.Errorf("invalid symlink source: %w", err)
+	}
+	// A source in the transformed root must not escape it through a symlink;
+	// the root may itself be a symlink, such as /opt -> /var/opt
+	root := filepath.Clean(v.transformedDir)
+	resolvedRoot, err := ResolveSymlinks(root)
+	if err != nil {
+		resolvedRoot = root
+	}
+	if within(filepath.Clean(source), root) && !within(resolvedSource, root) && !within(resolvedSource, resolvedRoot) {
+		return failure(CodeInvalidSymlink, source, "symlink source %s resolves to %s, outside %s", source, resolvedSource, root)
+	}
+
+	// The target itself is created or replaced, but its parent directories
+	// may be symlinks
+	resolvedParent, err := ResolveSymlinks(filepath.Dir(target))
+	if err != nil {
+		return fmt.Errorf("invalid symlink target: %w", err)
+	}
+	resolvedTarget := filepath.Join(resolvedParent, filepath.Base(target))
+	if forbidden := v.forbiddenPath(resolvedTarget); forbidden != "" {
+		return failure(CodeForbiddenPath, target, "symlink target %s resolves to forbidden path %s", target, resolvedTarget)
 	}
 
-	// Check if the symlink would create a cycle
-	if strings.HasPrefix(target, source) {
-		return fmt.Errorf("symlink would create a cycle: %s -> %s", source, target)
+	// A link inside the directory it points to creates a directory cycle, and
+	// a source below the link can never be resolved
+	if within(resolvedTarget, resolvedSource) || within(resolvedSource, resolvedTarget) {
+		return failure(CodeInvalidSymlink, source, "symlink would create a cycle: %s -> %s", source, target)
 	}
 
 	return nil
 }
 
+// forbiddenPath returns the forbidden path of the policy that path is or lies
+// below, or "" if there is none
+func (v *Validator) forbiddenPath(path string) string {
+	for _, forbiddenPath := range v.policy.ForbiddenPaths {
+		if within(path, forbiddenPath) {
+			return forbiddenPath
+		}
+	}
+	return ""
+}
+
 // ValidatePackageFile checks if a file is allowed in a Debian package
 func (v *Validator) ValidatePackageFile(path string, isDir bool) *ValidationResult {
+	return v.packageFileResult(path, isDir, v.CheckPath(path))
+}
+
+// packageFileResult returns the result of a package file whose path has the
+// findings, adding a note for scripts. Warnings and notes are logged.
+func (v *Validator) packageFileResult(path string, isDir bool, findings []ValidationFinding) *ValidationResult {
 	result := &ValidationResult{
-		Valid:   true,
-		Message: "File validation passed",
-		Errors:  []error{},
+		Valid:    true,
+		Message:  "File validation passed",
+		Errors:   []error{},
+		Findings: findings,
 	}
 
-	// Validate path first
-	if err := v.ValidatePath(path); err != nil {
+	if err := v.firstError(findings); err != nil {
 		result.Valid = false
 		result.Errors = append(result.Errors, err)
 		result.Message = "Path validation failed"
@@ -328,106 +493,268 @@ func (v *Validator) ValidatePackageFile(path string, isDir bool) *ValidationResu
 
 	// Check for potentially dangerous file patterns if not a directory
 	if !isDir {
-		// Check for executable scripts
-		if strings.HasSuffix(path, ".sh") || strings.HasSuffix(path, ".bash") ||
-			strings.HasSuffix(path, ".py") || strings.HasSuffix(path, ".pl") {
-			v.log("Warning: Package contains executable script: %s", path)
-			// We don't fail validation, just log a warning
-		}
-
-		// Check for setuid/setgid files (actual permissions will be checked elsewhere)
-		if regexp.MustCompile(`\.(sh|bash|py|pl|rb)$`).MatchString(path) {
-			v.log("Warning: Package contains script that could potentially be setuid/setgid: %s", path)
+		// Check for executable scripts; we don't fail validation, just note them
+		if isScriptName(path) {
+			finding := newFinding(CodeScriptFile, SeverityNote, path, "Package contains executable script: %s", path)
+			v.log("Warning: %s", finding.Message)
+			result.Findings = append(result.Findings, finding)
 		}
+		// Setuid, setgid and world-writable modes are checked by the builder,
+		// which knows the packaged mode of each file
 	}
 
 	return result
 }
 
-// ValidatePackage performs comprehensive validation of a Debian package structure
+// validDebianFiles are the files allowed in the DEBIAN directory
+var validDebianFiles = map[string]bool{
+	"control": true, "preinst": true, "postinst": true,
+	"prerm": true, "postrm": true, "conffiles": true,
+	"shlibs": true, "triggers": true, "md5sums": true,
+}
+
+// ValidatePackage performs comprehensive validation of a Debian package
+// structure. Directories are read concurrently, and the policy is checked
+// once per directory: below a directory that no forbidden, restricted or
+// exempt path overlaps, files only need the length check. A rejected package
+// returns a *ValidationError holding the report.
 func (v *Validator) ValidatePackage(packageDir string) error {
+	report, err := v.ValidatePackageReport(packageDir)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// ValidatePackageReport validates a package like ValidatePackage and returns
+// all of its findings, sorted by path, so callers can decide which of them
+// fail the build. The error is only set when the package cannot be read or
+// a plugin fails to run.
+func (v *Validator) ValidatePackageReport(packageDir string) (*Report, error) {
 	// Check if the package directory exists
 	info, err := os.Stat(packageDir)
 	if err != nil {
-		return fmt.Errorf("package directory error: %w", err)
+		return nil, fmt.Errorf("package directory error: %w", err)
 	}
 
 	if !info.IsDir() {
-		return fmt.Errorf("package path is not a directory: %s", packageDir)
+		return nil, fmt.Errorf("package path is not a directory: %s", packageDir)
 	}
 
 	// Check for required DEBIAN directory and control file
+	report := &Report{}
 	debianDir := filepath.Join(packageDir, "DEBIAN")
 	controlFile := filepath.Join(debianDir, "control")
 
 	if _, err := os.Stat(debianDir); os.IsNotExist(err) {
-		return errors.New("DEBIAN directory missing from package")
+		report.Add(newFinding(CodePackageStructure, SeverityError, "/DEBIAN", "DEBIAN directory missing from package"))
+		return report, nil
 	}
 
 	if _, err := os.Stat(controlFile); os.IsNotExist(err) {
-		return errors.New("control file missing from package")
+		report.Add(newFinding(CodePackageStructure, SeverityError, "/DEBIAN/control", "control file missing from package"))
+		return report, nil
 	}
 
 	// Check all files in the package
-	var invalidFiles []string
-	err = filepath.Walk(packageDir, func(path string, info os.FileInfo, err error) error {
-		// Skip the DEBIAN directory itself in validation
-		if path == debianDir {
-			return nil
-		}
-
-		// Get relative path from package directory for validation
-		relPath, err := filepath.Rel(packageDir, path)
+	var mu sync.Mutex
+	var packagePaths []string
+	var issues []PermissionIssue
+	err = walkDirs(packageDir, v.workerCount(), v.subtreeClean("/"), func(dir string, entries []os.DirEntry, clean bool) (map[string]bool, error) {
+		relDir, err := filepath.Rel(packageDir, dir)
 		if err != nil {
-			return fmt.Errorf("failed to get relative path: %w", err)
-		}
-
-		// Skip the package root
-		if relPath == "." {
-			return nil
+			return nil, fmt.Errorf("failed to get relative path: %w", err)
 		}
+		relDir = filepath.ToSlash(relDir)
+
+		var findings []ValidationFinding
+		var paths []string
+		var dirIssues []PermissionIssue
+		childClean := make(map[string]bool)
+		for _, entry := range entries {
+			relPath := path.Join(relDir, entry.Name())
+
+			// Only specific files are allowed in the DEBIAN directory
+			if relPath == "DEBIAN" || strings.HasPrefix(relPath, "DEBIAN/") {
+				if entry.IsDir() {
+					childClean[entry.Name()] = false
+				} else if !validDebianFiles[entry.Name()] {
+					findings = append(findings, newFinding(CodePackageStructure, SeverityError, "/"+relPath, "Invalid file in DEBIAN directory: %s", relPath))
+					v.log("Invalid file in DEBIAN directory: %s", relPath)
+				}
+				continue
+			}
 
-		// If this is the DEBIAN directory contents, apply special rules
-		if strings.HasPrefix(relPath, "DEBIAN/") {
-			// Only specific files are allowed in DEBIAN directory
-			validDebianFiles := map[string]bool{
-				"control": true, "preinst": true, "postinst": true,
-				"prerm": true, "postrm": true, "conffiles": true,
-				"shlibs": true, "triggers": true,
+			// For regular package files, validate the absolute installed path
+			absPath := "/" + relPath
+			paths = append(paths, absPath)
+			result := v.validatePackageEntry(absPath, entry.IsDir(), clean)
+			findings = append(findings, result.Findings...)
+			if !result.Valid {
+				for _, err := range result.Errors {
+					v.log("Invalid package file (%s): %v", relPath, err)
+				}
+			}
+			if entry.IsDir() {
+				childClean[entry.Name()] = clean || v.subtreeClean(absPath)
 			}
 
-			baseName := filepath.Base(relPath)
-			if !validDebianFiles[baseName] && !info.IsDir() {
-				invalidFiles = append(invalidFiles, relPath)
-				v.log("Invalid file in DEBIAN directory: %s", relPath)
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			issue, err := v.auditPermissions(filepath.Join(dir, entry.Name()), absPath, info)
+			if err != nil {
+				return nil, err
 			}
+			if issue != nil {
+				dirIssues = append(dirIssues, *issue)
+			}
+		}
+
+		mu.Lock()
+		report.Add(findings...)
+		packagePaths = append(packagePaths, paths...)
+		issues = append(issues, dirIssues...)
+		mu.Unlock()
+		return childClean, nil
+	})
 
-			return nil
+	if err != nil {
+		return nil, fmt.Errorf("error walking package directory: %w", err)
+	}
+
+	// Insecure modes fail strict validation unless they were fixed
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Path < issues[j].Path })
+	report.Permissions = issues
+	for _, issue := range issues {
+		severity := SeverityWarning
+		switch {
+		case issue.Fixed:
+			severity = SeverityNote
+			v.log("Fixed permissions: %s", issue)
+		case v.strictPaths:
+			severity = SeverityError
+		default:
+			logging.Logf(v.logger, slog.LevelWarn, "Insecure permissions: %s", issue)
 		}
+		report.Add(newFinding(CodeInsecurePermissions, severity, issue.Path, "%s", issue))
+	}
+
+	sort.Strings(packagePaths)
+	findings, err := v.runPlugins(packageDir, packagePaths)
+	if err != nil {
+		return nil, err
+	}
+	report.Add(findings...)
+	report.Sort()
+	return report, nil
+}
+
+// validatePackageEntry is ValidatePackageFile for a clean absolute path
+// found in the package. Below a directory the policy cannot affect, only the
+// length of the path is checked.
+func (v *Validator) validatePackageEntry(path string, isDir, clean bool) *ValidationResult {
+	if !clean {
+		return v.ValidatePackageFile(path, isDir)
+	}
+	var findings []ValidationFinding
+	if len(path) > v.policy.MaxPathLength {
+		findings = append(findings, newFinding(CodePathTooLong, SeverityError, path, "path exceeds maximum length of %d characters", v.policy.MaxPathLength))
+	}
+	return v.packageFileResult(path, isDir, findings)
+}
 
-		// For regular package files, get the absolute path for validation
-		absPath := filepath.Join("/", relPath)
-		result := v.ValidatePackageFile(absPath, info.IsDir())
+// isScriptName reports whether a file name has the extension of a script
+func isScriptName(path string) bool {
+	return strings.HasSuffix(path, ".sh") || strings.HasSuffix(path, ".bash") ||
+		strings.HasSuffix(path, ".py") || strings.HasSuffix(path, ".pl")
+}
 
-		if !result.Valid {
-			invalidFiles = append(invalidFiles, relPath)
-			for _, err := range result.Errors {
-				v.log("Invalid package file (%s): %v", relPath, err)
+// subtreeClean reports whether no forbidden, restricted or exempt path of
+// the policy is dir, lies below it or contains it, so ValidatePath accepts
+// every clean path below dir that is not too long
+func (v *Validator) subtreeClean(dir string) bool {
+	for _, paths := range [][]string{v.policy.ForbiddenPaths, v.policy.RestrictedPaths, v.exemptPaths} {
+		for _, p := range paths {
+			if within(p, dir) || within(dir, p) {
+				return false
 			}
 		}
+	}
+	return true
+}
 
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("error walking package directory: %w", err)
+// workerCount returns the number of directories ValidatePackage reads at once
+func (v *Validator) workerCount() int {
+	if v.workers > 0 {
+		return v.workers
 	}
+	return runtime.NumCPU()
+}
+
+// walkDirs reads root and every directory below it, without following
+// symlinks, with up to workers directories read at once. visit receives the
+// entries of each directory and the clean flag it was queued with, and
+// returns the clean flag of each subdirectory to descend into.
+func walkDirs(root string, workers int, clean bool, visit func(dir string, entries []os.DirEntry, clean bool) (map[string]bool, error)) error {
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, workers)
+
+	var walk func(dir string, clean bool)
+	walk = func(dir string, clean bool) {
+		defer wg.Done()
+		errMu.Lock()
+		failed := firstErr != nil
+		errMu.Unlock()
+		if failed {
+			return
+		}
 
-	if len(invalidFiles) > 0 {
-		return fmt.Errorf("package contains %d invalid files", len(invalidFiles))
+		sem <- struct{}{}
+		entries, err := os.ReadDir(dir)
+		var subdirs map[string]bool
+		if err == nil {
+			subdirs, err = visit(dir, entries, clean)
+		}
+		<-sem
+		if err != nil {
+			errMu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMu.Unlock()
+			return
+		}
+		for name, subClean := range subdirs {
+			wg.Add(1)
+			go walk(filepath.Join(dir, name), subClean)
+		}
 	}
 
-	return nil
+	wg.Add(1)
+	walk(root, clean)
+	wg.Wait()
+	return firstErr
+}
+
+// runPlugins runs the validator plugins on the packaged paths and returns
+// the problems they report as findings
+func (v *Validator) runPlugins(packageDir string, paths []string) ([]ValidationFinding, error) {
+	var findings []ValidationFinding
+	for _, plugin := range v.plugins {
+		found, err := plugin.ValidatePackage(packageDir, paths)
+		if err != nil {
+			return nil, fmt.Errorf("validator plugin %s: %w", plugin.Name(), err)
+		}
+		for _, problem := range found {
+			v.log("Plugin %s: %s", plugin.Name(), problem)
+			findings = append(findings, newFinding(CodePluginRejection, SeverityError, "", "%s: %s", plugin.Name(), problem))
+		}
+	}
+	return findings, nil
 }
 
 // WarnAboutHome warns if an application attempts to place files in /opt/home.
@@ -1,9 +1,11 @@
 package security
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 )
 
@@ -49,6 +51,22 @@ func TestValidateSymlink(t *testing.T) {
 		t.Fatalf("Failed to create existing file: %v", err)
 	}
 
+	// Existing symlinks that the validator has to follow
+	os.MkdirAll(filepath.Join(tmpDir, "app-2.0"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "dir"), 0755)
+	for link, target := range map[string]string{
+		"current": filepath.Join(tmpDir, "app-2.0"),
+		"escape":  "/etc",
+		"loop1":   "loop2",
+		"loop2":   "loop1",
+		"tools":   "/usr/bin",
+		"alias":   "dir",
+	} {
+		if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
 	validator := NewValidator(WithTransformedDir(tmpDir))
 
 	tests := []struct {
@@ -61,6 +79,11 @@ func TestValidateSymlink(t *testing.T) {
 		{"Target exists", filepath.Join(tmpDir, "source.txt"), existingFile, true},
 		{"Forbidden target", filepath.Join(tmpDir, "source.txt"), "/bin/bash", true},
 		{"Cyclic symlink", filepath.Join(tmpDir, "parent"), filepath.Join(tmpDir, "parent/child"), true},
+		{"Source through a symlink inside the root", filepath.Join(tmpDir, "current/bin/app"), filepath.Join(tmpDir, "app"), false},
+		{"Source escaping the root", filepath.Join(tmpDir, "escape/shadow"), filepath.Join(tmpDir, "shadow"), true},
+		{"Symlink loop", filepath.Join(tmpDir, "loop1/app"), filepath.Join(tmpDir, "app"), true},
+		{"Target resolving to a forbidden path", filepath.Join(tmpDir, "source.txt"), filepath.Join(tmpDir, "tools/new-tool"), true},
+		{"Cycle through a symlink", filepath.Join(tmpDir, "alias"), filepath.Join(tmpDir, "dir/link"), true},
 	}
 
 	for _, tt := range tests {
@@ -71,6 +94,28 @@ func TestValidateSymlink(t *testing.T) {
 			}
 		})
 	}
+
+	replacements := []struct {
+		name    string
+		source  string
+		target  string
+		wantErr bool
+	}{
+		{"Replace existing file", filepath.Join(tmpDir, "source.txt"), existingFile, false},
+		{"Replace existing symlink", filepath.Join(tmpDir, "source.txt"), filepath.Join(tmpDir, "escape"), false},
+		{"Replace missing target", filepath.Join(tmpDir, "source.txt"), filepath.Join(tmpDir, "target.txt"), false},
+		{"Replace directory", filepath.Join(tmpDir, "source.txt"), filepath.Join(tmpDir, "dir"), true},
+		{"Replace forbidden target", filepath.Join(tmpDir, "source.txt"), filepath.Join(tmpDir, "tools/ls"), true},
+	}
+
+	for _, tt := range replacements {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateSymlinkReplacement(tt.source, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSymlinkReplacement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
 }
 
 func TestValidatePackage(t *testing.T) {
@@ -160,3 +205,104 @@ func TestValidatePathTraversalEnhanced(t *testing.T) {
 		})
 	}
 }
+
+// writePackageTree creates a package directory with a control file and the
+// given payload files
+func writePackageTree(tb testing.TB, files []string) string {
+	dir, err := ioutil.TempDir("", "package-test-")
+	if err != nil {
+		tb.Fatalf("Failed to create temp dir: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	for _, file := range append([]string{"DEBIAN/control"}, files...) {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			tb.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+	return dir
+}
+
+func TestValidatePackageTree(t *testing.T) {
+	var payload []string
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			payload = append(payload, fmt.Sprintf("opt/demo/dir%d/sub%d/file.sh", i, j))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		extra   []string
+		wantErr bool
+	}{
+		{"Clean payload", nil, false},
+		{"Forbidden path", []string{"usr/bin/tool"}, true},
+		{"Forbidden path in a nested directory", []string{"sbin/deep/er/tool"}, true},
+		{"Unknown DEBIAN file", []string{"DEBIAN/junk"}, true},
+		{"Unknown file in a DEBIAN subdirectory", []string{"DEBIAN/sub/junk"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writePackageTree(t, append(append([]string(nil), payload...), tt.extra...))
+			var plugin recordingPlugin
+			v := NewValidator(WithWorkers(4))
+			v.plugins = []ValidatorPlugin{&plugin}
+			err := v.ValidatePackage(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidatePackage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (len(plugin.paths) == 0 || !sort.StringsAreSorted(plugin.paths)) {
+				t.Errorf("Plugins should receive the sorted paths, got %d paths", len(plugin.paths))
+			}
+		})
+	}
+}
+
+func TestValidatePackageExemptPath(t *testing.T) {
+	dir := writePackageTree(t, []string{"usr/bin/tool", "opt/demo/file"})
+	if err := NewValidator(WithExemptPaths([]string{"/usr/bin/tool"})).ValidatePackage(dir); err != nil {
+		t.Errorf("ValidatePackage() of an exempt path error = %v", err)
+	}
+}
+
+// recordingPlugin records the paths it is asked to validate
+type recordingPlugin struct {
+	paths []string
+}
+
+func (p *recordingPlugin) Name() string { return "recording" }
+
+func (p *recordingPlugin) ValidatePackage(packageDir string, paths []string) ([]string, error) {
+	p.paths = paths
+	return nil, nil
+}
+
+// BenchmarkValidatePackage validates a payload of 10,000 files in 1,000
+// directories
+func BenchmarkValidatePackage(b *testing.B) {
+	var payload []string
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			for k := 0; k < 10; k++ {
+				payload = append(payload, fmt.Sprintf("opt/demo/lib%d/mod%d/file%d.py", i, j, k))
+			}
+		}
+	}
+	dir := writePackageTree(b, payload)
+
+	for _, workers := range []int{1, 4, 0} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			v := NewValidator(WithWorkers(workers))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := v.ValidatePackage(dir); err != nil {
+					b.Fatalf("ValidatePackage() error = %v", err)
+				}
+			}
+		})
+	}
+}
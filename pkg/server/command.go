@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/privilege"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/go-i2p/go-pkginstall/pkg/telemetry"
+	"github.com/spf13/cobra"
+)
+
+// tokenEnv is consulted when no token is given on the command line, so the
+// token does not have to appear in process listings or shell history
+const tokenEnv = "PKGINSTALL_SERVE_TOKEN"
+
+// ServeOptions contains the options of the serve command
+type ServeOptions struct {
+	Listen        string
+	Token         string
+	DataDir       string
+	AllowedPaths  []string
+	Jobs          int
+	MaxUploadMB   int64
+	Profile       string
+	AllowProfiles []string
+	TLSCert       string
+	TLSKey        string
+	ShutdownGrace time.Duration
+	OTLPEndpoint  string
+	AllowRoot     bool
+}
+
+// NewServeCommand creates a command running the build service
+func NewServeCommand() *cobra.Command {
+	options := &ServeOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a shared build service with an HTTP API",
+		Long: `Run pkginstall as a build service. Clients authenticate with a bearer
+token, submit build jobs by uploading a tar.gz of the payload or by
+referencing a directory below an --allow-path, follow the build log and
+download the resulting .deb.
+
+The token is read from --token or the ` + tokenEnv + ` environment variable.
+Use --tls-cert and --tls-key when the service is reachable from other hosts.
+
+Endpoints:
+  POST   /v1/builds               submit a job
+  GET    /v1/builds               list jobs
+  GET    /v1/builds/{id}          job status and build report
+  GET    /v1/builds/{id}/log      build log (?follow=true streams it)
+  GET    /v1/builds/{id}/package  download the .deb
+  DELETE /v1/builds/{id}          remove a finished job
+  GET    /metrics                 build metrics for Prometheus
+
+Examples:
+  pkginstall serve --listen :8443 --tls-cert cert.pem --tls-key key.pem --data-dir /var/lib/pkginstall
+  curl -H "Authorization: Bearer $TOKEN" -F 'request={"name":"myapp","version":"1.0","maintainer":"Me <me@example.org>","description":"My app"}' \
+       -F source=@payload.tar.gz https://build.example.org:8443/v1/builds
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServeCommand(cmd.Context(), options)
+		},
+	}
+
+	cmd.Flags().StringVar(&options.Listen, "listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().StringVar(&options.Token, "token", "", "Bearer token clients must present (prefer $"+tokenEnv+")")
+	cmd.Flags().StringVar(&options.DataDir, "data-dir", "", "Directory for job sources and packages (default: a temporary directory removed on exit)")
+	cmd.Flags().StringSliceVar(&options.AllowedPaths, "allow-path", nil, "Server directory jobs may reference as their source (repeatable)")
+	cmd.Flags().IntVarP(&options.Jobs, "jobs", "j", 1, "Number of concurrent builds")
+	cmd.Flags().Int64Var(&options.MaxUploadMB, "max-upload-size", DefaultMaxUploadSize>>20, "Maximum size of an uploaded source archive in MiB")
+	cmd.Flags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile of jobs that do not select one ("+strings.Join(security.ProfileNames(), ", ")+")")
+	cmd.Flags().StringSliceVar(&options.AllowProfiles, "allow-profile", nil,
+		"Profile weaker than --profile that jobs may select (repeatable)")
+	cmd.Flags().StringVar(&options.TLSCert, "tls-cert", "", "TLS certificate file")
+	cmd.Flags().StringVar(&options.TLSKey, "tls-key", "", "TLS private key file")
+	cmd.Flags().StringVar(&options.OTLPEndpoint, "otlp-endpoint", telemetry.EndpointFromEnv(),
+		"Export build traces to this OTLP/HTTP collector (default: $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	cmd.Flags().BoolVar(&options.AllowRoot, "allow-root", false, privilege.AllowRootUsage)
+	cmd.Flags().DurationVar(&options.ShutdownGrace, "shutdown-timeout", 10*time.Second, "Time to wait for open requests on shutdown")
+
+	return cmd
+}
+
+// runServeCommand serves the API until ctx is cancelled
+func runServeCommand(ctx context.Context, options *ServeOptions) error {
+	if err := privilege.CheckRoot(options.AllowRoot); err != nil {
+		return err
+	}
+	if options.Token == "" {
+		options.Token = os.Getenv(tokenEnv)
+	}
+	if (options.TLSCert == "") != (options.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	if options.DataDir == "" {
+		dir, err := os.MkdirTemp("", "pkginstall-serve-")
+		if err != nil {
+			return fmt.Errorf("failed to create data directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		options.DataDir = dir
+	}
+
+	srv, err := New(Options{
+		Token:           options.Token,
+		DataDir:         options.DataDir,
+		AllowedPaths:    options.AllowedPaths,
+		Workers:         options.Jobs,
+		MaxUploadSize:   options.MaxUploadMB << 20,
+		Profile:         options.Profile,
+		AllowedProfiles: options.AllowProfiles,
+		OTLPEndpoint:    options.OTLPEndpoint,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workersDone := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(workersDone)
+	}()
+
+	httpServer := &http.Server{Addr: options.Listen, Handler: srv, ReadHeaderTimeout: 30 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Serving build API", "listen", options.Listen, "data", options.DataDir)
+		if options.TLSCert != "" {
+			serveErr <- httpServer.ListenAndServeTLS(options.TLSCert, options.TLSKey)
+		} else {
+			serveErr <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		slog.Info("Shutting down build API")
+		shutdownCtx, stop := context.WithTimeout(context.Background(), options.ShutdownGrace)
+		err = httpServer.Shutdown(shutdownCtx)
+		stop()
+	}
+	cancel()
+	<-workersDone
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
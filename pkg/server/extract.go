@@ -0,0 +1,245 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractSource unpacks an uploaded tar.gz payload into dir. Entry names and
+// link targets may not leave dir; symlinks must be relative. Symlinks and
+// hard links are created after all files, each only in a directory that
+// resolves inside dir, so no entry can be written through a link.
+func extractSource(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create source directory: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source directory: %w", err)
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("source must be a gzip-compressed tar archive: %w", err)
+	}
+	defer gz.Close()
+
+	type link struct {
+		header *tar.Header
+		target string
+	}
+	var links []link
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read source archive: %w", err)
+		}
+
+		name, err := entryPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		if name == dir {
+			continue
+		}
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, mode|0700); err != nil {
+				return fmt.Errorf("failed to create %s: %w", header.Name, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", header.Name, err)
+			}
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", header.Name, err)
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+			os.Chtimes(name, header.ModTime, header.ModTime)
+		case tar.TypeSymlink:
+			if err := checkSymlink(header.Name, header.Linkname); err != nil {
+				return err
+			}
+			links = append(links, link{header, name})
+		case tar.TypeLink:
+			if _, err := entryPath(dir, header.Linkname); err != nil {
+				return err
+			}
+			links = append(links, link{header, name})
+		default:
+			return fmt.Errorf("unsupported entry type in source archive: %s", header.Name)
+		}
+	}
+
+	for _, l := range links {
+		// Earlier symlinks may have made a parent directory a link
+		if err := checkInside(root, filepath.Dir(l.target)); err != nil {
+			return fmt.Errorf("source archive entry %s: %w", l.header.Name, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(l.target), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", l.header.Name, err)
+		}
+		if l.header.Typeflag == tar.TypeLink {
+			first, _ := entryPath(dir, l.header.Linkname)
+			if err := checkInside(root, filepath.Dir(first)); err != nil {
+				return fmt.Errorf("source archive entry %s: %w", l.header.Name, err)
+			}
+			err = os.Link(first, l.target)
+		} else {
+			err = os.Symlink(l.header.Linkname, l.target)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create link %s: %w", l.header.Name, err)
+		}
+		if l.header.Typeflag == tar.TypeSymlink {
+			if err := checkLink(root, l.target); err != nil {
+				return fmt.Errorf("source archive symlink %s: %w", l.header.Name, err)
+			}
+		}
+	}
+
+	// A later symlink can change where an earlier one leads, as with
+	// l -> sub/b/../.. created before sub/b -> .
+	for _, l := range links {
+		if l.header.Typeflag != tar.TypeSymlink {
+			continue
+		}
+		if err := checkLink(root, l.target); err != nil {
+			return fmt.Errorf("source archive symlink %s: %w", l.header.Name, err)
+		}
+	}
+	return nil
+}
+
+// entryPath returns where the archive entry name is extracted below dir
+func entryPath(dir, name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("source archive entry %s escapes the source directory", name)
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+// checkSymlink checks that the symlink entry name points inside the source
+// directory: its target must be relative and may not climb above the root
+func checkSymlink(name, target string) error {
+	if path.IsAbs(target) {
+		return fmt.Errorf("source archive symlink %s has absolute target %s", name, target)
+	}
+	resolved := path.Join(path.Dir(path.Clean("/" + name)[1:]), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("source archive symlink %s points outside the source directory", name)
+	}
+	return nil
+}
+
+// checkInside checks that p, or the part of it that exists, resolves to a
+// path inside root, which must itself be resolved
+func checkInside(root, p string) error {
+	existing := p
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !within(root, resolved) {
+				return fmt.Errorf("%s resolves outside the source directory", p)
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return fmt.Errorf("%s resolves outside the source directory", p)
+		}
+		existing = parent
+	}
+}
+
+// maxLinkDepth bounds the symlinks followed while resolving one link
+const maxLinkDepth = 40
+
+// checkLink checks that the symlink p points inside root. The target is
+// followed one component at a time, through the links it passes, so a
+// chain such as sub/b -> . and l -> sub/b/../.. is caught even when the
+// final file does not exist.
+func checkLink(root, p string) error {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return err
+	}
+	target, err := os.Readlink(p)
+	if err != nil {
+		return err
+	}
+	_, err = resolveInside(root, dir, target, 0)
+	return err
+}
+
+// resolveInside resolves the relative target from the directory dir,
+// failing as soon as a step leaves root
+func resolveInside(root, dir, target string, depth int) (string, error) {
+	if depth > maxLinkDepth {
+		return "", fmt.Errorf("too many levels of symlinks")
+	}
+	if filepath.IsAbs(target) {
+		return "", fmt.Errorf("absolute target %s", target)
+	}
+	current := dir
+	for _, part := range strings.Split(filepath.ToSlash(target), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			next := filepath.Join(current, part)
+			info, err := os.Lstat(next)
+			switch {
+			case os.IsNotExist(err):
+				current = next
+			case err != nil:
+				return "", err
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(next)
+				if err != nil {
+					return "", err
+				}
+				if current, err = resolveInside(root, current, link, depth+1); err != nil {
+					return "", err
+				}
+			default:
+				current = next
+			}
+		}
+		if !within(root, current) {
+			return "", fmt.Errorf("%s points outside the source directory", target)
+		}
+	}
+	return current, nil
+}
+
+// within reports whether the resolved path p is root or below it
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
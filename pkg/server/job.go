@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/debian"
+)
+
+// BuildRequest describes a package to build. The payload is either uploaded
+// as a tar.gz archive with the request or read from Source on the server.
+type BuildRequest struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Maintainer   string   `json:"maintainer"`
+	Description  string   `json:"description"`
+	Architecture string   `json:"architecture,omitempty"` // Default: all
+	Section      string   `json:"section,omitempty"`      // Default: utils
+	Priority     string   `json:"priority,omitempty"`     // Default: optional
+	Depends      []string `json:"depends,omitempty"`
+	Excludes     []string `json:"excludes,omitempty"`
+	Profile      string   `json:"profile,omitempty"` // Security profile; default: the server's. Weaker profiles must be allowed by the server
+	Source       string   `json:"source,omitempty"`  // Directory on the server below an allowed path
+}
+
+// JobState is the state of a build job
+type JobState string
+
+const (
+	StateQueued    JobState = "queued"
+	StateRunning   JobState = "running"
+	StateSucceeded JobState = "succeeded"
+	StateFailed    JobState = "failed"
+)
+
+// Job is a build submitted to the server
+type Job struct {
+	ID       string              `json:"id"`
+	State    JobState            `json:"state"`
+	Request  BuildRequest        `json:"request"`
+	Error    string              `json:"error,omitempty"`
+	Created  time.Time           `json:"created"`
+	Started  *time.Time          `json:"started,omitempty"`
+	Finished *time.Time          `json:"finished,omitempty"`
+	Report   *debian.BuildReport `json:"report,omitempty"`
+
+	dir    string // Job directory holding the uploaded source and the output
+	output string // Path of the built .deb
+	log    *jobLog
+}
+
+// done reports whether the job has finished
+func (j *Job) done() bool {
+	return j.State == StateSucceeded || j.State == StateFailed
+}
+
+// newJobID returns a random job identifier
+func newJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// jobLog collects the output of a build and lets readers follow it
+type jobLog struct {
+	mu     sync.Mutex
+	data   []byte
+	closed bool
+	wake   chan struct{} // Closed and replaced on every write
+}
+
+func newJobLog() *jobLog {
+	return &jobLog{wake: make(chan struct{})}
+}
+
+// Write appends p to the log and wakes the followers
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data = append(l.data, p...)
+	close(l.wake)
+	l.wake = make(chan struct{})
+	return len(p), nil
+}
+
+// close marks the end of the log
+func (l *jobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// read returns the log from offset, a channel closed on the next write, and
+// whether the log has ended
+func (l *jobLog) read(offset int) ([]byte, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset > len(l.data) {
+		offset = len(l.data)
+	}
+	return append([]byte(nil), l.data[offset:]...), l.wake, l.closed
+}
@@ -0,0 +1,512 @@
+// Package server runs pkginstall as a shared build service. Clients submit
+// build jobs over an authenticated HTTP API, follow their logs and download
+// the resulting packages.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/debian"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+	"github.com/go-i2p/go-pkginstall/pkg/telemetry"
+)
+
+// DefaultMaxUploadSize bounds the size of an uploaded source archive
+const DefaultMaxUploadSize = 512 << 20
+
+// queueSize is the number of jobs that may wait for a worker
+const queueSize = 64
+
+// Options configures a Server
+type Options struct {
+	Token           string   // Bearer token clients must present; required
+	DataDir         string   // Directory for job sources and outputs
+	AllowedPaths    []string // Server directories builds may reference as their source
+	Workers         int      // Concurrent builds (default: 1)
+	MaxUploadSize   int64    // Maximum upload size in bytes (default: DefaultMaxUploadSize)
+	Profile         string   // Security profile of builds that do not select one
+	AllowedProfiles []string // Profiles weaker than Profile that jobs may select; stricter ones are always allowed
+	OTLPEndpoint    string   // OTLP/HTTP collector receiving build traces, if any
+}
+
+// Server is an HTTP build service. The API is:
+//
+//	POST   /v1/builds              submit a job: a JSON BuildRequest referencing a
+//	                               source directory, or multipart/form-data with a
+//	                               "request" field and a "source" tar.gz file
+//	GET    /v1/builds              list jobs
+//	GET    /v1/builds/{id}         job status and build report
+//	GET    /v1/builds/{id}/log     build log; ?follow=true streams it until the job ends
+//	GET    /v1/builds/{id}/package download the built .deb
+//	DELETE /v1/builds/{id}         remove a finished job and its files
+//	GET    /metrics                build metrics in the Prometheus text format
+//	GET    /healthz                liveness check, without authentication
+type Server struct {
+	opts         Options
+	mu           sync.Mutex
+	jobs         map[string]*Job
+	queue        chan *Job
+	metrics      *telemetry.Registry
+	buildMetrics *debian.BuildMetrics
+	tracer       *telemetry.Tracer
+}
+
+// New creates a Server; call Run to start its workers
+func New(opts Options) (*Server, error) {
+	if opts.Token == "" {
+		return nil, fmt.Errorf("an API token is required")
+	}
+	if opts.DataDir == "" {
+		return nil, fmt.Errorf("data directory cannot be empty")
+	}
+	if err := os.MkdirAll(opts.DataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxUploadSize <= 0 {
+		opts.MaxUploadSize = DefaultMaxUploadSize
+	}
+	if _, err := security.LookupProfile(opts.Profile); err != nil {
+		return nil, err
+	}
+	for _, name := range opts.AllowedProfiles {
+		if _, err := security.LookupProfile(name); err != nil {
+			return nil, err
+		}
+	}
+	for i, dir := range opts.AllowedPaths {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed path: %w", err)
+		}
+		if opts.AllowedPaths[i], err = filepath.Abs(resolved); err != nil {
+			return nil, fmt.Errorf("invalid allowed path: %w", err)
+		}
+	}
+	s := &Server{
+		opts:    opts,
+		jobs:    make(map[string]*Job),
+		queue:   make(chan *Job, queueSize),
+		metrics: telemetry.NewRegistry(),
+	}
+	s.buildMetrics = debian.NewBuildMetrics(s.metrics)
+	if opts.OTLPEndpoint != "" {
+		s.tracer = telemetry.NewTracer("pkginstall-serve", opts.OTLPEndpoint, nil)
+	}
+	return s, nil
+}
+
+// Run builds queued jobs until ctx is cancelled, which also cancels the
+// running builds
+func (s *Server) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-s.queue:
+					s.runJob(ctx, job)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ServeHTTP routes API requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pkginstall"`)
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	if r.URL.Path == "/metrics" {
+		s.metrics.Handler().ServeHTTP(w, r)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/builds"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/v1/builds") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleList(w)
+		case http.MethodPost:
+			s.handleSubmit(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+		return
+	}
+
+	parts := strings.SplitN(rest, "/", 2)
+	job := s.job(parts[0])
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", parts[0]))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.snapshot(job))
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.handleDelete(w, job)
+	case parts[1] == "log" && r.Method == http.MethodGet:
+		s.handleLog(w, r, job)
+	case parts[1] == "package" && r.Method == http.MethodGet:
+		s.handlePackage(w, r, job)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// authorized reports whether r carries the API token
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) == 1
+}
+
+// job returns the job with id, or nil
+func (s *Server) job(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// snapshot returns a copy of job that is safe to encode
+func (s *Server) snapshot(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *job
+}
+
+// handleList lists the jobs, newest first
+func (s *Server) handleList(w http.ResponseWriter) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.After(jobs[j].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// handleSubmit creates and queues a job
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.opts.MaxUploadSize)
+
+	id, err := newJobID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	job := &Job{ID: id, State: StateQueued, Created: time.Now().UTC(), dir: filepath.Join(s.opts.DataDir, id), log: newJobLog()}
+	if err := os.Mkdir(job.dir, 0700); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to create job directory: %w", err))
+		return
+	}
+
+	err = s.readRequest(r, job)
+	if err == nil {
+		err = s.checkProfile(job.Request.Profile)
+	}
+	if err != nil {
+		os.RemoveAll(job.dir)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	select {
+	case s.queue <- job:
+		s.jobs[job.ID] = job
+	default:
+		err = errors.New("build queue is full")
+	}
+	s.mu.Unlock()
+	if err != nil {
+		os.RemoveAll(job.dir)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/builds/"+job.ID)
+	writeJSON(w, http.StatusAccepted, s.snapshot(job))
+}
+
+// readRequest reads the build request and the uploaded source of job
+func (s *Server) readRequest(r *http.Request, job *Job) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&job.Request); err != nil {
+			return fmt.Errorf("invalid build request: %w", err)
+		}
+		return s.checkSource(&job.Request)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	uploaded := false
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid upload: %w", err)
+		}
+		switch part.FormName() {
+		case "request":
+			if err := json.NewDecoder(part).Decode(&job.Request); err != nil {
+				return fmt.Errorf("invalid build request: %w", err)
+			}
+		case "source":
+			if err := extractSource(part, filepath.Join(job.dir, "src")); err != nil {
+				return err
+			}
+			uploaded = true
+		}
+		part.Close()
+	}
+	if !uploaded {
+		return errors.New("multipart requests need a source file")
+	}
+	if job.Request.Source != "" {
+		return errors.New("a build cannot both upload a source and reference one")
+	}
+	return nil
+}
+
+// checkSource checks that a referenced source directory is below an allowed
+// path and resolves it
+func (s *Server) checkSource(req *BuildRequest) error {
+	if req.Source == "" {
+		return errors.New("source is required unless a source archive is uploaded")
+	}
+	if len(s.opts.AllowedPaths) == 0 {
+		return errors.New("this server does not build from server paths; upload a source archive")
+	}
+	resolved, err := filepath.EvalSymlinks(req.Source)
+	if err != nil || !filepath.IsAbs(req.Source) {
+		return fmt.Errorf("invalid source %s", req.Source)
+	}
+	for _, allowed := range s.opts.AllowedPaths {
+		if resolved == allowed || strings.HasPrefix(resolved, allowed+string(filepath.Separator)) {
+			req.Source = resolved
+			return nil
+		}
+	}
+	return fmt.Errorf("source %s is not below an allowed path", req.Source)
+}
+
+// checkProfile checks that a job may select the named security profile: a
+// client must not weaken the validation the server was started with unless
+// the profile is allowlisted
+func (s *Server) checkProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, err := security.LookupProfile(name)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range s.opts.AllowedProfiles {
+		if strings.EqualFold(allowed, profile.Name) {
+			return nil
+		}
+	}
+	def, err := security.LookupProfile(s.opts.Profile)
+	if err != nil {
+		return err
+	}
+	if !profile.AtLeastAsStrictAs(def) {
+		return fmt.Errorf("security profile %s is weaker than the server's %s profile and not allowed", profile.Name, def.Name)
+	}
+	return nil
+}
+
+// handleDelete removes a finished job
+func (s *Server) handleDelete(w http.ResponseWriter, job *Job) {
+	s.mu.Lock()
+	if !job.done() {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s has not finished", job.ID))
+		return
+	}
+	delete(s.jobs, job.ID)
+	s.mu.Unlock()
+
+	if err := os.RemoveAll(job.dir); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLog writes the build log, following it until the job ends when asked
+func (s *Server) handleLog(w http.ResponseWriter, r *http.Request, job *Job) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	follow := r.URL.Query().Get("follow") == "true"
+	flusher, _ := w.(http.Flusher)
+
+	offset := 0
+	for {
+		chunk, wake, closed := job.log.read(offset)
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			offset += len(chunk)
+			continue
+		}
+		if closed || !follow {
+			return
+		}
+		select {
+		case <-wake:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handlePackage sends the built .deb
+func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request, job *Job) {
+	snapshot := s.snapshot(job)
+	if snapshot.State != StateSucceeded {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s is %s", job.ID, snapshot.State))
+		return
+	}
+	f, err := os.Open(job.output)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.debian.binary-package")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(job.output)}))
+	http.ServeContent(w, r, filepath.Base(job.output), info.ModTime(), f)
+}
+
+// runJob builds job and records its result
+func (s *Server) runJob(ctx context.Context, job *Job) {
+	s.mu.Lock()
+	started := time.Now().UTC()
+	job.State, job.Started = StateRunning, &started
+	s.mu.Unlock()
+
+	output, report, err := s.build(ctx, job)
+
+	s.mu.Lock()
+	finished := time.Now().UTC()
+	job.Finished, job.Report, job.output = &finished, report, output
+	if err != nil {
+		job.State, job.Error = StateFailed, err.Error()
+		fmt.Fprintf(job.log, "Build failed: %v\n", err)
+	} else {
+		job.State = StateSucceeded
+		fmt.Fprintf(job.log, "Built %s\n", filepath.Base(output))
+	}
+	s.mu.Unlock()
+	job.log.close()
+	if err := s.tracer.Flush(context.Background()); err != nil {
+		slog.Warn("Failed to export build traces", "error", err)
+	}
+}
+
+// build runs the builder for job
+func (s *Server) build(ctx context.Context, job *Job) (string, *debian.BuildReport, error) {
+	req := job.Request
+	sourceDir := req.Source
+	if sourceDir == "" {
+		sourceDir = filepath.Join(job.dir, "src")
+	}
+	profileName := req.Profile
+	if profileName == "" {
+		profileName = s.opts.Profile
+	}
+	profile, err := security.LookupProfile(profileName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pkg := debian.NewPackage(req.Name, req.Version, valueOr(req.Architecture, "all"), req.Maintainer,
+		req.Description, valueOr(req.Section, "utils"), valueOr(req.Priority, "optional"), req.Depends)
+	logger := slog.New(logging.NewTextHandler(job.log, slog.LevelInfo))
+	builder, err := debian.NewBuilder(pkg, sourceDir, filepath.Join(job.dir, "out"),
+		debian.WithLogger(logger),
+		debian.WithVerbose(true),
+		debian.WithWorkDir(job.dir),
+		debian.WithProfile(profile),
+		debian.WithExcludes(req.Excludes...),
+		debian.WithObserver(debian.MultiObserver{
+			debian.LogObserver{Logger: logger},
+			s.buildMetrics.Observer(),
+			debian.NewTracingObserver(ctx, s.tracer),
+		}),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	return builder.Build(ctx)
+}
+
+// valueOr returns value, or fallback if it is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes err as a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testToken = "secret"
+
+// payload returns a tar.gz archive holding files
+func payload(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// newTestServer starts a Server and returns its URL
+func newTestServer(t *testing.T, allowed ...string) (*httptest.Server, func()) {
+	dataDir, err := ioutil.TempDir("", "serve-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	srv, err := New(Options{Token: testToken, DataDir: dataDir, AllowedPaths: allowed})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go srv.Run(ctx)
+	ts := httptest.NewServer(srv)
+	return ts, func() {
+		ts.Close()
+		cancel()
+		os.RemoveAll(dataDir)
+	}
+}
+
+// do sends an authenticated request
+func do(t *testing.T, method, url, contentType string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, url, err)
+	}
+	return resp
+}
+
+// submit uploads source with request and returns the response
+func submit(t *testing.T, url string, request BuildRequest, source []byte) *http.Response {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	spec, _ := json.Marshal(request)
+	mw.WriteField("request", string(spec))
+	fw, _ := mw.CreateFormFile("source", "payload.tar.gz")
+	fw.Write(source)
+	mw.Close()
+	return do(t, http.MethodPost, url+"/v1/builds", mw.FormDataContentType(), &body)
+}
+
+func TestServeBuild(t *testing.T) {
+	ts, stop := newTestServer(t)
+	defer stop()
+
+	request := BuildRequest{Name: "app", Version: "1.0", Maintainer: "Test <test@example.com>", Description: "d"}
+	resp := submit(t, ts.URL, request, payload(t, map[string]string{"usr/share/app/README": "hello\n"}))
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || job.ID == "" {
+		t.Fatalf("Submit returned %d, job %+v", resp.StatusCode, job)
+	}
+
+	// Following the log returns once the build has finished
+	resp = do(t, http.MethodGet, ts.URL+"/v1/builds/"+job.ID+"/log?follow=true", "", nil)
+	logs, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(logs), "Built app_1.0_all.deb") {
+		t.Errorf("Log does not end with the build result:\n%s", logs)
+	}
+
+	resp = do(t, http.MethodGet, ts.URL+"/v1/builds/"+job.ID, "", nil)
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if job.State != StateSucceeded || job.Report == nil || job.Report.Files != 1 {
+		t.Fatalf("Job state %s, report %+v, error %q", job.State, job.Report, job.Error)
+	}
+
+	resp = do(t, http.MethodGet, ts.URL+"/v1/builds/"+job.ID+"/package", "", nil)
+	deb, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(deb, []byte("!<arch>\n")) || int64(len(deb)) != job.Report.Size {
+		t.Errorf("Download returned %d with %d bytes", resp.StatusCode, len(deb))
+	}
+
+	resp = do(t, http.MethodGet, ts.URL+"/metrics", "", nil)
+	metrics, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(metrics), `pkginstall_builds_total{result="succeeded"} 1`) {
+		t.Errorf("Metrics do not count the build:\n%s", metrics)
+	}
+
+	resp = do(t, http.MethodDelete, ts.URL+"/v1/builds/"+job.ID, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete returned %d", resp.StatusCode)
+	}
+	resp = do(t, http.MethodGet, ts.URL+"/v1/builds/"+job.ID, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Deleted job returned %d", resp.StatusCode)
+	}
+}
+
+func TestServeRejects(t *testing.T) {
+	allowed, err := ioutil.TempDir("", "serve-allowed-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(allowed)
+	ts, stop := newTestServer(t, allowed)
+	defer stop()
+
+	request := BuildRequest{Name: "app", Version: "1.0", Maintainer: "Test <test@example.com>", Description: "d"}
+	pathRequest := func(source string) io.Reader {
+		r := request
+		r.Source = source
+		body, _ := json.Marshal(r)
+		return bytes.NewReader(body)
+	}
+
+	tests := []struct {
+		name       string
+		send       func() *http.Response
+		wantStatus int
+		wantErr    string
+	}{
+		{"No token", func() *http.Response {
+			resp, err := http.Get(ts.URL + "/v1/builds")
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			return resp
+		}, http.StatusUnauthorized, "missing or invalid token"},
+		{"Traversal", func() *http.Response {
+			return submit(t, ts.URL, request, payload(t, map[string]string{"../escape": "x"}))
+		}, http.StatusBadRequest, "escapes the source directory"},
+		{"Not gzip", func() *http.Response {
+			return submit(t, ts.URL, request, []byte("plain"))
+		}, http.StatusBadRequest, "gzip-compressed tar archive"},
+		{"Path outside allowed", func() *http.Response {
+			return do(t, http.MethodPost, ts.URL+"/v1/builds", "application/json", pathRequest(os.TempDir()))
+		}, http.StatusBadRequest, "not below an allowed path"},
+		{"Weaker profile", func() *http.Response {
+			r := request
+			r.Profile = "permissive"
+			return submit(t, ts.URL, r, payload(t, map[string]string{"file": "x"}))
+		}, http.StatusBadRequest, "weaker than the server's standard profile"},
+		{"Unknown job", func() *http.Response {
+			return do(t, http.MethodGet, ts.URL+"/v1/builds/nope", "", nil)
+		}, http.StatusNotFound, "no job nope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := tt.send()
+			defer resp.Body.Close()
+			var body map[string]string
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(body["error"], tt.wantErr) {
+				t.Errorf("Got %d %q, want %d %q", resp.StatusCode, body["error"], tt.wantStatus, tt.wantErr)
+			}
+		})
+	}
+
+	// A source below the allowed path is accepted
+	if err := ioutil.WriteFile(filepath.Join(allowed, "file"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	resp := do(t, http.MethodPost, ts.URL+"/v1/builds", "application/json", pathRequest(allowed))
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Path build returned %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for !job.done() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		resp = do(t, http.MethodGet, ts.URL+"/v1/builds/"+job.ID, "", nil)
+		json.NewDecoder(resp.Body).Decode(&job)
+		resp.Body.Close()
+	}
+	if job.State != StateSucceeded {
+		t.Errorf("Path build ended %s: %s", job.State, job.Error)
+	}
+}
+
+func TestExtractSourceLinks(t *testing.T) {
+	archive := func(headers ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, header := range headers {
+			if err := tw.WriteHeader(header); err != nil {
+				t.Fatalf("Failed to write tar header: %v", err)
+			}
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+	outside, err := ioutil.TempDir("", "extract-outside-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outside)
+
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		wantErr string
+	}{
+		{"Absolute symlink", []*tar.Header{
+			{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		}, "absolute target"},
+		{"Escaping symlink", []*tar.Header{
+			{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: "../../etc"},
+		}, "points outside"},
+		{"Hard link through a symlinked directory", []*tar.Header{
+			{Name: "file", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "d", Typeflag: tar.TypeSymlink, Linkname: strings.Repeat("../", 8) + strings.TrimPrefix(outside, "/")},
+			{Name: "d/evil", Typeflag: tar.TypeLink, Linkname: "file"},
+		}, "points outside"},
+		{"Symlink chain", []*tar.Header{
+			{Name: "sub/b", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "sub/b/../.."},
+		}, "points outside"},
+		{"Symlink chain in reverse order", []*tar.Header{
+			{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "sub/b/../../missing"},
+			{Name: "sub/b", Typeflag: tar.TypeSymlink, Linkname: "."},
+		}, "points outside"},
+		{"Inside links", []*tar.Header{
+			{Name: "sub/file", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "d/copy", Typeflag: tar.TypeLink, Linkname: "sub/file"},
+			{Name: "sub/lib/up", Typeflag: tar.TypeSymlink, Linkname: "../file"},
+			{Name: "missing", Typeflag: tar.TypeSymlink, Linkname: "sub/none"},
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "extract-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			err = extractSource(bytes.NewReader(archive(tt.headers...)), dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("extractSource() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("extractSource() error = %v, want %q", err, tt.wantErr)
+			}
+			if entries, _ := ioutil.ReadDir(outside); len(entries) != 0 {
+				t.Errorf("Expected nothing written outside the source directory, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestCheckInside(t *testing.T) {
+	root, err := ioutil.TempDir("", "extract-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	outside, err := ioutil.TempDir("", "extract-outside-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outside)
+
+	// A symlink that escapes is caught even when it was created some other way
+	if err := os.Symlink(outside, filepath.Join(root, "d")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := checkInside(root, filepath.Join(root, "d", "missing")); err == nil {
+		t.Errorf("Expected a directory resolving outside the root to be rejected")
+	}
+	if err := checkInside(root, filepath.Join(root, "new", "dir")); err != nil {
+		t.Errorf("checkInside() error = %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Package signature writes and checks detached minisign and signify
+// signatures: small Ed25519 signature files next to the signed file, for
+// teams that use these tools instead of GPG. Like GPG elsewhere in
+// pkginstall, the signing tools themselves are run, so keys stay in the
+// formats and password prompts users already know.
+package signature
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Scheme is a detached signature scheme
+type Scheme string
+
+// Supported signature schemes
+const (
+	Minisign Scheme = "minisign"
+	Signify  Scheme = "signify"
+)
+
+// Schemes returns the names of the supported schemes
+func Schemes() []string {
+	names := []string{string(Minisign), string(Signify)}
+	sort.Strings(names)
+	return names
+}
+
+// ParseScheme parses the name of a signature scheme
+func ParseScheme(name string) (Scheme, error) {
+	switch Scheme(strings.ToLower(name)) {
+	case Minisign:
+		return Minisign, nil
+	case Signify:
+		return Signify, nil
+	}
+	return "", fmt.Errorf("unknown signature scheme %q (available: %s)", name, strings.Join(Schemes(), ", "))
+}
+
+// Extension returns the file extension of the scheme's signatures:
+// .minisig or .sig
+func (s Scheme) Extension() string {
+	if s == Minisign {
+		return ".minisig"
+	}
+	return ".sig"
+}
+
+// Path returns where the signature of a file is written
+func (s Scheme) Path(file string) string {
+	return file + s.Extension()
+}
+
+// Key is a secret key of a scheme
+type Key struct {
+	Scheme Scheme
+	File   string // Secret key file
+}
+
+// Keys returns the keys given by a pair of --minisign-key and --signify-key
+// flags, skipping empty ones
+func Keys(minisignKey, signifyKey string) []Key {
+	var keys []Key
+	if minisignKey != "" {
+		keys = append(keys, Key{Scheme: Minisign, File: minisignKey})
+	}
+	if signifyKey != "" {
+		keys = append(keys, Key{Scheme: Signify, File: signifyKey})
+	}
+	return keys
+}
+
+// signifyTools are the names signify is installed under, in order
+var signifyTools = []string{"signify", "signify-openbsd"}
+
+// lookPath finds a program in PATH.
+// It is a variable so tests can substitute it.
+var lookPath = exec.LookPath
+
+// run invokes a signing tool with the given arguments; the terminal is
+// passed through for password prompts.
+// It is a variable so tests can substitute it.
+var run = func(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// tool returns the program implementing the scheme
+func (s Scheme) tool() (string, error) {
+	names := []string{string(Minisign)}
+	if s == Signify {
+		names = signifyTools
+	}
+	for _, name := range names {
+		if path, err := lookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s signatures require %s in PATH", s, strings.Join(names, " or "))
+}
+
+// Sign writes the detached signature of file with key and returns its path
+func Sign(key Key, file string) (string, error) {
+	if _, err := os.Stat(key.File); err != nil {
+		return "", fmt.Errorf("invalid %s key: %w", key.Scheme, err)
+	}
+	tool, err := key.Scheme.tool()
+	if err != nil {
+		return "", err
+	}
+	sigPath := key.Scheme.Path(file)
+	// Both tools take the same flags: -S signs message -m with secret key
+	// -s into signature -x
+	if err := run(tool, "-S", "-s", key.File, "-m", file, "-x", sigPath); err != nil {
+		return "", fmt.Errorf("failed to sign %s with %s: %w", file, key.Scheme, err)
+	}
+	return sigPath, nil
+}
+
+// Verify checks the detached signature next to file with a public key
+func Verify(scheme Scheme, publicKey, file string) error {
+	tool, err := scheme.tool()
+	if err != nil {
+		return err
+	}
+	sigPath := scheme.Path(file)
+	if _, err := os.Stat(sigPath); err != nil {
+		return fmt.Errorf("missing %s signature: %w", scheme, err)
+	}
+	if err := run(tool, "-V", "-q", "-p", publicKey, "-m", file, "-x", sigPath); err != nil {
+		return fmt.Errorf("%s signature of %s does not verify with %s: %w", scheme, file, publicKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package signature
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeTools substitutes lookPath and run, finding the given programs and
+// recording every invocation
+func fakeTools(t *testing.T, installed ...string) *[][]string {
+	origLookPath, origRun := lookPath, run
+	t.Cleanup(func() { lookPath, run = origLookPath, origRun })
+
+	lookPath = func(name string) (string, error) {
+		for _, tool := range installed {
+			if tool == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+	var calls [][]string
+	run = func(name string, args ...string) error {
+		calls = append(calls, append([]string{name}, args...))
+		return nil
+	}
+	return &calls
+}
+
+func TestParseScheme(t *testing.T) {
+	if s, err := ParseScheme("Minisign"); err != nil || s != Minisign {
+		t.Errorf("ParseScheme(Minisign) = %q, %v", s, err)
+	}
+	if _, err := ParseScheme("gpg"); err == nil {
+		t.Errorf("ParseScheme(gpg) should fail")
+	}
+	if Minisign.Path("a.deb") != "a.deb.minisig" || Signify.Path("a.deb") != "a.deb.sig" {
+		t.Errorf("Unexpected signature paths")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	want := []Key{{Minisign, "m.key"}, {Signify, "s.sec"}}
+	if got := Keys("m.key", "s.sec"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+	if got := Keys("", ""); len(got) != 0 {
+		t.Errorf("Keys() without keys = %v", got)
+	}
+}
+
+func TestSign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key.sec")
+	if err := ioutil.WriteFile(keyFile, []byte("key"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	calls := fakeTools(t, "signify-openbsd")
+	sigPath, err := Sign(Key{Signify, keyFile}, "demo.deb")
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	want := []string{"/usr/bin/signify-openbsd", "-S", "-s", keyFile, "-m", "demo.deb", "-x", "demo.deb.sig"}
+	if sigPath != "demo.deb.sig" || len(*calls) != 1 || !reflect.DeepEqual((*calls)[0], want) {
+		t.Errorf("Sign() = %q, calls %v", sigPath, *calls)
+	}
+
+	if _, err := Sign(Key{Minisign, keyFile}, "demo.deb"); err == nil {
+		t.Errorf("Sign() without minisign in PATH should fail")
+	}
+	if _, err := Sign(Key{Signify, filepath.Join(dir, "missing")}, "demo.deb"); err == nil {
+		t.Errorf("Sign() with a missing key should fail")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "demo.deb")
+
+	calls := fakeTools(t, "minisign")
+	if err := Verify(Minisign, "minisign.pub", file); err == nil {
+		t.Errorf("Verify() without a signature should fail")
+	}
+	if err := ioutil.WriteFile(file+".minisig", []byte("sig"), 0644); err != nil {
+		t.Fatalf("Failed to write signature: %v", err)
+	}
+	if err := Verify(Minisign, "minisign.pub", file); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	want := []string{"/usr/bin/minisign", "-V", "-q", "-p", "minisign.pub", "-m", file, "-x", file + ".minisig"}
+	if len(*calls) != 1 || !reflect.DeepEqual((*calls)[0], want) {
+		t.Errorf("Unexpected calls %v", *calls)
+	}
+}
@@ -0,0 +1,25 @@
+// Package state locates the directory pkginstall keeps its per-user state
+// in: the run history, rollback manifests, the audit log and signing keys.
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir returns $XDG_STATE_HOME/pkginstall joined with elem, falling back to
+// ~/.local/state when XDG_STATE_HOME is unset or not absolute. The state
+// holds secret keys and the records rollbacks rely on, so when neither is
+// available Dir fails rather than use a shared directory such as /tmp.
+func Dir(elem ...string) (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if !filepath.IsAbs(base) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("no state directory: set XDG_STATE_HOME or HOME: %w", err)
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(append([]string{base, "pkginstall"}, elem...)...), nil
+}
@@ -0,0 +1,25 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/srv/state")
+	t.Setenv("HOME", "/home/user")
+	if got, err := Dir("i2p", "repo.keys"); err != nil || got != "/srv/state/pkginstall/i2p/repo.keys" {
+		t.Errorf("Dir() = %s, %v", got, err)
+	}
+
+	// A relative XDG_STATE_HOME is ignored, as the specification requires
+	t.Setenv("XDG_STATE_HOME", "state")
+	if got, err := Dir("history.jsonl"); err != nil || got != "/home/user/.local/state/pkginstall/history.jsonl" {
+		t.Errorf("Dir() = %s, %v", got, err)
+	}
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "")
+	if got, err := Dir(); err == nil {
+		t.Errorf("Dir() = %s, want an error without a state or home directory", got)
+	}
+}
@@ -0,0 +1,252 @@
+package symlink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+	"github.com/spf13/viper"
+)
+
+// Declaration is a file of symlinks applied together by "symlink apply"
+type Declaration struct {
+	Package  string            `mapstructure:"package"`  // Default owner of the links
+	Relative bool              `mapstructure:"relative"` // Create relative links
+	Links    []LinkDeclaration `mapstructure:"links"`
+}
+
+// LinkDeclaration is one symlink of a Declaration
+type LinkDeclaration struct {
+	Source      string `mapstructure:"source"`
+	Target      string `mapstructure:"target"`
+	Description string `mapstructure:"description"`
+	Package     string `mapstructure:"package"` // Owner, overriding the file's package
+	Force       bool   `mapstructure:"force"`   // Replace an existing target atomically
+}
+
+// LoadDeclaration reads a declaration file in YAML, JSON or any other
+// format viper supports
+func LoadDeclaration(path string) (*Declaration, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read declaration file %s: %w", path, err)
+	}
+
+	declaration := &Declaration{}
+	if err := v.UnmarshalExact(declaration); err != nil {
+		return nil, fmt.Errorf("invalid declaration file %s: %w", path, err)
+	}
+	if err := declaration.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid declaration file %s: %w", path, err)
+	}
+	return declaration, nil
+}
+
+// Validate checks that every link names an absolute source and target and
+// that no target is declared twice
+func (d *Declaration) Validate() error {
+	if len(d.Links) == 0 {
+		return fmt.Errorf("no links declared")
+	}
+	targets := make(map[string]int)
+	for i, link := range d.Links {
+		if !filepath.IsAbs(link.Source) {
+			return fmt.Errorf("links[%d]: source %q is not an absolute path", i, link.Source)
+		}
+		if !filepath.IsAbs(link.Target) {
+			return fmt.Errorf("links[%d]: target %q is not an absolute path", i, link.Target)
+		}
+		target := filepath.Clean(link.Target)
+		if first, ok := targets[target]; ok {
+			return fmt.Errorf("links[%d]: target %s is already declared by links[%d]", i, target, first)
+		}
+		targets[target] = i
+	}
+	return nil
+}
+
+// owner returns the package owning link
+func (d *Declaration) owner(link LinkDeclaration) string {
+	if link.Package != "" {
+		return link.Package
+	}
+	return d.Package
+}
+
+// runApplyCommand validates every declared symlink, then creates them all or
+// none: a failure removes the links created so far and restores what they
+// replaced
+func runApplyCommand(path string, options *CommandOptions) error {
+	declaration, err := LoadDeclaration(path)
+	if err != nil {
+		return err
+	}
+
+	pathMapper, err := newPathMapper(options)
+	if err != nil {
+		return err
+	}
+	validator, err := newValidator(options)
+	if err != nil {
+		return err
+	}
+	processor := NewSymlinkProcessor(pathMapper, NewSymlinkManager(pathMapper.GetSymlinkDirs()), validator, options.Verbose)
+	processor.SetDryRun(options.DryRun)
+	processor.SetRelative(options.Relative || declaration.Relative)
+	db, err := dpkgdb.Open(options.DpkgRoot)
+	if err != nil {
+		return fmt.Errorf("failed to read dpkg database: %w", err)
+	}
+	processor.SetManagedLinks(db, false)
+
+	// Validate everything before changing anything
+	var problems []string
+	var requests []SymlinkRequest
+	for i, link := range declaration.Links {
+		request, err := validateDeclaredLink(processor, link)
+		if err != nil {
+			problem := fmt.Sprintf("links[%d] %s: %v", i, link.Target, err)
+			if !options.Report {
+				return fmt.Errorf("validation failed: %s", problem)
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		request.Package = declaration.owner(link)
+		requests = append(requests, request)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Printf("❌ %s\n", problem)
+		}
+		return fmt.Errorf("%d of %d declared symlinks failed validation; nothing was changed", len(problems), len(declaration.Links))
+	}
+	fmt.Printf("✅ All %d declared symlinks passed validation\n", len(requests))
+
+	summary := history.NewSummary("symlink apply")
+	summary.DryRun = options.DryRun
+	for _, request := range requests {
+		summary.AddSymlink(request.Target, request.Source)
+		if request.Replace {
+			summary.AddOverride(fmt.Sprintf("existing target %s replaced (force)", request.Target))
+		}
+	}
+	if options.DryRun {
+		for _, request := range requests {
+			link, _ := processor.LinkTarget(request)
+			fmt.Printf("[DRY RUN] Would create symlink: %s -> %s\n", request.Target, link)
+		}
+		history.Record(os.Stdout, summary)
+		return nil
+	}
+
+	store, err := manifest.NewStore("")
+	if err != nil {
+		return fmt.Errorf("cannot record changes for rollback: %w", err)
+	}
+	record := manifest.New("symlink apply")
+	defer saveManifest(store, record, false)
+
+	var createdDirs []string
+	for _, request := range requests {
+		dirs, err := createLink(processor, store, record, request)
+		createdDirs = append(createdDirs, dirs...)
+		if err != nil {
+			err = fmt.Errorf("failed to create %s: %w", request.Target, err)
+			rollbackApply(record, createdDirs, summary)
+			summary.SetError(err)
+			history.Record(os.Stdout, summary)
+			return err
+		}
+		summary.AddAction(fmt.Sprintf("created symlink %s", request.Target))
+	}
+
+	for i, request := range requests {
+		recordOwner(request.Target, record.Symlinks[i].Source, request.Package, options.Marker && request.Package != "")
+	}
+	fmt.Printf("Successfully created %d symlinks\n", len(requests))
+	history.Record(os.Stdout, summary)
+	return nil
+}
+
+// validateDeclaredLink runs the checks of "symlink create" on a declared link
+// and returns the request that creates it
+func validateDeclaredLink(processor *SymlinkProcessor, link LinkDeclaration) (SymlinkRequest, error) {
+	request := SymlinkRequest{
+		Source:      filepath.Clean(link.Source),
+		Target:      filepath.Clean(link.Target),
+		Description: link.Description,
+	}
+	if request.Description == "" {
+		request.Description = fmt.Sprintf("Symlink from %s to %s", request.Source, request.Target)
+	}
+
+	if _, err := os.Stat(request.Source); err != nil {
+		return request, fmt.Errorf("source file error: %w", err)
+	}
+	if err := processor.validator.ValidatePathTraversal(request.Target); err != nil {
+		return request, fmt.Errorf("security validation failed: %w", err)
+	}
+	if err := processor.CheckManaged(&request); err != nil {
+		return request, err
+	}
+	if _, err := os.Lstat(request.Target); err == nil {
+		if !link.Force {
+			return request, fmt.Errorf("target path already exists (set force to replace it)")
+		}
+		request.Replace = true
+	}
+	// Checks source and target against the policy and queues the request
+	if err := processor.QueueSymlink(request); err != nil {
+		return request, err
+	}
+	return request, nil
+}
+
+// createLink creates the symlink of request, backing up the target it
+// replaces, and records it in the manifest. It returns the parent
+// directories it created.
+func createLink(processor *SymlinkProcessor, store *manifest.Store, record *manifest.Manifest, request SymlinkRequest) ([]string, error) {
+	missing := missingDirs(request.Target)
+
+	displaced := len(record.Displaced)
+	if request.Replace {
+		if err := store.Displace(record, request.Target); err != nil {
+			return nil, fmt.Errorf("refusing to replace existing target: %w", err)
+		}
+	}
+	if err := processor.createSymlink(request); err != nil {
+		// The target was not replaced, so there is nothing to restore
+		record.Displaced = record.Displaced[:displaced]
+		return missing, err
+	}
+
+	link, err := processor.LinkTarget(request)
+	if err != nil {
+		link = request.Source
+	}
+	record.AddSymlink(request.Target, link)
+	return missing, nil
+}
+
+// rollbackApply undoes a partially applied declaration: the symlinks created
+// are removed, the files they replaced restored and new directories removed
+func rollbackApply(record *manifest.Manifest, createdDirs []string, summary *history.Summary) {
+	actions, err := manifest.RollbackFiles(record, false)
+	for _, action := range actions {
+		summary.AddAction("rolled back: " + action)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		// Keep the manifest so "pkginstall rollback" can finish the job
+		return
+	}
+	removeDirs(createdDirs)
+	record.Symlinks = nil
+	record.Displaced = nil
+	fmt.Printf("Rolled back %d change(s); nothing was left applied\n", len(actions))
+}
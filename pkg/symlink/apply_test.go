@@ -0,0 +1,129 @@
+package symlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDeclaration(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink-apply-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"Valid", "package: app\nlinks:\n  - source: /opt/app/bin/app\n    target: /usr/local/bin/app\n    force: true\n", ""},
+		{"No links", "package: app\n", "no links declared"},
+		{"Relative source", "links:\n  - source: opt/app\n    target: /usr/local/bin/app\n", "not an absolute path"},
+		{"Duplicate target", "links:\n  - source: /opt/a\n    target: /usr/local/bin/app\n  - source: /opt/b\n    target: /usr/local/bin//app\n", "already declared by links[0]"},
+		{"Unknown key", "links:\n  - source: /opt/a\n    target: /usr/local/bin/app\n    overwrite: true\n", "invalid declaration file"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, strings.Repeat("x", i+1)+".yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write declaration: %v", err)
+			}
+			declaration, err := LoadDeclaration(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadDeclaration() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDeclaration() error = %v", err)
+			}
+			if len(declaration.Links) != 1 || !declaration.Links[0].Force || declaration.owner(declaration.Links[0]) != "app" {
+				t.Errorf("Unexpected declaration: %+v", declaration)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink-apply-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.Setenv("XDG_STATE_HOME", filepath.Join(tempDir, "state"))
+	defer os.Unsetenv("XDG_STATE_HOME")
+
+	src := filepath.Join(tempDir, "src")
+	dst := filepath.Join(tempDir, "dst")
+	for _, dir := range []string{src, dst} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	for name, content := range map[string]string{"src/app": "app", "dst/existing": "original", "dst/file": "in the way"} {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	declare := func(third string) string {
+		path := filepath.Join(tempDir, "links.yaml")
+		content := "package: app\nlinks:\n" +
+			"  - source: " + src + "/app\n    target: " + dst + "/new/app\n" +
+			"  - source: " + src + "/app\n    target: " + dst + "/existing\n    force: true\n" +
+			"  - source: " + third + "\n    target: " + dst + "/file/app\n"
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write declaration: %v", err)
+		}
+		return path
+	}
+	untouched := func(t *testing.T) {
+		if content, err := ioutil.ReadFile(filepath.Join(dst, "existing")); err != nil || string(content) != "original" {
+			t.Errorf("Expected the existing target to be untouched, got %q, %v", content, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dst, "new")); !os.IsNotExist(err) {
+			t.Errorf("Expected no new directory, got %v", err)
+		}
+	}
+
+	t.Run("Validation failure changes nothing", func(t *testing.T) {
+		err := runApplyCommand(declare(src+"/missing"), &CommandOptions{Report: true})
+		if err == nil || !strings.Contains(err.Error(), "1 of 3 declared symlinks failed validation") {
+			t.Errorf("runApplyCommand() error = %v", err)
+		}
+		untouched(t)
+	})
+
+	t.Run("Partial failure is rolled back", func(t *testing.T) {
+		// The parent of the third target is a file, which only creation notices
+		err := runApplyCommand(declare(src+"/app"), &CommandOptions{})
+		if err == nil || !strings.Contains(err.Error(), "failed to create "+dst+"/file/app") {
+			t.Errorf("runApplyCommand() error = %v", err)
+		}
+		untouched(t)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dst, "file")); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+		if err := runApplyCommand(declare(src+"/app"), &CommandOptions{}); err != nil {
+			t.Fatalf("runApplyCommand() error = %v", err)
+		}
+		state, err := LoadState("")
+		if err != nil {
+			t.Fatalf("LoadState() error = %v", err)
+		}
+		for _, target := range []string{"new/app", "existing", "file/app"} {
+			if owner := state.Owner(filepath.Join(dst, target)); owner != "app" {
+				t.Errorf("Owner(%s) = %q, want app", target, owner)
+			}
+		}
+	})
+}
@@ -1,33 +1,73 @@
 package symlink
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/config"
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
 	"github.com/go-i2p/go-pkginstall/pkg/security"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 )
 
 // CommandOptions contains options for the symlink command
 type CommandOptions struct {
 	// General options
-	Verbose bool
-	DryRun  bool
+	Verbose     bool
+	DryRun      bool
+	ConfigFile  string
+	PolicyFile  string
+	Profile     string
+	SymlinkDirs []string
+
+	policy *security.PolicyFile // Loaded from PolicyFile on first use
 
 	// Create command options
 	Source      string
 	Target      string
 	Description string
 	Force       bool
+	Relative    bool
+	Marker      bool
 
-	// List command options
+	// Create, list and remove command options
+	Package string
+
+	// List and scan command options
 	Format string
 
+	// List command options
+	Dirs        []string
+	Broken      bool
+	OutsideRoot bool
+	Quiet       bool
+
 	// Validate command options
 	StrictMode bool
+
+	// Apply command options
+	Report bool
+
+	// Create and apply command options
+	Alternatives        bool
+	AlternativePriority int
+
+	// Create, apply and scan command options
+	DpkgRoot string
+
+	// Scan command options
+	Clean bool
+	Yes   bool
 }
 
 // NewSymlinkCommand creates a new command for managing symlinks
@@ -50,17 +90,27 @@ Examples:
   pkginstall symlink create --source /opt/myapp/service.conf --target /etc/systemd/system/myapp.service
   pkginstall symlink list
   pkginstall symlink validate --strict /etc/systemd/system/myapp.service
+  pkginstall symlink remove /etc/systemd/system/myapp.service
+  pkginstall symlink apply links.yaml
 `,
 	}
 
 	// Add global flags
 	cmd.PersistentFlags().BoolVarP(&options.Verbose, "verbose", "v", false, "Enable verbose output")
 	cmd.PersistentFlags().BoolVarP(&options.DryRun, "dry-run", "n", false, "Show what would be done without making changes")
+	cmd.PersistentFlags().StringVar(&options.ConfigFile, "config", "", "Configuration file path")
+	cmd.PersistentFlags().StringVar(&options.PolicyFile, "policy", "", "Security policy file (YAML or JSON) extending or replacing the built-in rules")
+	cmd.PersistentFlags().StringVar(&options.Profile, "profile", security.DefaultProfileName,
+		"Security profile used for path validation ("+strings.Join(security.ProfileNames(), ", ")+")")
+	cmd.PersistentFlags().StringSliceVar(&options.SymlinkDirs, "symlink-dir", nil, "Additional directory where symlinks may be created (repeatable)")
 
 	// Add subcommands
 	cmd.AddCommand(newCreateCommand(options))
 	cmd.AddCommand(newListCommand(options))
 	cmd.AddCommand(newValidateCommand(options))
+	cmd.AddCommand(newRemoveCommand(options))
+	cmd.AddCommand(newApplyCommand(options))
+	cmd.AddCommand(newScanCommand(options))
 
 	return cmd
 }
@@ -74,13 +124,16 @@ func newCreateCommand(options *CommandOptions) *cobra.Command {
 
 This command creates a symlink from source to target, ensuring that:
 1. The target path undergoes security validation
-2. No existing files are overwritten
+2. No existing files are overwritten, unless --force replaces them atomically
+   after backing them up for "symlink remove" and "rollback"
 3. Parent directories are created if needed
 4. All operations follow the security model
 
 Examples:
   pkginstall symlink create --source /opt/myapp/bin/myapp --target /usr/local/bin/myapp
   pkginstall symlink create --source /opt/myapp/myapp.service --target /etc/systemd/system/myapp.service
+  pkginstall symlink create --relative --source /opt/myapp/bin/myapp --target /usr/local/bin/myapp
+  pkginstall symlink create --package myapp --marker --source /opt/myapp/bin/myapp --target /usr/local/bin/myapp
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runCreateCommand(options)
@@ -91,7 +144,13 @@ Examples:
 	cmd.Flags().StringVarP(&options.Source, "source", "s", "", "Source file path (required)")
 	cmd.Flags().StringVarP(&options.Target, "target", "t", "", "Target symlink path (required)")
 	cmd.Flags().StringVarP(&options.Description, "description", "d", "", "Description of the symlink purpose")
-	cmd.Flags().BoolVarP(&options.Force, "force", "f", false, "Force creation even if target exists (will remove existing file)")
+	cmd.Flags().BoolVarP(&options.Force, "force", "f", false, "Replace an existing target atomically, backing it up for rollback")
+	cmd.Flags().BoolVarP(&options.Relative, "relative", "r", false, "Point the symlink to the source with a relative path (../../opt/...)")
+	cmd.Flags().StringVarP(&options.Package, "package", "p", "", "Package that owns the symlink, recorded for list and remove --package")
+	cmd.Flags().BoolVar(&options.Marker, "marker", false, "Also record the owning package in a hidden marker file next to the symlink")
+	cmd.Flags().BoolVar(&options.Alternatives, "alternatives", false, "Register the source with update-alternatives when the target is an alternative's master link")
+	cmd.Flags().IntVar(&options.AlternativePriority, "alternative-priority", DefaultAlternativePriority, "Priority of the alternative registered with --alternatives")
+	cmd.Flags().StringVar(&options.DpkgRoot, "dpkg-root", "/", "Filesystem root whose dpkg database is checked for alternatives and diversions")
 
 	// Mark required flags
 	cmd.MarkFlagRequired("source")
@@ -100,6 +159,127 @@ Examples:
 	return cmd
 }
 
+// newRemoveCommand creates a subcommand for removing symlinks
+func newRemoveCommand(options *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove [target_path]",
+		Short: "Remove a symlink and restore what it replaced",
+		Long: `Remove a symlink and restore the file it replaced.
+
+When the symlink was created with "symlink create --force", the file or
+symlink it displaced is restored from the backup recorded in the rollback
+manifest, and the manifest is updated so a later rollback leaves the path
+alone. A symlink that has been changed since it was created is not removed.
+
+With --package, every symlink created for that package is removed: those
+recorded in the symlink state database and those whose ownership marker
+names the package. Links of other packages in the same directories are left
+alone.
+
+Examples:
+  pkginstall symlink remove /usr/local/bin/myapp
+  pkginstall symlink remove --package myapp
+  pkginstall symlink remove --dry-run /etc/systemd/system/myapp.service
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				options.Target = args[0]
+			}
+			if options.Target == "" && options.Package == "" {
+				return fmt.Errorf("specify a target path or --package")
+			}
+			return runRemoveCommand(options)
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.Package, "package", "p", "", "Remove every symlink owned by this package, or check that the target is owned by it")
+
+	return cmd
+}
+
+// newApplyCommand creates a subcommand for creating symlinks from a declaration file
+func newApplyCommand(options *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply [declaration_file]",
+		Short: "Create the symlinks declared in a file, all or none",
+		Long: `Create a batch of symlinks declared in a YAML or JSON file.
+
+Every declared symlink is validated like "symlink create" before anything is
+changed. The symlinks are then created in order; if one fails, those created
+so far are removed and the files they replaced restored, so the batch is
+applied completely or not at all. Useful in place of hand-written postinst
+snippets and in provisioning.
+
+By default validation stops at the first invalid entry; --report checks
+every entry and lists all problems.
+
+Declaration file:
+  package: myapp          # owner recorded for list/remove --package
+  relative: false         # create relative symlinks
+  links:
+    - source: /opt/myapp/bin/myapp
+      target: /usr/local/bin/myapp
+      description: Command-line entry point
+    - source: /opt/myapp/myapp.service
+      target: /etc/systemd/system/myapp.service
+      force: true         # replace an existing target, backing it up
+
+Examples:
+  pkginstall symlink apply links.yaml
+  pkginstall symlink apply --dry-run --report links.yaml
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runApplyCommand(args[0], options)
+		},
+	}
+
+	cmd.Flags().BoolVar(&options.Report, "report", false, "Validate every entry and report all problems instead of stopping at the first")
+	cmd.Flags().BoolVarP(&options.Relative, "relative", "r", false, "Create relative symlinks, as if the file set relative: true")
+	cmd.Flags().BoolVar(&options.Marker, "marker", false, "Also record the owning package in a hidden marker file next to each symlink")
+	cmd.Flags().StringVar(&options.DpkgRoot, "dpkg-root", "/", "Filesystem root whose dpkg database is checked for alternatives and diversions")
+
+	return cmd
+}
+
+// newScanCommand creates a subcommand for finding broken symlinks into /opt
+func newScanCommand(options *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scan",
+		Short: "Find broken symlinks into /opt and clean up orphaned ones",
+		Long: `Scan the symlink directories for symlinks pointing into the transformed
+root (/opt by default) whose source no longer exists, for example because
+the package payload was removed.
+
+Each broken symlink is cross-referenced with the dpkg database and the
+symlink state database. A symlink shipped by an installed package, or whose
+source or owning package is still installed, can be repaired by
+reinstalling that package and is left alone. The others are orphans.
+
+With --clean, orphaned symlinks are removed after confirmation and recorded
+in a rollback manifest, so "pkginstall rollback" can restore them.
+
+Examples:
+  pkginstall symlink scan
+  pkginstall symlink scan --format json
+  pkginstall symlink scan --clean
+  pkginstall symlink scan --clean --yes --symlink-dir /srv/bin
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runScanCommand(options, cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.Format, "format", "f", "table", "Output format (table, json)")
+	cmd.Flags().BoolVar(&options.Clean, "clean", false, "Remove orphaned symlinks after confirmation")
+	cmd.Flags().BoolVarP(&options.Yes, "yes", "y", false, "Do not ask for confirmation before cleaning")
+	cmd.Flags().StringVar(&options.DpkgRoot, "dpkg-root", "/", "Filesystem root whose dpkg database is consulted")
+
+	return cmd
+}
+
 // newListCommand creates a subcommand for listing symlinks
 func newListCommand(options *CommandOptions) *cobra.Command {
 	cmd := &cobra.Command{
@@ -109,11 +289,15 @@ func newListCommand(options *CommandOptions) *cobra.Command {
 
 This command displays information about symlinks that have been
 registered with the symlink processor, including their source,
-target, and security validation status.
+target, and security validation status. The owning package comes from the
+symlink state database or the ownership marker next to the link.
 
 Examples:
   pkginstall symlink list
   pkginstall symlink list --format json
+  pkginstall symlink list --package myapp
+  pkginstall symlink list --broken --quiet | xargs -r rm
+  pkginstall symlink list --dir /usr/local/bin --points-outside-opt --format yaml
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runListCommand(options)
@@ -122,6 +306,11 @@ Examples:
 
 	// Add list-specific flags
 	cmd.Flags().StringVarP(&options.Format, "format", "f", "table", "Output format (table, json, yaml)")
+	cmd.Flags().StringVarP(&options.Package, "package", "p", "", "Only list symlinks owned by this package")
+	cmd.Flags().StringSliceVar(&options.Dirs, "dir", nil, "Only list symlinks below this directory, scanning it instead of the symlink directories (repeatable)")
+	cmd.Flags().BoolVar(&options.Broken, "broken", false, "Only list symlinks whose source does not exist")
+	cmd.Flags().BoolVar(&options.OutsideRoot, "points-outside-opt", false, "Only list symlinks whose source resolves outside the transformed root (/opt by default)")
+	cmd.Flags().BoolVarP(&options.Quiet, "quiet", "q", false, "Print only the target paths, one per line")
 
 	return cmd
 }
@@ -156,6 +345,10 @@ Examples:
 
 // runCreateCommand handles the symlink creation logic
 func runCreateCommand(options *CommandOptions) error {
+	if options.Marker && options.Package == "" {
+		return fmt.Errorf("--marker requires --package")
+	}
+
 	// Normalize paths to absolute
 	source, err := filepath.Abs(options.Source)
 	if err != nil {
@@ -168,18 +361,27 @@ func runCreateCommand(options *CommandOptions) error {
 	}
 
 	// Create dependencies
-	pathMapper := security.NewPathMapper(
-		security.WithVerboseLogging(options.Verbose),
-	)
-	validator := security.NewValidator(
-		security.WithVerbose(options.Verbose),
-	)
+	pathMapper, err := newPathMapper(options)
+	if err != nil {
+		return err
+	}
+	validator, err := newValidator(options)
+	if err != nil {
+		return err
+	}
 
 	// Determine allowed symlink directories from PathMapper
 	symlinkDirs := pathMapper.GetSymlinkDirs()
 	manager := NewSymlinkManager(symlinkDirs)
 	processor := NewSymlinkProcessor(pathMapper, manager, validator, options.Verbose)
 	processor.SetDryRun(options.DryRun)
+	processor.SetRelative(options.Relative)
+	db, err := dpkgdb.Open(options.DpkgRoot)
+	if err != nil {
+		return fmt.Errorf("failed to read dpkg database: %w", err)
+	}
+	processor.SetManagedLinks(db, options.Alternatives)
+	processor.SetAlternativePriority(options.AlternativePriority)
 
 	// Validate that the source file exists
 	sourceInfo, err := os.Stat(source)
@@ -203,21 +405,37 @@ func runCreateCommand(options *CommandOptions) error {
 		description = fmt.Sprintf("Symlink from %s to %s", source, target)
 	}
 
+	summary := history.NewSummary("symlink create")
+	summary.DryRun = options.DryRun
+
+	// Record what is changed so "pkginstall rollback" can undo it
+	store, err := manifest.NewStore("")
+	if err != nil {
+		return fmt.Errorf("cannot record changes for rollback: %w", err)
+	}
+	record := manifest.New("symlink create")
+	defer saveManifest(store, record, options.DryRun)
+
+	// Targets dpkg maintains are refused, or routed through update-alternatives
+	request := SymlinkRequest{Source: source, Target: target, Description: description}
+	if err := processor.CheckManaged(&request); err != nil {
+		return err
+	}
+	if request.Alternative != "" {
+		return createAlternative(processor, request, summary)
+	}
+
 	// Check if target already exists
+	replace := false
 	if _, err := os.Lstat(target); err == nil {
 		if !options.Force {
 			return fmt.Errorf("target path already exists: %s (use --force to override)", target)
 		}
-		// Remove existing target if force is specified
-		if !options.DryRun {
-			if err := os.Remove(target); err != nil {
-				return fmt.Errorf("failed to remove existing target: %w", err)
-			}
-			if options.Verbose {
-				fmt.Printf("Removed existing target: %s\n", target)
-			}
-		} else {
-			fmt.Printf("[DRY RUN] Would remove existing target: %s\n", target)
+		summary.AddOverride(fmt.Sprintf("existing target %s replaced (--force)", target))
+		// The symlink is renamed over the target so the path never goes missing
+		replace = true
+		if options.DryRun {
+			fmt.Printf("[DRY RUN] Would replace existing target: %s\n", target)
 		}
 	}
 
@@ -233,26 +451,42 @@ func runCreateCommand(options *CommandOptions) error {
 		}
 	}
 
-	// Create symlink request
-	request := SymlinkRequest{
-		Source:      source,
-		Target:      target,
-		Description: description,
-	}
+	request.Replace = replace
 
 	// Queue the symlink
 	if err := processor.QueueSymlink(request); err != nil {
 		return fmt.Errorf("failed to queue symlink: %w", err)
 	}
 
+	// Back up the existing target right before it is replaced
+	if replace && !options.DryRun {
+		if err := store.Displace(record, target); err != nil {
+			return fmt.Errorf("refusing to replace existing target: %w", err)
+		}
+		if options.Verbose {
+			fmt.Printf("Backed up existing target: %s\n", target)
+		}
+	}
+
 	// Process the queued symlink
+	summary.AddSymlink(target, source)
 	if err := processor.ProcessQueuedSymlinks(); err != nil {
+		// The rename never happened, so the target was not displaced
+		record.Displaced = nil
+		summary.SetError(err)
+		history.Record(os.Stdout, summary)
 		return fmt.Errorf("failed to create symlink: %w", err)
 	}
 
+	// Record what the link actually contains, which rollback compares against
+	link, err := processor.LinkTarget(request)
+	if err != nil {
+		link = source
+	}
+
 	// Success message
 	if !options.DryRun {
-		fmt.Printf("Successfully created symlink: %s -> %s\n", target, source)
+		fmt.Printf("Successfully created symlink: %s -> %s\n", target, link)
 		// Add metadata about the file
 		if sourceInfo.IsDir() {
 			fmt.Printf("Source is a directory\n")
@@ -260,45 +494,262 @@ func runCreateCommand(options *CommandOptions) error {
 			fmt.Printf("Source is a file (%d bytes)\n", sourceInfo.Size())
 		}
 	} else {
-		fmt.Printf("[DRY RUN] Would create symlink: %s -> %s\n", target, source)
+		fmt.Printf("[DRY RUN] Would create symlink: %s -> %s\n", target, link)
 	}
 
+	if replace && !options.DryRun {
+		summary.AddAction(fmt.Sprintf("replaced existing %s", target))
+	}
+	record.AddSymlink(target, link)
+	if !options.DryRun {
+		recordOwner(target, link, options.Package, options.Marker)
+	}
+	history.Record(os.Stdout, summary)
 	return nil
 }
 
+// createAlternative registers the source of request with update-alternatives.
+// The link belongs to update-alternatives, so it is not recorded for rollback
+// or ownership.
+func createAlternative(processor *SymlinkProcessor, request SymlinkRequest, summary *history.Summary) error {
+	if err := processor.QueueSymlink(request); err != nil {
+		return fmt.Errorf("failed to queue symlink: %w", err)
+	}
+	summary.AddSymlink(request.Target, request.Source)
+	if err := processor.ProcessQueuedSymlinks(); err != nil {
+		summary.SetError(err)
+		history.Record(os.Stdout, summary)
+		return fmt.Errorf("failed to register alternative: %w", err)
+	}
+	if !summary.DryRun {
+		fmt.Printf("Registered %s as an alternative for %s (%s)\n", request.Source, request.Target, request.Alternative)
+		fmt.Printf("Undo with: update-alternatives --remove %s %s\n", request.Alternative, request.Source)
+		summary.AddAction(fmt.Sprintf("registered alternative %s for %s", request.Source, request.Alternative))
+	}
+	history.Record(os.Stdout, summary)
+	return nil
+}
+
+// runRemoveCommand removes a symlink, or every symlink owned by --package,
+// and restores the files they displaced
+func runRemoveCommand(options *CommandOptions) error {
+	state, err := LoadState("")
+	if err != nil {
+		return err
+	}
+
+	var targets []string
+	if options.Target != "" {
+		target, err := filepath.Abs(options.Target)
+		if err != nil {
+			return fmt.Errorf("invalid target path: %w", err)
+		}
+		if options.Package != "" && state.Owner(target) != options.Package {
+			return fmt.Errorf("%s is not owned by package %s", target, options.Package)
+		}
+		targets = []string{target}
+	} else {
+		if targets, err = ownedSymlinks(options, state, options.Package); err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("no symlinks owned by package %s", options.Package)
+		}
+	}
+
+	summary := history.NewSummary("symlink remove")
+	summary.DryRun = options.DryRun
+
+	store, err := manifest.NewStore("")
+	if err != nil {
+		return fmt.Errorf("cannot record changes for rollback: %w", err)
+	}
+	var errs []string
+	for _, target := range targets {
+		if err := removeSymlink(store, target, summary, options.DryRun); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if options.DryRun {
+			continue
+		}
+		state.Remove(target)
+		if err := RemoveMarker(target); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	if !options.DryRun {
+		if err := state.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update symlink state: %v\n", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("failed to remove %d symlink(s):\n- %s", len(errs), strings.Join(errs, "\n- "))
+	}
+	summary.SetError(err)
+	history.Record(os.Stdout, summary)
+	return err
+}
+
+// removeSymlink removes the symlink at target and restores the file it
+// displaced according to the rollback manifests
+func removeSymlink(store *manifest.Store, target string, summary *history.Summary, dryRun bool) error {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return fmt.Errorf("target path error: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("target is not a symlink: %s", target)
+	}
+
+	record, err := store.FindSymlink(target)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		// No manifest, so there is nothing to restore
+		if !dryRun {
+			if err := os.Remove(target); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", target, err)
+			}
+			auditlog.Record(auditlog.Entry{Action: auditlog.ActionSymlinkRemoved, Path: target})
+		}
+		fmt.Printf("No manifest records %s; nothing to restore\n", target)
+		summary.AddAction(fmt.Sprintf("removed symlink %s", target))
+		return nil
+	}
+
+	actions, err := manifest.RemoveSymlink(record, target, dryRun)
+	for _, action := range actions {
+		summary.AddAction(action)
+	}
+	if !dryRun && len(actions) > 0 {
+		if err := store.Save(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update rollback manifest %s: %v\n", record.ID, err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove symlink: %w", err)
+	}
+	return nil
+}
+
+// ownedSymlinks returns the symlinks owned by the named package, from the
+// state database and the ownership markers in the symlink directories
+func ownedSymlinks(options *CommandOptions, state *State, pkg string) ([]string, error) {
+	pathMapper, err := newPathMapper(options)
+	if err != nil {
+		return nil, err
+	}
+	// Directories without access are skipped, as list does
+	existing, _ := findExistingSymlinks(pathMapper.GetSymlinkDirs())
+
+	seen := make(map[string]bool)
+	var targets []string
+	add := func(target string) {
+		if !seen[target] && state.Owner(target) == pkg {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	for _, entry := range state.Package(pkg) {
+		add(entry.Target)
+	}
+	for _, link := range existing {
+		add(link.Target)
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// recordOwner adds a created symlink to the state database and writes its
+// ownership marker when requested. The symlink exists at this point, so
+// failures are only reported.
+func recordOwner(target, link, pkg string, marker bool) {
+	state, err := LoadState("")
+	if err == nil {
+		state.Add(StateEntry{Target: target, Source: link, Package: pkg})
+		err = state.Save()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record symlink state: %v\n", err)
+	}
+	if marker {
+		if err := WriteMarker(target, pkg, link); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// saveManifest persists a rollback manifest for a run that changed the system
+func saveManifest(store *manifest.Store, record *manifest.Manifest, dryRun bool) {
+	if dryRun || (len(record.Symlinks) == 0 && len(record.Displaced) == 0) {
+		return
+	}
+	if err := store.Save(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save rollback manifest: %v\n", err)
+		return
+	}
+	fmt.Printf("Rollback manifest: %s\n", record.ID)
+}
+
 // runListCommand handles the symlink listing logic
 func runListCommand(options *CommandOptions) error {
 	// Create a dummy processor to demonstrate functionality
 	// In a real implementation, this would access a persistent storage
 	// of symlinks or scan the filesystem
-	pathMapper := security.NewPathMapper(
-		security.WithVerboseLogging(options.Verbose),
-	)
-	validator := security.NewValidator(
-		security.WithVerbose(options.Verbose),
-	)
+	pathMapper, err := newPathMapper(options)
+	if err != nil {
+		return err
+	}
+	validator, err := newValidator(options)
+	if err != nil {
+		return err
+	}
 	manager := NewSymlinkManager(pathMapper.GetSymlinkDirs())
 	processor := NewSymlinkProcessor(pathMapper, manager, validator, options.Verbose)
 
-	// Get existing symlinks - in a real implementation, this might scan specific directories
-	// or read from a database of created symlinks
-	existingSymlinks, err := findExistingSymlinks(pathMapper.GetSymlinkDirs())
+	// Get existing symlinks from the symlink directories, or only the
+	// directories given with --dir
+	dirs := pathMapper.GetSymlinkDirs()
+	if len(options.Dirs) > 0 {
+		dirs = options.Dirs
+	}
+	existingSymlinks, err := findExistingSymlinks(dirs)
 	if err != nil {
-		fmt.Printf("Warning: Error scanning for existing symlinks: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Error scanning for existing symlinks: %v\n", err)
 		// Continue execution to show queued symlinks, if any
 	}
 
+	state, err := LoadState("")
+	if err != nil {
+		return err
+	}
+	existingSymlinks = tagOwners(existingSymlinks, state, options.Package)
+
+	filter := listFilter{dirs: options.Dirs, broken: options.Broken}
+	if options.OutsideRoot {
+		filter.outsideRoot = pathMapper.GetTransformedRoot()
+	}
+	existingSymlinks = filter.apply(existingSymlinks)
+
 	// Get queued symlinks
-	queuedSymlinks := processor.GetQueuedSymlinks()
+	queuedSymlinks := filter.apply(processor.GetQueuedSymlinks())
+
+	if options.Quiet {
+		printSymlinksQuiet(os.Stdout, existingSymlinks, queuedSymlinks)
+		return nil
+	}
 
 	// Display based on format
 	switch strings.ToLower(options.Format) {
 	case "table":
-		printSymlinksTable(existingSymlinks, queuedSymlinks, options.Verbose)
+		printSymlinksTable(os.Stdout, existingSymlinks, queuedSymlinks)
 	case "json":
-		printSymlinksJSON(existingSymlinks, queuedSymlinks)
+		return printSymlinksJSON(os.Stdout, existingSymlinks, queuedSymlinks)
 	case "yaml":
-		printSymlinksYAML(existingSymlinks, queuedSymlinks)
+		return printSymlinksYAML(os.Stdout, existingSymlinks, queuedSymlinks)
 	default:
 		return fmt.Errorf("unknown output format: %s", options.Format)
 	}
@@ -306,6 +757,107 @@ func runListCommand(options *CommandOptions) error {
 	return nil
 }
 
+// listFilter selects the symlinks shown by symlink list
+type listFilter struct {
+	dirs        []string // Only symlinks below one of these directories
+	broken      bool     // Only symlinks whose source does not exist
+	outsideRoot string   // Only symlinks whose source resolves outside this directory
+}
+
+// apply returns the symlinks matching every criterion of the filter
+func (f listFilter) apply(links []SymlinkRequest) []SymlinkRequest {
+	var matched []SymlinkRequest
+	for _, link := range links {
+		if f.match(link) {
+			matched = append(matched, link)
+		}
+	}
+	return matched
+}
+
+// match reports whether link matches every criterion of the filter
+func (f listFilter) match(link SymlinkRequest) bool {
+	if len(f.dirs) > 0 {
+		below := false
+		for _, dir := range f.dirs {
+			below = below || isBelow(link.Target, dir)
+		}
+		if !below {
+			return false
+		}
+	}
+	if f.broken && !isBroken(link.Target) {
+		return false
+	}
+	if f.outsideRoot != "" {
+		// The root itself may be a symlink, such as /opt -> /var/opt
+		source, err := security.ResolveSymlinks(link.Source)
+		if err != nil {
+			source = link.Source
+		}
+		root, err := security.ResolveSymlinks(f.outsideRoot)
+		if err != nil {
+			root = f.outsideRoot
+		}
+		if isBelow(source, f.outsideRoot) || isBelow(source, root) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBroken reports whether the symlink at target points to nothing
+func isBroken(target string) bool {
+	_, err := os.Stat(target)
+	return err != nil
+}
+
+// isBelow reports whether path is dir or lies below it
+func isBelow(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// tagOwners sets the owning package of existing symlinks and adds the ones
+// in the state database outside the scanned directories. With pkg set, only
+// the symlinks owned by that package are returned.
+func tagOwners(existing []SymlinkRequest, state *State, pkg string) []SymlinkRequest {
+	seen := make(map[string]bool)
+	for i := range existing {
+		existing[i].Package = state.Owner(existing[i].Target)
+		seen[existing[i].Target] = true
+	}
+	for _, entry := range state.Entries {
+		if seen[entry.Target] {
+			continue
+		}
+		if current, err := os.Readlink(entry.Target); err != nil || current != entry.Source {
+			continue
+		}
+		source := entry.Source
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(filepath.Dir(entry.Target), source)
+		}
+		existing = append(existing, SymlinkRequest{
+			Source:      source,
+			Target:      entry.Target,
+			Description: "Existing symlink",
+			Package:     entry.Package,
+		})
+	}
+
+	if pkg == "" {
+		return existing
+	}
+	var owned []SymlinkRequest
+	for _, link := range existing {
+		if link.Package == pkg {
+			owned = append(owned, link)
+		}
+	}
+	return owned
+}
+
 // runValidateCommand handles the symlink validation logic
 func runValidateCommand(options *CommandOptions) error {
 	// Normalize path to absolute
@@ -315,13 +867,14 @@ func runValidateCommand(options *CommandOptions) error {
 	}
 
 	// Create dependencies
-	pathMapper := security.NewPathMapper(
-		security.WithVerboseLogging(options.Verbose),
-	)
-	validator := security.NewValidator(
-		security.WithVerbose(options.Verbose),
-		security.WithTransformedDir("/opt"),
-	)
+	pathMapper, err := newPathMapper(options)
+	if err != nil {
+		return err
+	}
+	validator, err := newValidator(options, security.WithTransformedDir("/opt"))
+	if err != nil {
+		return err
+	}
 
 	// Check if the target exists
 	fileInfo, err := os.Lstat(target)
@@ -396,6 +949,71 @@ func runValidateCommand(options *CommandOptions) error {
 	return nil
 }
 
+// loadPolicy returns the security policy given with --policy, or nil
+func loadPolicy(options *CommandOptions) (*security.PolicyFile, error) {
+	if options.PolicyFile == "" || options.policy != nil {
+		return options.policy, nil
+	}
+	policy, err := security.LoadPolicyFile(options.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	options.policy = policy
+	return policy, nil
+}
+
+// newValidator creates a Validator honouring the --profile and --policy flags
+func newValidator(options *CommandOptions, opts ...security.ValidatorOption) (*security.Validator, error) {
+	profile, err := security.LookupProfile(options.Profile)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := loadPolicy(options)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]security.ValidatorOption{security.WithVerbose(options.Verbose)}, opts...)
+	opts = append(opts, profile.ValidatorOptions()...)
+	if policy != nil {
+		opts = append(opts, policy.ValidatorOptions()...)
+	}
+	return security.NewValidator(opts...), nil
+}
+
+// newPathMapper creates a PathMapper using the effective symlink directories
+// from the policy file, configuration file and --symlink-dir flags
+func newPathMapper(options *CommandOptions) (*security.PathMapper, error) {
+	var configured []string
+	if options.ConfigFile != "" {
+		cfg, err := config.LoadConfig(options.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration: %w", err)
+		}
+		configured = cfg.SymlinkDirs
+	}
+
+	policy, err := loadPolicy(options)
+	if err != nil {
+		return nil, err
+	}
+	opts := []security.PathMapperOption{security.WithVerboseLogging(options.Verbose)}
+	if policy != nil {
+		opts = append(opts, policy.PathMapperOptions()...)
+		// Symlink directories from the policy take precedence over the config file
+		if len(policy.PathMapping.SymlinkDirs) > 0 {
+			configured = policy.PathMapping.SymlinkDirs
+		}
+	}
+
+	symlinkDirs := security.ResolveSymlinkDirs(configured, options.SymlinkDirs)
+	if options.Verbose {
+		fmt.Printf("Effective symlink directories: %s\n", strings.Join(symlinkDirs, ", "))
+	}
+
+	return security.NewPathMapper(append(opts, security.WithSymlinkDirs(symlinkDirs))...), nil
+}
+
 // findExistingSymlinks scans specified directories for symlinks
 func findExistingSymlinks(dirs []string) ([]SymlinkRequest, error) {
 	var symlinks []SymlinkRequest
@@ -443,65 +1061,91 @@ func findExistingSymlinks(dirs []string) ([]SymlinkRequest, error) {
 	return symlinks, nil
 }
 
+// listedSymlink is a symlink as printed by symlink list
+type listedSymlink struct {
+	Target      string `json:"target" yaml:"target"`
+	Source      string `json:"source" yaml:"source"`
+	Package     string `json:"package,omitempty" yaml:"package,omitempty"`
+	Description string `json:"description" yaml:"description"`
+	Broken      bool   `json:"broken,omitempty" yaml:"broken,omitempty"`
+}
+
+// symlinkListing is the output of symlink list in JSON and YAML
+type symlinkListing struct {
+	Existing []listedSymlink `json:"existing" yaml:"existing"`
+	Queued   []listedSymlink `json:"queued" yaml:"queued"`
+}
+
+// newSymlinkListing converts symlinks for encoding; empty lists stay lists
+func newSymlinkListing(existing, queued []SymlinkRequest) symlinkListing {
+	convert := func(requests []SymlinkRequest) []listedSymlink {
+		listed := make([]listedSymlink, 0, len(requests))
+		for _, r := range requests {
+			listed = append(listed, listedSymlink{
+				Target:      r.Target,
+				Source:      r.Source,
+				Package:     r.Package,
+				Description: r.Description,
+				Broken:      isBroken(r.Target),
+			})
+		}
+		return listed
+	}
+	return symlinkListing{Existing: convert(existing), Queued: convert(queued)}
+}
+
 // printSymlinksTable prints symlinks in a table format
-func printSymlinksTable(existing, queued []SymlinkRequest, verbose bool) {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+func printSymlinksTable(out io.Writer, existing, queued []SymlinkRequest) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
 
-	fmt.Fprintln(w, "TYPE\tTARGET\tSOURCE\tDESCRIPTION")
-	fmt.Fprintln(w, "----\t------\t------\t-----------")
+	fmt.Fprintln(w, "TYPE\tTARGET\tSOURCE\tPACKAGE\tDESCRIPTION")
+	fmt.Fprintln(w, "----\t------\t------\t-------\t-----------")
 
 	for _, s := range existing {
-		fmt.Fprintf(w, "Existing\t%s\t%s\t%s\n", s.Target, s.Source, s.Description)
+		kind := "Existing"
+		if isBroken(s.Target) {
+			kind = "Broken"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", kind, s.Target, s.Source, packageColumn(s.Package), s.Description)
 	}
 
 	for _, s := range queued {
-		fmt.Fprintf(w, "Queued\t%s\t%s\t%s\n", s.Target, s.Source, s.Description)
+		fmt.Fprintf(w, "Queued\t%s\t%s\t%s\t%s\n", s.Target, s.Source, packageColumn(s.Package), s.Description)
 	}
 
 	w.Flush()
 
-	fmt.Printf("\nTotal: %d existing, %d queued symlinks\n", len(existing), len(queued))
+	fmt.Fprintf(out, "\nTotal: %d existing, %d queued symlinks\n", len(existing), len(queued))
 }
 
-// printSymlinksJSON prints symlinks in JSON format
-func printSymlinksJSON(existing, queued []SymlinkRequest) {
-	// Simple JSON output for demonstration
-	fmt.Println("{")
-	fmt.Println("  \"existing\": [")
-	for i, s := range existing {
-		fmt.Printf("    {\"target\": \"%s\", \"source\": \"%s\", \"description\": \"%s\"}",
-			s.Target, s.Source, s.Description)
-		if i < len(existing)-1 {
-			fmt.Println(",")
-		} else {
-			fmt.Println("")
-		}
-	}
-	fmt.Println("  ],")
-	fmt.Println("  \"queued\": [")
-	for i, s := range queued {
-		fmt.Printf("    {\"target\": \"%s\", \"source\": \"%s\", \"description\": \"%s\"}",
-			s.Target, s.Source, s.Description)
-		if i < len(queued)-1 {
-			fmt.Println(",")
-		} else {
-			fmt.Println("")
-		}
+// packageColumn returns the table cell for an owning package
+func packageColumn(pkg string) string {
+	if pkg == "" {
+		return "-"
 	}
-	fmt.Println("  ]")
-	fmt.Println("}")
+	return pkg
+}
+
+// printSymlinksJSON prints symlinks in JSON format
+func printSymlinksJSON(out io.Writer, existing, queued []SymlinkRequest) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newSymlinkListing(existing, queued))
 }
 
 // printSymlinksYAML prints symlinks in YAML format
-func printSymlinksYAML(existing, queued []SymlinkRequest) {
-	fmt.Println("existing:")
-	for _, s := range existing {
-		fmt.Printf("  - target: %s\n    source: %s\n    description: %s\n",
-			s.Target, s.Source, s.Description)
+func printSymlinksYAML(out io.Writer, existing, queued []SymlinkRequest) error {
+	data, err := yaml.Marshal(newSymlinkListing(existing, queued))
+	if err != nil {
+		return fmt.Errorf("failed to encode symlinks: %w", err)
 	}
-	fmt.Println("queued:")
-	for _, s := range queued {
-		fmt.Printf("  - target: %s\n    source: %s\n    description: %s\n",
-			s.Target, s.Source, s.Description)
+	_, err = out.Write(data)
+	return err
+}
+
+// printSymlinksQuiet prints one target path per line for scripts
+func printSymlinksQuiet(out io.Writer, existing, queued []SymlinkRequest) {
+	for _, s := range append(append([]SymlinkRequest{}, existing...), queued...) {
+		fmt.Fprintln(out, s.Target)
 	}
 }
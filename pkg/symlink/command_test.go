@@ -1 +1,120 @@
 package symlink
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPrintSymlinks(t *testing.T) {
+	existing := []SymlinkRequest{
+		{Source: `/opt/my "app"/bin/app`, Target: `/usr/local/bin/app"; rm -rf /`, Package: "app", Description: "Quote \" and backslash \\"},
+		{Source: "/opt/tool: weird\n", Target: "/usr/local/bin/- tool", Description: "Existing symlink"},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := printSymlinksJSON(&out, existing, nil); err != nil {
+			t.Fatalf("printSymlinksJSON() error = %v", err)
+		}
+		var listing symlinkListing
+		if err := json.Unmarshal(out.Bytes(), &listing); err != nil {
+			t.Fatalf("Output is not valid JSON: %v\n%s", err, out.String())
+		}
+		if !reflect.DeepEqual(listing, newSymlinkListing(existing, nil)) {
+			t.Errorf("JSON round trip = %+v", listing)
+		}
+		if listing.Queued == nil {
+			t.Errorf("Expected an empty queued list, not null")
+		}
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := printSymlinksYAML(&out, existing, nil); err != nil {
+			t.Fatalf("printSymlinksYAML() error = %v", err)
+		}
+		var listing symlinkListing
+		if err := yaml.Unmarshal(out.Bytes(), &listing); err != nil {
+			t.Fatalf("Output is not valid YAML: %v\n%s", err, out.String())
+		}
+		if !reflect.DeepEqual(listing.Existing, newSymlinkListing(existing, nil).Existing) {
+			t.Errorf("YAML round trip = %+v", listing)
+		}
+	})
+
+	t.Run("Quiet", func(t *testing.T) {
+		var out bytes.Buffer
+		printSymlinksQuiet(&out, existing, nil)
+		if want := existing[0].Target + "\n" + existing[1].Target + "\n"; out.String() != want {
+			t.Errorf("printSymlinksQuiet() = %q, want %q", out.String(), want)
+		}
+	})
+}
+
+func TestListFilter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink-list-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	root := filepath.Join(tempDir, "opt")
+	for _, dir := range []string{"opt/app", "bin", "sbin"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "app", "app"), nil, 0755); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	// escape points outside the root through a symlink inside it
+	if err := os.Symlink("/etc", filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	links := map[string]string{
+		"bin/app":     filepath.Join(root, "app", "app"),
+		"bin/missing": filepath.Join(root, "app", "missing"),
+		"bin/shell":   "/bin/sh",
+		"sbin/escape": filepath.Join(root, "escape", "passwd"),
+	}
+	for target, source := range links {
+		if err := os.Symlink(source, filepath.Join(tempDir, target)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+	existing, err := findExistingSymlinks([]string{tempDir})
+	if err != nil {
+		t.Fatalf("findExistingSymlinks() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter listFilter
+		want   []string
+	}{
+		{"No filter", listFilter{}, []string{"bin/app", "bin/missing", "bin/shell", "opt/escape", "sbin/escape"}},
+		{"Directory", listFilter{dirs: []string{filepath.Join(tempDir, "sbin")}}, []string{"sbin/escape"}},
+		{"Broken", listFilter{broken: true, dirs: []string{filepath.Join(tempDir, "bin")}}, []string{"bin/missing"}},
+		{"Points outside the root", listFilter{outsideRoot: root, dirs: []string{filepath.Join(tempDir, "bin"), filepath.Join(tempDir, "sbin")}}, []string{"bin/shell", "sbin/escape"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, link := range tt.filter.apply(existing) {
+				rel, _ := filepath.Rel(tempDir, link.Target)
+				got = append(got, rel)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package symlink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// sysOpenat2 is the openat2 system call number, the same on every Linux
+// architecture Go supports; the syscall package does not define it
+const sysOpenat2 = 437
+
+// resolveNoSymlinks is RESOLVE_NO_SYMLINKS: openat2 fails with ELOOP if any
+// component of the path is a symlink
+const resolveNoSymlinks = 0x04
+
+// atFDCWD is AT_FDCWD, which the syscall package only defines on some
+// architectures
+const atFDCWD = -0x64
+
+// oPath is O_PATH, which has the same value on every Linux architecture Go
+// supports but is missing from the syscall package on some of them
+const oPath = 0x200000
+
+// openHow is struct open_how of openat2
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+// maxSystemLinks bounds the system symlinks followed while resolving a
+// directory, like the kernel's limit on nested links
+const maxSystemLinks = 40
+
+// createSymlink creates target as a symlink to source. The parent directory
+// is resolved through system symlinks such as /bin -> usr/bin, then opened
+// without following symlinks in any of its components, and the link is
+// created relative to that descriptor with symlinkat, which fails if target
+// exists. Swapping a directory of the path for a symlink between a check and
+// the creation therefore cannot redirect the link.
+func createSymlink(source, target string) error {
+	dirfd, name, err := openParentNoFollow(target)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: source, New: target, Err: err}
+	}
+	defer syscall.Close(dirfd)
+
+	if err := symlinkat(source, dirfd, name); err != nil {
+		return &os.LinkError{Op: "symlink", Old: source, New: target, Err: err}
+	}
+	return nil
+}
+
+// replaceSymlink replaces target with a symlink to source. The link is
+// created under a temporary name with symlinkat and renamed over target with
+// renameat, both relative to the parent directory descriptor that
+// createSymlink would use.
+func replaceSymlink(source, target string) error {
+	dirfd, name, err := openParentNoFollow(target)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(dirfd)
+
+	var tmp string
+	for attempt := 0; ; attempt++ {
+		if tmp, err = tempLinkName(name); err != nil {
+			return err
+		}
+		err = symlinkat(source, dirfd, tmp)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EEXIST) || attempt == maxTempAttempts {
+			return err
+		}
+	}
+	if err := syscall.Renameat(dirfd, tmp, dirfd, name); err != nil {
+		syscall.Unlinkat(dirfd, tmp)
+		return err
+	}
+	return nil
+}
+
+// openParentNoFollow opens the directory of the absolute path target with
+// openDirNoFollow, after resolving its system symlinks, and returns the
+// descriptor and the last component of target
+func openParentNoFollow(target string) (int, string, error) {
+	dir, name := filepath.Split(filepath.Clean(target))
+	if name == "" || name == "." || name == ".." || !filepath.IsAbs(dir) {
+		return -1, "", syscall.EINVAL
+	}
+	resolved, err := resolveSystemLinks(dir)
+	if err != nil {
+		return -1, "", err
+	}
+	dirfd, err := openDirNoFollow(resolved)
+	if err != nil {
+		return -1, "", err
+	}
+	return dirfd, name, nil
+}
+
+// resolveSystemLinks resolves the symlinks of the absolute directory dir
+// that only root could have created: links owned by root in directories
+// owned by root that no one else can write to, such as /bin -> usr/bin on
+// merged-/usr systems. It stops at the first other symlink and returns the
+// rest of the path unresolved, for openDirNoFollow to reject.
+func resolveSystemLinks(dir string) (string, error) {
+	resolved := "/"
+	rest := strings.Split(filepath.Clean(dir), "/")
+	for followed := 0; len(rest) > 0; {
+		component := rest[0]
+		rest = rest[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, component)
+		var st syscall.Stat_t
+		if err := syscall.Lstat(next, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+			resolved = next
+			continue
+		}
+		if !systemLink(resolved, &st) {
+			return filepath.Join(append([]string{next}, rest...)...), nil
+		}
+		if followed++; followed > maxSystemLinks {
+			return "", &os.PathError{Op: "open", Path: dir, Err: syscall.ELOOP}
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(link, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// systemLink reports whether the symlink st in the directory parent is owned
+// by root and parent can only be written by root
+func systemLink(parent string, st *syscall.Stat_t) bool {
+	var dirSt syscall.Stat_t
+	if st.Uid != 0 || syscall.Stat(parent, &dirSt) != nil {
+		return false
+	}
+	return dirSt.Uid == 0 && dirSt.Mode&0022 == 0
+}
+
+// openDirNoFollow opens the absolute directory dir as an O_PATH descriptor,
+// failing if any component is a symlink. It uses openat2 with
+// RESOLVE_NO_SYMLINKS and falls back to openDirByComponent on kernels before
+// 5.6 or where seccomp filters openat2.
+func openDirNoFollow(dir string) (int, error) {
+	fd, err := openat2(dir, oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, resolveNoSymlinks)
+	if !errors.Is(err, syscall.ENOSYS) && !errors.Is(err, syscall.EPERM) {
+		if errors.Is(err, syscall.ELOOP) {
+			return -1, errSymlinkInPath(dir)
+		}
+		return fd, err
+	}
+	return openDirByComponent(dir)
+}
+
+// openDirByComponent opens the absolute directory dir one component at a
+// time with O_NOFOLLOW, failing if any component is a symlink
+func openDirByComponent(dir string) (int, error) {
+	fd, err := syscall.Open("/", oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	for _, component := range strings.Split(dir, "/") {
+		if component == "" || component == "." {
+			continue
+		}
+		if component == ".." {
+			syscall.Close(fd)
+			return -1, syscall.EINVAL
+		}
+		next, err := syscall.Openat(fd, component, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return -1, err
+		}
+		// With O_PATH|O_NOFOLLOW a symlink opens as itself
+		var st syscall.Stat_t
+		if err := syscall.Fstat(next, &st); err != nil {
+			syscall.Close(next)
+			return -1, err
+		}
+		switch st.Mode & syscall.S_IFMT {
+		case syscall.S_IFDIR:
+		case syscall.S_IFLNK:
+			syscall.Close(next)
+			return -1, errSymlinkInPath(dir)
+		default:
+			syscall.Close(next)
+			return -1, syscall.ENOTDIR
+		}
+		fd = next
+	}
+	return fd, nil
+}
+
+// errSymlinkInPath explains why a directory reached through a symlink is refused
+func errSymlinkInPath(dir string) error {
+	return &os.PathError{Op: "open", Path: dir, Err: errors.New("a component of the directory is a symlink; use the resolved directory")}
+}
+
+// openat2 calls openat2(AT_FDCWD, path, how) and returns the new descriptor
+func openat2(path string, flags int, resolve uint64) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	how := openHow{flags: uint64(flags), resolve: resolve}
+	cwd := atFDCWD
+	fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(cwd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// symlinkat calls symlinkat(oldpath, dirfd, newpath)
+func symlinkat(oldpath string, dirfd int, newpath string) error {
+	oldp, err := syscall.BytePtrFromString(oldpath)
+	if err != nil {
+		return err
+	}
+	newp, err := syscall.BytePtrFromString(newpath)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(oldp)), uintptr(dirfd), uintptr(unsafe.Pointer(newp)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
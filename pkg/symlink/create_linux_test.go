@@ -0,0 +1,108 @@
+package symlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestOpenDirNoFollow(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	if tempDir, err = filepath.EvalSymlinks(tempDir); err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+
+	realDir := filepath.Join(tempDir, "real", "bin")
+	if err := os.MkdirAll(realDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tempDir, "real"), filepath.Join(tempDir, "swapped")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr string
+	}{
+		{"Real directory", realDir, ""},
+		{"Symlinked component", filepath.Join(tempDir, "swapped", "bin"), "is a symlink"},
+		{"Symlink as the last component", filepath.Join(tempDir, "swapped"), "is a symlink"},
+		{"Not a directory", filepath.Join(tempDir, "file"), "not a directory"},
+		{"Missing directory", filepath.Join(tempDir, "missing"), "no such file"},
+	}
+
+	open := map[string]func(string) (int, error){
+		"openat2":      openDirNoFollow,
+		"by component": openDirByComponent,
+	}
+	for method, openDir := range open {
+		for _, tt := range tests {
+			t.Run(method+"/"+tt.name, func(t *testing.T) {
+				fd, err := openDir(tt.dir)
+				if err == nil {
+					syscall.Close(fd)
+				}
+				if tt.wantErr == "" && err != nil {
+					t.Errorf("open %s: %v", tt.dir, err)
+				}
+				if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+					t.Errorf("open %s: error = %v, want %q", tt.dir, err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateSymlinkSystemLinks(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("system symlinks must be owned by root")
+	}
+	tempDir, err := ioutil.TempDir("", "symlink_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	if tempDir, err = filepath.EvalSymlinks(tempDir); err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+
+	// An allowed directory reached through a root-owned link, like /bin -> usr/bin
+	if err := os.MkdirAll(filepath.Join(tempDir, "usr", "bin"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	merged := filepath.Join(tempDir, "bin")
+	if err := os.Symlink("usr/bin", merged); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := createSymlink("/opt/app/bin/app", filepath.Join(merged, "app")); err != nil {
+		t.Fatalf("createSymlink() through a system link error = %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(tempDir, "usr", "bin", "app")); err != nil || target != "/opt/app/bin/app" {
+		t.Errorf("Readlink() = %q, %v", target, err)
+	}
+
+	// The same link owned by another user is not followed
+	if err := os.Lchown(merged, 65534, 65534); err != nil {
+		t.Fatalf("Lchown() error = %v", err)
+	}
+	if err := createSymlink("/opt/app/bin/other", filepath.Join(merged, "other")); err == nil || !strings.Contains(err.Error(), "is a symlink") {
+		t.Errorf("createSymlink() through a user's link error = %v, want a symlink error", err)
+	}
+
+	if info, err := os.Lstat("/bin"); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if resolved, err := resolveSystemLinks("/bin"); err != nil || resolved != "/usr/bin" {
+			t.Errorf("resolveSystemLinks(/bin) = %q, %v, want /usr/bin", resolved, err)
+		}
+	}
+}
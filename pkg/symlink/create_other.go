@@ -0,0 +1,43 @@
+//go:build !linux
+// +build !linux
+
+package symlink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// createSymlink creates target as a symlink to source; it fails if target
+// exists. Without openat2 the parent directory is resolved by path, so
+// symlinks in it are followed.
+func createSymlink(source, target string) error {
+	return os.Symlink(source, target)
+}
+
+// replaceSymlink replaces target with a symlink to source, created under a
+// temporary name and renamed over target by path
+func replaceSymlink(source, target string) error {
+	dir, name := filepath.Split(target)
+	var tmp string
+	for attempt := 0; ; attempt++ {
+		base, err := tempLinkName(name)
+		if err != nil {
+			return err
+		}
+		tmp = filepath.Join(dir, base)
+		err = createSymlink(source, tmp)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) || attempt == maxTempAttempts {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package symlink
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 )
@@ -16,20 +19,57 @@ func NewSymlinkManager(symlinkDirs []string) *SymlinkManager {
 }
 
 // CreateSymlink creates a symlink at the target location pointing to the source.
-// It checks for existing files to prevent overwriting.
+// It never overwrites an existing file: the existence check is part of the
+// creation itself, and on Linux the parent directory must not be reached
+// through a symlink, so neither can be swapped in between.
 func (sm *SymlinkManager) CreateSymlink(source, target string) error {
-	if _, err := os.Lstat(target); err == nil {
-		return fmt.Errorf("collision detected: target %s already exists", target)
+	if err := createSymlink(source, target); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("collision detected: target %s already exists", target)
+		}
+		return fmt.Errorf("failed to create symlink from %s to %s: %v", source, target, err)
 	}
 
-	err := os.Symlink(source, target)
+	return nil
+}
+
+// ReplaceSymlink atomically replaces the file or symlink at target with a
+// symlink to source. The new link is created under a random temporary name
+// in the same directory, with the safeguards of CreateSymlink, and renamed
+// over target relative to the same directory descriptor, so target never
+// goes missing and the directory cannot be swapped in between. A target
+// that does not exist is created; directories are never replaced.
+func (sm *SymlinkManager) ReplaceSymlink(source, target string) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return sm.CreateSymlink(source, target)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create symlink from %s to %s: %v", source, target, err)
+		return fmt.Errorf("failed to inspect target %s: %v", target, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("refusing to replace directory %s with a symlink", target)
 	}
 
+	if err := replaceSymlink(source, target); err != nil {
+		return fmt.Errorf("failed to replace %s with a symlink to %s: %v", target, source, err)
+	}
 	return nil
 }
 
+// maxTempAttempts bounds the temporary names tried for a replacement link
+const maxTempAttempts = 10
+
+// tempLinkName returns a random hidden name next to name for the link that
+// replaces it
+func tempLinkName(name string) (string, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return "." + name + ".pkginstall-" + hex.EncodeToString(suffix[:]), nil
+}
+
 // IsSymlinkAllowed checks if the symlink can be created in the specified directory.
 func (sm *SymlinkManager) IsSymlinkAllowed(dir string) bool {
 	for _, allowedDir := range sm.symlinkDirs {
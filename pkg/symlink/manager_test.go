@@ -3,6 +3,9 @@ package symlink
 import (
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -150,6 +153,124 @@ func TestSymlinkManager_CreateSymlink(t *testing.T) {
 			t.Errorf("Expected error message to contain 'failed to create symlink', got: %v", err)
 		}
 	})
+
+	t.Run("parent directory swapped for a symlink", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("Symlinked parent directories are only refused on Linux")
+		}
+		realDir := filepath.Join(tempDir, "real_dir")
+		if err := os.Mkdir(realDir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		linkDir := filepath.Join(tempDir, "link_dir")
+		if err := os.Symlink(realDir, linkDir); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		// Root's links count as system links; give it to another user, as an attacker's would be
+		if os.Geteuid() == 0 {
+			if err := os.Lchown(linkDir, 65534, 65534); err != nil {
+				t.Fatalf("Lchown() error = %v", err)
+			}
+		}
+
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		err := sm.CreateSymlink(sourceFile, filepath.Join(linkDir, "target.link"))
+		if err == nil || !containsSubstring(err.Error(), "failed to create symlink") {
+			t.Errorf("Expected the symlinked parent directory to be refused, got: %v", err)
+		}
+		if _, err := os.Lstat(filepath.Join(realDir, "target.link")); err == nil {
+			t.Errorf("Symlink was created through the symlinked parent directory")
+		}
+	})
+}
+
+func TestSymlinkManager_ReplaceSymlink(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "symlink_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sm := NewSymlinkManager([]string{tempDir})
+	source := filepath.Join(tempDir, "source.txt")
+
+	tests := []struct {
+		name    string
+		setup   func(target string) error
+		wantErr string
+	}{
+		{"Regular file", func(target string) error { return os.WriteFile(target, []byte("original"), 0644) }, ""},
+		{"Existing symlink", func(target string) error { return os.Symlink("/somewhere", target) }, ""},
+		{"Missing target", func(target string) error { return nil }, ""},
+		{"Directory", func(target string) error { return os.Mkdir(target, 0755) }, "refusing to replace directory"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(tempDir, strconv.Itoa(i))
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			target := filepath.Join(dir, "target")
+			if err := tt.setup(target); err != nil {
+				t.Fatalf("Failed to set up target: %v", err)
+			}
+
+			err := sm.ReplaceSymlink(source, target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ReplaceSymlink() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReplaceSymlink() error = %v", err)
+			}
+			if link, err := os.Readlink(target); err != nil || link != source {
+				t.Errorf("Expected %s to point to %s, got %q, %v", target, source, link, err)
+			}
+			// Only the replaced target is left, no temporary link
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("Expected only the target in %s, got %d entries", dir, len(entries))
+			}
+		})
+	}
+
+	t.Run("Parent directory reached through a symlink", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("Symlinked parent directories are only refused on Linux")
+		}
+		realDir := filepath.Join(tempDir, "real_dir")
+		if err := os.Mkdir(realDir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(realDir, "target"), []byte("original"), 0644); err != nil {
+			t.Fatalf("Failed to write target: %v", err)
+		}
+		linkDir := filepath.Join(tempDir, "link_dir")
+		if err := os.Symlink(realDir, linkDir); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		if os.Geteuid() == 0 {
+			if err := os.Lchown(linkDir, 65534, 65534); err != nil {
+				t.Fatalf("Lchown() error = %v", err)
+			}
+		}
+
+		if err := sm.ReplaceSymlink(source, filepath.Join(linkDir, "target")); err == nil || !strings.Contains(err.Error(), "is a symlink") {
+			t.Errorf("ReplaceSymlink() error = %v, want the symlinked parent refused", err)
+		}
+		if info, err := os.Lstat(filepath.Join(realDir, "target")); err != nil || !info.Mode().IsRegular() {
+			t.Errorf("Expected the target through the symlinked parent to be kept, got %v, %v", info, err)
+		}
+	})
+
+	// Temporary names are random rather than derived from the time
+	first, _ := tempLinkName("target")
+	second, _ := tempLinkName("target")
+	if first == second || !strings.HasPrefix(first, ".target.pkginstall-") {
+		t.Errorf("tempLinkName() = %q, %q", first, second)
+	}
 }
 
 // Helper function to check if a string contains a substring
@@ -1,19 +1,38 @@
 package symlink
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/logging"
 	"github.com/go-i2p/go-pkginstall/pkg/security"
 )
 
+// DefaultAlternativePriority is the priority of alternatives registered for
+// targets managed by update-alternatives
+const DefaultAlternativePriority = 50
+
+// ErrManagedByDpkg is returned for symlink targets maintained by
+// update-alternatives or dpkg-divert, which dpkg would fight over
+var ErrManagedByDpkg = errors.New("target is managed by dpkg")
+
 // SymlinkRequest represents a request to create a symlink
 type SymlinkRequest struct {
 	Source      string // The secure source path
 	Target      string // The system target path
 	Description string // Description of what this symlink is for
+	Replace     bool   // Atomically replace an existing file at Target
+	Package     string // Package owning the symlink, if known
+	Alternative string // Register Source under this alternative instead of linking directly
 }
 
 // SymlinkProcessor integrates path transformation with symlink creation
@@ -27,7 +46,12 @@ type SymlinkProcessor struct {
 	queueMutex     sync.Mutex
 	verbose        bool
 	dryRun         bool
-	logFunc        func(format string, args ...interface{}) (int, error)
+	relative       bool
+	transactional  bool
+	dpkgDB         *dpkgdb.Database // Checked for targets dpkg maintains, if set
+	routeManaged   bool
+	priority       int
+	logger         *slog.Logger // Default: slog.Default()
 }
 
 // NewSymlinkProcessor creates a new SymlinkProcessor with the provided dependencies
@@ -44,13 +68,19 @@ func NewSymlinkProcessor(
 		symlinkQueue:   make([]SymlinkRequest, 0),
 		verbose:        verbose,
 		dryRun:         false,
-		logFunc:        fmt.Printf,
+		priority:       DefaultAlternativePriority,
 	}
 }
 
-// SetLogger allows customizing the logging function
-func (p *SymlinkProcessor) SetLogger(logFunc func(format string, args ...interface{}) (int, error)) {
-	p.logFunc = logFunc
+// SetLogger sets the logger for progress messages
+func (p *SymlinkProcessor) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
+// log writes a progress message at info level when verbose output is
+// enabled, and at debug level otherwise
+func (p *SymlinkProcessor) log(format string, args ...interface{}) {
+	logging.Logf(p.logger, logging.Verbose(p.verbose), format, args...)
 }
 
 // SetDryRun enables or disables dry run mode (no actual symlinks created)
@@ -58,8 +88,95 @@ func (p *SymlinkProcessor) SetDryRun(dryRun bool) {
 	p.dryRun = dryRun
 }
 
+// SetRelative makes created symlinks point to their source with a relative
+// path such as ../../opt/myapp/bin/myapp instead of an absolute one, so they
+// keep working when the tree is moved into a chroot or an image
+func (p *SymlinkProcessor) SetRelative(relative bool) {
+	p.relative = relative
+}
+
+// SetTransactional makes processing the queue all or nothing: when a symlink
+// cannot be created, those created before it are rolled back
+func (p *SymlinkProcessor) SetTransactional(transactional bool) {
+	p.transactional = transactional
+}
+
+// SetManagedLinks makes the processor check targets against the links dpkg
+// maintains. Targets managed by update-alternatives or dpkg-divert are
+// refused; with route set, the source is registered as a choice of the
+// alternative whose master link is the target instead of linking directly.
+func (p *SymlinkProcessor) SetManagedLinks(db *dpkgdb.Database, route bool) {
+	p.dpkgDB = db
+	p.routeManaged = route
+}
+
+// SetAlternativePriority sets the priority of alternatives registered for
+// managed targets
+func (p *SymlinkProcessor) SetAlternativePriority(priority int) {
+	p.priority = priority
+}
+
+// CheckManaged checks the target of request against the links dpkg
+// maintains. A target that can be routed through update-alternatives gets
+// its alternative set in request; other managed targets are an error
+// wrapping ErrManagedByDpkg.
+func (p *SymlinkProcessor) CheckManaged(request *SymlinkRequest) error {
+	if p.dpkgDB == nil {
+		return nil
+	}
+	managed, ok := p.dpkgDB.Managed(request.Target)
+	if !ok {
+		// Reached through a directory symlink, such as /bin on merged-/usr systems
+		if parent, err := security.ResolveSymlinks(filepath.Dir(request.Target)); err == nil {
+			managed, ok = p.dpkgDB.Managed(filepath.Join(parent, filepath.Base(request.Target)))
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if !managed.Installable() {
+		return fmt.Errorf("%w: %s", ErrManagedByDpkg, managed)
+	}
+	if !p.routeManaged {
+		return fmt.Errorf("%w: %s; register the source with update-alternatives instead", ErrManagedByDpkg, managed)
+	}
+	request.Target = managed.Path
+	request.Alternative = managed.Name
+	request.Replace = false
+	return nil
+}
+
+// Relative reports whether created symlinks are relative
+func (p *SymlinkProcessor) Relative() bool {
+	return p.relative
+}
+
+// LinkTarget returns the contents of the symlink created for request: the
+// source itself, or in relative mode the path to the source from the
+// directory the target's parent resolves to. Resolving the parent matters
+// when it is reached through a symlink, such as /bin on merged-/usr systems.
+// Alternatives are always registered with the absolute source.
+func (p *SymlinkProcessor) LinkTarget(request SymlinkRequest) (string, error) {
+	if !p.relative || request.Alternative != "" {
+		return request.Source, nil
+	}
+	parent, err := security.ResolveSymlinks(filepath.Dir(request.Target))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the directory of %s: %w", request.Target, err)
+	}
+	link, err := filepath.Rel(parent, filepath.Clean(request.Source))
+	if err != nil {
+		return "", fmt.Errorf("failed to make %s relative to %s: %w", request.Source, parent, err)
+	}
+	return link, nil
+}
+
 // QueueSymlink adds a symlink to the queue for later processing
 func (p *SymlinkProcessor) QueueSymlink(request SymlinkRequest) error {
+	if err := p.CheckManaged(&request); err != nil {
+		return err
+	}
+
 	// Validate both source and target paths
 	if err := p.validator.ValidatePath(request.Source); err != nil {
 		return fmt.Errorf("invalid source path %s: %w", request.Source, err)
@@ -69,7 +186,12 @@ func (p *SymlinkProcessor) QueueSymlink(request SymlinkRequest) error {
 	}
 
 	// Check if the symlink is allowed for this target directory
-	if err := p.validator.ValidateSymlink(request.Source, request.Target); err != nil {
+	validateSymlink := p.validator.ValidateSymlink
+	// update-alternatives owns the existing master link
+	if request.Replace || request.Alternative != "" {
+		validateSymlink = p.validator.ValidateSymlinkReplacement
+	}
+	if err := validateSymlink(request.Source, request.Target); err != nil {
 		return fmt.Errorf("symlink validation failed: %w", err)
 	}
 
@@ -84,9 +206,7 @@ func (p *SymlinkProcessor) QueueSymlink(request SymlinkRequest) error {
 	}
 
 	p.symlinkQueue = append(p.symlinkQueue, request)
-	if p.verbose {
-		p.logFunc("Queued symlink: %s -> %s (%s)\n", request.Source, request.Target, request.Description)
-	}
+	p.log("Queued symlink: %s -> %s (%s)", request.Source, request.Target, request.Description)
 	return nil
 }
 
@@ -129,65 +249,103 @@ func (p *SymlinkProcessor) ProcessPath(originalPath string, transformedPath stri
 	return nil
 }
 
-// ProcessQueuedSymlinks creates all queued symlinks
+// ProcessQueuedSymlinks creates all queued symlinks. When some fail, the
+// error is a *QueueError describing each of them.
 func (p *SymlinkProcessor) ProcessQueuedSymlinks() error {
+	_, err := p.ProcessQueue()
+	return err
+}
+
+// ProcessQueue creates all queued symlinks and returns the outcome of each.
+// By default every symlink is attempted and the failed ones stay queued for a
+// retry. In transactional mode the first failure stops processing and the
+// symlinks created so far are removed again, restoring the files they
+// replaced, so the queue is applied completely or not at all and stays
+// queued as a whole.
+func (p *SymlinkProcessor) ProcessQueue() ([]LinkResult, error) {
 	p.queueMutex.Lock()
 	defer p.queueMutex.Unlock()
 
 	if len(p.symlinkQueue) == 0 {
-		if p.verbose {
-			p.logFunc("No symlinks to process\n")
-		}
-		return nil
+		p.log("No symlinks to process")
+		return nil, nil
 	}
 
-	if p.verbose {
-		p.logFunc("Processing %d queued symlinks\n", len(p.symlinkQueue))
-	}
+	p.log("Processing %d queued symlinks", len(p.symlinkQueue))
 
-	var errs []error
+	results := make([]LinkResult, 0, len(p.symlinkQueue))
 	var failedSymlinks []SymlinkRequest
+	var undo []undoEntry
 	var successCount int
 
 	for _, request := range p.symlinkQueue {
-		if err := p.createSymlink(request); err != nil {
-			errs = append(errs, err)
-			failedSymlinks = append(failedSymlinks, request)
-			if p.verbose {
-				p.logFunc("Error creating symlink %s -> %s: %v\n",
-					request.Source, request.Target, err)
+		result := LinkResult{Request: request}
+		if p.transactional && len(failedSymlinks) > 0 {
+			result.Err = &LinkError{Request: request, Err: ErrNotAttempted}
+			results = append(results, result)
+			continue
+		}
+
+		var err error
+		if p.transactional && !p.dryRun {
+			var entry undoEntry
+			if entry, err = p.createUndoable(request); err == nil {
+				undo = append(undo, entry)
+				result.Link = entry.link
 			}
+		} else if err = p.createSymlink(request); err == nil {
+			result.Link, _ = p.LinkTarget(request)
+		}
+
+		if err != nil {
+			result.Err = &LinkError{Request: request, Err: err}
+			failedSymlinks = append(failedSymlinks, request)
+			p.log("Error creating symlink %s -> %s: %v", request.Source, request.Target, err)
 		} else {
+			result.Created = true
 			successCount++
 		}
+		results = append(results, result)
 	}
 
-	// Keep failed symlinks in the queue for potential retry
-	if len(failedSymlinks) > 0 {
-		p.symlinkQueue = failedSymlinks
-		if p.verbose {
-			p.logFunc("Kept %d failed symlinks in queue for retry\n", len(failedSymlinks))
-		}
-	} else {
+	if len(failedSymlinks) == 0 {
+		discardBackups(undo)
 		// Only clear the queue if all symlinks were created successfully
 		p.symlinkQueue = make([]SymlinkRequest, 0)
+		if successCount > 0 {
+			p.log("Successfully created %d symlinks", successCount)
+		}
+		return results, nil
 	}
 
-	if p.verbose && successCount > 0 {
-		p.logFunc("Successfully created %d symlinks\n", successCount)
+	queueErr := &QueueError{Results: results}
+	if p.transactional {
+		queueErr.RollbackErr = rollback(undo)
+		queueErr.RolledBack = true
+		for i := range results {
+			results[i].RolledBack = results[i].Created && !p.dryRun
+		}
+		// Nothing was applied, so the whole queue can be retried
+		p.log("Rolled back %d symlinks; kept %d in queue for retry", len(undo), len(p.symlinkQueue))
+		return results, queueErr
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("failed to create %d symlinks", len(errs))
+	// Keep failed symlinks in the queue for potential retry
+	p.symlinkQueue = failedSymlinks
+	if successCount > 0 {
+		p.log("Successfully created %d symlinks", successCount)
 	}
-
-	return nil
+	p.log("Kept %d failed symlinks in queue for retry", len(failedSymlinks))
+	return results, queueErr
 }
 
 // createSymlink creates a single symlink, ensuring parent directories exist
 func (p *SymlinkProcessor) createSymlink(request SymlinkRequest) error {
+	if request.Alternative != "" {
+		return p.installAlternative(request)
+	}
 	if p.dryRun {
-		p.logFunc("[DRY RUN] Would create symlink: %s -> %s\n", request.Source, request.Target)
+		logging.Logf(p.logger, slog.LevelInfo, "[DRY RUN] Would create symlink: %s -> %s", request.Source, request.Target)
 		return nil
 	}
 
@@ -197,12 +355,57 @@ func (p *SymlinkProcessor) createSymlink(request SymlinkRequest) error {
 		return fmt.Errorf("failed to create parent directory %s: %w", parentDir, err)
 	}
 
+	// Resolved only now that the parent directory exists
+	link, err := p.LinkTarget(request)
+	if err != nil {
+		return err
+	}
+
 	// Create the symlink
-	if p.verbose {
-		p.logFunc("Creating symlink: %s -> %s\n", request.Source, request.Target)
+	p.log("Creating symlink: %s -> %s", link, request.Target)
+
+	entry := auditlog.Entry{Action: auditlog.ActionSymlinkCreated, Path: request.Target, Target: link}
+	if request.Replace {
+		err = p.symlinkManager.ReplaceSymlink(link, request.Target)
+		entry.Detail = "replaced the existing target"
+	} else {
+		err = p.symlinkManager.CreateSymlink(link, request.Target)
+	}
+	if err != nil {
+		return err
+	}
+	auditlog.Record(entry)
+	return nil
+}
+
+// installAlternative registers the source of request as a choice of its
+// alternative, whose master link is the target
+func (p *SymlinkProcessor) installAlternative(request SymlinkRequest) error {
+	args := []string{"--install", request.Target, request.Alternative, request.Source, strconv.Itoa(p.priority)}
+	if p.dryRun {
+		logging.Logf(p.logger, slog.LevelInfo, "[DRY RUN] Would run: update-alternatives %s", strings.Join(args, " "))
+		return nil
+	}
+	p.log("Registering alternative: %s -> %s (%s)", request.Target, request.Source, request.Alternative)
+	if err := runAlternatives(args...); err != nil {
+		return err
 	}
+	auditlog.Record(auditlog.Entry{
+		Action: auditlog.ActionSymlinkCreated,
+		Path:   request.Target,
+		Target: request.Source,
+		Detail: "registered as alternative " + request.Alternative,
+	})
+	return nil
+}
 
-	return p.symlinkManager.CreateSymlink(request.Source, request.Target)
+// runAlternatives runs update-alternatives; replaced in tests
+var runAlternatives = func(args ...string) error {
+	output, err := exec.Command("update-alternatives", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("update-alternatives %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
 }
 
 // GetQueuedSymlinkCount returns the number of symlinks in the queue
@@ -1,16 +1,36 @@
 package symlink
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
 	"github.com/go-i2p/go-pkginstall/pkg/security"
 )
 
+// logRecorder is a slog handler appending the messages of info and higher
+// records to a slice
+type logRecorder struct {
+	messages *[]string
+}
+
+func (r logRecorder) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+func (r logRecorder) WithAttrs([]slog.Attr) slog.Handler { return r }
+func (r logRecorder) WithGroup(string) slog.Handler      { return r }
+func (r logRecorder) Handle(_ context.Context, record slog.Record) error {
+	*r.messages = append(*r.messages, record.Message)
+	return nil
+}
+
 // TestSymlinkProcessor tests the core functionality of SymlinkProcessor
 func TestSymlinkProcessor(t *testing.T) {
 	// Create a temporary directory for our tests
@@ -39,11 +59,7 @@ func TestSymlinkProcessor(t *testing.T) {
 
 	// Capture logs for verification
 	var logs []string
-	processor.SetLogger(func(format string, args ...interface{}) (int, error) {
-		log := fmt.Sprintf(format, args...)
-		logs = append(logs, log)
-		return len(log), nil
-	})
+	processor.SetLogger(slog.New(logRecorder{&logs}))
 
 	// Test 1: Queue a symlink
 	t.Run("QueueSymlink", func(t *testing.T) {
@@ -97,11 +113,7 @@ func TestSymlinkProcessor(t *testing.T) {
 		// Reset the queue and logs for this test
 		processor = NewSymlinkProcessor(pathMapper, symlinkManager, validator, true)
 		logs = nil
-		processor.SetLogger(func(format string, args ...interface{}) (int, error) {
-			log := fmt.Sprintf(format, args...)
-			logs = append(logs, log)
-			return len(log), nil
-		})
+		processor.SetLogger(slog.New(logRecorder{&logs}))
 
 		// Process a path that should need a symlink
 		if err := processor.ProcessPath("/system/bin/tool", ""); err != nil {
@@ -234,3 +246,264 @@ func TestSymlinkProcessor(t *testing.T) {
 		}
 	})
 }
+
+func TestLinkTarget(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	if tempDir, err = filepath.EvalSymlinks(tempDir); err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+
+	// A merged-/usr layout where bin is a symlink to usr/bin
+	if err := os.MkdirAll(filepath.Join(tempDir, "usr", "bin"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.Symlink("usr/bin", filepath.Join(tempDir, "bin")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	source := filepath.Join(tempDir, "opt", "myapp", "bin", "myapp")
+
+	tests := []struct {
+		name     string
+		relative bool
+		target   string
+		want     string
+	}{
+		{"Absolute", false, filepath.Join(tempDir, "usr", "bin", "myapp"), source},
+		{"Relative", true, filepath.Join(tempDir, "usr", "bin", "myapp"), "../../opt/myapp/bin/myapp"},
+		{"Relative from a symlinked directory", true, filepath.Join(tempDir, "bin", "myapp"), "../../opt/myapp/bin/myapp"},
+		{"Relative from a missing directory", true, filepath.Join(tempDir, "usr", "local", "bin", "myapp"), "../../../opt/myapp/bin/myapp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewSymlinkProcessor(security.NewPathMapper(), &SymlinkManager{}, security.NewValidator(), false)
+			processor.SetRelative(tt.relative)
+
+			got, err := processor.LinkTarget(SymlinkRequest{Source: source, Target: tt.target})
+			if err != nil {
+				t.Fatalf("LinkTarget() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("LinkTarget() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessQueueTransactional(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink-transaction-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	source := filepath.Join(tempDir, "opt", "app")
+	targetDir := filepath.Join(tempDir, "bin")
+	for _, dir := range []string{source, targetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	existing := filepath.Join(targetDir, "existing")
+	if err := ioutil.WriteFile(existing, []byte("original"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", existing, err)
+	}
+
+	requests := []SymlinkRequest{
+		{Source: source, Target: filepath.Join(targetDir, "new", "app")},
+		{Source: source, Target: existing, Replace: true},
+		{Source: source, Target: filepath.Join(targetDir, "collide")},
+		{Source: source, Target: filepath.Join(targetDir, "last")},
+	}
+	newProcessor := func(transactional bool) *SymlinkProcessor {
+		processor := NewSymlinkProcessor(security.NewPathMapper(), &SymlinkManager{}, security.NewValidator(), false)
+		processor.SetTransactional(transactional)
+		for _, request := range requests {
+			if err := processor.QueueSymlink(request); err != nil {
+				t.Fatalf("Failed to queue %s: %v", request.Target, err)
+			}
+		}
+		return processor
+	}
+	collide := requests[2].Target
+
+	t.Run("RollsBackOnFailure", func(t *testing.T) {
+		processor := newProcessor(true)
+		// Appears after validation, so creating it fails
+		if err := ioutil.WriteFile(collide, nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", collide, err)
+		}
+		defer os.Remove(collide)
+
+		results, err := processor.ProcessQueue()
+		queueErr, ok := err.(*QueueError)
+		if !ok {
+			t.Fatalf("Expected a *QueueError, got %v", err)
+		}
+		if !queueErr.RolledBack || queueErr.RollbackErr != nil {
+			t.Errorf("Expected a complete rollback, got %+v", queueErr)
+		}
+		if len(results) != 4 || !results[0].RolledBack || !results[1].RolledBack {
+			t.Fatalf("Unexpected results %+v", results)
+		}
+		if failed := queueErr.Failed(); len(failed) != 2 || failed[0].Request.Target != collide || !errors.Is(failed[1], ErrNotAttempted) {
+			t.Errorf("Unexpected failures %v", failed)
+		}
+
+		if _, err := os.Lstat(filepath.Join(targetDir, "new")); !os.IsNotExist(err) {
+			t.Errorf("Expected the created directory to be removed")
+		}
+		if data, err := ioutil.ReadFile(existing); err != nil || string(data) != "original" {
+			t.Errorf("Expected the replaced file to be restored, got %q, %v", data, err)
+		}
+		if entries, _ := ioutil.ReadDir(targetDir); len(entries) != 2 {
+			t.Errorf("Expected only existing and collide to remain, got %d entries", len(entries))
+		}
+		if count := processor.GetQueuedSymlinkCount(); count != 4 {
+			t.Errorf("Expected the whole queue to be kept, got %d", count)
+		}
+	})
+
+	t.Run("KeepsGoingWithoutTransaction", func(t *testing.T) {
+		processor := newProcessor(false)
+		if err := ioutil.WriteFile(collide, nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", collide, err)
+		}
+		defer os.Remove(collide)
+
+		results, err := processor.ProcessQueue()
+		var queueErr *QueueError
+		if !errors.As(err, &queueErr) || queueErr.RolledBack {
+			t.Fatalf("Expected a *QueueError without rollback, got %v", err)
+		}
+		if len(queueErr.Failed()) != 1 || !results[3].Created || results[2].Created {
+			t.Errorf("Unexpected results %+v", results)
+		}
+		if count := processor.GetQueuedSymlinkCount(); count != 1 {
+			t.Errorf("Expected the failed symlink to stay queued, got %d", count)
+		}
+		for _, request := range []SymlinkRequest{requests[0], requests[1], requests[3]} {
+			os.Remove(request.Target)
+		}
+		ioutil.WriteFile(existing, []byte("original"), 0644)
+	})
+
+	t.Run("Commits", func(t *testing.T) {
+		processor := newProcessor(true)
+		results, err := processor.ProcessQueue()
+		if err != nil {
+			t.Fatalf("ProcessQueue() error = %v", err)
+		}
+		for _, result := range results {
+			if !result.Created || result.Link != source {
+				t.Errorf("Unexpected result %+v", result)
+			}
+		}
+		if matches, _ := filepath.Glob(filepath.Join(targetDir, ".*pkginstall-backup-*")); len(matches) != 0 {
+			t.Errorf("Expected backups to be discarded, found %v", matches)
+		}
+	})
+}
+
+func TestCheckManaged(t *testing.T) {
+	root, err := ioutil.TempDir("", "symlink-managed-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	binDir := filepath.Join(root, "bin")
+	source := filepath.Join(root, "opt", "editor")
+	files := map[string]string{
+		filepath.Join(dpkgdb.DefaultAlternativesDir, "editor"): "auto\n" + filepath.Join(binDir, "editor") + "\neditor.1\n" + filepath.Join(binDir, "editor.1") + "\n\n",
+		dpkgdb.DefaultDiversionsFile:                           filepath.Join(binDir, "sh") + "\n" + filepath.Join(binDir, "sh.distrib") + "\ndash\n",
+		"opt/editor":                                           "#!/bin/sh\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	db, err := dpkgdb.Open(root)
+	if err != nil {
+		t.Fatalf("dpkgdb.Open() error = %v", err)
+	}
+
+	var calls []string
+	defer func(run func(args ...string) error) { runAlternatives = run }(runAlternatives)
+	runAlternatives = func(args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		route   bool
+		wantErr bool
+		wantAlt string
+	}{
+		{"Unmanaged", filepath.Join(binDir, "tool"), false, false, ""},
+		{"AlternativeRefused", filepath.Join(binDir, "editor"), false, true, ""},
+		{"AlternativeRouted", filepath.Join(binDir, "editor"), true, false, "editor"},
+		{"SlaveRefused", filepath.Join(binDir, "editor.1"), true, true, ""},
+		{"DiversionRefused", filepath.Join(binDir, "sh"), true, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewSymlinkProcessor(security.NewPathMapper(), &SymlinkManager{}, security.NewValidator(), false)
+			processor.SetManagedLinks(db, tt.route)
+			request := SymlinkRequest{Source: source, Target: tt.target}
+			err := processor.CheckManaged(&request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckManaged() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrManagedByDpkg) {
+				t.Errorf("Expected ErrManagedByDpkg, got %v", err)
+			}
+			if request.Alternative != tt.wantAlt {
+				t.Errorf("Alternative = %q, want %q", request.Alternative, tt.wantAlt)
+			}
+		})
+	}
+
+	t.Run("RoutedThroughUpdateAlternatives", func(t *testing.T) {
+		calls = nil
+		processor := NewSymlinkProcessor(security.NewPathMapper(), &SymlinkManager{}, security.NewValidator(), false)
+		processor.SetManagedLinks(db, true)
+		processor.SetAlternativePriority(70)
+		processor.SetTransactional(true)
+		target := filepath.Join(binDir, "editor")
+		if err := processor.QueueSymlink(SymlinkRequest{Source: source, Target: target}); err != nil {
+			t.Fatalf("QueueSymlink() error = %v", err)
+		}
+		if err := processor.QueueSymlink(SymlinkRequest{Source: source, Target: filepath.Join(root, "missing", "dir")}); err != nil {
+			t.Fatalf("QueueSymlink() error = %v", err)
+		}
+		// Fails the second link, rolling back the alternative
+		if err := ioutil.WriteFile(filepath.Join(root, "missing"), nil, 0644); err != nil {
+			t.Fatalf("Failed to block parent directory: %v", err)
+		}
+		if err := processor.ProcessQueuedSymlinks(); err == nil {
+			t.Fatalf("Expected the second symlink to fail")
+		}
+		want := []string{
+			"--install " + target + " editor " + source + " 70",
+			"--remove editor " + source,
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("update-alternatives calls = %q, want %q", calls, want)
+		}
+		if _, err := os.Lstat(target); !os.IsNotExist(err) {
+			t.Errorf("Expected no raw symlink at %s", target)
+		}
+	})
+}
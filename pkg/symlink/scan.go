@@ -0,0 +1,223 @@
+package symlink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+	"github.com/go-i2p/go-pkginstall/pkg/ci"
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+	"github.com/go-i2p/go-pkginstall/pkg/history"
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+	"github.com/go-i2p/go-pkginstall/pkg/security"
+)
+
+// ScanFinding is a symlink into the transformed root whose source no longer
+// exists, as reported by symlink scan
+type ScanFinding struct {
+	Target  string `json:"target"`
+	Source  string `json:"source"`
+	Package string `json:"package,omitempty"` // Package accounting for the link, if any
+	Reason  string `json:"reason"`
+	Orphan  bool   `json:"orphan"` // No installed package accounts for the link
+}
+
+// scanSymlinks returns the broken links among links whose source lies below
+// root, cross-referenced with the dpkg database and the symlink state.
+// Links shipped or owned by an installed package are reported but are not
+// orphans: reinstalling that package repairs them.
+func scanSymlinks(links []SymlinkRequest, root string, db *dpkgdb.Database, state *State) []ScanFinding {
+	// The root itself may be a symlink, such as /opt -> /var/opt
+	resolvedRoot, err := security.ResolveSymlinks(root)
+	if err != nil {
+		resolvedRoot = root
+	}
+
+	var findings []ScanFinding
+	for _, link := range links {
+		if !isBroken(link.Target) {
+			continue
+		}
+		source, err := security.ResolveSymlinks(link.Source)
+		if err != nil {
+			source = link.Source
+		}
+		if !isBelow(source, root) && !isBelow(source, resolvedRoot) {
+			continue
+		}
+
+		finding := ScanFinding{Target: link.Target, Source: link.Source}
+		owner := state.Owner(link.Target)
+		switch {
+		case len(db.Owners(link.Target)) > 0:
+			finding.Package = strings.Join(db.Owners(link.Target), ", ")
+			finding.Reason = "shipped by installed package; reinstall it to restore the source"
+		case len(db.Owners(link.Source)) > 0:
+			finding.Package = strings.Join(db.Owners(link.Source), ", ")
+			finding.Reason = "source belongs to installed package but is missing; reinstall it"
+		case owner != "" && db.Installed(owner):
+			finding.Package = owner
+			finding.Reason = "owning package is installed but its payload is missing"
+		case owner != "":
+			finding.Package = owner
+			finding.Reason = "owning package is no longer installed"
+			finding.Orphan = true
+		default:
+			finding.Reason = "no installed package accounts for it"
+			finding.Orphan = true
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// runScanCommand reports broken symlinks into the transformed root and, with
+// --clean, removes the orphaned ones after confirmation
+func runScanCommand(options *CommandOptions, in io.Reader, out io.Writer) error {
+	pathMapper, err := newPathMapper(options)
+	if err != nil {
+		return err
+	}
+	links, err := findExistingSymlinks(pathMapper.GetSymlinkDirs())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Error scanning for existing symlinks: %v\n", err)
+	}
+	db, err := dpkgdb.Open(options.DpkgRoot)
+	if err != nil {
+		return fmt.Errorf("failed to read dpkg database: %w", err)
+	}
+	state, err := LoadState("")
+	if err != nil {
+		return err
+	}
+
+	findings := scanSymlinks(links, pathMapper.GetTransformedRoot(), db, state)
+	switch strings.ToLower(options.Format) {
+	case "table":
+		printScanTable(out, findings)
+	case "json":
+		if findings == nil {
+			findings = []ScanFinding{}
+		}
+		data, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal to JSON: %w", err)
+		}
+		fmt.Fprintln(out, string(data))
+	default:
+		return fmt.Errorf("unknown output format: %s", options.Format)
+	}
+
+	if !options.Clean {
+		return nil
+	}
+	var orphans []ScanFinding
+	for _, finding := range findings {
+		if finding.Orphan {
+			orphans = append(orphans, finding)
+		}
+	}
+	if len(orphans) == 0 {
+		fmt.Fprintln(out, "No orphaned symlinks to clean")
+		return nil
+	}
+	if !options.DryRun && !options.Yes && !ci.Interactive() {
+		return ci.Errorf(ci.ClassUsage, "removing %d orphaned symlink(s) needs --yes in CI mode", len(orphans))
+	}
+	if !options.DryRun && !options.Yes &&
+		!confirm(in, out, fmt.Sprintf("Remove %d orphaned symlink(s)?", len(orphans))) {
+		fmt.Fprintln(out, "Nothing removed")
+		return nil
+	}
+	return cleanOrphans(orphans, state, options.DryRun, out)
+}
+
+// cleanOrphans removes orphaned symlinks, recording them in a rollback
+// manifest so "pkginstall rollback" can put them back
+func cleanOrphans(orphans []ScanFinding, state *State, dryRun bool, out io.Writer) error {
+	summary := history.NewSummary("symlink scan")
+	summary.DryRun = dryRun
+
+	store, err := manifest.NewStore("")
+	if err != nil {
+		return fmt.Errorf("cannot record changes for rollback: %w", err)
+	}
+	record := manifest.New("symlink scan")
+	defer saveManifest(store, record, dryRun)
+
+	var errs []string
+	for _, orphan := range orphans {
+		if dryRun {
+			fmt.Fprintf(out, "[DRY RUN] Would remove orphaned symlink: %s -> %s\n", orphan.Target, orphan.Source)
+			summary.AddAction(fmt.Sprintf("removed orphaned symlink %s", orphan.Target))
+			continue
+		}
+		if err := store.Displace(record, orphan.Target); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := os.Remove(orphan.Target); err != nil {
+			record.Displaced = record.Displaced[:len(record.Displaced)-1]
+			errs = append(errs, fmt.Sprintf("failed to remove %s: %v", orphan.Target, err))
+			continue
+		}
+		auditlog.Record(auditlog.Entry{Action: auditlog.ActionSymlinkRemoved, Path: orphan.Target, Target: orphan.Source,
+			Detail: "orphaned"})
+		state.Remove(orphan.Target)
+		if err := RemoveMarker(orphan.Target); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		fmt.Fprintf(out, "Removed orphaned symlink: %s\n", orphan.Target)
+		summary.AddAction(fmt.Sprintf("removed orphaned symlink %s", orphan.Target))
+	}
+	if !dryRun {
+		if err := state.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update symlink state: %v\n", err)
+		}
+	}
+
+	err = nil
+	if len(errs) > 0 {
+		err = fmt.Errorf("failed to remove %d symlink(s):\n- %s", len(errs), strings.Join(errs, "\n- "))
+	}
+	summary.SetError(err)
+	history.Record(out, summary)
+	return err
+}
+
+// printScanTable prints scan findings as a table
+func printScanTable(out io.Writer, findings []ScanFinding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(out, "No broken symlinks into the transformed root found")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSOURCE\tPACKAGE\tSTATUS\tREASON")
+	orphans := 0
+	for _, finding := range findings {
+		status := "Repairable"
+		if finding.Orphan {
+			status = "Orphan"
+			orphans++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", finding.Target, finding.Source, packageColumn(finding.Package), status, finding.Reason)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d broken symlink(s), %d orphaned\n", len(findings), orphans)
+}
+
+// confirm asks a yes/no question and reports whether it was answered yes
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
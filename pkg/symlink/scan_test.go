@@ -0,0 +1,127 @@
+package symlink
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-pkginstall/pkg/dpkgdb"
+)
+
+func TestScanSymlinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "symlink-scan-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	root := filepath.Join(tmpDir, "opt")
+	binDir := filepath.Join(tmpDir, "bin")
+	infoDir := filepath.Join(tmpDir, dpkgdb.DefaultInfoDir)
+	for _, dir := range []string{filepath.Join(root, "live"), binDir, infoDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "live", "app"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	link := func(name, source string) string {
+		target := filepath.Join(binDir, name)
+		if err := os.Symlink(source, target); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		return target
+	}
+	live := link("live", filepath.Join(root, "live", "app"))
+	outside := link("outside", filepath.Join(tmpDir, "elsewhere", "app"))
+	shipped := link("shipped", filepath.Join(root, "shipped", "app"))
+	damaged := link("damaged", filepath.Join(root, "damaged", "app"))
+	installed := link("installed", filepath.Join(root, "installed", "app"))
+	removed := link("removed", filepath.Join(root, "removed", "app"))
+	unknown := link("unknown", "../opt/unknown/app")
+
+	lists := map[string]string{
+		"shipper.list":   shipped + "\n",
+		"damaged.list":   filepath.Join(root, "damaged", "app") + "\n",
+		"installed.list": "/usr/share/doc/installed\n",
+	}
+	for name, content := range lists {
+		if err := ioutil.WriteFile(filepath.Join(infoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	db, err := dpkgdb.Open(tmpDir)
+	if err != nil {
+		t.Fatalf("dpkgdb.Open() error = %v", err)
+	}
+
+	state := &State{}
+	for target, pkg := range map[string]string{installed: "installed", removed: "removed"} {
+		source, _ := os.Readlink(target)
+		state.Add(StateEntry{Target: target, Source: source, Package: pkg})
+	}
+
+	links, err := findExistingSymlinks([]string{binDir})
+	if err != nil {
+		t.Fatalf("findExistingSymlinks() error = %v", err)
+	}
+	findings := scanSymlinks(links, root, db, state)
+
+	want := map[string]struct {
+		pkg    string
+		orphan bool
+	}{
+		shipped:   {"shipper", false},
+		damaged:   {"damaged", false},
+		installed: {"installed", false},
+		removed:   {"removed", true},
+		unknown:   {"", true},
+	}
+	if len(findings) != len(want) {
+		t.Fatalf("scanSymlinks() = %+v, want %d findings", findings, len(want))
+	}
+	for _, finding := range findings {
+		expected, ok := want[finding.Target]
+		if !ok {
+			t.Errorf("Unexpected finding %s", finding.Target)
+			continue
+		}
+		if finding.Package != expected.pkg || finding.Orphan != expected.orphan {
+			t.Errorf("%s: package %q orphan %v, want %q %v", finding.Target, finding.Package, finding.Orphan, expected.pkg, expected.orphan)
+		}
+	}
+	for _, target := range []string{live, outside} {
+		if _, ok := want[target]; ok {
+			t.Errorf("%s should not be reported", target)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.answer), func(t *testing.T) {
+			var out bytes.Buffer
+			if got := confirm(strings.NewReader(tt.answer), &out, "Remove?"); got != tt.want {
+				t.Errorf("confirm(%q) = %v, want %v", tt.answer, got, tt.want)
+			}
+			if out.String() != "Remove? [y/N] " {
+				t.Errorf("Unexpected prompt %q", out.String())
+			}
+		})
+	}
+}
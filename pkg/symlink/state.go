@@ -0,0 +1,189 @@
+package symlink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/manifest"
+)
+
+// StateEntry records a symlink created by pkginstall and the package owning it
+type StateEntry struct {
+	Target  string    `json:"target"`            // Path of the symlink
+	Source  string    `json:"source"`            // Contents of the symlink
+	Package string    `json:"package,omitempty"` // Owning package, if one was given
+	Created time.Time `json:"created"`
+}
+
+// State is the symlink state database: every symlink pkginstall created that
+// has not been removed since, so the links of one package can be told apart
+// from those of others linking into the same directories
+type State struct {
+	path    string
+	Entries []StateEntry `json:"symlinks"`
+}
+
+// DefaultStatePath returns the default location of the state database, next
+// to the rollback manifests
+func DefaultStatePath() (string, error) {
+	dir, err := manifest.DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dir), "symlinks.json"), nil
+}
+
+// LoadState reads the state database at path; a missing file is an empty
+// database. An empty path uses DefaultStatePath.
+func LoadState(path string) (*State, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultStatePath(); err != nil {
+			return nil, err
+		}
+	}
+	state := &State{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symlink state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("corrupt symlink state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Save writes the state database, replacing the previous version
+func (s *State) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode symlink state: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write symlink state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write symlink state: %w", err)
+	}
+	return nil
+}
+
+// Add records a symlink, replacing any previous entry for its target
+func (s *State) Add(entry StateEntry) {
+	s.Remove(entry.Target)
+	if entry.Created.IsZero() {
+		entry.Created = time.Now().UTC()
+	}
+	s.Entries = append(s.Entries, entry)
+	sort.Slice(s.Entries, func(i, j int) bool { return s.Entries[i].Target < s.Entries[j].Target })
+}
+
+// Remove drops the entry for target, if any
+func (s *State) Remove(target string) {
+	for i, entry := range s.Entries {
+		if entry.Target == target {
+			s.Entries = append(s.Entries[:i], s.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Lookup returns the entry for target
+func (s *State) Lookup(target string) (StateEntry, bool) {
+	for _, entry := range s.Entries {
+		if entry.Target == target {
+			return entry, true
+		}
+	}
+	return StateEntry{}, false
+}
+
+// Package returns the entries owned by the named package
+func (s *State) Package(name string) []StateEntry {
+	var entries []StateEntry
+	for _, entry := range s.Entries {
+		if entry.Package == name {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// markerSuffix ends the name of the marker file kept next to a symlink
+const markerSuffix = ".pkginstall-owner"
+
+// MarkerPath returns the path of the ownership marker of the symlink at
+// target: a hidden file in the same directory, such as
+// /usr/local/bin/.myapp.pkginstall-owner for /usr/local/bin/myapp
+func MarkerPath(target string) string {
+	dir, name := filepath.Split(target)
+	return filepath.Join(dir, "."+name+markerSuffix)
+}
+
+// WriteMarker records the owning package and contents of the symlink at
+// target in its marker, so ownership survives without the state database,
+// for example in images built on another host
+func WriteMarker(target, pkg, source string) error {
+	content := fmt.Sprintf("package=%s\nsource=%s\n", pkg, source)
+	if err := os.WriteFile(MarkerPath(target), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write ownership marker for %s: %w", target, err)
+	}
+	return nil
+}
+
+// ReadMarker returns the owning package and recorded contents of the symlink
+// at target from its marker. ok is false when there is no marker.
+func ReadMarker(target string) (pkg, source string, ok bool) {
+	data, err := os.ReadFile(MarkerPath(target))
+	if err != nil {
+		return "", "", false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, value, found := strings.Cut(line, "="); found {
+			switch key {
+			case "package":
+				pkg = value
+			case "source":
+				source = value
+			}
+		}
+	}
+	return pkg, source, pkg != ""
+}
+
+// RemoveMarker deletes the marker of the symlink at target, if any
+func RemoveMarker(target string) error {
+	if err := os.Remove(MarkerPath(target)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove ownership marker for %s: %w", target, err)
+	}
+	return nil
+}
+
+// Owner returns the package owning the symlink at target according to the
+// state database, or else its marker. The owner only counts while the
+// symlink still has the recorded contents.
+func (s *State) Owner(target string) string {
+	current, err := os.Readlink(target)
+	if err != nil {
+		return ""
+	}
+	if entry, ok := s.Lookup(target); ok && entry.Source == current {
+		return entry.Package
+	}
+	if pkg, source, ok := ReadMarker(target); ok && source == current {
+		return pkg
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package symlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "symlink-state-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	bin := filepath.Join(tempDir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	links := map[string]string{
+		"app":     "/opt/app/bin/app",
+		"tool":    "/opt/tool/bin/tool",
+		"marked":  "/opt/app/bin/marked",
+		"changed": "/elsewhere",
+		"foreign": "/usr/bin/foreign",
+	}
+	for name, source := range links {
+		if err := os.Symlink(source, filepath.Join(bin, name)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	statePath := filepath.Join(tempDir, "state", "symlinks.json")
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	state.Add(StateEntry{Target: filepath.Join(bin, "app"), Source: "/opt/app/bin/app", Package: "app"})
+	state.Add(StateEntry{Target: filepath.Join(bin, "tool"), Source: "/opt/tool/bin/tool", Package: "tool"})
+	// Recorded before someone else changed the link
+	state.Add(StateEntry{Target: filepath.Join(bin, "changed"), Source: "/opt/app/bin/changed", Package: "app"})
+	if err := state.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// Only the marker knows this one, as in an image built elsewhere
+	if err := WriteMarker(filepath.Join(bin, "marked"), "app", "/opt/app/bin/marked"); err != nil {
+		t.Fatalf("WriteMarker() error = %v", err)
+	}
+
+	state, err = LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(state.Entries) != 3 {
+		t.Fatalf("Expected 3 entries after reloading, got %+v", state.Entries)
+	}
+
+	t.Run("Owner", func(t *testing.T) {
+		tests := []struct {
+			name string
+			link string
+			want string
+		}{
+			{"Recorded in the state database", "app", "app"},
+			{"Other package in the same directory", "tool", "tool"},
+			{"Recorded in a marker", "marked", "app"},
+			{"Changed since it was recorded", "changed", ""},
+			{"Not created by pkginstall", "foreign", ""},
+			{"Missing", "missing", ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := state.Owner(filepath.Join(bin, tt.link)); got != tt.want {
+					t.Errorf("Owner(%s) = %q, want %q", tt.link, got, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Tag owners", func(t *testing.T) {
+		existing, err := findExistingSymlinks([]string{bin})
+		if err != nil {
+			t.Fatalf("findExistingSymlinks() error = %v", err)
+		}
+		var got []string
+		for _, link := range tagOwners(existing, state, "app") {
+			got = append(got, filepath.Base(link.Target))
+		}
+		if want := []string{"app", "marked"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Symlinks of app = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		state.Remove(filepath.Join(bin, "app"))
+		if _, ok := state.Lookup(filepath.Join(bin, "app")); ok {
+			t.Errorf("Entry still present after Remove")
+		}
+		if err := RemoveMarker(filepath.Join(bin, "marked")); err != nil {
+			t.Fatalf("RemoveMarker() error = %v", err)
+		}
+		if got := state.Owner(filepath.Join(bin, "marked")); got != "" {
+			t.Errorf("Owner() = %q after removing the marker", got)
+		}
+		if entries := state.Package("tool"); len(entries) != 1 {
+			t.Errorf("Package(tool) = %+v, want one entry", entries)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package symlink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-i2p/go-pkginstall/pkg/auditlog"
+)
+
+// ErrNotAttempted is the error of queued symlinks skipped because an earlier
+// one failed in transactional mode
+var ErrNotAttempted = errors.New("not attempted after an earlier failure")
+
+// LinkResult is the outcome of creating one queued symlink
+type LinkResult struct {
+	Request    SymlinkRequest
+	Link       string // Contents of the created symlink
+	Created    bool   // The symlink was created (or would be, in dry-run mode)
+	RolledBack bool   // The symlink was created, then removed by a rollback
+	Err        error  // A *LinkError when creation failed or was not attempted
+}
+
+// LinkError is the failure to create one queued symlink
+type LinkError struct {
+	Request SymlinkRequest
+	Err     error
+}
+
+func (e *LinkError) Error() string {
+	return fmt.Sprintf("symlink %s -> %s: %v", e.Request.Target, e.Request.Source, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// QueueError is returned when queued symlinks could not be created. Results
+// holds the outcome of every queued symlink.
+type QueueError struct {
+	Results     []LinkResult
+	RolledBack  bool  // Transactional mode undid the symlinks created
+	RollbackErr error // What the rollback could not undo, if anything
+}
+
+// Failed returns the errors of the symlinks that failed or were not attempted
+func (e *QueueError) Failed() []*LinkError {
+	var failed []*LinkError
+	for _, result := range e.Results {
+		var linkErr *LinkError
+		if errors.As(result.Err, &linkErr) {
+			failed = append(failed, linkErr)
+		}
+	}
+	return failed
+}
+
+func (e *QueueError) Error() string {
+	var failed []string
+	for _, linkErr := range e.Failed() {
+		if !errors.Is(linkErr, ErrNotAttempted) {
+			failed = append(failed, linkErr.Error())
+		}
+	}
+	msg := fmt.Sprintf("failed to create %d of %d symlinks", len(failed), len(e.Results))
+	if e.RolledBack {
+		msg += "; rolled back the symlinks created"
+	}
+	if len(failed) > 0 {
+		msg += ":\n- " + strings.Join(failed, "\n- ")
+	}
+	if e.RollbackErr != nil {
+		msg += "\n" + e.RollbackErr.Error()
+	}
+	return msg
+}
+
+// undoEntry records how to undo one symlink created in transactional mode
+type undoEntry struct {
+	target string
+	link   string   // Contents of the created symlink
+	backup string   // Hard link to the replaced file, if any
+	dirs   []string // Parent directories created for the symlink
+	source string   // Registered choice, for alternatives
+	alt    string   // Alternative the source was registered under, if any
+}
+
+// missingDirs returns the ancestors of target that do not exist yet, deepest
+// first
+func missingDirs(target string) []string {
+	var missing []string
+	for dir := filepath.Dir(target); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	return missing
+}
+
+// removeDirs removes created directories deepest first; directories that
+// are not empty stay
+func removeDirs(dirs []string) {
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+}
+
+// createUndoable creates the symlink of request and returns how to undo it.
+// A target being replaced is first hard linked under a hidden name in the
+// same directory, so rolling back can rename it into place again.
+func (p *SymlinkProcessor) createUndoable(request SymlinkRequest) (undoEntry, error) {
+	if request.Alternative != "" {
+		entry := undoEntry{target: request.Target, link: request.Source, source: request.Source, alt: request.Alternative}
+		return entry, p.createSymlink(request)
+	}
+	entry := undoEntry{target: request.Target, dirs: missingDirs(request.Target)}
+
+	if request.Replace {
+		if info, err := os.Lstat(request.Target); err == nil && !info.IsDir() {
+			dir, name := filepath.Split(request.Target)
+			entry.backup = filepath.Join(dir, "."+name+".pkginstall-backup-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+			if err := os.Link(request.Target, entry.backup); err != nil {
+				return entry, fmt.Errorf("cannot keep %s for rollback: %w", request.Target, err)
+			}
+		}
+	}
+
+	if err := p.createSymlink(request); err != nil {
+		if entry.backup != "" {
+			os.Remove(entry.backup)
+		}
+		removeDirs(entry.dirs)
+		return entry, err
+	}
+
+	link, err := p.LinkTarget(request)
+	if err != nil {
+		link = request.Source
+	}
+	entry.link = link
+	return entry, nil
+}
+
+// rollback undoes created symlinks in reverse order. Symlinks changed since
+// they were created are left alone.
+func rollback(entries []undoEntry) error {
+	var errs []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.alt != "" {
+			if err := runAlternatives("--remove", entry.alt, entry.source); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			auditlog.Record(auditlog.Entry{Action: auditlog.ActionSymlinkRemoved, Path: entry.target, Target: entry.source,
+				Detail: "rolled back alternative " + entry.alt})
+			continue
+		}
+		if current, err := os.Readlink(entry.target); err != nil || current != entry.link {
+			errs = append(errs, fmt.Sprintf("left %s alone: changed since it was created", entry.target))
+			continue
+		}
+		if entry.backup != "" {
+			if err := os.Rename(entry.backup, entry.target); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to restore %s: %v", entry.target, err))
+				continue
+			}
+			auditlog.Record(auditlog.Entry{Action: auditlog.ActionFileRestored, Path: entry.target, Detail: "rolled back"})
+			continue
+		}
+		if err := os.Remove(entry.target); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to remove %s: %v", entry.target, err))
+			continue
+		}
+		auditlog.Record(auditlog.Entry{Action: auditlog.ActionSymlinkRemoved, Path: entry.target, Target: entry.link, Detail: "rolled back"})
+		removeDirs(entry.dirs)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("rollback incomplete:\n- %s", strings.Join(errs, "\n- "))
+	}
+	return nil
+}
+
+// discardBackups removes the backups of a transaction that succeeded
+func discardBackups(entries []undoEntry) {
+	for _, entry := range entries {
+		if entry.backup != "" {
+			os.Remove(entry.backup)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package telemetry provides Prometheus metrics and OpenTelemetry traces for
+// long-running builds without depending on the client libraries: metrics are
+// written in the Prometheus text exposition format and traces are exported
+// with OTLP over HTTP/JSON.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds suited to build durations
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800}
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// metric is a named counter or histogram with one series per label set
+type metric struct {
+	name    string
+	help    string
+	kind    string // "counter" or "histogram"
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series holds the values of one label set
+type series struct {
+	labels []string
+	value  float64  // Counter value, or histogram sum
+	counts []uint64 // Histogram bucket counts, not cumulative
+	count  uint64   // Histogram observations
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	r *Registry
+	m *metric
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	r *Registry
+	m *metric
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r: r, m: r.register(name, help, "counter", labels, nil)}
+}
+
+// Histogram registers a histogram with the given buckets and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r: r, m: r.register(name, help, "histogram", labels, buckets)}
+}
+
+// register adds a metric, or returns the existing one of the same name
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		if m.name == name {
+			return m
+		}
+	}
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// get returns the series of labelValues, creating it; the caller holds r.mu
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add increases the counter of the label set by v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.m.get(labelValues).value += v
+}
+
+// Inc increases the counter of the label set by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Observe records v in the histogram of the label set
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.m.get(labelValues)
+	s.value += v
+	s.count++
+	for i, bound := range h.m.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range r.metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.kind == "counter" {
+				fmt.Fprintf(&b, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range m.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", formatValue(bound)), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels, "", ""), s.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFile atomically writes the metrics to path, for example for the
+// node_exporter textfile collector
+func (r *Registry) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = r.WritePrometheus(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats a label set, with an optional extra label
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatValue formats a sample value
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes a HELP line
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
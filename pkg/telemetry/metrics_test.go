@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	registry := NewRegistry()
+	builds := registry.Counter("builds_total", "Builds by result.", "result")
+	duration := registry.Histogram("duration_seconds", "Build duration.", []float64{10, 1})
+	builds.Inc("succeeded")
+	builds.Add(2, "succeeded")
+	builds.Inc(`bad "quote"`)
+	duration.Observe(0.5)
+	duration.Observe(5)
+	duration.Observe(50)
+
+	// Registering a metric again returns the existing one
+	registry.Counter("builds_total", "Builds by result.", "result").Inc("succeeded")
+
+	want := `# HELP builds_total Builds by result.
+# TYPE builds_total counter
+builds_total{result="bad \"quote\""} 1
+builds_total{result="succeeded"} 4
+# HELP duration_seconds Build duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="10"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 55.5
+duration_seconds_count 3
+`
+	var buf bytes.Buffer
+	if err := registry.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("WritePrometheus() =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.String() != want {
+		t.Errorf("Handler served\n%s", rec.Body.String())
+	}
+
+	dir, err := ioutil.TempDir("", "metrics-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pkginstall.prom")
+	if err := registry.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != want {
+		t.Errorf("WriteFile() wrote\n%s", content)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("WriteFile() left %d files behind", len(entries))
+	}
+}
+
+func TestCounterLabelMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for missing label values")
+		}
+	}()
+	NewRegistry().Counter("builds_total", "Builds.", "result").Inc()
+}
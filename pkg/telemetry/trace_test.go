@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracerFlush(t *testing.T) {
+	var (
+		path, auth string
+		exported   otlpRequest
+		requests   int
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&exported)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer("svc", collector.URL+"/", map[string]string{"Authorization": "Bearer x"})
+	ctx, parent := tracer.Start(context.Background(), "build")
+	_, child := tracer.Start(ctx, "build.copy")
+	child.SetAttribute("files", 3)
+	child.SetError(errors.New("disk full"))
+	child.End()
+	child.End()
+	parent.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if path != "/v1/traces" || auth != "Bearer x" {
+		t.Errorf("Exported to %s with Authorization %q", path, auth)
+	}
+	if len(exported.ResourceSpans) != 1 {
+		t.Fatalf("Unexpected export %+v", exported)
+	}
+	resource := exported.ResourceSpans[0]
+	if attrs := resource.Resource.Attributes; len(attrs) != 1 || attrs[0].Value["stringValue"] != "svc" {
+		t.Errorf("Resource attributes = %+v", attrs)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Exported %d spans, want 2", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID || p.ParentSpanID != "" {
+		t.Errorf("Child span %+v is not linked to parent %+v", c, p)
+	}
+	if c.Status.Code != 2 || c.Status.Message != "disk full" || p.Status.Code != 1 {
+		t.Errorf("Statuses = %+v, %+v", c.Status, p.Status)
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0].Value["intValue"] != "3" {
+		t.Errorf("Attributes = %+v", c.Attributes)
+	}
+
+	// Nothing is sent when no spans finished since the last flush
+	if err := tracer.Flush(context.Background()); err != nil || requests != 1 {
+		t.Errorf("Second Flush() error = %v after %d requests", err, requests)
+	}
+}
+
+func TestTracerFlushError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer("svc", collector.URL+"/v1/traces", nil)
+	_, span := tracer.Start(context.Background(), "build")
+	span.End()
+	err := tracer.Flush(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("Flush() error = %v, want the collector response", err)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "build")
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+	if SpanFromContext(ctx) != nil {
+		t.Errorf("Nil tracer stored a span")
+	}
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Package tmpfiles declares the state, log, cache and runtime directories a
+// package needs, as systemd-tmpfiles.d(5) entries created at install time
+// rather than as empty directories shipped in the payload.
+package tmpfiles
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigDir is where packages install their tmpfiles.d configuration
+const ConfigDir = "/usr/lib/tmpfiles.d"
+
+// StateDir is a directory created when the package is installed. Mode
+// defaults to 0755 and User and Group to root. Age, a tmpfiles.d cleanup age
+// such as 10d, removes older files from the directory; by default nothing is
+// cleaned up.
+//
+// Example configuration:
+//
+//	state_dirs:
+//	  - path: /var/lib/myapp
+//	    mode: "0750"
+//	    user: myapp
+//	    group: myapp
+//	  - path: /var/cache/myapp
+//	    user: myapp
+//	    age: 30d
+type StateDir struct {
+	Path  string `mapstructure:"path"`
+	Mode  string `mapstructure:"mode"`  // Octal mode, such as 0750
+	User  string `mapstructure:"user"`  // Owning user name or ID
+	Group string `mapstructure:"group"` // Owning group name or ID
+	Age   string `mapstructure:"age"`   // Cleanup age, such as 10d
+}
+
+// stateRoots are the directories state directories may be declared below
+var stateRoots = []string{"/var", "/run", "/srv"}
+
+// sharedDirs are directories below the state roots that belong to the
+// system, not to a package
+var sharedDirs = map[string]bool{
+	"/var/lib": true, "/var/log": true, "/var/cache": true, "/var/spool": true,
+	"/var/tmp": true, "/var/run": true, "/var/lock": true, "/var/opt": true,
+	"/var/local": true, "/var/mail": true, "/var/backups": true, "/run/user": true,
+	"/run/lock": true,
+}
+
+var (
+	// validPath matches paths that need no quoting in tmpfiles.d lines or
+	// shell scripts; % would start a tmpfiles.d specifier
+	validPath = regexp.MustCompile(`^/[A-Za-z0-9._+@/-]+$`)
+	// validOwner matches user and group names as useradd accepts them, and
+	// numeric IDs
+	validOwner = regexp.MustCompile(`^([a-z_][a-z0-9_-]{0,30}\$?|[0-9]+)$`)
+	validAge   = regexp.MustCompile(`^[0-9]+(us|ms|s|m|min|h|d|w)?$`)
+)
+
+// WithDefaults returns a copy of d with the default mode and owner filled in
+func (d StateDir) WithDefaults() StateDir {
+	if d.Mode == "" {
+		d.Mode = "0755"
+	}
+	if d.User == "" {
+		d.User = "root"
+	}
+	if d.Group == "" {
+		d.Group = "root"
+	}
+	return d
+}
+
+// Validate reports paths outside /var, /run and /srv, and malformed modes,
+// owners and ages
+func (d StateDir) Validate() error {
+	if !validPath.MatchString(d.Path) || path.Clean(d.Path) != d.Path {
+		return fmt.Errorf("invalid state directory %q: expected a clean absolute path", d.Path)
+	}
+	inRoot := false
+	for _, root := range stateRoots {
+		inRoot = inRoot || strings.HasPrefix(d.Path, root+"/")
+	}
+	if !inRoot {
+		return fmt.Errorf("state directory %s must be below %s", d.Path, strings.Join(stateRoots, ", "))
+	}
+	if sharedDirs[d.Path] {
+		return fmt.Errorf("state directory %s is a system directory; declare a directory of the package below it", d.Path)
+	}
+	if d.Mode != "" {
+		if mode, err := strconv.ParseUint(d.Mode, 8, 32); err != nil || mode > 07777 {
+			return fmt.Errorf("state directory %s: invalid mode %q: expected octal such as 0750", d.Path, d.Mode)
+		}
+	}
+	for _, owner := range []string{d.User, d.Group} {
+		if owner != "" && !validOwner.MatchString(owner) {
+			return fmt.Errorf("state directory %s: invalid user or group %q", d.Path, owner)
+		}
+	}
+	if d.Age != "" && !validAge.MatchString(d.Age) {
+		return fmt.Errorf("state directory %s: invalid age %q: expected a duration such as 10d", d.Path, d.Age)
+	}
+	return nil
+}
+
+// Line returns the tmpfiles.d entry that creates the directory
+func (d StateDir) Line() string {
+	d = d.WithDefaults()
+	age := d.Age
+	if age == "" {
+		age = "-"
+	}
+	return fmt.Sprintf("d %s %s %s %s %s", d.Path, d.Mode, d.User, d.Group, age)
+}
+
+// ConfigPath returns the installed path of the tmpfiles.d configuration of a
+// package
+func ConfigPath(packageName string) string {
+	return ConfigDir + "/" + packageName + ".conf"
+}
+
+// Config returns the tmpfiles.d configuration that creates dirs
+func Config(packageName string, dirs []StateDir) []byte {
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "# State directories of %s, generated by go-pkginstall\n", packageName)
+	conf.WriteString("# Type Path Mode User Group Age\n")
+	for _, d := range dirs {
+		conf.WriteString(d.Line() + "\n")
+	}
+	return []byte(conf.String())
+}
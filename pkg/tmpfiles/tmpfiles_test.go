@@ -0,0 +1,52 @@
+package tmpfiles
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     StateDir
+		wantErr bool
+	}{
+		{"Valid", StateDir{Path: "/var/lib/app", Mode: "0750", User: "app", Group: "app"}, false},
+		{"Defaults", StateDir{Path: "/run/app"}, false},
+		{"Numeric owner", StateDir{Path: "/srv/app", User: "1000", Age: "10d"}, false},
+		{"Relative", StateDir{Path: "var/lib/app"}, true},
+		{"Unclean", StateDir{Path: "/var/lib/../app"}, true},
+		{"Outside /var", StateDir{Path: "/opt/app"}, true},
+		{"System directory", StateDir{Path: "/var/lib"}, true},
+		{"Specifier", StateDir{Path: "/var/lib/%n"}, true},
+		{"Space", StateDir{Path: "/var/lib/my app"}, true},
+		{"Bad mode", StateDir{Path: "/var/lib/app", Mode: "0999"}, true},
+		{"Bad user", StateDir{Path: "/var/lib/app", User: "app;rm"}, true},
+		{"Bad age", StateDir{Path: "/var/lib/app", Age: "ten days"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.dir.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	conf := string(Config("app", []StateDir{
+		{Path: "/var/lib/app", Mode: "0750", User: "app", Group: "app"},
+		{Path: "/var/cache/app", User: "app", Age: "30d"},
+	}))
+	for _, want := range []string{
+		"d /var/lib/app 0750 app app -\n",
+		"d /var/cache/app 0755 app root 30d\n",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("Config() is missing %q:\n%s", want, conf)
+		}
+	}
+	if got := ConfigPath("app"); got != "/usr/lib/tmpfiles.d/app.conf" {
+		t.Errorf("ConfigPath() = %s", got)
+	}
+}
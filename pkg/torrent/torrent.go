@@ -0,0 +1,251 @@
+// Package torrent writes BitTorrent metainfo (.torrent) files and magnet
+// links for single files, so built packages can be shared peer to peer.
+// Every piece of the file is covered by a SHA-1 hash in the metainfo, and
+// the info hash in the magnet link covers those hashes, so a download that
+// completes is the exact file that was built.
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Extension is the file extension of metainfo files
+const Extension = ".torrent"
+
+// MinPieceLength and MaxPieceLength bound the automatically chosen piece
+// length; targetPieces is the number of pieces it aims for
+const (
+	MinPieceLength = 16 << 10
+	MaxPieceLength = 16 << 20
+	targetPieces   = 1500
+)
+
+// Options configures the metainfo of a file
+type Options struct {
+	Trackers    []string  // Announce URLs; the first is the primary tracker
+	WebSeeds    []string  // HTTP URLs serving the file (BEP 19)
+	PieceLength int64     // Bytes per piece, a power of two; 0 chooses one from the file size
+	Comment     string    // Free-form comment
+	CreatedBy   string    // Name of the program writing the metainfo
+	Created     time.Time // Creation date; zero omits it for reproducible output
+	Private     bool      // Restrict peers to the trackers (BEP 27)
+}
+
+// Torrent is the metainfo of a single file
+type Torrent struct {
+	Name        string
+	Length      int64
+	PieceLength int64
+	InfoHash    [sha1.Size]byte
+	Trackers    []string
+	WebSeeds    []string
+	metainfo    []byte // Bencoded metainfo
+}
+
+// PieceLengthFor returns the piece length used for a file of size bytes
+func PieceLengthFor(size int64) int64 {
+	length := int64(MinPieceLength)
+	for length < MaxPieceLength && size/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// Create hashes the file at path and returns its metainfo
+func Create(path string, opts Options) (*Torrent, error) {
+	for _, u := range append(append([]string(nil), opts.Trackers...), opts.WebSeeds...) {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid tracker or web seed URL %q", u)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = PieceLengthFor(info.Size())
+	}
+	if pieceLength < MinPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two of at least %d bytes, not %d", MinPieceLength, pieceLength)
+	}
+	pieces, length, err := hashPieces(f, pieceLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	t := &Torrent{
+		Name:        filepath.Base(path),
+		Length:      length,
+		PieceLength: pieceLength,
+		Trackers:    opts.Trackers,
+		WebSeeds:    opts.WebSeeds,
+	}
+	infoDict := map[string]interface{}{
+		"name":         t.Name,
+		"length":       length,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	if opts.Private {
+		infoDict["private"] = int64(1)
+	}
+	encodedInfo := encode(infoDict)
+	t.InfoHash = sha1.Sum(encodedInfo)
+
+	metainfo := map[string]interface{}{"info": rawValue(encodedInfo)}
+	if len(opts.Trackers) > 0 {
+		metainfo["announce"] = opts.Trackers[0]
+	}
+	if len(opts.Trackers) > 1 {
+		tiers := make([]interface{}, len(opts.Trackers))
+		for i, tracker := range opts.Trackers {
+			tiers[i] = []interface{}{tracker}
+		}
+		metainfo["announce-list"] = tiers
+	}
+	if len(opts.WebSeeds) > 0 {
+		seeds := make([]interface{}, len(opts.WebSeeds))
+		for i, seed := range opts.WebSeeds {
+			seeds[i] = seed
+		}
+		metainfo["url-list"] = seeds
+	}
+	if opts.Comment != "" {
+		metainfo["comment"] = opts.Comment
+	}
+	if opts.CreatedBy != "" {
+		metainfo["created by"] = opts.CreatedBy
+	}
+	if !opts.Created.IsZero() {
+		metainfo["creation date"] = opts.Created.Unix()
+	}
+	t.metainfo = encode(metainfo)
+	return t, nil
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of the pieces of r and
+// its length
+func hashPieces(r io.Reader, pieceLength int64) ([]byte, int64, error) {
+	var pieces []byte
+	var total int64
+	buf := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			pieces = append(pieces, sum[:]...)
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pieces, total, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// Bytes returns the bencoded metainfo
+func (t *Torrent) Bytes() []byte {
+	return t.metainfo
+}
+
+// InfoHashHex returns the info hash as 40 hex digits
+func (t *Torrent) InfoHashHex() string {
+	return hex.EncodeToString(t.InfoHash[:])
+}
+
+// Magnet returns the magnet link of the file, with its name, length,
+// trackers and web seeds
+func (t *Torrent) Magnet() string {
+	params := []string{
+		"xt=urn:btih:" + t.InfoHashHex(),
+		"dn=" + url.QueryEscape(t.Name),
+		"xl=" + strconv.FormatInt(t.Length, 10),
+	}
+	for _, tracker := range t.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, seed := range t.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(seed))
+	}
+	var b bytes.Buffer
+	b.WriteString("magnet:?")
+	for i, param := range params {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(param)
+	}
+	return b.String()
+}
+
+// WriteFile writes the metainfo to path
+func (t *Torrent) WriteFile(path string) error {
+	if err := os.WriteFile(path, t.metainfo, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// rawValue is an already bencoded value
+type rawValue []byte
+
+// encode bencodes strings, byte slices, integers, lists and dictionaries
+// with string keys, which are written in sorted order
+func encode(v interface{}) []byte {
+	var b bytes.Buffer
+	encodeTo(&b, v)
+	return b.Bytes()
+}
+
+func encodeTo(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case rawValue:
+		b.Write(v)
+	case string:
+		fmt.Fprintf(b, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(b, "%d:", len(v))
+		b.Write(v)
+	case int64:
+		fmt.Fprintf(b, "i%de", v)
+	case []interface{}:
+		b.WriteByte('l')
+		for _, item := range v {
+			encodeTo(b, item)
+		}
+		b.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteByte('d')
+		for _, key := range keys {
+			encodeTo(b, key)
+			encodeTo(b, v[key])
+		}
+		b.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("torrent: cannot bencode %T", v))
+	}
+}
@@ -0,0 +1,165 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decode parses one bencoded value and returns it with the remaining input
+func decode(t *testing.T, data []byte) (interface{}, []byte) {
+	switch {
+	case data[0] == 'i':
+		end := bytes.IndexByte(data, 'e')
+		n, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		if err != nil {
+			t.Fatalf("Invalid integer: %v", err)
+		}
+		return n, data[end+1:]
+	case data[0] == 'l':
+		var list []interface{}
+		data = data[1:]
+		for data[0] != 'e' {
+			var item interface{}
+			item, data = decode(t, data)
+			list = append(list, item)
+		}
+		return list, data[1:]
+	case data[0] == 'd':
+		dict := make(map[string]interface{})
+		data = data[1:]
+		for data[0] != 'e' {
+			var key, value interface{}
+			key, data = decode(t, data)
+			value, data = decode(t, data)
+			dict[key.(string)] = value
+		}
+		return dict, data[1:]
+	default:
+		colon := bytes.IndexByte(data, ':')
+		n, err := strconv.Atoi(string(data[:colon]))
+		if err != nil {
+			t.Fatalf("Invalid string length: %v", err)
+		}
+		return string(data[colon+1 : colon+1+n]), data[colon+1+n:]
+	}
+}
+
+func TestEncode(t *testing.T) {
+	got := encode(map[string]interface{}{
+		"b": []interface{}{"spam", int64(-3)},
+		"a": []byte{0, 1},
+	})
+	if want := "d1:a2:\x00\x011:bl4:spami-3eee"; string(got) != want {
+		t.Errorf("encode() = %q, want %q", got, want)
+	}
+}
+
+func TestPieceLengthFor(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{0, MinPieceLength},
+		{10 << 20, MinPieceLength},
+		{100 << 20, 128 << 10},
+		{1 << 40, MaxPieceLength},
+	}
+	for _, tt := range tests {
+		if got := PieceLengthFor(tt.size); got != tt.want {
+			t.Errorf("PieceLengthFor(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrent-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 2500) // Three pieces of 16 KiB, the last one short
+	path := filepath.Join(dir, "demo_1.0_amd64.deb")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	opts := Options{
+		Trackers:  []string{"http://tracker.example.com/announce", "udp://tracker.example.org:6969"},
+		WebSeeds:  []string{"https://example.com/pool/demo_1.0_amd64.deb"},
+		CreatedBy: "pkginstall",
+		Created:   time.Unix(1700000000, 0),
+	}
+	tr, err := Create(path, opts)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	value, rest := decode(t, tr.Bytes())
+	if len(rest) != 0 {
+		t.Fatalf("Trailing metainfo data %q", rest)
+	}
+	metainfo := value.(map[string]interface{})
+	if metainfo["announce"] != opts.Trackers[0] || metainfo["created by"] != "pkginstall" || metainfo["creation date"] != int64(1700000000) {
+		t.Errorf("Unexpected metainfo %v", metainfo)
+	}
+	if tiers := metainfo["announce-list"].([]interface{}); len(tiers) != 2 {
+		t.Errorf("Unexpected announce-list %v", tiers)
+	}
+	if seeds := metainfo["url-list"].([]interface{}); len(seeds) != 1 || seeds[0] != opts.WebSeeds[0] {
+		t.Errorf("Unexpected url-list %v", seeds)
+	}
+
+	info := metainfo["info"].(map[string]interface{})
+	if info["name"] != "demo_1.0_amd64.deb" || info["length"] != int64(len(content)) || info["piece length"] != int64(MinPieceLength) {
+		t.Errorf("Unexpected info %v", info)
+	}
+	pieces := []byte(info["pieces"].(string))
+	if len(pieces) != 3*sha1.Size {
+		t.Fatalf("Got %d bytes of piece hashes, want %d", len(pieces), 3*sha1.Size)
+	}
+	last := sha1.Sum(content[2*MinPieceLength:])
+	if !bytes.Equal(pieces[2*sha1.Size:], last[:]) {
+		t.Errorf("Hash of the last piece does not match")
+	}
+	if tr.InfoHash != sha1.Sum(encode(info)) {
+		t.Errorf("InfoHash does not match the info dictionary")
+	}
+
+	magnet := tr.Magnet()
+	for _, part := range []string{
+		"magnet:?xt=urn:btih:" + tr.InfoHashHex(),
+		"&dn=demo_1.0_amd64.deb",
+		"&xl=40000",
+		"&tr=http%3A%2F%2Ftracker.example.com%2Fannounce",
+		"&ws=https%3A%2F%2Fexample.com%2Fpool%2Fdemo_1.0_amd64.deb",
+	} {
+		if !strings.Contains(magnet, part) {
+			t.Errorf("Magnet() = %q, missing %q", magnet, part)
+		}
+	}
+
+	again, err := Create(path, opts)
+	if err != nil || !bytes.Equal(again.Bytes(), tr.Bytes()) {
+		t.Errorf("Create() is not reproducible: %v", err)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	if _, err := Create("/nonexistent", Options{}); err == nil {
+		t.Errorf("Create() of a missing file should fail")
+	}
+	if _, err := Create(os.Args[0], Options{Trackers: []string{"not a url"}}); err == nil {
+		t.Errorf("Create() with an invalid tracker should fail")
+	}
+	if _, err := Create(os.Args[0], Options{PieceLength: 3 << 14}); err == nil {
+		t.Errorf("Create() with a piece length that is not a power of two should fail")
+	}
+}
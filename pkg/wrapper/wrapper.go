@@ -0,0 +1,105 @@
+// Package wrapper generates shell scripts that set up the environment of a
+// relocated executable, such as LD_LIBRARY_PATH, before running it.
+package wrapper
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validName matches environment variable names
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Wrapper wraps one executable of the payload. The executable is packaged
+// elsewhere and a script setting the environment takes its place, so the
+// install-time symlink to it runs the script. Values are installed paths,
+// after transformation.
+//
+// Example configuration:
+//
+//	wrappers:
+//	  - path: /usr/bin/myapp
+//	    prepend:
+//	      LD_LIBRARY_PATH: [/opt/usr/lib/myapp]
+//	    env:
+//	      MYAPP_CONFIG: /opt/etc/myapp/myapp.conf
+//	    module_env: true
+type Wrapper struct {
+	Path      string              `mapstructure:"path"`       // Executable as packaged before transformation, such as /usr/bin/myapp
+	Env       map[string]string   `mapstructure:"env"`        // Variables set to a value
+	Prepend   map[string][]string `mapstructure:"prepend"`    // Search path variables the directories are prepended to
+	ModuleEnv bool                `mapstructure:"module_env"` // Whether relocated Python, Perl and Ruby module directories are prepended too
+}
+
+// Validate reports relative paths and invalid variable names
+func (w Wrapper) Validate() error {
+	if !path.IsAbs(w.Path) || path.Clean(w.Path) != w.Path {
+		return fmt.Errorf("invalid wrapped executable %q: expected a clean absolute path", w.Path)
+	}
+	for name := range w.Env {
+		if !validName.MatchString(name) {
+			return fmt.Errorf("wrapper for %s: invalid variable name %q", w.Path, name)
+		}
+	}
+	for name, dirs := range w.Prepend {
+		if !validName.MatchString(name) {
+			return fmt.Errorf("wrapper for %s: invalid variable name %q", w.Path, name)
+		}
+		if _, ok := w.Env[name]; ok {
+			return fmt.Errorf("wrapper for %s: %s is both set and prepended to", w.Path, name)
+		}
+		if len(dirs) == 0 {
+			return fmt.Errorf("wrapper for %s: no directories to prepend to %s", w.Path, name)
+		}
+	}
+	return nil
+}
+
+// Script returns the wrapper script running target, the installed path of
+// the wrapped executable. moduleEnv holds search path variables, such as
+// PYTHONPATH, prepended to when ModuleEnv is set.
+func (w Wrapper) Script(target string, moduleEnv map[string]string) []byte {
+	prepend := make(map[string][]string, len(w.Prepend))
+	for name, dirs := range w.Prepend {
+		prepend[name] = append([]string(nil), dirs...)
+	}
+	if w.ModuleEnv {
+		for name, value := range moduleEnv {
+			prepend[name] = append(prepend[name], value)
+		}
+	}
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&script, "# Runs %s from its relocated directory; generated by go-pkginstall\n", w.Path)
+	names := make([]string, 0, len(prepend))
+	for name := range prepend {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Keep the caller's entries, without an empty one that would mean
+		// the current directory
+		fmt.Fprintf(&script, "%s=%s\"${%s:+:$%s}\"\n", name, quote(strings.Join(prepend[name], ":")), name, name)
+		fmt.Fprintf(&script, "export %s\n", name)
+	}
+	names = names[:0]
+	for name := range w.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&script, "%s=%s\n", name, quote(w.Env[name]))
+		fmt.Fprintf(&script, "export %s\n", name)
+	}
+	fmt.Fprintf(&script, "exec %s \"$@\"\n", quote(target))
+	return []byte(script.String())
+}
+
+// quote quotes a value for a POSIX shell
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,74 @@
+package wrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		wrapper Wrapper
+		wantErr bool
+	}{
+		{"Valid", Wrapper{Path: "/usr/bin/app", Env: map[string]string{"APP_HOME": "/opt/usr/share/app"}, Prepend: map[string][]string{"LD_LIBRARY_PATH": {"/opt/usr/lib/app"}}}, false},
+		{"Relative", Wrapper{Path: "usr/bin/app"}, true},
+		{"Invalid name", Wrapper{Path: "/usr/bin/app", Env: map[string]string{"APP-HOME": "x"}}, true},
+		{"Set and prepended", Wrapper{Path: "/usr/bin/app", Env: map[string]string{"PATH": "/bin"}, Prepend: map[string][]string{"PATH": {"/opt/bin"}}}, true},
+		{"Nothing to prepend", Wrapper{Path: "/usr/bin/app", Prepend: map[string][]string{"PATH": nil}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.wrapper.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	env, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("env not available")
+	}
+	dir, err := ioutil.TempDir("", "wrapper-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := Wrapper{
+		Path:      "/usr/bin/app",
+		Env:       map[string]string{"APP_NAME": "it's me"},
+		Prepend:   map[string][]string{"LD_LIBRARY_PATH": {"/opt/usr/lib/app", "/opt/usr/lib"}},
+		ModuleEnv: true,
+	}
+	script := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(script, w.Script(env, map[string]string{"PYTHONPATH": "/opt/usr/lib/python3/dist-packages"}), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	cmd := exec.Command(sh, script)
+	cmd.Env = []string{"LD_LIBRARY_PATH=/custom"}
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Wrapper failed: %v", err)
+	}
+	for _, want := range []string{
+		"LD_LIBRARY_PATH=/opt/usr/lib/app:/opt/usr/lib:/custom\n",
+		"PYTHONPATH=/opt/usr/lib/python3/dist-packages\n",
+		"APP_NAME=it's me\n",
+	} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("Expected %q in the environment:\n%s", want, output)
+		}
+	}
+}